@@ -0,0 +1,221 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Flag is a runsc flag, as written to the flags JSON.
+type Flag struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Default is the default value; string defaults are unquoted, others
+	// are as in the source, e.g. time.Second.
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+
+	// Section is the group of flags the flag is defined in, e.g.
+	// "Debugging flags", if any.
+	Section string `json:"section,omitempty"`
+}
+
+// flagTypes are the types of the flag package's definition functions, by
+// name. The Var forms, e.g. BoolVar, take a pointer first.
+var flagTypes = map[string]string{
+	"Bool":     "bool",
+	"String":   "string",
+	"Int":      "int",
+	"Int64":    "int64",
+	"Uint":     "uint",
+	"Uint64":   "uint64",
+	"Float64":  "float64",
+	"Duration": "duration",
+}
+
+// testOnlyPrefix marks flags that are only for tests, which are not
+// documented.
+const testOnlyPrefix = "TESTONLY"
+
+// ParseFlags returns the flags defined with the flag package, or a flag set,
+// in the given package dirs of the source tree, in the order they are
+// defined. Dirs that don't exist are skipped, since runsc's flags have moved
+// between packages.
+func ParseFlags(src string, dirs []string) ([]Flag, error) {
+	var flags []Flag
+	for _, dir := range dirs {
+		fset, files, err := parseFiles(src, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			flags = append(flags, fileFlags(fset, f)...)
+		}
+	}
+	return flags, nil
+}
+
+// parseFiles parses the non-test Go files in the given dir of the source
+// tree, in name order. A dir that doesn't exist has no files.
+func parseFiles(src, dir string) (*token.FileSet, []*ast.File, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, filepath.Join(src, filepath.FromSlash(dir)), func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if os.IsNotExist(err) {
+		return fset, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []*ast.File
+	for _, p := range pkgs {
+		for _, f := range p.Files {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return fset.File(files[i].Pos()).Name() < fset.File(files[j].Pos()).Name() })
+	return fset, files, nil
+}
+
+// fileFlags returns the flags defined in a file.
+func fileFlags(fset *token.FileSet, f *ast.File) []Flag {
+	var flags []Flag
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if _, ok := sel.X.(*ast.Ident); !ok {
+			return true
+		}
+		args := call.Args
+		var fl Flag
+		switch fn := sel.Sel.Name; {
+		case flagTypes[fn] != "" && len(args) == 3:
+			fl.Type = flagTypes[fn]
+		case strings.HasSuffix(fn, "Var") && flagTypes[strings.TrimSuffix(fn, "Var")] != "" && len(args) == 4:
+			fl.Type = flagTypes[strings.TrimSuffix(fn, "Var")]
+			args = args[1:]
+		case fn == "Var" && len(args) == 3:
+			// Custom flag values have no default in the definition.
+			fl.Type = "value"
+			args = []ast.Expr{args[1], nil, args[2]}
+		default:
+			return true
+		}
+		name, ok := stringValue(args[0])
+		if !ok || name == "" || strings.HasPrefix(name, testOnlyPrefix) {
+			return true
+		}
+		fl.Name = name
+		if args[1] != nil {
+			if s, ok := stringValue(args[1]); ok {
+				fl.Default = s
+			} else {
+				var b bytes.Buffer
+				printer.Fprint(&b, fset, args[1])
+				fl.Default = b.String()
+			}
+		}
+		fl.Usage, _ = stringValue(args[2])
+		fl.Section = flagSection(f, call.Pos())
+		flags = append(flags, fl)
+		return true
+	})
+	return flags
+}
+
+// stringValue returns the value of a string literal, or a concatenation of
+// them.
+func stringValue(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := stringValue(e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := stringValue(e.Y)
+		return x + y, ok
+	case *ast.ParenExpr:
+		return stringValue(e.X)
+	}
+	return "", false
+}
+
+// flagSection returns the section of the flag defined at pos: the last
+// single-line comment mentioning flags before it, such as "// Debugging
+// flags.".
+func flagSection(f *ast.File, pos token.Pos) string {
+	section := ""
+	for _, cg := range f.Comments {
+		if cg.End() >= pos {
+			break
+		}
+		if len(cg.List) != 1 {
+			continue
+		}
+		if text := strings.TrimSpace(cg.Text()); strings.Contains(strings.ToLower(text), "flags") {
+			section = strings.TrimSuffix(text, ".")
+		}
+	}
+	return section
+}
+
+// FlagSection is a group of flags on the flags page.
+type FlagSection struct {
+	Name  string
+	Flags []Flag
+}
+
+// FlagSections groups flags by section, in the order the sections are
+// first defined.
+func FlagSections(flags []Flag) []FlagSection {
+	var sections []FlagSection
+	index := make(map[string]int)
+	for _, fl := range flags {
+		i, ok := index[fl.Section]
+		if !ok {
+			i = len(sections)
+			index[fl.Section] = i
+			sections = append(sections, FlagSection{Name: fl.Section})
+		}
+		sections[i].Flags = append(sections[i].Flags, fl)
+	}
+	return sections
+}
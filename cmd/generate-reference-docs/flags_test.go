@@ -0,0 +1,103 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mainSource = `package main
+
+import (
+	"flag"
+	"time"
+)
+
+var (
+	// Although these flags are not part of the OCI spec, they are used by
+	// Docker, and thus should not be changed.
+	rootDir = flag.String("root", "", "root directory for storage of container state.")
+
+	// Debugging flags.
+	debugLog = flag.String("debug-log", "", "additional location for logs. " +
+		"If it ends with '/', log files are created inside the directory.")
+	panicSignal = flag.Int("panic-signal", -1, "register signal handling that panics | with the signal.")
+
+	// Flags that control sandbox runtime behavior.
+	platformName = flag.String("platform", "ptrace", "specifies which platform to use: ptrace (default), kvm.")
+	testOnly     = flag.Bool("TESTONLY-unsafe-nonroot", false, "TEST ONLY; do not ever use!")
+)
+
+var timeout time.Duration
+
+func init() {
+	flag.DurationVar(&timeout, "watchdog-timeout", 3*time.Minute, "timeout of the watchdog.")
+	flag.Var(&fileAccess, "file-access", "specifies which filesystem to use.")
+}
+`
+
+func TestParseFlags(t *testing.T) {
+	src, err := ioutil.TempDir("", "flags-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "runsc"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "runsc/main.go"), []byte(mainSource), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	flags, err := ParseFlags(src, []string{"runsc", "runsc/config"})
+	if err != nil {
+		t.Fatalf("ParseFlags failed: %v", err)
+	}
+	want := []Flag{
+		{Name: "root", Type: "string", Usage: "root directory for storage of container state."},
+		{Name: "debug-log", Type: "string", Usage: "additional location for logs. If it ends with '/', log files are created inside the directory.", Section: "Debugging flags"},
+		{Name: "panic-signal", Type: "int", Default: "-1", Usage: "register signal handling that panics | with the signal.", Section: "Debugging flags"},
+		{Name: "platform", Type: "string", Default: "ptrace", Usage: "specifies which platform to use: ptrace (default), kvm.", Section: "Flags that control sandbox runtime behavior"},
+		{Name: "watchdog-timeout", Type: "duration", Default: "3 * time.Minute", Usage: "timeout of the watchdog.", Section: "Flags that control sandbox runtime behavior"},
+		{Name: "file-access", Type: "value", Usage: "specifies which filesystem to use.", Section: "Flags that control sandbox runtime behavior"},
+	}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("ParseFlags = %+v, want %+v", flags, want)
+	}
+
+	sections := FlagSections(flags)
+	if len(sections) != 3 || sections[0].Name != "" || len(sections[2].Flags) != 3 {
+		t.Errorf("FlagSections = %+v, want 3 sections", sections)
+	}
+	var b bytes.Buffer
+	if err := flagsTemplate.Execute(&b, sections); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	for _, want := range []string{
+		"## Debugging flags\n\n| Flag |",
+		"| <a class=\"doc-table-anchor\" id=\"panic-signal\"></a>`--panic-signal` | int | `-1` | register signal handling that panics \\| with the signal. |\n",
+		"`--root` | string |  | root directory",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("page doesn't contain %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// generate-reference-docs generates the reference documentation pages of
+// gVisor's Go packages from a gVisor source tree.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`+++
+title = "Reference"
+description = "Reference Documentation of gVisor's Go packages"
+weight = 30
++++
+
+This is the reference documentation of key gVisor Go packages, generated from
+the gVisor source when the site is built.
+{{if .Flags}}
+All of the flags of runsc are listed in the [runsc flags reference](./runsc-flags/).
+{{end}}{{if .OCI}}
+The features of the OCI runtime spec that runsc supports are listed in the
+[OCI runtime spec compliance matrix](./oci-compliance/).
+{{end}}
+| Package | Synopsis |
+| ---     | ---      |
+{{range .Packages}}| [{{.Path}}](./{{.Slug}}/) | {{.Synopsis}} |
+{{end}}`))
+
+// tableCell escapes text for a cell of a Markdown table.
+func tableCell(s string) string {
+	return strings.Replace(strings.Join(strings.Fields(s), " "), "|", "\\|", -1)
+}
+
+var flagsTemplate = template.Must(template.New("flags").Funcs(template.FuncMap{
+	"cell": tableCell,
+}).Parse(`+++
+title = "runsc flags"
+description = "Reference of the flags of runsc"
+weight = 5
++++
+
+These are the flags of runsc, generated from its source when the site is
+built. Flags are given before the command, e.g.
+` + "`runsc --platform=kvm run`" + `, and are usually set in the runtime
+options of Docker or containerd.
+{{range .}}
+{{if .Name}}## {{.Name}}
+
+{{end}}| Flag | Type | Default | Description |
+| ---  | ---  | ---     | ---         |
+{{range .Flags}}| <a class="doc-table-anchor" id="{{.Name}}"></a>` + "`--{{.Name}}`" + ` | {{.Type}} | {{if .Default}}` + "`{{cell .Default}}`" + `{{end}} | {{cell .Usage}} |
+{{end}}{{end}}`))
+
+var ociTemplate = template.Must(template.New("oci").Funcs(template.FuncMap{
+	"cell": tableCell,
+	"status": func(s string) string {
+		switch s {
+		case OCISupported:
+			return "Supported"
+		case OCIPartial:
+			return "Partial"
+		case OCIIgnored:
+			return "Ignored"
+		case OCIUnsupported:
+			return "Unsupported"
+		}
+		return "Not documented"
+	},
+}).Parse(`+++
+title = "OCI runtime spec compliance"
+description = "Support of the features of the OCI runtime spec in runsc"
+weight = 6
++++
+
+This is the support of runsc for the features of the
+[OCI runtime spec](https://github.com/opencontainers/runtime-spec), generated
+from its source when the site is built.{{with .SpecVersion}} runsc is built
+with version ` + "`{{.}}`" + ` of the spec.{{end}}
+
+Ignored features are accepted in config.json but have no effect. Features
+that are not documented have no annotation in the source yet.
+{{range .OCISections}}
+## {{.Name}}
+
+| Feature | Support | Notes |
+| ---     | ---     | ---   |
+{{range .Features}}| <a class="doc-table-anchor" id="{{.Feature}}"></a>` + "`{{.Feature}}`" + ` | {{status .Status}} | {{cell .Note}}{{if .Source}} [Source]({{.Source}}){{end}} |
+{{end}}{{end}}`))
+
+var packageTemplate = template.Must(template.New("package").Parse(`{{define "decl"}}{{if .Heading}}### {{.Heading}}
+
+{{end}}` + "```go\n{{.Code}}\n```" + `
+{{if .Source}}
+<a href="{{.Source}}">Source</a>
+{{end}}{{if .Doc}}
+<div class="godoc">
+{{.Doc}}</div>
+{{end}}
+{{end}}+++
+title = "{{.Path}}"
+description = "Reference Documentation of {{.ImportPath}}"
+weight = {{.Weight}}
++++
+
+` + "`import \"{{.ImportPath}}\"`" + `
+{{if .Doc}}
+<div class="godoc">
+{{.Doc}}</div>
+{{end}}{{with .Consts}}
+## Constants
+
+{{range .}}{{template "decl" .}}{{end}}{{end}}{{with .Vars}}
+## Variables
+
+{{range .}}{{template "decl" .}}{{end}}{{end}}{{with .Funcs}}
+## Functions
+
+{{range .}}{{template "decl" .}}{{end}}{{end}}{{with .Types}}
+## Types
+{{range .}}
+{{template "decl" .Decl}}{{range .Consts}}{{template "decl" .}}{{end}}{{range .Vars}}{{template "decl" .}}{{end}}{{range .Funcs}}{{template "decl" .}}{{end}}{{range .Methods}}{{template "decl" .}}{{end}}{{end}}{{end}}`))
+
+// Fatalf writes a message to stderr and exits with error code 1
+func Fatalf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, a...)
+	os.Exit(1)
+}
+
+// splitDirs splits a comma-separated list of package dirs.
+func splitDirs(s string) []string {
+	var dirs []string
+	for _, d := range strings.Split(s, ",") {
+		if d = strings.Trim(strings.TrimSpace(d), "/"); d != "" {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+// writePage executes the template into the given file.
+func writePage(file string, t *template.Template, data interface{}) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := t.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func main() {
+	srcFlag := flag.String("src", "", "gVisor source tree to read the packages from.")
+	outputDir := flag.String("out", ".", "Directory to output files; existing files are removed.")
+	packagesFlag := flag.String("packages", "runsc/boot,pkg/sentry/control", "Comma-separated packages to document, relative to the module root of -src.")
+	sourceURLFlag := flag.String("source-url", "https://github.com/google/gvisor/blob/master", "URL of the source tree that declarations link to; links are omitted if empty.")
+	flagDirsFlag := flag.String("runsc-flag-dirs", "runsc,runsc/config", "Comma-separated packages defining the flags of runsc, relative to the module root of -src; missing packages are skipped.")
+	jsonFlag := flag.String("flags-json", "", "File to also write the runsc flags to as JSON.")
+	ociDirsFlag := flag.String("oci-dirs", "runsc", "Comma-separated packages annotated with runsc's support of the OCI runtime spec, relative to the module root of -src; subpackages are not included.")
+	ociJSONFlag := flag.String("oci-json", "", "File to also write the OCI runtime spec compliance matrix to as JSON.")
+
+	flag.Parse()
+
+	if *srcFlag == "" {
+		Fatalf("-src is required\n")
+	}
+	modulePath, err := ModulePath(*srcFlag)
+	if err != nil {
+		Fatalf("Error reading module path: %v\n", err)
+	}
+
+	var pkgs []*Package
+	for _, p := range strings.Split(*packagesFlag, ",") {
+		if p = strings.Trim(strings.TrimSpace(p), "/"); p == "" {
+			continue
+		}
+		pkg, err := ParsePackage(*srcFlag, modulePath, p, *sourceURLFlag)
+		if err != nil {
+			Fatalf("Error parsing package %q: %v\n", p, err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	flags, err := ParseFlags(*srcFlag, splitDirs(*flagDirsFlag))
+	if err != nil {
+		Fatalf("Error parsing runsc flags: %v\n", err)
+	}
+	if *jsonFlag != "" {
+		b, err := json.MarshalIndent(flags, "", "  ")
+		if err != nil {
+			Fatalf("Error encoding json: %v\n", err)
+		}
+		if err := ioutil.WriteFile(*jsonFlag, b, 0644); err != nil {
+			Fatalf("Error writing file %q: %v\n", *jsonFlag, err)
+		}
+	}
+
+	oci, err := ParseOCICompliance(*srcFlag, splitDirs(*ociDirsFlag), *sourceURLFlag)
+	if err != nil {
+		Fatalf("Error parsing OCI compliance: %v\n", err)
+	}
+	if *ociJSONFlag != "" {
+		b, err := json.MarshalIndent(oci, "", "  ")
+		if err != nil {
+			Fatalf("Error encoding json: %v\n", err)
+		}
+		if err := ioutil.WriteFile(*ociJSONFlag, b, 0644); err != nil {
+			Fatalf("Error writing file %q: %v\n", *ociJSONFlag, err)
+		}
+	}
+
+	// Pages of packages that are no longer documented must not be left
+	// behind.
+	if err := os.RemoveAll(*outputDir); err != nil {
+		Fatalf("Error removing %q: %v\n", *outputDir, err)
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		Fatalf("Error creating directory %q: %v\n", *outputDir, err)
+	}
+	index := struct {
+		Packages []*Package
+		Flags    bool
+		OCI      bool
+	}{pkgs, len(flags) > 0, true}
+	if err := writePage(filepath.Join(*outputDir, "_index.md"), indexTemplate, index); err != nil {
+		Fatalf("Error writing index: %v\n", err)
+	}
+	if len(flags) > 0 {
+		outFile := filepath.Join(*outputDir, "runsc-flags.md")
+		if err := writePage(outFile, flagsTemplate, FlagSections(flags)); err != nil {
+			Fatalf("Error writing file %q: %v\n", outFile, err)
+		}
+	}
+	outFile := filepath.Join(*outputDir, "oci-compliance.md")
+	if err := writePage(outFile, ociTemplate, oci); err != nil {
+		Fatalf("Error writing file %q: %v\n", outFile, err)
+	}
+	for i, pkg := range pkgs {
+		data := struct {
+			*Package
+			Weight int
+		}{pkg, (i + 1) * 10}
+		outFile := filepath.Join(*outputDir, pkg.Slug()+".md")
+		if err := writePage(outFile, packageTemplate, data); err != nil {
+			Fatalf("Error writing file %q: %v\n", outFile, err)
+		}
+	}
+}
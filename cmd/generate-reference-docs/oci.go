@@ -0,0 +1,216 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// OCI support statuses. Features that aren't annotated are unknown.
+const (
+	OCISupported   = "supported"
+	OCIPartial     = "partial"
+	OCIIgnored     = "ignored"
+	OCIUnsupported = "unsupported"
+	OCIUnknown     = "unknown"
+)
+
+// OCIFeature is a feature of the OCI runtime spec and runsc's support of it.
+type OCIFeature struct {
+	// Feature is the path of the feature in config.json, e.g. linux.seccomp.
+	Feature string `json:"feature"`
+	Section string `json:"section"`
+	Status  string `json:"status"`
+	Note    string `json:"note,omitempty"`
+
+	// Source is the URL of the annotation the status is taken from.
+	Source string `json:"source,omitempty"`
+}
+
+// OCICompliance is the OCI runtime spec compliance matrix, as written to the
+// compliance JSON.
+type OCICompliance struct {
+	// SpecVersion is the version of the runtime spec runsc is built with.
+	SpecVersion string       `json:"spec_version,omitempty"`
+	Features    []OCIFeature `json:"features"`
+}
+
+// ociFeatures are the features of the runtime spec that are always listed,
+// so that the matrix shows features without annotations too.
+var ociFeatures = []string{
+	"root.path",
+	"root.readonly",
+	"mounts",
+	"process.terminal",
+	"process.consoleSize",
+	"process.user",
+	"process.args",
+	"process.env",
+	"process.cwd",
+	"process.capabilities",
+	"process.rlimits",
+	"process.noNewPrivileges",
+	"process.apparmorProfile",
+	"process.oomScoreAdj",
+	"process.selinuxLabel",
+	"hostname",
+	"hooks.prestart",
+	"hooks.poststart",
+	"hooks.poststop",
+	"annotations",
+	"linux.namespaces",
+	"linux.uidMappings",
+	"linux.gidMappings",
+	"linux.devices",
+	"linux.cgroupsPath",
+	"linux.resources.memory",
+	"linux.resources.cpu",
+	"linux.resources.pids",
+	"linux.resources.blockIO",
+	"linux.resources.hugepageLimits",
+	"linux.resources.network",
+	"linux.resources.devices",
+	"linux.sysctl",
+	"linux.seccomp",
+	"linux.rootfsPropagation",
+	"linux.maskedPaths",
+	"linux.readonlyPaths",
+	"linux.mountLabel",
+	"linux.intelRdt",
+}
+
+// ociAnnotation matches an annotation of runsc's support of a feature in a
+// comment line, e.g.
+//
+//	+oci:partial linux.resources.cpu Only shares and quota are enforced.
+var ociAnnotation = regexp.MustCompile(`^\+oci:(\w+)\s+(\S+)\s*(.*)$`)
+
+// ociSection returns the section of the matrix a feature is listed in.
+func ociSection(feature string) string {
+	switch strings.SplitN(feature, ".", 2)[0] {
+	case "process":
+		return "Process"
+	case "hooks":
+		return "Hooks"
+	case "linux":
+		return "Linux"
+	}
+	return "Container"
+}
+
+// ParseOCICompliance returns the compliance matrix from the annotations in
+// the comments of the given package dirs of the source tree, whose
+// annotations are linked to under sourceURL. Dirs that don't exist are
+// skipped.
+func ParseOCICompliance(src string, dirs []string, sourceURL string) (*OCICompliance, error) {
+	c := &OCICompliance{SpecVersion: runtimeSpecVersion(src)}
+	index := make(map[string]int)
+	for i, f := range ociFeatures {
+		c.Features = append(c.Features, OCIFeature{Feature: f, Section: ociSection(f), Status: OCIUnknown})
+		index[f] = i
+	}
+	for _, dir := range dirs {
+		fset, files, err := parseFiles(src, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			for _, cg := range f.Comments {
+				for _, cm := range cg.List {
+					text := strings.TrimSpace(strings.TrimPrefix(cm.Text, "//"))
+					m := ociAnnotation.FindStringSubmatch(text)
+					if m == nil {
+						continue
+					}
+					status, feature, note := m[1], m[2], strings.TrimSpace(m[3])
+					pos := fset.Position(cm.Pos())
+					switch status {
+					case OCISupported, OCIPartial, OCIIgnored, OCIUnsupported:
+					default:
+						return nil, fmt.Errorf("%s: invalid status %q of %s", pos, status, feature)
+					}
+					i, ok := index[feature]
+					if !ok {
+						i = len(c.Features)
+						index[feature] = i
+						c.Features = append(c.Features, OCIFeature{Feature: feature, Section: ociSection(feature), Status: OCIUnknown})
+					}
+					of := &c.Features[i]
+					if of.Status != OCIUnknown && of.Status != status {
+						return nil, fmt.Errorf("%s: %s is annotated as both %s and %s", pos, feature, of.Status, status)
+					}
+					if of.Status == OCIUnknown {
+						of.Status = status
+						if rel, err := filepath.Rel(src, pos.Filename); err == nil && sourceURL != "" {
+							of.Source = fmt.Sprintf("%s/%s#L%d", strings.TrimSuffix(sourceURL, "/"), filepath.ToSlash(rel), pos.Line)
+						}
+					}
+					if note != "" {
+						of.Note = strings.TrimSpace(of.Note + " " + note)
+					}
+				}
+			}
+		}
+	}
+	// Features that are only annotated follow the known ones.
+	extra := c.Features[len(ociFeatures):]
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Feature < extra[j].Feature })
+	return c, nil
+}
+
+// runtimeSpecVersion returns the version of the runtime spec required by the
+// go.mod of the source tree, if any.
+func runtimeSpecVersion(src string) string {
+	b, err := ioutil.ReadFile(filepath.Join(src, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "github.com/opencontainers/runtime-spec" {
+			return f[1]
+		} else if len(f) >= 3 && f[0] == "require" && f[1] == "github.com/opencontainers/runtime-spec" {
+			return f[2]
+		}
+	}
+	return ""
+}
+
+// OCISection is a group of features on the compliance page.
+type OCISection struct {
+	Name     string
+	Features []OCIFeature
+}
+
+// OCISections groups the features of the matrix by section, in the order of
+// their first features.
+func (c *OCICompliance) OCISections() []OCISection {
+	var sections []OCISection
+	index := make(map[string]int)
+	for _, f := range c.Features {
+		i, ok := index[f.Section]
+		if !ok {
+			i = len(sections)
+			index[f.Section] = i
+			sections = append(sections, OCISection{Name: f.Section})
+		}
+		sections[i].Features = append(sections[i].Features, f)
+	}
+	return sections
+}
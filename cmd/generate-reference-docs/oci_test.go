@@ -0,0 +1,127 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const ociSource = `package container
+
+// +oci:supported process.args
+// +oci:partial linux.resources.cpu Only shares and quota are enforced.
+func apply() {}
+
+// +oci:ignored linux.intelRdt
+// +oci:partial linux.resources.cpu CPU sets are | ignored.
+// +oci:unsupported windows.layerFolders
+var x int
+`
+
+func writeOCISource(t *testing.T, src string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(src, "runsc"), 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "go.mod"), []byte("module gvisor.dev/gvisor\n\nrequire github.com/opencontainers/runtime-spec v1.0.1\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "runsc/container.go"), []byte(ociSource), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestParseOCICompliance(t *testing.T) {
+	src, err := ioutil.TempDir("", "oci-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(src)
+	writeOCISource(t, src)
+
+	c, err := ParseOCICompliance(src, []string{"runsc", "runsc/container"}, "https://example.com/src/")
+	if err != nil {
+		t.Fatalf("ParseOCICompliance failed: %v", err)
+	}
+	if c.SpecVersion != "v1.0.1" {
+		t.Errorf("SpecVersion = %q, want v1.0.1", c.SpecVersion)
+	}
+	if len(c.Features) != len(ociFeatures)+1 {
+		t.Fatalf("got %d features, want %d", len(c.Features), len(ociFeatures)+1)
+	}
+	byName := make(map[string]OCIFeature)
+	for _, f := range c.Features {
+		byName[f.Feature] = f
+	}
+	for _, tc := range []struct {
+		feature string
+		want    OCIFeature
+	}{
+		{"process.args", OCIFeature{Feature: "process.args", Section: "Process", Status: OCISupported, Source: "https://example.com/src/runsc/container.go#L3"}},
+		{"linux.resources.cpu", OCIFeature{Feature: "linux.resources.cpu", Section: "Linux", Status: OCIPartial, Note: "Only shares and quota are enforced. CPU sets are | ignored.", Source: "https://example.com/src/runsc/container.go#L4"}},
+		{"linux.intelRdt", OCIFeature{Feature: "linux.intelRdt", Section: "Linux", Status: OCIIgnored, Source: "https://example.com/src/runsc/container.go#L7"}},
+		{"hostname", OCIFeature{Feature: "hostname", Section: "Container", Status: OCIUnknown}},
+		{"windows.layerFolders", OCIFeature{Feature: "windows.layerFolders", Section: "Container", Status: OCIUnsupported, Source: "https://example.com/src/runsc/container.go#L9"}},
+	} {
+		if got := byName[tc.feature]; got != tc.want {
+			t.Errorf("feature %s = %+v, want %+v", tc.feature, got, tc.want)
+		}
+	}
+	if last := c.Features[len(c.Features)-1].Feature; last != "windows.layerFolders" {
+		t.Errorf("last feature = %q, want windows.layerFolders", last)
+	}
+
+	var b bytes.Buffer
+	if err := ociTemplate.Execute(&b, c); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	for _, want := range []string{
+		"version `v1.0.1` of the spec",
+		"## Process\n\n| Feature |",
+		"`linux.resources.cpu` | Partial | Only shares and quota are enforced. CPU sets are \\| ignored. [Source](https://example.com/src/runsc/container.go#L4) |\n",
+		"`hostname` | Not documented |  |\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("page doesn't contain %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestParseOCIComplianceErrors(t *testing.T) {
+	for _, source := range []string{
+		"package runsc\n\n// +oci:maybe process.args\n",
+		"package runsc\n\n// +oci:supported process.args\n// +oci:ignored process.args\n",
+	} {
+		src, err := ioutil.TempDir("", "oci-test")
+		if err != nil {
+			t.Fatalf("TempDir failed: %v", err)
+		}
+		defer os.RemoveAll(src)
+		if err := os.MkdirAll(filepath.Join(src, "runsc"), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(src, "runsc/runsc.go"), []byte(source), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := ParseOCICompliance(src, []string{"runsc"}, ""); err == nil {
+			t.Errorf("ParseOCICompliance of %q succeeded, want error", source)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Decl is a documented declaration.
+type Decl struct {
+	// Heading is the title of the declaration, e.g. "func (Config) ToFlags".
+	Heading string
+	Code    string
+
+	// Doc is the doc comment, as HTML.
+	Doc string
+
+	// Source is the URL of the declaration in the source tree.
+	Source string
+}
+
+// Type is a documented type, with its associated declarations.
+type Type struct {
+	Decl
+	Consts  []Decl
+	Vars    []Decl
+	Funcs   []Decl
+	Methods []Decl
+}
+
+// Package is the documentation of a package.
+type Package struct {
+	// Path is the path of the package in the module, e.g. runsc/boot.
+	Path       string
+	ImportPath string
+	Name       string
+	Synopsis   string
+
+	// Doc is the package comment, as HTML.
+	Doc string
+
+	Consts []Decl
+	Vars   []Decl
+	Funcs  []Decl
+	Types  []Type
+}
+
+// Slug returns the name of the package's page.
+func (p *Package) Slug() string {
+	return strings.Replace(p.Path, "/", "-", -1)
+}
+
+// ModulePath returns the module path in the go.mod of the given source tree.
+func ModulePath(src string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(src, "go.mod"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "module" {
+			return strings.Trim(f[1], `"`), nil
+		}
+	}
+	return "", fmt.Errorf("no module path in %s/go.mod", src)
+}
+
+// generator renders the declarations of a parsed package.
+type generator struct {
+	fset      *token.FileSet
+	src       string
+	sourceURL string
+
+	// comments are all of the package's comments, in order, so that the
+	// comments within declarations are printed with them.
+	comments []*ast.CommentGroup
+}
+
+// ParsePackage parses the documentation of the package at the given path in
+// the source tree, whose declarations are linked to under sourceURL.
+func ParsePackage(src, modulePath, pkgPath, sourceURL string) (*Package, error) {
+	fset := token.NewFileSet()
+	dir := filepath.Join(src, filepath.FromSlash(pkgPath))
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	var astPkg *ast.Package
+	for name, p := range pkgs {
+		if len(pkgs) == 1 || name == path.Base(pkgPath) {
+			astPkg = p
+		}
+	}
+	if astPkg == nil {
+		return nil, fmt.Errorf("no package in %s", dir)
+	}
+
+	g := &generator{fset: fset, src: src, sourceURL: strings.TrimSuffix(sourceURL, "/")}
+	for _, f := range astPkg.Files {
+		g.comments = append(g.comments, f.Comments...)
+	}
+	sort.Slice(g.comments, func(i, j int) bool { return g.comments[i].Pos() < g.comments[j].Pos() })
+
+	importPath := path.Join(modulePath, pkgPath)
+	d := doc.New(astPkg, importPath, 0)
+	p := &Package{
+		Path:       pkgPath,
+		ImportPath: importPath,
+		Name:       d.Name,
+		Synopsis:   doc.Synopsis(d.Doc),
+		Doc:        g.html(d.Doc),
+		Consts:     g.values(d.Consts),
+		Vars:       g.values(d.Vars),
+		Funcs:      g.funcs(d.Funcs),
+	}
+	for _, t := range d.Types {
+		p.Types = append(p.Types, Type{
+			Decl:    g.decl("type "+t.Name, t.Decl, t.Doc),
+			Consts:  g.values(t.Consts),
+			Vars:    g.values(t.Vars),
+			Funcs:   g.funcs(t.Funcs),
+			Methods: g.funcs(t.Methods),
+		})
+	}
+	return p, nil
+}
+
+// decl renders a declaration.
+func (g *generator) decl(heading string, node ast.Node, text string) Decl {
+	var code bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	cfg.Fprint(&code, g.fset, &printer.CommentedNode{Node: node, Comments: g.comments})
+	d := Decl{Heading: heading, Code: code.String(), Doc: g.html(text)}
+	pos := g.fset.Position(node.Pos())
+	if rel, err := filepath.Rel(g.src, pos.Filename); err == nil && g.sourceURL != "" {
+		d.Source = fmt.Sprintf("%s/%s#L%d", g.sourceURL, filepath.ToSlash(rel), pos.Line)
+	}
+	return d
+}
+
+// values renders grouped constants or variables, which have no heading.
+func (g *generator) values(vs []*doc.Value) []Decl {
+	var decls []Decl
+	for _, v := range vs {
+		decls = append(decls, g.decl("", v.Decl, v.Doc))
+	}
+	return decls
+}
+
+// funcs renders functions or methods.
+func (g *generator) funcs(fs []*doc.Func) []Decl {
+	var decls []Decl
+	for _, f := range fs {
+		heading := "func " + f.Name
+		if f.Recv != "" {
+			heading = "func (" + strings.TrimPrefix(f.Recv, "*") + ") " + f.Name
+		}
+		decls = append(decls, g.decl(heading, f.Decl, f.Doc))
+	}
+	return decls
+}
+
+// html renders a doc comment as HTML.
+func (g *generator) html(text string) string {
+	var b bytes.Buffer
+	doc.ToHTML(&b, text, nil)
+	return b.String()
+}
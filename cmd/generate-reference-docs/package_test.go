@@ -0,0 +1,122 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const configSource = `// Package boot loads sandboxes.
+//
+// It is used by runsc.
+package boot
+
+// DefaultRoot is the default state dir.
+const DefaultRoot = "/var/run/runsc"
+
+// Config holds the configuration of runsc.
+type Config struct {
+	// Debug enables debug logging.
+	Debug bool
+
+	internal int
+}
+
+// NewConfig returns the default configuration.
+func NewConfig() *Config {
+	return &Config{}
+}
+
+// ToFlags returns the configuration as flags.
+func (c *Config) ToFlags() []string {
+	return nil
+}
+
+func unexported() {}
+`
+
+func TestParsePackage(t *testing.T) {
+	src, err := ioutil.TempDir("", "reference-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(src)
+	for name, content := range map[string]string{
+		"go.mod":                    "module gvisor.dev/gvisor\n\ngo 1.13\n",
+		"runsc/boot/config.go":      configSource,
+		"runsc/boot/config_test.go": "package boot_test\n",
+	} {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	modulePath, err := ModulePath(src)
+	if err != nil || modulePath != "gvisor.dev/gvisor" {
+		t.Fatalf("ModulePath = %q, %v; want gvisor.dev/gvisor", modulePath, err)
+	}
+	pkg, err := ParsePackage(src, modulePath, "runsc/boot", "https://github.com/google/gvisor/blob/master")
+	if err != nil {
+		t.Fatalf("ParsePackage failed: %v", err)
+	}
+	if pkg.ImportPath != "gvisor.dev/gvisor/runsc/boot" || pkg.Synopsis != "Package boot loads sandboxes." || pkg.Slug() != "runsc-boot" {
+		t.Errorf("package = %q, %q, %q", pkg.ImportPath, pkg.Synopsis, pkg.Slug())
+	}
+	if len(pkg.Consts) != 1 || len(pkg.Funcs) != 0 || len(pkg.Types) != 1 {
+		t.Fatalf("package has %d consts, %d funcs and %d types, want 1, 0 and 1", len(pkg.Consts), len(pkg.Funcs), len(pkg.Types))
+	}
+	config := pkg.Types[0]
+	if config.Heading != "type Config" || !strings.Contains(config.Code, "// Debug enables debug logging.\n\tDebug bool") || strings.Contains(config.Code, "internal") {
+		t.Errorf("Config = %+v", config.Decl)
+	}
+	if want := "https://github.com/google/gvisor/blob/master/runsc/boot/config.go#L10"; config.Source != want {
+		t.Errorf("Config source = %q, want %q", config.Source, want)
+	}
+	if len(config.Funcs) != 1 || config.Funcs[0].Code != "func NewConfig() *Config" {
+		t.Errorf("Config funcs = %+v, want NewConfig", config.Funcs)
+	}
+	if len(config.Methods) != 1 || config.Methods[0].Heading != "func (Config) ToFlags" {
+		t.Errorf("Config methods = %+v, want ToFlags", config.Methods)
+	}
+
+	var b bytes.Buffer
+	if err := packageTemplate.Execute(&b, struct {
+		*Package
+		Weight int
+	}{pkg, 10}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	for _, want := range []string{
+		"+++\ntitle = \"runsc/boot\"\n",
+		"`import \"gvisor.dev/gvisor/runsc/boot\"`",
+		"## Constants\n\n```go\nconst DefaultRoot = \"/var/run/runsc\"\n```\n",
+		"### func (Config) ToFlags\n\n```go\nfunc (c *Config) ToFlags() []string\n```\n",
+		"<div class=\"godoc\">\n<p>",
+		"It is used by runsc.",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("page doesn't contain %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
@@ -88,26 +89,46 @@ func Fatalf(format string, a ...interface{}) {
 
 func main() {
 	inputFlag := flag.String("in", "-", "File to input ('-' for stdin)")
+	srcFlag := flag.String("src", "", "gVisor source tree to read the syscall tables from, instead of reading the output of 'runsc help syscalls -format json' from -in.")
 	outputDir := flag.String("out", ".", "Directory to output files.")
+	jsonFlag := flag.String("json", "", "File to also write the compatibility information to as JSON.")
 
 	flag.Parse()
 
-	var input io.Reader
-	if *inputFlag == "-" {
-		input = os.Stdin
-	} else {
-		i, err := os.Open(*inputFlag)
+	var info CompatibilityInfo
+	if *srcFlag != "" {
+		var err error
+		info, err = ParseSource(*srcFlag)
 		if err != nil {
-			Fatalf("Error opening %q: %v", *inputFlag, err)
+			Fatalf("Error parsing syscall tables: %v", err)
+		}
+	} else {
+		var input io.Reader
+		if *inputFlag == "-" {
+			input = os.Stdin
+		} else {
+			i, err := os.Open(*inputFlag)
+			if err != nil {
+				Fatalf("Error opening %q: %v", *inputFlag, err)
+			}
+			input = i
+		}
+		input = bufio.NewReader(input)
+
+		d := json.NewDecoder(input)
+		if err := d.Decode(&info); err != nil {
+			Fatalf("Error reading json: %v", err)
 		}
-		input = i
 	}
-	input = bufio.NewReader(input)
 
-	var info CompatibilityInfo
-	d := json.NewDecoder(input)
-	if err := d.Decode(&info); err != nil {
-		Fatalf("Error reading json: %v")
+	if *jsonFlag != "" {
+		b, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			Fatalf("Error encoding json: %v", err)
+		}
+		if err := ioutil.WriteFile(*jsonFlag, b, 0644); err != nil {
+			Fatalf("Error writing file %q: %v", *jsonFlag, err)
+		}
 	}
 
 	weight := 0
@@ -0,0 +1,266 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// syscallTableDir is the package in the gVisor source tree that defines the
+// Linux syscall tables.
+const syscallTableDir = "pkg/sentry/syscalls/linux"
+
+// supportLevels maps the syscalls package helpers used in syscall tables to
+// the support level they declare, as printed by runsc help syscalls.
+var supportLevels = map[string]string{
+	"Supported":          "Full Support",
+	"PartiallySupported": "Partial Support",
+	"Error":              "Unimplemented",
+	"ErrorWithEvent":     "Unimplemented",
+	"CapError":           "Unimplemented",
+}
+
+// ParseSource reads the syscall tables from the gVisor source tree at root,
+// producing the same information as runsc help syscalls without having to
+// build runsc. Notes are formatted as the syscalls package helpers format
+// them.
+func ParseSource(root string) (CompatibilityInfo, error) {
+	dir := filepath.Join(root, syscallTableDir)
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	info := make(CompatibilityInfo)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			var perr error
+			ast.Inspect(f, func(n ast.Node) bool {
+				lit, ok := n.(*ast.CompositeLit)
+				if !ok || perr != nil || !isSelector(lit.Type, "kernel", "SyscallTable") {
+					return perr == nil
+				}
+				osName, archName, ai, err := parseTable(fset, lit)
+				if err != nil {
+					perr = err
+					return false
+				}
+				if info[osName] == nil {
+					info[osName] = make(map[string]ArchInfo)
+				}
+				info[osName][archName] = ai
+				return false
+			})
+			if perr != nil {
+				return nil, perr
+			}
+		}
+	}
+	if len(info) == 0 {
+		return nil, fmt.Errorf("no syscall tables found in %s", dir)
+	}
+	return info, nil
+}
+
+// isSelector returns true if e is the selector expression x.sel.
+func isSelector(e ast.Expr, x, sel string) bool {
+	s, ok := e.(*ast.SelectorExpr)
+	if !ok || s.Sel.Name != sel {
+		return false
+	}
+	id, ok := s.X.(*ast.Ident)
+	return ok && id.Name == x
+}
+
+// parseTable parses a kernel.SyscallTable literal.
+func parseTable(fset *token.FileSet, lit *ast.CompositeLit) (string, string, ArchInfo, error) {
+	var osName, archName string
+	ai := ArchInfo{Syscalls: make(map[uintptr]SyscallDoc)}
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		switch key.Name {
+		case "OS":
+			if s, ok := kv.Value.(*ast.SelectorExpr); ok {
+				osName = strings.ToLower(s.Sel.Name)
+			}
+		case "Arch":
+			if s, ok := kv.Value.(*ast.SelectorExpr); ok {
+				archName = strings.ToLower(s.Sel.Name)
+			}
+		case "Table":
+			table, ok := kv.Value.(*ast.CompositeLit)
+			if !ok {
+				return "", "", ai, fmt.Errorf("%s: Table is not a literal", fset.Position(kv.Pos()))
+			}
+			for _, e := range table.Elts {
+				num, doc, err := parseEntry(e)
+				if err != nil {
+					return "", "", ai, fmt.Errorf("%s: %v", fset.Position(e.Pos()), err)
+				}
+				ai.Syscalls[num] = doc
+			}
+		}
+	}
+	if osName == "" || archName == "" {
+		return "", "", ai, fmt.Errorf("%s: syscall table without OS or Arch", fset.Position(lit.Pos()))
+	}
+	return osName, archName, ai, nil
+}
+
+// parseEntry parses a table entry such as:
+//
+//	38: syscalls.PartiallySupported("setitimer", Setitimer, "Note.", []string{"gvisor.dev/issue/1"}),
+func parseEntry(e ast.Expr) (uintptr, SyscallDoc, error) {
+	var doc SyscallDoc
+	kv, ok := e.(*ast.KeyValueExpr)
+	if !ok {
+		return 0, doc, fmt.Errorf("unkeyed table entry")
+	}
+	numLit, ok := kv.Key.(*ast.BasicLit)
+	if !ok || numLit.Kind != token.INT {
+		return 0, doc, fmt.Errorf("table key is not a number")
+	}
+	num, err := strconv.ParseUint(numLit.Value, 0, 64)
+	if err != nil {
+		return 0, doc, err
+	}
+	call, ok := kv.Value.(*ast.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return 0, doc, fmt.Errorf("syscall %d: entry is not a helper call", num)
+	}
+	fn, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return 0, doc, fmt.Errorf("syscall %d: entry is not a helper call", num)
+	}
+	if doc.Name, ok = stringLit(call.Args[0]); !ok {
+		return 0, doc, fmt.Errorf("syscall %d: name is not a string literal", num)
+	}
+	if doc.Support, ok = supportLevels[fn.Sel.Name]; !ok {
+		doc.Support = "Undocumented"
+	}
+	if fn.Sel.Name == "Supported" {
+		doc.Note = "Fully Supported."
+		return uintptr(num), doc, nil
+	}
+	// All other helpers take a note and a list of URLs as their last two
+	// arguments.
+	n := len(call.Args)
+	if n < 4 {
+		return uintptr(num), doc, nil
+	}
+	doc.Note, _ = stringLit(call.Args[n-2])
+	if urls, ok := call.Args[n-1].(*ast.CompositeLit); ok {
+		for _, u := range urls.Elts {
+			if s, ok := stringLit(u); ok {
+				doc.URLs = append(doc.URLs, s)
+			}
+		}
+	}
+	// The error helpers append what the syscall returns to the note.
+	switch fn.Sel.Name {
+	case "Error", "ErrorWithEvent":
+		doc.Note = errorNote(doc.Note, fmt.Sprintf("Returns %q.", errnoMessage(call.Args[1])))
+	case "CapError":
+		doc.Note = errorNote(doc.Note, fmt.Sprintf("Returns %q if the process does not have %s; %q otherwise.", errnoMessages["EPERM"], selectorName(call.Args[1]), errnoMessages["ENOSYS"]))
+	}
+	return uintptr(num), doc, nil
+}
+
+// errorNote joins a note and the description of the error returned, as the
+// syscalls package does.
+func errorNote(note, returns string) string {
+	if note != "" {
+		return note + "; " + returns
+	}
+	return returns
+}
+
+// errnoMessages are the messages of the errors used in the syscall tables, as
+// returned by their Error method.
+var errnoMessages = map[string]string{
+	"EACCES":       "permission denied",
+	"EAFNOSUPPORT": "address family not supported by protocol",
+	"EBADF":        "bad file descriptor",
+	"EINVAL":       "invalid argument",
+	"ENODEV":       "no such device",
+	"ENOENT":       "no such file or directory",
+	"ENOMEM":       "cannot allocate memory",
+	"ENOPROTOOPT":  "protocol not available",
+	"ENOSYS":       "function not implemented",
+	"ENOTSUP":      "operation not supported",
+	"ENOTTY":       "inappropriate ioctl for device",
+	"EOPNOTSUPP":   "operation not supported",
+	"EPERM":        "operation not permitted",
+	"ESRCH":        "no such process",
+}
+
+// selectorName returns the name selected by e, e.g. ENOSYS for
+// syserror.ENOSYS, or e's source form if it isn't a selector.
+func selectorName(e ast.Expr) string {
+	if s, ok := e.(*ast.SelectorExpr); ok {
+		return s.Sel.Name
+	}
+	return types.ExprString(e)
+}
+
+// errnoMessage returns the message of the error e names, or its name if the
+// error is not known.
+func errnoMessage(e ast.Expr) string {
+	name := selectorName(e)
+	if msg, ok := errnoMessages[name]; ok {
+		return msg
+	}
+	return name
+}
+
+// stringLit returns the value of a string literal or a concatenation of
+// string literals.
+func stringLit(e ast.Expr) (string, bool) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		s, err := strconv.Unquote(e.Value)
+		return s, err == nil
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := stringLit(e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := stringLit(e.Y)
+		return x + y, ok
+	case *ast.ParenExpr:
+		return stringLit(e.X)
+	}
+	return "", false
+}
@@ -0,0 +1,75 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"reflect"
+	"testing"
+)
+
+func TestParseEntry(t *testing.T) {
+	for _, tc := range []struct {
+		entry string
+		num   uintptr
+		want  SyscallDoc
+	}{
+		{
+			entry: `0: syscalls.Supported("read", Read)`,
+			num:   0,
+			want:  SyscallDoc{Name: "read", Support: "Full Support", Note: "Fully Supported."},
+		},
+		{
+			entry: `38: syscalls.PartiallySupported("setitimer", Setitimer, "Not all " + "flags.", []string{"gvisor.dev/issue/1"})`,
+			num:   38,
+			want:  SyscallDoc{Name: "setitimer", Support: "Partial Support", Note: "Not all flags.", URLs: []string{"gvisor.dev/issue/1"}},
+		},
+		{
+			entry: `134: syscalls.Error("uselib", syserror.ENOSYS, "Obsolete", nil)`,
+			num:   134,
+			want:  SyscallDoc{Name: "uselib", Support: "Unimplemented", Note: `Obsolete; Returns "function not implemented".`},
+		},
+		{
+			entry: `135: syscalls.ErrorWithEvent("personality", syserror.EINVAL, "", nil)`,
+			num:   135,
+			want:  SyscallDoc{Name: "personality", Support: "Unimplemented", Note: `Returns "invalid argument".`},
+		},
+		{
+			entry: `156: syscalls.CapError("_sysctl", linux.CAP_SYS_ADMIN, "", nil)`,
+			num:   156,
+			want:  SyscallDoc{Name: "_sysctl", Support: "Unimplemented", Note: `Returns "operation not permitted" if the process does not have CAP_SYS_ADMIN; "function not implemented" otherwise.`},
+		},
+		{
+			entry: `0x10: syscalls.Other("ioctl", Ioctl)`,
+			num:   16,
+			want:  SyscallDoc{Name: "ioctl", Support: "Undocumented"},
+		},
+	} {
+		expr, err := parser.ParseExpr("map[uintptr]kernel.Syscall{" + tc.entry + "}")
+		if err != nil {
+			t.Fatalf("parsing %q: %v", tc.entry, err)
+		}
+		lit := expr.(*ast.CompositeLit)
+		num, got, err := parseEntry(lit.Elts[0])
+		if err != nil {
+			t.Errorf("parseEntry(%q) failed: %v", tc.entry, err)
+			continue
+		}
+		if num != tc.num || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseEntry(%q) = %d, %+v, want %d, %+v", tc.entry, num, got, tc.num, tc.want)
+		}
+	}
+}
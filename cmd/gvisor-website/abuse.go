@@ -0,0 +1,246 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Offender score increments.
+const (
+	// errorScore is added for each 4xx response.
+	errorScore = 1
+
+	// probeScore is added for each request for a probed path, such that a
+	// handful of probes is enough to be blocked.
+	probeScore = 10
+)
+
+// offender is the abuse state of a single client.
+type offender struct {
+	// score decays exponentially from last.
+	score float64
+	last  time.Time
+
+	// blockedUntil is zero if the client is not blocked.
+	blockedUntil time.Time
+}
+
+// offenderTracker scores clients by the errors and probes they generate, and
+// temporarily blocks clients whose score reaches a threshold. Scores decay
+// with the given half-life, so only sustained abuse leads to a block.
+type offenderTracker struct {
+	threshold   float64
+	halfLife    time.Duration
+	blockFor    time.Duration
+	probePrefix []string
+
+	mu        sync.Mutex
+	offenders map[string]*offender
+	lastGC    time.Time
+}
+
+// newOffenderTracker returns a tracker that blocks clients for blockFor once
+// their score reaches threshold. Requests for paths with any of the given
+// prefixes count as probes.
+func newOffenderTracker(threshold int, halfLife, blockFor time.Duration, probePrefixes []string) *offenderTracker {
+	return &offenderTracker{
+		threshold:   float64(threshold),
+		halfLife:    halfLife,
+		blockFor:    blockFor,
+		probePrefix: probePrefixes,
+		offenders:   make(map[string]*offender),
+		lastGC:      time.Now(),
+	}
+}
+
+// offenders is the global tracker, or nil if abuse blocking is disabled. It
+// is set from flags at startup.
+var offenders *offenderTracker
+
+var (
+	abuseBlocks  = newCounter("abuse_blocks_total", "Clients blocked for abuse.")
+	abuseBlocked = newGauge("abuse_blocked_clients", "Clients currently blocked for abuse.")
+)
+
+// decayedLocked returns the score of o at the given time.
+//
+// Precondition: t.mu must be held.
+func (t *offenderTracker) decayedLocked(o *offender, now time.Time) float64 {
+	return o.score * math.Pow(0.5, now.Sub(o.last).Seconds()/t.halfLife.Seconds())
+}
+
+// blocked returns true if the given client is currently blocked.
+func (t *offenderTracker) blocked(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, ok := t.offenders[ip]
+	return ok && time.Now().Before(o.blockedUntil)
+}
+
+// isProbe returns true if the given path is a known scanner target.
+func (t *offenderTracker) isProbe(path string) bool {
+	path = strings.ToLower(path)
+	for _, prefix := range t.probePrefix {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// record adds delta to the score of the given client, blocking it if the
+// threshold is reached.
+func (t *offenderTracker) record(ip string, delta float64) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gcLocked(now)
+	o, ok := t.offenders[ip]
+	if !ok {
+		o = &offender{}
+		t.offenders[ip] = o
+	}
+	o.score = t.decayedLocked(o, now) + delta
+	o.last = now
+	if o.score >= t.threshold && !now.Before(o.blockedUntil) {
+		o.blockedUntil = now.Add(t.blockFor)
+		abuseBlocks.inc()
+	}
+}
+
+// unblock clears the state of the given client, returning false if the
+// client was unknown.
+func (t *offenderTracker) unblock(ip string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.offenders[ip]
+	delete(t.offenders, ip)
+	return ok
+}
+
+// gcLocked drops clients that are not blocked and whose score has decayed
+// away, at most once a minute.
+//
+// Precondition: t.mu must be held.
+func (t *offenderTracker) gcLocked(now time.Time) {
+	if now.Sub(t.lastGC) < time.Minute {
+		return
+	}
+	t.lastGC = now
+	blocked := 0
+	for ip, o := range t.offenders {
+		if now.Before(o.blockedUntil) {
+			blocked++
+			continue
+		}
+		if t.decayedLocked(o, now) < errorScore {
+			delete(t.offenders, ip)
+		}
+	}
+	abuseBlocked.set(float64(blocked))
+}
+
+// offenderEntry is the admin API representation of a tracked client.
+type offenderEntry struct {
+	IP           string     `json:"ip"`
+	Score        float64    `json:"score"`
+	BlockedUntil *time.Time `json:"blocked_until,omitempty"`
+}
+
+// list returns the tracked clients, highest score first.
+func (t *offenderTracker) list() []offenderEntry {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := make([]offenderEntry, 0, len(t.offenders))
+	for ip, o := range t.offenders {
+		e := offenderEntry{IP: ip, Score: t.decayedLocked(o, now)}
+		if now.Before(o.blockedUntil) {
+			until := o.blockedUntil
+			e.BlockedUntil = &until
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Score > entries[j].Score })
+	return entries
+}
+
+// abuseBlockHandler rejects requests from blocked clients with 403 Forbidden,
+// and scores clients by the 4xx responses they receive. Rate limited requests
+// aren't scored, since well-behaved clients hit the limits too. Requests
+// bearing the admin token are exempt, so that operators can't lock themselves
+// out.
+func abuseBlockHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := offenders
+		if t == nil || (*adminToken != "" && hasBearerToken(r, *adminToken)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if t.blocked(ip) {
+			requestsShed.inc(route, "abuse")
+			httpError(w, r, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if t.isProbe(r.URL.Path) {
+			t.record(ip, probeScore)
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if code := rec.code(); code >= 400 && code < 500 && code != http.StatusTooManyRequests {
+			t.record(ip, errorScore)
+		}
+	})
+}
+
+// adminBlockedHandler returns a handler for listing (GET) and unblocking
+// (DELETE ?ip=) clients tracked for abuse.
+func adminBlockedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := offenders
+		if t == nil {
+			httpError(w, r, "abuse blocking is disabled", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(t.list())
+		case "DELETE":
+			ip := r.URL.Query().Get("ip")
+			if ip == "" {
+				httpError(w, r, "invalid request: missing ip", http.StatusBadRequest)
+				return
+			}
+			if !t.unblock(ip) {
+				httpError(w, r, "unknown client", http.StatusNotFound)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOffenderDecay(t *testing.T) {
+	tr := newOffenderTracker(10, time.Minute, time.Hour, nil)
+	now := time.Now()
+	for _, tc := range []struct {
+		score float64
+		age   time.Duration
+		want  float64
+	}{
+		{8, 0, 8},
+		{8, time.Minute, 4},
+		{8, 2 * time.Minute, 2},
+		{8, 30 * time.Second, 8 / math.Sqrt2},
+	} {
+		o := &offender{score: tc.score, last: now.Add(-tc.age)}
+		if got := tr.decayedLocked(o, now); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("decayed(%v after %v) = %v, want %v", tc.score, tc.age, got, tc.want)
+		}
+	}
+}
+
+func TestOffenderTracker(t *testing.T) {
+	tr := newOffenderTracker(15, time.Minute, time.Hour, []string{"/wp-admin", "/.env"})
+	for path, want := range map[string]bool{
+		"/wp-admin/setup.php": true,
+		"/.ENV":               true,
+		"/docs/":              false,
+	} {
+		if got := tr.isProbe(path); got != want {
+			t.Errorf("isProbe(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	tr.record("a", probeScore)
+	if tr.blocked("a") {
+		t.Errorf("client blocked below the threshold")
+	}
+	tr.record("a", probeScore)
+	if !tr.blocked("a") {
+		t.Errorf("client not blocked at the threshold")
+	}
+	if tr.blocked("b") {
+		t.Errorf("unknown client blocked")
+	}
+
+	// Decayed scores don't add up to a block.
+	tr.record("c", probeScore)
+	tr.offenders["c"].last = time.Now().Add(-time.Hour)
+	tr.record("c", probeScore)
+	if tr.blocked("c") {
+		t.Errorf("client blocked by a decayed score")
+	}
+
+	if !tr.unblock("a") || tr.blocked("a") {
+		t.Errorf("unblock didn't unblock the client")
+	}
+	if tr.unblock("b") {
+		t.Errorf("unblock of an unknown client succeeded")
+	}
+}
+
+func TestAbuseBlockHandler(t *testing.T) {
+	defer func(t *offenderTracker) { offenders = t }(offenders)
+	offenders = newOffenderTracker(3, time.Hour, time.Hour, []string{"/wp-admin"})
+	code := http.StatusTooManyRequests
+	h := abuseBlockHandler("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	get := func(ip, path string) int {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Rate limited clients aren't scored.
+	for i := 0; i < 5; i++ {
+		get("192.0.2.1", "/api/search")
+	}
+	if offenders.blocked("192.0.2.1") {
+		t.Errorf("client blocked for rate limited requests")
+	}
+
+	// Scores decay, so take one error more than the threshold.
+	code = http.StatusNotFound
+	for i := 0; i < 4; i++ {
+		get("192.0.2.2", "/missing")
+	}
+	if got := get("192.0.2.2", "/docs/"); got != http.StatusForbidden {
+		t.Errorf("got status %d after repeated 404s, want 403", got)
+	}
+
+	if got := get("192.0.2.3", "/wp-admin/"); got != http.StatusNotFound {
+		t.Errorf("got status %d for a probe, want 404", got)
+	}
+}
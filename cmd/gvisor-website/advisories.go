@@ -0,0 +1,382 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// advisoriesCacheKey is the cache key for the security advisories.
+	advisoriesCacheKey = "github:advisories"
+
+	// advisoriesTTL is how long the advisories are served without
+	// refetching them from GitHub.
+	advisoriesTTL = 15 * time.Minute
+
+	// advisoriesStaleTTL is how long the advisories are kept for serving if
+	// GitHub is unavailable.
+	advisoriesStaleTTL = 7 * 24 * time.Hour
+
+	// advisoriesFetchTimeout bounds a fetch of the advisories, which is
+	// shared by all requests waiting for it.
+	advisoriesFetchTimeout = 30 * time.Second
+
+	// maxAdvisoryPages bounds the pages of advisories fetched.
+	maxAdvisoryPages = 10
+
+	// advisoryPrefix is the path of the advisory shortlinks.
+	advisoryPrefix = "/advisory/"
+)
+
+var advisoryFetches = newCounter("advisories_fetch_total", "GitHub security advisory fetches by result.", "result")
+
+// advisoryVulnerability is a vulnerable package of an advisory.
+type advisoryVulnerability struct {
+	Ecosystem  string `json:"ecosystem,omitempty"`
+	Package    string `json:"package,omitempty"`
+	Vulnerable string `json:"vulnerable_versions,omitempty"`
+	Patched    string `json:"patched_versions,omitempty"`
+}
+
+// advisory is a published security advisory of gVisor.
+type advisory struct {
+	// ID is the GitHub Security Advisory ID, e.g. GHSA-xxxx-xxxx-xxxx.
+	ID          string                  `json:"id"`
+	CVE         string                  `json:"cve,omitempty"`
+	Summary     string                  `json:"summary"`
+	Description string                  `json:"description,omitempty"`
+	Severity    string                  `json:"severity,omitempty"`
+	URL         string                  `json:"url"`
+	Published   time.Time               `json:"published"`
+	Updated     time.Time               `json:"updated"`
+	Withdrawn   *time.Time              `json:"withdrawn,omitempty"`
+	Affected    []advisoryVulnerability `json:"vulnerabilities,omitempty"`
+
+	// Shortlink is the gvisor.dev URL of the advisory.
+	Shortlink string `json:"shortlink"`
+}
+
+// githubAdvisory is an advisory as returned by the GitHub repository security
+// advisories API.
+type githubAdvisory struct {
+	GHSAID          string     `json:"ghsa_id"`
+	CVEID           string     `json:"cve_id"`
+	HTMLURL         string     `json:"html_url"`
+	Summary         string     `json:"summary"`
+	Description     string     `json:"description"`
+	Severity        string     `json:"severity"`
+	PublishedAt     time.Time  `json:"published_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	WithdrawnAt     *time.Time `json:"withdrawn_at"`
+	Vulnerabilities []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		VulnerableVersionRange string `json:"vulnerable_version_range"`
+		PatchedVersions        string `json:"patched_versions"`
+	} `json:"vulnerabilities"`
+}
+
+// advisory converts the advisory.
+func (g *githubAdvisory) advisory() advisory {
+	a := advisory{
+		ID:          g.GHSAID,
+		CVE:         g.CVEID,
+		Summary:     g.Summary,
+		Description: g.Description,
+		Severity:    g.Severity,
+		URL:         g.HTMLURL,
+		Published:   g.PublishedAt,
+		Updated:     g.UpdatedAt,
+		Withdrawn:   g.WithdrawnAt,
+		Shortlink:   siteURL(advisoryPrefix + g.GHSAID),
+	}
+	for _, v := range g.Vulnerabilities {
+		a.Affected = append(a.Affected, advisoryVulnerability{
+			Ecosystem:  v.Package.Ecosystem,
+			Package:    v.Package.Name,
+			Vulnerable: v.VulnerableVersionRange,
+			Patched:    v.PatchedVersions,
+		})
+	}
+	return a
+}
+
+// linkNextRE matches the next page in a GitHub Link header.
+var linkNextRE = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// fetchAdvisories fetches the published advisories from GitHub, newest
+// first.
+func fetchAdvisories(ctx context.Context) ([]advisory, error) {
+	advisories := []advisory{}
+	next := *advisoriesURL + "?state=published&sort=published&direction=desc&per_page=100"
+	for page := 0; next != "" && page < maxAdvisoryPages; page++ {
+		req, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		var body []githubAdvisory
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("security advisories: %s", resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for i := range body {
+			if body[i].GHSAID != "" {
+				advisories = append(advisories, body[i].advisory())
+			}
+		}
+		next = ""
+		if m := linkNextRE.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			next = m[1]
+		}
+	}
+	return advisories, nil
+}
+
+// advisoriesEntry is the cached advisories.
+type advisoriesEntry struct {
+	Advisories []advisory `json:"advisories"`
+	Fetched    time.Time  `json:"fetched"`
+}
+
+// advisoriesFlight deduplicates concurrent fetches of the advisories.
+var advisoriesFlight flightGroup
+
+// securityAdvisories returns the published advisories, newest first, using
+// the shared cache so that instances don't each use up the GitHub API rate
+// limit. Stale advisories are served if GitHub is unavailable.
+func securityAdvisories(ctx context.Context) (*advisoriesEntry, error) {
+	var prev *advisoriesEntry
+	if b, ok, err := sharedCache.get(ctx, advisoriesCacheKey); err == nil && ok {
+		var e advisoriesEntry
+		if err := json.Unmarshal(b, &e); err == nil {
+			prev = &e
+		}
+	}
+	if prev != nil && time.Since(prev.Fetched) < advisoriesTTL {
+		return prev, nil
+	}
+	v, err := advisoriesFlight.do(advisoriesCacheKey, func() (interface{}, error) {
+		// The fetch is shared, so it must not be canceled with the
+		// request that started it.
+		ctx, cancel := context.WithTimeout(context.Background(), advisoriesFetchTimeout)
+		defer cancel()
+		advisories, err := fetchAdvisories(ctx)
+		if err != nil {
+			advisoryFetches.inc("error")
+			if prev == nil {
+				return nil, err
+			}
+			log.Printf("Error fetching security advisories, serving stale: %v", err)
+			return prev, nil
+		}
+		advisoryFetches.inc("ok")
+		e := &advisoriesEntry{Advisories: advisories, Fetched: time.Now()}
+		if b, err := json.Marshal(e); err == nil {
+			sharedCache.set(ctx, advisoriesCacheKey, b, advisoriesStaleTTL)
+		}
+		return e, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e, ok := v.(*advisoriesEntry)
+	if !ok {
+		return nil, errFlightPanicked
+	}
+	return e, nil
+}
+
+// advisoriesJSONHandler serves the published advisories as JSON.
+func advisoriesJSONHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, err := securityAdvisories(r.Context())
+		if err != nil {
+			log.Printf("Error fetching security advisories: %v", err)
+			httpError(w, r, "security advisories are unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(e.Advisories)
+	})
+}
+
+// atomFeed is an Atom feed of advisories.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is a link of an Atom feed or entry.
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// atomCategory is a category of an Atom entry.
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// atomText is a text construct of an Atom entry.
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+// atomEntry is an advisory in an Atom feed.
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Categories []atomCategory `xml:"category"`
+	Summary    string         `xml:"summary"`
+	Content    *atomText      `xml:"content,omitempty"`
+}
+
+// advisoriesFeed returns the Atom feed of the advisories.
+func advisoriesFeed(advisories []advisory) *atomFeed {
+	feed := &atomFeed{
+		Title: "gVisor security advisories",
+		ID:    siteURL("/security/advisories.atom"),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: siteURL("/security/advisories.atom")},
+			{Rel: "alternate", Type: "application/json", Href: siteURL("/security/advisories.json")},
+		},
+	}
+	var updated time.Time
+	for _, a := range advisories {
+		if a.Updated.After(updated) {
+			updated = a.Updated
+		}
+		title := a.ID + ": " + a.Summary
+		if a.CVE != "" {
+			title = a.CVE + " (" + a.ID + "): " + a.Summary
+		}
+		if a.Withdrawn != nil {
+			title = "[Withdrawn] " + title
+		}
+		e := atomEntry{
+			Title:     title,
+			ID:        a.Shortlink,
+			Published: a.Published.UTC().Format(time.RFC3339),
+			Updated:   a.Updated.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: a.URL}},
+			Summary:   a.Summary,
+		}
+		if a.Severity != "" {
+			e.Categories = append(e.Categories, atomCategory{Term: a.Severity})
+		}
+		if a.Description != "" {
+			e.Content = &atomText{Type: "text", Body: a.Description}
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	return feed
+}
+
+// advisoriesAtomHandler serves the published advisories as an Atom feed.
+func advisoriesAtomHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e, err := securityAdvisories(r.Context())
+		if err != nil {
+			log.Printf("Error fetching security advisories: %v", err)
+			httpError(w, r, "security advisories are unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		b, err := xml.MarshalIndent(advisoriesFeed(e.Advisories), "", "  ")
+		if err != nil {
+			httpError(w, r, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		io.WriteString(w, xml.Header)
+		w.Write(b)
+	})
+}
+
+var (
+	ghsaIDRE = regexp.MustCompile(`^GHSA(-[23456789cfghjmpqrvwx]{4}){3}$`)
+	cveIDRE  = regexp.MustCompile(`^CVE-[0-9]{4}-[0-9]{4,}$`)
+)
+
+// advisoryRedirectHandler redirects /advisory/<id> to the advisory with the
+// given GHSA or CVE ID on GitHub.
+func advisoryRedirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, advisoryPrefix), "/")
+		// GHSA IDs are canonically lowercase after the prefix, and CVE
+		// IDs uppercase.
+		ghsa := ""
+		if strings.HasPrefix(strings.ToUpper(id), "GHSA-") {
+			ghsa = "GHSA" + strings.ToLower(id[len("GHSA"):])
+		}
+		if !ghsaIDRE.MatchString(ghsa) && !cveIDRE.MatchString(strings.ToUpper(id)) {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		e, err := securityAdvisories(r.Context())
+		if err == nil {
+			for _, a := range e.Advisories {
+				if strings.EqualFold(a.ID, id) || strings.EqualFold(a.CVE, id) {
+					redirectWithQuery(w, r, a.URL)
+					return
+				}
+			}
+		}
+		switch {
+		case ghsa != "":
+			// GitHub knows advisories that aren't in the cached
+			// advisories yet.
+			redirectWithQuery(w, r, "https://github.com/google/gvisor/security/advisories/"+ghsa)
+		case err != nil:
+			log.Printf("Error fetching security advisories: %v", err)
+			httpError(w, r, "security advisories are unavailable", http.StatusServiceUnavailable)
+		default:
+			httpError(w, r, "Not found", http.StatusNotFound)
+		}
+	})
+}
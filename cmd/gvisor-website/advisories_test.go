@@ -0,0 +1,173 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testAdvisories are the pages of advisories served by the test GitHub API.
+var testAdvisories = []string{`[
+	{"ghsa_id": "GHSA-2222-3333-4444", "cve_id": "CVE-2020-1234", "html_url": "https://github.com/google/gvisor/security/advisories/GHSA-2222-3333-4444",
+	 "summary": "Sandbox escape", "description": "A bug & more.", "severity": "high",
+	 "published_at": "2020-02-01T00:00:00Z", "updated_at": "2020-02-03T00:00:00Z",
+	 "vulnerabilities": [{"package": {"ecosystem": "go", "name": "gvisor.dev/gvisor"}, "vulnerable_version_range": "< 20200127.0", "patched_versions": "20200127.0"}]}
+]`, `[
+	{"ghsa_id": "GHSA-5555-6666-7777", "html_url": "https://github.com/google/gvisor/security/advisories/GHSA-5555-6666-7777",
+	 "summary": "Denial of service", "severity": "low",
+	 "published_at": "2020-01-01T00:00:00Z", "updated_at": "2020-01-02T00:00:00Z", "withdrawn_at": "2020-01-05T00:00:00Z"}
+]`}
+
+// advisoriesTestServer serves testAdvisories, or errors if fail is set.
+type advisoriesTestServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fail     bool
+	requests int
+}
+
+func newAdvisoriesTestServer(t *testing.T) *advisoriesTestServer {
+	s := &advisoriesTestServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests++
+		fail := s.fail
+		s.mu.Unlock()
+		if fail {
+			http.Error(w, "unavailable", http.StatusBadGateway)
+			return
+		}
+		if r.URL.Query().Get("state") != "published" {
+			t.Errorf("request %s isn't for published advisories", r.URL)
+		}
+		page := 0
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page+1 < len(testAdvisories) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/advisories?state=published&page=%d>; rel="next", <%s/advisories?state=published&page=%d>; rel="last"`, s.URL, page+1, s.URL, len(testAdvisories)-1))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testAdvisories[page]))
+	}))
+	return s
+}
+
+func TestAdvisories(t *testing.T) {
+	defer func(c cache, u string) { sharedCache, *advisoriesURL = c, u }(sharedCache, *advisoriesURL)
+	sharedCache = newMemoryCache(100, 1<<20)
+	srv := newAdvisoriesTestServer(t)
+	defer srv.Close()
+	*advisoriesURL = srv.URL + "/advisories"
+
+	rec := httptest.NewRecorder()
+	advisoriesJSONHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/security/advisories.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var advisories []advisory
+	if err := json.NewDecoder(rec.Body).Decode(&advisories); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(advisories) != 2 || advisories[0].ID != "GHSA-2222-3333-4444" || advisories[1].ID != "GHSA-5555-6666-7777" {
+		t.Fatalf("got advisories %+v, want both pages", advisories)
+	}
+	if a := advisories[0]; a.CVE != "CVE-2020-1234" || len(a.Affected) != 1 || a.Affected[0].Patched != "20200127.0" || a.Shortlink != siteURL("/advisory/GHSA-2222-3333-4444") {
+		t.Errorf("got advisory %+v", a)
+	}
+	if advisories[1].Withdrawn == nil {
+		t.Errorf("withdrawn advisory has no withdrawn time")
+	}
+
+	rec = httptest.NewRecorder()
+	advisoriesAtomHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/security/advisories.atom", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("got Content-Type %q, want Atom", ct)
+	}
+	var feed atomFeed
+	if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil {
+		t.Fatalf("decoding feed failed: %v", err)
+	}
+	if feed.Updated != "2020-02-03T00:00:00Z" || len(feed.Entries) != 2 {
+		t.Fatalf("got feed %+v", feed)
+	}
+	if e := feed.Entries[0]; e.Title != "CVE-2020-1234 (GHSA-2222-3333-4444): Sandbox escape" || e.Content == nil || e.Content.Body != "A bug & more." {
+		t.Errorf("got entry %+v", e)
+	}
+	if e := feed.Entries[1]; !strings.HasPrefix(e.Title, "[Withdrawn] ") {
+		t.Errorf("withdrawn entry has title %q", e.Title)
+	}
+
+	// The advisories are cached.
+	if srv.requests != len(testAdvisories) {
+		t.Errorf("got %d upstream requests, want %d", srv.requests, len(testAdvisories))
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+		want string
+	}{
+		{"/advisory/GHSA-2222-3333-4444", http.StatusFound, "https://github.com/google/gvisor/security/advisories/GHSA-2222-3333-4444"},
+		{"/advisory/cve-2020-1234", http.StatusFound, "https://github.com/google/gvisor/security/advisories/GHSA-2222-3333-4444"},
+		{"/advisory/ghsa-8888-9999-cccc/", http.StatusFound, "https://github.com/google/gvisor/security/advisories/GHSA-8888-9999-cccc"},
+		{"/advisory/CVE-2019-0001", http.StatusNotFound, ""},
+		{"/advisory/../status", http.StatusNotFound, ""},
+		{"/advisory/", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		advisoryRedirectHandler().ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.path, rec.Code, tc.code)
+		}
+		if got := rec.Header().Get("Location"); got != tc.want {
+			t.Errorf("%s: got Location %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestAdvisoriesStale(t *testing.T) {
+	defer func(c cache, u string) { sharedCache, *advisoriesURL = c, u }(sharedCache, *advisoriesURL)
+	sharedCache = newMemoryCache(100, 1<<20)
+	srv := newAdvisoriesTestServer(t)
+	defer srv.Close()
+	*advisoriesURL = srv.URL + "/advisories"
+	srv.fail = true
+
+	rec := httptest.NewRecorder()
+	advisoriesJSONHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/security/advisories.json", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without cached advisories: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// Stale advisories are served while GitHub is unavailable.
+	b, err := json.Marshal(advisoriesEntry{Advisories: []advisory{{ID: "GHSA-2222-3333-4444"}}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	sharedCache.set(context.Background(), advisoriesCacheKey, b, advisoriesStaleTTL)
+	rec = httptest.NewRecorder()
+	advisoriesJSONHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/security/advisories.json", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "GHSA-2222-3333-4444") {
+		t.Errorf("with stale advisories: got status %d, body %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxAnalyticsPayload bounds the size of a proxied collection request. The
+// Measurement Protocol limits payloads to 8K.
+const maxAnalyticsPayload = 8 << 10
+
+var analyticsHits = newCounter("analytics_hits_total", "Analytics collection requests, by result.", "result")
+
+// analyticsParams returns the Measurement Protocol parameters of the request,
+// from the query string for GET and the body for POST. analytics.js posts
+// hits as text/plain, so the body is parsed whatever its content type.
+func analyticsParams(r *http.Request) (url.Values, error) {
+	if r.Method == "GET" {
+		return r.URL.Query(), nil
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(b))
+}
+
+// analyticsProxyHandler forwards Measurement Protocol hits to the given
+// collection endpoint.
+//
+// The upstream only ever sees the server's address: the client IP is not
+// forwarded, any IP override is dropped and IP anonymization is forced on.
+// Cookies are not forwarded either. Hits from clients sending DNT or Sec-GPC
+// are accepted and discarded.
+func analyticsProxyHandler(collectURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "POST" {
+			w.Header().Set("Allow", "GET, POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if doNotTrack(r) {
+			analyticsHits.inc("dnt")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxAnalyticsPayload)
+		params, err := analyticsParams(r)
+		if err != nil {
+			analyticsHits.inc("invalid")
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		params.Del("uip")
+		params.Set("aip", "1")
+
+		req, err := http.NewRequest("POST", collectURL, strings.NewReader(params.Encode()))
+		if err != nil {
+			analyticsHits.inc("error")
+			httpError(w, r, "internal error", http.StatusInternalServerError)
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", r.UserAgent())
+		resp, err := upstreamClient("analytics").Do(req.WithContext(r.Context()))
+		if err != nil {
+			analyticsHits.inc("error")
+			httpError(w, r, "analytics backend unavailable", http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		analyticsHits.inc("forwarded")
+		if ct := resp.Header.Get("Content-Type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, io.LimitReader(resp.Body, maxAnalyticsPayload))
+	})
+}
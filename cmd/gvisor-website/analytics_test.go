@@ -0,0 +1,47 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnalyticsParams(t *testing.T) {
+	for _, tc := range []struct {
+		method      string
+		target      string
+		contentType string
+		body        string
+	}{
+		{method: "GET", target: "/collect?v=1&t=pageview&dp=%2Fdocs%2F"},
+		{method: "POST", target: "/collect", contentType: "text/plain;charset=UTF-8", body: "v=1&t=pageview&dp=%2Fdocs%2F"},
+		{method: "POST", target: "/collect", contentType: "application/x-www-form-urlencoded", body: "v=1&t=pageview&dp=%2Fdocs%2F"},
+	} {
+		r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			r.Header.Set("Content-Type", tc.contentType)
+		}
+		params, err := analyticsParams(r)
+		if err != nil {
+			t.Errorf("%s %s (%s): %v", tc.method, tc.target, tc.contentType, err)
+			continue
+		}
+		if got := params.Encode(); got != "dp=%2Fdocs%2F&t=pageview&v=1" {
+			t.Errorf("%s %s (%s): got params %q", tc.method, tc.target, tc.contentType, got)
+		}
+	}
+}
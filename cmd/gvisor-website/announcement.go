@@ -0,0 +1,304 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Announcement severities.
+const (
+	severityInfo     = "info"
+	severityWarning  = "warning"
+	severityCritical = "critical"
+)
+
+// announcement is a site-wide message shown on every page, such as a release
+// announcement or security advisory.
+type announcement struct {
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+
+	// Link is an optional URL for more information.
+	Link string `json:"link,omitempty"`
+
+	// Expires is the time after which the announcement is no longer
+	// shown. It is nil if the announcement does not expire.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// active returns true if the announcement should be shown at the given time.
+func (a *announcement) active(now time.Time) bool {
+	return a != nil && (a.Expires == nil || now.Before(*a.Expires))
+}
+
+// validate checks the announcement.
+func (a *announcement) validate() error {
+	if strings.TrimSpace(a.Message) == "" {
+		return fmt.Errorf("message must be non-empty")
+	}
+	switch a.Severity {
+	case "":
+		a.Severity = severityInfo
+	case severityInfo, severityWarning, severityCritical:
+	default:
+		return fmt.Errorf("unknown severity %q", a.Severity)
+	}
+	if a.Link != "" && !strings.HasPrefix(a.Link, "/") && !strings.HasPrefix(a.Link, "https://") {
+		return fmt.Errorf("link must be a path or https URL")
+	}
+	return nil
+}
+
+// announcementBackend persists the current announcement.
+type announcementBackend interface {
+	// load returns the stored announcement, or nil if there is none.
+	load(ctx context.Context) (*announcement, error)
+
+	// put stores the announcement.
+	put(ctx context.Context, a *announcement) error
+
+	// remove deletes the announcement.
+	remove(ctx context.Context) error
+}
+
+// announcements holds the current announcement. As with dynamic redirects,
+// it is served from a local copy which is periodically synced from the
+// backend.
+type announcements struct {
+	// backend is nil if the announcement is only kept in memory.
+	backend announcementBackend
+
+	mu      sync.RWMutex
+	current *announcement
+}
+
+// newAnnouncements returns announcements for the given store type, which is
+// either "memory" or "firestore".
+func newAnnouncements(ctx context.Context, store string) (*announcements, error) {
+	a := &announcements{}
+	switch store {
+	case "", "memory":
+	case "firestore":
+		fs, err := newFirestoreClient(ctx, *projectId)
+		if err != nil {
+			return nil, err
+		}
+		a.backend = &firestoreAnnouncements{fs: fs}
+	default:
+		return nil, fmt.Errorf("unknown announcement store %q", store)
+	}
+	return a, nil
+}
+
+// get returns the current announcement, or nil if there is none or it has
+// expired.
+func (a *announcements) get() *announcement {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !a.current.active(time.Now()) {
+		return nil
+	}
+	return a.current
+}
+
+// set replaces the announcement.
+func (a *announcements) set(ctx context.Context, ann *announcement) error {
+	if a.backend != nil {
+		if err := a.backend.put(ctx, ann); err != nil {
+			return err
+		}
+	}
+	a.mu.Lock()
+	a.current = ann
+	a.mu.Unlock()
+	return nil
+}
+
+// clear removes the announcement.
+func (a *announcements) clear(ctx context.Context) error {
+	if a.backend != nil {
+		if err := a.backend.remove(ctx); err != nil {
+			return err
+		}
+	}
+	a.mu.Lock()
+	a.current = nil
+	a.mu.Unlock()
+	return nil
+}
+
+// sync replaces the local copy with the backend contents.
+func (a *announcements) sync(ctx context.Context) error {
+	if a.backend == nil {
+		return nil
+	}
+	ann, err := a.backend.load(ctx)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.current = ann
+	a.mu.Unlock()
+	return nil
+}
+
+// syncLoop periodically syncs the announcement until the context is
+// cancelled.
+func (a *announcements) syncLoop(ctx context.Context, interval time.Duration) {
+	if a.backend == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.sync(ctx); err != nil {
+				log.Printf("Error syncing announcement: %v", err)
+			}
+		}
+	}
+}
+
+// Firestore location of the announcement.
+const (
+	announcementCollection = "announcements"
+	announcementDoc        = "current"
+)
+
+// firestoreAnnouncements stores the announcement as a single Firestore
+// document.
+type firestoreAnnouncements struct {
+	fs *firestoreClient
+}
+
+func (f *firestoreAnnouncements) load(ctx context.Context) (*announcement, error) {
+	docs, err := f.fs.list(ctx, announcementCollection)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		if path.Base(doc.Name) != announcementDoc {
+			continue
+		}
+		a := &announcement{
+			Message:  doc.Fields["message"].str(),
+			Severity: doc.Fields["severity"].str(),
+			Link:     doc.Fields["link"].str(),
+		}
+		if t := doc.Fields["expires"].time(); !t.IsZero() {
+			a.Expires = &t
+		}
+		return a, nil
+	}
+	return nil, nil
+}
+
+func (f *firestoreAnnouncements) put(ctx context.Context, a *announcement) error {
+	fields := map[string]firestoreValue{
+		"message":  stringValue(a.Message),
+		"severity": stringValue(a.Severity),
+		"link":     stringValue(a.Link),
+	}
+	if a.Expires != nil {
+		fields["expires"] = timestampValue(*a.Expires)
+	}
+	return f.fs.set(ctx, announcementCollection, announcementDoc, fields)
+}
+
+func (f *firestoreAnnouncements) remove(ctx context.Context) error {
+	return f.fs.delete(ctx, announcementCollection, announcementDoc)
+}
+
+var bannerTemplate = template.Must(template.New("banner").Parse(`<div class="site-announcement site-announcement-{{.Severity}}" role="{{if eq .Severity "info"}}status{{else}}alert{{end}}" style="position:fixed;left:0;right:0;bottom:0;z-index:2000;padding:0.6em 1em;text-align:center;font-size:0.95em;color:{{if eq .Severity "info"}}#fff;background:#262362{{else if eq .Severity "warning"}}#222;background:#ffd866{{else}}#fff;background:#cf222e{{end}}">
+{{.Message}}{{if .Link}} <a href="{{.Link}}" style="color:inherit;text-decoration:underline">Learn more</a>{{end}}
+</div>
+`))
+
+// bannerRule returns the rewrite rule injecting the current announcement, if
+// any, into all pages.
+func bannerRule(a *announcements) *rewriteRule {
+	return &rewriteRule{
+		Name:   "announcement",
+		Paths:  []string{"*"},
+		Anchor: "</body>",
+		Action: rewriteBefore,
+		render: func(r *http.Request) string {
+			ann := a.get()
+			if ann == nil {
+				return ""
+			}
+			var b strings.Builder
+			if err := bannerTemplate.Execute(&b, ann); err != nil {
+				log.Printf("Error rendering announcement: %v", err)
+				return ""
+			}
+			return b.String()
+		},
+	}
+}
+
+// adminAnnouncementHandler returns a handler for getting (GET), setting (PUT
+// or POST) and removing (DELETE) the announcement.
+func adminAnnouncementHandler(a *announcements) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			a.mu.RLock()
+			ann := a.current
+			a.mu.RUnlock()
+			if ann == nil {
+				httpError(w, r, "no announcement", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(ann)
+		case "PUT", "POST":
+			var ann announcement
+			if err := json.NewDecoder(r.Body).Decode(&ann); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := ann.validate(); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := a.set(r.Context(), &ann); err != nil {
+				httpError(w, r, "store error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			if err := a.clear(r.Context()); err != nil {
+				httpError(w, r, "store error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
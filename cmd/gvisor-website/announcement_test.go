@@ -0,0 +1,162 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnouncementValidate(t *testing.T) {
+	for _, tc := range []struct {
+		ann      announcement
+		ok       bool
+		severity string
+	}{
+		{announcement{Message: "gVisor 1.0 is out"}, true, severityInfo},
+		{announcement{Message: "Upgrade now", Severity: severityCritical, Link: "https://gvisor.dev/blog/"}, true, severityCritical},
+		{announcement{Message: "See the notes", Severity: severityWarning, Link: "/docs/"}, true, severityWarning},
+		{announcement{Message: "  "}, false, ""},
+		{announcement{Message: "Hi", Severity: "urgent"}, false, "urgent"},
+		{announcement{Message: "Hi", Link: "javascript:alert(1)"}, false, severityInfo},
+		{announcement{Message: "Hi", Link: "http://example.com/"}, false, severityInfo},
+	} {
+		ann := tc.ann
+		if err := ann.validate(); (err == nil) != tc.ok || (tc.ok && ann.Severity != tc.severity) {
+			t.Errorf("validate(%+v) = %v with severity %q, want ok %t and severity %q", tc.ann, err, ann.Severity, tc.ok, tc.severity)
+		}
+	}
+}
+
+func TestAdminAnnouncementHandler(t *testing.T) {
+	defer func(token string) { *adminToken = token }(*adminToken)
+	*adminToken = "secret"
+	banner, err := newAnnouncements(context.Background(), "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerAdmin(mux, nil, nil, banner, nil)
+	do := func(method, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/admin/announcement", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("PUT", "wrong", `{"message":"hi"}`); w.Code != http.StatusUnauthorized || banner.get() != nil {
+		t.Errorf("PUT with a wrong token: got status %d and announcement %+v, want 401 and none", w.Code, banner.get())
+	}
+	if w := do("GET", "secret", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET without an announcement: got status %d, want 404", w.Code)
+	}
+	for _, body := range []string{`not json`, `{"message":""}`, `{"message":"hi","severity":"urgent"}`, `{"message":"hi","link":"javascript:x"}`} {
+		if w := do("PUT", "secret", body); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: got status %d, want 400", body, w.Code)
+		}
+	}
+	if w := do("PATCH", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PATCH: got status %d, want 405", w.Code)
+	}
+
+	if w := do("POST", "secret", `{"message":"gVisor 1.0 is out","link":"/blog/"}`); w.Code != http.StatusOK {
+		t.Fatalf("POST: got status %d, want 200: %s", w.Code, w.Body)
+	}
+	w := do("GET", "secret", "")
+	var got announcement
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Message != "gVisor 1.0 is out" || got.Severity != severityInfo || got.Link != "/blog/" {
+		t.Errorf("GET: got announcement %+v, want the one set with the default severity", got)
+	}
+
+	if w := do("DELETE", "secret", ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE: got status %d, want 200", w.Code)
+	}
+	if w := do("GET", "secret", ""); w.Code != http.StatusNotFound || banner.get() != nil {
+		t.Errorf("GET after DELETE: got status %d, want 404", w.Code)
+	}
+}
+
+func TestBannerRule(t *testing.T) {
+	ctx := context.Background()
+	banner, err := newAnnouncements(ctx, "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule := bannerRule(banner)
+	if err := rule.validate(); err != nil {
+		t.Fatal(err)
+	}
+	defer func(rules []*rewriteRule) { rewriteRules = rules }(rewriteRules)
+	rewriteRules = []*rewriteRule{rule}
+	const page = "<html><body><p>docs</p></body></html>"
+	h := rewriteHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	}))
+	get := func() string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/docs/", nil))
+		return w.Body.String()
+	}
+
+	if body := get(); body != page {
+		t.Errorf("got page %q without an announcement, want it unchanged", body)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	if err := banner.set(ctx, &announcement{Message: "Patch <now>", Severity: severityCritical, Link: "/blog/cve/", Expires: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	body := get()
+	for _, want := range []string{
+		`class="site-announcement site-announcement-critical" role="alert"`,
+		"Patch &lt;now&gt;",
+		`<a href="/blog/cve/"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page %q does not contain %q", body, want)
+		}
+	}
+	if !strings.HasSuffix(body, "</div>\n</body></html>") {
+		t.Errorf("page %q doesn't have the banner before </body>", body)
+	}
+
+	if err := banner.set(ctx, &announcement{Message: "Release", Severity: severityInfo}); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(); !strings.Contains(body, `role="status"`) || strings.Contains(body, "<a ") {
+		t.Errorf("got page %q, want an info banner without a link", body)
+	}
+
+	expires = time.Now().Add(-time.Minute)
+	if err := banner.set(ctx, &announcement{Message: "Old news", Expires: &expires}); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(); body != page {
+		t.Errorf("got page %q with an expired announcement, want it unchanged", body)
+	}
+}
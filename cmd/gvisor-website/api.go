@@ -0,0 +1,361 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// apiVersion is the current API version, served under apiPrefix.
+	apiVersion = 1
+	apiPrefix  = "/api/v1/"
+
+	// apiVersionHeader is set on every API response.
+	apiVersionHeader = "Api-Version"
+)
+
+// apiMediaTypeRE matches the versioned media types clients may request with
+// Accept, e.g. application/vnd.gvisor.v1+json.
+var apiMediaTypeRE = regexp.MustCompile(`application/vnd\.gvisor\.v([0-9]+)\+json`)
+
+// requestedAPIVersions returns the API versions requested in the Accept
+// header, or none if the client accepts any version.
+func requestedAPIVersions(r *http.Request) []int {
+	var versions []int
+	for _, m := range apiMediaTypeRE.FindAllStringSubmatch(r.Header.Get("Accept"), -1) {
+		if v, err := strconv.Atoi(m[1]); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// apiHandler applies the policies common to all versioned API endpoints:
+// CORS, including preflight requests, read-only methods and version
+// negotiation. Errors are served as JSON for all paths under /api/.
+func apiHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		hdr.Set("Access-Control-Allow-Origin", "*")
+		hdr.Set("Access-Control-Expose-Headers", apiVersionHeader+", Retry-After, X-Request-Id")
+		hdr.Set(apiVersionHeader, strconv.Itoa(apiVersion))
+		addVary(hdr, "Accept")
+		switch r.Method {
+		case "GET", "HEAD":
+		case "OPTIONS":
+			hdr.Set("Allow", "GET, HEAD, OPTIONS")
+			if r.Header.Get("Access-Control-Request-Method") != "" {
+				hdr.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+				hdr.Set("Access-Control-Allow-Headers", "Accept, Content-Type")
+				hdr.Set("Access-Control-Max-Age", "86400")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			hdr.Set("Allow", "GET, HEAD, OPTIONS")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if versions := requestedAPIVersions(r); len(versions) > 0 {
+			supported := false
+			for _, v := range versions {
+				supported = supported || v == apiVersion
+			}
+			if !supported {
+				httpError(w, r, fmt.Sprintf("unsupported API version; this endpoint serves version %d", apiVersion), http.StatusNotAcceptable)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// apiChain returns the middleware applied to versioned API endpoints. A nil
+// limiter doesn't limit requests.
+func apiChain(route string, limiter *rateLimiter) chain {
+	c := baseChain(route).append(middleware{"api", apiHandler})
+	if limiter != nil {
+		c = c.append(middleware{"rate-limit", func(h http.Handler) http.Handler { return rateLimitHandler(limiter, h) }})
+	}
+	return c
+}
+
+// apiIndex is served at the API prefix.
+type apiIndex struct {
+	Version   int      `json:"version"`
+	Endpoints []string `json:"endpoints"`
+}
+
+// apiIndexHandler lists the API endpoints at the prefix, and serves not found
+// errors for unknown endpoints under it.
+func apiIndexHandler(endpoints []string) http.Handler {
+	index := apiIndex{Version: apiVersion, Endpoints: endpoints}
+	sort.Strings(index.Endpoints)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != apiPrefix {
+			httpError(w, r, "unknown API endpoint "+strings.TrimPrefix(r.URL.Path, apiPrefix), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(index)
+	})
+}
+
+// apiParam is a query parameter of an API endpoint.
+type apiParam struct {
+	name        string
+	description string
+	required    bool
+
+	// typ is the OpenAPI type of the parameter, "string", "integer" or
+	// "number".
+	typ string
+
+	// example is a valid value, used in the OpenAPI document and by the
+	// contract tests.
+	example string
+}
+
+// apiEndpoint is a read-only endpoint of the versioned API.
+type apiEndpoint struct {
+	path    string
+	route   string
+	summary string
+	params  []apiParam
+
+	// response is a value of the type encoded in successful responses, from
+	// which the OpenAPI schema is generated.
+	response interface{}
+
+	h http.Handler
+}
+
+// apiEndpoints returns the endpoints of the versioned API.
+func apiEndpoints(staticDir string, benchmarks benchmarkStore) []apiEndpoint {
+	limit := func(max int) apiParam {
+		return apiParam{name: "limit", description: fmt.Sprintf("Maximum number of results, up to %d.", max), typ: "integer", example: "5"}
+	}
+	searchParams := []apiParam{
+		{name: "q", description: "Search terms.", typ: "string", example: "gofer"},
+		limit(maxSearchLimit),
+		{name: "section", description: "Limit results to a section: " + strings.Join(searchSections, ", ") + ".", typ: "string", example: "docs"},
+		{name: "arch", description: "Limit results to pages about an architecture, " + strings.Join(searchArches, " or ") + ", or about all architectures.", typ: "string", example: "amd64"},
+		{name: "title_boost", description: fmt.Sprintf("Score of terms in titles, up to %d.", maxSearchBoost), typ: "number", example: "10"},
+		{name: "heading_boost", description: fmt.Sprintf("Score of terms in headings, up to %d.", maxSearchBoost), typ: "number", example: "5"},
+	}
+	return []apiEndpoint{
+		{
+			path:     "search",
+			route:    "search",
+			summary:  "Search the documentation.",
+			params:   searchParams,
+			response: searchResponse{},
+			h:        searchHandler(staticDir),
+		},
+		{
+			path:     "toc",
+			route:    "docs",
+			summary:  "List the headings of a page.",
+			params:   []apiParam{{name: "page", description: "Absolute path of the page.", required: true, typ: "string", example: "/docs/"}},
+			response: tocResponse{},
+			h:        tocHandler(staticDir),
+		},
+		{
+			path:     "compatibility/search",
+			route:    "docs",
+			summary:  "Search the syscall compatibility tables.",
+			params:   []apiParam{{name: "q", description: "Syscall name or number.", required: true, typ: "string", example: "read"}, limit(50)},
+			response: []syscallMatch{},
+			h:        compatSearchHandler(staticDir),
+		},
+		{
+			path:    "compatibility/integrations",
+			route:   "docs",
+			summary: "List the containerd shims and the containerd, Kubernetes and Docker versions supported by each gVisor release.",
+			params: []apiParam{
+				{name: "release", description: "Only return this release, or the newest one if \"latest\".", typ: "string", example: "latest"},
+				{name: "containerd", description: "Only return releases, and their shims, that support this containerd version.", typ: "string", example: "1.3.2"},
+				{name: "kubernetes", description: "Only return releases that support this Kubernetes version.", typ: "string", example: "1.16"},
+				{name: "docker", description: "Only return releases that support this Docker version.", typ: "string", example: "19.03.5"},
+			},
+			response: []integrationRelease{},
+			h:        integrationsHandler(staticDir),
+		},
+		{
+			path:    "runsc/flags",
+			route:   "docs",
+			summary: "List the flags of runsc.",
+			params: []apiParam{
+				{name: "q", description: "Only return flags whose name contains this.", typ: "string", example: "platform"},
+				{name: "section", description: "Only return flags in this section of the flags reference.", typ: "string", example: "Debugging flags"},
+			},
+			response: []runscFlag{},
+			h:        runscFlagsHandler(staticDir),
+		},
+		{
+			path:    "compliance/oci",
+			route:   "docs",
+			summary: "Get the OCI runtime spec compliance matrix of runsc.",
+			params: []apiParam{
+				{name: "status", description: "Only return features with this support status: supported, partial, ignored, unsupported or unknown.", typ: "string", example: "partial"},
+				{name: "section", description: "Only return features in this section of the matrix.", typ: "string", example: "Linux"},
+			},
+			response: ociCompliance{},
+			h:        ociComplianceHandler(staticDir),
+		},
+		{
+			path:    "edit-link",
+			route:   "docs",
+			summary: "Get the GitHub edit URL of the source of a page.",
+			params: []apiParam{
+				{name: "page", description: "Absolute path of the page.", required: true, typ: "string", example: "/docs/"},
+				{name: "redirect", description: "If set, redirect to the edit URL instead.", typ: "string"},
+			},
+			response: editLink{},
+			h:        editLinkHandler(),
+		},
+		{
+			path:    "blog/posts",
+			route:   "blog",
+			summary: "List the posts of the blog, newest first.",
+			params: []apiParam{
+				{name: "author", description: "Only return posts by this author, by name or as in the author's index page URL.", typ: "string", example: "ian-gudger"},
+				{name: "tag", description: "Only return posts with this tag.", typ: "string", example: "security"},
+			},
+			response: []blogPost{},
+			h:        blogPostsHandler(),
+		},
+		{
+			path:     "blog/authors",
+			route:    "blog",
+			summary:  "List the authors of the blog.",
+			response: []blogTerm{},
+			h:        blogTermsHandler(blogAuthors),
+		},
+		{
+			path:     "blog/tags",
+			route:    "blog",
+			summary:  "List the tags of the blog posts.",
+			response: []blogTerm{},
+			h:        blogTermsHandler(blogTags),
+		},
+		{
+			path:    "comments",
+			route:   "blog",
+			summary: "Get the GitHub Discussion of a blog post and its comment count, or a link to start one.",
+			params: []apiParam{
+				{name: "post", description: "URL or path of the post.", required: true, typ: "string", example: "/blog/2019/11/18/gvisor-security-basics-part-1/"},
+			},
+			response: postComments{},
+			h:        commentsHandler(),
+		},
+		{
+			path:     "git/tags",
+			route:    "git-refs",
+			summary:  "List the upstream release tags, newest first.",
+			response: []refInfo{},
+			h:        gitRefsHandler("refs/tags/"),
+		},
+		{
+			path:     "git/branches",
+			route:    "git-refs",
+			summary:  "List the upstream branches.",
+			response: []refInfo{},
+			h:        gitRefsHandler("refs/heads/"),
+		},
+		{
+			path:     "status",
+			route:    "status",
+			summary:  "Get the status of the site builds and upstream CI.",
+			response: siteStatus{},
+			h:        apiStatusHandler(),
+		},
+		{
+			path:     "rebuild/history",
+			route:    "status",
+			summary:  "List the recent site builds.",
+			params:   []apiParam{limit(50)},
+			response: rebuildHistory{},
+			h:        rebuildHistoryHandler(),
+		},
+		{
+			path:     "version",
+			route:    "health",
+			summary:  "Get the version serving requests and the state of its listeners.",
+			response: versionResponse{},
+			h:        versionHandler(),
+		},
+		{
+			path:     "slo",
+			route:    "status",
+			summary:  "Get the state of the service level objectives over their window.",
+			response: []sloStatus{},
+			h:        sloHandler(),
+		},
+		{
+			path:    "benchmarks",
+			route:   "benchmarks",
+			summary: "Get benchmark time series.",
+			params: []apiParam{
+				{name: "suite", description: "Only return series of this suite.", typ: "string", example: "startup"},
+				{name: "metric", description: "Only return series of this metric.", typ: "string", example: "latency"},
+				limit(maxBenchmarkLimit),
+			},
+			response: []benchmarkSeries{},
+			h:        benchmarksHandler(benchmarks),
+		},
+	}
+}
+
+// apiRouteSubsystems are the flags enabling the subsystems that API endpoints
+// belong to, by route. Endpoints of disabled subsystems are not served.
+var apiRouteSubsystems = map[string]*bool{
+	"git-refs":   enableGitProxy,
+	"status":     enableStatus,
+	"benchmarks": enableBenchmarks,
+}
+
+// registerAPI registers the versioned API and its OpenAPI document. The
+// unversioned endpoints under /api/ continue to be served for existing
+// clients.
+func registerAPI(mux *http.ServeMux, staticDir string, benchmarks benchmarkStore) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	var limiter *rateLimiter
+	if *apiRate > 0 {
+		limiter = newRateLimiter(*apiRate, *apiRate)
+	}
+	var api []apiEndpoint
+	for _, e := range apiEndpoints(staticDir, benchmarks) {
+		if enabled, ok := apiRouteSubsystems[e.route]; !ok || *enabled {
+			api = append(api, e)
+		}
+	}
+	var endpoints []string
+	for _, e := range api {
+		mux.Handle(apiPrefix+e.path, apiChain(e.route, limiter).then(e.h))
+		endpoints = append(endpoints, apiPrefix+e.path)
+	}
+	mux.Handle(apiPrefix, apiChain("api", limiter).then(apiIndexHandler(endpoints)))
+	mux.Handle(openAPIPath, apiChain("api", limiter).then(openAPIHandler(api)))
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAPIHandler(t *testing.T) {
+	h := apiHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	for _, tc := range []struct {
+		name      string
+		method    string
+		header    http.Header
+		wantCode  int
+		wantAllow string
+		wantBody  string
+	}{
+		{name: "get", method: "GET", wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "head", method: "HEAD", wantCode: http.StatusOK},
+		{name: "post", method: "POST", wantCode: http.StatusMethodNotAllowed},
+		{name: "options", method: "OPTIONS", wantCode: http.StatusNoContent},
+		{
+			name:      "preflight",
+			method:    "OPTIONS",
+			header:    http.Header{"Origin": {"https://example.com"}, "Access-Control-Request-Method": {"GET"}},
+			wantCode:  http.StatusNoContent,
+			wantAllow: "GET, HEAD, OPTIONS",
+		},
+		{name: "any version", method: "GET", header: http.Header{"Accept": {"application/json"}}, wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "current version", method: "GET", header: http.Header{"Accept": {"application/vnd.gvisor.v1+json"}}, wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "one of several versions", method: "GET", header: http.Header{"Accept": {"application/vnd.gvisor.v2+json, application/vnd.gvisor.v1+json;q=0.5"}}, wantCode: http.StatusOK, wantBody: "ok"},
+		{name: "unsupported version", method: "GET", header: http.Header{"Accept": {"application/vnd.gvisor.v2+json"}}, wantCode: http.StatusNotAcceptable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, apiPrefix+"search", nil)
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.wantCode {
+				t.Errorf("got status %d, want %d", rec.Code, tc.wantCode)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+				t.Errorf("got Access-Control-Allow-Origin %q, want *", got)
+			}
+			if got := rec.Header().Get(apiVersionHeader); got != "1" {
+				t.Errorf("got %s %q, want 1", apiVersionHeader, got)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tc.wantAllow {
+				t.Errorf("got Access-Control-Allow-Methods %q, want %q", got, tc.wantAllow)
+			}
+			if tc.wantBody != "" && rec.Body.String() != tc.wantBody {
+				t.Errorf("got body %q, want %q", rec.Body.String(), tc.wantBody)
+			}
+		})
+	}
+}
+
+func TestAPIIndexHandler(t *testing.T) {
+	h := apiIndexHandler([]string{apiPrefix + "toc", apiPrefix + "search"})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", apiPrefix, nil))
+	var got apiIndex
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid index %q: %v", rec.Body.String(), err)
+	}
+	want := apiIndex{Version: 1, Endpoints: []string{apiPrefix + "search", apiPrefix + "toc"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got index %+v, want %+v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", apiPrefix+"releases", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown endpoint, want %d", rec.Code, http.StatusNotFound)
+	}
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error.Code != http.StatusNotFound {
+		t.Errorf("got error body %q, want a JSON error", rec.Body.String())
+	}
+}
+
+func TestEndToEndAPI(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	resp, body := s.do(t, "GET", apiPrefix+"git/branches", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(apiVersionHeader) != "1" {
+		t.Errorf("GET %sgit/branches: got status %d, %s %q, body %q", apiPrefix, resp.StatusCode, apiVersionHeader, resp.Header.Get(apiVersionHeader), body)
+	}
+	// Publishing benchmarks is not part of the read-only API.
+	if resp, _ := s.do(t, "POST", apiPrefix+"benchmarks", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST %sbenchmarks: got status %d, want %d", apiPrefix, resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+	// Unversioned endpoints are still served.
+	if resp, _ := s.do(t, "GET", "/api/git/tags", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/git/tags: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
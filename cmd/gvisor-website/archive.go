@@ -0,0 +1,101 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// codeloadURL is the GitHub archive download URL for the gVisor repository.
+const codeloadURL = "https://codeload.github.com/google/gvisor/"
+
+// validRef matches branch and tag names that may be requested as archives.
+var validRef = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// archiveFormats maps archive suffixes to codeload formats and content types.
+var archiveFormats = map[string]struct {
+	format      string
+	contentType string
+}{
+	".tar.gz": {"tar.gz", "application/gzip"},
+	".zip":    {"zip", "application/zip"},
+}
+
+// archiveHandler returns a handler for /<prefix>/<ref>.tar.gz and
+// /<prefix>/<ref>.zip. By default clients are redirected to GitHub; if
+// archive proxying is enabled, the archive is streamed through instead.
+func archiveHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		var (
+			ref         string
+			format      string
+			contentType string
+		)
+		for suffix, f := range archiveFormats {
+			if strings.HasSuffix(name, suffix) {
+				ref = strings.TrimSuffix(name, suffix)
+				format, contentType = f.format, f.contentType
+				break
+			}
+		}
+		if ref == "" || !validRef.MatchString(ref) || strings.Contains(ref, "..") {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		target := codeloadURL + format + "/" + ref
+		if !*archiveProxy {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+
+		req, err := http.NewRequest(r.Method, target, nil)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp, err := upstreamClient("github").Do(req.WithContext(r.Context()))
+		if err != nil {
+			httpError(w, r, "upstream error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			httpError(w, r, "upstream error: "+resp.Status, http.StatusBadGateway)
+			return
+		}
+		hdr := w.Header()
+		hdr.Set("Content-Type", contentType)
+		hdr.Set("Content-Disposition", `attachment; filename="gvisor-`+strings.Replace(ref, "/", "-", -1)+`.`+format+`"`)
+		if cl := resp.Header.Get("Content-Length"); cl != "" {
+			hdr.Set("Content-Length", cl)
+		}
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			hdr.Set("ETag", etag)
+		}
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Printf("Error streaming archive %q: %v", ref, err)
+		}
+	})
+}
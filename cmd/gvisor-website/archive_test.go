@@ -0,0 +1,111 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestArchiveRedirect(t *testing.T) {
+	defer func(proxy bool) { *archiveProxy = proxy }(*archiveProxy)
+	*archiveProxy = false
+	h := archiveHandler("/gvisor/archive/")
+	for _, tc := range []struct {
+		path   string
+		code   int
+		target string
+	}{
+		{"/gvisor/archive/master.tar.gz", http.StatusFound, codeloadURL + "tar.gz/master"},
+		{"/gvisor/archive/release-20190806.1.zip", http.StatusFound, codeloadURL + "zip/release-20190806.1"},
+		{"/gvisor/archive/feature/x.zip", http.StatusFound, codeloadURL + "zip/feature/x"},
+		{"/gvisor/archive/master.tar.bz2", http.StatusNotFound, ""},
+		{"/gvisor/archive/.tar.gz", http.StatusNotFound, ""},
+		{"/gvisor/archive/-rf.zip", http.StatusNotFound, ""},
+		{"/gvisor/archive/a/../b.zip", http.StatusNotFound, ""},
+		{"/gvisor/archive/a%20b.zip", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code || w.Header().Get("Location") != tc.target {
+			t.Errorf("GET %s: got status %d and Location %q, want %d and %q", tc.path, w.Code, w.Header().Get("Location"), tc.code, tc.target)
+		}
+	}
+}
+
+func TestArchiveProxy(t *testing.T) {
+	defer func(proxy bool) { *archiveProxy = proxy }(*archiveProxy)
+	*archiveProxy = true
+	var requested []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		switch r.URL.Path {
+		case "/google/gvisor/tar.gz/master":
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Header().Set("ETag", `"abc"`)
+			io.WriteString(w, "archive")
+		case "/google/gvisor/zip/broken":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	// Archives are fetched from the test server instead of GitHub.
+	upstreamClient("github")
+	defer func(t http.RoundTripper) { upstreamTransport = t }(upstreamTransport)
+	upstreamTransport = storageTransport{srv}
+
+	h := archiveHandler("/gvisor/archive/")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/gvisor/archive/master.tar.gz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "archive" {
+		t.Fatalf("GET master.tar.gz: got status %d and body %q, want the archive", w.Code, w.Body)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":        "application/gzip",
+		"Content-Disposition": `attachment; filename="gvisor-master.tar.gz"`,
+		"Content-Length":      "7",
+		"ETag":                `"abc"`,
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("GET master.tar.gz: got %s %q, want %q", name, got, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		path string
+		code int
+	}{
+		{"/gvisor/archive/missing.zip", http.StatusNotFound},
+		{"/gvisor/archive/broken.zip", http.StatusBadGateway},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("GET %s: got status %d, want %d", tc.path, w.Code, tc.code)
+		}
+	}
+
+	// Invalid refs aren't requested upstream.
+	requested = nil
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/gvisor/archive/a/../b.zip", nil))
+	if w.Code != http.StatusNotFound || len(requested) != 0 {
+		t.Errorf("GET of an invalid ref: got status %d and upstream requests %q, want 404 and none", w.Code, requested)
+	}
+}
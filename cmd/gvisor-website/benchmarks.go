@@ -0,0 +1,283 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxBenchmarkBody bounds the size of a single benchmark upload.
+const maxBenchmarkBody = 1 << 20
+
+// Limits on the results a benchmark query returns.
+const (
+	defaultBenchmarkLimit = 1000
+	maxBenchmarkLimit     = 10000
+)
+
+// benchmarkResult is a single benchmark measurement published by CI.
+type benchmarkResult struct {
+	Suite  string    `json:"suite"`
+	Metric string    `json:"metric"`
+	Value  float64   `json:"value"`
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+}
+
+// validate checks that all required fields are set.
+func (b *benchmarkResult) validate() error {
+	if b.Suite == "" || b.Metric == "" || b.Commit == "" {
+		return fmt.Errorf("suite, metric and commit are required")
+	}
+	return nil
+}
+
+// benchmarkStore persists benchmark results.
+type benchmarkStore interface {
+	// add stores the given results.
+	add(ctx context.Context, results []benchmarkResult) error
+
+	// query returns up to limit results for the given suite and metric.
+	// If both are empty, results of all series are returned.
+	query(ctx context.Context, suite, metric string, limit int) ([]benchmarkResult, error)
+}
+
+// newBenchmarkStore returns a store of the given type, which is either
+// "memory" or "firestore".
+func newBenchmarkStore(ctx context.Context, store string) (benchmarkStore, error) {
+	switch store {
+	case "", "memory":
+		return &memoryBenchmarks{}, nil
+	case "firestore":
+		fs, err := newFirestoreClient(ctx, *projectId)
+		if err != nil {
+			return nil, err
+		}
+		return &firestoreBenchmarks{fs: fs}, nil
+	default:
+		return nil, fmt.Errorf("unknown benchmark store %q", store)
+	}
+}
+
+// memoryBenchmarks keeps benchmark results in memory. Results are lost on
+// restart, so this is only suitable for development.
+type memoryBenchmarks struct {
+	mu      sync.Mutex
+	results []benchmarkResult
+}
+
+func (m *memoryBenchmarks) add(ctx context.Context, results []benchmarkResult) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results = append(m.results, results...)
+	return nil
+}
+
+// query returns the latest added results.
+func (m *memoryBenchmarks) query(ctx context.Context, suite, metric string, limit int) ([]benchmarkResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var results []benchmarkResult
+	for i := len(m.results) - 1; i >= 0 && len(results) < limit; i-- {
+		if r := m.results[i]; (suite == "" || r.Suite == suite) && (metric == "" || r.Metric == metric) {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// benchmarkCollection is the Firestore collection holding benchmark results.
+const benchmarkCollection = "benchmarks"
+
+// firestoreBenchmarks stores benchmark results in Firestore, one document per
+// result.
+type firestoreBenchmarks struct {
+	fs *firestoreClient
+}
+
+func (f *firestoreBenchmarks) add(ctx context.Context, results []benchmarkResult) error {
+	for _, r := range results {
+		if err := f.fs.create(ctx, benchmarkCollection, map[string]firestoreValue{
+			"suite":  stringValue(r.Suite),
+			"metric": stringValue(r.Metric),
+			"value":  doubleValue(r.Value),
+			"commit": stringValue(r.Commit),
+			"time":   timestampValue(r.Time),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *firestoreBenchmarks) query(ctx context.Context, suite, metric string, limit int) ([]benchmarkResult, error) {
+	equal := make(map[string]string)
+	if suite != "" {
+		equal["suite"] = suite
+	}
+	if metric != "" {
+		equal["metric"] = metric
+	}
+	docs, err := f.fs.where(ctx, benchmarkCollection, equal, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]benchmarkResult, 0, len(docs))
+	for _, doc := range docs {
+		results = append(results, benchmarkResult{
+			Suite:  doc.Fields["suite"].str(),
+			Metric: doc.Fields["metric"].str(),
+			Value:  doc.Fields["value"].float(),
+			Commit: doc.Fields["commit"].str(),
+			Time:   doc.Fields["time"].time(),
+		})
+	}
+	return results, nil
+}
+
+// benchmarkPoint is a single point in a benchmark time series.
+type benchmarkPoint struct {
+	Commit string    `json:"commit"`
+	Value  float64   `json:"value"`
+	Time   time.Time `json:"time"`
+}
+
+// benchmarkSeries is the time series for a single suite and metric.
+type benchmarkSeries struct {
+	Suite  string           `json:"suite"`
+	Metric string           `json:"metric"`
+	Points []benchmarkPoint `json:"points"`
+}
+
+// toSeries groups results into time series ordered by suite and metric, with
+// points in chronological order.
+func toSeries(results []benchmarkResult) []benchmarkSeries {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Time.Before(results[j].Time)
+	})
+	index := make(map[[2]string]int)
+	var series []benchmarkSeries
+	for _, r := range results {
+		key := [2]string{r.Suite, r.Metric}
+		i, ok := index[key]
+		if !ok {
+			i = len(series)
+			index[key] = i
+			series = append(series, benchmarkSeries{Suite: r.Suite, Metric: r.Metric})
+		}
+		series[i].Points = append(series[i].Points, benchmarkPoint{Commit: r.Commit, Value: r.Value, Time: r.Time})
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Suite != series[j].Suite {
+			return series[i].Suite < series[j].Suite
+		}
+		return series[i].Metric < series[j].Metric
+	})
+	return series
+}
+
+// decodeBenchmarkResults decodes either a single result or a list of results.
+func decodeBenchmarkResults(body []byte) ([]benchmarkResult, error) {
+	body = bytes.TrimSpace(body)
+	var results []benchmarkResult
+	if len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &results); err != nil {
+			return nil, err
+		}
+	} else {
+		var r benchmarkResult
+		if err := json.Unmarshal(body, &r); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// benchmarksHandler returns a handler for publishing results (POST, requires
+// the benchmark token) and fetching time series (GET, optionally filtered by
+// the suite and metric query parameters, and of up to limit results). Store
+// errors are only logged, since they carry the paths of the store.
+func benchmarksHandler(store benchmarkStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD":
+			q := r.URL.Query()
+			limit := defaultBenchmarkLimit
+			if l := q.Get("limit"); l != "" {
+				n, err := strconv.Atoi(l)
+				if err != nil || n < 1 || n > maxBenchmarkLimit {
+					httpError(w, r, fmt.Sprintf("invalid request: limit must be between 1 and %d", maxBenchmarkLimit), http.StatusBadRequest)
+					return
+				}
+				limit = n
+			}
+			results, err := store.query(r.Context(), q.Get("suite"), q.Get("metric"), limit)
+			if err != nil {
+				log.Printf("Error querying benchmark results: %v", err)
+				httpError(w, r, "benchmark results are unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "public, max-age=300")
+			json.NewEncoder(w).Encode(toSeries(results))
+		case "POST":
+			if !hasBearerToken(r, *benchmarkToken) {
+				httpError(w, r, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBenchmarkBody))
+			if err != nil {
+				httpError(w, r, "read error: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			results, err := decodeBenchmarkResults(body)
+			if err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			now := time.Now()
+			for i := range results {
+				if err := results[i].validate(); err != nil {
+					httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				if results[i].Time.IsZero() {
+					results[i].Time = now
+				}
+			}
+			if err := store.add(r.Context(), results); err != nil {
+				log.Printf("Error storing benchmark results: %v", err)
+				httpError(w, r, "benchmark results are unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
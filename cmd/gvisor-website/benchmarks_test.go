@@ -0,0 +1,128 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBenchmarks is a benchmark store recording its queries.
+type fakeBenchmarks struct {
+	memoryBenchmarks
+	err       error
+	lastLimit int
+}
+
+func (f *fakeBenchmarks) add(ctx context.Context, results []benchmarkResult) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.memoryBenchmarks.add(ctx, results)
+}
+
+func (f *fakeBenchmarks) query(ctx context.Context, suite, metric string, limit int) ([]benchmarkResult, error) {
+	f.lastLimit = limit
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.memoryBenchmarks.query(ctx, suite, metric, limit)
+}
+
+func TestBenchmarksHandler(t *testing.T) {
+	defer func(token string) { *benchmarkToken = token }(*benchmarkToken)
+	*benchmarkToken = "secret"
+	store := &fakeBenchmarks{}
+	h := benchmarksHandler(store)
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("POST", "/benchmarks", "", `{"suite":"startup","metric":"latency","value":1,"commit":"abc"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST without token: got status %d, want 401", w.Code)
+	}
+	if w := do("POST", "/benchmarks", "secret", `{"suite":"startup","value":1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("POST of an invalid result: got status %d, want 400", w.Code)
+	}
+	if w := do("POST", "/benchmarks", "secret", `[{"suite":"startup","metric":"latency","value":2,"commit":"def","time":"2019-10-02T00:00:00Z"},{"suite":"startup","metric":"latency","value":1,"commit":"abc","time":"2019-10-01T00:00:00Z"},{"suite":"network","metric":"throughput","value":3,"commit":"abc"}]`); w.Code != http.StatusCreated {
+		t.Fatalf("POST: got status %d, want 201: %s", w.Code, w.Body)
+	}
+	if r := store.results[2]; r.Time.IsZero() {
+		t.Errorf("result without a time stored with a zero time")
+	}
+
+	w := do("GET", "/benchmarks?suite=startup", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET: got status %d, want 200", w.Code)
+	}
+	var series []benchmarkSeries
+	if err := json.NewDecoder(w.Body).Decode(&series); err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || len(series[0].Points) != 2 || series[0].Points[0].Commit != "abc" {
+		t.Errorf("GET: got series %+v, want the startup points in time order", series)
+	}
+	if store.lastLimit != defaultBenchmarkLimit {
+		t.Errorf("GET: queried %d results, want %d", store.lastLimit, defaultBenchmarkLimit)
+	}
+	if w := do("GET", "/benchmarks?limit=5", "", ""); w.Code != http.StatusOK || store.lastLimit != 5 {
+		t.Errorf("GET with limit=5: got status %d and limit %d, want 200 and 5", w.Code, store.lastLimit)
+	}
+	for _, l := range []string{"0", "10001", "all"} {
+		if w := do("GET", "/benchmarks?limit="+l, "", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET with limit=%s: got status %d, want 400", l, w.Code)
+		}
+	}
+	if w := do("PUT", "/benchmarks", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: got status %d, want 405", w.Code)
+	}
+
+	// Store errors aren't disclosed.
+	store.err = errors.New("firestore POST projects/gvisor-website/databases/(default)/documents:runQuery: 500")
+	for _, method := range []string{"GET", "POST"} {
+		w := do(method, "/benchmarks", "secret", `{"suite":"startup","metric":"latency","value":1,"commit":"abc"}`)
+		if w.Code != http.StatusServiceUnavailable || strings.Contains(w.Body.String(), "projects/") {
+			t.Errorf("%s with a store error: got status %d and body %q, want 503 without the error", method, w.Code, w.Body)
+		}
+	}
+}
+
+func TestMemoryBenchmarksLimit(t *testing.T) {
+	m := &memoryBenchmarks{}
+	start := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		m.add(context.Background(), []benchmarkResult{{Suite: "startup", Metric: "latency", Value: float64(i), Commit: "abc", Time: start.Add(time.Duration(i) * time.Hour)}})
+	}
+	m.add(context.Background(), []benchmarkResult{{Suite: "network", Metric: "throughput", Commit: "abc", Time: start}})
+	results, err := m.query(context.Background(), "startup", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Value != 4 || results[1].Value != 3 {
+		t.Errorf("got results %+v, want the latest two startup results", results)
+	}
+}
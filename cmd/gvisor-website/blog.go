@@ -0,0 +1,306 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	// blogAuthorsPrefix and blogTagsPrefix are the paths of the author and
+	// tag index pages of the blog.
+	blogAuthorsPrefix = "/blog/authors/"
+	blogTagsPrefix    = "/blog/tags/"
+)
+
+// blogPost is a post of the blog, as described by its front matter.
+type blogPost struct {
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Date        time.Time `json:"date"`
+	Description string    `json:"description,omitempty"`
+	Authors     []string  `json:"authors,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+
+	// src is the Markdown source of the post.
+	src string
+
+	// discussion is the number of the GitHub Discussion of the post, if
+	// set in its front matter.
+	discussion int
+}
+
+// blogTerm is an author or tag of the blog.
+type blogTerm struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Posts int    `json:"posts"`
+}
+
+// blogSlug returns the URL path element for the given title or name, as
+// Hugo's urlize: lowercased, with runs of other characters than letters and
+// digits replaced by a dash.
+func blogSlug(s string) string {
+	return strings.Trim(strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "-"), "-")
+}
+
+// authorSeparatorRE matches the separators of the authors of a post, which
+// are given as a single name, e.g. "Jeremiah Spradlin & Zach Koopmans".
+var authorSeparatorRE = regexp.MustCompile(`\s*(?:,|&|\band\b)\s*`)
+
+// parseBlogPost returns the post with the given Markdown source, or false if
+// it has no title or date. Its URL is derived as by the blog's permalink
+// pattern in config.toml, /:section/:year/:month/:day/:slug/.
+func parseBlogPost(src string, b []byte) (blogPost, bool) {
+	fm := frontMatter(b)
+	first := func(key string) string {
+		if v := fm[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	p := blogPost{
+		Title:       first("title"),
+		Date:        frontMatterDate(b),
+		Description: first("description"),
+		Tags:        fm["tags"],
+		src:         src,
+	}
+	if p.Title == "" || p.Date.IsZero() {
+		return blogPost{}, false
+	}
+	for _, author := range authorSeparatorRE.Split(first("author"), -1) {
+		if author != "" {
+			p.Authors = append(p.Authors, author)
+		}
+	}
+	p.discussion, _ = strconv.Atoi(first("discussion"))
+	slug := first("slug")
+	if slug == "" {
+		slug = blogSlug(p.Title)
+	}
+	p.URL = "/blog/" + p.Date.Format("2006/01/02") + "/" + slug + "/"
+	return p, true
+}
+
+var (
+	blogPostsOnce sync.Once
+	allBlogPosts  []blogPost
+)
+
+// blogPosts returns the published posts of the blog in the content dir,
+// newest first.
+func blogPosts() []blogPost {
+	blogPostsOnce.Do(func() {
+		dir := filepath.Join(*contentDir, "blog")
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(p) != ".md" || info.Name() == "_index.md" {
+				return err
+			}
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if post, ok := parseBlogPost(p, b); ok {
+				allBlogPosts = append(allBlogPosts, post)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error reading blog posts: %v", err)
+		}
+		sort.SliceStable(allBlogPosts, func(i, j int) bool { return allBlogPosts[i].Date.After(allBlogPosts[j].Date) })
+	})
+	var posts []blogPost
+	for _, p := range allBlogPosts {
+		if !futureContent(p.src) {
+			posts = append(posts, p)
+		}
+	}
+	return posts
+}
+
+// blogTaxonomy is a way of grouping posts: by author or by tag.
+type blogTaxonomy struct {
+	prefix string
+
+	// title is the title of the index page, and termTitle the format of
+	// the title of the page of a term.
+	title     string
+	termTitle string
+
+	terms func(p blogPost) []string
+}
+
+var (
+	blogAuthors = blogTaxonomy{blogAuthorsPrefix, "Blog authors", "Posts by %s", func(p blogPost) []string { return p.Authors }}
+	blogTags    = blogTaxonomy{blogTagsPrefix, "Blog tags", "Posts tagged %s", func(p blogPost) []string { return p.Tags }}
+)
+
+// index returns the terms of the given posts, by name.
+func (t blogTaxonomy) index(posts []blogPost) []blogTerm {
+	bySlug := make(map[string]*blogTerm)
+	for _, p := range posts {
+		for _, name := range t.terms(p) {
+			slug := blogSlug(name)
+			if bySlug[slug] == nil {
+				bySlug[slug] = &blogTerm{Name: name, URL: t.prefix + slug + "/"}
+			}
+			bySlug[slug].Posts++
+		}
+	}
+	terms := []blogTerm{}
+	for _, term := range bySlug {
+		terms = append(terms, *term)
+	}
+	sort.Slice(terms, func(i, j int) bool { return strings.ToLower(terms[i].Name) < strings.ToLower(terms[j].Name) })
+	return terms
+}
+
+// posts returns the given posts with the term with the given slug, and the
+// name of the term.
+func (t blogTaxonomy) posts(posts []blogPost, slug string) ([]blogPost, string) {
+	matched := []blogPost{}
+	var name string
+	for _, p := range posts {
+		for _, n := range t.terms(p) {
+			if blogSlug(n) == slug {
+				matched = append(matched, p)
+				name = n
+				break
+			}
+		}
+	}
+	return matched, name
+}
+
+// blogIndexPage is passed to blogIndexTemplate.
+type blogIndexPage struct {
+	Title     string
+	Canonical string
+	Terms     []blogTerm
+	Posts     []blogPost
+}
+
+var blogIndexTemplate = template.Must(template.New("blog-index").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - gVisor</title>
+<link rel="canonical" href="{{.Canonical}}">
+<style>
+body { font-family: "Roboto", sans-serif; margin: 0; color: #222; line-height: 1.5; }
+header { background: #262362; color: #fff; padding: 1em 2em; font-size: 1.5em; }
+header a { color: #fff; text-decoration: none; }
+main { margin: 2em; max-width: 50em; }
+a { color: #286FD7; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<header><a href="/">gVisor</a></header>
+<main>
+<p class="meta"><a href="/blog/">Blog</a></p>
+<h1>{{.Title}}</h1>
+{{if .Terms}}<ul>
+{{range .Terms}}<li><a href="{{.URL}}">{{.Name}}</a> <span class="meta">({{.Posts}})</span></li>
+{{end}}</ul>{{end}}
+{{range .Posts}}<article>
+<h2><a href="{{.URL}}">{{.Title}}</a></h2>
+<p class="meta">{{.Date.Format "January 2, 2006"}}{{if .Authors}} by {{range $i, $a := .Authors}}{{if $i}}, {{end}}{{$a}}{{end}}{{end}}</p>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+</article>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+// blogIndexHandler serves the index page of the taxonomy at its prefix, and
+// the page listing the posts of each term at prefix/<term>/.
+func blogIndexHandler(t blogTaxonomy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, t.prefix)
+		slug := strings.TrimSuffix(rest, "/")
+		page := blogIndexPage{Title: t.title, Canonical: siteURL(r.URL.Path)}
+		posts := blogPosts()
+		switch {
+		case rest == "":
+			page.Terms = t.index(posts)
+		case strings.Contains(slug, "/") || slug != blogSlug(slug):
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		case !strings.HasSuffix(rest, "/"):
+			http.Redirect(w, r, t.prefix+slug+"/", http.StatusMovedPermanently)
+			return
+		default:
+			var name string
+			page.Posts, name = t.posts(posts, slug)
+			if len(page.Posts) == 0 {
+				httpError(w, r, "Not found", http.StatusNotFound)
+				return
+			}
+			page.Title = strings.Replace(t.termTitle, "%s", name, 1)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := blogIndexTemplate.Execute(w, page); err != nil {
+			log.Printf("Error rendering %s: %v", r.URL.Path, err)
+		}
+	})
+}
+
+// blogPostsHandler serves the posts of the blog as JSON, optionally only
+// those of the author and tag given by their slug.
+func blogPostsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts := blogPosts()
+		if author := r.FormValue("author"); author != "" {
+			posts, _ = blogAuthors.posts(posts, blogSlug(author))
+		}
+		if tag := r.FormValue("tag"); tag != "" {
+			posts, _ = blogTags.posts(posts, blogSlug(tag))
+		}
+		if posts == nil {
+			posts = []blogPost{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(posts)
+	})
+}
+
+// blogTermsHandler serves the terms of the taxonomy as JSON.
+func blogTermsHandler(t blogTaxonomy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.index(blogPosts()))
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseBlogPost(t *testing.T) {
+	src := "---\ndate: 2019-11-18\ntitle: \"gVisor Security Basics - Part 1\"\nlinkTitle: \"gVisor Security Basics - Part 1\"\ndescription: \"\"\nauthor: Jeremiah Spradlin & Zach Koopmans\ntags:\n  - security\n  - Design Principles\n---\n\n# Part 1\n"
+	p, ok := parseBlogPost("content/blog/1_security_basics/index.md", []byte(src))
+	if !ok {
+		t.Fatalf("parseBlogPost failed")
+	}
+	if want := "/blog/2019/11/18/gvisor-security-basics-part-1/"; p.URL != want {
+		t.Errorf("got URL %q, want %q", p.URL, want)
+	}
+	if len(p.Authors) != 2 || p.Authors[0] != "Jeremiah Spradlin" || p.Authors[1] != "Zach Koopmans" {
+		t.Errorf("got authors %q, want both authors", p.Authors)
+	}
+	if len(p.Tags) != 2 || p.Tags[1] != "Design Principles" {
+		t.Errorf("got tags %q", p.Tags)
+	}
+	if _, ok := parseBlogPost("content/blog/_draft.md", []byte("---\ntitle: Undated\n---\n")); ok {
+		t.Errorf("parseBlogPost of an undated post succeeded")
+	}
+}
+
+func TestBlogIndexHandler(t *testing.T) {
+	blogPostsOnce.Do(func() {})
+	defer func(p []blogPost) { allBlogPosts = p }(allBlogPosts)
+	for _, src := range []string{
+		"---\ndate: 2020-04-02\ntitle: \"gVisor Networking Security\"\nauthor: Ian Gudger\ntags: [security, networking]\n---\n",
+		"---\ndate: 2019-11-18\ntitle: \"gVisor Security Basics - Part 1\"\nauthor: Jeremiah Spradlin & Zach Koopmans\ntags: [security]\n---\n",
+	} {
+		p, _ := parseBlogPost("", []byte(src))
+		allBlogPosts = append(allBlogPosts, p)
+	}
+
+	authors, tags := blogIndexHandler(blogAuthors), blogIndexHandler(blogTags)
+	for _, tc := range []struct {
+		h        http.Handler
+		path     string
+		code     int
+		location string
+		body     []string
+	}{
+		{authors, "/blog/authors/", http.StatusOK, "", []string{`<a href="/blog/authors/ian-gudger/">Ian Gudger</a>`, `<a href="/blog/authors/zach-koopmans/">Zach Koopmans</a>`}},
+		{authors, "/blog/authors/zach-koopmans/", http.StatusOK, "", []string{"<h1>Posts by Zach Koopmans</h1>", `<a href="/blog/2019/11/18/gvisor-security-basics-part-1/">`}},
+		{authors, "/blog/authors/zach-koopmans", http.StatusMovedPermanently, "/blog/authors/zach-koopmans/", nil},
+		{authors, "/blog/authors/nobody/", http.StatusNotFound, "", nil},
+		{authors, "/blog/authors/Zach%20Koopmans/", http.StatusNotFound, "", nil},
+		{tags, "/blog/tags/security/", http.StatusOK, "", []string{"<h1>Posts tagged security</h1>", "gVisor Networking Security", "gVisor Security Basics"}},
+		{tags, "/blog/tags/networking/a/", http.StatusNotFound, "", nil},
+	} {
+		rec := httptest.NewRecorder()
+		tc.h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.path, rec.Code, tc.code)
+			continue
+		}
+		if got := rec.Header().Get("Location"); got != tc.location {
+			t.Errorf("%s: got Location %q, want %q", tc.path, got, tc.location)
+		}
+		for _, want := range tc.body {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("%s: body doesn't contain %q:\n%s", tc.path, want, rec.Body.String())
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	blogPostsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/blog/posts?tag=networking&author=Ian+Gudger", nil))
+	var posts []blogPost
+	if err := json.Unmarshal(rec.Body.Bytes(), &posts); err != nil || len(posts) != 1 || posts[0].Title != "gVisor Networking Security" {
+		t.Errorf("got posts %s, want the networking post", rec.Body.String())
+	}
+}
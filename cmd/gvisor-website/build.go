@@ -0,0 +1,242 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// buildConfig configures the build subcommand.
+type buildConfig struct {
+	// src is the website repository.
+	src string
+
+	// out is the static dir written, and contentOut the dir the Markdown
+	// sources are copied to.
+	out        string
+	contentOut string
+
+	// versionOut is the marker file the content version of the static
+	// dir is written to, outside the static dir so that it isn't served.
+	versionOut string
+
+	// manifestOut is the integrity manifest of the static dir, which
+	// the deploy signs.
+	manifestOut string
+
+	// gvisorRepo and gvisorBranch are cloned to upstream/gvisor in src if
+	// it doesn't exist, for generating the compatibility docs.
+	gvisorRepo   string
+	gvisorBranch string
+
+	// g3docDirs are the dirs of the docs in the gVisor repository synced
+	// into the content, as src=dest pairs. g3docBranch is cloned to
+	// upstream/gvisor-g3doc for them, since the go branch has no docs.
+	g3docDirs       string
+	g3docBranch     string
+	g3docVersionOut string
+
+	hugo    string
+	baseURL string
+	minify  bool
+}
+
+// buildStep is a step of the site build.
+type buildStep struct {
+	name string
+	run  func(c *buildConfig) error
+}
+
+// buildSteps are the steps of the site build, in order.
+var buildSteps = []buildStep{
+	{"upstream", fetchUpstream},
+	{"g3doc", syncG3doc},
+	{"compatibility-docs", generateCompatibilityDocs},
+	{"reference-docs", generateReferenceDocs},
+	{"node-modules", installNodeModules},
+	{"hugo", runHugo},
+	{"sitemaps", func(c *buildConfig) error { return writeSitemaps(c.path(c.out)) }},
+	{"content-sources", copyContentSources},
+	{"minify", minifyStaticDir},
+	{"content-version", func(c *buildConfig) error { return writeContentVersion(c.path(c.out), c.path(c.versionOut)) }},
+	{"manifest", func(c *buildConfig) error { return writeIntegrityManifest(c.path(c.out), c.path(c.manifestOut)) }},
+}
+
+// runBuild runs the build subcommand with the given arguments. It builds the
+// static dir and the Markdown sources that the server serves, replacing the
+// Makefile's website target.
+func runBuild(args []string) error {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	c := &buildConfig{}
+	fs.StringVar(&c.src, "src", ".", "Website repository to build.")
+	fs.StringVar(&c.out, "out", "public/static", "Static dir to write, relative to -src.")
+	fs.StringVar(&c.contentOut, "content-out", "public/content", "Dir to copy the Markdown sources to, relative to -src.")
+	fs.StringVar(&c.versionOut, "version-out", "public/content-version", "Marker file the content version of the static dir is written to, relative to -src.")
+	fs.StringVar(&c.manifestOut, "manifest-out", "public/static-manifest.json", "Integrity manifest of the static dir to write for signing, relative to -src.")
+	fs.StringVar(&c.gvisorRepo, "gvisor-repo", "https://github.com/google/gvisor.git", "gVisor repository the compatibility docs are generated from.")
+	fs.StringVar(&c.gvisorBranch, "gvisor-branch", "go", "Branch of the gVisor repository to clone.")
+	fs.StringVar(&c.g3docDirs, "g3doc-dirs", "", "Comma-separated dirs of the gVisor docs to sync into the content, as src=dest pairs relative to g3doc and content, e.g. architecture_guide=docs/architecture_guide.")
+	fs.StringVar(&c.g3docBranch, "g3doc-branch", "master", "Branch of the gVisor repository the docs are synced from.")
+	fs.StringVar(&c.g3docVersionOut, "g3doc-version-out", "public/g3doc-version", "Marker file the version of the synced docs is written to, relative to -src.")
+	fs.StringVar(&c.hugo, "hugo", "hugo", "Hugo binary.")
+	fs.StringVar(&c.baseURL, "base-url", "", "Base URL of the site, e.g. for staging; defaults to baseURL in config.toml.")
+	fs.BoolVar(&c.minify, "minify", true, "Minify HTML, CSS and JS in the static dir.")
+	fs.Parse(args)
+
+	for _, step := range buildSteps {
+		start := time.Now()
+		log.Printf("Build step %s...", step.name)
+		if err := step.run(c); err != nil {
+			return fmt.Errorf("build step %s: %v", step.name, err)
+		}
+		log.Printf("Build step %s done in %v", step.name, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// path returns the given path relative to the repository.
+func (c *buildConfig) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.src, p)
+}
+
+// command runs the named program in the repository, with the given extra
+// environment.
+func (c *buildConfig) command(env []string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = c.src
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// fetchUpstream clones the gVisor repository, or updates an existing clone.
+func fetchUpstream(c *buildConfig) error {
+	dir := c.path("upstream/gvisor")
+	if _, err := os.Stat(dir); err == nil {
+		return c.command(nil, "git", "-C", dir, "pull", "--ff-only")
+	}
+	return c.command(nil, "git", "clone", "--branch", c.gvisorBranch, "--depth", "1", c.gvisorRepo, dir)
+}
+
+// generateCompatibilityDocs generates the compatibility docs and data from
+// the syscall tables in the gVisor repository.
+func generateCompatibilityDocs(c *buildConfig) error {
+	return c.command([]string{"GO111MODULE=on"}, "go", "run", "gvisor.dev/website/cmd/generate-syscall-docs",
+		"-src", "upstream/gvisor",
+		"-out", "content/docs/user_guide/compatibility/",
+		"-json", "static/compatibility.json")
+}
+
+// generateReferenceDocs generates the reference docs of gVisor's Go packages
+// from the gVisor repository.
+func generateReferenceDocs(c *buildConfig) error {
+	return c.command([]string{"GO111MODULE=on"}, "go", "run", "gvisor.dev/website/cmd/generate-reference-docs",
+		"-src", "upstream/gvisor",
+		"-out", "content/docs/reference/",
+		"-flags-json", "static/runsc-flags.json",
+		"-oci-json", "static/oci-compliance.json")
+}
+
+// installNodeModules installs the npm dependencies of the SCSS pipeline,
+// unless they are already installed.
+func installNodeModules(c *buildConfig) error {
+	if _, err := os.Stat(c.path("node_modules")); err == nil {
+		return nil
+	}
+	return c.command(nil, "npm", "ci")
+}
+
+// runHugo renders the site into the static dir.
+func runHugo(c *buildConfig) error {
+	args := []string{"--destination", c.path(c.out)}
+	if c.baseURL != "" {
+		args = append(args, "--baseURL", c.baseURL)
+	}
+	return c.command([]string{"HUGO_ENV=production"}, c.hugo, args...)
+}
+
+// copyContentSources copies the Markdown sources, which are served for docs
+// pages on request.
+func copyContentSources(c *buildConfig) error {
+	content, out := c.path("content"), c.path(c.contentOut)
+	if err := os.RemoveAll(out); err != nil {
+		return err
+	}
+	return filepath.Walk(content, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(p) != ".md" {
+			return err
+		}
+		rel, err := filepath.Rel(content, p)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(out, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dst, b, 0644)
+	})
+}
+
+// minifyStaticDir minifies the files in the static dir in place, so that the
+// server doesn't need to with --minify-static. Asset fingerprints are taken
+// from the static manifest when the server starts, and so match the minified
+// files.
+func minifyStaticDir(c *buildConfig) error {
+	if !c.minify {
+		return nil
+	}
+	var before, after int64
+	err := filepath.Walk(c.path(c.out), func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		minify, ok := minifiers[filepath.Ext(p)]
+		if !ok {
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		m := minify(b)
+		before += int64(len(b))
+		after += int64(len(m))
+		return ioutil.WriteFile(p, m, info.Mode())
+	})
+	if err == nil {
+		log.Printf("Minified %d bytes to %d bytes", before, after)
+	}
+	return err
+}
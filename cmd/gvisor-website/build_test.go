@@ -0,0 +1,115 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes the given files, keyed by slash-separated path, to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+}
+
+func TestCopyContentSources(t *testing.T) {
+	src, err := ioutil.TempDir("", "build-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(src)
+	writeFiles(t, src, map[string]string{
+		"content/_index.md":                "home",
+		"content/docs/user_guide/faq.md":   "faq",
+		"content/docs/images/diagram.png":  "png",
+		"public/content/docs/removed.md":   "stale",
+		"content/blog/2019/01/01/index.md": "post",
+	})
+
+	c := &buildConfig{src: src, contentOut: "public/content"}
+	if err := copyContentSources(c); err != nil {
+		t.Fatalf("copyContentSources failed: %v", err)
+	}
+	for name, want := range map[string]string{
+		"_index.md":                "home",
+		"docs/user_guide/faq.md":   "faq",
+		"blog/2019/01/01/index.md": "post",
+		"docs/images/diagram.png":  "",
+		"docs/removed.md":          "",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(src, "public/content", filepath.FromSlash(name)))
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s was copied or kept, want it absent", name)
+			}
+			continue
+		}
+		if err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v; want %q", name, b, err, want)
+		}
+	}
+}
+
+func TestMinifyStaticDir(t *testing.T) {
+	src, err := ioutil.TempDir("", "build-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(src)
+	files := map[string]string{
+		"out/index.html":   "<html>\n  <body>\n    <p>gVisor</p>\n  </body>\n</html>\n",
+		"out/css/main.css": "body {\n  color: #222;\n}\n",
+		"out/js/main.js":   "// Comment.\nvar x = 1;\n",
+		"out/logo.svg":     "<svg>\n  <g/>\n</svg>\n",
+	}
+	writeFiles(t, src, files)
+
+	if err := minifyStaticDir(&buildConfig{src: src, out: "out", minify: false}); err != nil {
+		t.Fatalf("minifyStaticDir failed: %v", err)
+	}
+	for name, content := range files {
+		if b, _ := ioutil.ReadFile(filepath.Join(src, name)); string(b) != content {
+			t.Errorf("%s changed with minification disabled", name)
+		}
+	}
+
+	if err := minifyStaticDir(&buildConfig{src: src, out: "out", minify: true}); err != nil {
+		t.Fatalf("minifyStaticDir failed: %v", err)
+	}
+	for name, content := range files {
+		b, err := ioutil.ReadFile(filepath.Join(src, name))
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		want := content
+		if m, ok := minifiers[filepath.Ext(name)]; ok {
+			want = string(m([]byte(content)))
+		}
+		if string(b) != want {
+			t.Errorf("%s = %q, want %q", name, b, want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/option"
+)
+
+var (
+	buildServiceMu sync.Mutex
+	buildService   *cloudbuild.Service
+	buildProject   string
+)
+
+// newCloudBuild returns a Cloud Build service and the project to use it with.
+// The service is created on first use and shared; failures are retried on
+// the next call.
+func newCloudBuild(ctx context.Context) (*cloudbuild.Service, string, error) {
+	buildServiceMu.Lock()
+	defer buildServiceMu.Unlock()
+	if buildService != nil {
+		return buildService, buildProject, nil
+	}
+	// The service outlives this request, so it must not use its context.
+	ctx = context.Background()
+	credentials, err := google.FindDefaultCredentials(ctx, cloudbuild.CloudPlatformScope)
+	if err != nil {
+		return nil, "", fmt.Errorf("credentials error: %v", err)
+	}
+	client, err := googleClient(ctx, "cloudbuild", cloudbuild.CloudPlatformScope)
+	if err != nil {
+		return nil, "", fmt.Errorf("credentials error: %v", err)
+	}
+	service, err := cloudbuild.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, "", fmt.Errorf("cloudbuild service error: %v", err)
+	}
+	projectID := credentials.ProjectID
+	if projectID == "" {
+		// If running locally, then this project will not be
+		// available. Use the default project here.
+		projectID = "gvisor-website"
+	}
+	buildService, buildProject = service, projectID
+	return buildService, buildProject, nil
+}
+
+// buildStatus is the status of a single website build.
+type buildStatus struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	Detail     string    `json:"detail,omitempty"`
+	Branch     string    `json:"branch,omitempty"`
+	Commit     string    `json:"commit,omitempty"`
+	CreateTime time.Time `json:"create_time"`
+	StartTime  time.Time `json:"start_time"`
+	FinishTime time.Time `json:"finish_time"`
+	LogURL     string    `json:"log_url,omitempty"`
+}
+
+// upstreamStatus is the combined CI state of the upstream gVisor repository.
+type upstreamStatus struct {
+	State    string          `json:"state"`
+	Commit   string          `json:"commit"`
+	Contexts []upstreamCheck `json:"contexts"`
+}
+
+// upstreamCheck is the state of a single upstream CI pipeline.
+type upstreamCheck struct {
+	Context   string `json:"context"`
+	State     string `json:"state"`
+	TargetURL string `json:"target_url,omitempty"`
+}
+
+// siteStatus is the aggregated status served by /status and /api/status.
+type siteStatus struct {
+	// Version is the App Engine version serving this request.
+	Version string `json:"version,omitempty"`
+
+	// LastSuccess is the finish time of the most recent successful build.
+	LastSuccess time.Time `json:"last_success"`
+
+	Builds   []buildStatus   `json:"builds"`
+	Upstream *upstreamStatus `json:"upstream,omitempty"`
+	Errors   []string        `json:"errors,omitempty"`
+	Updated  time.Time       `json:"updated"`
+}
+
+// parseBuildTime parses a Cloud Build timestamp, returning the zero time if it
+// is unset or invalid.
+func parseBuildTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+// recentBuilds returns the most recent website builds, newest first.
+func recentBuilds(ctx context.Context, n int64) ([]buildStatus, error) {
+	cloudbuildService, projectID, err := newCloudBuild(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cloudbuildService.Projects.Builds.List(projectID).PageSize(n).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("build list error: %v", err)
+	}
+	var builds []buildStatus
+	for _, b := range resp.Builds {
+		if isPreviewBuild(b.Tags) {
+			continue
+		}
+		s := buildStatus{
+			ID:         b.Id,
+			Status:     b.Status,
+			Detail:     b.StatusDetail,
+			CreateTime: parseBuildTime(b.CreateTime),
+			StartTime:  parseBuildTime(b.StartTime),
+			FinishTime: parseBuildTime(b.FinishTime),
+			LogURL:     b.LogUrl,
+		}
+		if b.Substitutions != nil {
+			s.Branch = b.Substitutions["BRANCH_NAME"]
+			s.Commit = b.Substitutions["COMMIT_SHA"]
+		}
+		builds = append(builds, s)
+	}
+	return builds, nil
+}
+
+// upstreamStatusCacheKey is the cache key for the upstream CI status.
+const upstreamStatusCacheKey = "github:status:master"
+
+// upstreamCIStatus returns the combined commit status of the upstream
+// repository's master branch from the GitHub API, using the shared cache so
+// that instances don't each use up the unauthenticated API rate limit.
+func upstreamCIStatus(ctx context.Context) (*upstreamStatus, error) {
+	if b, ok, err := sharedCache.get(ctx, upstreamStatusCacheKey); err == nil && ok {
+		var s upstreamStatus
+		if err := json.Unmarshal(b, &s); err == nil {
+			return &s, nil
+		}
+	}
+	req, err := http.NewRequest("GET", "https://api.github.com/repos/google/gvisor/commits/master/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream status: %s", resp.Status)
+	}
+	var body struct {
+		State    string          `json:"state"`
+		SHA      string          `json:"sha"`
+		Statuses []upstreamCheck `json:"statuses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	s := &upstreamStatus{State: body.State, Commit: body.SHA, Contexts: body.Statuses}
+	if b, err := json.Marshal(s); err == nil {
+		sharedCache.set(ctx, upstreamStatusCacheKey, b, 5*time.Minute)
+	}
+	return s, nil
+}
+
+// statusCacheKey is the cache key for the aggregated status.
+const statusCacheKey = "status:site"
+
+// currentStatus returns the aggregated site status, cached for a minute.
+// Errors fetching individual sources are logged and reported generically in
+// the status rather than failing the whole request.
+func currentStatus(ctx context.Context) *siteStatus {
+	if b, ok, err := sharedCache.get(ctx, statusCacheKey); err == nil && ok {
+		var s siteStatus
+		if err := json.Unmarshal(b, &s); err == nil {
+			return &s
+		}
+	}
+
+	s := &siteStatus{
+		Version: os.Getenv("GAE_VERSION"),
+		Updated: time.Now(),
+	}
+	builds, err := recentBuilds(ctx, 10)
+	if err != nil {
+		log.Printf("Error fetching recent builds: %v", err)
+		s.Errors = append(s.Errors, "build status unavailable")
+	}
+	s.Builds = builds
+	recordBuilds(builds)
+	for _, b := range builds {
+		if b.Status == "SUCCESS" {
+			s.LastSuccess = b.FinishTime
+			break
+		}
+	}
+	if *upstreamCI {
+		upstream, err := upstreamCIStatus(ctx)
+		if err != nil {
+			log.Printf("Error fetching upstream CI status: %v", err)
+			s.Errors = append(s.Errors, "upstream CI status unavailable")
+		}
+		s.Upstream = upstream
+	}
+
+	if b, err := json.Marshal(s); err == nil {
+		sharedCache.set(ctx, statusCacheKey, b, time.Minute)
+	}
+	return s
+}
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>gVisor website status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.3em 1em; border-bottom: 1px solid #ddd; text-align: left; }
+.SUCCESS, .success { color: #1a7f37; }
+.FAILURE, .INTERNAL_ERROR, .TIMEOUT, .failure, .error { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>gVisor website status</h1>
+<p>Serving version: {{if .Version}}{{.Version}}{{else}}unknown{{end}}</p>
+<p>Last successful build: {{if .LastSuccess.IsZero}}unknown{{else}}{{.LastSuccess.Format "2006-01-02 15:04 MST"}}{{end}}</p>
+{{range .Errors}}<p class="error">Error: {{.}}</p>{{end}}
+<h2>Recent builds</h2>
+<table>
+<tr><th>Build</th><th>Status</th><th>Branch</th><th>Commit</th><th>Created</th><th>Finished</th></tr>
+{{range .Builds}}<tr>
+<td>{{if .LogURL}}<a href="{{.LogURL}}">{{.ID}}</a>{{else}}{{.ID}}{{end}}</td>
+<td class="{{.Status}}">{{.Status}}{{if .Detail}}: {{.Detail}}{{end}}</td>
+<td>{{.Branch}}</td>
+<td>{{.Commit}}</td>
+<td>{{.CreateTime.Format "2006-01-02 15:04"}}</td>
+<td>{{if not .FinishTime.IsZero}}{{.FinishTime.Format "2006-01-02 15:04"}}{{end}}</td>
+</tr>{{end}}
+</table>
+{{with .Upstream}}
+<h2>Upstream gVisor CI <span class="{{.State}}">({{.State}})</span></h2>
+<p>Commit: {{.Commit}}</p>
+<table>
+<tr><th>Pipeline</th><th>State</th></tr>
+{{range .Contexts}}<tr>
+<td>{{if .TargetURL}}<a href="{{.TargetURL}}">{{.Context}}</a>{{else}}{{.Context}}{{end}}</td>
+<td class="{{.State}}">{{.State}}</td>
+</tr>{{end}}
+</table>
+{{end}}
+<p>Updated {{.Updated.Format "2006-01-02 15:04:05 MST"}}</p>
+</body>
+</html>
+`))
+
+// statusHandler serves the aggregated status as HTML.
+func statusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		statusTemplate.Execute(w, currentStatus(r.Context()))
+	})
+}
+
+// apiStatusHandler serves the aggregated status as JSON.
+func apiStatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(currentStatus(r.Context()))
+	})
+}
+
+// badgeTemplate is a flat shields.io-style badge.
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="build: {{.Label}}">
+<title>build: {{.Label}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="37" height="20" fill="#555"/>
+<rect x="37" width="{{.LabelWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="18.5" y="14">build</text>
+<text x="{{.LabelX}}" y="14">{{.Label}}</text>
+</g>
+</svg>
+`))
+
+// badgeState maps a Cloud Build status to a badge label and color.
+func badgeState(status string) (string, string) {
+	switch status {
+	case "SUCCESS":
+		return "passing", "#4c1"
+	case "QUEUED", "WORKING":
+		return "building", "#dfb317"
+	case "FAILURE", "INTERNAL_ERROR", "TIMEOUT", "CANCELLED":
+		return "failing", "#e05d44"
+	default:
+		return "unknown", "#9f9f9f"
+	}
+}
+
+// badgeHandler serves an SVG badge for the most recent website build.
+func badgeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status string
+		if builds := currentStatus(r.Context()).Builds; len(builds) > 0 {
+			status = builds[0].Status
+		}
+		label, color := badgeState(status)
+		// Approximate the label width at 7px per character.
+		labelWidth := 7*len(label) + 10
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		badgeTemplate.Execute(w, struct {
+			Label      string
+			Color      string
+			Width      int
+			LabelWidth int
+			LabelX     float64
+		}{
+			Label:      label,
+			Color:      color,
+			Width:      37 + labelWidth,
+			LabelWidth: labelWidth,
+			LabelX:     37 + float64(labelWidth)/2,
+		})
+	})
+}
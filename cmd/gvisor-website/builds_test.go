@@ -0,0 +1,191 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/cloudbuild/v1"
+)
+
+// testBuildHistory is the website build history of the fake Cloud Build API,
+// newest first, with a preview build in between.
+var testBuildHistory = []*cloudbuild.Build{
+	{
+		Id:            "build-4",
+		Status:        "WORKING",
+		CreateTime:    "2019-10-03T10:00:00Z",
+		StartTime:     "2019-10-03T10:00:05Z",
+		Substitutions: map[string]string{"BRANCH_NAME": "master", "COMMIT_SHA": "dddd"},
+	},
+	{
+		Id:         "build-3",
+		Status:     "FAILURE",
+		CreateTime: "2019-10-03T09:00:00Z",
+		FinishTime: "2019-10-03T09:10:00Z",
+		Tags:       []string{"preview", "pr-42"},
+	},
+	{
+		Id:            "build-2",
+		Status:        "SUCCESS",
+		CreateTime:    "2019-10-02T10:00:00Z",
+		StartTime:     "2019-10-02T10:00:05Z",
+		FinishTime:    "2019-10-02T10:08:00Z",
+		LogUrl:        "https://console.cloud.google.com/cloud-build/builds/build-2",
+		Substitutions: map[string]string{"BRANCH_NAME": "master", "COMMIT_SHA": "bbbb"},
+	},
+	{
+		Id:           "build-1",
+		Status:       "FAILURE",
+		StatusDetail: "step 4 failed",
+		CreateTime:   "2019-10-01T10:00:00Z",
+		FinishTime:   "2019-10-01T10:05:00Z",
+	},
+}
+
+func TestEndToEndStatus(t *testing.T) {
+	defer func(upstream bool) { *upstreamCI = upstream }(*upstreamCI)
+	*upstreamCI = false
+	s := newTestServer(t)
+	defer s.close()
+	s.history = testBuildHistory
+
+	resp, body := s.do(t, "GET", "/api/status", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("GET /api/status: got status %d and Cache-Control %q, want 200 and no-cache", resp.StatusCode, resp.Header.Get("Cache-Control"))
+	}
+	var status siteStatus
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatalf("GET /api/status: invalid JSON: %v", err)
+	}
+	var ids []string
+	for _, b := range status.Builds {
+		ids = append(ids, b.ID)
+	}
+	if want := []string{"build-4", "build-2", "build-1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got builds %q, want %q without the preview build", ids, want)
+	}
+	if want := time.Date(2019, 10, 2, 10, 8, 0, 0, time.UTC); !status.LastSuccess.Equal(want) {
+		t.Errorf("got last success %v, want %v", status.LastSuccess, want)
+	}
+	if b := status.Builds[1]; b.Branch != "master" || b.Commit != "bbbb" || b.LogURL == "" || b.FinishTime.IsZero() {
+		t.Errorf("got build %+v, want the branch, commit, log and finish time of build-2", b)
+	}
+	if b := status.Builds[2]; b.Detail != "step 4 failed" {
+		t.Errorf("got build %+v, want the failure detail of build-1", b)
+	}
+	if len(status.Errors) != 0 || status.Upstream != nil {
+		t.Errorf("got errors %q and upstream %+v, want neither", status.Errors, status.Upstream)
+	}
+
+	resp, body = s.do(t, "GET", "/status", nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET /status: got status %d and Content-Type %q, want an HTML page", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"Last successful build: 2019-10-02 10:08 UTC",
+		`<a href="https://console.cloud.google.com/cloud-build/builds/build-2">build-2</a>`,
+		`<td class="FAILURE">FAILURE: step 4 failed</td>`,
+		`<td class="WORKING">WORKING</td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET /status: body does not contain %q", want)
+		}
+	}
+	if strings.Contains(body, "build-3") {
+		t.Errorf("GET /status lists the preview build")
+	}
+}
+
+func TestEndToEndStatusError(t *testing.T) {
+	defer func(upstream bool) { *upstreamCI = upstream }(*upstreamCI)
+	*upstreamCI = false
+	s := newTestServer(t)
+	defer s.close()
+	s.historyErr = true
+
+	resp, body := s.do(t, "GET", "/api/status", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/status: got status %d, want 200", resp.StatusCode)
+	}
+	var status siteStatus
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatalf("GET /api/status: invalid JSON: %v", err)
+	}
+	if want := []string{"build status unavailable"}; len(status.Builds) != 0 || !reflect.DeepEqual(status.Errors, want) {
+		t.Errorf("got builds %+v and errors %q, want no builds and %q", status.Builds, status.Errors, want)
+	}
+	if strings.Contains(body, "projects/") {
+		t.Errorf("GET /api/status discloses the Cloud Build error: %s", body)
+	}
+	if _, body := s.do(t, "GET", "/status", nil); !strings.Contains(body, "Error: build status unavailable") || strings.Contains(body, "projects/") {
+		t.Errorf("GET /status: got body %q, want the generic error", body)
+	}
+}
+
+func TestBadgeState(t *testing.T) {
+	for _, tc := range []struct {
+		status, label, color string
+	}{
+		{"SUCCESS", "passing", "#4c1"},
+		{"QUEUED", "building", "#dfb317"},
+		{"WORKING", "building", "#dfb317"},
+		{"FAILURE", "failing", "#e05d44"},
+		{"TIMEOUT", "failing", "#e05d44"},
+		{"CANCELLED", "failing", "#e05d44"},
+		{"STATUS_UNKNOWN", "unknown", "#9f9f9f"},
+		{"", "unknown", "#9f9f9f"},
+	} {
+		if label, color := badgeState(tc.status); label != tc.label || color != tc.color {
+			t.Errorf("badgeState(%q) = %q, %q, want %q, %q", tc.status, label, color, tc.label, tc.color)
+		}
+	}
+}
+
+func TestEndToEndBadge(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		history []*cloudbuild.Build
+		label   string
+	}{
+		{"latest build running", testBuildHistory, "building"},
+		{"latest build succeeded", testBuildHistory[2:], "passing"},
+		// Preview builds don't count.
+		{"only a preview build", testBuildHistory[1:2], "unknown"},
+		{"preview failed after a success", testBuildHistory[1:3], "passing"},
+		{"latest build failed", testBuildHistory[3:], "failing"},
+		{"no builds", nil, "unknown"},
+	} {
+		s := newTestServer(t)
+		s.history = tc.history
+		resp, body := s.do(t, "GET", "/build/badge.svg", nil)
+		s.close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/svg+xml" {
+			t.Errorf("%s: got status %d and Content-Type %q, want an SVG", tc.name, resp.StatusCode, resp.Header.Get("Content-Type"))
+			continue
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != "public, max-age=60" {
+			t.Errorf("%s: got Cache-Control %q, want a short TTL", tc.name, cc)
+		}
+		if want := "<title>build: " + tc.label + "</title>"; !strings.Contains(body, want) {
+			t.Errorf("%s: badge %q does not contain %q", tc.name, body, want)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cache is a key/value cache for data fetched from upstreams.
+//
+// Implementations may be shared between serving instances, so values must be
+// self-contained and keys should be namespaced by the caller.
+type cache interface {
+	// get returns the value for the given key, and whether it was found.
+	get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// set stores the value for the given key. A zero ttl means the value
+	// does not expire, although it may still be evicted.
+	set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// delete removes the given key.
+	delete(ctx context.Context, key string) error
+}
+
+// newCache returns a cache for the given spec, which is either "memory" or a
+// redis://[:password@]host:port[/db] URL. Memorystore instances are addressed
+// as plain Redis.
+func newCache(spec string) (cache, error) {
+	if spec == "" || spec == "memory" {
+		return newMemoryCache(*memoryCacheEntries, *memoryCacheBytes), nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache %q: %v", spec, err)
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unknown cache %q", spec)
+	}
+	return newRedisCache(u)
+}
+
+// memoryEntry is a single value in a memoryCache.
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// memoryCache is a cache local to this instance.
+type memoryCache struct {
+	maxEntries int
+	maxBytes   int
+
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+
+	// size is the total size of the values in entries.
+	size int
+}
+
+// memoryMaxValueFraction limits the size of a single value to this fraction
+// of a memoryCache's capacity, so that one large value can't flush the whole
+// cache.
+const memoryMaxValueFraction = 8
+
+// newMemoryCache returns a cache holding at most maxEntries values totalling
+// at most maxBytes.
+func newMemoryCache(maxEntries, maxBytes int) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    make(map[string]memoryEntry),
+	}
+}
+
+// stats returns the number of entries and their total size.
+func (c *memoryCache) stats() (entries, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
+
+func (c *memoryCache) get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.removeLocked(key)
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (c *memoryCache) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	e := memoryEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+	if len(value) > c.maxBytes/memoryMaxValueFraction {
+		// Too large to cache.
+		return nil
+	}
+	for len(c.entries) > 0 && (len(c.entries) >= c.maxEntries || c.size+len(value) > c.maxBytes) {
+		c.evictLocked()
+	}
+	c.entries[key] = e
+	c.size += len(value)
+	return nil
+}
+
+func (c *memoryCache) delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+	return nil
+}
+
+// removeLocked removes the given key, if present.
+//
+// Precondition: c.mu must be held.
+func (c *memoryCache) removeLocked(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.size -= len(e.value)
+		delete(c.entries, key)
+	}
+}
+
+// evictLocked drops at least one entry. Expired entries are dropped first;
+// failing that, the entry closest to expiry is dropped.
+//
+// Precondition: c.mu must be held and c.entries must not be empty.
+func (c *memoryCache) evictLocked() {
+	now := time.Now()
+	n := len(c.entries)
+	var (
+		victim  string
+		soonest time.Time
+	)
+	for key, e := range c.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			c.removeLocked(key)
+			continue
+		}
+		if victim == "" || (!e.expires.IsZero() && (soonest.IsZero() || e.expires.Before(soonest))) {
+			victim, soonest = key, e.expires
+		}
+	}
+	if len(c.entries) == n {
+		c.removeLocked(victim)
+	}
+}
+
+// redisTimeout bounds each round trip to Redis.
+const redisTimeout = 2 * time.Second
+
+// redisCache is a cache backed by Redis or Memorystore, speaking the RESP
+// protocol directly over a small pool of connections.
+type redisCache struct {
+	addr     string
+	password string
+	db       int
+
+	// pool holds idle connections.
+	pool chan *redisConn
+}
+
+// redisConn is a single connection to Redis.
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// newRedisCache returns a cache for the given redis:// URL.
+func newRedisCache(u *url.URL) (*redisCache, error) {
+	c := &redisCache{
+		addr: u.Host,
+		pool: make(chan *redisConn, 8),
+	}
+	if !strings.Contains(c.addr, ":") {
+		c.addr += ":6379"
+	}
+	if u.User != nil {
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+		c.db = n
+	}
+	return c, nil
+}
+
+func (c *redisCache) get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := c.do(ctx, "GET", key)
+	if err != nil {
+		return nil, false, err
+	}
+	if v == nil {
+		return nil, false, nil
+	}
+	return v, true, nil
+}
+
+func (c *redisCache) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(ttl/time.Millisecond), 10))
+	}
+	_, err := c.do(ctx, args...)
+	return err
+}
+
+func (c *redisCache) delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, "DEL", key)
+	return err
+}
+
+// do runs a single command and returns its reply. Nil bulk replies are
+// returned as nil; status and integer replies are returned as their text.
+func (c *redisCache) do(ctx context.Context, args ...string) ([]byte, error) {
+	rc, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := rc.do(ctx, args...)
+	if err != nil {
+		// The connection is in an unknown state.
+		rc.conn.Close()
+		return nil, err
+	}
+	c.release(rc)
+	return reply, nil
+}
+
+// conn returns an idle connection, or dials a new one.
+func (c *redisCache) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case rc := <-c.pool:
+		return rc, nil
+	default:
+	}
+	d := net.Dialer{Timeout: redisTimeout}
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, rd: bufio.NewReader(conn)}
+	if c.password != "" {
+		if _, err := rc.do(ctx, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do(ctx, "SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// release returns a connection to the pool, closing it if the pool is full.
+func (c *redisCache) release(rc *redisConn) {
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+}
+
+// do writes a command and reads its reply.
+func (rc *redisConn) do(ctx context.Context, args ...string) ([]byte, error) {
+	deadline := time.Now().Add(redisTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	rc.conn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+// readReply reads a single non-array RESP reply.
+func (rc *redisConn) readReply() ([]byte, error) {
+	line, err := rc.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line[1:])
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
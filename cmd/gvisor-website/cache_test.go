@@ -0,0 +1,272 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadReply(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []byte
+		wantErr bool
+	}{
+		{in: "+OK\r\n", want: []byte("OK")},
+		{in: ":42\r\n", want: []byte("42")},
+		{in: "$5\r\nhello\r\n", want: []byte("hello")},
+		{in: "$7\r\nfoo\r\nba\r\n", want: []byte("foo\r\nba")},
+		{in: "$0\r\n\r\n", want: []byte{}},
+		{in: "$-1\r\n", want: nil},
+		{in: "-ERR wrong type\r\n", wantErr: true},
+		{in: "*1\r\n$1\r\nx\r\n", wantErr: true},
+		{in: "$x\r\n", wantErr: true},
+		{in: "$5\r\nhel", wantErr: true},
+		{in: "\r\n", wantErr: true},
+		{in: "", wantErr: true},
+	} {
+		rc := &redisConn{rd: bufio.NewReader(strings.NewReader(tc.in))}
+		got, err := rc.readReply()
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("readReply(%q) = %q, want error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("readReply(%q) failed: %v", tc.in, err)
+			continue
+		}
+		if (got == nil) != (tc.want == nil) || string(got) != string(tc.want) {
+			t.Errorf("readReply(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// fakeRedis is a minimal Redis server supporting the commands redisCache
+// uses. Keys starting with "err" reply with an error.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	values   map[string]string
+	commands [][]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, values: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, f.reply(args))
+	}
+}
+
+// readCommand reads a RESP array of bulk strings.
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(rd, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(rd, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, args)
+	if len(args) > 1 && strings.HasPrefix(args[1], "err") {
+		return "-ERR fake failure\r\n"
+	}
+	switch args[0] {
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		v, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case "SET":
+		f.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		_, ok := f.values[args[1]]
+		delete(f.values, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// command returns the i'th command received, joined by spaces.
+func (f *fakeRedis) command(i int) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if i >= len(f.commands) {
+		return ""
+	}
+	return strings.Join(f.commands[i], " ")
+}
+
+func TestRedisCache(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.ln.Close()
+	u, _ := url.Parse("redis://:secret@" + f.ln.Addr().String() + "/2")
+	c, err := newRedisCache(u)
+	if err != nil {
+		t.Fatalf("newRedisCache: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, ok, err := c.get(ctx, "missing"); err != nil || ok {
+		t.Errorf("get(missing) = %v, %v, want not found", ok, err)
+	}
+	// The first command on a connection authenticates and selects the
+	// database.
+	if got := f.command(0); got != "AUTH secret" {
+		t.Errorf("first command = %q, want AUTH", got)
+	}
+	if got := f.command(1); got != "SELECT 2" {
+		t.Errorf("second command = %q, want SELECT 2", got)
+	}
+
+	value := "line\r\nbreak"
+	if err := c.set(ctx, "k", []byte(value), 1500*time.Millisecond); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if got := f.command(3); got != "SET k "+value+" PX 1500" {
+		t.Errorf("set sent %q, want a PX ttl", got)
+	}
+	if err := c.set(ctx, "forever", []byte("v"), 0); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if got := f.command(4); got != "SET forever v" {
+		t.Errorf("set without ttl sent %q", got)
+	}
+	if got, ok, err := c.get(ctx, "k"); err != nil || !ok || string(got) != value {
+		t.Errorf("get(k) = %q, %v, %v, want %q", got, ok, err, value)
+	}
+	if err := c.delete(ctx, "k"); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if _, ok, err := c.get(ctx, "k"); err != nil || ok {
+		t.Errorf("get after delete = %v, %v, want not found", ok, err)
+	}
+
+	// Error replies are returned, and the connection is not reused.
+	if _, _, err := c.get(ctx, "err"); err == nil {
+		t.Errorf("get(err) succeeded, want error")
+	}
+	if _, _, err := c.get(ctx, "missing"); err != nil {
+		t.Errorf("get after error failed: %v", err)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(2, 1<<20)
+	c.set(ctx, "a", []byte("1"), time.Hour)
+	c.set(ctx, "b", []byte("2"), time.Minute)
+	c.set(ctx, "c", []byte("3"), 0)
+	// b expires soonest, so it is evicted.
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok, _ := c.get(ctx, key); ok != want {
+			t.Errorf("get(%q) found = %v, want %v", key, ok, want)
+		}
+	}
+
+	c.set(ctx, "expired", []byte("x"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok, _ := c.get(ctx, "expired"); ok {
+		t.Errorf("get(expired) found an expired entry")
+	}
+}
+
+func TestMemoryCacheBytes(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(100, 80)
+	c.set(ctx, "a", make([]byte, 10), time.Hour)
+	c.set(ctx, "b", make([]byte, 10), time.Minute)
+	c.set(ctx, "big", make([]byte, 11), time.Hour)
+	for i := 0; i < 7; i++ {
+		c.set(ctx, fmt.Sprint("c", i), make([]byte, 10), 2*time.Hour)
+	}
+	// Values over an eighth of the limit are not cached, and b is evicted
+	// to keep the total within it.
+	for key, want := range map[string]bool{"a": true, "b": false, "big": false, "c6": true} {
+		if _, ok, _ := c.get(ctx, key); ok != want {
+			t.Errorf("get(%q) found = %v, want %v", key, ok, want)
+		}
+	}
+	if c.size != 80 {
+		t.Errorf("size = %d, want 80", c.size)
+	}
+
+	// Replacing a value accounts for the old one.
+	c.set(ctx, "a", make([]byte, 5), time.Hour)
+	if c.size != 75 {
+		t.Errorf("size after replace = %d, want 75", c.size)
+	}
+	c.delete(ctx, "a")
+	if c.size != 70 {
+		t.Errorf("size after delete = %d, want 70", c.size)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+const (
+	// canaryCookie records whether a client is served the canary ("1") or
+	// the stable site ("0"), so that clients don't flip between the two.
+	canaryCookie = "gvisor_canary"
+
+	// canaryHeader forces a request to the canary ("1") or the stable site
+	// ("0"), so that a canary can be checked before it takes any traffic.
+	canaryHeader = "X-Canary"
+)
+
+var canaryRequests = newCounter("canary_requests_total", "Static site requests, by whether they were served by the canary.", "variant")
+
+// newCanary returns the handler serving the canary build from the given
+// static dir or upstream URL, or nil if neither is set.
+func newCanary(staticDir, upstream string) (http.Handler, error) {
+	switch {
+	case staticDir != "" && upstream != "":
+		return nil, fmt.Errorf("only one of a canary static dir and upstream may be set")
+	case staticDir != "":
+		return staticFilesHandler(staticDir), nil
+	case upstream != "":
+		u, err := url.Parse(upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid canary upstream %q", upstream)
+		}
+		proxy := httputil.NewSingleHostReverseProxy(u)
+		proxy.Transport = upstreamClient("canary").Transport
+		return proxy, nil
+	}
+	return nil, nil
+}
+
+// useCanary returns true if the request should be served by the canary, and
+// whether the choice is new and should be stored in the canary cookie.
+func useCanary(r *http.Request, percent int) (canary, assigned bool) {
+	switch r.Header.Get(canaryHeader) {
+	case "1":
+		return true, false
+	case "0":
+		return false, false
+	}
+	if percent <= 0 {
+		return false, false
+	}
+	if c, err := r.Cookie(canaryCookie); err == nil && (c.Value == "0" || c.Value == "1") {
+		return c.Value == "1", false
+	}
+	if trafficClass(r) != classBrowser {
+		// Clients that don't keep cookies would flip between builds, and
+		// crawlers shouldn't index a build that isn't live yet.
+		return false, false
+	}
+	return rand.Intn(100) < percent, true
+}
+
+// canaryHandler serves --canary-percent of clients, and requests with the
+// canary header, from the canary handler instead of h. A nil canary serves
+// everything from h.
+func canaryHandler(canary http.Handler, h http.Handler) http.Handler {
+	if canary == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Cookie", canaryHeader)
+		use, assigned := useCanary(r, *canaryPercent)
+		if assigned {
+			value := "0"
+			if use {
+				value = "1"
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     canaryCookie,
+				Value:    value,
+				Path:     "/",
+				Expires:  time.Now().Add(24 * time.Hour),
+				Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+				HttpOnly: true,
+			})
+		}
+		if use {
+			canaryRequests.inc("canary")
+			canary.ServeHTTP(w, r)
+			return
+		}
+		canaryRequests.inc("stable")
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const browserUA = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/76.0.3809.100 Safari/537.36"
+
+// classified returns the request with its traffic class set, as by
+// classifyHandler.
+func classified(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), trafficClassKey{}, classifyRequest(r)))
+}
+
+func TestUseCanary(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		header       string
+		cookie       string
+		ua           string
+		percent      int
+		wantCanary   bool
+		wantAssigned bool
+	}{
+		{name: "disabled", ua: browserUA, percent: 0},
+		{name: "header forces canary", header: "1", percent: 0, wantCanary: true},
+		{name: "header forces stable", header: "0", cookie: "1", ua: browserUA, percent: 100},
+		{name: "cookie canary", cookie: "1", ua: browserUA, percent: 10, wantCanary: true},
+		{name: "cookie stable", cookie: "0", ua: browserUA, percent: 100},
+		{name: "cookie ignored when disabled", cookie: "1", ua: browserUA, percent: 0},
+		{name: "invalid cookie", cookie: "x", ua: browserUA, percent: 100, wantCanary: true, wantAssigned: true},
+		{name: "new browser", ua: browserUA, percent: 100, wantCanary: true, wantAssigned: true},
+		{name: "new browser stable", ua: browserUA, percent: 0},
+		{name: "crawler", ua: "Googlebot/2.1 (+http://www.google.com/bot.html)", percent: 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/docs/", nil)
+			r.Header.Set("User-Agent", tc.ua)
+			r.Header.Set("Accept", "text/html")
+			if tc.header != "" {
+				r.Header.Set(canaryHeader, tc.header)
+			}
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: canaryCookie, Value: tc.cookie})
+			}
+			canary, assigned := useCanary(classified(r), tc.percent)
+			if canary != tc.wantCanary || assigned != tc.wantAssigned {
+				t.Errorf("useCanary() = %v, %v, want %v, %v", canary, assigned, tc.wantCanary, tc.wantAssigned)
+			}
+		})
+	}
+}
+
+func TestCanaryHandler(t *testing.T) {
+	defer func(p int) { *canaryPercent = p }(*canaryPercent)
+	*canaryPercent = 100
+
+	body := func(s string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, s) })
+	}
+	h := canaryHandler(body("canary"), body("stable"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", browserUA)
+	r.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, classified(r))
+	if got := rec.Body.String(); got != "canary" {
+		t.Errorf("got body %q, want canary", got)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != canaryCookie || cookies[0].Value != "1" {
+		t.Errorf("got cookies %v, want %s=1", cookies, canaryCookie)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: canaryCookie, Value: "0"})
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if got := rec.Body.String(); got != "stable" {
+		t.Errorf("got body %q with stable cookie, want stable", got)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("got cookies %v with existing cookie, want none", cookies)
+	}
+
+	// Without a canary, everything is served by the stable handler.
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(canaryHeader, "1")
+	rec = httptest.NewRecorder()
+	canaryHandler(nil, body("stable")).ServeHTTP(rec, r)
+	if got := rec.Body.String(); got != "stable" {
+		t.Errorf("got body %q without a canary, want stable", got)
+	}
+}
+
+func TestNewCanary(t *testing.T) {
+	for _, tc := range []struct {
+		staticDir, upstream string
+		wantNil, wantErr    bool
+	}{
+		{wantNil: true},
+		{staticDir: "canary"},
+		{upstream: "https://canary-dot-gvisor-website.appspot.com"},
+		{upstream: "canary-dot-gvisor-website.appspot.com", wantErr: true},
+		{staticDir: "canary", upstream: "https://canary.example.com", wantErr: true},
+	} {
+		h, err := newCanary(tc.staticDir, tc.upstream)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("newCanary(%q, %q) returned error %v, want error %v", tc.staticDir, tc.upstream, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && (h == nil) != tc.wantNil {
+			t.Errorf("newCanary(%q, %q) = %v, want nil %v", tc.staticDir, tc.upstream, h, tc.wantNil)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var chaosFaults = newCounter("chaos_faults_total", "Faults injected into upstream requests, by upstream and fault.", "upstream", "fault")
+
+// chaosConfig configures the faults injected into upstream requests, so that
+// retries, caching and fallbacks can be exercised in integration tests and
+// game days.
+type chaosConfig struct {
+	// latency is added to every request.
+	latency time.Duration
+
+	// errorRate is the fraction of requests answered with a 503 without
+	// reaching the upstream.
+	errorRate float64
+
+	// truncateRate is the fraction of response bodies cut short.
+	truncateRate float64
+}
+
+// chaos is nil unless fault injection is enabled with --chaos.
+var chaos *chaosConfig
+
+// parseChaos parses a comma-separated list of fault=value pairs, e.g.
+// "latency=500ms,error=0.1,truncate=0.05". An empty spec disables fault
+// injection.
+func parseChaos(spec string) (*chaosConfig, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	c := &chaosConfig{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid fault %q: want fault=value", part)
+		}
+		var err error
+		switch kv[0] {
+		case "latency":
+			c.latency, err = time.ParseDuration(kv[1])
+			if err == nil && c.latency < 0 {
+				err = fmt.Errorf("must not be negative")
+			}
+		case "error":
+			c.errorRate, err = parseRate(kv[1])
+		case "truncate":
+			c.truncateRate, err = parseRate(kv[1])
+		default:
+			return nil, fmt.Errorf("unknown fault %q", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault %q: %v", part, err)
+		}
+	}
+	return c, nil
+}
+
+// parseRate parses a fraction between 0 and 1.
+func parseRate(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("must be between 0 and 1")
+	}
+	return f, nil
+}
+
+// transport returns rt with faults injected into requests to the named
+// upstream. A nil config returns rt unchanged.
+func (c *chaosConfig) transport(upstream string, rt http.RoundTripper) http.RoundTripper {
+	if c == nil {
+		return rt
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &chaosTransport{config: c, upstream: upstream, rt: rt}
+}
+
+// chaosTransport is an http.RoundTripper injecting faults.
+type chaosTransport struct {
+	config   *chaosConfig
+	upstream string
+	rt       http.RoundTripper
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.config.latency > 0 {
+		chaosFaults.inc(t.upstream, "latency")
+		timer := time.NewTimer(t.config.latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	if rand.Float64() < t.config.errorRate {
+		chaosFaults.inc(t.upstream, "error")
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			Body:       ioutil.NopCloser(strings.NewReader("injected fault\n")),
+			Request:    req,
+		}, nil
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil || rand.Float64() >= t.config.truncateRate {
+		return resp, err
+	}
+	chaosFaults.inc(t.upstream, "truncate")
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	if len(b) > 0 {
+		n = rand.Intn(len(b))
+	}
+	resp.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(b[:n]), errReader{io.ErrUnexpectedEOF}))
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// errReader is an io.Reader that always fails with the given error.
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
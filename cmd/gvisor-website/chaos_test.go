@@ -0,0 +1,100 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChaos(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		want    *chaosConfig
+		wantErr bool
+	}{
+		{spec: "", want: nil},
+		{spec: "latency=500ms", want: &chaosConfig{latency: 500 * time.Millisecond}},
+		{spec: "latency=1s, error=0.1,truncate=1", want: &chaosConfig{latency: time.Second, errorRate: 0.1, truncateRate: 1}},
+		{spec: "latency=-1s", wantErr: true},
+		{spec: "error=1.5", wantErr: true},
+		{spec: "truncate=x", wantErr: true},
+		{spec: "error", wantErr: true},
+		{spec: "drop=0.1", wantErr: true},
+	} {
+		got, err := parseChaos(tc.spec)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseChaos(%q) returned error %v, want error %v", tc.spec, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseChaos(%q) = %+v, want %+v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestChaosTransport(t *testing.T) {
+	const body = "0123456789abcdef"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	get := func(ctx context.Context, c *chaosConfig) (*http.Response, string, error) {
+		client := &http.Client{Transport: c.transport("test", srv.Client().Transport)}
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return resp, string(b), err
+	}
+
+	// Without faults, responses pass through.
+	if resp, got, err := get(context.Background(), nil); err != nil || resp.StatusCode != http.StatusOK || got != body {
+		t.Errorf("no faults: got %v, %q, %v", resp, got, err)
+	}
+	if resp, got, err := get(context.Background(), &chaosConfig{}); err != nil || resp.StatusCode != http.StatusOK || got != body {
+		t.Errorf("zero faults: got %v, %q, %v", resp, got, err)
+	}
+
+	if resp, _, err := get(context.Background(), &chaosConfig{errorRate: 1}); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("error fault: got %v, %v, want status 503", resp, err)
+	}
+
+	_, got, err := get(context.Background(), &chaosConfig{truncateRate: 1})
+	if err != io.ErrUnexpectedEOF || len(got) >= len(body) || !strings.HasPrefix(body, got) {
+		t.Errorf("truncate fault: got %q, %v, want a prefix and %v", got, err, io.ErrUnexpectedEOF)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := get(ctx, &chaosConfig{latency: time.Minute}); err == nil {
+		t.Errorf("latency fault: got no error after the context expired")
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("latency fault: took %v after the context expired", d)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Traffic classes.
+const (
+	classCrawler = "crawler"
+	classGo      = "go"
+	classGit     = "git"
+	classCI      = "ci"
+	classBrowser = "browser"
+	classOther   = "other"
+)
+
+// crawlerTokens identify search engine and other crawlers. The match is
+// case-insensitive.
+var crawlerTokens = []string{
+	"googlebot",
+	"bingbot",
+	"duckduckbot",
+	"baiduspider",
+	"yandex",
+	"applebot",
+	"slurp",
+	"semrushbot",
+	"ahrefsbot",
+	"mj12bot",
+	"petalbot",
+	"facebookexternalhit",
+	"twitterbot",
+	"linkedinbot",
+	"crawler",
+	"spider",
+	"bot/",
+	"bot;",
+}
+
+// ciTokens identify scripted fetchers typically used in CI.
+var ciTokens = []string{
+	"curl/",
+	"wget/",
+	"python-requests/",
+	"python-urllib/",
+	"go-http-client/",
+	"okhttp/",
+	"bazel/",
+	"github-actions",
+}
+
+// classifyRequest returns the traffic class of the given request.
+func classifyRequest(r *http.Request) string {
+	ua := strings.ToLower(r.UserAgent())
+	switch {
+	case ua == "":
+		return classOther
+	case strings.HasPrefix(ua, "git/"):
+		return classGit
+	case r.URL.Query().Get("go-get") == "1", strings.Contains(ua, "goproxy"), strings.HasPrefix(ua, "go/"):
+		return classGo
+	}
+	for _, token := range crawlerTokens {
+		if strings.Contains(ua, token) {
+			return classCrawler
+		}
+	}
+	for _, token := range ciTokens {
+		if strings.Contains(ua, token) {
+			return classCI
+		}
+	}
+	if strings.HasPrefix(ua, "mozilla/") {
+		return classBrowser
+	}
+	return classOther
+}
+
+// trafficClassKey is the context key for the traffic class.
+type trafficClassKey struct{}
+
+// trafficClass returns the class assigned to the given request.
+func trafficClass(r *http.Request) string {
+	if class, ok := r.Context().Value(trafficClassKey{}).(string); ok {
+		return class
+	}
+	return classOther
+}
+
+var requestsByClass = newCounter("http_requests_by_class_total", "HTTP requests by route and traffic class.", "route", "class")
+
+// classifyHandler assigns each request a traffic class, for use by logging,
+// metrics and per-class policies.
+func classifyHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := classifyRequest(r)
+		requestsByClass.inc(route, class)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), trafficClassKey{}, class)))
+	})
+}
+
+// parseClassRoutes parses a comma-separated list of route=class pairs into a
+// map from route to the set of classes.
+func parseClassRoutes(spec string) (map[string]map[string]bool, error) {
+	m := make(map[string]map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid policy %q: want route=class", part)
+		}
+		if m[kv[0]] == nil {
+			m[kv[0]] = make(map[string]bool)
+		}
+		m[kv[0]][kv[1]] = true
+	}
+	return m, nil
+}
+
+var (
+	// deniedClasses maps routes to the traffic classes that may not use
+	// them, set from flags at startup.
+	deniedClasses map[string]map[string]bool
+
+	// classLimiters are per-class rate limiters, set from flags at startup.
+	classLimiters map[string]*rateLimiter
+)
+
+// newLimiters returns rate limiters for the given key=perMinute pairs.
+func newLimiters(spec string) (map[string]*rateLimiter, error) {
+	limits, err := parseLimits(spec)
+	if err != nil {
+		return nil, err
+	}
+	limiters := make(map[string]*rateLimiter, len(limits))
+	for class, perMinute := range limits {
+		limiters[class] = newRateLimiter(perMinute, perMinute)
+	}
+	return limiters, nil
+}
+
+// classPolicyHandler applies the per-class policies: classes denied for the
+// route get 403 Forbidden, and rate limited classes get 429 Too Many Requests
+// when a client exceeds the class limit.
+func classPolicyHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := trafficClass(r)
+		if deniedClasses[route][class] {
+			requestsShed.inc(route, "class-denied")
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+			httpError(w, r, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if l, ok := classLimiters[class]; ok && !l.allow(clientIP(r)) {
+			requestsShed.inc(route, "class-rate")
+			w.Header().Set("Retry-After", "60")
+			httpError(w, r, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
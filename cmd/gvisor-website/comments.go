@@ -0,0 +1,281 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// discussionsCacheKey is the cache key for the discussions of the
+	// repository.
+	discussionsCacheKey = "discussions"
+
+	// discussionsTTL is how long comment counts are served before the
+	// discussions are fetched again.
+	discussionsTTL = 10 * time.Minute
+
+	// discussionsFetchTimeout bounds fetching the discussions, which is
+	// shared by all requests waiting for them.
+	discussionsFetchTimeout = 30 * time.Second
+
+	// maxDiscussionPages bounds the pages of discussions fetched, of 100
+	// discussions each.
+	maxDiscussionPages = 10
+)
+
+// discussion is a GitHub Discussion of the repository.
+type discussion struct {
+	Number   int    `json:"number"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Comments int    `json:"comments"`
+}
+
+// postComments is the discussion of a blog post.
+type postComments struct {
+	Post  string `json:"post"`
+	Title string `json:"title"`
+
+	// Discussion is the number of the discussion of the post, or zero if
+	// there is none yet, in which case URL starts one.
+	Discussion int    `json:"discussion,omitempty"`
+	URL        string `json:"url"`
+	Comments   int    `json:"comments"`
+}
+
+// repoName returns the owner and name of the repository whose releases are
+// at --releases-url.
+func repoName() (string, string) {
+	u, err := url.Parse(repoAPIURL())
+	if err != nil {
+		return "", ""
+	}
+	p := strings.Split(strings.TrimPrefix(u.Path, "/repos/"), "/")
+	if len(p) < 2 {
+		return "", ""
+	}
+	return p[0], p[1]
+}
+
+// githubGraphQLURL returns the GitHub GraphQL API endpoint of the API host of
+// --releases-url.
+func githubGraphQLURL() (string, error) {
+	u, err := url.Parse(*releasesURL)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + u.Host + "/graphql", nil
+}
+
+// discussionsQuery lists a page of the discussions of a repository. The
+// comment count doesn't include replies to comments.
+const discussionsQuery = `query($owner: String!, $name: String!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    discussions(first: 100, after: $after) {
+      pageInfo { hasNextPage endCursor }
+      nodes { number title url comments { totalCount } }
+    }
+  }
+}`
+
+// fetchDiscussions fetches the discussions of the repository from GitHub.
+// Fetching discussions requires --github-token.
+func fetchDiscussions(ctx context.Context) ([]discussion, error) {
+	if *githubToken == "" {
+		return nil, errors.New("no GitHub token")
+	}
+	endpoint, err := githubGraphQLURL()
+	if err != nil {
+		return nil, err
+	}
+	owner, name := repoName()
+	ds := []discussion{}
+	var after *string
+	for page := 0; page < maxDiscussionPages; page++ {
+		b, err := json.Marshal(map[string]interface{}{
+			"query":     discussionsQuery,
+			"variables": map[string]interface{}{"owner": owner, "name": name, "after": after},
+		})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "bearer "+*githubToken)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Data struct {
+				Repository struct {
+					Discussions struct {
+						PageInfo struct {
+							HasNextPage bool   `json:"hasNextPage"`
+							EndCursor   string `json:"endCursor"`
+						} `json:"pageInfo"`
+						Nodes []struct {
+							Number   int    `json:"number"`
+							Title    string `json:"title"`
+							URL      string `json:"url"`
+							Comments struct {
+								TotalCount int `json:"totalCount"`
+							} `json:"comments"`
+						} `json:"nodes"`
+					} `json:"discussions"`
+				} `json:"repository"`
+			} `json:"data"`
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("discussions: %s", resp.Status)
+		case err != nil:
+			return nil, err
+		case len(body.Errors) > 0:
+			return nil, fmt.Errorf("discussions: %s", body.Errors[0].Message)
+		}
+		d := body.Data.Repository.Discussions
+		for _, n := range d.Nodes {
+			ds = append(ds, discussion{Number: n.Number, Title: n.Title, URL: n.URL, Comments: n.Comments.TotalCount})
+		}
+		if !d.PageInfo.HasNextPage {
+			break
+		}
+		after = &d.PageInfo.EndCursor
+	}
+	return ds, nil
+}
+
+// discussionsFlight deduplicates concurrent fetches of the discussions.
+var discussionsFlight flightGroup
+
+// currentDiscussions returns the discussions of the repository, using the
+// shared cache so that instances don't each use up the GitHub API rate limit.
+func currentDiscussions(ctx context.Context) ([]discussion, error) {
+	if b, ok, err := sharedCache.get(ctx, discussionsCacheKey); err == nil && ok {
+		var ds []discussion
+		if err := json.Unmarshal(b, &ds); err == nil {
+			return ds, nil
+		}
+	}
+	v, err := discussionsFlight.do(discussionsCacheKey, func() (interface{}, error) {
+		// The fetch is shared, so it must not be canceled with the
+		// request that started it.
+		ctx, cancel := context.WithTimeout(context.Background(), discussionsFetchTimeout)
+		defer cancel()
+		ds, err := fetchDiscussions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if b, err := json.Marshal(ds); err == nil {
+			sharedCache.set(ctx, discussionsCacheKey, b, discussionsTTL)
+		}
+		return ds, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ds, ok := v.([]discussion)
+	if !ok {
+		return nil, errFlightPanicked
+	}
+	return ds, nil
+}
+
+// findBlogPost returns the published post at the given URL or path.
+func findBlogPost(s string) (blogPost, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Path == "" {
+		return blogPost{}, false
+	}
+	p := u.Path
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	for _, post := range blogPosts() {
+		if post.URL == p {
+			return post, true
+		}
+	}
+	return blogPost{}, false
+}
+
+// commentsFor returns the discussion of the given post: the one numbered in
+// its front matter, or else the one titled as the post or its path, as
+// mapped by giscus.
+func commentsFor(post blogPost, ds []discussion) postComments {
+	c := postComments{Post: post.URL, Title: post.Title}
+	path := strings.Trim(post.URL, "/")
+	for _, d := range ds {
+		if post.discussion != 0 && d.Number != post.discussion {
+			continue
+		}
+		title := strings.TrimSpace(d.Title)
+		if post.discussion != 0 || strings.EqualFold(title, post.Title) || strings.Trim(title, "/") == path {
+			c.Discussion, c.URL, c.Comments = d.Number, d.URL, d.Comments
+			return c
+		}
+	}
+	owner, name := repoName()
+	c.URL = fmt.Sprintf("https://github.com/%s/%s/discussions/new?%s", owner, name, url.Values{
+		"title": {post.Title},
+		"body":  {siteURL(post.URL)},
+	}.Encode())
+	return c
+}
+
+// commentsHandler serves the discussion of the blog post given by the post
+// parameter, as its URL or path, as JSON.
+func commentsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("post")
+		if q == "" {
+			httpError(w, r, "missing post parameter", http.StatusBadRequest)
+			return
+		}
+		post, ok := findBlogPost(q)
+		if !ok {
+			httpError(w, r, "unknown post", http.StatusNotFound)
+			return
+		}
+		ds, err := currentDiscussions(r.Context())
+		if err != nil {
+			log.Printf("Error fetching discussions: %v", err)
+			httpError(w, r, "comments unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(commentsFor(post, ds))
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCommentsHandler(t *testing.T) {
+	blogPostsOnce.Do(func() {})
+	defer func(p []blogPost) { allBlogPosts = p }(allBlogPosts)
+	allBlogPosts = nil
+	for _, src := range []string{
+		"---\ndate: 2020-04-02\ntitle: \"gVisor Networking Security\"\n---\n",
+		"---\ndate: 2019-11-18\ntitle: \"gVisor Security Basics - Part 1\"\ndiscussion: 7\n---\n",
+		"---\ndate: 2019-10-29\ntitle: \"Running gVisor in Production at Scale in Ant\"\n---\n",
+		"---\ndate: 2019-10-01\ntitle: \"Not yet discussed\"\n---\n",
+	} {
+		p, _ := parseBlogPost("", []byte(src))
+		allBlogPosts = append(allBlogPosts, p)
+	}
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req struct {
+			Variables struct {
+				Owner string  `json:"owner"`
+				Name  string  `json:"name"`
+				After *string `json:"after"`
+			} `json:"variables"`
+		}
+		if r.URL.Path != "/graphql" || r.Header.Get("Authorization") != "bearer secret" || json.NewDecoder(r.Body).Decode(&req) != nil || req.Variables.Owner != "google" || req.Variables.Name != "gvisor" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.Variables.After == nil {
+			io.WriteString(w, `{"data": {"repository": {"discussions": {"pageInfo": {"hasNextPage": true, "endCursor": "c1"}, "nodes": [
+				{"number": 3, "title": "gVisor networking security", "url": "https://github.com/google/gvisor/discussions/3", "comments": {"totalCount": 4}},
+				{"number": 5, "title": "gVisor Security Basics - Part 1", "url": "https://github.com/google/gvisor/discussions/5", "comments": {"totalCount": 1}}
+			]}}}}`)
+			return
+		}
+		io.WriteString(w, `{"data": {"repository": {"discussions": {"pageInfo": {"hasNextPage": false}, "nodes": [
+			{"number": 7, "title": "Security basics", "url": "https://github.com/google/gvisor/discussions/7", "comments": {"totalCount": 2}},
+			{"number": 8, "title": "blog/2019/10/29/running-gvisor-in-production-at-scale-in-ant", "url": "https://github.com/google/gvisor/discussions/8", "comments": {"totalCount": 9}}
+		]}}}}`)
+	}))
+	defer srv.Close()
+	defer func(c cache, r, tok string) { sharedCache, *releasesURL, *githubToken = c, r, tok }(sharedCache, *releasesURL, *githubToken)
+	sharedCache = newMemoryCache(100, 1<<20)
+	*releasesURL = srv.URL + "/repos/google/gvisor/releases"
+	*githubToken = "secret"
+
+	h := commentsHandler()
+	for _, tc := range []struct {
+		post string
+		code int
+		want postComments
+	}{
+		// Matched by title.
+		{"/blog/2020/04/02/gvisor-networking-security/", http.StatusOK, postComments{Post: "/blog/2020/04/02/gvisor-networking-security/", Discussion: 3, URL: "https://github.com/google/gvisor/discussions/3", Comments: 4}},
+		// Numbered in the front matter.
+		{"https://gvisor.dev/blog/2019/11/18/gvisor-security-basics-part-1", http.StatusOK, postComments{Post: "/blog/2019/11/18/gvisor-security-basics-part-1/", Discussion: 7, URL: "https://github.com/google/gvisor/discussions/7", Comments: 2}},
+		// Matched by path.
+		{"/blog/2019/10/29/running-gvisor-in-production-at-scale-in-ant/", http.StatusOK, postComments{Post: "/blog/2019/10/29/running-gvisor-in-production-at-scale-in-ant/", Discussion: 8, URL: "https://github.com/google/gvisor/discussions/8", Comments: 9}},
+		{"/blog/2019/10/01/not-yet-discussed/", http.StatusOK, postComments{Post: "/blog/2019/10/01/not-yet-discussed/", URL: "https://github.com/google/gvisor/discussions/new?body=https%3A%2F%2F" + *customHost + "%2Fblog%2F2019%2F10%2F01%2Fnot-yet-discussed%2F&title=Not+yet+discussed"}},
+		{"/blog/2019/10/02/missing/", http.StatusNotFound, postComments{}},
+		{"", http.StatusBadRequest, postComments{}},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/comments?post="+tc.post, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%q: got status %d, want %d", tc.post, rec.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var got postComments
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%q: invalid response %q: %v", tc.post, rec.Body.String(), err)
+		}
+		if got.Discussion != tc.want.Discussion || got.URL != tc.want.URL || got.Comments != tc.want.Comments || got.Post != tc.want.Post {
+			t.Errorf("%q: got %+v, want %+v", tc.post, got, tc.want)
+		}
+	}
+	// The discussions are fetched once, in two pages.
+	if requests != 2 {
+		t.Errorf("got %d upstream requests, want 2", requests)
+	}
+
+	// Without a token, comments are unavailable.
+	sharedCache = newMemoryCache(100, 1<<20)
+	*githubToken = ""
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/comments?post=/blog/2020/04/02/gvisor-networking-security/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a token, got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
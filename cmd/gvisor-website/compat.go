@@ -0,0 +1,347 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compatFile is the compatibility data written into the static dir by
+// generate-syscall-docs.
+const compatFile = "compatibility.json"
+
+// compatDocsPath is the URL path of the per-architecture compatibility
+// tables.
+const compatDocsPath = "/docs/user_guide/compatibility/"
+
+// syscallDoc is the documented support of a syscall on one architecture.
+type syscallDoc struct {
+	Name    string   `json:"name"`
+	Support string   `json:"support"`
+	Note    string   `json:"note,omitempty"`
+	URLs    []string `json:"urls,omitempty"`
+}
+
+// compatInfo maps OS and architecture to the syscalls by number, as written
+// by generate-syscall-docs.
+type compatInfo map[string]map[string]struct {
+	Syscalls map[string]syscallDoc `json:"syscalls"`
+}
+
+// syscallEntry is a syscall on one OS and architecture.
+type syscallEntry struct {
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	Number int    `json:"number"`
+	syscallDoc
+
+	// Anchor is the URL of the syscall in the compatibility tables.
+	Anchor string `json:"anchor"`
+}
+
+// loadSyscalls reads the compatibility data from the static dir, grouping
+// the entries by syscall name.
+func loadSyscalls(staticDir string) (map[string][]syscallEntry, error) {
+	b, err := ioutil.ReadFile(filepath.Join(staticDir, compatFile))
+	if err != nil {
+		return nil, err
+	}
+	var info compatInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	syscalls := make(map[string][]syscallEntry)
+	for osName, arches := range info {
+		for archName, arch := range arches {
+			for num, doc := range arch.Syscalls {
+				n, err := strconv.Atoi(num)
+				if err != nil {
+					continue
+				}
+				syscalls[doc.Name] = append(syscalls[doc.Name], syscallEntry{
+					OS:         osName,
+					Arch:       archName,
+					Number:     n,
+					syscallDoc: doc,
+					Anchor:     compatDocsPath + osName + "/" + archName + "/#" + doc.Name,
+				})
+			}
+		}
+	}
+	for _, entries := range syscalls {
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].OS != entries[j].OS {
+				return entries[i].OS < entries[j].OS
+			}
+			return entries[i].Arch < entries[j].Arch
+		})
+	}
+	return syscalls, nil
+}
+
+var (
+	syscallsOnce sync.Once
+	syscalls     map[string][]syscallEntry
+)
+
+// getSyscalls returns the compatibility data, loading it on first use.
+func getSyscalls(staticDir string) map[string][]syscallEntry {
+	syscallsOnce.Do(func() {
+		var err error
+		syscalls, err = loadSyscalls(staticDir)
+		if err != nil {
+			log.Printf("Error loading compatibility data: %v", err)
+		}
+	})
+	return syscalls
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if d := prev[j] + 1; d < cur[j] {
+				cur[j] = d
+			}
+			if d := cur[j-1] + 1; d < cur[j] {
+				cur[j] = d
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// syscallScore returns how well the syscall matches the lowercased query, or
+// 0 if it doesn't. Names match exactly, by prefix, by substring or within a
+// small edit distance, so that e.g. "epol" finds epoll_wait and "opnat"
+// finds openat. Notes match by substring, so that capabilities such as
+// CAP_SYS_ADMIN can be looked up.
+func syscallScore(name string, entries []syscallEntry, q string) int {
+	switch {
+	case name == q:
+		return 100
+	case strings.HasPrefix(name, q):
+		return 50
+	case strings.Contains(name, q):
+		return 25
+	}
+	maxDist := 1
+	if len(q) > 5 {
+		maxDist = 2
+	}
+	if d := editDistance(name, q); d <= maxDist {
+		return 20 - d
+	}
+	for _, e := range entries {
+		if strings.Contains(strings.ToLower(e.Note), q) {
+			return 5
+		}
+	}
+	return 0
+}
+
+// syscallMatch is a result of a syscall search.
+type syscallMatch struct {
+	Name    string         `json:"name"`
+	Entries []syscallEntry `json:"entries"`
+}
+
+// compatSearchHandler serves /api/compatibility/search?q=..., returning the
+// syscalls matching the query with their support on each architecture. The
+// limit parameter bounds the number of syscalls returned, up to 50.
+func compatSearchHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+		if q == "" || len(q) > 64 {
+			httpError(w, r, "invalid query", http.StatusBadRequest)
+			return
+		}
+		limit := 10
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > 50 {
+				httpError(w, r, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		all := getSyscalls(staticDir)
+		if all == nil {
+			httpError(w, r, "compatibility data is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		type scored struct {
+			syscallMatch
+			score int
+		}
+		var matches []scored
+		for name, entries := range all {
+			if s := syscallScore(name, entries, q); s > 0 {
+				matches = append(matches, scored{syscallMatch{name, entries}, s})
+			}
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			if matches[i].score != matches[j].score {
+				return matches[i].score > matches[j].score
+			}
+			return matches[i].Name < matches[j].Name
+		})
+		results := make([]syscallMatch, 0, limit)
+		for i := 0; i < len(matches) && i < limit; i++ {
+			results = append(results, matches[i].syscallMatch)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(results)
+	})
+}
+
+var (
+	// docAnchorRE matches the syscall anchors generate-syscall-docs writes
+	// into the compatibility tables.
+	docAnchorRE = regexp.MustCompile(`<a class="doc-table-anchor" id="([^"]+)">`)
+
+	// validAnchor matches syscall names in compatibility redirects.
+	validAnchor = regexp.MustCompile(`^[A-Za-z0-9_]+/?$`)
+)
+
+// pageAnchors returns the syscall anchors of the rendered compatibility table
+// at the given URL path in the static dir.
+func pageAnchors(staticDir, page string) (map[string]bool, error) {
+	b, err := readPage(staticDir, page)
+	if err != nil {
+		return nil, err
+	}
+	anchors := make(map[string]bool)
+	for _, m := range docAnchorRE.FindAllSubmatch(b, -1) {
+		anchors[string(m[1])] = true
+	}
+	return anchors, nil
+}
+
+// maxAnchorSuggestions bounds the suggestions for an unknown anchor.
+const maxAnchorSuggestions = 5
+
+// suggestAnchors returns the anchors closest to the given unknown one, best
+// first, using the same matching as the syscall search.
+func suggestAnchors(anchors map[string]bool, id string) []string {
+	type scored struct {
+		anchor string
+		score  int
+	}
+	var matches []scored
+	for a := range anchors {
+		if s := syscallScore(a, nil, id); s > 0 {
+			matches = append(matches, scored{a, s})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].anchor < matches[j].anchor
+	})
+	var suggestions []string
+	for i := 0; i < len(matches) && i < maxAnchorSuggestions; i++ {
+		suggestions = append(suggestions, matches[i].anchor)
+	}
+	return suggestions
+}
+
+// compatPlatform is an OS and architecture with compatibility tables.
+type compatPlatform struct {
+	os   string
+	arch string
+}
+
+// compatPlatforms are the platforms whose tables are linked as
+// /c/<os>/<arch>, redirecting to the tables, and /c/<os>/<arch>/<syscall>,
+// redirecting to the syscall's anchor in them.
+var compatPlatforms = []compatPlatform{
+	{"linux", "amd64"},
+	{"linux", "arm64"},
+}
+
+// page returns the URL path of the platform's compatibility tables.
+func (p compatPlatform) page() string {
+	return compatDocsPath + p.os + "/" + p.arch + "/"
+}
+
+// registerCompatRedirects registers the /c/<os>/<arch> redirects. Syscalls
+// are validated against the anchors of each platform's page.
+func registerCompatRedirects(mux *http.ServeMux, staticDir string) {
+	for _, p := range compatPlatforms {
+		path := "/c/" + p.os + "/" + p.arch
+		anchors, err := pageAnchors(staticDir, p.page())
+		if err != nil {
+			log.Printf("Error reading anchors of %s, not validating %s/ redirects: %v", p.page(), path, err)
+		}
+		mux.Handle(path, siteChain("redirect").then(redirectHandler(p.page())))
+		mux.Handle(path+"/", siteChain("prefix-redirect").then(compatRedirectHandler(path+"/", p.page()+"#%s", anchors)))
+	}
+}
+
+// compatRedirectHandler redirects /c/<os>/<arch>/<syscall> to the syscall in
+// the compatibility tables, like prefixRedirectHandler. Syscalls that aren't
+// in the given anchors of the page are not found, with suggestions in the
+// language the client prefers, rather than redirecting to the top of the page. If anchors is nil, all syscalls
+// are redirected.
+func compatRedirectHandler(prefix, baseURL string, anchors map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := r.URL.Path; p == prefix {
+			// Redirect /prefix/ to /prefix.
+			http.Redirect(w, r, p[:len(p)-1], http.StatusFound)
+			return
+		}
+		id := r.URL.Path[len(prefix):]
+		if !validAnchor.MatchString(id) {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		id = strings.ToLower(strings.TrimSuffix(id, "/"))
+		if anchors != nil && !anchors[id] {
+			lang := pageLanguage(w, r)
+			msg := translate(lang, "unknown_syscall", id)
+			if s := suggestAnchors(anchors, id); len(s) > 0 {
+				msg += "; " + translate(lang, "did_you_mean", strings.Join(s, ", "))
+			}
+			httpError(w, r, msg, http.StatusNotFound)
+			return
+		}
+		redirectWithQuery(w, r, fmt.Sprintf(baseURL, id))
+	})
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"open", "", 4},
+		{"", "open", 4},
+		{"openat", "openat", 0},
+		{"openat", "opnat", 1},
+		{"read", "raed", 2},
+		{"epoll_wait", "epoll_pwait", 1},
+	} {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestSyscallScore(t *testing.T) {
+	entries := []syscallEntry{{syscallDoc: syscallDoc{Note: "Returns EPERM without CAP_SYS_ADMIN."}}}
+	for _, tc := range []struct {
+		name, q string
+		want    int
+	}{
+		{"epoll_wait", "epoll_wait", 100},
+		{"epoll_wait", "epoll", 50},
+		{"epoll_pwait", "pwait", 25},
+		{"openat", "opnat", 19},
+		{"openat", "openxx", 18},
+		{"read", "rxxd", 0},
+		{"mount", "cap_sys_admin", 5},
+		{"mount", "cap_net_raw", 0},
+	} {
+		if got := syscallScore(tc.name, entries, tc.q); got != tc.want {
+			t.Errorf("syscallScore(%q, %q) = %d, want %d", tc.name, tc.q, got, tc.want)
+		}
+	}
+}
+
+func TestLoadSyscalls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := `{"linux": {
+		"amd64": {"syscalls": {"232": {"name": "epoll_wait", "support": "Full"}}},
+		"arm64": {"syscalls": {"22": {"name": "epoll_pwait", "support": "Partial", "note": "No signals."}, "x": {"name": "bad"}}}
+	}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, compatFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadSyscalls(dir)
+	if err != nil {
+		t.Fatalf("loadSyscalls failed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("loadSyscalls returned %d syscalls, want 2", len(got))
+	}
+	e := got["epoll_pwait"]
+	if len(e) != 1 || e[0].Arch != "arm64" || e[0].Number != 22 || e[0].Note != "No signals." {
+		t.Errorf("epoll_pwait = %+v", e)
+	}
+	if want := "/docs/user_guide/compatibility/linux/amd64/#epoll_wait"; len(got["epoll_wait"]) != 1 || got["epoll_wait"][0].Anchor != want {
+		t.Errorf("epoll_wait = %+v, want anchor %q", got["epoll_wait"], want)
+	}
+}
+
+func TestCompatRedirectHandler(t *testing.T) {
+	anchors := map[string]bool{"epoll_wait": true, "epoll_pwait": true, "openat": true}
+	for _, tc := range []struct {
+		anchors  map[string]bool
+		path     string
+		code     int
+		location string
+		body     string
+	}{
+		{anchors, "/c/linux/amd64/openat", http.StatusFound, "/docs/user_guide/compatibility/linux/amd64/#openat", ""},
+		{anchors, "/c/linux/amd64/OpenAt/", http.StatusFound, "/docs/user_guide/compatibility/linux/amd64/#openat", ""},
+		{anchors, "/c/linux/amd64/", http.StatusFound, "/c/linux/amd64", ""},
+		{anchors, "/c/linux/amd64/epoll", http.StatusNotFound, "", "did you mean epoll_pwait, epoll_wait?"},
+		{anchors, "/c/linux/amd64/nosuchcall", http.StatusNotFound, "", `Unknown syscall "nosuchcall"`},
+		{anchors, "/c/linux/amd64/a.b", http.StatusNotFound, "", "Not found"},
+		{nil, "/c/linux/amd64/anything", http.StatusFound, "/docs/user_guide/compatibility/linux/amd64/#anything", ""},
+	} {
+		h := compatRedirectHandler("/c/linux/amd64/", "/docs/user_guide/compatibility/linux/amd64/#%s", tc.anchors)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code {
+			t.Errorf("GET %s = %d, want %d", tc.path, w.Code, tc.code)
+		}
+		if got := w.Header().Get("Location"); got != tc.location {
+			t.Errorf("GET %s redirected to %q, want %q", tc.path, got, tc.location)
+		}
+		if !strings.Contains(w.Body.String(), tc.body) {
+			t.Errorf("GET %s = %q, want %q", tc.path, w.Body.String(), tc.body)
+		}
+	}
+}
+
+func TestPageAnchors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anchors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	page := filepath.Join(dir, "docs", "user_guide", "compatibility", "linux", "amd64")
+	if err := os.MkdirAll(page, 0755); err != nil {
+		t.Fatal(err)
+	}
+	html := `<div id="nav"></div><td><a class="doc-table-anchor" id="read"></a>0</td><td><a class="doc-table-anchor" id="write"></a>1</td>`
+	if err := ioutil.WriteFile(filepath.Join(page, "index.html"), []byte(html), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := pageAnchors(dir, "/docs/user_guide/compatibility/linux/amd64/")
+	if err != nil {
+		t.Fatalf("pageAnchors failed: %v", err)
+	}
+	if want := map[string]bool{"read": true, "write": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pageAnchors = %v, want %v", got, want)
+	}
+	if _, err := pageAnchors(dir, "/missing/"); err == nil {
+		t.Errorf("pageAnchors of a missing page succeeded")
+	}
+}
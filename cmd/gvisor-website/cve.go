@@ -0,0 +1,179 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// cvesFile is the curated CVE statuses, which is maintained in the static
+// dir.
+const cvesFile = "cves.json"
+
+// cvePrefix is the path of the CVE lookup API.
+const cvePrefix = "/api/cve/"
+
+// CVE statuses. CVEs with an advisory are fixed if it names the patched
+// versions.
+const (
+	cveFixed              = "fixed"
+	cveAffected           = "affected"
+	cveNotAffected        = "not_affected"
+	cveUnderInvestigation = "under_investigation"
+)
+
+// curatedCVE is a CVE whose status is maintained in the curated data file,
+// e.g. because gVisor is not affected by a vulnerability in the Linux kernel
+// or other runtimes.
+type curatedCVE struct {
+	ID           string   `json:"id"`
+	Status       string   `json:"status"`
+	FixedRelease string   `json:"fixed_release,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	References   []string `json:"references,omitempty"`
+}
+
+// loadCVEs reads the curated CVEs from the static dir, by ID.
+func loadCVEs(staticDir string) (map[string]curatedCVE, error) {
+	b, err := ioutil.ReadFile(filepath.Join(staticDir, cvesFile))
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		CVEs []curatedCVE `json:"cves"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	cves := make(map[string]curatedCVE)
+	for _, c := range data.CVEs {
+		if !cveIDRE.MatchString(c.ID) {
+			return nil, fmt.Errorf("invalid CVE ID %q", c.ID)
+		}
+		switch c.Status {
+		case cveFixed, cveAffected, cveNotAffected, cveUnderInvestigation:
+		default:
+			return nil, fmt.Errorf("%s: invalid status %q", c.ID, c.Status)
+		}
+		if _, ok := cves[c.ID]; ok {
+			return nil, fmt.Errorf("%s is listed twice", c.ID)
+		}
+		cves[c.ID] = c
+	}
+	return cves, nil
+}
+
+var (
+	cvesOnce sync.Once
+	cves     map[string]curatedCVE
+)
+
+// getCVEs returns the curated CVEs, loading them on first use.
+func getCVEs(staticDir string) map[string]curatedCVE {
+	cvesOnce.Do(func() {
+		var err error
+		cves, err = loadCVEs(staticDir)
+		if err != nil {
+			log.Printf("Error loading curated CVEs: %v", err)
+		}
+	})
+	return cves
+}
+
+// cveStatus is served by /api/cve/<id>.
+type cveStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+
+	// FixedRelease is the first release with the fix, if any.
+	FixedRelease string   `json:"fixed_release,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	References   []string `json:"references,omitempty"`
+
+	// Advisory is the URL of the security advisory of the CVE, if any.
+	Advisory string `json:"advisory,omitempty"`
+}
+
+// lookupCVE returns the status of the CVE from the curated CVEs and the
+// advisories, or nil if neither has it. Curated statuses take precedence over
+// those derived from advisories.
+func lookupCVE(id string, curated map[string]curatedCVE, advisories []advisory) *cveStatus {
+	var s *cveStatus
+	for _, a := range advisories {
+		if a.CVE != id || a.Withdrawn != nil {
+			continue
+		}
+		s = &cveStatus{ID: id, Status: cveAffected, Note: a.Summary, Advisory: a.Shortlink}
+		for _, v := range a.Affected {
+			if v.Patched != "" {
+				s.Status, s.FixedRelease = cveFixed, v.Patched
+				break
+			}
+		}
+		break
+	}
+	if c, ok := curated[id]; ok {
+		if s == nil {
+			s = &cveStatus{ID: id}
+		}
+		s.Status, s.References = c.Status, c.References
+		if c.FixedRelease != "" {
+			s.FixedRelease = c.FixedRelease
+		}
+		if c.Note != "" {
+			s.Note = c.Note
+		}
+	}
+	return s
+}
+
+// cveHandler serves the status of the CVE in the path in gVisor.
+func cveHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, cvePrefix), "/"))
+		if !cveIDRE.MatchString(id) {
+			httpError(w, r, "invalid CVE ID", http.StatusBadRequest)
+			return
+		}
+		curated := getCVEs(staticDir)
+		var advisories []advisory
+		e, err := securityAdvisories(r.Context())
+		if err != nil {
+			log.Printf("Error fetching security advisories: %v", err)
+		} else {
+			advisories = e.Advisories
+		}
+		s := lookupCVE(id, curated, advisories)
+		if s == nil {
+			if err != nil || curated == nil {
+				// Without both sources, the CVE may be known.
+				httpError(w, r, "CVE data is unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			httpError(w, r, "unknown CVE "+id, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(s)
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLookupCVE(t *testing.T) {
+	curated := map[string]curatedCVE{
+		"CVE-2019-5736": {ID: "CVE-2019-5736", Status: cveNotAffected, Note: "runc only."},
+		"CVE-2020-1234": {ID: "CVE-2020-1234", Status: cveFixed, References: []string{"https://example.com"}},
+		"CVE-2020-9999": {ID: "CVE-2020-9999", Status: cveUnderInvestigation},
+	}
+	advisories := []advisory{
+		{ID: "GHSA-2222-3333-4444", CVE: "CVE-2020-1234", Summary: "Sandbox escape", Shortlink: "https://gvisor.dev/advisory/GHSA-2222-3333-4444",
+			Affected: []advisoryVulnerability{{Vulnerable: "< 20200127.0", Patched: "20200127.0"}}},
+		{ID: "GHSA-5555-6666-7777", CVE: "CVE-2020-5555", Summary: "Denial of service", Shortlink: "https://gvisor.dev/advisory/GHSA-5555-6666-7777"},
+	}
+	for _, tc := range []struct {
+		id   string
+		want *cveStatus
+	}{
+		{"CVE-2019-5736", &cveStatus{ID: "CVE-2019-5736", Status: cveNotAffected, Note: "runc only."}},
+		{"CVE-2020-1234", &cveStatus{ID: "CVE-2020-1234", Status: cveFixed, FixedRelease: "20200127.0", Note: "Sandbox escape", References: []string{"https://example.com"}, Advisory: "https://gvisor.dev/advisory/GHSA-2222-3333-4444"}},
+		{"CVE-2020-5555", &cveStatus{ID: "CVE-2020-5555", Status: cveAffected, Note: "Denial of service", Advisory: "https://gvisor.dev/advisory/GHSA-5555-6666-7777"}},
+		{"CVE-2020-9999", &cveStatus{ID: "CVE-2020-9999", Status: cveUnderInvestigation}},
+		{"CVE-2018-0001", nil},
+	} {
+		if got := lookupCVE(tc.id, curated, advisories); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("lookupCVE(%q) = %+v, want %+v", tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestCVEHandler(t *testing.T) {
+	defer func(c cache, u string) { sharedCache, *advisoriesURL = c, u }(sharedCache, *advisoriesURL)
+	sharedCache = newMemoryCache(100, 1<<20)
+	srv := newAdvisoriesTestServer(t)
+	defer srv.Close()
+	*advisoriesURL = srv.URL + "/advisories"
+
+	dir, err := ioutil.TempDir("", "cves")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := `{"cves": [{"id": "CVE-2019-5736", "status": "not_affected", "note": "runc only."}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, cvesFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cvesOnce, cves = sync.Once{}, nil
+	defer func() { cvesOnce, cves = sync.Once{}, nil }()
+
+	h := cveHandler(dir)
+	for _, tc := range []struct {
+		path   string
+		code   int
+		status string
+	}{
+		{"/api/cve/CVE-2019-5736", http.StatusOK, cveNotAffected},
+		{"/api/cve/cve-2020-1234/", http.StatusOK, cveFixed},
+		{"/api/cve/CVE-2018-0001", http.StatusNotFound, ""},
+		{"/api/cve/GHSA-2222-3333-4444", http.StatusBadRequest, ""},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.path, rec.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var s cveStatus
+		if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+			t.Fatalf("%s: Decode failed: %v", tc.path, err)
+		}
+		if s.Status != tc.status {
+			t.Errorf("%s: got status %q, want %q", tc.path, s.Status, tc.status)
+		}
+	}
+
+	// Unknown CVEs can't be reported as such without the advisories.
+	sharedCache = newMemoryCache(100, 1<<20)
+	srv.fail = true
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/cve/CVE-2018-0001", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without advisories: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/cve/CVE-2019-5736", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("curated CVE without advisories: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestLoadCVEs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cves")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, data := range []string{
+		`{"cves": [{"id": "CVE-19-1", "status": "fixed"}]}`,
+		`{"cves": [{"id": "CVE-2019-5736", "status": "safe"}]}`,
+		`{"cves": [{"id": "CVE-2019-5736", "status": "fixed"}, {"id": "CVE-2019-5736", "status": "fixed"}]}`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, cvesFile), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadCVEs(dir); err == nil {
+			t.Errorf("loadCVEs of %s succeeded, want error", data)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redirectDeprecation marks a redirect as deprecated, so that it can be
+// retired gracefully. Browsers are shown an interstitial page explaining the
+// deprecation before they are redirected; other clients are redirected with
+// Deprecation and Sunset headers. After the sunset, the link is gone.
+type redirectDeprecation struct {
+	// note explains the deprecation to users. It is the key of the message
+	// in the catalog, so that it is translated.
+	note string
+
+	// replacement is the link to use instead, if any.
+	replacement string
+
+	// sunset is when the link stops redirecting, or zero if that isn't
+	// planned yet.
+	sunset time.Time
+}
+
+// interstitialDelay is how long the interstitial page is shown before
+// redirecting.
+const interstitialDelay = 5 * time.Second
+
+// deprecatedRedirects are the deprecated redirects, by the pattern they are
+// registered at.
+var deprecatedRedirects = map[string]redirectDeprecation{
+	"/cl":  {note: "deprecation_gerrit", replacement: "/pr"},
+	"/cl/": {note: "deprecation_gerrit", replacement: "/pr"},
+}
+
+var deprecatedRedirectRequests = newCounter("deprecated_redirect_requests_total", "Requests for deprecated redirects, by pattern and response: interstitial, redirect or gone.", "pattern", "response")
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!doctype html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+{{if not .Gone}}<meta http-equiv="refresh" content="{{.Delay}}; url={{.Target}}">{{end}}
+<title>{{if .Gone}}{{.T "retired_title"}}{{else}}{{.T "deprecated_title"}}{{end}} - gVisor</title>
+<style>
+body { font-family: "Roboto", sans-serif; margin: 0; color: #222; line-height: 1.5; }
+header { background: #262362; color: #fff; padding: 1em 2em; font-size: 1.5em; }
+header a { color: #fff; text-decoration: none; }
+main { margin: 2em; max-width: 50em; }
+a { color: #286FD7; }
+</style>
+</head>
+<body>
+<header><a href="/">gVisor</a></header>
+<main>
+{{if .Gone}}
+<h1>{{.T "retired_heading"}}</h1>
+<p>{{.T "retired_body" .Path .Sunset}} {{.T .Note}}</p>
+<p>{{.T "retired_target"}} <a href="{{.Target}}">{{.Target}}</a></p>
+{{else}}
+<h1>{{.T "deprecated_heading"}}</h1>
+<p>{{.T "deprecated_body" .Path}}{{if .Sunset}} {{.T "deprecated_sunset" .Sunset}}{{end}} {{.T .Note}}</p>
+<p>{{.T "deprecated_redirecting" .Delay}} <a href="{{.Target}}">{{.Target}}</a></p>
+{{end}}
+{{if .Replacement}}<p>{{.T "use_replacement"}} <a href="{{.Replacement}}">{{.Replacement}}</a></p>{{end}}
+</main>
+</body>
+</html>
+`))
+
+// interstitialData is passed to the interstitial template.
+type interstitialData struct {
+	Path, Target, Note, Replacement string
+	// Sunset is the formatted sunset date, or "" if there is none.
+	Sunset string
+	Delay  int
+	Gone   bool
+	Lang   string
+}
+
+// T returns the message with the given key in the language of the page.
+func (d interstitialData) T(key string, args ...interface{}) string {
+	return translate(d.Lang, key, args...)
+}
+
+// redirectRecorder records the response of a redirect handler, so that it
+// can be served differently.
+type redirectRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *redirectRecorder) Header() http.Header { return r.header }
+
+func (r *redirectRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (r *redirectRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// deprecationMiddleware returns the middleware applying the deprecation of
+// the redirect registered at the pattern, if it is deprecated.
+func deprecationMiddleware(pattern string) middleware {
+	return middleware{"deprecation", func(h http.Handler) http.Handler {
+		d, ok := deprecatedRedirects[pattern]
+		if !ok {
+			return h
+		}
+		return deprecatedRedirectHandler(pattern, d, h)
+	}}
+}
+
+// deprecatedRedirectHandler serves the deprecated redirect of h. Responses
+// that aren't redirects, e.g. for invalid IDs, are served as is.
+func deprecatedRedirectHandler(pattern string, d redirectDeprecation, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &redirectRecorder{header: make(http.Header)}
+		h.ServeHTTP(rec, r)
+		target := rec.header.Get("Location")
+		if rec.status < 300 || rec.status >= 400 || target == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hdr := w.Header()
+		// Browsers are served the interstitial, in their language.
+		addVary(hdr, "User-Agent", "Accept-Language")
+		hdr.Set("Deprecation", "true")
+		if !d.sunset.IsZero() {
+			hdr.Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.replacement != "" {
+			hdr.Add("Link", "<"+d.replacement+`>; rel="successor-version"`)
+		}
+		gone := !d.sunset.IsZero() && !time.Now().Before(d.sunset)
+		if !gone && trafficClass(r) != classBrowser {
+			deprecatedRedirectRequests.inc(pattern, "redirect")
+			for k, v := range rec.header {
+				hdr[k] = v
+			}
+			w.WriteHeader(rec.status)
+			return
+		}
+		status, response := http.StatusOK, "interstitial"
+		if gone {
+			status, response = http.StatusGone, "gone"
+		}
+		deprecatedRedirectRequests.inc(pattern, response)
+		lang := pageLanguage(w, r)
+		data := interstitialData{
+			Path:        r.URL.Path,
+			Target:      target,
+			Note:        d.note,
+			Replacement: d.replacement,
+			Delay:       int(interstitialDelay.Seconds()),
+			Gone:        gone,
+			Lang:        lang,
+		}
+		if !d.sunset.IsZero() {
+			data.Sunset = formatDate(lang, d.sunset)
+		}
+		var b strings.Builder
+		if err := interstitialTemplate.Execute(&b, data); err != nil {
+			log.Printf("Error rendering interstitial of %s: %v", r.URL.Path, err)
+			httpError(w, r, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		hdr.Set("Content-Type", "text/html; charset=utf-8")
+		hdr.Set("Content-Language", lang)
+		hdr.Set("Content-Length", strconv.Itoa(b.Len()))
+		hdr.Set("Cache-Control", "no-cache")
+		hdr.Set("X-Robots-Tag", "noindex")
+		w.WriteHeader(status)
+		if r.Method != "HEAD" {
+			w.Write([]byte(b.String()))
+		}
+	})
+}
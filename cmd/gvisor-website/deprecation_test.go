@@ -0,0 +1,122 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeprecatedRedirectHandler(t *testing.T) {
+	const browser = "Mozilla/5.0 (X11; Linux x86_64)"
+	deprecated := redirectDeprecation{note: "Moved.", replacement: "/pr"}
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name       string
+		d          redirectDeprecation
+		url        string
+		ua         string
+		wantCode   int
+		wantLoc    string
+		wantSunset string
+		wantBody   string
+	}{
+		{
+			name:     "tool",
+			d:        deprecated,
+			url:      "/cl/123",
+			ua:       "curl/7.64.0",
+			wantCode: http.StatusFound,
+			wantLoc:  "https://gvisor-review.googlesource.com/c/gvisor/+/123",
+		},
+		{
+			name:     "browser",
+			d:        deprecated,
+			url:      "/cl/123",
+			ua:       browser,
+			wantCode: http.StatusOK,
+			wantBody: `<meta http-equiv="refresh" content="5; url=https://gvisor-review.googlesource.com/c/gvisor/&#43;/123">`,
+		},
+		{
+			name:       "sunset planned",
+			d:          redirectDeprecation{note: "Moved.", sunset: sunset},
+			url:        "/cl/123",
+			ua:         browser,
+			wantCode:   http.StatusOK,
+			wantSunset: "Tue, 01 Jan 2030 00:00:00 GMT",
+			wantBody:   "will stop working on January 1, 2030",
+		},
+		{
+			name:       "sunset passed",
+			d:          redirectDeprecation{note: "Moved.", sunset: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+			url:        "/cl/123",
+			ua:         "curl/7.64.0",
+			wantCode:   http.StatusGone,
+			wantSunset: "Tue, 01 Jan 2019 00:00:00 GMT",
+			wantBody:   "stopped working on January 1, 2019",
+		},
+		{
+			name:     "not a redirect",
+			d:        deprecated,
+			url:      "/cl/a_b",
+			ua:       browser,
+			wantCode: http.StatusNotFound,
+		},
+	} {
+		h := classifyHandler("test", deprecatedRedirectHandler("/cl/", tc.d, prefixRedirectHandler("/cl/", prefixHelpers["cl"])))
+		req := httptest.NewRequest("GET", tc.url, nil)
+		req.Header.Set("User-Agent", tc.ua)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.wantCode)
+			continue
+		}
+		if got := w.Header().Get("Location"); got != tc.wantLoc {
+			t.Errorf("%s: got Location %q, want %q", tc.name, got, tc.wantLoc)
+		}
+		if got := w.Header().Get("Sunset"); got != tc.wantSunset {
+			t.Errorf("%s: got Sunset %q, want %q", tc.name, got, tc.wantSunset)
+		}
+		if tc.wantCode == http.StatusNotFound {
+			continue
+		}
+		if got := w.Header().Get("Deprecation"); got != "true" {
+			t.Errorf("%s: got Deprecation %q, want true", tc.name, got)
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "User-Agent") {
+			t.Errorf("%s: Vary %q does not include User-Agent", tc.name, w.Header().Get("Vary"))
+		}
+		if tc.d.replacement != "" && w.Header().Get("Link") != `</pr>; rel="successor-version"` {
+			t.Errorf("%s: got Link %q, want the replacement", tc.name, w.Header().Get("Link"))
+		}
+		if !strings.Contains(w.Body.String(), tc.wantBody) {
+			t.Errorf("%s: body %q does not contain %q", tc.name, w.Body.String(), tc.wantBody)
+		}
+	}
+}
+
+func TestDeprecatedRedirectsAreRegistered(t *testing.T) {
+	for pattern := range deprecatedRedirects {
+		_, exact := redirects[pattern]
+		_, prefix := prefixHelpers[strings.Trim(pattern, "/")]
+		if !exact && !(prefix && strings.HasSuffix(pattern, "/")) {
+			t.Errorf("deprecated redirect %s is not a redirect", pattern)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redirectRecord is a single dynamic redirect.
+type redirectRecord struct {
+	Target string
+
+	// Expires is the time after which the redirect is no longer served,
+	// e.g. for time-limited invite links. It is zero if the redirect does
+	// not expire.
+	Expires time.Time
+}
+
+// expired returns true if the redirect has expired at the given time.
+func (r redirectRecord) expired(now time.Time) bool {
+	return !r.Expires.IsZero() && now.After(r.Expires)
+}
+
+// redirectBackend persists dynamic redirects.
+type redirectBackend interface {
+	// load returns all stored redirects, keyed by path.
+	load(ctx context.Context) (map[string]redirectRecord, error)
+
+	// put stores the redirect for the given path.
+	put(ctx context.Context, path string, rec redirectRecord) error
+
+	// remove deletes the redirect for the given path.
+	remove(ctx context.Context, path string) error
+}
+
+// dynamicRedirects is the table of redirects that are added at runtime via the
+// admin API, as opposed to the static redirects compiled into the binary.
+//
+// Lookups are served from a local copy of the table, which is periodically
+// synced from the backend so that all serving instances converge.
+type dynamicRedirects struct {
+	// backend is nil if redirects are only kept in memory.
+	backend redirectBackend
+
+	mu sync.RWMutex
+	m  map[string]redirectRecord
+}
+
+// newDynamicRedirects returns a dynamic redirect table for the given store
+// type, which is either "memory" or "firestore".
+func newDynamicRedirects(ctx context.Context, store string) (*dynamicRedirects, error) {
+	d := &dynamicRedirects{m: make(map[string]redirectRecord)}
+	switch store {
+	case "", "memory":
+	case "firestore":
+		fs, err := newFirestoreClient(ctx, *projectId)
+		if err != nil {
+			return nil, err
+		}
+		d.backend = &firestoreRedirects{fs: fs}
+	default:
+		return nil, fmt.Errorf("unknown redirect store %q", store)
+	}
+	return d, nil
+}
+
+var expiredRedirects = newCounter("dynamic_redirect_expired_total", "Requests for dynamic redirects that have expired.", "path")
+
+// lookup returns the target for the given path. Expired redirects are not
+// returned.
+func (d *dynamicRedirects) lookup(path string) (string, bool) {
+	d.mu.RLock()
+	rec, ok := d.m[path]
+	d.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	if rec.expired(time.Now()) {
+		expiredRedirects.inc(path)
+		return "", false
+	}
+	return rec.Target, true
+}
+
+// all returns a copy of the table, including expired redirects.
+func (d *dynamicRedirects) all() map[string]redirectRecord {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	m := make(map[string]redirectRecord, len(d.m))
+	for path, rec := range d.m {
+		m[path] = rec
+	}
+	return m
+}
+
+// set adds or replaces a redirect.
+func (d *dynamicRedirects) set(ctx context.Context, path string, rec redirectRecord) error {
+	if d.backend != nil {
+		if err := d.backend.put(ctx, path, rec); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	d.m[path] = rec
+	d.mu.Unlock()
+	return nil
+}
+
+// remove deletes a redirect.
+func (d *dynamicRedirects) remove(ctx context.Context, path string) error {
+	if d.backend != nil {
+		if err := d.backend.remove(ctx, path); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	delete(d.m, path)
+	d.mu.Unlock()
+	return nil
+}
+
+// sync replaces the local table with the backend contents.
+func (d *dynamicRedirects) sync(ctx context.Context) error {
+	if d.backend == nil {
+		return nil
+	}
+	m, err := d.backend.load(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.m = m
+	d.mu.Unlock()
+	return nil
+}
+
+// syncLoop periodically syncs the table until the context is cancelled. On
+// error, the last successfully loaded table continues to be served.
+func (d *dynamicRedirects) syncLoop(ctx context.Context, interval time.Duration) {
+	if d.backend == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.sync(ctx); err != nil {
+				log.Printf("Error syncing dynamic redirects: %v", err)
+			}
+		}
+	}
+}
+
+// redirectCollection is the Firestore collection holding dynamic redirects.
+const redirectCollection = "redirects"
+
+// firestoreRedirects stores redirects in Firestore, one document per path.
+type firestoreRedirects struct {
+	fs *firestoreClient
+}
+
+// docID returns the document ID for the given path. Firestore document IDs
+// may not contain slashes, so the path is encoded.
+func (*firestoreRedirects) docID(path string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(path))
+}
+
+func (f *firestoreRedirects) load(ctx context.Context) (map[string]redirectRecord, error) {
+	docs, err := f.fs.list(ctx, redirectCollection)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]redirectRecord, len(docs))
+	for _, doc := range docs {
+		path, target := doc.Fields["path"].str(), doc.Fields["target"].str()
+		if path == "" || target == "" {
+			continue
+		}
+		m[path] = redirectRecord{
+			Target:  target,
+			Expires: doc.Fields["expires"].time(),
+		}
+	}
+	return m, nil
+}
+
+func (f *firestoreRedirects) put(ctx context.Context, path string, rec redirectRecord) error {
+	fields := map[string]firestoreValue{
+		"path":   stringValue(path),
+		"target": stringValue(rec.Target),
+	}
+	if !rec.Expires.IsZero() {
+		fields["expires"] = timestampValue(rec.Expires)
+	}
+	return f.fs.set(ctx, redirectCollection, f.docID(path), fields)
+}
+
+func (f *firestoreRedirects) remove(ctx context.Context, path string) error {
+	return f.fs.delete(ctx, redirectCollection, f.docID(path))
+}
+
+// dynamicRedirectHandler serves redirects from the dynamic table, falling
+// through to the given handler for all other paths.
+func dynamicRedirectHandler(d *dynamicRedirects, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := d.lookup(r.URL.Path); ok {
+			redirectWithQuery(w, r, target)
+			return
+		}
+		// Fallthrough.
+		h.ServeHTTP(w, r)
+	})
+}
+
+// redirectEntry is the admin API representation of a dynamic redirect.
+type redirectEntry struct {
+	Path    string     `json:"path"`
+	Target  string     `json:"target"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// adminRedirectsHandler returns a handler for listing (GET), adding or
+// replacing (POST) and removing (DELETE) dynamic redirects.
+func adminRedirectsHandler(d *dynamicRedirects) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			var entries []redirectEntry
+			for path, rec := range d.all() {
+				e := redirectEntry{Path: path, Target: rec.Target}
+				if !rec.Expires.IsZero() {
+					expires := rec.Expires
+					e.Expires = &expires
+				}
+				entries = append(entries, e)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entries)
+		case "POST":
+			var e redirectEntry
+			if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !strings.HasPrefix(e.Path, "/") || e.Target == "" {
+				httpError(w, r, "invalid request: path must be absolute and target non-empty", http.StatusBadRequest)
+				return
+			}
+			rec := redirectRecord{Target: e.Target}
+			if e.Expires != nil {
+				rec.Expires = *e.Expires
+			}
+			if err := d.set(r.Context(), e.Path, rec); err != nil {
+				httpError(w, r, "store error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "DELETE":
+			path := r.URL.Query().Get("path")
+			if path == "" {
+				httpError(w, r, "invalid request: missing path", http.StatusBadRequest)
+				return
+			}
+			if err := d.remove(r.Context(), path); err != nil {
+				httpError(w, r, "store error: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// communityLinks are shortlinks to community resources whose targets change
+// over time, such as expiring chat invites. The targets here are defaults,
+// used until they are overridden (or when the override expires) in the
+// dynamic redirect table via the admin API.
+var communityLinks = map[string]string{
+	"/chat":            "https://gitter.im/gvisor/community",
+	"/slack":           "/docs/community/",
+	"/mailinglist":     "https://groups.google.com/forum/#!forum/gvisor-users",
+	"/mailinglist/dev": "https://groups.google.com/forum/#!forum/gvisor-dev",
+	"/meeting":         "https://calendar.google.com/calendar/embed?src=bd6f4k210u3ukmlj9b8vl053fk%40group.calendar.google.com",
+}
+
+// managedRedirectHandler returns a handler that redirects to the target for
+// the given path in the dynamic table, or to the default if there is none.
+func managedRedirectHandler(d *dynamicRedirects, path, def string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, ok := d.lookup(path)
+		if !ok {
+			target = def
+		}
+		redirectWithQuery(w, r, target)
+	})
+}
@@ -0,0 +1,256 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRedirectBackend is a redirect backend kept in memory.
+type fakeRedirectBackend struct {
+	m   map[string]redirectRecord
+	err error
+}
+
+func (f *fakeRedirectBackend) load(ctx context.Context) (map[string]redirectRecord, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	m := make(map[string]redirectRecord, len(f.m))
+	for path, rec := range f.m {
+		m[path] = rec
+	}
+	return m, nil
+}
+
+func (f *fakeRedirectBackend) put(ctx context.Context, path string, rec redirectRecord) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.m[path] = rec
+	return nil
+}
+
+func (f *fakeRedirectBackend) remove(ctx context.Context, path string) error {
+	if f.err != nil {
+		return f.err
+	}
+	delete(f.m, path)
+	return nil
+}
+
+func TestNewDynamicRedirects(t *testing.T) {
+	for _, store := range []string{"", "memory"} {
+		d, err := newDynamicRedirects(context.Background(), store)
+		if err != nil || d.backend != nil {
+			t.Errorf("newDynamicRedirects(%q) = %+v, %v, want a memory table", store, d, err)
+		}
+	}
+	if _, err := newDynamicRedirects(context.Background(), "redis"); err == nil {
+		t.Errorf("newDynamicRedirects(redis) succeeded, want an error")
+	}
+}
+
+func TestDynamicRedirects(t *testing.T) {
+	ctx := context.Background()
+	backend := &fakeRedirectBackend{m: make(map[string]redirectRecord)}
+	d := &dynamicRedirects{backend: backend, m: make(map[string]redirectRecord)}
+
+	if err := d.set(ctx, "/chat", redirectRecord{Target: "https://chat.example.com/"}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if err := d.set(ctx, "/invite", redirectRecord{Target: "https://invite.example.com/", Expires: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if target, ok := d.lookup("/chat"); !ok || target != "https://chat.example.com/" {
+		t.Errorf("lookup(/chat) = %q, %t, want the stored target", target, ok)
+	}
+	if target, ok := d.lookup("/invite"); ok {
+		t.Errorf("lookup(/invite) = %q, want the expired redirect not to be served", target)
+	}
+	if _, ok := d.all()["/invite"]; !ok {
+		t.Errorf("all() doesn't list the expired redirect")
+	}
+	if len(backend.m) != 2 {
+		t.Errorf("got %d stored redirects, want 2", len(backend.m))
+	}
+
+	// Failed writes leave the table unchanged.
+	backend.err = errors.New("unavailable")
+	if err := d.set(ctx, "/slack", redirectRecord{Target: "/docs/community/"}); err == nil {
+		t.Errorf("set with a failing backend succeeded")
+	}
+	if _, ok := d.lookup("/slack"); ok {
+		t.Errorf("failed set was applied to the table")
+	}
+	if err := d.remove(ctx, "/chat"); err == nil {
+		t.Errorf("remove with a failing backend succeeded")
+	}
+	if err := d.sync(ctx); err == nil {
+		t.Errorf("sync with a failing backend succeeded")
+	}
+	if _, ok := d.lookup("/chat"); !ok {
+		t.Errorf("failed remove or sync changed the table")
+	}
+
+	// Other instances' changes are picked up by the sync.
+	backend.err = nil
+	backend.m["/meeting"] = redirectRecord{Target: "https://meet.example.com/"}
+	delete(backend.m, "/chat")
+	if err := d.sync(ctx); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if _, ok := d.lookup("/chat"); ok {
+		t.Errorf("redirect removed from the backend is still served")
+	}
+	if target, ok := d.lookup("/meeting"); !ok || target != "https://meet.example.com/" {
+		t.Errorf("lookup(/meeting) = %q, %t, want the synced target", target, ok)
+	}
+	if err := d.remove(ctx, "/meeting"); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if _, ok := backend.m["/meeting"]; ok {
+		t.Errorf("removed redirect is still stored")
+	}
+}
+
+func TestAdminRedirectsHandler(t *testing.T) {
+	defer func(token string) { *adminToken = token }(*adminToken)
+	*adminToken = "secret"
+	d, err := newDynamicRedirects(context.Background(), "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerAdmin(mux, d, nil, nil, nil)
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if w := do("POST", "/admin/redirects", token, `{"path":"/chat","target":"https://chat.example.com/"}`); w.Code != http.StatusUnauthorized {
+			t.Errorf("POST with token %q: got status %d, want 401", token, w.Code)
+		}
+	}
+	if len(d.all()) != 0 {
+		t.Errorf("unauthorized POST added a redirect")
+	}
+	for _, body := range []string{
+		`not json`,
+		`{"path":"chat","target":"https://chat.example.com/"}`,
+		`{"path":"/chat"}`,
+		`{"path":"/chat","target":"https://chat.example.com/","expires":"tomorrow"}`,
+	} {
+		if w := do("POST", "/admin/redirects", "secret", body); w.Code != http.StatusBadRequest {
+			t.Errorf("POST %s: got status %d, want 400", body, w.Code)
+		}
+	}
+	if w := do("DELETE", "/admin/redirects", "secret", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("DELETE without a path: got status %d, want 400", w.Code)
+	}
+	if w := do("PUT", "/admin/redirects", "secret", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: got status %d, want 405", w.Code)
+	}
+
+	if w := do("POST", "/admin/redirects", "secret", `{"path":"/chat","target":"https://chat.example.com/","expires":"2099-01-01T00:00:00Z"}`); w.Code != http.StatusOK {
+		t.Fatalf("POST: got status %d, want 200: %s", w.Code, w.Body)
+	}
+	w := do("GET", "/admin/redirects", "secret", "")
+	var entries []redirectEntry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "/chat" || entries[0].Expires == nil || entries[0].Expires.Year() != 2099 {
+		t.Errorf("GET: got entries %+v, want the added redirect", entries)
+	}
+
+	// The added redirect is served, and other paths fall through.
+	h := dynamicRedirectHandler(d, http.NotFoundHandler())
+	r := httptest.NewRequest("GET", "/chat?room=dev", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusFound || loc != "https://chat.example.com/?room=dev" {
+		t.Errorf("GET /chat: got status %d and Location %q, want a redirect to the added target", w.Code, loc)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/slack", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /slack: got status %d, want 404", w.Code)
+	}
+
+	if w := do("DELETE", "/admin/redirects?path=/chat", "secret", ""); w.Code != http.StatusOK {
+		t.Errorf("DELETE: got status %d, want 200", w.Code)
+	}
+	if _, ok := d.lookup("/chat"); ok {
+		t.Errorf("deleted redirect is still served")
+	}
+
+	// The admin API is disabled without a token.
+	*adminToken = ""
+	if w := do("GET", "/admin/redirects", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET with the admin API disabled: got status %d, want 404", w.Code)
+	}
+}
+
+func TestCommunityLinks(t *testing.T) {
+	ctx := context.Background()
+	d, err := newDynamicRedirects(ctx, "memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerCommunityLinks(mux, d)
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusFound {
+			t.Errorf("GET %s: got status %d, want 302", path, w.Code)
+		}
+		return w.Header().Get("Location")
+	}
+
+	for path, def := range communityLinks {
+		if loc := get(path); loc != def {
+			t.Errorf("GET %s: got Location %q, want the default %q", path, loc, def)
+		}
+	}
+
+	// Targets are rotated through the dynamic table, until they expire.
+	if err := d.set(ctx, "/slack", redirectRecord{Target: "https://join.slack.com/t/gvisor/shared_invite/new", Expires: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if loc := get("/slack"); loc != "https://join.slack.com/t/gvisor/shared_invite/new" {
+		t.Errorf("GET /slack: got Location %q, want the rotated invite", loc)
+	}
+	if err := d.set(ctx, "/slack", redirectRecord{Target: "https://join.slack.com/t/gvisor/shared_invite/old", Expires: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if loc := get("/slack"); loc != communityLinks["/slack"] {
+		t.Errorf("GET /slack: got Location %q, want the default once the invite expired", loc)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// editSource maps content generated from another repository back to its
+// source, so that edit links don't point at generated files that are not
+// checked in.
+type editSource struct {
+	// Prefix is the path of the generated content, relative to the content
+	// dir, e.g. "docs/user_guide/compatibility/linux/".
+	Prefix string `json:"prefix"`
+
+	// Repo is the GitHub repository the content is generated from.
+	Repo   string `json:"repo"`
+	Branch string `json:"branch"`
+
+	// Path is the source in the repository. If it ends in a slash, it is
+	// the directory the content is generated from, and the remainder of
+	// the content path is ignored; otherwise the remainder is appended.
+	Path string `json:"path"`
+}
+
+// defaultEditSources are the generated content of the site build.
+var defaultEditSources = []editSource{
+	{
+		Prefix: "docs/user_guide/compatibility/linux/",
+		Repo:   "https://github.com/google/gvisor",
+		Branch: "master",
+		Path:   "pkg/sentry/syscalls/linux/",
+	},
+}
+
+// editSources are the generated content mappings, set at startup.
+var editSources = defaultEditSources
+
+// loadEditSources returns the mappings in the given JSON file followed by the
+// default ones, so that the file can override them. A missing file is not an
+// error.
+func loadEditSources(file string) ([]editSource, error) {
+	var sources []editSource
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &sources); err != nil {
+			return nil, fmt.Errorf("invalid edit link config %s: %v", file, err)
+		}
+	}
+	for _, s := range sources {
+		if s.Prefix == "" || !strings.HasPrefix(s.Repo, "https://github.com/") || s.Branch == "" || s.Path == "" {
+			return nil, fmt.Errorf("edit source %q: prefix, a GitHub repo, branch and path are required", s.Prefix)
+		}
+	}
+	return append(sources, defaultEditSources...), nil
+}
+
+// editLink is served by /api/edit-link.
+type editLink struct {
+	Page   string `json:"page"`
+	Repo   string `json:"repo"`
+	Branch string `json:"branch"`
+	Path   string `json:"path"`
+
+	// Generated is true if the page is generated from another source.
+	Generated bool `json:"generated"`
+
+	// URL is the GitHub edit URL of the source file, or the URL of the
+	// source directory if the page is generated from a whole directory.
+	URL string `json:"url"`
+}
+
+// resolveEditLink returns the edit link of the page with the given content
+// file, relative to the content dir.
+func resolveEditLink(page, rel string) editLink {
+	for _, s := range editSources {
+		if !strings.HasPrefix(rel, s.Prefix) {
+			continue
+		}
+		l := editLink{Page: page, Repo: s.Repo, Branch: s.Branch, Path: s.Path, Generated: true}
+		if strings.HasSuffix(s.Path, "/") {
+			l.Path = strings.TrimSuffix(s.Path, "/")
+			l.URL = s.Repo + "/tree/" + s.Branch + "/" + l.Path
+			return l
+		}
+		l.Path = path.Join(s.Path, strings.TrimPrefix(rel, s.Prefix))
+		l.URL = s.Repo + "/edit/" + s.Branch + "/" + l.Path
+		return l
+	}
+	p := path.Join(*editRepoDir, rel)
+	return editLink{
+		Page:   page,
+		Repo:   *editRepo,
+		Branch: *editBranch,
+		Path:   p,
+		URL:    *editRepo + "/edit/" + *editBranch + "/" + p,
+	}
+}
+
+// editLinkHandler serves the edit link of the page given by the page
+// parameter, or redirects to it if the redirect parameter is set, for use as
+// the href of the theme's edit button.
+func editLinkHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		page := q.Get("page")
+		if !strings.HasPrefix(page, "/") {
+			httpError(w, r, "invalid request: page must be an absolute path", http.StatusBadRequest)
+			return
+		}
+		page = markdownPage(page)
+		src := markdownPath(page)
+		if src == "" {
+			httpError(w, r, "unknown page", http.StatusNotFound)
+			return
+		}
+		rel, err := filepath.Rel(*contentDir, src)
+		if err != nil {
+			httpError(w, r, "unknown page", http.StatusNotFound)
+			return
+		}
+		l := resolveEditLink(page, filepath.ToSlash(rel))
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if q.Get("redirect") != "" {
+			http.Redirect(w, r, l.URL, http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l)
+	})
+}
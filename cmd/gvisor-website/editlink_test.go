@@ -0,0 +1,79 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadEditSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "editlink-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if sources, err := loadEditSources(filepath.Join(dir, "missing.json")); err != nil || len(sources) != len(defaultEditSources) {
+		t.Errorf("loadEditSources(missing) = %v, %v, want the defaults", sources, err)
+	}
+	for _, tc := range []struct {
+		config string
+		ok     bool
+	}{
+		{`[{"prefix": "docs/", "repo": "https://github.com/google/gvisor", "branch": "master", "path": "g3doc/"}]`, true},
+		{`[{"prefix": "docs/", "repo": "https://example.com/gvisor", "branch": "master", "path": "g3doc/"}]`, false},
+		{`[{"prefix": "", "repo": "https://github.com/google/gvisor", "branch": "master", "path": "g3doc/"}]`, false},
+		{`{}`, false},
+	} {
+		file := filepath.Join(dir, "edit-links.json")
+		if err := ioutil.WriteFile(file, []byte(tc.config), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		sources, err := loadEditSources(file)
+		if (err == nil) != tc.ok {
+			t.Errorf("loadEditSources(%s) error = %v, want ok %v", tc.config, err, tc.ok)
+		}
+		if err == nil && sources[0].Prefix != "docs/" {
+			t.Errorf("loadEditSources(%s) = %+v, want the file's sources first", tc.config, sources)
+		}
+	}
+}
+
+func TestResolveEditLink(t *testing.T) {
+	defer func(s []editSource) { editSources = s }(editSources)
+	editSources = append([]editSource{{
+		Prefix: "docs/architecture_guide/",
+		Repo:   "https://github.com/google/gvisor",
+		Branch: "master",
+		Path:   "g3doc/architecture_guide",
+	}}, defaultEditSources...)
+
+	for _, tc := range []struct {
+		rel       string
+		url       string
+		generated bool
+	}{
+		{"docs/user_guide/quick_start.md", *editRepo + "/edit/" + *editBranch + "/content/docs/user_guide/quick_start.md", false},
+		{"docs/user_guide/compatibility/linux/amd64.md", "https://github.com/google/gvisor/tree/master/pkg/sentry/syscalls/linux", true},
+		{"docs/architecture_guide/security.md", "https://github.com/google/gvisor/edit/master/g3doc/architecture_guide/security.md", true},
+	} {
+		l := resolveEditLink("/"+tc.rel, tc.rel)
+		if l.URL != tc.url || l.Generated != tc.generated {
+			t.Errorf("resolveEditLink(%q) = %+v, want URL %s, generated %v", tc.rel, l, tc.url, tc.generated)
+		}
+	}
+}
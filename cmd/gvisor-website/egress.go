@@ -0,0 +1,126 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var egressDenied = newCounter("upstream_egress_denied_total", "Upstream requests denied by the egress policy, by upstream.", "upstream")
+
+// egressPolicy is the allow-list of hosts upstream requests may be sent to,
+// so that a request forged through a bug in a handler can't reach internal
+// services such as the metadata server.
+type egressPolicy struct {
+	// hosts are the allowed hosts.
+	hosts map[string]bool
+
+	// suffixes are the allowed domain suffixes, e.g. ".googleapis.com"
+	// for *.googleapis.com.
+	suffixes []string
+}
+
+// egress is nil unless upstream requests are restricted with --egress-allow.
+var egress *egressPolicy
+
+// parseEgressPolicy parses a comma-separated list of allowed hosts, e.g.
+// "github.com,*.googleapis.com". The hosts of the given upstream URLs, e.g.
+// a configured mirror, are allowed too; empty URLs are ignored. A spec of *
+// allows all hosts.
+func parseEgressPolicy(spec string, upstreams ...string) (*egressPolicy, error) {
+	if strings.TrimSpace(spec) == "*" {
+		return nil, nil
+	}
+	p := &egressPolicy{hosts: make(map[string]bool)}
+	for _, host := range strings.Split(spec, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		switch {
+		case host == "":
+		case strings.ContainsAny(host, "/:@"):
+			return nil, fmt.Errorf("invalid host %q: want a host name without scheme or port", host)
+		case strings.HasPrefix(host, "*."):
+			p.suffixes = append(p.suffixes, host[1:])
+		case strings.Contains(host, "*"):
+			return nil, fmt.Errorf("invalid host %q: wildcards are only allowed as the first label", host)
+		default:
+			p.hosts[host] = true
+		}
+	}
+	for _, upstream := range upstreams {
+		if upstream == "" {
+			continue
+		}
+		u, err := url.Parse(upstream)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("invalid upstream URL %q", upstream)
+		}
+		p.hosts[strings.ToLower(u.Hostname())] = true
+	}
+	return p, nil
+}
+
+// allows returns true if requests may be sent to the host. A nil policy
+// allows all hosts.
+func (p *egressPolicy) allows(host string) bool {
+	if p == nil {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if p.hosts[host] {
+		return true
+	}
+	for _, suffix := range p.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// transport returns rt restricted to the allowed hosts, and those for which
+// extra, if not nil, returns true. A nil policy returns rt unchanged.
+func (p *egressPolicy) transport(upstream string, extra func(host string) bool, rt http.RoundTripper) http.RoundTripper {
+	if p == nil {
+		return rt
+	}
+	return &egressTransport{policy: p, upstream: upstream, extra: extra, rt: rt}
+}
+
+// egressTransport is an http.RoundTripper enforcing an egress policy. As the
+// client sends redirected requests through the transport too, redirects are
+// restricted as well.
+type egressTransport struct {
+	policy   *egressPolicy
+	upstream string
+	extra    func(host string) bool
+	rt       http.RoundTripper
+}
+
+func (t *egressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if !t.policy.allows(host) && (t.extra == nil || !t.extra(host)) {
+		egressDenied.inc(t.upstream)
+		log.Printf("Denied %s request to %s: host not allowed by the egress policy", t.upstream, host)
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("egress to %s is not allowed", host)
+	}
+	return t.rt.RoundTrip(req)
+}
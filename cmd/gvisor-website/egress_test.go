@@ -0,0 +1,112 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseEgressPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		spec      string
+		upstreams []string
+		wantNil   bool
+		wantErr   bool
+		allowed   []string
+		denied    []string
+	}{
+		{
+			spec:    "*",
+			wantNil: true,
+		},
+		{
+			spec:    "github.com, *.googleapis.com",
+			allowed: []string{"github.com", "GitHub.com", "github.com.", "cloudbuild.googleapis.com", "storage.googleapis.com"},
+			denied:  []string{"api.github.com", "googleapis.com", "evilgoogleapis.com", "metadata.google.internal", "169.254.169.254", "localhost", ""},
+		},
+		{
+			spec:      "",
+			upstreams: []string{"https://git.example.com:8443/gvisor.git", ""},
+			allowed:   []string{"git.example.com"},
+			denied:    []string{"github.com"},
+		},
+		{spec: "https://github.com", wantErr: true},
+		{spec: "github.com:443", wantErr: true},
+		{spec: "git*.com", wantErr: true},
+		{spec: "github.com", upstreams: []string{"/relative"}, wantErr: true},
+	} {
+		p, err := parseEgressPolicy(tc.spec, tc.upstreams...)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseEgressPolicy(%q, %q) returned error %v, want error %t", tc.spec, tc.upstreams, err, tc.wantErr)
+			continue
+		}
+		if tc.wantErr {
+			continue
+		}
+		if (p == nil) != tc.wantNil {
+			t.Errorf("parseEgressPolicy(%q, %q) = %v, want nil %t", tc.spec, tc.upstreams, p, tc.wantNil)
+		}
+		for _, host := range tc.allowed {
+			if !p.allows(host) {
+				t.Errorf("policy %q denies %q, want allowed", tc.spec, host)
+			}
+		}
+		for _, host := range tc.denied {
+			if p.allows(host) {
+				t.Errorf("policy %q allows %q, want denied", tc.spec, host)
+			}
+		}
+	}
+}
+
+func TestEgressTransport(t *testing.T) {
+	defer func(c *chaosConfig, e *egressPolicy) { chaos, egress = c, e }(chaos, egress)
+	chaos = nil
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://localhost:1/internal", http.StatusFound)
+		}
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	var err error
+	egress, err = parseEgressPolicy("github.com", s.URL)
+	if err != nil {
+		t.Fatalf("parseEgressPolicy failed: %v", err)
+	}
+	resp, err := upstreamClient("test").Get(s.URL)
+	if err != nil {
+		t.Fatalf("request to allowed host %s failed: %v", u.Hostname(), err)
+	}
+	resp.Body.Close()
+	if _, err := upstreamClient("test").Get(s.URL + "/redirect"); err == nil {
+		t.Errorf("redirect to a denied host succeeded, want error")
+	}
+
+	egress, _ = parseEgressPolicy("github.com")
+	if _, err := upstreamClient("test").Get(s.URL); err == nil {
+		t.Errorf("request to denied host %s succeeded, want error", u.Hostname())
+	}
+	resp, err = upstreamClientAllowing("test", func(host string) bool { return host == u.Hostname() }).Get(s.URL)
+	if err != nil {
+		t.Fatalf("request to extra allowed host %s failed: %v", u.Hostname(), err)
+	}
+	resp.Body.Close()
+}
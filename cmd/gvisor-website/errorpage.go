@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// errorPageDir is the directory in the static dir holding error page
+// templates. Templates are named by status code (e.g. 503.html), with
+// 5xx.html used for any server error without its own template.
+const errorPageDir = "errors"
+
+// errorPageData is passed to error page templates. Templates get their text
+// from the message catalog with T, in the language of the page.
+type errorPageData struct {
+	Code      int
+	Status    string
+	RequestID string
+	Lang      string
+}
+
+// T returns the message with the given key in the language of the page.
+func (d errorPageData) T(key string, args ...interface{}) string {
+	return translate(d.Lang, key, args...)
+}
+
+// defaultErrorPage is used if the static dir has no error page templates.
+var defaultErrorPage = template.Must(template.New("error").Parse(`<!doctype html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Code}} {{.Status}} - gVisor</title>
+<style>
+body { font-family: "Roboto", sans-serif; margin: 0; color: #222; }
+header { background: #262362; color: #fff; padding: 1em 2em; font-size: 1.5em; }
+main { margin: 2em; }
+a { color: #286FD7; }
+</style>
+</head>
+<body>
+<header>gVisor</header>
+<main>
+<h1>{{.Code}} {{.Status}}</h1>
+<p>{{.T "error_server"}}</p>
+<p><a href="/">{{.T "homepage"}}</a></p>
+{{if .RequestID}}<p><small>{{.T "request_id" .RequestID}}</small></p>{{end}}
+</main>
+</body>
+</html>
+`))
+
+var (
+	errorPagesMu sync.Mutex
+	errorPages   = make(map[int]*template.Template)
+)
+
+// errorPage returns the template for the given status code. Templates are
+// loaded from the static dir on first use and kept for the life of the
+// process, since the static dir only changes on deploy.
+func errorPage(code int) *template.Template {
+	errorPagesMu.Lock()
+	defer errorPagesMu.Unlock()
+	if t, ok := errorPages[code]; ok {
+		return t
+	}
+	t := defaultErrorPage
+	for _, name := range []string{fmt.Sprintf("%d.html", code), "5xx.html"} {
+		path := filepath.Join(*staticDir, errorPageDir, name)
+		b, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			t, err = template.New(name).Parse(string(b))
+		}
+		if err != nil {
+			log.Printf("Error loading error page %s: %v", path, err)
+			t = defaultErrorPage
+		}
+		break
+	}
+	errorPages[code] = t
+	return t
+}
+
+// wantsHTMLError returns true if server errors for the given request should
+// be rendered as a themed page. Only browsers ask for HTML; git, go and other
+// tooling get the plain error.
+func wantsHTMLError(r *http.Request) bool {
+	return accepts(r, "Accept", "text/html")
+}
+
+// renderErrorPage replies to the request with the themed page for the given
+// status code. The error message is not shown, since server errors may
+// contain internal details; the request ID is shown instead so that users can
+// report the error. The page is in the language the client prefers.
+func renderErrorPage(w http.ResponseWriter, r *http.Request, code int) {
+	lang := pageLanguage(w, r)
+	hdr := w.Header()
+	hdr.Del("Content-Length")
+	hdr.Set("Content-Type", "text/html; charset=utf-8")
+	hdr.Set("Cache-Control", "no-store")
+	hdr.Set("X-Content-Type-Options", "nosniff")
+	hdr.Set("Content-Language", lang)
+	w.WriteHeader(code)
+	if err := errorPage(code).Execute(w, errorPageData{
+		Code:      code,
+		Status:    statusText(lang, code),
+		RequestID: requestID(r),
+		Lang:      lang,
+	}); err != nil {
+		log.Printf("Error rendering error page: %v", err)
+	}
+}
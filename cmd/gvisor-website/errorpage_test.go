@@ -0,0 +1,115 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withRequestID returns the request with the given request ID.
+func withRequestID(r *http.Request, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+func TestErrorPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "errorpage-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"errors/503.html": `<title>Down for maintenance</title><p>{{.Code}} {{.RequestID}}</p>`,
+		"errors/5xx.html": `<title>Themed</title><p>{{.Code}} {{.Status}}</p>`,
+		"errors/502.html": `{{.Broken`,
+	})
+	defer func(dir string, pages map[int]*template.Template) {
+		*staticDir, errorPages = dir, pages
+	}(*staticDir, errorPages)
+	*staticDir, errorPages = dir, make(map[int]*template.Template)
+
+	for _, tc := range []struct {
+		name   string
+		code   int
+		accept string
+		// want is in the body, which is text if html is false.
+		want string
+		html bool
+	}{
+		{"page for the status", http.StatusServiceUnavailable, "text/html", "<title>Down for maintenance</title><p>503 req-1</p>", true},
+		{"server error page", http.StatusInternalServerError, "text/html", "<title>Themed</title><p>500 Internal Server Error</p>", true},
+		{"invalid template", http.StatusBadGateway, "text/html", "<h1>502 Bad Gateway</h1>", true},
+		{"plain-text client", http.StatusServiceUnavailable, "*/*", "upstream error: detail", false},
+		{"no Accept", http.StatusInternalServerError, "", "upstream error: detail", false},
+		{"client error", http.StatusNotFound, "text/html", "upstream error: detail", false},
+	} {
+		r := httptest.NewRequest("GET", "/docs/", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Length", "12")
+		httpError(w, withRequestID(r, "req-1"), "upstream error: detail", tc.code)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.code)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: body %q does not contain %q", tc.name, w.Body, tc.want)
+		}
+		hdr := w.Header()
+		if !tc.html {
+			if ct := hdr.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+				t.Errorf("%s: got Content-Type %q, want text", tc.name, ct)
+			}
+			continue
+		}
+		if strings.Contains(w.Body.String(), "detail") {
+			t.Errorf("%s: page %q shows the error message", tc.name, w.Body)
+		}
+		for name, want := range map[string]string{
+			"Content-Type":     "text/html; charset=utf-8",
+			"Content-Length":   "",
+			"Cache-Control":    "no-store",
+			"Content-Language": "en",
+		} {
+			if got := hdr.Get(name); got != want {
+				t.Errorf("%s: got %s %q, want %q", tc.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestDefaultErrorPage(t *testing.T) {
+	defer func(dir string, pages map[int]*template.Template) {
+		*staticDir, errorPages = dir, pages
+	}(*staticDir, errorPages)
+	*staticDir, errorPages = "/nonexistent", make(map[int]*template.Template)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	renderErrorPage(w, withRequestID(r, "req-2"), http.StatusInternalServerError)
+	for _, want := range []string{`<html lang="en">`, "<h1>500 Internal Server Error</h1>", "req-2", `<meta name="robots" content="noindex">`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("body %q does not contain %q", w.Body, want)
+		}
+	}
+}
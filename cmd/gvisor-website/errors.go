@@ -0,0 +1,105 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requestIDKey is the context key for the request ID.
+type requestIDKey struct{}
+
+// requestID returns the ID of the given request, or "" if none was assigned.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestIDHandler assigns each request an ID, which is returned in the
+// X-Request-Id header and included in error responses. The Cloud trace ID is
+// used if present so that errors can be correlated with request logs.
+func requestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Cloud-Trace-Context")
+		if i := strings.Index(id, "/"); i >= 0 {
+			id = id[:i]
+		}
+		if id == "" {
+			var b [8]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set("X-Request-Id", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// jsonErrorPrefixes are the path prefixes that always get JSON errors.
+var jsonErrorPrefixes = []string{
+	"/api/",
+	"/admin/",
+	"/rebuild",
+	"/metrics",
+}
+
+// wantsJSONError returns true if errors for the given request should be
+// rendered as JSON rather than for a browser.
+func wantsJSONError(r *http.Request) bool {
+	for _, prefix := range jsonErrorPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return accepts(r, "Accept", "application/json")
+}
+
+// errorBody is the JSON error response.
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+// errorDetail describes a single error.
+type errorDetail struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// httpError replies to the request with the given error message and status
+// code, as JSON for API clients, as a themed page for server errors seen by
+// browsers, or as text otherwise.
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if !wantsJSONError(r) {
+		if code >= 500 && wantsHTMLError(r) {
+			renderErrorPage(w, r, code)
+			return
+		}
+		http.Error(w, message, code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorBody{Error: errorDetail{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(r),
+	}})
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		path   string
+		accept string
+		code   int
+		// contentType is the media type of the response: JSON, the
+		// themed page or text.
+		contentType string
+	}{
+		{"API path", "/api/v1/search", "", http.StatusBadRequest, "application/json"},
+		{"API path from a browser", "/api/v1/search", "text/html,*/*;q=0.8", http.StatusInternalServerError, "application/json"},
+		{"admin path", "/admin/redirects", "text/html", http.StatusUnauthorized, "application/json"},
+		{"rebuild", "/rebuild", "", http.StatusForbidden, "application/json"},
+		{"metrics", "/metrics", "", http.StatusNotFound, "application/json"},
+		{"JSON client", "/docs/", "application/json", http.StatusNotFound, "application/json"},
+		{"JSON client with a quality", "/docs/", "text/plain, application/json;q=0.5", http.StatusBadGateway, "application/json"},
+		{"JSON refused", "/docs/", "application/json;q=0", http.StatusNotFound, "text/plain"},
+		{"browser server error", "/docs/", "text/html,application/xhtml+xml,*/*;q=0.8", http.StatusBadGateway, "text/html"},
+		{"browser client error", "/docs/", "text/html", http.StatusNotFound, "text/plain"},
+		{"git client", "/gvisor/info/refs", "*/*", http.StatusBadGateway, "text/plain"},
+		{"no Accept", "/docs/", "", http.StatusServiceUnavailable, "text/plain"},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		w := httptest.NewRecorder()
+		requestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpError(w, r, "upstream error: secret detail", tc.code)
+		})).ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tc.contentType) {
+			t.Errorf("%s: got Content-Type %q, want %s", tc.name, ct, tc.contentType)
+			continue
+		}
+		id := w.Header().Get("X-Request-Id")
+		switch tc.contentType {
+		case "application/json":
+			var body errorBody
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Errorf("%s: invalid JSON error: %v", tc.name, err)
+				continue
+			}
+			if want := (errorDetail{Code: tc.code, Message: "upstream error: secret detail", RequestID: id}); body.Error != want {
+				t.Errorf("%s: got error %+v, want %+v", tc.name, body.Error, want)
+			}
+		case "text/html":
+			// The themed page shows the request ID, not the message.
+			if b := w.Body.String(); strings.Contains(b, "secret detail") || !strings.Contains(b, id) {
+				t.Errorf("%s: got page %q, want the request ID %s without the message", tc.name, b, id)
+			}
+		default:
+			if b := strings.TrimSpace(w.Body.String()); b != "upstream error: secret detail" {
+				t.Errorf("%s: got body %q, want the message", tc.name, b)
+			}
+		}
+	}
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	var got string
+	h := requestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = requestID(r)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got != "105445aa7843bc8bf206b12000100000" || w.Header().Get("X-Request-Id") != got {
+		t.Errorf("got request ID %q and header %q, want the trace ID", got, w.Header().Get("X-Request-Id"))
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if len(got) != 16 || w.Header().Get("X-Request-Id") != got {
+		t.Errorf("got request ID %q and header %q, want a random ID", got, w.Header().Get("X-Request-Id"))
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// experimentControl is the variant of enrolled clients that are served
+	// the unmodified page.
+	experimentControl = "control"
+
+	// experimentHeader forces variants for a request, as a comma-separated
+	// list of experiment=variant pairs, so that variants can be previewed.
+	experimentHeader = "X-Gvisor-Experiments"
+)
+
+// experiment is an A/B test of alternate page layouts. Clients are bucketed
+// by a hash of their address and user agent rather than a cookie, so that no
+// state is kept on the client; a client whose address changes may change
+// buckets, which is acceptable for layout experiments.
+type experiment struct {
+	// Name identifies the experiment in rewrite rules, beacons and metrics.
+	Name string `json:"name"`
+
+	// Paths are the pages the experiment runs on, matched as in rewrite
+	// rules.
+	Paths []string `json:"paths"`
+
+	// Percent is the percentage of clients enrolled in the experiment.
+	// Enrolled clients are split evenly between the control and the
+	// variants.
+	Percent int `json:"percent"`
+
+	// Variants are the names of the alternate layouts, which rewrite rules
+	// select with their variant field.
+	Variants []string `json:"variants"`
+}
+
+// experiments are the configured experiments.
+var experiments []*experiment
+
+// loadExperiments reads experiments from the given JSON file. A missing file
+// is not an error; there are simply no experiments.
+func loadExperiments(file string) ([]*experiment, error) {
+	var es []*experiment
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &es); err != nil {
+		return nil, fmt.Errorf("invalid experiment config %s: %v", file, err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range es {
+		if e.Name == "" || strings.ContainsAny(e.Name, "=,") || seen[e.Name] {
+			return nil, fmt.Errorf("experiment %q: names must be unique and non-empty, without = or ,", e.Name)
+		}
+		seen[e.Name] = true
+		if len(e.Paths) == 0 {
+			return nil, fmt.Errorf("experiment %q: paths are required", e.Name)
+		}
+		if e.Percent < 0 || e.Percent > 100 {
+			return nil, fmt.Errorf("experiment %q: percent must be between 0 and 100", e.Name)
+		}
+		if len(e.Variants) == 0 {
+			return nil, fmt.Errorf("experiment %q: at least one variant is required", e.Name)
+		}
+		for _, v := range e.Variants {
+			if v == "" || v == experimentControl || strings.ContainsAny(v, "=,") {
+				return nil, fmt.Errorf("experiment %q: invalid variant %q", e.Name, v)
+			}
+		}
+	}
+	return es, nil
+}
+
+// runsOn returns true if the experiment runs on the given page.
+func (e *experiment) runsOn(urlPath string) bool {
+	for _, p := range e.Paths {
+		if p == "*" || p == urlPath || (strings.HasSuffix(p, "*") && strings.HasPrefix(urlPath, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVariant returns true if v is the control or one of the variants.
+func (e *experiment) hasVariant(v string) bool {
+	if v == experimentControl {
+		return true
+	}
+	for _, ev := range e.Variants {
+		if ev == v {
+			return true
+		}
+	}
+	return false
+}
+
+// variantFor returns the variant of the client with the given bucketing key,
+// or "" if the client is not enrolled. Each experiment buckets clients
+// independently, and changing the salt reshuffles all of them.
+func (e *experiment) variantFor(salt, key string) string {
+	sum := sha256.Sum256([]byte(salt + "\x00" + e.Name + "\x00" + key))
+	bucket := binary.BigEndian.Uint32(sum[:4]) % 10000
+	if bucket >= uint32(e.Percent)*100 {
+		return ""
+	}
+	i := int(bucket) % (len(e.Variants) + 1)
+	if i == 0 {
+		return experimentControl
+	}
+	return e.Variants[i-1]
+}
+
+// findExperiment returns the named experiment, or nil.
+func findExperiment(name string) *experiment {
+	for _, e := range experiments {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// experimentsKey is the context key for the variants a request is served.
+type experimentsKey struct{}
+
+// experimentVariant returns the variant of the named experiment the request
+// is served, or "" if it is not enrolled.
+func experimentVariant(r *http.Request, name string) string {
+	variants, _ := r.Context().Value(experimentsKey{}).(map[string]string)
+	return variants[name]
+}
+
+// experimentsHandler assigns requests to the variants of the experiments
+// running on the page, so that rewrite rules can query them with
+// experimentVariant. Crawlers are never enrolled, so that they index the
+// control layout.
+//
+// Pages that experiments run on vary by client, so they must not be stored
+// by shared caches.
+func experimentsHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var running []*experiment
+		for _, e := range experiments {
+			if e.runsOn(r.URL.Path) {
+				running = append(running, e)
+			}
+		}
+		if len(running) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-cache")
+		variants := make(map[string]string)
+		if trafficClass(r) != classCrawler {
+			key := clientIP(r) + "\x00" + r.UserAgent()
+			for _, e := range running {
+				if v := e.variantFor(*experimentSalt, key); v != "" {
+					variants[e.Name] = v
+				}
+			}
+		}
+		for _, pair := range strings.Split(r.Header.Get(experimentHeader), ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			if e := findExperiment(kv[0]); e != nil && e.runsOn(r.URL.Path) && e.hasVariant(kv[1]) {
+				variants[kv[0]] = kv[1]
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), experimentsKey{}, variants)))
+	})
+}
+
+// experimentsRule is the built-in rule exposing the variants a page is served
+// in a meta tag, from which the page's scripts tag RUM beacons.
+func experimentsRule() *rewriteRule {
+	return &rewriteRule{
+		Name:   "experiments",
+		Paths:  []string{"*"},
+		Anchor: "</head>",
+		Action: rewriteBefore,
+		render: func(r *http.Request) string {
+			variants, _ := r.Context().Value(experimentsKey{}).(map[string]string)
+			if len(variants) == 0 {
+				return ""
+			}
+			pairs := make([]string, 0, len(variants))
+			for name, v := range variants {
+				pairs = append(pairs, name+"="+v)
+			}
+			sort.Strings(pairs)
+			return `<meta name="gvisor-experiments" content="` + html.EscapeString(strings.Join(pairs, ",")) + `">` + "\n"
+		},
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadExperiments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "experiments-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if es, err := loadExperiments(filepath.Join(dir, "missing.json")); err != nil || es != nil {
+		t.Errorf("loadExperiments(missing) = %v, %v, want none", es, err)
+	}
+	for _, tc := range []struct {
+		config string
+		ok     bool
+	}{
+		{`[{"name": "nav", "paths": ["/docs/*"], "percent": 20, "variants": ["collapsed"]}]`, true},
+		{`[{"name": "nav", "paths": ["/docs/*"], "percent": 20, "variants": []}]`, false},
+		{`[{"name": "nav", "paths": [], "percent": 20, "variants": ["a"]}]`, false},
+		{`[{"name": "nav", "paths": ["*"], "percent": 101, "variants": ["a"]}]`, false},
+		{`[{"name": "nav", "paths": ["*"], "percent": 10, "variants": ["control"]}]`, false},
+		{`[{"name": "nav", "paths": ["*"], "percent": 10, "variants": ["a=b"]}]`, false},
+		{`[{"name": "nav", "paths": ["*"], "percent": 10, "variants": ["a"]}, {"name": "nav", "paths": ["*"], "percent": 10, "variants": ["a"]}]`, false},
+	} {
+		file := filepath.Join(dir, "experiments.json")
+		if err := ioutil.WriteFile(file, []byte(tc.config), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := loadExperiments(file); (err == nil) != tc.ok {
+			t.Errorf("loadExperiments(%s) error = %v, want ok %v", tc.config, err, tc.ok)
+		}
+	}
+}
+
+func TestExperimentVariantFor(t *testing.T) {
+	e := &experiment{Name: "nav", Percent: 50, Variants: []string{"a", "b"}}
+	counts := make(map[string]int)
+	for i := 0; i < 6000; i++ {
+		key := fmt.Sprintf("10.0.%d.%d\x00agent", i/256, i%256)
+		v := e.variantFor("salt", key)
+		if again := e.variantFor("salt", key); again != v {
+			t.Fatalf("variantFor(%q) = %q, then %q", key, v, again)
+		}
+		counts[v]++
+	}
+	// Half are enrolled, split evenly in thirds.
+	for v, want := range map[string]int{"": 3000, "control": 1000, "a": 1000, "b": 1000} {
+		if got := counts[v]; got < want*8/10 || got > want*12/10 {
+			t.Errorf("%d clients got variant %q, want about %d", got, v, want)
+		}
+	}
+	if v := (&experiment{Name: "off", Variants: []string{"a"}}).variantFor("salt", "key"); v != "" {
+		t.Errorf("variantFor at 0%% = %q, want not enrolled", v)
+	}
+}
+
+func TestExperimentsHandler(t *testing.T) {
+	defer func(es []*experiment, rules []*rewriteRule) {
+		experiments, rewriteRules = es, rules
+	}(experiments, rewriteRules)
+	experiments = []*experiment{{Name: "nav", Paths: []string{"/docs/*"}, Percent: 100, Variants: []string{"collapsed"}}}
+	rules := []*rewriteRule{
+		{Name: "collapsed", Paths: []string{"*"}, Anchor: "<nav>", Action: rewriteReplace, End: "</nav>", Content: "<nav class=collapsed></nav>", Experiment: "nav", Variant: "collapsed"},
+		experimentsRule(),
+	}
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			t.Fatalf("validate failed: %v", err)
+		}
+	}
+	rewriteRules = rules
+	if err := (&rewriteRule{Name: "x", Paths: []string{"*"}, Anchor: "a", Action: rewriteBefore, Experiment: "nav", Variant: "other"}).validate(); err == nil {
+		t.Errorf("rule with an unknown variant is valid")
+	}
+
+	const page = "<html><head></head><body><nav></nav></body></html>"
+	h := experimentsHandler(rewriteHandler(chunkedHandler("text/html; charset=utf-8", page, 1000)))
+	for _, tc := range []struct {
+		path    string
+		variant string
+		want    string
+	}{
+		{"/docs/", "collapsed", `<html><head><meta name="gvisor-experiments" content="nav=collapsed">` + "\n" + `</head><body><nav class=collapsed></nav></body></html>`},
+		{"/docs/", "control", `<html><head><meta name="gvisor-experiments" content="nav=control">` + "\n" + `</head><body><nav></nav></body></html>`},
+		{"/blog/", "collapsed", page},
+	} {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set(experimentHeader, "nav="+tc.variant)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tc.want {
+			t.Errorf("%s as %s: got %q, want %q", tc.path, tc.variant, got, tc.want)
+		}
+		if private := strings.HasPrefix(rec.Header().Get("Cache-Control"), "private"); private != strings.HasPrefix(tc.path, "/docs/") {
+			t.Errorf("%s: Cache-Control %q", tc.path, rec.Header().Get("Cache-Control"))
+		}
+	}
+
+	// Without an override, every client is enrolled at 100%.
+	var got string
+	experimentsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = experimentVariant(r, "nav")
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/docs/", nil))
+	if got != "control" && got != "collapsed" {
+		t.Errorf("variant = %q, want enrolled", got)
+	}
+}
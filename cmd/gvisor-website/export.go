@@ -0,0 +1,503 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// exportPath is the URL path of the docs export.
+const exportPath = "/docs/export"
+
+// exportPage is a page of an exported docs section.
+type exportPage struct {
+	URL   string
+	Title string
+
+	// Body is the main content of the page, as XHTML.
+	Body string
+}
+
+// docsExport is a docs section collected for export, with its pages in the
+// order of the docs navigation.
+type docsExport struct {
+	Section  string
+	Title    string
+	Modified time.Time
+	Pages    []exportPage
+}
+
+// exportSectionRE matches the docs sections that may be exported.
+var exportSectionRE = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// collectExport collects the rendered pages of the given docs section from
+// the static dir. Pages are ordered as they are linked from the section's
+// index page, whose navigation lists them in order, followed by any pages it
+// doesn't link to.
+func collectExport(staticDir, section string) (*docsExport, error) {
+	root := "/docs/" + section + "/"
+	index, err := readPage(staticDir, root)
+	if err != nil {
+		return nil, err
+	}
+	e := &docsExport{Section: section, Title: "gVisor: " + section}
+	if m := titleRE.FindSubmatch(index); m != nil {
+		e.Title = "gVisor: " + strings.TrimSuffix(pageText(m[1]), " | gVisor")
+	}
+	if fi, err := os.Stat(filepath.Join(staticDir, filepath.FromSlash(root), "index.html")); err == nil {
+		e.Modified = fi.ModTime().UTC()
+	}
+
+	seen := map[string]bool{root: true}
+	urls := []string{root}
+	linkRE := regexp.MustCompile(`href="(` + regexp.QuoteMeta(root) + `[^"#?]*)"`)
+	for _, m := range linkRE.FindAllSubmatch(index, -1) {
+		u := path.Clean(string(m[1])) + "/"
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	var rest []string
+	dir := filepath.Join(staticDir, filepath.FromSlash(root))
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != "index.html" {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		u := path.Clean(root+filepath.ToSlash(rel)) + "/"
+		if !seen[u] {
+			seen[u] = true
+			rest = append(rest, u)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rest)
+	urls = append(urls, rest...)
+
+	for _, u := range urls {
+		b, err := readPage(staticDir, u)
+		if os.IsNotExist(err) {
+			// Linked from the navigation but not a page.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p := exportPage{URL: u, Title: u}
+		if m := titleRE.FindSubmatch(b); m != nil {
+			p.Title = strings.TrimSuffix(pageText(m[1]), " | gVisor")
+		}
+		if m := mainRE.FindSubmatch(b); m != nil {
+			b = m[1]
+		}
+		body, err := toXHTML(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", u, err)
+		}
+		p.Body = body
+		e.Pages = append(e.Pages, p)
+	}
+	return e, nil
+}
+
+var (
+	// exportSkipElements are not exported, along with their content.
+	exportSkipElements = map[string]bool{
+		"script": true, "style": true, "noscript": true, "svg": true, "form": true,
+		"button": true, "iframe": true, "img": true, "picture": true, "video": true,
+	}
+
+	// exportVoidElements have no end tag in HTML.
+	exportVoidElements = map[string]bool{
+		"area": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+		"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+	}
+
+	// exportImpliedEnd are elements whose end tag HTML implies when another
+	// of them starts, e.g. <li>one<li>two.
+	exportImpliedEnd = map[string]bool{
+		"p": true, "li": true, "dt": true, "dd": true, "tr": true, "td": true, "th": true,
+	}
+
+	// exportAttrs are the attributes kept in exported pages.
+	exportAttrs = map[string]bool{
+		"href": true, "id": true, "class": true, "title": true, "colspan": true, "rowspan": true,
+	}
+)
+
+// toXHTML converts an HTML fragment to well-formed XHTML for export, dropping
+// scripts, forms, images and other content that can't be read offline.
+// Links to the site are made absolute. Elements are balanced here rather than
+// by the decoder, which rejects end tags that don't match.
+func toXHTML(b []byte) (string, error) {
+	d := xml.NewDecoder(bytes.NewReader(nonTextRE.ReplaceAll(b, nil)))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	// open are the elements written and not yet closed; skipped are those
+	// being skipped, with their content.
+	var open, skipped []string
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Close what is open, rather than failing on markup
+			// that can't be fixed up.
+			log.Printf("Error parsing exported page, truncating: %v", err)
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			void := exportVoidElements[name]
+			if len(skipped) > 0 || exportSkipElements[name] || t.Name.Space != "" {
+				if !void {
+					skipped = append(skipped, name)
+				}
+				continue
+			}
+			start := xml.StartElement{Name: xml.Name{Local: name}}
+			for _, a := range t.Attr {
+				n := strings.ToLower(a.Name.Local)
+				if a.Name.Space != "" || !exportAttrs[n] {
+					continue
+				}
+				if n == "href" && strings.HasPrefix(a.Value, "/") && !strings.HasPrefix(a.Value, "//") {
+					a.Value = siteURL(a.Value)
+				}
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: n}, Value: a.Value})
+			}
+			if exportImpliedEnd[name] && len(open) > 0 && open[len(open)-1] == name {
+				if err := enc.EncodeToken(start.End()); err != nil {
+					return "", err
+				}
+				open = open[:len(open)-1]
+			}
+			if err := enc.EncodeToken(start); err != nil {
+				return "", err
+			}
+			if void {
+				if err := enc.EncodeToken(start.End()); err != nil {
+					return "", err
+				}
+				continue
+			}
+			open = append(open, name)
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			if len(skipped) > 0 {
+				if i := lastIndex(skipped, name); i >= 0 {
+					skipped = skipped[:i]
+				}
+				continue
+			}
+			// Close the elements left open inside this one, and ignore
+			// stray end tags.
+			i := lastIndex(open, name)
+			if i < 0 {
+				continue
+			}
+			for len(open) > i {
+				if err := enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: open[len(open)-1]}}); err != nil {
+					return "", err
+				}
+				open = open[:len(open)-1]
+			}
+		case xml.CharData:
+			if len(skipped) == 0 {
+				if err := enc.EncodeToken(t); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		if err := enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: open[i]}}); err != nil {
+			return "", err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// lastIndex returns the index of the last occurrence of s in a, or -1.
+func lastIndex(a []string, s string) int {
+	for i := len(a) - 1; i >= 0; i-- {
+		if a[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+var epubFuncs = template.FuncMap{"x": html.EscapeString}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+var epubPackageTemplate = template.Must(template.New("opf").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">{{x .Identifier}}</dc:identifier>
+    <dc:title>{{x .Title}}</dc:title>
+    <dc:language>en</dc:language>
+    <dc:publisher>gVisor Authors</dc:publisher>
+    <meta property="dcterms:modified">{{.Modified.Format "2006-01-02T15:04:05Z"}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+{{range $i, $p := .Pages}}    <item id="page-{{$i}}" href="page-{{$i}}.xhtml" media-type="application/xhtml+xml"/>
+{{end}}  </manifest>
+  <spine>
+{{range $i, $p := .Pages}}    <itemref idref="page-{{$i}}"/>
+{{end}}  </spine>
+</package>
+`))
+
+var epubNavTemplate = template.Must(template.New("nav").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en">
+<head><title>{{x .Title}}</title></head>
+<body>
+<nav epub:type="toc">
+<h1>{{x .Title}}</h1>
+<ol>
+{{range $i, $p := .Pages}}<li><a href="page-{{$i}}.xhtml">{{x $p.Title}}</a></li>
+{{end}}</ol>
+</nav>
+</body>
+</html>
+`))
+
+var epubPageTemplate = template.Must(template.New("page").Funcs(epubFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" lang="en">
+<head><title>{{x .Title}}</title><link rel="stylesheet" type="text/css" href="style.css"/></head>
+<body>
+<p class="source"><a href="{{x .Source}}">{{x .Source}}</a></p>
+{{.Body}}
+</body>
+</html>
+`))
+
+const epubStyle = `body { font-family: sans-serif; line-height: 1.4; }
+pre { white-space: pre-wrap; font-size: 0.85em; background: #f6f8fa; padding: 0.5em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ddd; padding: 0.2em 0.5em; }
+.source { font-size: 0.8em; color: #666; }
+`
+
+// epubFile is a file of an EPUB book.
+type epubFile struct {
+	name string
+	body []byte
+}
+
+// renderEPUB renders the export as an EPUB 3 book, with a chapter per page.
+func renderEPUB(e *docsExport) ([]byte, error) {
+	files := []epubFile{
+		{"META-INF/container.xml", []byte(epubContainer)},
+		{"OEBPS/style.css", []byte(epubStyle)},
+	}
+	add := func(name string, t *template.Template, data interface{}) error {
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err != nil {
+			return err
+		}
+		files = append(files, epubFile{name, b.Bytes()})
+		return nil
+	}
+	pkg := struct {
+		*docsExport
+		Identifier string
+	}{e, siteURL("/docs/" + e.Section + "/")}
+	if err := add("OEBPS/content.opf", epubPackageTemplate, pkg); err != nil {
+		return nil, err
+	}
+	if err := add("OEBPS/nav.xhtml", epubNavTemplate, e); err != nil {
+		return nil, err
+	}
+	for i, p := range e.Pages {
+		page := struct {
+			exportPage
+			Source string
+		}{p, siteURL(p.URL)}
+		if err := add(fmt.Sprintf("OEBPS/page-%d.xhtml", i), epubPageTemplate, page); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	// The mimetype must come first, uncompressed.
+	w, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(w, "application/epub+zip")
+	for _, f := range files {
+		w, err := z.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(f.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// exportFormat is a format docs sections are exported to.
+type exportFormat struct {
+	contentType string
+	render      func(*docsExport) ([]byte, error)
+}
+
+// exportFormats are the export formats, by name.
+var exportFormats = map[string]exportFormat{
+	"epub": {"application/epub+zip", renderEPUB},
+	"pdf":  {"application/pdf", renderPDF},
+}
+
+var (
+	exportsMu sync.Mutex
+	exports   = make(map[string][]byte)
+
+	// exportsGeneration is incremented by resetExports, so that exports
+	// rendered from the content before are not kept.
+	exportsGeneration int
+)
+
+// resetExports drops the rendered exports, e.g. once the static content is
+// ready if they were rendered while it was still synced.
+func resetExports() {
+	exportsMu.Lock()
+	defer exportsMu.Unlock()
+	exports = make(map[string][]byte)
+	exportsGeneration++
+}
+
+// exportFlight deduplicates concurrent renderings of the same export.
+var exportFlight flightGroup
+
+// renderExport returns the docs section in the given format. Exports are kept
+// until reset, since the static dir only changes on deploy.
+func renderExport(staticDir, section, format string) ([]byte, error) {
+	key := section + "." + format
+	exportsMu.Lock()
+	b, ok := exports[key]
+	gen := exportsGeneration
+	exportsMu.Unlock()
+	if ok {
+		return b, nil
+	}
+	v, err := exportFlight.do(key, func() (interface{}, error) {
+		e, err := collectExport(staticDir, section)
+		if err != nil {
+			return nil, err
+		}
+		b, err := exportFormats[format].render(e)
+		if err != nil {
+			return nil, err
+		}
+		exportsMu.Lock()
+		if exportsGeneration == gen {
+			exports[key] = b
+		}
+		exportsMu.Unlock()
+		return b, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b, ok = v.([]byte)
+	if !ok {
+		return nil, errFlightPanicked
+	}
+	return b, nil
+}
+
+// docsExportHandler serves a docs section as a single document for offline
+// reading, e.g. /docs/export?section=user_guide&format=pdf.
+func docsExportHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		section := r.URL.Query().Get("section")
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "pdf"
+		}
+		f, ok := exportFormats[format]
+		if !ok {
+			httpError(w, r, "format must be pdf or epub", http.StatusBadRequest)
+			return
+		}
+		if !exportSectionRE.MatchString(section) {
+			httpError(w, r, "invalid section", http.StatusBadRequest)
+			return
+		}
+		b, err := renderExport(staticDir, section, format)
+		if os.IsNotExist(err) {
+			httpError(w, r, "unknown section "+section, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error exporting docs section %s as %s: %v", section, format, err)
+			httpError(w, r, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		hdr := w.Header()
+		hdr.Set("Content-Type", f.contentType)
+		hdr.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gvisor-%s.%s"`, section, format))
+		hdr.Set("Cache-Control", "public, max-age=3600")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+	})
+}
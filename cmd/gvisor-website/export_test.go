@@ -0,0 +1,233 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newExportTestDir returns a static dir with a user_guide docs section whose
+// navigation lists the install page before the FAQ.
+func newExportTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "export-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	page := func(title, main string) string {
+		return `<html><head><title>` + title + ` | gVisor</title></head><body>
+<nav><a href="/docs/user_guide/install/">Install</a><a href="/docs/user_guide/faq/">FAQ</a></nav>
+<main>` + main + `</main><script>track()</script></body></html>`
+	}
+	writeFiles(t, dir, map[string]string{
+		"docs/user_guide/index.html":     page("User Guide", `<h1>User Guide</h1><p>Start here.`),
+		"docs/user_guide/faq/index.html": page("FAQ", `<h1>FAQ</h1><ul><li>Why? <li>Because &mdash; security.</ul>`),
+		"docs/user_guide/install/index.html": page("Install", `<h1>Install</h1><pre>apt install runsc
+runsc --version</pre><img src="x.png"><table><tr><th>A<td>B</table>`),
+		"docs/user_guide/debugging/index.html": page("Debugging", `<h1>Debugging</h1><p>Use <a href="/docs/user_guide/faq/">the FAQ</a>.`),
+	})
+	return dir
+}
+
+func TestToXHTML(t *testing.T) {
+	for _, in := range []string{
+		`<p>Unclosed <b>bold<p>next`,
+		`<ul><li>one<li>two</ul><br><hr>`,
+		`<p>a &amp; b &nbsp;&mdash; <script>if (a < b) {}</script><style>p{}</style>c</p>`,
+		`</div><p>stray end tag</p>`,
+	} {
+		got, err := toXHTML([]byte(in))
+		if err != nil {
+			t.Errorf("toXHTML(%q) failed: %v", in, err)
+			continue
+		}
+		d := xml.NewDecoder(strings.NewReader("<body>" + got + "</body>"))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("toXHTML(%q) = %q, not well-formed: %v", in, got, err)
+				break
+			}
+		}
+		if strings.Contains(got, "script") || strings.Contains(got, "p{}") {
+			t.Errorf("toXHTML(%q) = %q, want scripts and styles dropped", in, got)
+		}
+	}
+}
+
+func TestCollectExport(t *testing.T) {
+	dir := newExportTestDir(t)
+	defer os.RemoveAll(dir)
+	e, err := collectExport(dir, "user_guide")
+	if err != nil {
+		t.Fatalf("collectExport failed: %v", err)
+	}
+	var titles []string
+	for _, p := range e.Pages {
+		titles = append(titles, p.Title)
+	}
+	if got, want := strings.Join(titles, ","), "User Guide,Install,FAQ,Debugging"; got != want {
+		t.Errorf("page titles = %s, want %s", got, want)
+	}
+	if e.Title != "gVisor: User Guide" {
+		t.Errorf("title = %q, want %q", e.Title, "gVisor: User Guide")
+	}
+	if body := e.Pages[3].Body; !strings.Contains(body, `href="`+siteURL("/docs/user_guide/faq/")+`"`) {
+		t.Errorf("debugging page body = %q, want absolute link to the FAQ", body)
+	}
+	if _, err := collectExport(dir, "missing"); !os.IsNotExist(err) {
+		t.Errorf("collectExport of a missing section: got error %v, want not exist", err)
+	}
+}
+
+func TestRenderEPUB(t *testing.T) {
+	dir := newExportTestDir(t)
+	defer os.RemoveAll(dir)
+	e, err := collectExport(dir, "user_guide")
+	if err != nil {
+		t.Fatalf("collectExport failed: %v", err)
+	}
+	b, err := renderEPUB(e)
+	if err != nil {
+		t.Fatalf("renderEPUB failed: %v", err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("EPUB is not a zip: %v", err)
+	}
+	if f := z.File[0]; f.Name != "mimetype" || f.Method != zip.Store {
+		t.Errorf("first entry = %s (method %d), want stored mimetype", f.Name, f.Method)
+	}
+	files := map[string]*zip.File{}
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"META-INF/container.xml", "OEBPS/content.opf", "OEBPS/nav.xhtml", "OEBPS/page-0.xhtml", "OEBPS/page-3.xhtml"} {
+		f, ok := files[name]
+		if !ok {
+			t.Errorf("EPUB has no %s", name)
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("Open %s failed: %v", name, err)
+		}
+		d := xml.NewDecoder(r)
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s is not well-formed: %v", name, err)
+				break
+			}
+		}
+		r.Close()
+	}
+}
+
+func TestRenderPDF(t *testing.T) {
+	dir := newExportTestDir(t)
+	defer os.RemoveAll(dir)
+	e, err := collectExport(dir, "user_guide")
+	if err != nil {
+		t.Fatalf("collectExport failed: %v", err)
+	}
+	b, err := renderPDF(e)
+	if err != nil {
+		t.Fatalf("renderPDF failed: %v", err)
+	}
+	if !bytes.HasPrefix(b, []byte("%PDF-")) || !bytes.HasSuffix(b, []byte("%%EOF\n")) {
+		t.Errorf("PDF has no header or trailer")
+	}
+	if got := bytes.Count(b, []byte("/Type /Page ")); got != 4 {
+		t.Errorf("PDF has %d pages, want 4", got)
+	}
+	for _, title := range []string{"(Install)", "(FAQ)", "(Debugging)"} {
+		if !bytes.Contains(b, []byte("/Title "+title)) {
+			t.Errorf("PDF outline has no %s entry", title)
+		}
+	}
+}
+
+func TestPDFBlocks(t *testing.T) {
+	body, err := toXHTML([]byte(`<h2>Flags</h2><p>Use  the
+<code>--debug</code> flag.</p><ul><li>one</li></ul><pre>a
+  b</pre><table><tr><th>Name</th><td>Value</td></tr></table>`))
+	if err != nil {
+		t.Fatalf("toXHTML failed: %v", err)
+	}
+	want := []pdfBlock{
+		{pdfH2, "Flags"},
+		{pdfText, "Use the --debug flag."},
+		{pdfText, "• one"},
+		{pdfCode, "a\n  b"},
+		{pdfText, "Name | Value"},
+	}
+	got := pdfBlocks(body)
+	if len(got) != len(want) {
+		t.Fatalf("pdfBlocks = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDocsExportHandler(t *testing.T) {
+	dir := newExportTestDir(t)
+	defer os.RemoveAll(dir)
+	defer func(m map[string][]byte) { exports = m }(exports)
+	exports = make(map[string][]byte)
+	h := docsExportHandler(dir)
+	for _, tc := range []struct {
+		url      string
+		wantCode int
+		wantType string
+		wantCD   string
+	}{
+		{"/docs/export?section=user_guide", http.StatusOK, "application/pdf", `attachment; filename="gvisor-user_guide.pdf"`},
+		{"/docs/export?section=user_guide&format=epub", http.StatusOK, "application/epub+zip", `attachment; filename="gvisor-user_guide.epub"`},
+		{"/docs/export?section=user_guide&format=docx", http.StatusBadRequest, "", ""},
+		{"/docs/export?section=../etc", http.StatusBadRequest, "", ""},
+		{"/docs/export", http.StatusBadRequest, "", ""},
+		{"/docs/export?section=architecture_guide", http.StatusNotFound, "", ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: got status %d, want %d", tc.url, w.Code, tc.wantCode)
+			continue
+		}
+		if tc.wantCode != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tc.wantType {
+			t.Errorf("%s: Content-Type = %q, want %q", tc.url, got, tc.wantType)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != tc.wantCD {
+			t.Errorf("%s: Content-Disposition = %q, want %q", tc.url, got, tc.wantCD)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// featureCookie holds the client's random rollout ID, which keeps the
+	// features enabled for a client stable across requests.
+	featureCookie = "gvisor_rollout"
+
+	// featureHeader forces features on or off for a request, as a
+	// comma-separated list of names, each optionally prefixed with "-".
+	// This lets changes be tested before they are rolled out.
+	featureHeader = "X-Gvisor-Features"
+)
+
+// feature is a feature flag, enabled for a percentage of clients.
+type feature struct {
+	// Name identifies the feature in code, headers and rewrite rules.
+	Name string `json:"name"`
+
+	// Percent is the percentage of clients the feature is enabled for.
+	Percent int `json:"percent"`
+}
+
+// features are the configured feature flags.
+var features []*feature
+
+// loadFeatures reads feature flags from the given JSON file. A missing file
+// is not an error; there are simply no features.
+func loadFeatures(file string) ([]*feature, error) {
+	var fs []*feature
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &fs); err != nil {
+		return nil, fmt.Errorf("invalid feature config %s: %v", file, err)
+	}
+	seen := make(map[string]bool)
+	for _, f := range fs {
+		if f.Name == "" || seen[f.Name] {
+			return nil, fmt.Errorf("feature %q: names must be unique and non-empty", f.Name)
+		}
+		if f.Percent < 0 || f.Percent > 100 {
+			return nil, fmt.Errorf("feature %q: percent must be between 0 and 100", f.Name)
+		}
+		seen[f.Name] = true
+	}
+	return fs, nil
+}
+
+// partialRollout returns true if any feature is enabled for only some
+// clients, i.e. if responses may depend on the rollout cookie.
+func partialRollout() bool {
+	for _, f := range features {
+		if f.Percent > 0 && f.Percent < 100 {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledFor returns true if the feature is enabled for the given rollout ID.
+// Each feature buckets clients independently.
+func (f *feature) enabledFor(id string) bool {
+	if f.Percent <= 0 || id == "" {
+		return f.Percent >= 100
+	}
+	sum := sha256.Sum256([]byte(f.Name + "\x00" + id))
+	return binary.BigEndian.Uint32(sum[:4])%100 < uint32(f.Percent)
+}
+
+// featuresKey is the context key for the set of enabled features.
+type featuresKey struct{}
+
+// featureEnabled returns true if the named feature is enabled for the given
+// request.
+func featureEnabled(r *http.Request, name string) bool {
+	enabled, _ := r.Context().Value(featuresKey{}).(map[string]bool)
+	return enabled[name]
+}
+
+// featuresHandler evaluates the feature flags for each request, so that
+// handlers and rewrite rules can query them with featureEnabled.
+//
+// Clients taking part in a partial rollout are given a sticky random ID in a
+// cookie, and responses are marked as varying by cookie.
+func featuresHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(features) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		var id string
+		if c, err := r.Cookie(featureCookie); err == nil {
+			id = c.Value
+		}
+		if partialRollout() {
+			addVary(w.Header(), "Cookie")
+			if id == "" && trafficClass(r) == classBrowser {
+				var b [8]byte
+				rand.Read(b[:])
+				id = hex.EncodeToString(b[:])
+				http.SetCookie(w, &http.Cookie{
+					Name:     featureCookie,
+					Value:    id,
+					Path:     "/",
+					Expires:  time.Now().Add(90 * 24 * time.Hour),
+					Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+					HttpOnly: true,
+				})
+			}
+		}
+		enabled := make(map[string]bool)
+		for _, f := range features {
+			enabled[f.Name] = f.enabledFor(id)
+		}
+		for _, name := range strings.Split(r.Header.Get(featureHeader), ",") {
+			name = strings.TrimSpace(name)
+			on := !strings.HasPrefix(name, "-")
+			name = strings.TrimPrefix(name, "-")
+			if _, ok := enabled[name]; ok {
+				enabled[name] = on
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), featuresKey{}, enabled)))
+	})
+}
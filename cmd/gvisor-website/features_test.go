@@ -0,0 +1,158 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadFeatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "features-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if fs, err := loadFeatures(filepath.Join(dir, "missing.json")); err != nil || fs != nil {
+		t.Errorf("loadFeatures of a missing file = %v, %v, want no features", fs, err)
+	}
+	for _, tc := range []struct {
+		config string
+		want   []*feature
+	}{
+		{`[{"name":"new-nav","percent":10},{"name":"search-v2","percent":100}]`, []*feature{{"new-nav", 10}, {"search-v2", 100}}},
+		{`[]`, []*feature{}},
+		{`{"name":"new-nav"}`, nil},
+		{`[{"name":"","percent":10}]`, nil},
+		{`[{"name":"new-nav","percent":10},{"name":"new-nav","percent":20}]`, nil},
+		{`[{"name":"new-nav","percent":101}]`, nil},
+		{`[{"name":"new-nav","percent":-1}]`, nil},
+	} {
+		file := filepath.Join(dir, "features.json")
+		if err := ioutil.WriteFile(file, []byte(tc.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := loadFeatures(file)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("loadFeatures(%s) succeeded, want an error", tc.config)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(fs, tc.want) {
+			t.Errorf("loadFeatures(%s) = %v, %v, want %v", tc.config, fs, err, tc.want)
+		}
+	}
+}
+
+func TestFeatureEnabledFor(t *testing.T) {
+	off, on, half := &feature{"off", 0}, &feature{"on", 100}, &feature{"half", 50}
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("%016x", i)
+		if off.enabledFor(id) || !on.enabledFor(id) {
+			t.Fatalf("rollout ID %s: got 0%% enabled or 100%% disabled", id)
+		}
+		if half.enabledFor(id) {
+			enabled++
+		}
+		if half.enabledFor(id) != half.enabledFor(id) {
+			t.Fatalf("rollout ID %s: unstable result", id)
+		}
+	}
+	if enabled < 400 || enabled > 600 {
+		t.Errorf("50%% feature enabled for %d of 1000 clients", enabled)
+	}
+	// Clients without a rollout ID only get fully rolled out features.
+	if half.enabledFor("") || !on.enabledFor("") {
+		t.Errorf("features without a rollout ID: got half %t and on %t, want false and true", half.enabledFor(""), on.enabledFor(""))
+	}
+}
+
+func TestFeaturesHandler(t *testing.T) {
+	defer func(fs []*feature) { features = fs }(features)
+	var got map[string]bool
+	h := classifyHandler("test", featuresHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = make(map[string]bool)
+		for _, name := range []string{"on", "off", "half", "unknown"} {
+			if featureEnabled(r, name) {
+				got[name] = true
+			}
+		}
+	})))
+	do := func(userAgent string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/docs/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	const browser = "Mozilla/5.0 (X11; Linux x86_64) Firefox/70.0"
+
+	// Without partial rollouts, no cookie is needed.
+	features = []*feature{{"on", 100}, {"off", 0}}
+	w := do(browser, nil)
+	if !reflect.DeepEqual(got, map[string]bool{"on": true}) || w.Header().Get("Set-Cookie") != "" || w.Header().Get("Vary") != "" {
+		t.Errorf("full rollout: got features %v, Set-Cookie %q and Vary %q, want on without a cookie", got, w.Header().Get("Set-Cookie"), w.Header().Get("Vary"))
+	}
+	do(browser, http.Header{"X-Gvisor-Features": {"-on, off,unknown"}})
+	if !reflect.DeepEqual(got, map[string]bool{"off": true}) {
+		t.Errorf("forced features: got %v, want only off", got)
+	}
+
+	// Browsers are given a rollout ID, which keeps their features stable.
+	features = []*feature{{"on", 100}, {"half", 50}}
+	w = do(browser, nil)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != featureCookie || !cookies[0].HttpOnly || !strings.Contains(w.Header().Get("Vary"), "Cookie") {
+		t.Fatalf("partial rollout: got cookies %v and Vary %q, want a rollout cookie and Vary: Cookie", cookies, w.Header().Get("Vary"))
+	}
+	id := cookies[0].Value
+	want := map[string]bool{"on": true}
+	if (&feature{"half", 50}).enabledFor(id) {
+		want["half"] = true
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("partial rollout: got features %v, want %v for rollout ID %s", got, want, id)
+	}
+	w = do(browser, http.Header{"Cookie": {featureCookie + "=" + id}})
+	if !reflect.DeepEqual(got, want) || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("returning client: got features %v and Set-Cookie %q, want %v without a new cookie", got, w.Header().Get("Set-Cookie"), want)
+	}
+
+	// Crawlers aren't given a rollout ID, and only get full rollouts.
+	w = do("Googlebot/2.1 (+http://www.google.com/bot.html)", nil)
+	if !reflect.DeepEqual(got, map[string]bool{"on": true}) || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("crawler: got features %v and Set-Cookie %q, want on without a cookie", got, w.Header().Get("Set-Cookie"))
+	}
+
+	// Without features, nothing is enabled.
+	features = nil
+	do(browser, http.Header{"X-Gvisor-Features": {"on"}})
+	if len(got) != 0 {
+		t.Errorf("no features: got %v, want none", got)
+	}
+}
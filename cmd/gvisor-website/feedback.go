@@ -0,0 +1,320 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFeedbackComment bounds the length of a feedback comment.
+	maxFeedbackComment = 2000
+
+	// maxSummaryComments is the number of recent comments returned per page
+	// in the summary.
+	maxSummaryComments = 20
+
+	// maxMemoryFeedback bounds the number of responses kept by the memory
+	// store; the oldest are dropped first.
+	maxMemoryFeedback = 10000
+)
+
+// feedback is a single "was this page helpful?" response.
+type feedback struct {
+	Page    string    `json:"page"`
+	Helpful bool      `json:"helpful"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// feedbackStore persists feedback.
+type feedbackStore interface {
+	// add stores the given feedback.
+	add(ctx context.Context, f feedback) error
+
+	// all returns all stored feedback.
+	all(ctx context.Context) ([]feedback, error)
+}
+
+// newFeedbackStore returns a store of the given type, which is either
+// "memory" or "firestore".
+func newFeedbackStore(ctx context.Context, store string) (feedbackStore, error) {
+	switch store {
+	case "", "memory":
+		return &memoryFeedback{}, nil
+	case "firestore":
+		fs, err := newFirestoreClient(ctx, *projectId)
+		if err != nil {
+			return nil, err
+		}
+		return &firestoreFeedback{fs: fs}, nil
+	default:
+		return nil, fmt.Errorf("unknown feedback store %q", store)
+	}
+}
+
+// memoryFeedback keeps the most recent feedback in memory. Feedback is lost
+// on restart, so this is only suitable for development.
+type memoryFeedback struct {
+	mu       sync.Mutex
+	feedback []feedback
+}
+
+func (m *memoryFeedback) add(ctx context.Context, f feedback) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.feedback) == maxMemoryFeedback {
+		m.feedback = append(m.feedback[:0], m.feedback[1:]...)
+	}
+	m.feedback = append(m.feedback, f)
+	return nil
+}
+
+func (m *memoryFeedback) all(ctx context.Context) ([]feedback, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]feedback(nil), m.feedback...), nil
+}
+
+// feedbackCollection is the Firestore collection holding feedback.
+const feedbackCollection = "feedback"
+
+// firestoreFeedback stores feedback in Firestore, one document per response.
+type firestoreFeedback struct {
+	fs *firestoreClient
+}
+
+func (f *firestoreFeedback) add(ctx context.Context, fb feedback) error {
+	return f.fs.create(ctx, feedbackCollection, map[string]firestoreValue{
+		"page":    stringValue(fb.Page),
+		"helpful": boolValue(fb.Helpful),
+		"comment": stringValue(fb.Comment),
+		"time":    timestampValue(fb.Time),
+	})
+}
+
+func (f *firestoreFeedback) all(ctx context.Context) ([]feedback, error) {
+	docs, err := f.fs.list(ctx, feedbackCollection)
+	if err != nil {
+		return nil, err
+	}
+	fbs := make([]feedback, 0, len(docs))
+	for _, doc := range docs {
+		fbs = append(fbs, feedback{
+			Page:    doc.Fields["page"].str(),
+			Helpful: doc.Fields["helpful"].bool(),
+			Comment: doc.Fields["comment"].str(),
+			Time:    doc.Fields["time"].time(),
+		})
+	}
+	return fbs, nil
+}
+
+// recaptchaAction is the reCAPTCHA v3 action the feedback widget executes.
+const recaptchaAction = "feedback"
+
+// recaptchaVerifyURL is the reCAPTCHA verification API, replaced in tests.
+var recaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+
+// verifyRecaptcha checks the given reCAPTCHA response token. It always
+// succeeds if no reCAPTCHA secret is configured.
+func verifyRecaptcha(ctx context.Context, token, remoteIP string) error {
+	if *recaptchaSecret == "" {
+		return nil
+	}
+	if token == "" {
+		return fmt.Errorf("missing reCAPTCHA response")
+	}
+	req, err := http.NewRequest("POST", recaptchaVerifyURL, strings.NewReader(url.Values{
+		"secret":   {*recaptchaSecret},
+		"response": {token},
+		"remoteip": {remoteIP},
+	}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := upstreamClient("recaptcha").Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool     `json:"success"`
+		Score   *float64 `json:"score"`
+		Action  string   `json:"action"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("reCAPTCHA verification failed")
+	}
+	// Only reCAPTCHA v3 returns a score and action.
+	if result.Score != nil && *result.Score < *recaptchaMinScore {
+		return fmt.Errorf("reCAPTCHA score too low")
+	}
+	if result.Action != "" && result.Action != recaptchaAction {
+		return fmt.Errorf("unexpected reCAPTCHA action %q", result.Action)
+	}
+	return nil
+}
+
+// feedbackRequest is the body accepted by /api/feedback, either as JSON or as
+// a form.
+type feedbackRequest struct {
+	Page      string `json:"page"`
+	Helpful   bool   `json:"helpful"`
+	Comment   string `json:"comment"`
+	Recaptcha string `json:"g-recaptcha-response"`
+
+	// Website is a honeypot field hidden from humans. Bots filling in every
+	// field will set it.
+	Website string `json:"website"`
+}
+
+// parseFeedbackRequest decodes the request body.
+func parseFeedbackRequest(r *http.Request) (*feedbackRequest, error) {
+	var fr feedbackRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&fr); err != nil {
+			return nil, err
+		}
+		return &fr, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	helpful, err := strconv.ParseBool(r.PostForm.Get("helpful"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid helpful value")
+	}
+	fr.Page = r.PostForm.Get("page")
+	fr.Helpful = helpful
+	fr.Comment = r.PostForm.Get("comment")
+	fr.Recaptcha = r.PostForm.Get("g-recaptcha-response")
+	fr.Website = r.PostForm.Get("website")
+	return &fr, nil
+}
+
+// feedbackHandler returns a handler recording page feedback. Only feedback
+// for pages in the static dir is accepted.
+func feedbackHandler(store feedbackStore, staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 16<<10)
+		fr, err := parseFeedbackRequest(r)
+		if err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if fr.Website != "" {
+			// Pretend to succeed so that bots don't adapt.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		page, ok := knownPage(staticDir, fr.Page)
+		if !ok {
+			httpError(w, r, "invalid request: unknown page", http.StatusBadRequest)
+			return
+		}
+		if len(fr.Comment) > maxFeedbackComment {
+			httpError(w, r, "invalid request: comment too long", http.StatusBadRequest)
+			return
+		}
+		if err := verifyRecaptcha(r.Context(), fr.Recaptcha, clientIP(r)); err != nil {
+			httpError(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err := store.add(r.Context(), feedback{
+			Page:    page,
+			Helpful: fr.Helpful,
+			Comment: strings.TrimSpace(fr.Comment),
+			Time:    time.Now(),
+		}); err != nil {
+			log.Printf("Error storing feedback: %v", err)
+			httpError(w, r, "feedback is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// pageFeedback summarizes the feedback for a single page.
+type pageFeedback struct {
+	Page      string     `json:"page"`
+	Helpful   int        `json:"helpful"`
+	Unhelpful int        `json:"unhelpful"`
+	Comments  []feedback `json:"comments,omitempty"`
+}
+
+// summarizeFeedback aggregates feedback per page, ordered by the number of
+// unhelpful responses so that the pages most in need of work come first.
+func summarizeFeedback(fbs []feedback) []*pageFeedback {
+	// Sort a copy, newest first, to leave the caller's slice alone.
+	fbs = append([]feedback(nil), fbs...)
+	sort.Slice(fbs, func(i, j int) bool {
+		return fbs[i].Time.After(fbs[j].Time)
+	})
+	pages := make(map[string]*pageFeedback)
+	var summary []*pageFeedback
+	for _, fb := range fbs {
+		p, ok := pages[fb.Page]
+		if !ok {
+			p = &pageFeedback{Page: fb.Page}
+			pages[fb.Page] = p
+			summary = append(summary, p)
+		}
+		if fb.Helpful {
+			p.Helpful++
+		} else {
+			p.Unhelpful++
+		}
+		if fb.Comment != "" && len(p.Comments) < maxSummaryComments {
+			p.Comments = append(p.Comments, fb)
+		}
+	}
+	sort.SliceStable(summary, func(i, j int) bool {
+		return summary[i].Unhelpful > summary[j].Unhelpful
+	})
+	return summary
+}
+
+// feedbackSummaryHandler returns a handler serving the feedback summary.
+func feedbackSummaryHandler(store feedbackStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fbs, err := store.all(r.Context())
+		if err != nil {
+			httpError(w, r, "store error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summarizeFeedback(fbs))
+	})
+}
@@ -0,0 +1,196 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingFeedback is a feedback store whose writes fail.
+type failingFeedback struct {
+	memoryFeedback
+}
+
+func (f *failingFeedback) add(ctx context.Context, fb feedback) error {
+	return errors.New("firestore POST projects/gvisor-website/databases/(default)/documents/feedback: 500")
+}
+
+func TestFeedbackHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "feedback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "docs", "index.html"), []byte("<p>docs</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryFeedback{}
+	h := feedbackHandler(store, dir)
+	post := func(h http.Handler, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/feedback", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, tc := range []struct {
+		name, contentType, body string
+		want                    int
+	}{
+		{"json", "application/json", `{"page": "/docs/", "helpful": true}`, http.StatusNoContent},
+		{"form", "application/x-www-form-urlencoded", "page=/docs&helpful=false&comment=+Unclear.+", http.StatusNoContent},
+		{"honeypot", "application/x-www-form-urlencoded", "page=/docs/&helpful=true&website=spam", http.StatusNoContent},
+		{"unknown page", "application/json", `{"page": "/missing/", "helpful": true}`, http.StatusBadRequest},
+		{"invalid helpful", "application/x-www-form-urlencoded", "page=/docs/&helpful=maybe", http.StatusBadRequest},
+		{"comment too long", "application/json", fmt.Sprintf(`{"page": "/docs/", "comment": %q}`, strings.Repeat("x", maxFeedbackComment+1)), http.StatusBadRequest},
+	} {
+		if w := post(h, tc.contentType, tc.body); w.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d: %s", tc.name, w.Code, tc.want, w.Body)
+		}
+	}
+	// The honeypot submission isn't stored.
+	fbs, _ := store.all(context.Background())
+	if len(fbs) != 2 {
+		t.Fatalf("got %d stored responses, want 2: %+v", len(fbs), fbs)
+	}
+	if fb := fbs[1]; fb.Page != "/docs/" || fb.Helpful || fb.Comment != "Unclear." || fb.Time.IsZero() {
+		t.Errorf("got stored feedback %+v, want the trimmed form response", fb)
+	}
+
+	// Store errors aren't disclosed.
+	w := post(feedbackHandler(&failingFeedback{}, dir), "application/json", `{"page": "/docs/", "helpful": true}`)
+	if w.Code != http.StatusServiceUnavailable || strings.Contains(w.Body.String(), "projects/") {
+		t.Errorf("store error: got status %d and body %q, want 503 without the error", w.Code, w.Body)
+	}
+}
+
+func TestVerifyRecaptcha(t *testing.T) {
+	defer func(secret string, score float64, u string) {
+		*recaptchaSecret, *recaptchaMinScore, recaptchaVerifyURL = secret, score, u
+	}(*recaptchaSecret, *recaptchaMinScore, recaptchaVerifyURL)
+	var reply string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") != "secret" || r.PostFormValue("response") != "token" {
+			reply = `{"success": false}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, reply)
+	}))
+	defer srv.Close()
+	recaptchaVerifyURL = srv.URL
+
+	// Verification is disabled without a secret.
+	*recaptchaSecret = ""
+	if err := verifyRecaptcha(context.Background(), "", "192.0.2.1"); err != nil {
+		t.Errorf("verifyRecaptcha without a secret failed: %v", err)
+	}
+
+	*recaptchaSecret, *recaptchaMinScore = "secret", 0.5
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, tc := range []struct {
+		name, token, reply string
+		ok                 bool
+	}{
+		{"missing token", "", `{"success": true}`, false},
+		{"v2", "token", `{"success": true}`, true},
+		{"above threshold", "token", `{"success": true, "score": 0.9, "action": "feedback"}`, true},
+		{"at threshold", "token", `{"success": true, "score": 0.5, "action": "feedback"}`, true},
+		{"below threshold", "token", `{"success": true, "score": 0.3, "action": "feedback"}`, false},
+		{"other action", "token", `{"success": true, "score": 0.9, "action": "login"}`, false},
+		{"failed", "token", `{"success": false}`, false},
+	} {
+		reply = tc.reply
+		if err := verifyRecaptcha(ctx, tc.token, "192.0.2.1"); (err == nil) != tc.ok {
+			t.Errorf("%s: verifyRecaptcha = %v, want success %v", tc.name, err, tc.ok)
+		}
+	}
+
+	// Feedback below the threshold is rejected.
+	reply = `{"success": true, "score": 0.1, "action": "feedback"}`
+	dir, err := ioutil.TempDir("", "feedback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryFeedback{}
+	r := httptest.NewRequest("POST", "/api/feedback", strings.NewReader(`{"page": "/", "helpful": true, "g-recaptcha-response": "token"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	feedbackHandler(store, dir).ServeHTTP(w, r)
+	if fbs, _ := store.all(context.Background()); w.Code != http.StatusForbidden || len(fbs) != 0 {
+		t.Errorf("got status %d and %d stored responses, want 403 and none", w.Code, len(fbs))
+	}
+}
+
+func TestMemoryFeedbackLimit(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryFeedback{}
+	start := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxMemoryFeedback+2; i++ {
+		if err := store.add(ctx, feedback{Page: "/docs/", Time: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	fbs, err := store.all(ctx)
+	if err != nil {
+		t.Fatalf("all failed: %v", err)
+	}
+	if len(fbs) != maxMemoryFeedback || !fbs[0].Time.Equal(start.Add(2*time.Second)) {
+		t.Errorf("got %d responses from %v, want the %d newest", len(fbs), fbs[0].Time, maxMemoryFeedback)
+	}
+}
+
+func TestSummarizeFeedback(t *testing.T) {
+	start := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	fbs := []feedback{
+		{Page: "/docs/", Helpful: true, Time: start},
+		{Page: "/blog/", Comment: "outdated", Time: start.Add(time.Minute)},
+		{Page: "/docs/", Comment: "too short", Time: start.Add(2 * time.Minute)},
+		{Page: "/blog/", Time: start.Add(3 * time.Minute)},
+	}
+	in := append([]feedback(nil), fbs...)
+	summary := summarizeFeedback(fbs)
+	if !reflect.DeepEqual(fbs, in) {
+		t.Errorf("summarizeFeedback reordered its argument to %v", fbs)
+	}
+	if len(summary) != 2 {
+		t.Fatalf("got %d pages, want 2", len(summary))
+	}
+	if p := summary[0]; p.Page != "/blog/" || p.Helpful != 0 || p.Unhelpful != 2 || len(p.Comments) != 1 {
+		t.Errorf("got first page %+v, want /blog/ with 2 unhelpful responses and a comment", p)
+	}
+	if p := summary[1]; p.Page != "/docs/" || p.Helpful != 1 || p.Unhelpful != 1 {
+		t.Errorf("got second page %+v, want /docs/ with a helpful and an unhelpful response", p)
+	}
+}
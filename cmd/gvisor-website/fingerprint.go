@@ -0,0 +1,115 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+)
+
+// fingerprintedRE matches fingerprinted asset paths, e.g.
+// /js/main.0123456789abcdef.js, capturing the original name parts.
+var fingerprintedRE = regexp.MustCompile(`^(.+)\.([0-9a-f]{16})(\.(?:css|js))$`)
+
+// fingerprintable returns true if references to the given asset path are
+// fingerprinted.
+func fingerprintable(p string) bool {
+	switch path.Ext(p) {
+	case ".css", ".js":
+		return true
+	}
+	return false
+}
+
+// fingerprint returns the fingerprinted path of the given asset, or "" if the
+// asset is not in the manifest.
+func fingerprint(m *staticManifest, p string) string {
+	hash, ok := m.Files[p]
+	if !ok || !fingerprintable(p) {
+		return ""
+	}
+	ext := path.Ext(p)
+	return p[:len(p)-len(ext)] + "." + hash + ext
+}
+
+// assetURL returns the path under which the given asset is referenced: its
+// fingerprinted path if fingerprinting is enabled and the asset is known, or
+// the path itself.
+func assetURL(staticDir, p string) string {
+	if !*fingerprintAssets {
+		return p
+	}
+	if m := getStaticManifest(staticDir); m != nil {
+		if fp := fingerprint(m, p); fp != "" {
+			return fp
+		}
+	}
+	return p
+}
+
+// assetFilter returns the HTML filter rewriting src and href references to
+// CSS and JS assets in the static dir to their fingerprinted paths.
+func assetFilter(staticDir string) htmlFilter {
+	refRE := regexp.MustCompile(`(\b(?:src|href)\s*=\s*["']?)((?:https://` + regexp.QuoteMeta(*customHost) + `)?)(/[^"'\s?#>]+\.(?:css|js))(["'?#\s>])`)
+	return func(b []byte) []byte {
+		return refRE.ReplaceAllFunc(b, func(ref []byte) []byte {
+			sub := refRE.FindSubmatch(ref)
+			return []byte(string(sub[1]) + string(sub[2]) + assetURL(staticDir, string(sub[3])) + string(sub[4]))
+		})
+	}
+}
+
+// withPath returns a shallow copy of the request for the given URL path.
+func withPath(r *http.Request, p string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	return r2
+}
+
+// fingerprintHandler serves fingerprinted asset paths from the original files.
+// Paths whose fingerprint matches the current contents are cached
+// indefinitely; stale fingerprints, e.g. from pages cached before a deploy,
+// still get the current contents but must be revalidated.
+func fingerprintHandler(staticDir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub := fingerprintedRE.FindStringSubmatch(r.URL.Path)
+		if sub == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		orig := sub[1] + sub[3]
+		m := getStaticManifest(staticDir)
+		if m == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		hash, ok := m.Files[orig]
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if hash == sub[2] {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		h.ServeHTTP(w, withPath(r, orig))
+	})
+}
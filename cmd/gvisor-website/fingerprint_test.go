@@ -0,0 +1,120 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newFingerprintDir returns a static dir with assets, and its manifest.
+func newFingerprintDir(t *testing.T) (string, *staticManifest) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "fingerprint-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"css/main.css":    "body{}",
+		"js/main.js":      "main()",
+		"images/logo.png": "png",
+	})
+	return dir, getStaticManifest(dir)
+}
+
+func TestAssetURL(t *testing.T) {
+	dir, m := newFingerprintDir(t)
+	defer os.RemoveAll(dir)
+	defer setStaticManifest(dir, nil)
+	defer func(fp bool) { *fingerprintAssets = fp }(*fingerprintAssets)
+
+	*fingerprintAssets = true
+	for p, want := range map[string]string{
+		"/css/main.css":    "/css/main." + m.Files["/css/main.css"] + ".css",
+		"/js/main.js":      "/js/main." + m.Files["/js/main.js"] + ".js",
+		"/js/missing.js":   "/js/missing.js",
+		"/images/logo.png": "/images/logo.png",
+	} {
+		if got := assetURL(dir, p); got != want {
+			t.Errorf("assetURL(%q) = %q, want %q", p, got, want)
+		}
+	}
+	*fingerprintAssets = false
+	if got := assetURL(dir, "/css/main.css"); got != "/css/main.css" {
+		t.Errorf("assetURL with fingerprinting disabled = %q, want the path", got)
+	}
+}
+
+func TestAssetFilter(t *testing.T) {
+	dir, m := newFingerprintDir(t)
+	defer os.RemoveAll(dir)
+	defer setStaticManifest(dir, nil)
+	defer func(fp bool) { *fingerprintAssets = fp }(*fingerprintAssets)
+	*fingerprintAssets = true
+
+	css := "/css/main." + m.Files["/css/main.css"] + ".css"
+	js := "/js/main." + m.Files["/js/main.js"] + ".js"
+	filter := assetFilter(dir)
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{`<link rel="stylesheet" href="/css/main.css">`, `<link rel="stylesheet" href="` + css + `">`},
+		{`<script src='/js/main.js'></script>`, `<script src='` + js + `'></script>`},
+		{`<script src=/js/main.js></script>`, `<script src=` + js + `></script>`},
+		{`<link href="https://gvisor.dev/css/main.css?v=1">`, `<link href="https://gvisor.dev` + css + `?v=1">`},
+		{`<script src="/js/missing.js"></script>`, `<script src="/js/missing.js"></script>`},
+		{`<img src="/images/logo.png">`, `<img src="/images/logo.png">`},
+		{`<link href="https://cdn.example.com/css/main.css">`, `<link href="https://cdn.example.com/css/main.css">`},
+		{`<p>See /css/main.css</p>`, `<p>See /css/main.css</p>`},
+	} {
+		if got := string(filter([]byte(tc.in))); got != tc.want {
+			t.Errorf("filter(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestFingerprintHandler(t *testing.T) {
+	dir, m := newFingerprintDir(t)
+	defer os.RemoveAll(dir)
+	defer setStaticManifest(dir, nil)
+	h := fingerprintHandler(dir, http.FileServer(http.Dir(dir)))
+
+	for _, tc := range []struct {
+		path         string
+		code         int
+		body         string
+		cacheControl string
+	}{
+		{"/css/main." + m.Files["/css/main.css"] + ".css", http.StatusOK, "body{}", "public, max-age=31536000, immutable"},
+		{"/js/main." + m.Files["/js/main.js"] + ".js", http.StatusOK, "main()", "public, max-age=31536000, immutable"},
+		// Pages cached before a deploy may reference old fingerprints.
+		{"/css/main.0123456789abcdef.css", http.StatusOK, "body{}", "no-cache"},
+		{"/css/other.0123456789abcdef.css", http.StatusNotFound, "", ""},
+		{"/css/main.css", http.StatusOK, "body{}", ""},
+		{"/images/logo.0123456789abcdef.png", http.StatusNotFound, "", ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.code || (tc.body != "" && w.Body.String() != tc.body) {
+			t.Errorf("GET %s: got status %d and body %q, want %d and %q", tc.path, w.Code, w.Body, tc.code, tc.body)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != tc.cacheControl {
+			t.Errorf("GET %s: got Cache-Control %q, want %q", tc.path, cc, tc.cacheControl)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// datastoreScope is the OAuth scope required by the Firestore REST API.
+const datastoreScope = "https://www.googleapis.com/auth/datastore"
+
+// firestoreClient is a minimal client for the Cloud Firestore REST API.
+//
+// Only flat documents with scalar fields are supported, which is all the
+// website needs. See: https://cloud.google.com/firestore/docs/reference/rest
+type firestoreClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+// newFirestoreClient returns a client for the default database of the given
+// project using the application default credentials.
+func newFirestoreClient(ctx context.Context, projectID string) (*firestoreClient, error) {
+	if projectID == "" {
+		return nil, fmt.Errorf("firestore requires a project ID")
+	}
+	client, err := googleClient(ctx, "firestore", datastoreScope)
+	if err != nil {
+		return nil, err
+	}
+	return &firestoreClient{
+		client:  client,
+		baseURL: "https://firestore.googleapis.com/v1/projects/" + projectID + "/databases/(default)/documents",
+	}, nil
+}
+
+// firestoreValue is a single typed field value.
+type firestoreValue struct {
+	StringValue    *string  `json:"stringValue,omitempty"`
+	IntegerValue   *string  `json:"integerValue,omitempty"`
+	DoubleValue    *float64 `json:"doubleValue,omitempty"`
+	BooleanValue   *bool    `json:"booleanValue,omitempty"`
+	TimestampValue *string  `json:"timestampValue,omitempty"`
+}
+
+// firestoreDocument is a document as returned by the REST API.
+type firestoreDocument struct {
+	Name       string                    `json:"name,omitempty"`
+	Fields     map[string]firestoreValue `json:"fields"`
+	UpdateTime string                    `json:"updateTime,omitempty"`
+}
+
+func stringValue(s string) firestoreValue {
+	return firestoreValue{StringValue: &s}
+}
+
+func doubleValue(f float64) firestoreValue {
+	return firestoreValue{DoubleValue: &f}
+}
+
+func boolValue(b bool) firestoreValue {
+	return firestoreValue{BooleanValue: &b}
+}
+
+func timestampValue(t time.Time) firestoreValue {
+	s := t.UTC().Format(time.RFC3339Nano)
+	return firestoreValue{TimestampValue: &s}
+}
+
+// str returns the string value of the field, or "" if it is not a string.
+func (v firestoreValue) str() string {
+	if v.StringValue == nil {
+		return ""
+	}
+	return *v.StringValue
+}
+
+// float returns the numeric value of the field, or 0 if it is not a number.
+func (v firestoreValue) float() float64 {
+	if v.DoubleValue != nil {
+		return *v.DoubleValue
+	}
+	if v.IntegerValue != nil {
+		f, _ := strconv.ParseFloat(*v.IntegerValue, 64)
+		return f
+	}
+	return 0
+}
+
+// bool returns the boolean value of the field, or false if it is not a
+// boolean.
+func (v firestoreValue) bool() bool {
+	return v.BooleanValue != nil && *v.BooleanValue
+}
+
+// time returns the timestamp value of the field, or the zero time if it is not
+// a timestamp.
+func (v firestoreValue) time() time.Time {
+	if v.TimestampValue == nil {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339Nano, *v.TimestampValue)
+	return t
+}
+
+// do issues a request against the given path relative to the documents root
+// and decodes the response into out, if non-nil.
+func (c *firestoreClient) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("firestore %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// list returns all documents in the given collection.
+func (c *firestoreClient) list(ctx context.Context, collection string) ([]firestoreDocument, error) {
+	var docs []firestoreDocument
+	query := url.Values{"pageSize": {"300"}}
+	for {
+		var page struct {
+			Documents     []firestoreDocument `json:"documents"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		if err := c.do(ctx, "GET", "/"+collection, query, nil, &page); err != nil {
+			return nil, err
+		}
+		docs = append(docs, page.Documents...)
+		if page.NextPageToken == "" {
+			return docs, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// set creates or replaces the document with the given ID.
+func (c *firestoreClient) set(ctx context.Context, collection, id string, fields map[string]firestoreValue) error {
+	return c.do(ctx, "PATCH", "/"+collection+"/"+url.PathEscape(id), nil, firestoreDocument{Fields: fields}, nil)
+}
+
+// create adds a document with a server-assigned ID.
+func (c *firestoreClient) create(ctx context.Context, collection string, fields map[string]firestoreValue) error {
+	return c.do(ctx, "POST", "/"+collection, nil, firestoreDocument{Fields: fields}, nil)
+}
+
+// where returns up to limit documents in the given collection whose string
+// fields equal the given values, or all of them if limit is 0.
+func (c *firestoreClient) where(ctx context.Context, collection string, equal map[string]string, limit int) ([]firestoreDocument, error) {
+	type fieldFilter struct {
+		Field struct {
+			FieldPath string `json:"fieldPath"`
+		} `json:"field"`
+		Op    string         `json:"op"`
+		Value firestoreValue `json:"value"`
+	}
+	type filter struct {
+		FieldFilter fieldFilter `json:"fieldFilter"`
+	}
+	var filters []filter
+	for field, value := range equal {
+		var f filter
+		f.FieldFilter.Field.FieldPath = field
+		f.FieldFilter.Op = "EQUAL"
+		f.FieldFilter.Value = stringValue(value)
+		filters = append(filters, f)
+	}
+	query := map[string]interface{}{
+		"from": []map[string]string{{"collectionId": collection}},
+	}
+	if len(filters) > 0 {
+		query["where"] = map[string]interface{}{
+			"compositeFilter": map[string]interface{}{
+				"op":      "AND",
+				"filters": filters,
+			},
+		}
+	}
+	if limit > 0 {
+		query["limit"] = limit
+	}
+	var results []struct {
+		Document *firestoreDocument `json:"document"`
+	}
+	if err := c.do(ctx, "POST", ":runQuery", nil, map[string]interface{}{"structuredQuery": query}, &results); err != nil {
+		return nil, err
+	}
+	var docs []firestoreDocument
+	for _, r := range results {
+		if r.Document != nil {
+			docs = append(docs, *r.Document)
+		}
+	}
+	return docs, nil
+}
+
+// delete removes the document with the given ID.
+func (c *firestoreClient) delete(ctx context.Context, collection, id string) error {
+	return c.do(ctx, "DELETE", "/"+collection+"/"+url.PathEscape(id), nil, nil, nil)
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// flightCall is a call in progress or completed in a flightGroup.
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// errFlightPanicked is returned to the callers waiting for a call that
+// panicked.
+var errFlightPanicked = errors.New("shared call panicked")
+
+// flightGroup deduplicates concurrent calls for the same key, so that a burst
+// of requests missing the cache makes a single upstream fetch.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do calls fn and returns its results. Callers with the same key while fn is
+// running wait for it and share its results. If fn panics, the panic
+// propagates in the caller that made the call, and the waiting callers get
+// errFlightPanicked.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.value, c.err
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	returned := false
+	defer func() {
+		if !returned {
+			c.value, c.err = nil, errFlightPanicked
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.value, c.err = fn()
+	returned = true
+	return c.value, c.err
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var (
+		g       flightGroup
+		calls   int32
+		started = make(chan struct{})
+		release = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	fn := func() (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "v", nil
+	}
+	results := make([]interface{}, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = g.do("k", fn)
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do("k", fn)
+		}(i)
+	}
+	// Give the followers time to join the call in progress.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	for i, v := range results {
+		if v != "v" {
+			t.Errorf("result %d = %v, want v", i, v)
+		}
+	}
+
+	// Once done, calls are made again.
+	if v, err := g.do("k", func() (interface{}, error) { return "w", nil }); v != "w" || err != nil {
+		t.Errorf("do after completion = %v, %v, want w", v, err)
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+	var (
+		g       flightGroup
+		started = make(chan struct{})
+		release = make(chan struct{})
+		waiter  = make(chan error)
+	)
+	leader := make(chan interface{})
+	go func() {
+		defer func() { leader <- recover() }()
+		g.do("k", func() (interface{}, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+	go func() {
+		v, err := g.do("k", func() (interface{}, error) { return "w", nil })
+		if v != nil {
+			t.Errorf("waiter got value %v, want none", v)
+		}
+		waiter <- err
+	}()
+	// Give the waiter time to join the call in progress.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if p := <-leader; p != "boom" {
+		t.Errorf("leader recovered %v, want the panic", p)
+	}
+	if err := <-waiter; err != errFlightPanicked {
+		t.Errorf("waiter got error %v, want errFlightPanicked", err)
+	}
+
+	// The panicked call isn't shared with later calls.
+	if v, err := g.do("k", func() (interface{}, error) { return "w", nil }); v != "w" || err != nil {
+		t.Errorf("do after a panic = %v, %v, want w", v, err)
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// g3docDir is the dir of the docs in the gVisor repository.
+	g3docDir = "g3doc"
+
+	// g3docRebuildWait is how long a rebuild triggered for a version of the
+	// docs is waited for before it is triggered again, which is longer
+	// than the build timeout in cloudbuild.yaml.
+	g3docRebuildWait = 30 * time.Minute
+)
+
+var g3docSyncs = newCounter("g3doc_syncs_total", "Checks of the gVisor docs for changes, by result.", "result")
+
+// g3docMapping is a dir of the gVisor docs synced into the content.
+type g3docMapping struct {
+	// src is relative to g3docDir, and dest to the content dir.
+	src  string
+	dest string
+}
+
+// parseG3docDirs parses a comma-separated list of src=dest pairs.
+func parseG3docDirs(spec string) ([]g3docMapping, error) {
+	var mappings []g3docMapping
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid docs dir %q: want src=dest", pair)
+		}
+		m := g3docMapping{src: path.Clean(kv[0]), dest: path.Clean(kv[1])}
+		for _, p := range []string{m.src, m.dest} {
+			if path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+				return nil, fmt.Errorf("invalid docs dir %q: paths must be relative", pair)
+			}
+		}
+		if m.dest == "." {
+			return nil, fmt.Errorf("invalid docs dir %q: the whole content dir can't be replaced", pair)
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
+
+// g3docLink matches relative links to Markdown files.
+var g3docLink = regexp.MustCompile(`\]\(([^)\s:#/][^)\s:#]*)\.md(#[^)\s]*)?\)`)
+
+// convertG3doc converts a Markdown file of the gVisor docs to a content page.
+// The docs have no front matter, but are titled by their first heading,
+// which becomes the page's title. Links to other Markdown files are
+// rewritten to their pages; pages other than index pages are served one
+// level down, at name/, so their relative links are too.
+func convertG3doc(b []byte, index bool) ([]byte, error) {
+	body := g3docLink.ReplaceAllFunc(b, func(m []byte) []byte {
+		sub := g3docLink.FindSubmatch(m)
+		target := string(sub[1])
+		switch path.Base(target) {
+		case "README", "index", "_index":
+			target = path.Dir(target)
+		}
+		if !index {
+			target = path.Join("..", target)
+		}
+		return []byte("](" + path.Clean(target) + "/" + string(sub[2]) + ")")
+	})
+	if bytes.HasPrefix(body, []byte("+++")) || bytes.HasPrefix(body, []byte("---")) {
+		return body, nil
+	}
+	lines := strings.SplitAfter(string(body), "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		title := strings.TrimSpace(strings.TrimPrefix(line, "# "))
+		rest := strings.TrimLeft(strings.Join(append(lines[:i:i], lines[i+1:]...), ""), "\n")
+		return []byte("+++\ntitle = " + strconv.Quote(title) + "\n+++\n\n" + rest), nil
+	}
+	return nil, fmt.Errorf("no front matter or title heading")
+}
+
+// syncG3doc syncs the configured dirs of the gVisor docs into the content.
+// All of them are converted into a staging dir first, so that a failed
+// conversion leaves the content as it was, and then replace the dests. The
+// version of the docs is written for the server's sync worker.
+func syncG3doc(c *buildConfig) error {
+	mappings, err := parseG3docDirs(c.g3docDirs)
+	if err != nil || len(mappings) == 0 {
+		return err
+	}
+	// Commands run in the repository, so they are given the clone's path
+	// relative to it.
+	const clone = "upstream/gvisor-g3doc"
+	upstream := c.path(clone)
+	if _, err = os.Stat(upstream); err == nil {
+		err = c.command(nil, "git", "-C", clone, "pull", "--ff-only")
+	} else {
+		err = c.command(nil, "git", "clone", "--branch", c.g3docBranch, "--depth", "1", c.gvisorRepo, clone)
+	}
+	if err != nil {
+		return err
+	}
+	if err := stageG3doc(c, upstream, mappings); err != nil {
+		return err
+	}
+	if c.g3docVersionOut == "" {
+		return nil
+	}
+	cmd := exec.Command("git", "-C", upstream, "rev-parse", "HEAD:"+g3docDir)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("docs version: %v", err)
+	}
+	log.Printf("Synced docs version %s", strings.TrimSpace(string(out)))
+	if err := os.MkdirAll(filepath.Dir(c.path(c.g3docVersionOut)), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path(c.g3docVersionOut), out, 0644)
+}
+
+// stageG3doc converts the mapped dirs of the docs in the given clone of the
+// gVisor repository, and replaces their dests in the content with them.
+// README.md files become the index pages of their dirs.
+func stageG3doc(c *buildConfig, upstream string, mappings []g3docMapping) error {
+	stage := c.path("upstream/g3doc-staging")
+	if err := os.RemoveAll(stage); err != nil {
+		return err
+	}
+	defer os.RemoveAll(stage)
+	for i, m := range mappings {
+		src := filepath.Join(upstream, g3docDir, filepath.FromSlash(m.src))
+		dst := filepath.Join(stage, strconv.Itoa(i))
+		n := 0
+		err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if filepath.Ext(p) == ".md" {
+				index := filepath.Base(p) == "README.md"
+				if index {
+					rel = filepath.Join(filepath.Dir(rel), "_index.md")
+				}
+				if b, err = convertG3doc(b, index); err != nil {
+					return fmt.Errorf("%s: %v", p, err)
+				}
+				n++
+			}
+			out := filepath.Join(dst, rel)
+			if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(out, b, 0644)
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("no docs in %s", src)
+		}
+		log.Printf("Staged %d docs from %s/%s for %s", n, g3docDir, m.src, m.dest)
+	}
+	for i, m := range mappings {
+		dest := c.path(filepath.Join("content", filepath.FromSlash(m.dest)))
+		if err := os.RemoveAll(dest); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(stage, strconv.Itoa(i)), dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runG3doc runs the g3doc subcommand with the given arguments, for builds
+// that don't use the build subcommand.
+func runG3doc(args []string) error {
+	fs := flag.NewFlagSet("g3doc", flag.ExitOnError)
+	c := &buildConfig{}
+	fs.StringVar(&c.src, "src", ".", "Website repository to sync the docs into.")
+	fs.StringVar(&c.g3docDirs, "dirs", "", "Comma-separated dirs of the gVisor docs to sync, as src=dest pairs relative to g3doc and content.")
+	fs.StringVar(&c.gvisorRepo, "gvisor-repo", "https://github.com/google/gvisor.git", "gVisor repository the docs are synced from.")
+	fs.StringVar(&c.g3docBranch, "branch", "master", "Branch of the gVisor repository to clone.")
+	fs.StringVar(&c.g3docVersionOut, "version-out", "public/g3doc-version", "Marker file the version of the synced docs is written to, relative to -src.")
+	fs.Parse(args)
+	return syncG3doc(c)
+}
+
+// g3docSyncer triggers a rebuild of the site when the docs in the gVisor
+// repository change, so that the synced content is published without
+// waiting for the daily rebuild. It compares the hash of the docs' tree,
+// which the build records, with the upstream one.
+type g3docSyncer struct {
+	// deployed is the version of the docs the site was built with.
+	deployed string
+
+	// upstream returns the current version of the docs, and rebuild
+	// triggers a rebuild.
+	upstream func(ctx context.Context) (string, error)
+	rebuild  func(ctx context.Context) error
+
+	mu sync.Mutex
+
+	// triggered is the version a rebuild was last triggered for, and when,
+	// so that a version is only rebuilt once while its build runs however
+	// often the sync runs.
+	triggered   string
+	triggeredAt time.Time
+}
+
+// g3docSync is the docs sync worker, or nil if the site was built without
+// synced docs.
+var g3docSync *g3docSyncer
+
+// newG3docSyncer returns a sync worker for the docs on the given branch of
+// the gVisor repository, or nil if the given marker file, written by the
+// build, doesn't exist.
+func newG3docSyncer(versionFile, branch string) (*g3docSyncer, error) {
+	b, err := ioutil.ReadFile(versionFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &g3docSyncer{
+		deployed: strings.TrimSpace(string(b)),
+		upstream: func(ctx context.Context) (string, error) { return upstreamG3docVersion(ctx, branch) },
+		rebuild:  runRebuild,
+	}, nil
+}
+
+// upstreamG3docVersion returns the hash of the docs' tree on the given branch
+// of the gVisor repository.
+func upstreamG3docVersion(ctx context.Context, branch string) (string, error) {
+	req, err := http.NewRequest("GET", "https://api.github.com/repos/google/gvisor/git/trees/"+branch, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("upstream tree: %s", resp.Status)
+	}
+	var body struct {
+		Tree []struct {
+			Path string `json:"path"`
+			Type string `json:"type"`
+			SHA  string `json:"sha"`
+		} `json:"tree"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	for _, e := range body.Tree {
+		if e.Path == g3docDir && e.Type == "tree" {
+			return e.SHA, nil
+		}
+	}
+	return "", fmt.Errorf("no %s dir on %s", g3docDir, branch)
+}
+
+// g3docSyncResult is served by the sync handler.
+type g3docSyncResult struct {
+	Deployed string `json:"deployed"`
+	Upstream string `json:"upstream"`
+
+	// Result is current if the deployed docs are up to date, pending if a
+	// rebuild was already triggered for the upstream docs, and triggered
+	// if one was triggered now.
+	Result string `json:"result"`
+}
+
+// sync triggers a rebuild if the upstream docs differ from the deployed ones.
+func (s *g3docSyncer) sync(ctx context.Context) (*g3docSyncResult, error) {
+	upstream, err := s.upstream(ctx)
+	if err != nil {
+		g3docSyncs.inc("error")
+		return nil, err
+	}
+	res := &g3docSyncResult{Deployed: s.deployed, Upstream: upstream, Result: "current"}
+	if upstream == s.deployed {
+		g3docSyncs.inc(res.Result)
+		return res, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.triggered == upstream && time.Since(s.triggeredAt) < g3docRebuildWait {
+		res.Result = "pending"
+		g3docSyncs.inc(res.Result)
+		return res, nil
+	}
+	if err := s.rebuild(ctx); err != nil {
+		g3docSyncs.inc("error")
+		return nil, err
+	}
+	log.Printf("Docs changed from %s to %s, triggered a rebuild", s.deployed, upstream)
+	s.triggered, s.triggeredAt = upstream, time.Now()
+	res.Result = "triggered"
+	g3docSyncs.inc(res.Result)
+	return res, nil
+}
+
+// g3docSyncHandler runs the sync, for the cron job.
+func g3docSyncHandler(s *g3docSyncer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+		res, err := s.sync(ctx)
+		if err != nil {
+			log.Printf("Error syncing docs: %v", err)
+			httpError(w, r, "sync error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+}
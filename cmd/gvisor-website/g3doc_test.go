@@ -0,0 +1,172 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseG3docDirs(t *testing.T) {
+	mappings, err := parseG3docDirs("architecture_guide=docs/architecture_guide, user_guide/=docs/user_guide")
+	if err != nil {
+		t.Fatalf("parseG3docDirs failed: %v", err)
+	}
+	want := []g3docMapping{{"architecture_guide", "docs/architecture_guide"}, {"user_guide", "docs/user_guide"}}
+	if !reflect.DeepEqual(mappings, want) {
+		t.Errorf("parseG3docDirs = %+v, want %+v", mappings, want)
+	}
+	for _, spec := range []string{"docs", "a=.", "../a=docs/a", "a=/docs", "a=docs/../../b"} {
+		if _, err := parseG3docDirs(spec); err == nil {
+			t.Errorf("parseG3docDirs(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestConvertG3doc(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		index bool
+		want  string
+	}{
+		{
+			"# Security\n\nSee [the overview](overview.md#goals) and [the guide](../user_guide/README.md).\n",
+			false,
+			"+++\ntitle = \"Security\"\n+++\n\nSee [the overview](../overview/#goals) and [the guide](../../user_guide/).\n",
+		},
+		{
+			"# \"Architecture\" Guide\n\n[Security](security.md), [home](README.md), [GitHub](https://github.com/google/gvisor/blob/master/README.md)\n",
+			true,
+			"+++\ntitle = \"\\\"Architecture\\\" Guide\"\n+++\n\n[Security](security/), [home](./), [GitHub](https://github.com/google/gvisor/blob/master/README.md)\n",
+		},
+		{"+++\ntitle = \"FAQ\"\n+++\n\n# Answers\n", false, "+++\ntitle = \"FAQ\"\n+++\n\n# Answers\n"},
+	} {
+		got, err := convertG3doc([]byte(tc.in), tc.index)
+		if err != nil || string(got) != tc.want {
+			t.Errorf("convertG3doc(%q) = %q, %v; want %q", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := convertG3doc([]byte("No title.\n"), false); err == nil {
+		t.Errorf("convertG3doc of a page without a title succeeded")
+	}
+}
+
+func TestStageG3doc(t *testing.T) {
+	src, err := ioutil.TempDir("", "g3doc-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(src)
+	writeFiles(t, src, map[string]string{
+		"upstream/gvisor-g3doc/g3doc/architecture_guide/README.md":   "# Architecture\n",
+		"upstream/gvisor-g3doc/g3doc/architecture_guide/security.md": "# Security\n",
+		"upstream/gvisor-g3doc/g3doc/architecture_guide/Layers.png":  "png",
+		"upstream/gvisor-g3doc/g3doc/broken/page.md":                 "No title.\n",
+		"content/docs/architecture_guide/removed.md":                 "stale",
+		"content/docs/user_guide/faq.md":                             "faq",
+	})
+	c := &buildConfig{src: src}
+	upstream := filepath.Join(src, "upstream/gvisor-g3doc")
+
+	if err := stageG3doc(c, upstream, []g3docMapping{{"architecture_guide", "docs/architecture_guide"}, {"broken", "docs/broken"}}); err == nil {
+		t.Errorf("stageG3doc with a broken page succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(src, "content/docs/architecture_guide/removed.md")); err != nil {
+		t.Errorf("failed stageG3doc changed the content: %v", err)
+	}
+
+	if err := stageG3doc(c, upstream, []g3docMapping{{"architecture_guide", "docs/architecture_guide"}}); err != nil {
+		t.Fatalf("stageG3doc failed: %v", err)
+	}
+	for name, want := range map[string]string{
+		"docs/architecture_guide/_index.md":   "+++\ntitle = \"Architecture\"\n+++\n\n",
+		"docs/architecture_guide/security.md": "+++\ntitle = \"Security\"\n+++\n\n",
+		"docs/architecture_guide/Layers.png":  "png",
+		"docs/architecture_guide/removed.md":  "",
+		"docs/user_guide/faq.md":              "faq",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(src, "content", filepath.FromSlash(name)))
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s was kept, want it removed", name)
+			}
+			continue
+		}
+		if err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v; want %q", name, b, err, want)
+		}
+	}
+}
+
+func TestG3docSync(t *testing.T) {
+	upstream, rebuilds := "abc", 0
+	var rebuildErr error
+	s := &g3docSyncer{
+		deployed: "abc",
+		upstream: func(context.Context) (string, error) { return upstream, nil },
+		rebuild: func(context.Context) error {
+			rebuilds++
+			return rebuildErr
+		},
+	}
+	for _, tc := range []struct {
+		upstream   string
+		rebuildErr error
+		want       string
+		rebuilds   int
+	}{
+		{"abc", nil, "current", 0},
+		{"def", fmt.Errorf("no triggers"), "", 1},
+		{"def", nil, "triggered", 2},
+		{"def", nil, "pending", 2},
+		{"ghi", nil, "triggered", 3},
+	} {
+		upstream, rebuildErr = tc.upstream, tc.rebuildErr
+		res, err := s.sync(context.Background())
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("sync with upstream %s succeeded, want error", tc.upstream)
+			}
+		} else if err != nil || res.Result != tc.want {
+			t.Errorf("sync with upstream %s = %+v, %v; want %s", tc.upstream, res, err, tc.want)
+		}
+		if rebuilds != tc.rebuilds {
+			t.Errorf("sync with upstream %s: %d rebuilds, want %d", tc.upstream, rebuilds, tc.rebuilds)
+		}
+	}
+}
+
+func TestPushEventTouchesG3doc(t *testing.T) {
+	var ev pushEvent
+	if err := json.Unmarshal([]byte(`{"ref": "refs/heads/master", "repository": {"full_name": "google/gvisor"}, "commits": [{"modified": ["runsc/main.go"]}]}`), &ev); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if ev.touchesG3doc("master") {
+		t.Errorf("push without docs changes touches the docs")
+	}
+	ev.Commits[0].Removed = []string{"g3doc/user_guide/FAQ.md"}
+	if !ev.touchesG3doc("master") {
+		t.Errorf("push removing a doc doesn't touch the docs")
+	}
+	if ev.touchesG3doc("go") {
+		t.Errorf("push to master touches the docs of the go branch")
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// origin describes where a request came from, as reported by the front end or
+// load balancer.
+type origin struct {
+	// Country is the ISO 3166-1 alpha-2 country code, or "" if unknown.
+	Country string
+
+	// ASN is the autonomous system number, or "" if unknown.
+	ASN string
+
+	// Flagged is true if a Cloud Armor rule annotated the request.
+	Flagged bool
+}
+
+// requestOrigin returns the origin of the given request. Only headers set by
+// trusted infrastructure are consulted: App Engine and the load balancer strip
+// or overwrite client-provided values.
+func requestOrigin(r *http.Request) origin {
+	o := origin{
+		Country: strings.ToUpper(strings.TrimSpace(r.Header.Get(*geoCountryHeader))),
+		ASN:     strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(r.Header.Get(*geoASNHeader))), "AS"),
+	}
+	// App Engine reports "ZZ" when the country is unknown. Anything that
+	// isn't a country code is dropped to bound metric cardinality.
+	if o.Country == "ZZ" || len(o.Country) != 2 {
+		o.Country = ""
+	}
+	if *armorHeader != "" && r.Header.Get(*armorHeader) != "" {
+		o.Flagged = true
+	}
+	return o
+}
+
+var (
+	// datacenterASNs is the set of ASNs of hosting providers, set from
+	// flags at startup.
+	datacenterASNs map[string]bool
+
+	// strictLimiters are the per-route rate limiters applied to datacenter
+	// and flagged clients, set from flags at startup.
+	strictLimiters map[string]*rateLimiter
+)
+
+// parseASNs parses a comma-separated list of ASNs into a set.
+func parseASNs(spec string) map[string]bool {
+	m := make(map[string]bool)
+	for _, asn := range strings.Split(spec, ",") {
+		asn = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(asn)), "AS")
+		if asn != "" {
+			m[asn] = true
+		}
+	}
+	return m
+}
+
+// strict returns true if stricter policies apply to the origin.
+func (o origin) strict() bool {
+	return o.Flagged || datacenterASNs[o.ASN]
+}
+
+var requestsByCountry = newCounter("http_requests_by_country_total", "HTTP requests by origin country.", "country")
+
+// originPolicyHandler records the origin country of each request and applies
+// the stricter per-route rate limits to clients from datacenter ASNs or
+// flagged by Cloud Armor.
+func originPolicyHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := requestOrigin(r)
+		country := o.Country
+		if country == "" {
+			country = "unknown"
+		}
+		requestsByCountry.inc(country)
+		if l, ok := strictLimiters[route]; ok && o.strict() && !l.allow(clientIP(r)) {
+			requestsShed.inc(route, "origin-rate")
+			w.Header().Set("Retry-After", "60")
+			httpError(w, r, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRequestOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   origin
+	}{
+		{"no geo headers", nil, origin{}},
+		{"country", http.Header{"X-Appengine-Country": {"de"}}, origin{Country: "DE"}},
+		{"unknown country", http.Header{"X-Appengine-Country": {"ZZ"}}, origin{}},
+		{"not a country code", http.Header{"X-Appengine-Country": {"Germany"}}, origin{}},
+		{"ASN", http.Header{"X-Client-Asn": {" as15169 "}}, origin{ASN: "15169"}},
+		{"flagged", http.Header{"X-Cloud-Armor-Flag": {"scanner"}, "X-Appengine-Country": {"US"}}, origin{Country: "US", Flagged: true}},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range tc.header {
+			r.Header[k] = v
+		}
+		if got := requestOrigin(r); got != tc.want {
+			t.Errorf("%s: got origin %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseASNs(t *testing.T) {
+	want := map[string]bool{"15169": true, "16509": true, "8075": true}
+	if got := parseASNs("AS15169, as16509,,8075 "); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseASNs = %v, want %v", got, want)
+	}
+	if got := parseASNs(""); len(got) != 0 {
+		t.Errorf("parseASNs(\"\") = %v, want none", got)
+	}
+}
+
+func TestOriginPolicyHandler(t *testing.T) {
+	defer func(asns map[string]bool, limiters map[string]*rateLimiter) {
+		datacenterASNs, strictLimiters = asns, limiters
+	}(datacenterASNs, strictLimiters)
+	datacenterASNs = parseASNs("AS16509")
+	strictLimiters = map[string]*rateLimiter{"search": newRateLimiter(1, 1)}
+	h := originPolicyHandler("search", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(ip string, header http.Header) int {
+		r := httptest.NewRequest("GET", "/search", nil)
+		r.RemoteAddr = ip + ":1234"
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	counted := map[string]float64{
+		"NZ":      metricValue(requestsByCountry, "NZ"),
+		"unknown": metricValue(requestsByCountry, "unknown"),
+	}
+	for _, tc := range []struct {
+		name   string
+		ip     string
+		header http.Header
+		// limited is true if the second request is rate limited.
+		limited bool
+	}{
+		{"residential client", "192.0.2.1", http.Header{"X-Appengine-Country": {"NZ"}, "X-Client-Asn": {"AS9500"}}, false},
+		{"client without geo headers", "192.0.2.2", nil, false},
+		{"datacenter client", "192.0.2.3", http.Header{"X-Client-Asn": {"AS16509"}}, true},
+		{"flagged client", "192.0.2.4", http.Header{"X-Cloud-Armor-Flag": {"scanner"}}, true},
+	} {
+		if code := do(tc.ip, tc.header); code != http.StatusOK {
+			t.Errorf("%s: got status %d for the first request, want 200", tc.name, code)
+		}
+		want := http.StatusOK
+		if tc.limited {
+			want = http.StatusTooManyRequests
+		}
+		if code := do(tc.ip, tc.header); code != want {
+			t.Errorf("%s: got status %d for the second request, want %d", tc.name, code, want)
+		}
+	}
+
+	// Requests are counted by country, or as unknown without a country.
+	for country, want := range map[string]float64{"NZ": 2, "unknown": 6} {
+		if got := metricValue(requestsByCountry, country) - counted[country]; got != want {
+			t.Errorf("got %v more requests counted for country %s, want %v", got, country, want)
+		}
+	}
+
+	// Routes without a strict limit aren't limited.
+	h = originPolicyHandler("docs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		if code := do("192.0.2.3", http.Header{"X-Client-Asn": {"AS16509"}}); code != http.StatusOK {
+			t.Errorf("datacenter client on an unlimited route: got status %d, want 200", code)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The retired Gerrit review system at gvisor-review.googlesource.com is still
+// linked from mailing lists and blogs. Its most common URL formats are
+// redirected to the GitHub equivalents.
+
+var (
+	// gerritChangeRE matches the change number of a change URL path,
+	// /c/<n> or /c/gvisor/+/<n>, optionally followed by a patchset and file.
+	gerritChangeRE = regexp.MustCompile(`^/c/(?:gvisor/\+/)?([0-9]+)(?:/.*)?$`)
+
+	// commitRE matches an abbreviated or full commit hash.
+	commitRE = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+)
+
+// gerritChangeURL returns the URL of a search for the commit that merged the
+// given Gerrit change. Merged changes were imported with a reference to the
+// review in the commit message.
+func gerritChangeURL(change string) string {
+	q := `repo:google/gvisor "gvisor-review.googlesource.com/c/gvisor/+/` + change + `"`
+	return "https://github.com/search?type=commits&q=" + url.QueryEscape(q)
+}
+
+// gerritOperators maps Gerrit search operators to GitHub pull request search
+// qualifiers. Operators not listed are passed through as search terms.
+var gerritOperators = map[string]string{
+	"status:open":      "is:open",
+	"status:merged":    "is:merged",
+	"status:abandoned": "is:closed is:unmerged",
+	"status:closed":    "is:closed",
+	"is:open":          "is:open",
+	"is:merged":        "is:merged",
+	"is:abandoned":     "is:closed is:unmerged",
+	"is:closed":        "is:closed",
+}
+
+// gerritQueryURL returns the GitHub equivalent of a Gerrit search: the change
+// for a change number, the commit for a commit hash, and otherwise a pull
+// request search with the operators translated.
+func gerritQueryURL(query string) string {
+	query = strings.TrimSpace(query)
+	if m := gerritChangeRE.FindStringSubmatch("/c/" + strings.TrimPrefix(query, "change:")); m != nil {
+		return gerritChangeURL(m[1])
+	}
+	if commitRE.MatchString(query) {
+		return "https://github.com/google/gvisor/commit/" + query
+	}
+	terms := []string{"is:pr"}
+	for _, term := range strings.Fields(query) {
+		switch {
+		case gerritOperators[term] != "":
+			term = gerritOperators[term]
+		case strings.HasPrefix(term, "owner:"):
+			term = "author:" + strings.TrimPrefix(term, "owner:")
+		case strings.HasPrefix(term, "reviewer:"):
+			term = "reviewed-by:" + strings.TrimPrefix(term, "reviewer:")
+		case strings.HasPrefix(term, "project:"), strings.HasPrefix(term, "branch:"):
+			// There is a single project and branch.
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return "https://github.com/google/gvisor/pulls?q=" + url.QueryEscape(strings.Join(terms, " "))
+}
+
+// gerritChangeHandler redirects Gerrit change URLs, /c/<n> and
+// /c/gvisor/+/<n>, to the commit that merged the change.
+func gerritChangeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/c/" {
+			http.Redirect(w, r, "/c", http.StatusFound)
+			return
+		}
+		m := gerritChangeRE.FindStringSubmatch(r.URL.Path)
+		if m == nil {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, gerritChangeURL(m[1]), http.StatusFound)
+	})
+}
+
+// gerritQueryHandler redirects Gerrit searches, /q/<query>, to GitHub. Gerrit
+// encodes spaces in the query as +.
+func gerritQueryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := strings.Replace(strings.TrimPrefix(r.URL.Path, "/q/"), "+", " ", -1)
+		if strings.TrimSpace(query) == "" {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, gerritQueryURL(query), http.StatusFound)
+	})
+}
+
+// registerGerritRedirects registers the redirects of Gerrit URLs. The
+// compatibility shortlinks under /c/ take precedence, being more specific.
+func registerGerritRedirects(mux *http.ServeMux) {
+	mux.Handle("/c/", siteChain("prefix-redirect").then(gerritChangeHandler()))
+	mux.Handle("/q/", siteChain("prefix-redirect").then(gerritQueryHandler()))
+}
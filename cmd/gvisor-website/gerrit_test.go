@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGerritQueryURL(t *testing.T) {
+	prs := "https://github.com/google/gvisor/pulls?q="
+	for _, tc := range []struct {
+		query string
+		want  string
+	}{
+		{"1234", gerritChangeURL("1234")},
+		{"change:1234", gerritChangeURL("1234")},
+		{"3f9ac2d", "https://github.com/google/gvisor/commit/3f9ac2d"},
+		{"status:open", prs + url.QueryEscape("is:pr is:open")},
+		{"status:abandoned owner:jane@example.com", prs + url.QueryEscape("is:pr is:closed is:unmerged author:jane@example.com")},
+		{"project:gvisor branch:master reviewer:bob netstack", prs + url.QueryEscape("is:pr reviewed-by:bob netstack")},
+	} {
+		if got := gerritQueryURL(tc.query); got != tc.want {
+			t.Errorf("gerritQueryURL(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestGerritHandlers(t *testing.T) {
+	mux := http.NewServeMux()
+	registerGerritRedirects(mux)
+	for _, tc := range []struct {
+		path     string
+		wantCode int
+		wantLoc  string
+	}{
+		{"/c/gvisor/+/1234", http.StatusFound, gerritChangeURL("1234")},
+		{"/c/gvisor/+/1234/3", http.StatusFound, gerritChangeURL("1234")},
+		{"/c/gvisor/+/1234/3/runsc/main.go", http.StatusFound, gerritChangeURL("1234")},
+		{"/c/1234", http.StatusFound, gerritChangeURL("1234")},
+		{"/c/", http.StatusFound, "/c"},
+		{"/c/gvisor/+/abc", http.StatusNotFound, ""},
+		{"/c/linux", http.StatusNotFound, ""},
+		{"/q/status:open+project:gvisor", http.StatusFound, "https://github.com/google/gvisor/pulls?q=" + url.QueryEscape("is:pr is:open")},
+		{"/q/1234", http.StatusFound, gerritChangeURL("1234")},
+		{"/q/", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.wantCode {
+			t.Errorf("GET %s: got status %d, want %d", tc.path, rec.Code, tc.wantCode)
+			continue
+		}
+		if got := rec.Header().Get("Location"); got != tc.wantLoc {
+			t.Errorf("GET %s: got Location %q, want %q", tc.path, got, tc.wantLoc)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// infoRefsPath is the smart HTTP ref advertisement, relative to the
+	// upstream repository URL.
+	infoRefsPath = "/info/refs?service=git-upload-pack"
+
+	// infoRefsCacheKey is the cache key for the ref advertisement.
+	infoRefsCacheKey = "git:info-refs"
+
+	// infoRefsTTL is how long the ref advertisement is used without
+	// revalidating it upstream.
+	infoRefsTTL = 5 * time.Minute
+
+	// infoRefsStaleTTL is how long the ref advertisement is kept for
+	// revalidation, and for serving if upstream is unavailable.
+	infoRefsStaleTTL = 24 * time.Hour
+
+	// infoRefsFetchTimeout bounds a refresh of the ref advertisement, which
+	// is shared by all requests waiting for it.
+	infoRefsFetchTimeout = 30 * time.Second
+
+	// maxInfoRefsSize bounds the size of the ref advertisement.
+	maxInfoRefsSize = 16 << 20
+)
+
+var infoRefsFetches = newCounter("git_info_refs_fetch_total", "Upstream ref advertisement fetches by result.", "result")
+
+// infoRefsEntry is a cached ref advertisement along with the validators
+// needed to revalidate it.
+type infoRefsEntry struct {
+	Body         []byte    `json:"body"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// cachedInfoRefs returns the cached entry, or nil if there is none.
+func cachedInfoRefs(ctx context.Context) *infoRefsEntry {
+	b, ok, err := sharedCache.get(ctx, infoRefsCacheKey)
+	if err != nil || !ok {
+		return nil
+	}
+	var e infoRefsEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// fetchInfoRefs fetches the ref advertisement from upstream. If prev is
+// non-nil, the request is conditional on the advertisement having changed; if
+// it has not, prev is returned with an updated fetch time.
+func fetchInfoRefs(ctx context.Context, prev *infoRefsEntry) (*infoRefsEntry, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(*gitUpstream, "/")+infoRefsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+	}
+	resp, err := upstreamClient("git").Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		infoRefsFetches.inc("not_modified")
+		e := *prev
+		e.Fetched = time.Now()
+		return &e, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("info/refs: %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxInfoRefsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxInfoRefsSize {
+		return nil, fmt.Errorf("info/refs exceeds %d bytes", maxInfoRefsSize)
+	}
+	if prev != nil && bytes.Equal(b, prev.Body) {
+		// Upstream does not support validators, but nothing changed.
+		infoRefsFetches.inc("unchanged")
+	} else {
+		infoRefsFetches.inc("modified")
+	}
+	return &infoRefsEntry{
+		Body:         b,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+	}, nil
+}
+
+var infoRefsRefreshed = newGauge("git_info_refs_last_refresh_timestamp_seconds", "Time of the last successful upstream ref advertisement refresh.")
+
+// refreshInfoRefs revalidates the cached ref advertisement upstream and
+// stores the result, returning the previous entry if the refresh fails.
+func refreshInfoRefs(ctx context.Context, prev *infoRefsEntry) (*infoRefsEntry, error) {
+	e, err := fetchInfoRefs(ctx, prev)
+	if err != nil {
+		infoRefsFetches.inc("error")
+		return prev, err
+	}
+	infoRefsRefreshed.set(float64(e.Fetched.Unix()))
+	if b, err := json.Marshal(e); err == nil {
+		sharedCache.set(ctx, infoRefsCacheKey, b, infoRefsStaleTTL)
+	}
+	return e, nil
+}
+
+// infoRefsFlight deduplicates concurrent refreshes of the ref advertisement.
+var infoRefsFlight flightGroup
+
+// infoRefs returns the upstream ref advertisement, using the shared cache.
+// Stale advertisements are revalidated upstream, and served as-is if upstream
+// is unavailable. Concurrent requests share a single refresh.
+func infoRefs(ctx context.Context) ([]byte, error) {
+	prev := cachedInfoRefs(ctx)
+	if prev != nil && time.Since(prev.Fetched) < infoRefsTTL {
+		return prev.Body, nil
+	}
+	v, err := infoRefsFlight.do(infoRefsCacheKey, func() (interface{}, error) {
+		// The refresh is shared, so it must not be canceled with the
+		// request that started it.
+		ctx, cancel := context.WithTimeout(context.Background(), infoRefsFetchTimeout)
+		defer cancel()
+		e, err := refreshInfoRefs(ctx, prev)
+		if err != nil {
+			if e == nil {
+				return nil, err
+			}
+			log.Printf("Error refreshing info/refs, serving stale: %v", err)
+		}
+		return e.Body, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	body, ok := v.([]byte)
+	if !ok {
+		return nil, errFlightPanicked
+	}
+	return body, nil
+}
+
+// refreshRefsLoop keeps the ref advertisement warm by refreshing it every
+// interval until the context is cancelled, so that client requests are served
+// from the cache rather than waiting on a round trip upstream. The interval
+// should be shorter than infoRefsTTL.
+func refreshRefsLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := refreshInfoRefs(ctx, cachedInfoRefs(ctx)); err != nil {
+			log.Printf("Error refreshing info/refs: %v", err)
+		} else if _, err := upstreamRefs(ctx); err != nil {
+			// Parse eagerly to warm the memoized advertisement.
+			log.Printf("Error parsing info/refs: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parsedRefs memoizes the most recently parsed advertisement, so that an
+// unchanged advertisement is not parsed again on every request.
+var parsedRefs struct {
+	mu  sync.Mutex
+	sum [sha256.Size]byte
+	adv *refAdvertisement
+}
+
+// upstreamRefs returns the parsed upstream ref advertisement.
+func upstreamRefs(ctx context.Context) (*refAdvertisement, error) {
+	b, err := infoRefs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	parsedRefs.mu.Lock()
+	defer parsedRefs.mu.Unlock()
+	if parsedRefs.adv != nil && parsedRefs.sum == sum {
+		return parsedRefs.adv, nil
+	}
+	adv, err := parseRefs(b)
+	if err != nil {
+		return nil, err
+	}
+	parsedRefs.sum, parsedRefs.adv = sum, adv
+	return adv, nil
+}
+
+// gitRef is a single advertised ref.
+type gitRef struct {
+	Name string
+
+	// Hash is the object the ref points to.
+	Hash string
+
+	// Peeled is the commit an annotated tag points to, if any.
+	Peeled string
+}
+
+// refAdvertisement is a parsed ref advertisement.
+type refAdvertisement struct {
+	// Head is the ref that HEAD points to, e.g. refs/heads/master.
+	Head string
+
+	// Refs are the advertised refs, in advertisement order.
+	Refs []*gitRef
+}
+
+// readPktLines splits the given data into pkt-lines. Flush packets are
+// returned as nil.
+func readPktLines(b []byte) ([][]byte, error) {
+	var lines [][]byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("short pkt-line header")
+		}
+		n, err := strconv.ParseUint(string(b[:4]), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkt-line length %q", b[:4])
+		}
+		if n == 0 {
+			lines = append(lines, nil)
+			b = b[4:]
+			continue
+		}
+		if n < 4 || int(n) > len(b) {
+			return nil, fmt.Errorf("invalid pkt-line length %d", n)
+		}
+		lines = append(lines, b[4:n])
+		b = b[n:]
+	}
+	return lines, nil
+}
+
+// parseRefs parses a smart HTTP upload-pack ref advertisement.
+func parseRefs(b []byte) (*refAdvertisement, error) {
+	lines, err := readPktLines(b)
+	if err != nil {
+		return nil, err
+	}
+	adv := &refAdvertisement{}
+	byName := make(map[string]*gitRef)
+	first := true
+	for _, line := range lines {
+		if line == nil || bytes.HasPrefix(line, []byte("# service=")) {
+			continue
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if first && bytes.HasPrefix(line, []byte("version ")) {
+			// Protocol v2 advertises capabilities instead of refs.
+			// It is only sent if requested, which this never does.
+			return nil, fmt.Errorf("unsupported protocol %s", line)
+		}
+		if first {
+			// The first ref carries the capabilities.
+			first = false
+			if i := bytes.IndexByte(line, 0); i >= 0 {
+				for _, c := range strings.Fields(string(line[i+1:])) {
+					if strings.HasPrefix(c, "symref=HEAD:") {
+						adv.Head = strings.TrimPrefix(c, "symref=HEAD:")
+					}
+				}
+				line = line[:i]
+			}
+		}
+		fields := strings.SplitN(string(line), " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid ref line %q", line)
+		}
+		hash, name := fields[0], fields[1]
+		if strings.HasSuffix(name, "^{}") {
+			if ref, ok := byName[strings.TrimSuffix(name, "^{}")]; ok {
+				ref.Peeled = hash
+			}
+			continue
+		}
+		ref := &gitRef{Name: name, Hash: hash}
+		byName[name] = ref
+		adv.Refs = append(adv.Refs, ref)
+	}
+	return adv, nil
+}
+
+// refInfo is the API representation of a branch or tag.
+type refInfo struct {
+	Name    string `json:"name"`
+	Commit  string `json:"commit"`
+	Default bool   `json:"default,omitempty"`
+}
+
+// listRefs returns the refs under the given prefix, sorted by name in
+// descending order so that the newest releases come first.
+func (adv *refAdvertisement) listRefs(prefix string) []refInfo {
+	infos := []refInfo{}
+	for _, ref := range adv.Refs {
+		if !strings.HasPrefix(ref.Name, prefix) {
+			continue
+		}
+		commit := ref.Hash
+		if ref.Peeled != "" {
+			commit = ref.Peeled
+		}
+		infos = append(infos, refInfo{
+			Name:    strings.TrimPrefix(ref.Name, prefix),
+			Commit:  commit,
+			Default: ref.Name == adv.Head,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name > infos[j].Name
+	})
+	return infos
+}
+
+// gitRefsHandler returns a handler listing the upstream refs under the given
+// prefix, e.g. refs/tags/.
+func gitRefsHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adv, err := upstreamRefs(r.Context())
+		if err != nil {
+			httpError(w, r, "upstream error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(adv.listRefs(prefix))
+	})
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	recordFixtures = flag.Bool("record", false, "Record the upstream ref advertisement to "+recordedFixture+" before replaying the fixtures.")
+	updateGolden   = flag.Bool("update", false, "Update the golden files of the replayed fixtures.")
+)
+
+const (
+	// fixtureDir holds ref advertisement fixtures (.pkt) and the refs
+	// parsed from them (.json).
+	fixtureDir = "testdata/info-refs"
+
+	// recordedFixture is the fixture written by -record.
+	recordedFixture = fixtureDir + "/upstream.pkt"
+)
+
+// pkt returns s as a pkt-line.
+func pkt(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func TestReadPktLines(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: pkt("a\n") + "0000" + pkt("bc"), want: []string{"a\n", "", "bc"}},
+		{in: "0004", want: []string{""}},
+		{in: "000", wantErr: true},
+		{in: "zzzz", wantErr: true},
+		{in: "0003", wantErr: true},
+		{in: "0009abc", wantErr: true},
+	} {
+		lines, err := readPktLines([]byte(tc.in))
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("readPktLines(%q) = %q, want error", tc.in, lines)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("readPktLines(%q) failed: %v", tc.in, err)
+			continue
+		}
+		var got []string
+		for _, l := range lines {
+			got = append(got, string(l))
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("readPktLines(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseRefs(t *testing.T) {
+	adv := pkt("# service=git-upload-pack\n") + "0000" +
+		pkt("aaaa HEAD\x00multi_ack symref=HEAD:refs/heads/master agent=git\n") +
+		pkt("aaaa refs/heads/master\n") +
+		pkt("bbbb refs/heads/go\n") +
+		pkt("cccc refs/tags/release-1\n") +
+		pkt("dddd refs/tags/release-1^{}\n") +
+		pkt("eeee refs/tags/release-2\n") + "0000"
+	a, err := parseRefs([]byte(adv))
+	if err != nil {
+		t.Fatalf("parseRefs failed: %v", err)
+	}
+	if a.Head != "refs/heads/master" {
+		t.Errorf("Head = %q, want refs/heads/master", a.Head)
+	}
+	for _, tc := range []struct {
+		prefix string
+		want   []refInfo
+	}{
+		{"refs/heads/", []refInfo{{"master", "aaaa", true}, {"go", "bbbb", false}}},
+		// Annotated tags list the commit they point to.
+		{"refs/tags/", []refInfo{{"release-2", "eeee", false}, {"release-1", "dddd", false}}},
+		{"refs/pull/", []refInfo{}},
+	} {
+		if got := a.listRefs(tc.prefix); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("listRefs(%q) = %+v, want %+v", tc.prefix, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{
+		pkt("aaaa\n"),
+		"00",
+	} {
+		if _, err := parseRefs([]byte(bad)); err == nil {
+			t.Errorf("parseRefs(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+// fixtureRefs is the golden result of replaying a fixture.
+type fixtureRefs struct {
+	Head     string    `json:"head,omitempty"`
+	Branches []refInfo `json:"branches,omitempty"`
+	Tags     []refInfo `json:"tags,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// TestInfoRefsFixtures replays recorded ref advertisements through infoRefs
+// and compares the parsed refs with the golden files. Run with -record to
+// record the current upstream advertisement, and -update to regenerate the
+// golden files after an intended change.
+func TestInfoRefsFixtures(t *testing.T) {
+	if *recordFixtures {
+		e, err := fetchInfoRefs(context.Background(), nil)
+		if err != nil {
+			t.Fatalf("recording %s failed: %v", *gitUpstream, err)
+		}
+		if err := ioutil.WriteFile(recordedFixture, e.Body, 0644); err != nil {
+			t.Fatalf("writing %s failed: %v", recordedFixture, err)
+		}
+	}
+
+	fixtures, err := filepath.Glob(filepath.Join(fixtureDir, "*.pkt"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no fixtures in %s: %v", fixtureDir, err)
+	}
+	defer func(c cache, upstream string) {
+		sharedCache, *gitUpstream = c, upstream
+	}(sharedCache, *gitUpstream)
+
+	for _, fixture := range fixtures {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			b, err := ioutil.ReadFile(fixture)
+			if err != nil {
+				t.Fatalf("ReadFile failed: %v", err)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+				w.Write(b)
+			}))
+			defer srv.Close()
+			sharedCache = newMemoryCache(100, 1<<20)
+			*gitUpstream = srv.URL + "/repo.git"
+
+			// The advertisement is passed through unchanged.
+			got, err := infoRefs(context.Background())
+			if err != nil {
+				t.Fatalf("infoRefs failed: %v", err)
+			}
+			if !bytes.Equal(got, b) {
+				t.Errorf("infoRefs returned %q, want the fixture %q", got, b)
+			}
+
+			var refs fixtureRefs
+			if adv, err := upstreamRefs(context.Background()); err != nil {
+				refs.Error = err.Error()
+			} else {
+				refs.Head = adv.Head
+				refs.Branches = adv.listRefs("refs/heads/")
+				refs.Tags = adv.listRefs("refs/tags/")
+			}
+			goldenFile := strings.TrimSuffix(fixture, ".pkt") + ".json"
+			j, err := json.MarshalIndent(refs, "", "  ")
+			if err != nil {
+				t.Fatalf("MarshalIndent failed: %v", err)
+			}
+			j = append(j, '\n')
+			if *updateGolden || *recordFixtures {
+				if err := ioutil.WriteFile(goldenFile, j, 0644); err != nil {
+					t.Fatalf("writing %s failed: %v", goldenFile, err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("reading golden file failed: %v", err)
+			}
+			if !bytes.Equal(j, want) {
+				t.Errorf("got refs\n%s\nwant\n%s", j, want)
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
-module main
+module gvisor.dev/website/cmd/gvisor-website
 
 go 1.12
 
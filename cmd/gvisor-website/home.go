@@ -0,0 +1,420 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// homeCacheKey is the cache key for the homepage data.
+	homeCacheKey = "home"
+
+	// homeTTL is how long the homepage data is served before it is
+	// aggregated again.
+	homeTTL = 10 * time.Minute
+
+	// homeFetchTimeout bounds the aggregation of the homepage data, which
+	// is shared by all requests waiting for it.
+	homeFetchTimeout = 30 * time.Second
+
+	// homePosts is the number of latest blog posts in the homepage data.
+	homePosts = 3
+
+	// homeMeetings is the maximum number of upcoming meetings in the
+	// homepage data, and homeMeetingHorizon how far ahead they are looked
+	// for.
+	homeMeetings       = 3
+	homeMeetingHorizon = 60 * 24 * time.Hour
+
+	// blogFeed is the RSS feed of the blog generated by Hugo.
+	blogFeed = "blog/index.xml"
+)
+
+// homePost is a blog post in the homepage data.
+type homePost struct {
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Published time.Time `json:"published"`
+	Summary   string    `json:"summary,omitempty"`
+}
+
+// homeRelease is the latest release in the homepage data.
+type homeRelease struct {
+	Tag       string    `json:"tag"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Published time.Time `json:"published"`
+}
+
+// meeting is an upcoming community meeting.
+type meeting struct {
+	Title string    `json:"title"`
+	Start time.Time `json:"start"`
+	URL   string    `json:"url,omitempty"`
+}
+
+// homeData is served at /api/home: the dynamic sections of the homepage,
+// aggregated so that the homepage needs a single request. Errors fetching
+// individual sources are reported generically, and their sections left
+// empty, rather than failing the whole request.
+type homeData struct {
+	Posts    []homePost   `json:"posts"`
+	Release  *homeRelease `json:"release,omitempty"`
+	Stars    int          `json:"stars,omitempty"`
+	Meetings []meeting    `json:"meetings"`
+	Updated  time.Time    `json:"updated"`
+	Errors   []string     `json:"errors,omitempty"`
+}
+
+// latestPosts returns the latest blog posts, newest first, from the blog's
+// RSS feed in the static dir.
+func latestPosts(staticDir string, n int) ([]homePost, error) {
+	f, err := os.Open(filepath.Join(staticDir, filepath.FromSlash(blogFeed)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var feed struct {
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			PubDate     string `xml:"pubDate"`
+			Description string `xml:"description"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.NewDecoder(f).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("%s: %v", blogFeed, err)
+	}
+	posts := []homePost{}
+	for _, item := range feed.Items {
+		published, _ := time.Parse(time.RFC1123Z, item.PubDate)
+		posts = append(posts, homePost{
+			Title:     item.Title,
+			URL:       item.Link,
+			Published: published,
+			Summary:   pageText([]byte(item.Description)),
+		})
+	}
+	sort.SliceStable(posts, func(i, j int) bool { return posts[i].Published.After(posts[j].Published) })
+	if len(posts) > n {
+		posts = posts[:n]
+	}
+	return posts, nil
+}
+
+// repoAPIURL returns the GitHub API URL of the repository whose releases are
+// at --releases-url.
+func repoAPIURL() string {
+	return strings.TrimSuffix(strings.TrimSuffix(*releasesURL, "/"), "/releases")
+}
+
+// fetchStars fetches the star count of the repository from GitHub.
+func fetchStars(ctx context.Context) (int, error) {
+	req, err := http.NewRequest("GET", repoAPIURL(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("repository: %s", resp.Status)
+	}
+	var body struct {
+		Stars int `json:"stargazers_count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	return body.Stars, nil
+}
+
+// fetchLatestRelease fetches the latest release from GitHub, or nil if there
+// is none.
+func fetchLatestRelease(ctx context.Context) (*homeRelease, error) {
+	n, err := fetchRelease(ctx, strings.TrimSuffix(*releasesURL, "/")+"/latest", "latest")
+	if err != nil || n.NotFound {
+		return nil, err
+	}
+	return &homeRelease{Tag: n.Tag, Name: n.Name, URL: n.URL, Published: n.Published}, nil
+}
+
+// calendarEvent is an event of an iCalendar feed.
+type calendarEvent struct {
+	summary string
+	url     string
+	start   time.Time
+
+	// freq, interval, count and until are the parts of the recurrence
+	// rule, if any, that are supported.
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+}
+
+// parseCalendarTime parses an iCalendar DATE or DATE-TIME value with the
+// given property parameters, e.g. TZID=Europe/Zurich.
+func parseCalendarTime(params []string, value string) (time.Time, error) {
+	loc := time.UTC
+	for _, p := range params {
+		if strings.HasPrefix(p, "TZID=") {
+			if l, err := time.LoadLocation(strings.Trim(p[len("TZID="):], `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case len(value) == len("20060102"):
+		return time.ParseInLocation("20060102", value, loc)
+	default:
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+}
+
+// parseCalendar parses the events of an iCalendar feed. Only the properties
+// needed to list upcoming events are kept.
+func parseCalendar(r io.Reader) ([]calendarEvent, error) {
+	// Long lines are folded by starting continuation lines with a space
+	// or tab.
+	var lines []string
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if n := len(lines); n > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[n-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	var e *calendarEvent
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		params := strings.Split(line[:i], ";")
+		name, value := strings.ToUpper(params[0]), line[i+1:]
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			e = &calendarEvent{interval: 1}
+		case e == nil:
+		case name == "END" && value == "VEVENT":
+			if !e.start.IsZero() {
+				events = append(events, *e)
+			}
+			e = nil
+		case name == "SUMMARY":
+			e.summary = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ", `\\`, `\`).Replace(value)
+		case name == "URL":
+			e.url = value
+		case name == "LOCATION" && e.url == "" && strings.HasPrefix(value, "https://"):
+			e.url = value
+		case name == "DTSTART":
+			t, err := parseCalendarTime(params[1:], value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DTSTART %q: %v", value, err)
+			}
+			e.start = t
+		case name == "RRULE":
+			for _, part := range strings.Split(value, ";") {
+				kv := strings.SplitN(part, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				switch kv[0] {
+				case "FREQ":
+					e.freq = kv[1]
+				case "INTERVAL":
+					if n, err := strconv.Atoi(kv[1]); err == nil && n > 0 {
+						e.interval = n
+					}
+				case "COUNT":
+					e.count, _ = strconv.Atoi(kv[1])
+				case "UNTIL":
+					e.until, _ = parseCalendarTime(nil, kv[1])
+				}
+			}
+		}
+	}
+	return events, nil
+}
+
+// next returns the first occurrence of the event at or after t, or false if
+// there is none. Daily, weekly and monthly recurrences are expanded; events
+// with other recurrences only occur at their start.
+func (e calendarEvent) next(t time.Time) (time.Time, bool) {
+	occurrence := e.start
+	for n := 1; occurrence.Before(t); n++ {
+		if e.count > 0 && n >= e.count {
+			return time.Time{}, false
+		}
+		switch e.freq {
+		case "DAILY":
+			occurrence = e.start.AddDate(0, 0, n*e.interval)
+		case "WEEKLY":
+			occurrence = e.start.AddDate(0, 0, 7*n*e.interval)
+		case "MONTHLY":
+			occurrence = e.start.AddDate(0, n*e.interval, 0)
+		default:
+			return time.Time{}, false
+		}
+	}
+	if !e.until.IsZero() && occurrence.After(e.until) {
+		return time.Time{}, false
+	}
+	return occurrence, true
+}
+
+// upcomingMeetings returns the first n meetings of the calendar starting
+// between now and the horizon, soonest first.
+func upcomingMeetings(events []calendarEvent, now time.Time, n int) []meeting {
+	meetings := []meeting{}
+	for _, e := range events {
+		t := now
+		for i := 0; i < n; i++ {
+			start, ok := e.next(t)
+			if !ok || !start.Before(now.Add(homeMeetingHorizon)) {
+				break
+			}
+			meetings = append(meetings, meeting{Title: e.summary, Start: start.UTC(), URL: e.url})
+			t = start.Add(time.Second)
+		}
+	}
+	sort.SliceStable(meetings, func(i, j int) bool { return meetings[i].Start.Before(meetings[j].Start) })
+	if len(meetings) > n {
+		meetings = meetings[:n]
+	}
+	return meetings
+}
+
+// fetchMeetings fetches the upcoming meetings from the --meetings-calendar-url
+// iCalendar feed.
+func fetchMeetings(ctx context.Context) ([]meeting, error) {
+	req, err := http.NewRequest("GET", *meetingsCalendarURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient("calendar").Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calendar: %s", resp.Status)
+	}
+	events, err := parseCalendar(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return upcomingMeetings(events, time.Now(), homeMeetings), nil
+}
+
+// aggregateHome aggregates the homepage data from its sources.
+func aggregateHome(ctx context.Context, staticDir string) *homeData {
+	d := &homeData{Posts: []homePost{}, Meetings: []meeting{}, Updated: time.Now()}
+	posts, err := latestPosts(staticDir, homePosts)
+	if err != nil {
+		log.Printf("Error reading blog posts: %v", err)
+		d.Errors = append(d.Errors, "blog posts unavailable")
+	} else {
+		d.Posts = posts
+	}
+	if *enableGitProxy {
+		if d.Release, err = fetchLatestRelease(ctx); err != nil {
+			log.Printf("Error fetching latest release: %v", err)
+			d.Errors = append(d.Errors, "latest release unavailable")
+		}
+		if d.Stars, err = fetchStars(ctx); err != nil {
+			log.Printf("Error fetching star count: %v", err)
+			d.Errors = append(d.Errors, "star count unavailable")
+		}
+	}
+	if *meetingsCalendarURL != "" {
+		meetings, err := fetchMeetings(ctx)
+		if err != nil {
+			log.Printf("Error fetching meetings: %v", err)
+			d.Errors = append(d.Errors, "meetings unavailable")
+		} else {
+			d.Meetings = meetings
+		}
+	}
+	return d
+}
+
+// homeFlight deduplicates concurrent aggregations of the homepage data.
+var homeFlight flightGroup
+
+// currentHome returns the homepage data, using the shared cache so that
+// instances don't each use up the GitHub API rate limit.
+func currentHome(ctx context.Context, staticDir string) *homeData {
+	if b, ok, err := sharedCache.get(ctx, homeCacheKey); err == nil && ok {
+		var d homeData
+		if err := json.Unmarshal(b, &d); err == nil {
+			return &d
+		}
+	}
+	v, err := homeFlight.do(homeCacheKey, func() (interface{}, error) {
+		// The aggregation is shared, so it must not be canceled with
+		// the request that started it.
+		ctx, cancel := context.WithTimeout(context.Background(), homeFetchTimeout)
+		defer cancel()
+		d := aggregateHome(ctx, staticDir)
+		if b, err := json.Marshal(d); err == nil {
+			sharedCache.set(ctx, homeCacheKey, b, homeTTL)
+		}
+		return d, nil
+	})
+	d, ok := v.(*homeData)
+	if err != nil || !ok {
+		log.Printf("Error aggregating the homepage data: %v", err)
+		return &homeData{}
+	}
+	return d
+}
+
+// homeHandler serves the homepage data as JSON.
+func homeHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(currentHome(r.Context(), staticDir))
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=America/Los_Angeles:20200107T090000\r\n" +
+	"RRULE:FREQ=WEEKLY;INTERVAL=2\r\n" +
+	"SUMMARY:gVisor community\\, biweekly\r\n" +
+	"LOCATION:https://meet.google.com/abc-defg-hij\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20200201T170000Z\r\n" +
+	"SUMMARY:Past one-off meeting\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20200115\r\n" +
+	"RRULE:FREQ=MONTHLY;COUNT=3\r\n" +
+	"SUMMARY:Release plann\r\n" +
+	" ing\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestUpcomingMeetings(t *testing.T) {
+	events, err := parseCalendar(strings.NewReader(testCalendar))
+	if err != nil {
+		t.Fatalf("parseCalendar failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	now := time.Date(2020, 2, 10, 0, 0, 0, 0, time.UTC)
+	community := "https://meet.google.com/abc-defg-hij"
+	want := []meeting{
+		{Title: "Release planning", Start: time.Date(2020, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{Title: "gVisor community, biweekly", Start: time.Date(2020, 2, 18, 17, 0, 0, 0, time.UTC), URL: community},
+		{Title: "gVisor community, biweekly", Start: time.Date(2020, 3, 3, 17, 0, 0, 0, time.UTC), URL: community},
+		{Title: "Release planning", Start: time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	got := upcomingMeetings(events, now, len(want))
+	if len(got) != len(want) {
+		t.Fatalf("got meetings %+v, want %+v", got, want)
+	}
+	for i := range got {
+		if got[i].Title != want[i].Title || !got[i].Start.Equal(want[i].Start) || got[i].URL != want[i].URL {
+			t.Errorf("meeting %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	// The monthly meeting only occurs three times.
+	if got := upcomingMeetings(events, time.Date(2020, 3, 20, 0, 0, 0, 0, time.UTC), 10); len(got) != 4 || got[0].Title != "gVisor community, biweekly" {
+		t.Errorf("after the last planning meeting, got meetings %+v, want only the biweekly ones", got)
+	}
+}
+
+func TestHomeHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "blog"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	feed := `<?xml version="1.0" encoding="utf-8"?><rss version="2.0"><channel>
+<item><title>gVisor Security Basics - Part 1</title><link>https://gvisor.dev/blog/2019/11/18/gvisor-security-basics-part-1/</link><pubDate>Mon, 18 Nov 2019 00:00:00 +0000</pubDate><description>&lt;p&gt;Part 1&lt;/p&gt;</description></item>
+<item><title>gVisor Networking Security</title><link>https://gvisor.dev/blog/2020/04/02/gvisor-networking-security/</link><pubDate>Thu, 02 Apr 2020 00:00:00 +0000</pubDate></item>
+</channel></rss>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "blog", "index.xml"), []byte(feed), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/repos/google/gvisor":
+			io.WriteString(w, `{"stargazers_count": 12345}`)
+		case "/repos/google/gvisor/releases/latest":
+			io.WriteString(w, `{"tag_name": "release-20200127.0", "name": "", "html_url": "https://github.com/google/gvisor/releases/tag/release-20200127.0", "published_at": "2020-01-27T18:00:00Z"}`)
+		case "/calendar.ics":
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(c cache, r, m string) { sharedCache, *releasesURL, *meetingsCalendarURL = c, r, m }(sharedCache, *releasesURL, *meetingsCalendarURL)
+	sharedCache = newMemoryCache(100, 1<<20)
+	*releasesURL = srv.URL + "/repos/google/gvisor/releases"
+	*meetingsCalendarURL = srv.URL + "/calendar.ics"
+
+	h := homeHandler(dir)
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/home", nil))
+		var got homeData
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+		if len(got.Posts) != 2 || got.Posts[0].Title != "gVisor Networking Security" || got.Posts[1].Summary != "Part 1" {
+			t.Errorf("got posts %+v, want the newest first", got.Posts)
+		}
+		if got.Release == nil || got.Release.Name != "release-20200127.0" {
+			t.Errorf("got release %+v, want release-20200127.0", got.Release)
+		}
+		if got.Stars != 12345 {
+			t.Errorf("got %d stars, want 12345", got.Stars)
+		}
+		if len(got.Meetings) != 0 || len(got.Errors) != 1 || got.Errors[0] != "meetings unavailable" {
+			t.Errorf("got meetings %+v and errors %q, want the meetings reported unavailable", got.Meetings, got.Errors)
+		}
+	}
+	// The payload is cached.
+	if requests != 3 {
+		t.Errorf("got %d upstream requests, want 3", requests)
+	}
+}
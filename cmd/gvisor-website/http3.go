@@ -0,0 +1,58 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build http3
+// +build http3
+
+// HTTP/3 support needs quic-go, which needs a newer Go than the rest of
+// the server, so it is only built with the http3 tag:
+//
+//	go get github.com/quic-go/quic-go@v0.41.0
+//	go build -tags http3
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// listenQUIC opens the UDP socket of the quic listener c, with its
+// certificate.
+func listenQUIC(c listenerConfig) (*openListener, error) {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the certificate: %v", err)
+	}
+	pc, err := net.ListenPacket("udp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &openListener{packets: pc, tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}, nil
+}
+
+// serveQUIC serves HTTP/3 on the quic listener l.
+func serveQUIC(c listenerConfig, l *openListener, h http.Handler) error {
+	srv := &http3.Server{
+		Handler:    h,
+		TLSConfig:  l.tlsConfig,
+		QuicConfig: &quic.Config{MaxIdleTimeout: c.idleTimeout},
+	}
+	return srv.Serve(l.packets)
+}
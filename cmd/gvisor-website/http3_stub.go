@@ -0,0 +1,35 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !http3
+// +build !http3
+
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errNoHTTP3 is returned for quic listeners by servers built without the
+// http3 tag.
+var errNoHTTP3 = errors.New("HTTP/3 support is not built in; build with -tags http3")
+
+func listenQUIC(c listenerConfig) (*openListener, error) {
+	return nil, errNoHTTP3
+}
+
+func serveQUIC(c listenerConfig, l *openListener, h http.Handler) error {
+	return errNoHTTP3
+}
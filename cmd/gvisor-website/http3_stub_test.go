@@ -0,0 +1,43 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !http3
+// +build !http3
+
+package main
+
+import "testing"
+
+func TestListenQUICWithoutHTTP3(t *testing.T) {
+	defer func(l []listenerStatus) { listeners = l }(listeners)
+
+	ls, err := listen([]listenerConfig{
+		{network: "tcp4", addr: "127.0.0.1:0"},
+		{network: "quic", addr: "127.0.0.1:0", certFile: "cert.pem", keyFile: "key.pem", optional: true},
+	})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ls[0].Close()
+	if ls[1] != nil {
+		t.Errorf("optional quic listener is listening without HTTP/3 support")
+	}
+	if s := listeners[1]; s.Listening || s.Error != errNoHTTP3.Error() {
+		t.Errorf("quic listener = %+v, want it failed with %q", s, errNoHTTP3)
+	}
+
+	if _, err := listen([]listenerConfig{{network: "quic", addr: "127.0.0.1:0", certFile: "cert.pem", keyFile: "key.pem"}}); err != errNoHTTP3 {
+		t.Errorf("listen on a required quic listener = %v, want %v", err, errNoHTTP3)
+	}
+}
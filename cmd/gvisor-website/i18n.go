@@ -0,0 +1,291 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLanguage is the language of the site, and of messages that aren't
+// translated.
+const defaultLanguage = "en"
+
+// messages is the catalog of the messages of pages generated by the server,
+// e.g. error pages and interstitials, by language and key. Messages are
+// fmt formats. Translations may be incomplete; missing messages are served
+// in the default language.
+var messages = map[string]map[string]string{
+	"en": {
+		"date_format":            "January 2, 2006",
+		"status_500":             "Internal Server Error",
+		"status_502":             "Bad Gateway",
+		"status_503":             "Service Unavailable",
+		"status_504":             "Gateway Timeout",
+		"error_server":           "Something went wrong on our end. Please try again in a little while.",
+		"error_overloaded":       "The site is temporarily overloaded. Please try again in a few seconds.",
+		"error_upstream":         "We couldn't reach an upstream service. Please try again shortly.",
+		"error_meanwhile":        "In the meantime:",
+		"read_docs":              "Read the documentation",
+		"report_problem":         "Report a problem",
+		"homepage":               "Go to the gVisor homepage",
+		"request_id":             "Request ID: %s",
+		"unknown_syscall":        "Unknown syscall %q",
+		"did_you_mean":           "did you mean %s?",
+		"deprecated_title":       "Deprecated link",
+		"deprecated_heading":     "This link is deprecated",
+		"deprecated_body":        "The link %s is deprecated.",
+		"deprecated_sunset":      "It will stop working on %s.",
+		"deprecated_redirecting": "You will be redirected in %d seconds to:",
+		"retired_title":          "Retired link",
+		"retired_heading":        "This link has been retired",
+		"retired_body":           "The link %s stopped working on %s.",
+		"retired_target":         "It used to lead to:",
+		"use_replacement":        "Please use this link instead, and update any links you maintain:",
+		"deprecation_gerrit":     "Code review has moved from Gerrit to GitHub pull requests.",
+	},
+	"de": {
+		"date_format":            "2.1.2006",
+		"status_500":             "Interner Serverfehler",
+		"status_502":             "Fehlerhaftes Gateway",
+		"status_503":             "Dienst nicht verfügbar",
+		"status_504":             "Gateway-Zeitüberschreitung",
+		"error_server":           "Bei uns ist etwas schiefgelaufen. Bitte versuchen Sie es in Kürze erneut.",
+		"error_overloaded":       "Die Website ist vorübergehend überlastet. Bitte versuchen Sie es in einigen Sekunden erneut.",
+		"error_upstream":         "Ein vorgelagerter Dienst war nicht erreichbar. Bitte versuchen Sie es gleich noch einmal.",
+		"error_meanwhile":        "In der Zwischenzeit:",
+		"read_docs":              "Dokumentation lesen",
+		"report_problem":         "Problem melden",
+		"homepage":               "Zur gVisor-Startseite",
+		"request_id":             "Anfrage-ID: %s",
+		"unknown_syscall":        "Unbekannter Systemaufruf %q",
+		"did_you_mean":           "meinten Sie %s?",
+		"deprecated_title":       "Veralteter Link",
+		"deprecated_heading":     "Dieser Link ist veraltet",
+		"deprecated_body":        "Der Link %s ist veraltet.",
+		"deprecated_sunset":      "Er funktioniert ab dem %s nicht mehr.",
+		"deprecated_redirecting": "Sie werden in %d Sekunden weitergeleitet zu:",
+		"retired_title":          "Stillgelegter Link",
+		"retired_heading":        "Dieser Link wurde stillgelegt",
+		"retired_body":           "Der Link %s funktioniert seit dem %s nicht mehr.",
+		"retired_target":         "Er führte zu:",
+		"use_replacement":        "Bitte verwenden Sie stattdessen diesen Link und aktualisieren Sie Links, die Sie pflegen:",
+		"deprecation_gerrit":     "Code-Reviews finden nicht mehr in Gerrit, sondern in GitHub-Pull-Requests statt.",
+	},
+	"es": {
+		"date_format":            "2/1/2006",
+		"status_500":             "Error interno del servidor",
+		"status_502":             "Puerta de enlace incorrecta",
+		"status_503":             "Servicio no disponible",
+		"status_504":             "Tiempo de espera de la puerta de enlace agotado",
+		"error_server":           "Algo salió mal por nuestra parte. Vuelva a intentarlo dentro de un rato.",
+		"error_overloaded":       "El sitio está sobrecargado temporalmente. Vuelva a intentarlo en unos segundos.",
+		"error_upstream":         "No pudimos conectar con un servicio externo. Vuelva a intentarlo en breve.",
+		"error_meanwhile":        "Mientras tanto:",
+		"read_docs":              "Leer la documentación",
+		"report_problem":         "Informar de un problema",
+		"homepage":               "Ir a la página principal de gVisor",
+		"request_id":             "ID de solicitud: %s",
+		"unknown_syscall":        "Llamada al sistema desconocida: %q",
+		"did_you_mean":           "¿quiso decir %s?",
+		"deprecated_title":       "Enlace obsoleto",
+		"deprecated_heading":     "Este enlace está obsoleto",
+		"deprecated_body":        "El enlace %s está obsoleto.",
+		"deprecated_sunset":      "Dejará de funcionar el %s.",
+		"deprecated_redirecting": "Se le redirigirá en %d segundos a:",
+		"retired_title":          "Enlace retirado",
+		"retired_heading":        "Este enlace se ha retirado",
+		"retired_body":           "El enlace %s dejó de funcionar el %s.",
+		"retired_target":         "Antes llevaba a:",
+		"use_replacement":        "Utilice este enlace en su lugar y actualice los enlaces que mantenga:",
+		"deprecation_gerrit":     "La revisión de código se ha trasladado de Gerrit a las pull requests de GitHub.",
+	},
+	"fr": {
+		"date_format":            "2/1/2006",
+		"status_500":             "Erreur interne du serveur",
+		"status_502":             "Passerelle incorrecte",
+		"status_503":             "Service indisponible",
+		"status_504":             "Délai d'attente de la passerelle dépassé",
+		"error_server":           "Un problème est survenu de notre côté. Veuillez réessayer dans quelques instants.",
+		"error_overloaded":       "Le site est temporairement surchargé. Veuillez réessayer dans quelques secondes.",
+		"error_upstream":         "Nous n'avons pas pu joindre un service externe. Veuillez réessayer sous peu.",
+		"error_meanwhile":        "En attendant :",
+		"read_docs":              "Lire la documentation",
+		"report_problem":         "Signaler un problème",
+		"homepage":               "Aller à la page d'accueil de gVisor",
+		"request_id":             "Identifiant de la requête : %s",
+		"unknown_syscall":        "Appel système inconnu : %q",
+		"did_you_mean":           "vouliez-vous dire %s ?",
+		"deprecated_title":       "Lien obsolète",
+		"deprecated_heading":     "Ce lien est obsolète",
+		"deprecated_body":        "Le lien %s est obsolète.",
+		"deprecated_sunset":      "Il cessera de fonctionner le %s.",
+		"deprecated_redirecting": "Vous serez redirigé dans %d secondes vers :",
+		"retired_title":          "Lien retiré",
+		"retired_heading":        "Ce lien a été retiré",
+		"retired_body":           "Le lien %s ne fonctionne plus depuis le %s.",
+		"retired_target":         "Il menait vers :",
+		"use_replacement":        "Veuillez utiliser ce lien à la place et mettre à jour les liens que vous gérez :",
+		"deprecation_gerrit":     "La revue de code est passée de Gerrit aux pull requests GitHub.",
+	},
+	"ja": {
+		"date_format":            "2006年1月2日",
+		"status_500":             "内部サーバーエラー",
+		"status_502":             "不正なゲートウェイ",
+		"status_503":             "サービス利用不可",
+		"status_504":             "ゲートウェイタイムアウト",
+		"error_server":           "サーバー側で問題が発生しました。しばらくしてからもう一度お試しください。",
+		"error_overloaded":       "サイトが一時的に過負荷状態です。数秒後にもう一度お試しください。",
+		"error_upstream":         "上流のサービスに接続できませんでした。しばらくしてからもう一度お試しください。",
+		"error_meanwhile":        "その間に、次のこともできます:",
+		"read_docs":              "ドキュメントを読む",
+		"report_problem":         "問題を報告する",
+		"homepage":               "gVisor のホームページへ",
+		"request_id":             "リクエスト ID: %s",
+		"unknown_syscall":        "不明なシステムコール %q",
+		"did_you_mean":           "もしかして: %s",
+		"deprecated_title":       "非推奨のリンク",
+		"deprecated_heading":     "このリンクは非推奨です",
+		"deprecated_body":        "リンク %s は非推奨です。",
+		"deprecated_sunset":      "%s に利用できなくなります。",
+		"deprecated_redirecting": "%d 秒後に次のページへ移動します:",
+		"retired_title":          "廃止されたリンク",
+		"retired_heading":        "このリンクは廃止されました",
+		"retired_body":           "リンク %s は %s に利用できなくなりました。",
+		"retired_target":         "以前のリンク先:",
+		"use_replacement":        "代わりに次のリンクを使用し、管理しているリンクも更新してください:",
+		"deprecation_gerrit":     "コードレビューは Gerrit から GitHub のプルリクエストに移行しました。",
+	},
+	"zh": {
+		"date_format":            "2006年1月2日",
+		"status_500":             "服务器内部错误",
+		"status_502":             "网关错误",
+		"status_503":             "服务不可用",
+		"status_504":             "网关超时",
+		"error_server":           "我们这边出了点问题。请稍后再试。",
+		"error_overloaded":       "网站暂时过载。请几秒钟后再试。",
+		"error_upstream":         "无法连接上游服务。请稍后再试。",
+		"error_meanwhile":        "在此期间，您可以：",
+		"read_docs":              "阅读文档",
+		"report_problem":         "报告问题",
+		"homepage":               "前往 gVisor 主页",
+		"request_id":             "请求 ID：%s",
+		"unknown_syscall":        "未知的系统调用 %q",
+		"did_you_mean":           "您是不是要找 %s？",
+		"deprecated_title":       "已弃用的链接",
+		"deprecated_heading":     "此链接已弃用",
+		"deprecated_body":        "链接 %s 已弃用。",
+		"deprecated_sunset":      "它将于 %s 停止使用。",
+		"deprecated_redirecting": "您将在 %d 秒后被重定向到：",
+		"retired_title":          "已停用的链接",
+		"retired_heading":        "此链接已停用",
+		"retired_body":           "链接 %s 已于 %s 停止使用。",
+		"retired_target":         "它原先指向：",
+		"use_replacement":        "请改用此链接，并更新您维护的链接：",
+		"deprecation_gerrit":     "代码审查已从 Gerrit 迁移到 GitHub 拉取请求。",
+	},
+}
+
+// languageQuality returns the quality the client gives the language in the
+// Accept-Language header. Ranges of a region of the language, e.g. de-CH for
+// de, match too, since browsers often only list those; an exact match takes
+// precedence.
+func languageQuality(r *http.Request, lang string) float64 {
+	exact, region := 0.0, 0.0
+	for _, part := range strings.Split(strings.Join(r.Header["Accept-Language"], ","), ",") {
+		params := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch {
+		case tag == lang:
+			exact = q
+		case strings.HasPrefix(tag, lang+"-") && q > region:
+			region = q
+		}
+	}
+	if exact > 0 {
+		return exact
+	}
+	return region
+}
+
+// languages are the languages of the catalog, the default language first so
+// that it wins ties.
+var languages = func() []string {
+	langs := []string{defaultLanguage}
+	for lang := range messages {
+		if lang != defaultLanguage {
+			langs = append(langs, lang)
+		}
+	}
+	sort.Strings(langs[1:])
+	return langs
+}()
+
+// pageLanguage returns the language the client prefers among those of the
+// catalog, or the default language. Accept-Language is added to Vary
+// whatever the result.
+func pageLanguage(w http.ResponseWriter, r *http.Request) string {
+	addVary(w.Header(), "Accept-Language")
+	best, bestQ := defaultLanguage, 0.0
+	for _, lang := range languages {
+		if q := languageQuality(r, lang); q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// translate returns the message with the given key in the language, formatted
+// with the arguments.
+func translate(lang, key string, args ...interface{}) string {
+	msg, ok := messages[lang][key]
+	if !ok {
+		msg, ok = messages[defaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// statusText returns the text of the HTTP status code in the language.
+func statusText(lang string, code int) string {
+	key := fmt.Sprintf("status_%d", code)
+	if msg := translate(lang, key); msg != key {
+		return msg
+	}
+	return http.StatusText(code)
+}
+
+// formatDate formats the date in the language.
+func formatDate(lang string, t time.Time) string {
+	return t.Format(translate(lang, "date_format"))
+}
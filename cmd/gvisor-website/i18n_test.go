@@ -0,0 +1,99 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-CH, en;q=0.5", "de"},
+		{"en-US, de;q=0.9", "en"},
+		{"fr;q=0.5, ja", "ja"},
+		{"pt-BR", "en"},
+		{"de;q=0, fr-FR;q=0.1", "fr"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tc.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tc.acceptLanguage)
+		}
+		rec := httptest.NewRecorder()
+		if got := pageLanguage(rec, r); got != tc.want {
+			t.Errorf("pageLanguage(Accept-Language: %q) = %q, want %q", tc.acceptLanguage, got, tc.want)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Language" {
+			t.Errorf("pageLanguage(Accept-Language: %q) set Vary %q, want Accept-Language", tc.acceptLanguage, got)
+		}
+	}
+}
+
+func TestMessagesAreTranslated(t *testing.T) {
+	for lang, msgs := range messages {
+		for key := range msgs {
+			if _, ok := messages[defaultLanguage][key]; !ok {
+				t.Errorf("message %s of %s is missing in %s", key, lang, defaultLanguage)
+			}
+		}
+	}
+	if got := translate("de", "Moved."); got != "Moved." {
+		t.Errorf("translate of a missing key = %q, want the key", got)
+	}
+	if got := statusText("ja", http.StatusNotFound); got != "Not Found" {
+		t.Errorf("statusText of an untranslated status = %q, want Not Found", got)
+	}
+	if got := formatDate("de", time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)); got != "2.1.2030" {
+		t.Errorf("formatDate(de) = %q, want 2.1.2030", got)
+	}
+}
+
+func TestLocalizedPages(t *testing.T) {
+	anchors := map[string]bool{"epoll_wait": true}
+	compat := compatRedirectHandler("/c/linux/amd64/", "/docs/user_guide/compatibility/linux/amd64/#%s", anchors)
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	deprecated := deprecatedRedirectHandler("/cl/", redirectDeprecation{note: "deprecation_gerrit", sunset: sunset}, prefixRedirectHandler("/cl/", prefixHelpers["cl"]))
+	for _, tc := range []struct {
+		h    http.Handler
+		path string
+		want []string
+	}{
+		{compat, "/c/linux/amd64/epoll", []string{`Unbekannter Systemaufruf "epoll"`, "meinten Sie epoll_wait?"}},
+		{deprecated, "/cl/123", []string{`<html lang="de">`, "Er funktioniert ab dem 1.1.2030 nicht mehr.", "Gerrit"}},
+		{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { renderErrorPage(w, r, http.StatusInternalServerError) }), "/", []string{"500 Interner Serverfehler", "Zur gVisor-Startseite"}},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+		r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+		rec := httptest.NewRecorder()
+		classifyHandler("test", tc.h).ServeHTTP(rec, r)
+		for _, want := range tc.want {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("GET %s: body %q does not contain %q", tc.path, rec.Body.String(), want)
+			}
+		}
+		if !strings.Contains(rec.Header().Get("Vary"), "Accept-Language") {
+			t.Errorf("GET %s: Vary %q does not include Accept-Language", tc.path, rec.Header().Get("Vary"))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// imageVariant is an alternative encoding of an image.
+type imageVariant struct {
+	ext         string
+	contentType string
+}
+
+// imageVariants are the supported variants, most preferred first.
+var imageVariants = []imageVariant{
+	{".avif", "image/avif"},
+	{".webp", "image/webp"},
+}
+
+// variantSources are the image types that variants may exist for.
+var variantSources = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+}
+
+var (
+	variantFilesMu sync.Mutex
+	variantFiles   = make(map[string]map[string]string)
+)
+
+// findVariantFiles returns the variants of the images in the static
+// manifest, keyed by image path plus variant extension. Variants are named
+// either by appending the variant extension (foo.png.webp) or by replacing
+// the image extension (foo.webp).
+func findVariantFiles(m *staticManifest) map[string]string {
+	files := make(map[string]string)
+	if m == nil {
+		return files
+	}
+	for image := range m.Files {
+		if !variantSources[strings.ToLower(path.Ext(image))] {
+			continue
+		}
+		for _, v := range imageVariants {
+			for _, name := range []string{image + v.ext, strings.TrimSuffix(image, path.Ext(image)) + v.ext} {
+				if _, ok := m.Files[name]; ok {
+					files[image+v.ext] = name
+					break
+				}
+			}
+		}
+	}
+	return files
+}
+
+// variantFile returns the path of the given variant of the image, or "" if
+// there is none. Variants are found once from the static manifest, since the
+// static dir only changes on deploy.
+func variantFile(staticDir, image string, v imageVariant) string {
+	variantFilesMu.Lock()
+	files, ok := variantFiles[staticDir]
+	if !ok {
+		files = findVariantFiles(getStaticManifest(staticDir))
+		variantFiles[staticDir] = files
+	}
+	variantFilesMu.Unlock()
+	return files[image+v.ext]
+}
+
+// imageVariantHandler serves AVIF or WebP variants of PNG and JPEG images to
+// clients that accept them, when a variant exists in the static dir.
+func imageVariantHandler(staticDir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !variantSources[strings.ToLower(path.Ext(r.URL.Path))] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		var offers []string
+		names := make(map[string]string)
+		for _, v := range imageVariants {
+			if name := variantFile(staticDir, path.Clean(r.URL.Path), v); name != "" {
+				offers = append(offers, v.contentType)
+				names[v.contentType] = name
+			}
+		}
+		if len(offers) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		contentType := negotiate(w, r, "Accept", offers...)
+		if contentType == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		h.ServeHTTP(w, withPath(r, names[contentType]))
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindVariantFiles(t *testing.T) {
+	m := &staticManifest{Files: map[string]string{
+		"/a.png":      "",
+		"/a.png.webp": "",
+		"/a.webp":     "",
+		"/a.avif":     "",
+		"/b.JPG":      "",
+		"/b.webp":     "",
+		"/c.gif":      "",
+		"/c.webp":     "",
+		"/d.jpeg":     "",
+		"/e.webp":     "",
+	}}
+	want := map[string]string{
+		"/a.png.avif": "/a.avif",
+		"/a.png.webp": "/a.png.webp",
+		"/b.JPG.webp": "/b.webp",
+	}
+	if got := findVariantFiles(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("findVariantFiles = %v, want %v", got, want)
+	}
+	if got := findVariantFiles(nil); len(got) != 0 {
+		t.Errorf("findVariantFiles(nil) = %v, want none", got)
+	}
+}
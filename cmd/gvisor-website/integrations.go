@@ -0,0 +1,226 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// integrationsFile is the integration versions supported by each gVisor
+// release, which is maintained in the static dir.
+const integrationsFile = "integrations.json"
+
+// integrationsPath is the URL path of the integrations matrix.
+const integrationsPath = "/docs/user_guide/compatibility/integrations/"
+
+// versionRange is a range of versions of an integration. Max is empty if
+// later versions are supported too.
+type versionRange struct {
+	Min string `json:"min"`
+	Max string `json:"max,omitempty"`
+}
+
+// contains returns whether version is in the range, comparing only the
+// components given in the bounds, so that 1.14.2 is in 1.12 – 1.14.
+func (r versionRange) contains(version string) bool {
+	if c, err := compareVersions(version, r.Min); err != nil || c < 0 {
+		return false
+	}
+	if r.Max == "" {
+		return true
+	}
+	c, err := compareVersions(version, r.Max)
+	return err == nil && c <= 0
+}
+
+// compareVersions compares dotted versions such as v1.2.3 component by
+// component, returning -1, 0 or 1. Components missing from b are ignored,
+// and those missing from a are 0.
+func compareVersions(a, b string) (int, error) {
+	pa, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pb {
+		n := 0
+		if i < len(pa) {
+			n = pa[i]
+		}
+		if n != pb[i] {
+			if n < pb[i] {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// parseVersion returns the numeric components of a dotted version.
+func parseVersion(v string) ([]int, error) {
+	var parts []int
+	for _, s := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", v)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// containerdShim is a containerd shim that can run a gVisor release.
+type containerdShim struct {
+	Name       string       `json:"name"`
+	Version    string       `json:"version"`
+	Containerd versionRange `json:"containerd"`
+}
+
+// integrationRelease is the integration versions supported by a gVisor
+// release.
+type integrationRelease struct {
+	Release    string           `json:"release"`
+	Date       string           `json:"date"`
+	Shims      []containerdShim `json:"shims"`
+	Kubernetes versionRange     `json:"kubernetes"`
+	Docker     versionRange     `json:"docker"`
+	Notes      string           `json:"notes,omitempty"`
+
+	// Anchor is the URL of the release in the integrations matrix.
+	Anchor string `json:"anchor"`
+}
+
+// loadIntegrations reads the integrations matrix from the static dir, newest
+// release first.
+func loadIntegrations(staticDir string) ([]integrationRelease, error) {
+	b, err := ioutil.ReadFile(filepath.Join(staticDir, integrationsFile))
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Releases []integrationRelease `json:"releases"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	for i := range data.Releases {
+		r := &data.Releases[i]
+		if r.Release == "" {
+			return nil, fmt.Errorf("release %d has no name", i)
+		}
+		ranges := []versionRange{r.Kubernetes, r.Docker}
+		for _, s := range r.Shims {
+			ranges = append(ranges, s.Containerd)
+		}
+		for _, vr := range ranges {
+			if _, err := parseVersion(vr.Min); err != nil {
+				return nil, fmt.Errorf("release %s: %v", r.Release, err)
+			}
+			if _, err := parseVersion(vr.Max); vr.Max != "" && err != nil {
+				return nil, fmt.Errorf("release %s: %v", r.Release, err)
+			}
+		}
+		r.Anchor = integrationsPath + "#release-" + r.Release
+	}
+	return data.Releases, nil
+}
+
+var (
+	integrationsOnce sync.Once
+	integrations     []integrationRelease
+)
+
+// getIntegrations returns the integrations matrix, loading it on first use.
+func getIntegrations(staticDir string) []integrationRelease {
+	integrationsOnce.Do(func() {
+		var err error
+		integrations, err = loadIntegrations(staticDir)
+		if err != nil {
+			log.Printf("Error loading integrations: %v", err)
+		}
+	})
+	return integrations
+}
+
+// integrationsHandler serves the integration versions supported by each
+// release, optionally only the given release ("latest" for the newest) or
+// the releases that support the given containerd, Kubernetes or Docker
+// versions.
+func integrationsHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		release := query.Get("release")
+		versions := make(map[string]string)
+		for _, name := range []string{"containerd", "kubernetes", "docker"} {
+			v := query.Get(name)
+			if v == "" {
+				continue
+			}
+			if _, err := parseVersion(v); err != nil {
+				httpError(w, r, "invalid "+name+" version", http.StatusBadRequest)
+				return
+			}
+			versions[name] = v
+		}
+		all := getIntegrations(staticDir)
+		if all == nil {
+			httpError(w, r, "integrations are unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if release == "latest" && len(all) > 0 {
+			release = all[0].Release
+		}
+		results := make([]integrationRelease, 0, len(all))
+		for _, rel := range all {
+			if release != "" && rel.Release != release {
+				continue
+			}
+			if v, ok := versions["kubernetes"]; ok && !rel.Kubernetes.contains(v) {
+				continue
+			}
+			if v, ok := versions["docker"]; ok && !rel.Docker.contains(v) {
+				continue
+			}
+			if v, ok := versions["containerd"]; ok {
+				var shims []containerdShim
+				for _, s := range rel.Shims {
+					if s.Containerd.contains(v) {
+						shims = append(shims, s)
+					}
+				}
+				if len(shims) == 0 {
+					continue
+				}
+				rel.Shims = shims
+			}
+			results = append(results, rel)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(results)
+	})
+}
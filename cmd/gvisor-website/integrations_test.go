@@ -0,0 +1,133 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.14", "1.14", 0},
+		{"1.14.2", "1.14", 0},
+		{"v1.2.0", "1.2", 0},
+		{"1.2", "1.2.0", 0},
+		{"1.9", "1.14", -1},
+		{"17.09.1", "17.09.0", 1},
+		{"1.3", "1.12", -1},
+	} {
+		got, err := compareVersions(tc.a, tc.b)
+		if err != nil {
+			t.Errorf("compareVersions(%q, %q) failed: %v", tc.a, tc.b, err)
+		} else if got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+	if _, err := compareVersions("1.x", "1.2"); err == nil {
+		t.Errorf("compareVersions of 1.x succeeded, want error")
+	}
+}
+
+func TestIntegrationsHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := `{"releases": [
+		{"release": "20200115.0", "date": "2020-01-15",
+		 "shims": [
+			{"name": "containerd-shim-runsc-v1", "version": "v0.0.4", "containerd": {"min": "1.2"}},
+			{"name": "gvisor-containerd-shim", "version": "v0.0.4", "containerd": {"min": "1.1", "max": "1.3"}}
+		 ],
+		 "kubernetes": {"min": "1.14"}, "docker": {"min": "17.09.0"}},
+		{"release": "20191129.0", "date": "2019-11-29",
+		 "shims": [{"name": "gvisor-containerd-shim", "version": "v0.0.3", "containerd": {"min": "1.1", "max": "1.2"}}],
+		 "kubernetes": {"min": "1.12", "max": "1.15"}, "docker": {"min": "17.09.0"}}
+	]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, integrationsFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	integrationsOnce, integrations = sync.Once{}, nil
+	defer func() { integrationsOnce, integrations = sync.Once{}, nil }()
+
+	h := integrationsHandler(dir)
+	for _, tc := range []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"", http.StatusOK, []string{"20200115.0:2", "20191129.0:1"}},
+		{"?release=latest", http.StatusOK, []string{"20200115.0:2"}},
+		{"?release=20191129.0", http.StatusOK, []string{"20191129.0:1"}},
+		{"?kubernetes=1.16.3", http.StatusOK, []string{"20200115.0:2"}},
+		{"?kubernetes=1.12&docker=18.09", http.StatusOK, []string{"20191129.0:1"}},
+		{"?containerd=1.3.2", http.StatusOK, []string{"20200115.0:2"}},
+		{"?containerd=1.4", http.StatusOK, []string{"20200115.0:1"}},
+		{"?docker=1.13", http.StatusOK, []string{}},
+		{"?containerd=latest", http.StatusBadRequest, nil},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/compatibility/integrations"+tc.query, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.query, rec.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var releases []integrationRelease
+		if err := json.NewDecoder(rec.Body).Decode(&releases); err != nil {
+			t.Fatalf("%s: Decode failed: %v", tc.query, err)
+		}
+		got := []string{}
+		for _, r := range releases {
+			got = append(got, fmt.Sprintf("%s:%d", r.Release, len(r.Shims)))
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: got releases %v, want %v", tc.query, got, tc.want)
+		}
+		if len(releases) > 0 && releases[0].Anchor != integrationsPath+"#release-"+releases[0].Release {
+			t.Errorf("%s: anchor = %q", tc.query, releases[0].Anchor)
+		}
+	}
+}
+
+func TestLoadIntegrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := `{"releases": [{"release": "20200115.0", "kubernetes": {"min": "1.x"}, "docker": {"min": "17.09"}}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, integrationsFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIntegrations(dir); err == nil {
+		t.Errorf("loadIntegrations of an invalid version succeeded, want error")
+	}
+}
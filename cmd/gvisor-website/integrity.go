@@ -0,0 +1,220 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var staticIntegrity = newGauge("static_content_integrity", "Whether the static content matched the signed manifest at startup.")
+
+// integrityManifest lists the files of a static dir with their SHA-256. It is
+// written by the build and signed with a Cloud KMS key, e.g.
+//
+//	gcloud kms asymmetric-sign --digest-algorithm sha256 \
+//		--input-file public/static-manifest.json \
+//		--signature-file public/static-manifest.json.sig ...
+//
+// Unlike the static manifest, which identifies content versions, it holds
+// full hashes of every file, including error page templates.
+type integrityManifest struct {
+	// Files maps paths relative to the static dir to hex SHA-256 hashes.
+	Files map[string]string `json:"files"`
+}
+
+// hashStaticDir returns the integrity manifest of the static dir.
+func hashStaticDir(staticDir string) (*integrityManifest, error) {
+	m := &integrityManifest{Files: make(map[string]string)}
+	err := filepath.Walk(staticDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(staticDir, p)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		m.Files[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// diff returns the differences of the given manifest from m, at most max of
+// them, sorted by path.
+func (m *integrityManifest) diff(got *integrityManifest, max int) []string {
+	var diffs []string
+	for p, want := range m.Files {
+		switch h, ok := got.Files[p]; {
+		case !ok:
+			diffs = append(diffs, p+": missing")
+		case h != want:
+			diffs = append(diffs, p+": modified")
+		}
+	}
+	for p := range got.Files {
+		if _, ok := m.Files[p]; !ok {
+			diffs = append(diffs, p+": unexpected")
+		}
+	}
+	sort.Strings(diffs)
+	if len(diffs) > max {
+		diffs = append(diffs[:max], fmt.Sprintf("and %d more", len(diffs)-max))
+	}
+	return diffs
+}
+
+// parsePublicKey parses a PEM-encoded ECDSA or RSA public key, as exported by
+// Cloud KMS.
+func parsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// verifySignature verifies the signature of the SHA-256 digest of b: an
+// ASN.1 ECDSA signature, or an RSA PKCS #1 v1.5 signature.
+func verifySignature(key crypto.PublicKey, b, sig []byte) error {
+	digest := sha256.Sum256(b)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		var s struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &s); err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+		if !ecdsa.Verify(key, digest[:], s.R, s.S) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	}
+	return fmt.Errorf("unsupported public key type %T", key)
+}
+
+// verifyStaticDir verifies the signature of the given integrity manifest with
+// the given public key, and that the static dir matches it.
+func verifyStaticDir(staticDir, manifestFile, signatureFile, keyFile string) error {
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := parsePublicKey(keyPEM)
+	if err != nil {
+		return fmt.Errorf("public key %s: %v", keyFile, err)
+	}
+	b, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return err
+	}
+	sig, err := ioutil.ReadFile(signatureFile)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(key, b, sig); err != nil {
+		return fmt.Errorf("manifest %s: %v", manifestFile, err)
+	}
+	var want integrityManifest
+	if err := json.Unmarshal(b, &want); err != nil {
+		return fmt.Errorf("manifest %s: %v", manifestFile, err)
+	}
+	got, err := hashStaticDir(staticDir)
+	if err != nil {
+		return err
+	}
+	if diffs := want.diff(got, 10); len(diffs) > 0 {
+		return fmt.Errorf("static dir %s doesn't match the manifest: %s", staticDir, strings.Join(diffs, ", "))
+	}
+	return nil
+}
+
+// checkStaticIntegrity verifies the static dir at startup. On a mismatch, the
+// server refuses to start, or if alertOnly is set logs the error and reports
+// it in the static_content_integrity metric.
+func checkStaticIntegrity(staticDir, manifestFile, signatureFile, keyFile string, alertOnly bool) {
+	if err := verifyStaticDir(staticDir, manifestFile, signatureFile, keyFile); err != nil {
+		staticIntegrity.set(0)
+		if !alertOnly {
+			log.Fatalf("Refusing to serve unverified static content: %v", err)
+		}
+		log.Printf("ALERT: serving unverified static content: %v", err)
+		return
+	}
+	staticIntegrity.set(1)
+	log.Printf("Verified static content against %s", manifestFile)
+}
+
+// writeIntegrityManifest writes the integrity manifest of the static dir, to
+// be signed by the build.
+func writeIntegrityManifest(staticDir, file string) error {
+	m, err := hashStaticDir(staticDir)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// runManifest runs the manifest subcommand with the given arguments, for
+// builds that don't use the build subcommand.
+func runManifest(args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ExitOnError)
+	static := fs.String("static", "public/static", "Static dir to hash.")
+	out := fs.String("out", "public/static-manifest.json", "Manifest file to write.")
+	fs.Parse(args)
+	return writeIntegrityManifest(*static, *out)
+}
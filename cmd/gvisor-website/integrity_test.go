@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntegrityManifestDiff(t *testing.T) {
+	want := &integrityManifest{Files: map[string]string{"a": "1", "b": "2", "c": "3"}}
+	got := &integrityManifest{Files: map[string]string{"a": "1", "b": "x", "d": "4"}}
+	if diffs, wantDiffs := want.diff(got, 10), []string{"b: modified", "c: missing", "d: unexpected"}; !reflect.DeepEqual(diffs, wantDiffs) {
+		t.Errorf("diff = %q, want %q", diffs, wantDiffs)
+	}
+	if diffs, wantDiffs := want.diff(got, 1), []string{"b: modified", "and 2 more"}; !reflect.DeepEqual(diffs, wantDiffs) {
+		t.Errorf("diff with max 1 = %q, want %q", diffs, wantDiffs)
+	}
+}
+
+func TestVerifyStaticDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "integrity-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	static := filepath.Join(dir, "static")
+	write := func(name, content string) {
+		p := filepath.Join(static, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	write("index.html", "<p>home</p>")
+	write("docs/index.html", "<p>docs</p>")
+	manifest := filepath.Join(dir, "static-manifest.json")
+	if err := writeIntegrityManifest(static, manifest); err != nil {
+		t.Fatalf("writeIntegrityManifest failed: %v", err)
+	}
+	b, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	digest := sha256.Sum256(b)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	ecSig, err := ecKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15 failed: %v", err)
+	}
+	writeKey := func(name string, pub interface{}) string {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+		}
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		return p
+	}
+	writeSig := func(name string, sig []byte) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, sig, 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		return p
+	}
+	ecPub, rsaPub := writeKey("ec.pem", &ecKey.PublicKey), writeKey("rsa.pem", &rsaKey.PublicKey)
+	ecSigFile, rsaSigFile := writeSig("ec.sig", ecSig), writeSig("rsa.sig", rsaSig)
+
+	for _, tc := range []struct {
+		name    string
+		key     string
+		sig     string
+		modify  func()
+		wantErr string
+	}{
+		{name: "ecdsa", key: ecPub, sig: ecSigFile},
+		{name: "rsa", key: rsaPub, sig: rsaSigFile},
+		{name: "wrong key", key: rsaPub, sig: ecSigFile, wantErr: "verification error"},
+		{name: "wrong signature", key: ecPub, sig: writeSig("bad.sig", []byte("bad")), wantErr: "invalid signature"},
+		{name: "modified", key: ecPub, sig: ecSigFile, modify: func() { write("docs/index.html", "<p>tampered</p>") }, wantErr: "docs/index.html: modified"},
+		{name: "unexpected", key: ecPub, sig: ecSigFile, modify: func() { write("evil.js", "alert(1)") }, wantErr: "evil.js: unexpected"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.modify != nil {
+				tc.modify()
+			}
+			err := verifyStaticDir(static, manifest, tc.sig, tc.key)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("verifyStaticDir failed: %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("verifyStaticDir returned error %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// siteURL returns the absolute URL of the given path.
+func siteURL(p string) string {
+	return "https://" + *customHost + p
+}
+
+var (
+	pageTitlesMu sync.Mutex
+	pageTitles   = make(map[string]string)
+)
+
+// pageTitle returns the title of the rendered page at the given URL path, or
+// "" if there is none. Titles are cached, since the static dir only changes on
+// deploy.
+func pageTitle(staticDir, urlPath string) string {
+	pageTitlesMu.Lock()
+	defer pageTitlesMu.Unlock()
+	if t, ok := pageTitles[urlPath]; ok {
+		return t
+	}
+	var title string
+	if f, err := http.Dir(staticDir).Open(path.Join(urlPath, "index.html")); err == nil {
+		if b, err := ioutil.ReadAll(f); err == nil {
+			if m := titleRE.FindSubmatch(b); m != nil {
+				title = strings.TrimSuffix(pageText(m[1]), " | gVisor")
+			}
+		}
+		f.Close()
+	}
+	pageTitles[urlPath] = title
+	return title
+}
+
+// breadcrumbs returns the schema.org BreadcrumbList for the given page, with
+// one item for each enclosing section.
+func breadcrumbs(staticDir, urlPath, title string) map[string]interface{} {
+	var items []interface{}
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	for i := range segments {
+		p := "/" + strings.Join(segments[:i+1], "/") + "/"
+		name := title
+		if i < len(segments)-1 {
+			name = pageTitle(staticDir, p)
+		}
+		if name == "" {
+			name = strings.Title(strings.Replace(segments[i], "_", " ", -1))
+		}
+		items = append(items, map[string]interface{}{
+			"@type":    "ListItem",
+			"position": i + 1,
+			"name":     name,
+			"item":     siteURL(p),
+		})
+	}
+	return map[string]interface{}{
+		"@type":           "BreadcrumbList",
+		"itemListElement": items,
+	}
+}
+
+// publisher is the schema.org publisher of all pages.
+var publisher = map[string]interface{}{
+	"@type": "Organization",
+	"name":  "gVisor",
+	"url":   "https://gvisor.dev/",
+	"logo": map[string]interface{}{
+		"@type": "ImageObject",
+		"url":   "https://gvisor.dev/favicons/apple-touch-icon-180x180.png",
+	},
+}
+
+// structuredData returns the schema.org entities describing the page at the
+// given URL path: the project itself for the home page, and an article with
+// its breadcrumbs for docs and blog pages.
+func structuredData(staticDir, urlPath, title string) []interface{} {
+	switch {
+	case urlPath == "/":
+		return []interface{}{map[string]interface{}{
+			"@context":            "https://schema.org",
+			"@type":               "SoftwareApplication",
+			"name":                "gVisor",
+			"url":                 siteURL("/"),
+			"description":         "gVisor is an application kernel, written in Go, that implements a substantial portion of the Linux system surface.",
+			"applicationCategory": "DeveloperApplication",
+			"operatingSystem":     "Linux",
+			"license":             "https://www.apache.org/licenses/LICENSE-2.0",
+			"sameAs":              "https://github.com/google/gvisor",
+			"offers": map[string]interface{}{
+				"@type":         "Offer",
+				"price":         "0",
+				"priceCurrency": "USD",
+			},
+		}}
+	case strings.HasPrefix(urlPath, "/docs/"), strings.HasPrefix(urlPath, "/blog/"):
+		bc := breadcrumbs(staticDir, urlPath, title)
+		bc["@context"] = "https://schema.org"
+		return []interface{}{
+			map[string]interface{}{
+				"@context":         "https://schema.org",
+				"@type":            "TechArticle",
+				"headline":         title,
+				"url":              siteURL(urlPath),
+				"mainEntityOfPage": siteURL(urlPath),
+				"publisher":        publisher,
+			},
+			bc,
+		}
+	}
+	return nil
+}
+
+// structuredDataScripts returns the JSON-LD scripts for the given page.
+func structuredDataScripts(staticDir, urlPath string) string {
+	var b strings.Builder
+	for _, entity := range structuredData(staticDir, urlPath, pageTitle(staticDir, urlPath)) {
+		j, err := json.Marshal(entity)
+		if err != nil {
+			continue
+		}
+		// json.Marshal escapes <, > and &, so the script can't be closed
+		// early by page content.
+		b.WriteString(`<script type="application/ld+json">`)
+		b.Write(j)
+		b.WriteString("</script>\n")
+	}
+	return b.String()
+}
+
+// structuredDataRule returns the rewrite rule injecting schema.org JSON-LD
+// into pages, so that search engines can show rich results without changes
+// to the Hugo theme for each page type.
+func structuredDataRule(staticDir string) *rewriteRule {
+	return &rewriteRule{
+		Name:   "structured-data",
+		Paths:  []string{"/", "/docs/*", "/blog/*"},
+		Anchor: "</head>",
+		Action: rewriteBefore,
+		render: func(r *http.Request) string {
+			return structuredDataScripts(staticDir, r.URL.Path)
+		},
+	}
+}
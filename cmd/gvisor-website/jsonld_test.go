@@ -0,0 +1,140 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jsonLDScripts returns the entities of the JSON-LD scripts in the HTML.
+func jsonLDScripts(t *testing.T, html string) []map[string]interface{} {
+	t.Helper()
+	var entities []map[string]interface{}
+	for _, s := range strings.Split(html, `<script type="application/ld+json">`)[1:] {
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.SplitN(s, "</script>", 2)[0]), &e); err != nil {
+			t.Fatalf("invalid JSON-LD %q: %v", s, err)
+		}
+		entities = append(entities, e)
+	}
+	return entities
+}
+
+// breadcrumbNames returns the names of the items of the breadcrumb list.
+func breadcrumbNames(bc map[string]interface{}) []string {
+	var names []string
+	items, _ := bc["itemListElement"].([]interface{})
+	for _, item := range items {
+		names = append(names, item.(map[string]interface{})["name"].(string))
+	}
+	return names
+}
+
+func TestStructuredData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonld-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"docs/index.html":                            `<title>Documentation | gVisor</title>`,
+		"docs/user_guide/quick_start/index.html":     `<title>Quick Start &amp; Setup | gVisor</title>`,
+		"blog/index.html":                            `<title>Blog | gVisor</title>`,
+		"blog/2019/11/18/security-basics/index.html": `<title>gVisor Security Basics - Part 1 | gVisor</title>`,
+	})
+	defer func(titles map[string]string) { pageTitles = titles }(pageTitles)
+	pageTitles = make(map[string]string)
+
+	for _, tc := range []struct {
+		path        string
+		headline    string
+		breadcrumbs []string
+	}{
+		{
+			path:        "/docs/user_guide/quick_start/",
+			headline:    "Quick Start & Setup",
+			breadcrumbs: []string{"Documentation", "User Guide", "Quick Start & Setup"},
+		},
+		{
+			path:        "/blog/2019/11/18/security-basics/",
+			headline:    "gVisor Security Basics - Part 1",
+			breadcrumbs: []string{"Blog", "2019", "11", "18", "gVisor Security Basics - Part 1"},
+		},
+	} {
+		entities := jsonLDScripts(t, structuredDataScripts(dir, tc.path))
+		if len(entities) != 2 {
+			t.Fatalf("%s: got %d entities, want an article and its breadcrumbs", tc.path, len(entities))
+		}
+		article, bc := entities[0], entities[1]
+		for key, want := range map[string]interface{}{
+			"@context":         "https://schema.org",
+			"@type":            "TechArticle",
+			"headline":         tc.headline,
+			"url":              "https://gvisor.dev" + tc.path,
+			"mainEntityOfPage": "https://gvisor.dev" + tc.path,
+		} {
+			if article[key] != want {
+				t.Errorf("%s: got article %s %v, want %v", tc.path, key, article[key], want)
+			}
+		}
+		if p, _ := article["publisher"].(map[string]interface{}); p["name"] != "gVisor" {
+			t.Errorf("%s: got publisher %v, want gVisor", tc.path, article["publisher"])
+		}
+		if bc["@type"] != "BreadcrumbList" || bc["@context"] != "https://schema.org" {
+			t.Errorf("%s: got %v, want a breadcrumb list", tc.path, bc)
+		}
+		if names := breadcrumbNames(bc); !reflect.DeepEqual(names, tc.breadcrumbs) {
+			t.Errorf("%s: got breadcrumbs %q, want %q", tc.path, names, tc.breadcrumbs)
+		}
+		items := bc["itemListElement"].([]interface{})
+		if last := items[len(items)-1].(map[string]interface{}); last["item"] != "https://gvisor.dev"+tc.path || last["position"] != float64(len(items)) {
+			t.Errorf("%s: got last breadcrumb %v, want the page", tc.path, last)
+		}
+	}
+
+	// The home page describes the project, and other pages nothing.
+	if entities := jsonLDScripts(t, structuredDataScripts(dir, "/")); len(entities) != 1 || entities[0]["@type"] != "SoftwareApplication" {
+		t.Errorf("got home page entities %v, want the project", entities)
+	}
+	if s := structuredDataScripts(dir, "/community/"); s != "" {
+		t.Errorf("got community page scripts %q, want none", s)
+	}
+}
+
+func TestStructuredDataEscaping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonld-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"docs/index.html": `<title>&lt;/script&gt;&lt;script&gt;alert(1)</title>`,
+	})
+	defer func(titles map[string]string) { pageTitles = titles }(pageTitles)
+	pageTitles = make(map[string]string)
+
+	s := structuredDataScripts(dir, "/docs/")
+	if strings.Count(s, "</script>") != 2 || strings.Contains(s, "<script>") {
+		t.Errorf("got scripts %q, want the title escaped", s)
+	}
+	if entities := jsonLDScripts(t, s); entities[0]["headline"] != "</script><script>alert(1)" {
+		t.Errorf("got headline %q, want the title", entities[0]["headline"])
+	}
+}
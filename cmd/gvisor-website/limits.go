@@ -0,0 +1,126 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parseLimits parses a comma-separated list of route=limit pairs.
+func parseLimits(spec string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid limit %q: want route=limit", part)
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q: want a positive integer", part)
+		}
+		limits[kv[0]] = n
+	}
+	return limits, nil
+}
+
+var requestsShed = newCounter("http_requests_shed_total", "Requests rejected to protect the server, by route and reason.", "route", "reason")
+
+var (
+	// concurrencyLimits are the per-route concurrency limits, set from
+	// flags at startup.
+	concurrencyLimits map[string]int
+
+	semaphoresMu sync.Mutex
+	semaphores   = make(map[string]chan struct{})
+)
+
+// routeSemaphore returns the semaphore for the given route, or nil if the
+// route is not limited. All handlers registered under the same route name
+// share a semaphore.
+func routeSemaphore(route string) chan struct{} {
+	n, ok := concurrencyLimits[route]
+	if !ok {
+		return nil
+	}
+	semaphoresMu.Lock()
+	defer semaphoresMu.Unlock()
+	sem, ok := semaphores[route]
+	if !ok {
+		sem = make(chan struct{}, n)
+		semaphores[route] = sem
+	}
+	return sem
+}
+
+// concurrencyLimitHandler rejects requests with 503 Service Unavailable when
+// the route already has its limit of requests in flight, so that a burst of
+// expensive requests can't starve cheap ones.
+func concurrencyLimitHandler(route string, h http.Handler) http.Handler {
+	sem := routeSemaphore(route)
+	if sem == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			h.ServeHTTP(w, r)
+		default:
+			requestsShed.inc(route, "concurrency")
+			w.Header().Set("Retry-After", "5")
+			httpError(w, r, "Server busy, try again later", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// bodyLimits are the per-route request body size limits, set from flags at
+// startup. Other routes are limited to --max-body-bytes.
+var bodyLimits map[string]int
+
+// requestSizeHandler rejects requests with URIs longer than --max-uri-length
+// with 414 URI Too Long, and bodies larger than the route's limit with 413
+// Payload Too Large, before they reach handlers or upstreams. Bodies of
+// unknown length are cut off at the limit.
+func requestSizeHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *maxURILength > 0 && len(r.URL.RequestURI()) > *maxURILength {
+			requestsShed.inc(route, "uri-length")
+			httpError(w, r, "URI too long", http.StatusRequestURITooLong)
+			return
+		}
+		limit, ok := bodyLimits[route]
+		if !ok {
+			limit = *maxBodyBytes
+		}
+		if limit > 0 && r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > int64(limit) {
+				requestsShed.inc(route, "body-size")
+				w.Header().Set("Connection", "close")
+				httpError(w, r, "Payload too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, int64(limit))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
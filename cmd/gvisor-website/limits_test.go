@@ -0,0 +1,118 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestSizeHandler(t *testing.T) {
+	defer func(uri, body int, limits map[string]int) {
+		*maxURILength, *maxBodyBytes, bodyLimits = uri, body, limits
+	}(*maxURILength, *maxBodyBytes, bodyLimits)
+	*maxURILength, *maxBodyBytes = 64, 32
+	bodyLimits = map[string]int{"beacon": 8}
+
+	h := func(route string) http.Handler {
+		return requestSizeHandler(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := ioutil.ReadAll(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		}))
+	}
+	for _, tc := range []struct {
+		name     string
+		route    string
+		url      string
+		body     io.Reader
+		chunked  bool
+		wantCode int
+	}{
+		{name: "short uri", route: "search", url: "/api/search?q=gofer", wantCode: http.StatusOK},
+		{name: "long query", route: "search", url: "/api/search?q=" + strings.Repeat("a", 64), wantCode: http.StatusRequestURITooLong},
+		{name: "long path", route: "static", url: "/" + strings.Repeat("a", 64), wantCode: http.StatusRequestURITooLong},
+		{name: "small body", route: "feedback", url: "/api/feedback", body: strings.NewReader(strings.Repeat("a", 32)), wantCode: http.StatusOK},
+		{name: "large body", route: "feedback", url: "/api/feedback", body: strings.NewReader(strings.Repeat("a", 33)), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "route limit", route: "beacon", url: "/api/beacon", body: strings.NewReader(strings.Repeat("a", 9)), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "chunked body", route: "feedback", url: "/api/feedback", body: strings.NewReader(strings.Repeat("a", 33)), chunked: true, wantCode: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			method := "GET"
+			if tc.body != nil {
+				method = "POST"
+			}
+			r := httptest.NewRequest(method, tc.url, tc.body)
+			if tc.chunked {
+				r.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h(tc.route).ServeHTTP(rec, r)
+			if rec.Code != tc.wantCode {
+				t.Errorf("got status %d, want %d", rec.Code, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitHandler(t *testing.T) {
+	defer func(l map[string]int) { concurrencyLimits = l }(concurrencyLimits)
+	limits, err := parseLimits(*concurrencyLimitSpec)
+	if err != nil {
+		t.Fatalf("parseLimits(%q) failed: %v", *concurrencyLimitSpec, err)
+	}
+	concurrencyLimits = limits
+	defer func() {
+		semaphoresMu.Lock()
+		semaphores = make(map[string]chan struct{})
+		semaphoresMu.Unlock()
+	}()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := concurrencyLimitHandler("publish", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/publish", nil))
+		close(done)
+	}()
+	<-started
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	// A slow publish sheds other publishes, but not the rebuild.
+	for _, tc := range []struct {
+		route string
+		want  int
+	}{
+		{"publish", http.StatusServiceUnavailable},
+		{"rebuild", http.StatusOK},
+		{"g3doc-sync", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		concurrencyLimitHandler(tc.route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+		if w.Code != tc.want {
+			t.Errorf("%s: got status %d while a publish is in flight, want %d", tc.route, w.Code, tc.want)
+		}
+	}
+}
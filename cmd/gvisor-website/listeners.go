@@ -0,0 +1,307 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listenerConfig is an address to listen on, with its server settings.
+type listenerConfig struct {
+	// network is tcp, or tcp4 or tcp6 to listen on only IPv4 or IPv6. An
+	// IPv6 wildcard address with tcp6 doesn't accept IPv4 connections, so
+	// that a separate tcp4 listener can share the port. quic serves
+	// HTTP/3 on a UDP port, which needs a server built with the http3 tag.
+	network string
+	addr    string
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	// certFile and keyFile are the PEM certificate and key quic
+	// listeners terminate TLS with.
+	certFile string
+	keyFile  string
+
+	// optional listeners that fail to listen are reported rather than
+	// stopping the server, e.g. IPv6 on hosts without it.
+	optional bool
+}
+
+// parseListeners parses a comma-separated list of listener URLs, e.g.
+// "tcp4://0.0.0.0:8080,tcp6://[::]:8080?optional=true&idle_timeout=2m".
+// Query parameters set read_timeout, write_timeout, idle_timeout and
+// optional, and cert and key for quic listeners, which don't take read or
+// write timeouts. An empty spec listens on the given default address.
+func parseListeners(spec, defaultAddr string) ([]listenerConfig, error) {
+	if strings.TrimSpace(spec) == "" {
+		return []listenerConfig{{network: "tcp", addr: defaultAddr}}, nil
+	}
+	var configs []listenerConfig
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		u, err := url.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listener %q: %v", part, err)
+		}
+		switch u.Scheme {
+		case "tcp", "tcp4", "tcp6", "quic":
+		default:
+			return nil, fmt.Errorf("invalid listener %q: network must be tcp, tcp4, tcp6 or quic", part)
+		}
+		if u.Host == "" || u.Port() == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("invalid listener %q: want network://host:port", part)
+		}
+		c := listenerConfig{network: u.Scheme, addr: u.Host}
+		for k, v := range u.Query() {
+			var err error
+			switch k {
+			case "read_timeout":
+				c.readTimeout, err = time.ParseDuration(v[0])
+			case "write_timeout":
+				c.writeTimeout, err = time.ParseDuration(v[0])
+			case "idle_timeout":
+				c.idleTimeout, err = time.ParseDuration(v[0])
+			case "optional":
+				c.optional, err = strconv.ParseBool(v[0])
+			case "cert":
+				c.certFile = v[0]
+			case "key":
+				c.keyFile = v[0]
+			default:
+				err = fmt.Errorf("unknown setting")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid listener %q: %s: %v", part, k, err)
+			}
+		}
+		if c.network == "quic" {
+			if c.certFile == "" || c.keyFile == "" {
+				return nil, fmt.Errorf("invalid listener %q: quic needs a cert and key", part)
+			}
+			if c.readTimeout != 0 || c.writeTimeout != 0 {
+				return nil, fmt.Errorf("invalid listener %q: quic has no read or write timeouts", part)
+			}
+		} else if c.certFile != "" || c.keyFile != "" {
+			return nil, fmt.Errorf("invalid listener %q: only quic takes a cert and key", part)
+		}
+		configs = append(configs, c)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no listeners")
+	}
+	return configs, nil
+}
+
+// listenerStatus is the state of a listener, served by /api/version.
+type listenerStatus struct {
+	Network  string `json:"network"`
+	Address  string `json:"address"`
+	Optional bool   `json:"optional,omitempty"`
+
+	Listening bool   `json:"listening"`
+	Error     string `json:"error,omitempty"`
+}
+
+var (
+	listenersMu sync.Mutex
+
+	// listeners is the state of the listeners, set by main.
+	listeners []listenerStatus
+)
+
+// setListenerStatus records the state of the listener at index i.
+func setListenerStatus(i int, s listenerStatus) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	listeners[i] = s
+}
+
+// openListener is an open listener: a TCP listener, or the UDP socket and
+// TLS config of a quic listener.
+type openListener struct {
+	stream    net.Listener
+	packets   net.PacketConn
+	tlsConfig *tls.Config
+}
+
+// Addr returns the address the listener is open on.
+func (l *openListener) Addr() net.Addr {
+	if l.packets != nil {
+		return l.packets.LocalAddr()
+	}
+	return l.stream.Addr()
+}
+
+// Close closes the listener.
+func (l *openListener) Close() error {
+	if l.packets != nil {
+		return l.packets.Close()
+	}
+	return l.stream.Close()
+}
+
+// open opens the listener of c.
+func open(c listenerConfig) (*openListener, error) {
+	if c.network == "quic" {
+		return listenQUIC(c)
+	}
+	l, err := net.Listen(c.network, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &openListener{stream: l}, nil
+}
+
+// listen opens the configured listeners. Failing to open an optional
+// listener is logged and reported, failing to open another is an error.
+func listen(configs []listenerConfig) ([]*openListener, error) {
+	listenersMu.Lock()
+	listeners = make([]listenerStatus, len(configs))
+	listenersMu.Unlock()
+	ls := make([]*openListener, len(configs))
+	for i, c := range configs {
+		s := listenerStatus{Network: c.network, Address: c.addr, Optional: c.optional}
+		l, err := open(c)
+		if err != nil {
+			s.Error = err.Error()
+			setListenerStatus(i, s)
+			if !c.optional {
+				for _, l := range ls {
+					if l != nil {
+						l.Close()
+					}
+				}
+				return nil, err
+			}
+			log.Printf("Not listening on optional %s %s: %v", c.network, c.addr, err)
+			continue
+		}
+		s.Address, s.Listening = l.Addr().String(), true
+		setListenerStatus(i, s)
+		ls[i] = l
+	}
+	return ls, nil
+}
+
+// serve serves the handler on the open listeners, each with its settings,
+// until one of them fails. Responses on TCP listeners advertise the quic
+// listeners that are up.
+func serve(configs []listenerConfig, ls []*openListener, h http.Handler) error {
+	errc := make(chan error, len(ls))
+	for i, l := range ls {
+		if l == nil {
+			continue
+		}
+		i, l := i, l
+		log.Printf("Listening on %s %s...", configs[i].network, l.Addr())
+		go func() {
+			var err error
+			if l.packets != nil {
+				err = serveQUIC(configs[i], l, h)
+			} else {
+				srv := &http.Server{
+					Handler:      altSvcHandler(h),
+					ReadTimeout:  configs[i].readTimeout,
+					WriteTimeout: configs[i].writeTimeout,
+					IdleTimeout:  configs[i].idleTimeout,
+				}
+				err = srv.Serve(l.stream)
+			}
+			listenersMu.Lock()
+			listeners[i].Listening, listeners[i].Error = false, err.Error()
+			listenersMu.Unlock()
+			errc <- fmt.Errorf("%s %s: %v", configs[i].network, l.Addr(), err)
+		}()
+	}
+	return <-errc
+}
+
+// altSvcHandler advertises HTTP/3 on the quic listeners that are up, so
+// that clients only try it while it is served.
+func altSvcHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var services []string
+		listenersMu.Lock()
+		for _, s := range listeners {
+			if s.Network != "quic" || !s.Listening {
+				continue
+			}
+			if _, port, err := net.SplitHostPort(s.Address); err == nil {
+				services = append(services, fmt.Sprintf(`h3=":%s"; ma=86400`, port))
+			}
+		}
+		listenersMu.Unlock()
+		if len(services) > 0 {
+			w.Header().Set("Alt-Svc", strings.Join(services, ", "))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// versionResponse is served by /api/version.
+type versionResponse struct {
+	// Version is the App Engine version of this instance.
+	Version        string           `json:"version,omitempty"`
+	Profile        string           `json:"profile"`
+	ContentVersion string           `json:"content_version,omitempty"`
+	GoVersion      string           `json:"go_version"`
+	Listeners      []listenerStatus `json:"listeners"`
+}
+
+// versionHandler serves what is running on this instance and how it is
+// listening.
+func versionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := versionResponse{
+			Version:   os.Getenv("GAE_VERSION"),
+			Profile:   deployProfile.name,
+			GoVersion: runtime.Version(),
+		}
+		if readiness != nil {
+			_, res.ContentVersion, _ = readiness.check(time.Now())
+		}
+		listenersMu.Lock()
+		res.Listeners = append([]listenerStatus{}, listeners...)
+		listenersMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+// registerVersion registers the version endpoint.
+func registerVersion(mux *http.ServeMux) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle("/api/version", baseChain("health").then(versionHandler()))
+}
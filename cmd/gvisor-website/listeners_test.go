@@ -0,0 +1,189 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseListeners(t *testing.T) {
+	configs, err := parseListeners("", ":8080")
+	if err != nil {
+		t.Fatalf("parseListeners failed: %v", err)
+	}
+	if want := []listenerConfig{{network: "tcp", addr: ":8080"}}; !reflect.DeepEqual(configs, want) {
+		t.Errorf("parseListeners(\"\") = %+v, want %+v", configs, want)
+	}
+
+	configs, err = parseListeners("tcp4://0.0.0.0:8080, tcp6://[::]:8080?optional=true&idle_timeout=2m&read_timeout=30s&write_timeout=1m", ":8080")
+	if err != nil {
+		t.Fatalf("parseListeners failed: %v", err)
+	}
+	want := []listenerConfig{
+		{network: "tcp4", addr: "0.0.0.0:8080"},
+		{network: "tcp6", addr: "[::]:8080", readTimeout: 30 * time.Second, writeTimeout: time.Minute, idleTimeout: 2 * time.Minute, optional: true},
+	}
+	if !reflect.DeepEqual(configs, want) {
+		t.Errorf("parseListeners = %+v, want %+v", configs, want)
+	}
+
+	configs, err = parseListeners("quic://:8443?cert=cert.pem&key=key.pem&idle_timeout=1m&optional=true", ":8080")
+	if err != nil {
+		t.Fatalf("parseListeners failed: %v", err)
+	}
+	if want := []listenerConfig{{network: "quic", addr: ":8443", idleTimeout: time.Minute, optional: true, certFile: "cert.pem", keyFile: "key.pem"}}; !reflect.DeepEqual(configs, want) {
+		t.Errorf("parseListeners(quic) = %+v, want %+v", configs, want)
+	}
+
+	for _, spec := range []string{
+		"udp://:8080",
+		"tcp://:8080/path",
+		"tcp://localhost",
+		"tcp4://:8080?idle_timeout=x",
+		"tcp4://:8080?backlog=10",
+		"tcp4://:8080?optional=maybe",
+		"tcp4://:8080?read_timeout=",
+		"tcp://:",
+		"tcp6://[::1",
+		"tcp4://0.0.0.0:8080,unix:///tmp/gvisor.sock",
+		"quic://:8443",
+		"quic://:8443?cert=cert.pem",
+		"quic://:8443?cert=cert.pem&key=key.pem&read_timeout=30s",
+		"tcp://:8080?cert=cert.pem&key=key.pem",
+		",",
+		" , ",
+	} {
+		if _, err := parseListeners(spec, ":8080"); err == nil {
+			t.Errorf("parseListeners(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	defer func(l []listenerStatus) { listeners = l }(listeners)
+
+	ls, err := listen([]listenerConfig{
+		{network: "tcp4", addr: "127.0.0.1:0"},
+		{network: "tcp4", addr: "256.0.0.1:0", optional: true},
+	})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ls[0].Close()
+	if ls[1] != nil {
+		t.Errorf("invalid optional address is listening")
+	}
+
+	rec := httptest.NewRecorder()
+	versionHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/version", nil))
+	var res versionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if len(res.Listeners) != 2 {
+		t.Fatalf("listeners = %+v, want 2", res.Listeners)
+	}
+	if s := res.Listeners[0]; !s.Listening || s.Address != ls[0].Addr().String() {
+		t.Errorf("listener 0 = %+v, want listening on %s", s, ls[0].Addr())
+	}
+	if s := res.Listeners[1]; s.Listening || s.Error == "" || !s.Optional {
+		t.Errorf("listener 1 = %+v, want failed optional listener", s)
+	}
+
+	if _, err := listen([]listenerConfig{{network: "tcp4", addr: "256.0.0.1:0"}}); err == nil {
+		t.Errorf("listen on an invalid required address succeeded")
+	}
+}
+
+func TestAltSvcHandler(t *testing.T) {
+	defer func(l []listenerStatus) { listeners = l }(listeners)
+	h := altSvcHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/docs/", nil))
+		return rec.Header().Get("Alt-Svc")
+	}
+
+	listeners = []listenerStatus{{Network: "tcp", Address: "[::]:443", Listening: true}}
+	if got := get(); got != "" {
+		t.Errorf("without quic listeners: got Alt-Svc %q, want none", got)
+	}
+	listeners = append(listeners,
+		listenerStatus{Network: "quic", Address: "[::]:443", Listening: true},
+		listenerStatus{Network: "quic", Address: "0.0.0.0:8443", Optional: true, Error: "HTTP/3 support is not built in"},
+	)
+	if got, want := get(), `h3=":443"; ma=86400`; got != want {
+		t.Errorf("with a quic listener: got Alt-Svc %q, want %q", got, want)
+	}
+
+	// HTTP/3 stops being advertised when its listener fails.
+	setListenerStatus(1, listenerStatus{Network: "quic", Address: "[::]:443", Error: "use of closed network connection"})
+	if got := get(); got != "" {
+		t.Errorf("after the quic listener failed: got Alt-Svc %q, want none", got)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	defer func(l []listenerStatus, r *contentReadiness, p profile, v string) {
+		listeners, readiness, deployProfile = l, r, p
+		os.Setenv("GAE_VERSION", v)
+	}(listeners, readiness, deployProfile, os.Getenv("GAE_VERSION"))
+	status := []listenerStatus{
+		{Network: "tcp4", Address: "0.0.0.0:8080", Listening: true},
+		{Network: "tcp6", Address: "[::]:8080", Optional: true, Error: "address family not supported"},
+	}
+	listeners = status
+	deployProfile = profiles["dev"]
+	os.Setenv("GAE_VERSION", "20191002t100000")
+
+	get := func() versionResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		versionHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/version", nil))
+		if rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("Cache-Control") != "no-cache" {
+			t.Errorf("got Content-Type %q and Cache-Control %q, want uncached JSON", rec.Header().Get("Content-Type"), rec.Header().Get("Cache-Control"))
+		}
+		var res versionResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		return res
+	}
+
+	// Before main has loaded the content version, none is reported.
+	readiness = nil
+	want := versionResponse{Version: "20191002t100000", Profile: "dev", GoVersion: runtime.Version(), Listeners: status}
+	if res := get(); !reflect.DeepEqual(res, want) {
+		t.Errorf("got %+v, want %+v", res, want)
+	}
+	readiness = &contentReadiness{ready: true, version: "0123456789abcdef"}
+	want.ContentVersion = "0123456789abcdef"
+	if res := get(); !reflect.DeepEqual(res, want) {
+		t.Errorf("got %+v, want %+v", res, want)
+	}
+
+	// Listeners failing while serving are reported.
+	setListenerStatus(0, listenerStatus{Network: "tcp4", Address: "0.0.0.0:8080", Error: "use of closed network connection"})
+	if s := get().Listeners[0]; s.Listening || s.Error == "" {
+		t.Errorf("got listener %+v after it failed, want it not listening", s)
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// loadRequest is a request sent by the load test.
+type loadRequest struct {
+	method string
+	uri    string
+
+	// route is the route that served the request, by which results are
+	// reported.
+	route string
+}
+
+// accessLogRE matches the request of an access log line written by
+// loggingHandler, and the sample rate of sampled lines.
+var accessLogRE = regexp.MustCompile(`\s(GET|HEAD) (/\S*) route=(\S+)(?:.* sample=([0-9.e-]+))?`)
+
+// parseAccessLog returns the requests in an access log, in order. Lines that
+// were sampled at a rate are repeated to restore the traffic mix. Only GET and
+// HEAD requests are replayed; other lines are ignored.
+func parseAccessLog(r io.Reader) ([]loadRequest, error) {
+	var reqs []loadRequest
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		m := accessLogRE.FindStringSubmatch(s.Text())
+		if m == nil {
+			continue
+		}
+		n := 1
+		if m[4] != "" {
+			if rate, err := strconv.ParseFloat(m[4], 64); err == nil && rate > 0 && rate < 1 {
+				n = int(math.Round(1 / rate))
+			}
+		}
+		for i := 0; i < n; i++ {
+			reqs = append(reqs, loadRequest{method: m[1], uri: m[2], route: m[3]})
+		}
+	}
+	return reqs, s.Err()
+}
+
+// syntheticMix is the traffic replayed when no access log is given, by
+// weight: static pages and assets, go-get requests and the git ref listings,
+// which do a handshake with the upstream repository.
+var syntheticMix = []struct {
+	weight int
+	req    loadRequest
+}{
+	{20, loadRequest{"GET", "/", "static"}},
+	{20, loadRequest{"GET", "/docs/", "static"}},
+	{10, loadRequest{"GET", "/docs/user_guide/install/", "static"}},
+	{10, loadRequest{"GET", "/docs/user_guide/compatibility/linux/amd64/", "static"}},
+	{10, loadRequest{"GET", "/favicons/favicon.ico", "static"}},
+	{10, loadRequest{"GET", "/runsc?go-get=1", "go-get"}},
+	{5, loadRequest{"GET", "/pkg/sentry/kernel?go-get=1", "go-get"}},
+	{5, loadRequest{"GET", "/gvisor?go-get=1", "go-get"}},
+	{5, loadRequest{"GET", "/api/git/tags", "git-refs"}},
+	{5, loadRequest{"GET", "/api/git/branches", "git-refs"}},
+}
+
+// syntheticLoad returns n requests drawn from the synthetic mix.
+func syntheticLoad(n int, seed int64) []loadRequest {
+	total := 0
+	for _, m := range syntheticMix {
+		total += m.weight
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	reqs := make([]loadRequest, n)
+	for i := range reqs {
+		w := rnd.Intn(total)
+		for _, m := range syntheticMix {
+			if w < m.weight {
+				reqs[i] = m.req
+				break
+			}
+			w -= m.weight
+		}
+	}
+	return reqs
+}
+
+// loadResult is the result of a request of the load test.
+type loadResult struct {
+	route   string
+	status  int
+	latency time.Duration
+	err     error
+}
+
+// runLoad sends the requests to the target with the given number of requests
+// in flight, and returns their results in order. If the context is done,
+// the requests not yet sent are dropped. Redirects are not followed, so that
+// load isn't sent to the sites redirected to.
+func runLoad(ctx context.Context, client *http.Client, target string, reqs []loadRequest, concurrency int) []loadResult {
+	results := make([]loadResult, len(reqs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = sendLoadRequest(ctx, client, target, reqs[i])
+			}
+		}()
+	}
+	sent := 0
+loop:
+	for ; sent < len(reqs) && ctx.Err() == nil; sent++ {
+		select {
+		case next <- sent:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(next)
+	wg.Wait()
+	return results[:sent]
+}
+
+// sendLoadRequest sends a request and reads its response.
+func sendLoadRequest(ctx context.Context, client *http.Client, target string, lr loadRequest) loadResult {
+	res := loadResult{route: lr.route}
+	if err := ctx.Err(); err != nil {
+		res.err = err
+		return res
+	}
+	req, err := http.NewRequest(lr.method, strings.TrimSuffix(target, "/")+lr.uri, nil)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	req.Header.Set("User-Agent", "gvisor-website-loadtest")
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		res.status = resp.StatusCode
+	}
+	res.latency = time.Since(start)
+	res.err = err
+	return res
+}
+
+// percentile returns the q-th quantile of the sorted latencies.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(q*float64(len(sorted)-1))]
+}
+
+// writeLoadReport writes the request counts, errors and latencies per route,
+// and in total. Errors are failed requests and server errors.
+func writeLoadReport(w io.Writer, results []loadResult, elapsed time.Duration) {
+	byRoute := map[string][]loadResult{}
+	var routes []string
+	for _, r := range results {
+		if _, ok := byRoute[r.route]; !ok {
+			routes = append(routes, r.route)
+		}
+		byRoute[r.route] = append(byRoute[r.route], r)
+	}
+	sort.Strings(routes)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "route\trequests\terrors\tp50\tp90\tp99\tmax\t")
+	row := func(name string, rs []loadResult) {
+		var latencies []time.Duration
+		errors := 0
+		for _, r := range rs {
+			if r.err != nil || r.status >= 500 {
+				errors++
+			}
+			if r.err == nil {
+				latencies = append(latencies, r.latency)
+			}
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%v\t%v\t%v\t\n", name, len(rs), errors,
+			percentile(latencies, 0.5).Round(time.Microsecond), percentile(latencies, 0.9).Round(time.Microsecond),
+			percentile(latencies, 0.99).Round(time.Microsecond), percentile(latencies, 1).Round(time.Microsecond))
+	}
+	for _, route := range routes {
+		row(route, byRoute[route])
+	}
+	row("total", results)
+	tw.Flush()
+	fmt.Fprintf(w, "%d requests in %v, %.1f requests/s\n", len(results), elapsed.Round(time.Millisecond), float64(len(results))/elapsed.Seconds())
+}
+
+// runLoadTest runs the loadtest subcommand with the given arguments. It
+// replays an access log, or a synthetic mix of requests, against a target
+// instance and reports the latencies per route, for validating capacity
+// changes before they are deployed.
+func runLoadTest(args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := fs.String("target", "", "Base URL of the instance to load, e.g. https://staging-foo-dot-gvisor-website.appspot.com.")
+	logFile := fs.String("log", "", "Access log to replay, as written with --access-log; if empty, a synthetic mix of static, go-get and git ref requests is sent.")
+	n := fs.Int("requests", 1000, "Requests to send; the access log is repeated or truncated to this many. 0 replays the access log once.")
+	concurrency := fs.Int("concurrency", 8, "Requests in flight.")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of each request.")
+	duration := fs.Duration("duration", 0, "Stop after this long, if not done; 0 for no limit.")
+	seed := fs.Int64("seed", 1, "Seed of the synthetic mix.")
+	fs.Parse(args)
+	if *target == "" {
+		return fmt.Errorf("-target is required")
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("-concurrency must be positive")
+	}
+
+	var reqs []loadRequest
+	if *logFile != "" {
+		f, err := os.Open(*logFile)
+		if err != nil {
+			return err
+		}
+		reqs, err = parseAccessLog(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %v", *logFile, err)
+		}
+		if len(reqs) == 0 {
+			return fmt.Errorf("%s has no GET or HEAD requests", *logFile)
+		}
+		if *n > 0 {
+			all := reqs
+			reqs = make([]loadRequest, *n)
+			for i := range reqs {
+				reqs[i] = all[i%len(all)]
+			}
+		}
+	} else {
+		if *n <= 0 {
+			return fmt.Errorf("-requests must be positive without -log")
+		}
+		reqs = syntheticLoad(*n, *seed)
+	}
+
+	ctx := context.Background()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	client := &http.Client{
+		Timeout:       *timeout,
+		Transport:     &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: *concurrency},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	start := time.Now()
+	results := runLoad(ctx, client, *target, reqs, *concurrency)
+	writeLoadReport(os.Stdout, results, time.Since(start))
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseAccessLog(t *testing.T) {
+	log := `2019/08/06 10:00:00 10.0.0.1:1234 GET /docs/?q=1 route=static class=browser status=200 size=100 latency=1ms sample=0.25
+2019/08/06 10:00:01 10.0.0.2:1234 POST /api/feedback route=feedback class=browser status=200 size=2 latency=3ms
+2019/08/06 10:00:02 10.0.0.3:1234 HEAD /runsc?go-get=1 route=go-get class=tool status=200 size=0 latency=1ms
+2019/08/06 10:00:03 Error refreshing info/refs: timeout
+2019/08/06 10:00:04 10.0.0.4:1234 GET /api/git/tags route=git-refs class=tool status=200 size=10 latency=20ms request_id=abc user_agent="Go-http-client/1.1" referer=""
+`
+	got, err := parseAccessLog(strings.NewReader(log))
+	if err != nil {
+		t.Fatalf("parseAccessLog failed: %v", err)
+	}
+	docs := loadRequest{"GET", "/docs/?q=1", "static"}
+	want := []loadRequest{docs, docs, docs, docs, {"HEAD", "/runsc?go-get=1", "go-get"}, {"GET", "/api/git/tags", "git-refs"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAccessLog = %+v, want %+v", got, want)
+	}
+}
+
+func TestSyntheticLoad(t *testing.T) {
+	reqs := syntheticLoad(1000, 1)
+	routes := map[string]int{}
+	for _, r := range reqs {
+		routes[r.route]++
+	}
+	for _, route := range []string{"static", "go-get", "git-refs"} {
+		if routes[route] == 0 {
+			t.Errorf("synthetic load has no %s requests", route)
+		}
+	}
+	if !reflect.DeepEqual(reqs, syntheticLoad(1000, 1)) {
+		t.Errorf("synthetic load differs with the same seed")
+	}
+}
+
+func TestRunLoad(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight, requests := 0, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		requests++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/redirect":
+			http.Redirect(w, r, "https://github.com/google/gvisor", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	var reqs []loadRequest
+	for i := 0; i < 20; i++ {
+		reqs = append(reqs, loadRequest{"GET", "/", "static"}, loadRequest{"GET", "/fail", "api"}, loadRequest{"GET", "/redirect", "redirect"})
+	}
+	results := runLoad(context.Background(), client, srv.URL+"/", reqs, 3)
+	if len(results) != len(reqs) || requests != len(reqs) {
+		t.Fatalf("got %d results of %d requests, want %d", len(results), requests, len(reqs))
+	}
+	if maxInFlight > 3 {
+		t.Errorf("%d requests in flight, want at most 3", maxInFlight)
+	}
+	for i, r := range results {
+		want := map[string]int{"static": 200, "api": 503, "redirect": 302}[reqs[i].route]
+		if r.err != nil || r.status != want || r.route != reqs[i].route {
+			t.Errorf("result %d = %+v, want status %d", i, r, want)
+		}
+	}
+
+	var b bytes.Buffer
+	writeLoadReport(&b, results, time.Second)
+	for _, want := range []string{"route", "static", "total", "60 requests in 1s, 60.0 requests/s"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("report %q does not contain %q", b.String(), want)
+		}
+	}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if f := strings.Fields(line); len(f) > 2 && f[0] == "api" && (f[1] != "20" || f[2] != "20") {
+			t.Errorf("report line %q, want 20 requests and 20 errors", line)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if results := runLoad(ctx, client, srv.URL, reqs, 3); len(results) != 0 {
+		t.Errorf("got %d results after the context was done, want none", len(results))
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxVerbosityDuration bounds how long verbosity can be raised for, so
+	// that a forgotten bump can't run up the logging bill.
+	maxVerbosityDuration = time.Hour
+
+	// defaultVerbosityDuration is how long verbosity is raised for if the
+	// request doesn't say.
+	defaultVerbosityDuration = 15 * time.Minute
+
+	// maxVerbosity is the highest verbosity level: 1 logs every request,
+	// and 2 also logs the request ID, user agent and referrer.
+	maxVerbosity = 2
+)
+
+var accessLogsSampledOut = newCounter("access_log_sampled_out_total", "Access log lines dropped by sampling, by route.", "route")
+
+// accessLogSampleRates are the fractions of successful requests logged per
+// route, set from flags at startup. Routes without a rate are always logged.
+var accessLogSampleRates map[string]float64
+
+// parseSampleRates parses route=fraction pairs, e.g. "static=0.1".
+func parseSampleRates(spec string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid sample rate %q: want route=fraction", part)
+		}
+		f, err := parseRate(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample rate %q: %v", part, err)
+		}
+		rates[kv[0]] = f
+	}
+	return rates, nil
+}
+
+// sampleAccessLog returns whether a request to the route that got the given
+// status is logged at the given verbosity, and the rate it was sampled at.
+// Errors are always logged, as is everything while verbosity is raised.
+func sampleAccessLog(route string, status, verbosity int) (bool, float64) {
+	rate, ok := accessLogSampleRates[route]
+	if !ok || verbosity > 0 || status >= 400 {
+		return true, 1
+	}
+	if rand.Float64() < rate {
+		return true, rate
+	}
+	accessLogsSampledOut.inc(route)
+	return false, rate
+}
+
+// logVerbosity is the access log verbosity, raised temporarily through the
+// admin API to debug an issue.
+type logVerbosity struct {
+	mu    sync.Mutex
+	level int
+	until time.Time
+}
+
+// accessLogVerbosity is the verbosity of the access log.
+var accessLogVerbosity logVerbosity
+
+// get returns the verbosity level in effect at the given time, and when it
+// expires.
+func (v *logVerbosity) get(now time.Time) (int, time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !now.Before(v.until) {
+		return 0, time.Time{}
+	}
+	return v.level, v.until
+}
+
+// set sets the verbosity level until the given time.
+func (v *logVerbosity) set(level int, until time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.level, v.until = level, until
+}
+
+// verbosityRequest sets the access log verbosity.
+type verbosityRequest struct {
+	Level int `json:"level"`
+
+	// Duration is how long the level is in effect, e.g. "10m".
+	Duration string `json:"duration"`
+}
+
+// verbosityResponse is served by /admin/log-verbosity.
+type verbosityResponse struct {
+	Level int        `json:"level"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// adminLogVerbosityHandler serves the access log verbosity on GET, raises it
+// temporarily on POST and resets it on DELETE.
+func adminLogVerbosityHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		switch r.Method {
+		case "GET":
+		case "POST":
+			var req verbosityRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Level < 0 || req.Level > maxVerbosity {
+				httpError(w, r, fmt.Sprintf("invalid request: level must be between 0 and %d", maxVerbosity), http.StatusBadRequest)
+				return
+			}
+			d := defaultVerbosityDuration
+			if req.Duration != "" {
+				var err error
+				d, err = time.ParseDuration(req.Duration)
+				if err != nil || d < time.Second || d > maxVerbosityDuration {
+					httpError(w, r, fmt.Sprintf("invalid request: duration must be between 1s and %v", maxVerbosityDuration), http.StatusBadRequest)
+					return
+				}
+			}
+			accessLogVerbosity.set(req.Level, now.Add(d))
+			log.Printf("Access log verbosity set to %d for %v", req.Level, d)
+		case "DELETE":
+			accessLogVerbosity.set(0, time.Time{})
+			log.Printf("Access log verbosity reset")
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		level, until := accessLogVerbosity.get(now)
+		res := verbosityResponse{Level: level}
+		if level > 0 {
+			until = until.UTC()
+			res.Until = &until
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(res)
+	})
+}
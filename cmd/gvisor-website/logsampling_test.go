@@ -0,0 +1,113 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSampleRates(t *testing.T) {
+	rates, err := parseSampleRates("static=0.1, docs=1,raw=0")
+	if err != nil {
+		t.Fatalf("parseSampleRates failed: %v", err)
+	}
+	if want := map[string]float64{"static": 0.1, "docs": 1, "raw": 0}; !reflect.DeepEqual(rates, want) {
+		t.Errorf("parseSampleRates = %v, want %v", rates, want)
+	}
+	for _, spec := range []string{"static", "static=x", "static=2", "static=-0.5"} {
+		if _, err := parseSampleRates(spec); err == nil {
+			t.Errorf("parseSampleRates(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestSampleAccessLog(t *testing.T) {
+	defer func(rates map[string]float64) { accessLogSampleRates = rates }(accessLogSampleRates)
+	accessLogSampleRates = map[string]float64{"static": 0}
+	for _, tc := range []struct {
+		route     string
+		status    int
+		verbosity int
+		want      bool
+	}{
+		{"static", 200, 0, false},
+		{"static", 304, 0, false},
+		{"static", 404, 0, true},
+		{"static", 500, 0, true},
+		{"static", 200, 1, true},
+		{"docs", 200, 0, true},
+	} {
+		if got, _ := sampleAccessLog(tc.route, tc.status, tc.verbosity); got != tc.want {
+			t.Errorf("sampleAccessLog(%s, %d, %d) = %v, want %v", tc.route, tc.status, tc.verbosity, got, tc.want)
+		}
+	}
+}
+
+func TestLogVerbosityExpires(t *testing.T) {
+	var v logVerbosity
+	now := time.Now()
+	v.set(2, now.Add(time.Minute))
+	if level, _ := v.get(now); level != 2 {
+		t.Errorf("level = %d, want 2", level)
+	}
+	if level, _ := v.get(now.Add(time.Minute)); level != 0 {
+		t.Errorf("level after expiry = %d, want 0", level)
+	}
+}
+
+func TestAdminLogVerbosityHandler(t *testing.T) {
+	defer accessLogVerbosity.set(0, time.Time{})
+	for _, tc := range []struct {
+		method string
+		body   string
+		code   int
+		level  int
+	}{
+		{"GET", "", 200, 0},
+		{"POST", `{"level": 2, "duration": "5m"}`, 200, 2},
+		{"GET", "", 200, 2},
+		{"POST", `{"level": 1}`, 200, 1},
+		{"POST", `{"level": 3}`, 400, 1},
+		{"POST", `{"level": 1, "duration": "2h"}`, 400, 1},
+		{"POST", `{"level": 1, "duration": "x"}`, 400, 1},
+		{"DELETE", "", 200, 0},
+		{"PUT", "", 405, 0},
+	} {
+		rec := httptest.NewRecorder()
+		adminLogVerbosityHandler().ServeHTTP(rec, httptest.NewRequest(tc.method, "/admin/log-verbosity", strings.NewReader(tc.body)))
+		if rec.Code != tc.code {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.body, rec.Code, tc.code)
+			continue
+		}
+		if level, _ := accessLogVerbosity.get(time.Now()); level != tc.level {
+			t.Errorf("%s %s: level %d, want %d", tc.method, tc.body, level, tc.level)
+		}
+		if rec.Code != 200 {
+			continue
+		}
+		var res verbosityResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if res.Level != tc.level || (res.Until != nil) != (tc.level > 0) {
+			t.Errorf("%s %s: response %+v, want level %d", tc.method, tc.body, res, tc.level)
+		}
+	}
+}
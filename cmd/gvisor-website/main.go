@@ -16,16 +16,18 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	// For triggering manual rebuilds.
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/cloudbuild/v1"
 )
 
@@ -39,9 +41,9 @@ var redirects = map[string]string{
 	// For links
 	"/faq": "/docs/user_guide/faq/",
 
-	// Redirects to compatibility docs.
-	"/c":             "/docs/user_guide/compatibility/",
-	"/c/linux/amd64": "/docs/user_guide/compatibility/linux/amd64/",
+	// Redirects to compatibility docs; /c/<os>/<arch> are registered from
+	// compatPlatforms.
+	"/c": "/docs/user_guide/compatibility/",
 
 	// Redirect for old urls
 	"/docs/user_guide/compatibility/amd64/": "/docs/user_guide/compatibility/linux/amd64/",
@@ -53,7 +55,7 @@ var redirects = map[string]string{
 	"/docs/user_guide/docker/":              "/docs/user_guide/quick_start/docker/",
 	"/docs/user_guide/docker":               "/docs/user_guide/quick_start/docker/",
 
-	// Deprecated, but links continue to work.
+	// Deprecated; see deprecatedRedirects.
 	"/cl": "https://gvisor-review.googlesource.com",
 }
 
@@ -62,17 +64,14 @@ var prefixHelpers = map[string]string{
 	"issue":  "https://github.com/google/gvisor/issues/%s",
 	"pr":     "https://github.com/google/gvisor/pull/%s",
 
-	// Redirects to compatibility docs.
-	"c/linux/amd64": "/docs/user_guide/compatibility/linux/amd64/#%s",
-
-	// Deprecated, but links continue to work.
+	// Deprecated; see deprecatedRedirects.
 	"cl": "https://gvisor-review.googlesource.com/c/gvisor/+/%s",
 }
 
 var (
 	validId     = regexp.MustCompile(`^[A-Za-z0-9-]*/?$`)
 	goGetHeader = `<meta name="go-import" content="gvisor.dev/gvisor git https://github.com/google/gvisor">`
-	goGetHTML5  = `<!doctype html><html><head><meta charset=utf-8>` + goGetHeader + `<title>Go-get</title></head><body></html>`
+	goGetHTML5  = `<!doctype html><html><head><meta charset=utf-8><meta name="robots" content="noindex">` + goGetHeader + `<title>Go-get</title></head><body></html>`
 )
 
 // cronHandler wraps an http.Handler to check that the request is from the App
@@ -81,7 +80,35 @@ var (
 func cronHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Header.Get("X-Appengine-Cron") != "true" {
-			http.NotFound(w, r)
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		// Fallthrough.
+		h.ServeHTTP(w, r)
+	})
+}
+
+// hasBearerToken returns true if the request is authorized with the given
+// non-empty bearer token.
+func hasBearerToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// adminHandler wraps an http.Handler to check that the request carries the
+// configured admin token as a bearer token. If no admin token is configured,
+// the admin API is disabled entirely.
+func adminHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *adminToken == "" {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		if !hasBearerToken(r, *adminToken) {
+			httpError(w, r, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 		// Fallthrough.
@@ -97,7 +124,17 @@ func wrappedHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		gg, ok := r.URL.Query()["go-get"]
 		if ok && len(gg) == 1 && gg[0] == "1" {
-			// Serve a trivial html page.
+			// Serve a trivial html page. The content is the same for
+			// every path and only changes on deploy, so it may be
+			// cached for a long time.
+			hdr := w.Header()
+			hdr.Set("Content-Type", "text/html; charset=utf-8")
+			hdr.Set("Content-Length", strconv.Itoa(len(goGetHTML5)))
+			hdr.Set("Cache-Control", "public, max-age=86400")
+			hdr.Set("X-Robots-Tag", "noindex")
+			if r.Method == "HEAD" {
+				return
+			}
 			w.Write([]byte(goGetHTML5))
 			return
 		}
@@ -106,12 +143,16 @@ func wrappedHandler(h http.Handler) http.Handler {
 	})
 }
 
-// redirectWithQuery redirects to the given target url preserving query parameters.
+// redirectWithQuery redirects to the given target url preserving query
+// parameters, except tracking parameters if they are stripped.
 func redirectWithQuery(w http.ResponseWriter, r *http.Request, target string) {
 	url := target
-	if qs := r.URL.RawQuery; qs != "" {
+	if qs := stripTrackingParams(r.URL.RawQuery); qs != "" {
 		url += "?" + qs
 	}
+	if redirectWarning(w, target, url) {
+		return
+	}
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
@@ -122,6 +163,7 @@ func hostRedirectHandler(h http.Handler) http.Handler {
 			// Redirect to the naked domain.
 			r.URL.Scheme = "https"  // Assume https.
 			r.URL.Host = r.Host[4:] // Remove the 'www.'
+			r.URL.RawQuery = stripTrackingParams(r.URL.RawQuery)
 			http.Redirect(w, r, r.URL.String(), http.StatusMovedPermanently)
 			return
 		}
@@ -130,6 +172,7 @@ func hostRedirectHandler(h http.Handler) http.Handler {
 			// Redirect to the custom domain.
 			r.URL.Scheme = "https" // Assume https.
 			r.URL.Host = *customHost
+			r.URL.RawQuery = stripTrackingParams(r.URL.RawQuery)
 			http.Redirect(w, r, r.URL.String(), http.StatusMovedPermanently)
 			return
 		}
@@ -147,7 +190,7 @@ func prefixRedirectHandler(prefix, baseURL string) http.Handler {
 		}
 		id := r.URL.Path[len(prefix):]
 		if !validId.MatchString(id) {
-			http.Error(w, "Not found", http.StatusNotFound)
+			httpError(w, r, "Not found", http.StatusNotFound)
 			return
 		}
 		target := fmt.Sprintf(baseURL, id)
@@ -162,78 +205,405 @@ func redirectHandler(target string) http.Handler {
 	})
 }
 
+// flattenRedirects returns a copy of the given redirects with internal chains
+// resolved, so that each path redirects directly to its final target rather
+// than through a series of redirects. Loops are logged and left unresolved.
+func flattenRedirects(m map[string]string) map[string]string {
+	flat := make(map[string]string, len(m))
+	for path, target := range m {
+		seen := map[string]bool{path: true}
+		final := target
+		for {
+			next, ok := m[final]
+			if !ok {
+				break
+			}
+			if seen[final] {
+				log.Printf("Warning: redirect loop from %q via %q; not flattening", path, final)
+				final = target
+				break
+			}
+			seen[final] = true
+			final = next
+		}
+		flat[path] = final
+	}
+	return flat
+}
+
 // redirectRedirects registers redirect http handlers.
-func registerRedirects(mux *http.ServeMux) {
+func registerRedirects(mux *http.ServeMux, staticDir string) {
 	if mux == nil {
 		mux = http.DefaultServeMux
 	}
 
 	for prefix, baseURL := range prefixHelpers {
 		p := "/" + prefix + "/"
-		mux.Handle(p, hostRedirectHandler(wrappedHandler(prefixRedirectHandler(p, baseURL))))
+		c := siteChain("prefix-redirect").append(deprecationMiddleware(p))
+		if trackedShortlinks[prefix] {
+			prefix := prefix
+			c = c.append(middleware{"referrers", func(h http.Handler) http.Handler { return referrerHandler(shortlinkReferrers, prefix, h) }})
+		}
+		mux.Handle(p, c.then(prefixRedirectHandler(p, baseURL)))
+	}
+	registerCompatRedirects(mux, staticDir)
+	registerGerritRedirects(mux)
+
+	for path, redirect := range flattenRedirects(redirects) {
+		mux.Handle(path, siteChain("redirect").append(deprecationMiddleware(path)).then(redirectHandler(redirect)))
+	}
+}
+
+// registerCommunityLinks registers the managed community shortlinks.
+func registerCommunityLinks(mux *http.ServeMux, dynamic *dynamicRedirects) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	for path, def := range communityLinks {
+		mux.Handle(path, siteChain("community-redirect").then(managedRedirectHandler(dynamic, path, def)))
+	}
+}
+
+// registerSource registers the raw source passthrough, archive downloads, ref
+// listings and release notes.
+func registerSource(mux *http.ServeMux) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle("/gvisor/raw/", baseChain("raw").then(rawHandler("/gvisor/raw/")))
+	mux.Handle("/gvisor/archive/", baseChain("archive").then(archiveHandler("/gvisor/archive/")))
+	mux.Handle("/api/git/tags", baseChain("git-refs").then(gitRefsHandler("refs/tags/")))
+	mux.Handle("/api/git/branches", baseChain("git-refs").then(gitRefsHandler("refs/heads/")))
+	mux.Handle(releasesPrefix, siteChain("releases").then(releaseNotesHandler()))
+}
+
+// registerDocs registers the docs APIs, which serve data derived from the
+// rendered pages in the static dir.
+func registerDocs(mux *http.ServeMux, staticDir string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
 	}
+	mux.Handle("/api/toc", baseChain("docs").then(tocHandler(staticDir)))
+	// Queries are counted outside of the response cache, so that cached
+	// searches are counted too.
+	mux.Handle("/api/search", baseChain("search").before("response-cache",
+		middleware{"search-queries", func(h http.Handler) http.Handler { return searchQueriesHandler(siteSearchQueries, h) }},
+	).then(searchHandler(staticDir)))
+	mux.Handle("/api/compatibility/search", baseChain("docs").then(compatSearchHandler(staticDir)))
+	mux.Handle("/api/compatibility/integrations", baseChain("docs").then(integrationsHandler(staticDir)))
+	mux.Handle("/api/runsc/flags", baseChain("docs").then(runscFlagsHandler(staticDir)))
+	mux.Handle("/api/compliance/oci", baseChain("docs").then(ociComplianceHandler(staticDir)))
+	mux.Handle("/api/edit-link", baseChain("docs").then(editLinkHandler()))
+	mux.Handle(exportPath, baseChain("export").then(docsExportHandler(staticDir)))
+	mux.Handle("/opensearch.xml", baseChain("search").then(openSearchHandler()))
+	mux.Handle("/precache-manifest.json", baseChain("docs").then(precacheManifestHandler(staticDir)))
+}
+
+// registerHome registers the homepage data API, which aggregates the dynamic
+// sections of the homepage.
+func registerHome(mux *http.ServeMux, staticDir string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle("/api/home", baseChain("home").then(homeHandler(staticDir)))
+}
+
+// registerSocialCards registers the social card images of docs and blog
+// pages, which their Open Graph tags refer to.
+func registerSocialCards(mux *http.ServeMux, staticDir string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle(ogPrefix, baseChain("og").then(socialCardHandler(staticDir)))
+}
+
+// registerBlog registers the author and tag index pages of the blog, the blog
+// APIs they are generated from, and the comment counts of posts.
+func registerBlog(mux *http.ServeMux) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle(blogAuthorsPrefix, siteChain("blog").then(blogIndexHandler(blogAuthors)))
+	mux.Handle(blogTagsPrefix, siteChain("blog").then(blogIndexHandler(blogTags)))
+	mux.Handle("/api/blog/posts", baseChain("blog").then(blogPostsHandler()))
+	mux.Handle("/api/blog/authors", baseChain("blog").then(blogTermsHandler(blogAuthors)))
+	mux.Handle("/api/blog/tags", baseChain("blog").then(blogTermsHandler(blogTags)))
+	mux.Handle("/api/comments", baseChain("blog").then(commentsHandler()))
+}
+
+// registerStatic registers static file handlers. Paths in the dynamic redirect
+// table take precedence over static files. Canary traffic, if any, is served
+// by the given canary handler.
+func registerStatic(mux *http.ServeMux, staticDir string, dynamic *dynamicRedirects, canary http.Handler) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle("/", siteChain("static").append(
+		middleware{"dynamic-redirects", func(h http.Handler) http.Handler { return dynamicRedirectHandler(dynamic, h) }},
+		middleware{"canary", func(h http.Handler) http.Handler { return canaryHandler(canary, h) }},
+	).then(staticFilesHandler(staticDir)))
+	// Error page templates are only rendered by the server.
+	mux.Handle("/"+errorPageDir+"/", siteChain("static").then(http.NotFoundHandler()))
+}
 
-	for path, redirect := range redirects {
-		mux.Handle(path, hostRedirectHandler(wrappedHandler(redirectHandler(redirect))))
+// staticFilesHandler serves the files in the given static dir.
+func staticFilesHandler(staticDir string) http.Handler {
+	return newChain(
+		middleware{"markdown", markdownHandler},
+		middleware{"fingerprint", func(h http.Handler) http.Handler { return fingerprintHandler(staticDir, h) }},
+		middleware{"image-variants", func(h http.Handler) http.Handler { return imageVariantHandler(staticDir, h) }},
+		middleware{"preload", func(h http.Handler) http.Handler { return preloadHandler(staticDir, h) }},
+		middleware{"rewrite", rewriteHandler},
+		middleware{"minify", func(h http.Handler) http.Handler {
+			if !*minifyStatic {
+				return h
+			}
+			return minifyHandler(staticDir, h)
+		}},
+	).then(http.FileServer(http.Dir(staticDir)))
+}
+
+// registerBenchmarks registers the benchmark results API.
+func registerBenchmarks(mux *http.ServeMux, store benchmarkStore) {
+	if mux == nil {
+		mux = http.DefaultServeMux
 	}
+	mux.Handle("/api/benchmarks", baseChain("benchmarks").then(benchmarksHandler(store)))
 }
 
-// registerStatic registers static file handlers
-func registerStatic(mux *http.ServeMux, staticDir string) {
+// registerStatus registers the build status dashboard and badge.
+func registerStatus(mux *http.ServeMux) {
 	if mux == nil {
 		mux = http.DefaultServeMux
 	}
-	mux.Handle("/", hostRedirectHandler(wrappedHandler(http.FileServer(http.Dir(staticDir)))))
+	mux.Handle("/status", siteChain("status").then(statusHandler()))
+	mux.Handle("/api/status", baseChain("status").then(apiStatusHandler()))
+	mux.Handle("/api/rebuild/history", baseChain("status").then(rebuildHistoryHandler()))
+	mux.Handle("/api/slo", baseChain("status").then(sloHandler()))
+	mux.Handle("/build/badge.svg", baseChain("badge").then(badgeHandler()))
 }
 
-// registerRebuild registers the rebuild handler.
+// registerSecurity registers the security advisories feeds and shortlinks,
+// and the CVE lookup API.
+func registerSecurity(mux *http.ServeMux, staticDir string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	mux.Handle("/security/advisories.json", baseChain("security").then(advisoriesJSONHandler()))
+	mux.Handle("/security/advisories.atom", baseChain("security").then(advisoriesAtomHandler()))
+	mux.Handle(advisoryPrefix, siteChain("advisory-redirect").then(advisoryRedirectHandler()))
+	mux.Handle(cvePrefix, baseChain("security").then(cveHandler(staticDir)))
+}
+
+// registerFeedback registers the page feedback API.
+func registerFeedback(mux *http.ServeMux, store feedbackStore, staticDir string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	limiter := newRateLimiter(*feedbackRate, *feedbackRate)
+	mux.Handle("/api/feedback", baseChain("feedback").append(
+		middleware{"rate-limit", func(h http.Handler) http.Handler { return rateLimitHandler(limiter, h) }},
+	).then(feedbackHandler(store, staticDir)))
+}
+
+// registerSubscribe registers the announce-list signup API, if signups are
+// enabled.
+func registerSubscribe(mux *http.ServeMux, subs *subscriptions) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	if subs == nil {
+		return
+	}
+	limiter := newRateLimiter(*subscribeRate, *subscribeRate)
+	chain := baseChain("subscribe").append(
+		middleware{"rate-limit", func(h http.Handler) http.Handler { return rateLimitHandler(limiter, h) }},
+	)
+	mux.Handle("/api/subscribe", chain.then(subscribeHandler(subs)))
+	mux.Handle(subscribeConfirmPath, chain.then(subscribeConfirmHandler(subs)))
+}
+
+// registerBeacons registers the page view and performance beacons.
+func registerBeacons(mux *http.ServeMux, views *pageViews, staticDir string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	limiter := newRateLimiter(*beaconRate, *beaconRate)
+	beacons := baseChain("beacon").append(
+		middleware{"rate-limit", func(h http.Handler) http.Handler { return rateLimitHandler(limiter, h) }},
+	)
+	mux.Handle("/api/beacon", beacons.then(beaconHandler(views, staticDir)))
+	mux.Handle("/api/rum", beacons.then(rumHandler(staticDir)))
+}
+
+// registerAnalytics registers the first-party analytics proxy, if enabled.
+func registerAnalytics(mux *http.ServeMux, collectURL string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	if collectURL == "" {
+		return
+	}
+	mux.Handle("/collect", baseChain("analytics").then(analyticsProxyHandler(collectURL)))
+}
+
+// registerPreviews registers the per-PR preview handler, if previews are
+// enabled.
+func registerPreviews(mux *http.ServeMux, previews *previewStore) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	if previews == nil {
+		return
+	}
+	mux.Handle("/preview/", baseChain("preview").then(previewHandler(previews)))
+}
+
+// registerWebhooks registers the GitHub and Cloud Build webhook handlers that
+// are configured.
+func registerWebhooks(mux *http.ServeMux) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	if *githubWebhookSecret != "" {
+		mux.Handle("/webhook/github", baseChain("webhook").then(githubWebhookHandler(*githubWebhookSecret)))
+	}
+	if ping := sitemapPingEndpoints(); *buildNotifyToken != "" && (*buildNotifyURL != "" || len(ping) > 0) {
+		mux.Handle("/webhook/cloud-builds", baseChain("webhook").then(buildNotifyHandler(*buildNotifyToken, *buildNotifyURL, ping)))
+	}
+}
+
+// registerAdmin registers the admin API handlers.
+func registerAdmin(mux *http.ServeMux, dynamic *dynamicRedirects, feedback feedbackStore, banner *announcements, views *pageViews) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	admin := baseChain("admin").append(middleware{"admin", adminHandler})
+	mux.Handle("/admin/redirects", admin.then(adminRedirectsHandler(dynamic)))
+	mux.Handle("/admin/redirects/health", admin.then(adminRedirectHealthHandler()))
+	mux.Handle("/admin/feedback", admin.then(feedbackSummaryHandler(feedback)))
+	mux.Handle("/admin/announcement", admin.then(adminAnnouncementHandler(banner)))
+	mux.Handle("/admin/pageviews", admin.then(pageViewsReportHandler(views)))
+	mux.Handle("/admin/blocked", admin.then(adminBlockedHandler()))
+	mux.Handle("/admin/stats", admin.then(adminStatsHandler(shortlinkReferrers, siteSearchQueries)))
+	signing := baseChain("admin").append(middleware{"signer", signerHandler})
+	mux.Handle("/admin/signed-url", signing.then(adminSignedURLHandler()))
+	mux.Handle("/admin/log-verbosity", admin.then(adminLogVerbosityHandler()))
+	mux.Handle("/metrics", admin.then(metricsHandler()))
+}
+
+// runRebuild runs the site's build trigger on the master branch.
+func runRebuild(ctx context.Context) error {
+	cloudbuildService, projectID, err := newCloudBuild(ctx)
+	if err != nil {
+		return err
+	}
+	triggers, err := cloudbuildService.Projects.Triggers.List(projectID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("trigger list error: %v", err)
+	}
+	if len(triggers.Triggers) < 1 {
+		return fmt.Errorf("trigger list error: no triggers")
+	}
+	if _, err := cloudbuildService.Projects.Triggers.Run(
+		projectID,
+		triggers.Triggers[0].Id,
+		&cloudbuild.RepoSource{
+			// In the current project, require that a
+			// github cloud source repository exists with
+			// the given name, and build from master.
+			BranchName: "master",
+			RepoName:   "github_google_gvisor-website",
+			ProjectId:  projectID,
+		}).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("run error: %v", err)
+	}
+	return nil
+}
+
+// registerRebuild registers the rebuild handler, the handler publishing
+// scheduled content, and the docs sync handler if the site was built with
+// synced docs.
 func registerRebuild(mux *http.ServeMux) {
 	if mux == nil {
 		mux = http.DefaultServeMux
 	}
 
-	mux.Handle("/rebuild", cronHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.Background()
-		credentials, err := google.FindDefaultCredentials(ctx, cloudbuild.CloudPlatformScope)
-		if err != nil {
-			http.Error(w, "credentials error: "+err.Error(), 500)
-			return
-		}
-		cloudbuildService, err := cloudbuild.NewService(ctx)
-		if err != nil {
-			http.Error(w, "cloudbuild service error: "+err.Error(), 500)
-			return
-		}
-		projectID := credentials.ProjectID
-		if projectID == "" {
-			// If running locally, then this project will not be
-			// available. Use the default project here.
-			projectID = "gvisor-website"
-		}
-		triggers, err := cloudbuildService.Projects.Triggers.List(projectID).Do()
-		if err != nil {
-			http.Error(w, "trigger list error: "+err.Error(), 500)
-			return
-		}
-		if len(triggers.Triggers) < 1 {
-			http.Error(w, "trigger list error: no triggers", 500)
-			return
-		}
-		if _, err := cloudbuildService.Projects.Triggers.Run(
-			projectID,
-			triggers.Triggers[0].Id,
-			&cloudbuild.RepoSource{
-				// In the current project, require that a
-				// github cloud source repository exists with
-				// the given name, and build from master.
-				BranchName: "master",
-				RepoName:   "github_google_gvisor-website",
-				ProjectId:  projectID,
-			}).Do(); err != nil {
-			http.Error(w, "run error: "+err.Error(), 500)
+	mux.Handle("/rebuild", baseChain("rebuild").append(middleware{"cron", cronHandler}, middleware{"rebuild-metrics", rebuildMetricsHandler}).then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := runRebuild(context.Background()); err != nil {
+			httpError(w, r, err.Error(), 500)
 			return
 		}
 	})))
+	mux.Handle("/publish", baseChain("publish").append(middleware{"cron", cronHandler}).then(publishHandler()))
+	if g3docSync != nil {
+		mux.Handle("/sync/g3doc", baseChain("g3doc-sync").append(middleware{"cron", cronHandler}).then(g3docSyncHandler(g3docSync)))
+	}
+}
+
+// site holds the stores and handlers that the site's handlers are created
+// with.
+type site struct {
+	dynamic    *dynamicRedirects
+	banner     *announcements
+	benchmarks benchmarkStore
+	feedback   feedbackStore
+	views      *pageViews
+
+	// previews is nil if previews are disabled.
+	previews *previewStore
+
+	// subscriptions is nil if announce-list signups are disabled.
+	subscriptions *subscriptions
+
+	// canary is nil if no canary build is served.
+	canary http.Handler
+}
+
+// registerSite registers all of the site's handlers. Subsystems disabled with
+// their --enable flag are not registered, and are not found.
+func registerSite(mux *http.ServeMux, staticDir string, s *site) {
+	registerReadiness(mux, staticDir)
+	registerVersion(mux)
+	registerRedirects(mux, staticDir)
+	registerCommunityLinks(mux, s.dynamic)
+	if deployProfile.rebuild {
+		registerRebuild(mux)
+	}
+	registerProfile(mux)
+	if *enableGitProxy {
+		registerSource(mux)
+	}
+	if *enableBenchmarks {
+		registerBenchmarks(mux, s.benchmarks)
+	}
+	if *enableStatus {
+		registerStatus(mux)
+	}
+	if *enableSecurity {
+		registerSecurity(mux, staticDir)
+	}
+	if *enableFeedback {
+		registerFeedback(mux, s.feedback, staticDir)
+	}
+	if *enableBeacons {
+		registerBeacons(mux, s.views, staticDir)
+	}
+	registerAnalytics(mux, *analyticsCollectURL)
+	if *enableAdmin {
+		registerAdmin(mux, s.dynamic, s.feedback, s.banner, s.views)
+	}
+	registerPreviews(mux, s.previews)
+	registerSubscribe(mux, s.subscriptions)
+	if *enableWebhooks {
+		registerWebhooks(mux)
+	}
+	registerDocs(mux, staticDir)
+	registerHome(mux, staticDir)
+	registerBlog(mux)
+	registerSocialCards(mux, staticDir)
+	registerAPI(mux, staticDir, s.benchmarks)
+	registerStatic(mux, staticDir, s.dynamic, s.canary)
 }
 
 func envFlagString(name, def string) string {
@@ -243,21 +613,537 @@ func envFlagString(name, def string) string {
 	return def
 }
 
+func envFlagBool(name string, def bool) bool {
+	if val := os.Getenv(name); val != "" {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func envFlagInt(name string, def int) int {
+	if val := os.Getenv(name); val != "" {
+		if i, err := strconv.Atoi(val); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+func envFlagFloat(name string, def float64) float64 {
+	if val := os.Getenv(name); val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func envFlagDuration(name string, def time.Duration) time.Duration {
+	if val := os.Getenv(name); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
 var (
-	addr      = flag.String("http", envFlagString("HTTP", ":8080"), "HTTP service address")
-	staticDir = flag.String("static-dir", envFlagString("STATIC_DIR", "static"), "static files directory")
+	addr       = flag.String("http", envFlagString("HTTP", ":8080"), "HTTP service address, used if --listen is empty")
+	staticDir  = flag.String("static-dir", envFlagString("STATIC_DIR", "static"), "static files directory")
+	contentDir = flag.String("content-dir", envFlagString("CONTENT_DIR", "content"), "Markdown sources directory")
 	// Uses the standard GOOGLE_CLOUD_PROJECT environment variable set by App Engine.
 	projectId  = flag.String("project-id", envFlagString("GOOGLE_CLOUD_PROJECT", ""), "The App Engine project ID.")
 	customHost = flag.String("custom-domain", envFlagString("CUSTOM_DOMAIN", "gvisor.dev"), "The application's custom domain.")
+	accessLog  = flag.Bool("access-log", envFlagBool("ACCESS_LOG", true), "Log every request.")
+	adminToken = flag.String("admin-token", envFlagString("ADMIN_TOKEN", ""), "Bearer token for the admin API; the admin API is disabled if empty.")
+
+	accessLogSampleSpec = flag.String("access-log-sample-rates", envFlagString("ACCESS_LOG_SAMPLE_RATES", "static=0.1"), "Fractions of successful requests logged per route, as route=fraction pairs; errors are always logged.")
+
+	sloSpec          = flag.String("slos", envFlagString("SLOS", "git-refs=99.5:2s,rebuild=99:60s"), "Per-route objectives, as route=target:latency pairs with the target in percent of requests that must succeed and be faster than latency.")
+	sloWindow        = flag.Duration("slo-window", envFlagDuration("SLO_WINDOW", time.Hour), "Sliding window over which SLOs are evaluated.")
+	sloCheckInterval = flag.Duration("slo-check-interval", envFlagDuration("SLO_CHECK_INTERVAL", time.Minute), "How often SLOs are checked for threshold crossings, which are logged as SLO_BREACHED and SLO_RECOVERED events.")
+
+	startupBudget = flag.Duration("startup-budget", envFlagDuration("STARTUP_BUDGET", 10*time.Second), "Time from process start to listening above which a warning is logged; 0 disables the warning.")
+
+	watchdogInterval         = flag.Duration("watchdog-interval", envFlagDuration("WATCHDOG_INTERVAL", time.Minute), "How often goroutines, upstream connections and cache sizes are sampled; 0 disables the watchdog.")
+	watchdogMaxGoroutines    = flag.Int("watchdog-max-goroutines", envFlagInt("WATCHDOG_MAX_GOROUTINES", 10000), "Goroutine count above which the watchdog logs an anomaly; 0 is unlimited.")
+	watchdogMaxUpstreamConns = flag.Int("watchdog-max-upstream-conns", envFlagInt("WATCHDOG_MAX_UPSTREAM_CONNS", 500), "Open upstream connection count above which the watchdog logs an anomaly; 0 is unlimited.")
+
+	memoryLimitMB       = flag.Int("memory-limit-mb", envFlagInt("MEMORY_LIMIT_MB", 0), "Memory limit of the instance in MiB; 0 uses the container's cgroup limit, if any.")
+	memoryShedPercent   = flag.Int("memory-shed-percent", envFlagInt("MEMORY_SHED_PERCENT", 85), "Percentage of the memory limit above which requests to --memory-shed-routes are rejected; 0 disables shedding.")
+	memoryShedRouteSpec = flag.String("memory-shed-routes", envFlagString("MEMORY_SHED_ROUTES", "git-refs,archive,raw,search"), "Comma-separated routes whose requests are rejected under memory pressure.")
+	memoryCheckInterval = flag.Duration("memory-check-interval", envFlagDuration("MEMORY_CHECK_INTERVAL", time.Second), "How often memory use is checked against the limit.")
+
+	listenSpec = flag.String("listen", envFlagString("LISTEN", ""), "Comma-separated listener URLs, e.g. tcp4://0.0.0.0:8080,tcp6://[::]:8080?optional=true; settings read_timeout, write_timeout, idle_timeout and optional are set as query parameters. quic://:443?cert=cert.pem&key=key.pem serves HTTP/3 in servers built with the http3 tag, and is advertised with Alt-Svc on the other listeners while it is up.")
+
+	contentVersion     = flag.String("content-version", envFlagString("CONTENT_VERSION", ""), "Expected version of the static content, as in /precache-manifest.json; /readyz reports not ready until the static dir matches it.")
+	contentVersionFile = flag.String("content-version-file", envFlagString("CONTENT_VERSION_FILE", "content-version"), "Marker file written by the build holding the expected content version, used if --content-version is empty.")
+
+	manifestKey       = flag.String("manifest-public-key", envFlagString("MANIFEST_PUBLIC_KEY", ""), "PEM file of the ECDSA or RSA public key the static content manifest is signed with; the static content is verified at startup if set.")
+	manifestFile      = flag.String("manifest-file", envFlagString("MANIFEST_FILE", "static-manifest.json"), "Manifest of the static content written by the build.")
+	manifestSignature = flag.String("manifest-signature-file", envFlagString("MANIFEST_SIGNATURE_FILE", "static-manifest.json.sig"), "Signature of the SHA-256 digest of the manifest.")
+	manifestAlertOnly = flag.Bool("manifest-alert-only", envFlagBool("MANIFEST_ALERT_ONLY", false), "Log and report static content not matching the signed manifest instead of refusing to start.")
+
+	profileName    = flag.String("profile", envFlagString("PROFILE", ""), "Deployment profile: prod, staging or dev. Defaults to dev outside App Engine, staging for App Engine versions named staging-*, and prod otherwise.")
+	noindex        = flag.Bool("noindex", envFlagBool("NOINDEX", false), "Mark all responses noindex and disallow all crawling in robots.txt; defaults to the profile's setting.")
+	debugEndpoints = flag.Bool("debug-endpoints", envFlagBool("DEBUG_ENDPOINTS", false), "Serve the debug endpoints and allow --chaos; defaults to the profile's setting.")
+
+	// Subsystems can be disabled so that reduced-privilege deployments,
+	// e.g. a public mirror, serve only the static site and redirects.
+	enableRebuild    = flag.Bool("enable-rebuild", envFlagBool("ENABLE_REBUILD", false), "Serve the /rebuild cron handler, which runs Cloud Build triggers; defaults to the profile's setting.")
+	enableGitProxy   = flag.Bool("enable-git-proxy", envFlagBool("ENABLE_GIT_PROXY", true), "Serve the raw source passthrough, archive downloads, git ref APIs and release notes pages, which proxy GitHub.")
+	enableStatus     = flag.Bool("enable-status", envFlagBool("ENABLE_STATUS", true), "Serve the build status dashboard, badge and APIs, which read Cloud Build.")
+	enableWebhooks   = flag.Bool("enable-webhooks", envFlagBool("ENABLE_WEBHOOKS", true), "Serve the configured GitHub and Cloud Build webhooks.")
+	enableAdmin      = flag.Bool("enable-admin", envFlagBool("ENABLE_ADMIN", true), "Serve the admin API, if --admin-token is set.")
+	enableFeedback   = flag.Bool("enable-feedback", envFlagBool("ENABLE_FEEDBACK", true), "Serve the page feedback API.")
+	enableBeacons    = flag.Bool("enable-beacons", envFlagBool("ENABLE_BEACONS", true), "Serve the page view and performance beacons.")
+	enableBenchmarks = flag.Bool("enable-benchmarks", envFlagBool("ENABLE_BENCHMARKS", true), "Serve the benchmark results API.")
+	enableSecurity   = flag.Bool("enable-security", envFlagBool("ENABLE_SECURITY", true), "Serve the security advisories feeds and shortlinks and the CVE lookup API, which read GitHub.")
+
+	redirectStore         = flag.String("redirect-store", envFlagString("REDIRECT_STORE", "memory"), "Backend for dynamic redirects: memory or firestore.")
+	redirectSyncInterval  = flag.Duration("redirect-sync-interval", envFlagDuration("REDIRECT_SYNC_INTERVAL", time.Minute), "How often dynamic redirects and the announcement are synced from the backend.")
+	redirectCheckInterval = flag.Duration("redirect-check-interval", envFlagDuration("REDIRECT_CHECK_INTERVAL", 15*time.Minute), "How often external redirect targets are checked; 0 disables checks.")
+	redirectWarnAfter     = flag.Duration("redirect-warn-after", envFlagDuration("REDIRECT_WARN_AFTER", 0), "How long an external redirect target must have been failing before a warning page is served instead of redirecting; 0 always redirects.")
+	announcementStore     = flag.String("announcement-store", envFlagString("ANNOUNCEMENT_STORE", "memory"), "Backend for the site announcement: memory or firestore.")
+
+	benchmarkStoreType = flag.String("benchmark-store", envFlagString("BENCHMARK_STORE", "memory"), "Backend for benchmark results: memory or firestore.")
+	benchmarkToken     = flag.String("benchmark-token", envFlagString("BENCHMARK_TOKEN", ""), "Bearer token CI uses to publish benchmark results; publishing is disabled if empty.")
+
+	feedbackStoreType = flag.String("feedback-store", envFlagString("FEEDBACK_STORE", "memory"), "Backend for page feedback: memory or firestore.")
+	feedbackRate      = flag.Int("feedback-rate", envFlagInt("FEEDBACK_RATE", 10), "Maximum feedback submissions per minute per client.")
+	recaptchaSecret   = flag.String("recaptcha-secret", envFlagString("RECAPTCHA_SECRET", ""), "reCAPTCHA v3 secret for verifying feedback; verification is disabled if empty. Requires the params.ui.feedback.site_key site parameter.")
+	recaptchaMinScore = flag.Float64("recaptcha-min-score", envFlagFloat("RECAPTCHA_MIN_SCORE", 0.5), "Minimum reCAPTCHA v3 score for accepting feedback.")
+
+	subscribeProvider = flag.String("subscribe-provider", envFlagString("SUBSCRIBE_PROVIDER", ""), "Mailing list provider that confirmed announce-list signups from /api/subscribe are added to: mailchimp or groups; signups are disabled if empty.")
+	subscribeListURL  = flag.String("subscribe-list-url", envFlagString("SUBSCRIBE_LIST_URL", ""), "API URL of the announce list: a Mailchimp audience, e.g. https://us4.api.mailchimp.com/3.0/lists/<list-id>, or a Google Group in the Admin SDK, e.g. https://admin.googleapis.com/admin/directory/v1/groups/<group-email>.")
+	mailchimpAPIKey   = flag.String("mailchimp-api-key", envFlagString("MAILCHIMP_API_KEY", ""), "Mailchimp API key, for the mailchimp subscribe provider.")
+	subscribeSecret   = flag.String("subscribe-secret", envFlagString("SUBSCRIBE_SECRET", ""), "Secret signing the confirmation links of announce-list signups.")
+	subscribeSMTPURL  = flag.String("subscribe-smtp-url", envFlagString("SUBSCRIBE_SMTP_URL", ""), "SMTP relay confirmation links are mailed through, as smtp://[user:password@]host:port.")
+	subscribeFrom     = flag.String("subscribe-from", envFlagString("SUBSCRIBE_FROM", "gVisor <noreply@gvisor.dev>"), "Sender of the confirmation mails of announce-list signups.")
+	subscribeRate     = flag.Int("subscribe-rate", envFlagInt("SUBSCRIBE_RATE", 5), "Maximum announce-list signups and confirmations per minute per client.")
+
+	apiRate             = flag.Int("api-rate", envFlagInt("API_RATE", 120), "Maximum versioned API requests per minute per client; 0 disables the limit.")
+	beaconRate          = flag.Int("beacon-rate", envFlagInt("BEACON_RATE", 60), "Maximum page view and performance beacons per minute per client.")
+	analyticsCollectURL = flag.String("analytics-collect-url", envFlagString("ANALYTICS_COLLECT_URL", ""), "Analytics collection endpoint that hits to /collect are proxied to, e.g. https://www.google-analytics.com/collect; the proxy is disabled if empty.")
+
+	previewBucket = flag.String("preview-bucket", envFlagString("PREVIEW_BUCKET", ""), "Cloud Storage bucket holding per-PR preview builds; previews are disabled if empty.")
+	previewPrefix = flag.String("preview-prefix", envFlagString("PREVIEW_PREFIX", "previews"), "Object prefix of preview builds in the preview bucket.")
+	previewTTL    = flag.Duration("preview-ttl", envFlagDuration("PREVIEW_TTL", 14*24*time.Hour), "How long a preview is served after it was last built; 0 serves previews indefinitely.")
+
+	signingAccount = flag.String("signing-account", envFlagString("SIGNING_ACCOUNT", ""), "Service account that signed download URLs minted by /admin/signed-url are signed as; the app's service account needs the Service Account Token Creator role on it. Signed URLs are disabled if empty.")
+	signedBuckets  = flag.String("signed-url-buckets", envFlagString("SIGNED_URL_BUCKETS", ""), "Comma-separated Cloud Storage buckets signed URLs may be minted for, e.g. the preview bucket and build output buckets.")
+	signedPrefixes = flag.String("signed-url-prefixes", envFlagString("SIGNED_URL_PREFIXES", "previews/"), "Comma-separated object name prefixes signed URLs may be minted for.")
+	signedURLTTL   = flag.Duration("signed-url-ttl", envFlagDuration("SIGNED_URL_TTL", 15*time.Minute), "Maximum and default time signed URLs are valid for.")
+	signerToken    = flag.String("signer-token", envFlagString("SIGNER_TOKEN", ""), "Bearer token for /admin/signed-url only, which also accepts the admin token.")
+
+	githubWebhookSecret = flag.String("github-webhook-secret", envFlagString("GITHUB_WEBHOOK_SECRET", ""), "Secret GitHub webhook deliveries are signed with; the webhook is disabled if empty. Pull request events start preview builds into the preview bucket, and push events from the gVisor repository run the docs sync.")
+	githubToken         = flag.String("github-token", envFlagString("GITHUB_TOKEN", ""), "GitHub token used to comment preview URLs on pull requests and to fetch the GitHub Discussions of blog posts; both are disabled if empty.")
+	buildNotifyURL      = flag.String("build-notify-url", envFlagString("BUILD_NOTIFY_URL", ""), "Slack or Google Chat incoming webhook that finished site builds are posted to.")
+	buildNotifyToken    = flag.String("build-notify-token", envFlagString("BUILD_NOTIFY_TOKEN", ""), "Token the cloud-builds Pub/Sub push subscription passes to /webhook/cloud-builds; build notifications and sitemap pings are disabled if empty.")
+	sitemapPingURLs     = flag.String("sitemap-ping-urls", envFlagString("SITEMAP_PING_URLS", ""), "Comma-separated search engine endpoints pinged with the URL of the sitemap appended after each deployed build, e.g. https://example.com/ping?sitemap=; needs --build-notify-token.")
+
+	upstreamCI = flag.Bool("upstream-ci-status", envFlagBool("UPSTREAM_CI_STATUS", false), "Include upstream gVisor CI state in the status dashboard.")
+
+	canaryStaticDir = flag.String("canary-static-dir", envFlagString("CANARY_STATIC_DIR", ""), "Static files directory of a canary site build.")
+	canaryUpstream  = flag.String("canary-upstream", envFlagString("CANARY_UPSTREAM", ""), "URL of a server serving a canary site build; only one of --canary-static-dir and --canary-upstream may be set.")
+	canaryPercent   = flag.Int("canary-percent", envFlagInt("CANARY_PERCENT", 0), "Percentage of browser clients served the canary build; requests with an X-Canary: 1 header are always served the canary.")
+
+	shadowUpstream    = flag.String("shadow-upstream", envFlagString("SHADOW_UPSTREAM", ""), "URL of a shadow server that a fraction of the read-only requests of --shadow-routes are mirrored to, e.g. a new implementation of the git proxy; its responses are only compared with the served ones by status code.")
+	shadowFraction    = flag.Float64("shadow-fraction", envFlagFloat("SHADOW_FRACTION", 0.01), "Fraction of the GET and HEAD requests of --shadow-routes mirrored to --shadow-upstream.")
+	shadowRoutes      = flag.String("shadow-routes", envFlagString("SHADOW_ROUTES", "git-refs,raw,archive"), "Comma-separated routes whose requests are mirrored to --shadow-upstream.")
+	shadowMaxInFlight = flag.Int("shadow-max-in-flight", envFlagInt("SHADOW_MAX_IN_FLIGHT", 16), "Maximum mirrored requests in flight; requests beyond it are not mirrored.")
+
+	stripTracking = flag.Bool("strip-tracking-params", envFlagBool("STRIP_TRACKING_PARAMS", false), "Drop utm_* and click identifier parameters such as fbclid from redirect targets and response cache keys.")
+
+	archiveProxy = flag.Bool("archive-proxy", envFlagBool("ARCHIVE_PROXY", false), "Stream source archives through the server instead of redirecting to GitHub.")
+
+	buildMetricsInterval = flag.Duration("build-metrics-interval", envFlagDuration("BUILD_METRICS_INTERVAL", 5*time.Minute), "How often finished builds are recorded in the build metrics; 0 disables background recording.")
+
+	upstreamTimeout = flag.Duration("upstream-timeout", envFlagDuration("UPSTREAM_TIMEOUT", 30*time.Second), "Maximum time to wait for the response headers of upstream requests, e.g. to GitHub and Google APIs.")
+	egressAllow     = flag.String("egress-allow", envFlagString("EGRESS_ALLOW", "github.com,api.github.com,codeload.github.com,raw.githubusercontent.com,*.googleapis.com,www.google.com"), "Comma-separated hosts upstream requests may be sent to, with *.domain matching subdomains; the hosts of --git-upstream, --advisories-url, --releases-url, --meetings-calendar-url, --canary-upstream, --shadow-upstream, --analytics-collect-url, --build-notify-url and --subscribe-list-url are allowed too. * allows all hosts.")
+
+	gitUpstream    = flag.String("git-upstream", envFlagString("GIT_UPSTREAM", "https://github.com/google/gvisor.git"), "Upstream repository whose refs are served by the git APIs.")
+	gitRefsRefresh = flag.Duration("git-refs-refresh", envFlagDuration("GIT_REFS_REFRESH", time.Minute), "How often the upstream ref advertisement is refreshed in the background; 0 disables background refresh.")
+
+	releasesURL   = flag.String("releases-url", envFlagString("RELEASES_URL", "https://api.github.com/repos/google/gvisor/releases"), "GitHub releases API the release notes pages are rendered from.")
+	advisoriesURL = flag.String("advisories-url", envFlagString("ADVISORIES_URL", "https://api.github.com/repos/google/gvisor/security-advisories"), "GitHub repository security advisories API the advisories feeds are sourced from.")
+
+	meetingsCalendarURL = flag.String("meetings-calendar-url", envFlagString("MEETINGS_CALENDAR_URL", "https://calendar.google.com/calendar/ical/bd6f4k210u3ukmlj9b8vl053fk%40group.calendar.google.com/public/basic.ics"), "iCalendar feed of the community meetings listed in /api/home; meetings are not listed if empty.")
+
+	chaosSpec = flag.String("chaos", envFlagString("CHAOS", ""), "Faults injected into git and Cloud Build requests, as latency=duration, error=fraction and truncate=fraction pairs. For testing only.")
+
+	concurrencyLimitSpec = flag.String("concurrency-limits", envFlagString("CONCURRENCY_LIMITS", "rebuild=1,publish=1,g3doc-sync=1,archive=16,raw=64,status=8,export=4"), "Per-route limits on requests in flight, as route=limit pairs.")
+
+	maxURILength  = flag.Int("max-uri-length", envFlagInt("MAX_URI_LENGTH", 8192), "Maximum length of request URIs, including the query; 0 disables the limit.")
+	maxBodyBytes  = flag.Int("max-body-bytes", envFlagInt("MAX_BODY_BYTES", 1<<20), "Maximum size of request bodies on routes without a limit in --body-limits; 0 disables the limit.")
+	bodyLimitSpec = flag.String("body-limits", envFlagString("BODY_LIMITS", "feedback=16384,beacon=4096,analytics=8192"), "Per-route request body size limits in bytes, as route=limit pairs.")
+
+	trustedProxies = flag.Int("trusted-proxies", envFlagInt("TRUSTED_PROXIES", 0), "Number of trusted proxies in front of the server appending to X-Forwarded-For; the client address is taken that many hops from the right. Ignored on App Engine.")
+
+	deniedClassSpec    = flag.String("deny-classes", envFlagString("DENY_CLASSES", "rebuild=crawler,publish=crawler,g3doc-sync=crawler,archive=crawler,raw=crawler,git-refs=crawler,benchmarks=crawler,feedback=crawler,export=crawler"), "Traffic classes denied per route, as route=class pairs.")
+	classRateLimitSpec = flag.String("class-rate-limits", envFlagString("CLASS_RATE_LIMITS", ""), "Per-client rate limits per traffic class, as class=requests-per-minute pairs.")
+
+	geoCountryHeader    = flag.String("geo-country-header", envFlagString("GEO_COUNTRY_HEADER", "X-Appengine-Country"), "Request header carrying the client country, set by the front end.")
+	geoASNHeader        = flag.String("geo-asn-header", envFlagString("GEO_ASN_HEADER", "X-Client-Asn"), "Request header carrying the client ASN, set by a load balancer custom header.")
+	armorHeader         = flag.String("armor-header", envFlagString("ARMOR_HEADER", "X-Cloud-Armor-Flag"), "Request header added by Cloud Armor rules to flag suspicious clients.")
+	datacenterASNSpec   = flag.String("datacenter-asns", envFlagString("DATACENTER_ASNS", "14061,14618,15169,16276,16509,20473,24940,396982,63949,8075"), "Comma-separated ASNs of hosting providers, subject to the strict rate limits.")
+	strictRateLimitSpec = flag.String("strict-rate-limits", envFlagString("STRICT_RATE_LIMITS", "git-refs=30,archive=10,raw=120"), "Per-client rate limits for datacenter and flagged clients, as route=requests-per-minute pairs.")
+
+	minifyStatic      = flag.Bool("minify", envFlagBool("MINIFY", false), "Serve HTML, CSS and JS from the static dir minified.")
+	fingerprintAssets = flag.Bool("fingerprint-assets", envFlagBool("FINGERPRINT_ASSETS", true), "Rewrite CSS and JS references in pages to content-hashed paths served with immutable caching.")
+	featureConfig     = flag.String("feature-config", envFlagString("FEATURE_CONFIG", "features.json"), "JSON file of feature flags, as name and percent of clients pairs.")
+	rewriteConfig     = flag.String("html-rewrite-config", envFlagString("HTML_REWRITE_CONFIG", "rewrite.json"), "JSON file of rules for rewriting served HTML pages.")
+
+	subresourceIntegrity = flag.Bool("subresource-integrity", envFlagBool("SUBRESOURCE_INTEGRITY", true), "Add integrity attributes to scripts and stylesheets in pages.")
+	sriConfig            = flag.String("sri-config", envFlagString("SRI_CONFIG", "sri.json"), "JSON file of integrity values of third-party scripts and stylesheets, by URL.")
+
+	experimentConfig = flag.String("experiment-config", envFlagString("EXPERIMENT_CONFIG", "experiments.json"), "JSON file of layout experiments, whose variants are served by rewrite rules.")
+	experimentSalt   = flag.String("experiment-salt", envFlagString("EXPERIMENT_SALT", "gvisor"), "Salt of the hash bucketing clients into experiment variants; changing it reshuffles clients.")
+
+	searchSynonymsConfig = flag.String("search-synonyms", envFlagString("SEARCH_SYNONYMS", "search-synonyms.json"), "JSON file of groups of equivalent search terms and phrases, in addition to the built-in ones.")
+
+	editRepo       = flag.String("edit-repo", envFlagString("EDIT_REPO", "https://github.com/google/gvisor-website"), "GitHub repository of the site's content, for edit links.")
+	editBranch     = flag.String("edit-branch", envFlagString("EDIT_BRANCH", "master"), "Branch of --edit-repo edit links point to.")
+	editRepoDir    = flag.String("edit-repo-dir", envFlagString("EDIT_REPO_DIR", "content"), "Directory of the content in --edit-repo.")
+	editLinkConfig = flag.String("edit-link-config", envFlagString("EDIT_LINK_CONFIG", "edit-links.json"), "JSON file mapping generated content to the repositories it is generated from, for edit links.")
+
+	g3docVersionFile = flag.String("g3doc-version-file", envFlagString("G3DOC_VERSION_FILE", "g3doc-version"), "Marker file written by the build holding the version of the gVisor docs synced into the content; the docs sync worker is disabled if it doesn't exist.")
+	g3docBranch      = flag.String("g3doc-branch", envFlagString("G3DOC_BRANCH", "master"), "Branch of the gVisor repository the docs are synced from.")
+
+	abuseThreshold = flag.Int("abuse-threshold", envFlagInt("ABUSE_THRESHOLD", 50), "Offender score at which a client is blocked; each 4xx response scores 1 and each probe 10. 0 disables abuse blocking.")
+	abuseHalfLife  = flag.Duration("abuse-half-life", envFlagDuration("ABUSE_HALF_LIFE", 5*time.Minute), "Half-life of offender scores.")
+	abuseBlockFor  = flag.Duration("abuse-block-duration", envFlagDuration("ABUSE_BLOCK_DURATION", 15*time.Minute), "How long abusive clients are blocked.")
+	abuseProbes    = flag.String("abuse-probe-paths", envFlagString("ABUSE_PROBE_PATHS", "/wp-admin,/wp-login.php,/wp-content,/xmlrpc.php,/.env,/.git/,/phpmyadmin,/cgi-bin/,/vendor/phpunit"), "Comma-separated path prefixes that only scanners request.")
+
+	cacheSpec          = flag.String("cache", envFlagString("CACHE", "memory"), "Cache for upstream data: memory or a redis://host:port URL (e.g. Memorystore).")
+	memoryCacheEntries = flag.Int("memory-cache-entries", envFlagInt("MEMORY_CACHE_ENTRIES", 1024), "Maximum number of entries in the in-memory cache.")
+	memoryCacheBytes   = flag.Int("memory-cache-bytes", envFlagInt("MEMORY_CACHE_BYTES", 32<<20), "Maximum total size of the values in the in-memory cache; values larger than an eighth of it are not cached.")
+
+	responseCacheTTLSpec = flag.String("response-cache-ttls", envFlagString("RESPONSE_CACHE_TTLS", "search=5m,docs=5m,git-refs=1m,benchmarks=1m,status=15s,og=1h"), "Per-route TTLs of generated responses cached in memory, as route=duration pairs.")
+	responseCacheBytes   = flag.Int("response-cache-bytes", envFlagInt("RESPONSE_CACHE_BYTES", 16<<20), "Maximum total size of cached generated responses; 0 disables the response cache.")
 )
 
+// sharedCache caches data fetched from upstreams. It is shared by all
+// instances when backed by Redis.
+var sharedCache cache
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		if err := runBuild(os.Args[2:]); err != nil {
+			log.Fatalf("Error building site: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "manifest" {
+		if err := runManifest(os.Args[2:]); err != nil {
+			log.Fatalf("Error writing manifest: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "g3doc" {
+		if err := runG3doc(os.Args[2:]); err != nil {
+			log.Fatalf("Error syncing docs: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sitemaps" {
+		if err := runSitemaps(os.Args[2:]); err != nil {
+			log.Fatalf("Error writing sitemaps: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "content-version" {
+		if err := runContentVersion(os.Args[2:]); err != nil {
+			log.Fatalf("Error writing content version: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := runLoadTest(os.Args[2:]); err != nil {
+			log.Fatalf("Error running load test: %v", err)
+		}
+		return
+	}
 	flag.Parse()
 
-	registerRedirects(nil)
-	registerRebuild(nil)
-	registerStatic(nil, *staticDir)
+	ctx := context.Background()
+	var err error
+	deployProfile, err = selectProfile(*profileName, explicitBool("noindex", "NOINDEX", noindex), explicitBool("enable-rebuild", "ENABLE_REBUILD", enableRebuild), explicitBool("debug-endpoints", "DEBUG_ENDPOINTS", debugEndpoints))
+	if err != nil {
+		log.Fatalf("Error selecting profile: %v", err)
+	}
+	log.Printf("Using the %s profile: noindex=%t rebuild=%t debug=%t future=%t", deployProfile.name, deployProfile.noindex, deployProfile.rebuild, deployProfile.debug, deployProfile.future)
+	startup := newStartupTimer(processStart)
+	startup.phase("flags", time.Now())
+	if *manifestKey != "" {
+		checkStaticIntegrity(*staticDir, *manifestFile, *manifestSignature, *manifestKey, *manifestAlertOnly)
+		startup.phase("integrity", time.Now())
+	}
+	chaos, err = parseChaos(*chaosSpec)
+	if err != nil {
+		log.Fatalf("Error parsing chaos faults: %v", err)
+	}
+	if chaos != nil && !deployProfile.debug {
+		log.Fatalf("Fault injection with --chaos requires the debug endpoints, which the %s profile disables", deployProfile.name)
+	}
+	if chaos != nil {
+		log.Printf("Injecting faults into upstream requests: %s", *chaosSpec)
+	}
+	upstreams := []string{*gitUpstream, *advisoriesURL, *releasesURL, *meetingsCalendarURL, *canaryUpstream, *shadowUpstream, *analyticsCollectURL, *buildNotifyURL, *subscribeListURL}
+	egress, err = parseEgressPolicy(*egressAllow, append(upstreams, sitemapPingEndpoints()...)...)
+	if err != nil {
+		log.Fatalf("Error parsing egress policy: %v", err)
+	}
+	listenConfigs, err := parseListeners(*listenSpec, *addr)
+	if err != nil {
+		log.Fatalf("Error parsing listeners: %v", err)
+	}
+	accessLogSampleRates, err = parseSampleRates(*accessLogSampleSpec)
+	if err != nil {
+		log.Fatalf("Error parsing access log sample rates: %v", err)
+	}
+	objectives, err := parseSLOs(*sloSpec)
+	if err != nil {
+		log.Fatalf("Error parsing SLOs: %v", err)
+	}
+	if len(objectives) > 0 {
+		slos = newSLOTracker(objectives, *sloWindow)
+	}
+	if *memoryShedPercent > 0 {
+		limit := int64(*memoryLimitMB) << 20
+		if limit == 0 {
+			limit = cgroupMemoryLimit()
+		}
+		if limit > 0 {
+			memory = &memoryMonitor{limit: limit, threshold: float64(*memoryShedPercent) / 100}
+			memoryShedRoutes = make(map[string]bool)
+			for _, route := range strings.Split(*memoryShedRouteSpec, ",") {
+				if route = strings.TrimSpace(route); route != "" {
+					memoryShedRoutes[route] = true
+				}
+			}
+			log.Printf("Shedding %s requests above %d%% of the %d MiB memory limit", *memoryShedRouteSpec, *memoryShedPercent, limit>>20)
+		}
+	}
+	concurrencyLimits, err = parseLimits(*concurrencyLimitSpec)
+	if err != nil {
+		log.Fatalf("Error parsing concurrency limits: %v", err)
+	}
+	bodyLimits, err = parseLimits(*bodyLimitSpec)
+	if err != nil {
+		log.Fatalf("Error parsing body limits: %v", err)
+	}
+	deniedClasses, err = parseClassRoutes(*deniedClassSpec)
+	if err != nil {
+		log.Fatalf("Error parsing denied classes: %v", err)
+	}
+	classLimiters, err = newLimiters(*classRateLimitSpec)
+	if err != nil {
+		log.Fatalf("Error parsing class rate limits: %v", err)
+	}
+	datacenterASNs = parseASNs(*datacenterASNSpec)
+	strictLimiters, err = newLimiters(*strictRateLimitSpec)
+	if err != nil {
+		log.Fatalf("Error parsing strict rate limits: %v", err)
+	}
+	if *abuseThreshold > 0 {
+		offenders = newOffenderTracker(*abuseThreshold, *abuseHalfLife, *abuseBlockFor, strings.Split(*abuseProbes, ","))
+	}
+	sharedCache, err = newCache(*cacheSpec)
+	if err != nil {
+		log.Fatalf("Error creating cache: %v", err)
+	}
+	responseCacheTTLs, err = parseTTLs(*responseCacheTTLSpec)
+	if err != nil {
+		log.Fatalf("Error parsing response cache TTLs: %v", err)
+	}
+	if *responseCacheBytes > 0 {
+		responseCache = newMemoryCache(*memoryCacheEntries, *responseCacheBytes)
+	}
+	expected, err := loadExpectedContentVersion(*contentVersion, *contentVersionFile)
+	if err != nil {
+		log.Fatalf("Error loading the expected content version: %v", err)
+	}
+	readiness = &contentReadiness{staticDir: *staticDir, expected: expected}
+	if deployProfile.rebuild {
+		g3docSync, err = newG3docSyncer(*g3docVersionFile, *g3docBranch)
+		if err != nil {
+			log.Fatalf("Error loading the synced docs version: %v", err)
+		}
+	}
+	startup.phase("config", time.Now())
+	dynamic, err := newDynamicRedirects(ctx, *redirectStore)
+	if err != nil {
+		log.Fatalf("Error creating redirect store: %v", err)
+	}
+	if err := dynamic.sync(ctx); err != nil {
+		log.Printf("Error loading dynamic redirects: %v", err)
+	}
+	go dynamic.syncLoop(ctx, *redirectSyncInterval)
+	if *redirectCheckInterval > 0 {
+		targetChecker = newRedirectChecker(dynamic)
+		go redirectCheckLoop(ctx, targetChecker, *redirectCheckInterval)
+	}
+	banner, err := newAnnouncements(ctx, *announcementStore)
+	if err != nil {
+		log.Fatalf("Error creating announcement store: %v", err)
+	}
+	if err := banner.sync(ctx); err != nil {
+		log.Printf("Error loading announcement: %v", err)
+	}
+	go banner.syncLoop(ctx, *redirectSyncInterval)
+	startup.phase("sync", time.Now())
+	if *fingerprintAssets {
+		htmlFilters = append(htmlFilters, assetFilter(*staticDir))
+	}
+	if *subresourceIntegrity {
+		thirdParty, err := loadSRIHashes(*sriConfig)
+		if err != nil {
+			log.Fatalf("Error loading SRI config: %v", err)
+		}
+		htmlFilters = append(htmlFilters, sriFilter(*staticDir, thirdParty))
+	}
+	features, err = loadFeatures(*featureConfig)
+	if err != nil {
+		log.Fatalf("Error loading feature flags: %v", err)
+	}
+	synonyms, err := loadSearchSynonyms(*searchSynonymsConfig)
+	if err != nil {
+		log.Fatalf("Error loading search synonyms: %v", err)
+	}
+	searchSynonyms = newSynonyms(synonyms)
+	editSources, err = loadEditSources(*editLinkConfig)
+	if err != nil {
+		log.Fatalf("Error loading edit link config: %v", err)
+	}
+	experiments, err = loadExperiments(*experimentConfig)
+	if err != nil {
+		log.Fatalf("Error loading experiments: %v", err)
+	}
+	builtinRules := []*rewriteRule{structuredDataRule(*staticDir), bannerRule(banner)}
+	builtinRules = append(builtinRules, socialCardRules(*staticDir)...)
+	builtinRules = append(builtinRules, printRules()...)
+	if len(experiments) > 0 {
+		builtinRules = append(builtinRules, experimentsRule())
+	}
+	rewriteRules, err = loadRewriteRules(*rewriteConfig, builtinRules...)
+	if err != nil {
+		log.Fatalf("Error loading rewrite rules: %v", err)
+	}
+	startup.phase("filters", time.Now())
+	if *enableGitProxy && *gitRefsRefresh > 0 {
+		go refreshRefsLoop(ctx, *gitRefsRefresh)
+	}
+	if slos != nil && *sloCheckInterval > 0 {
+		go sloCheckLoop(ctx, slos, *sloCheckInterval)
+	}
+	if memory != nil {
+		go memoryMonitorLoop(ctx, memory, *memoryCheckInterval)
+	}
+	if *watchdogInterval > 0 {
+		caches := make(map[string]*memoryCache)
+		if c, ok := sharedCache.(*memoryCache); ok {
+			caches["shared"] = c
+		}
+		if c, ok := responseCache.(*memoryCache); ok {
+			caches["response"] = c
+		}
+		go watchdogLoop(ctx, newWatchdog(*watchdogMaxGoroutines, *watchdogMaxUpstreamConns, caches), *watchdogInterval)
+	}
+	if *enableStatus && *buildMetricsInterval > 0 {
+		go buildMetricsLoop(ctx, *buildMetricsInterval)
+	}
+
+	canary, err := newCanary(*canaryStaticDir, *canaryUpstream)
+	if err != nil {
+		log.Fatalf("Error creating canary: %v", err)
+	}
+	shadow, err = newShadowMirror(*shadowUpstream, *shadowFraction, *shadowRoutes, *shadowMaxInFlight)
+	if err != nil {
+		log.Fatalf("Error creating shadow mirror: %v", err)
+	}
 
-	log.Printf("Listening on %s...", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	// Stores of disabled subsystems are kept in memory, so that no
+	// credentials are needed for them.
+	if !*enableBenchmarks {
+		*benchmarkStoreType = "memory"
+	}
+	benchmarks, err := newBenchmarkStore(ctx, *benchmarkStoreType)
+	if err != nil {
+		log.Fatalf("Error creating benchmark store: %v", err)
+	}
+
+	if !*enableFeedback {
+		*feedbackStoreType = "memory"
+	}
+	feedback, err := newFeedbackStore(ctx, *feedbackStoreType)
+	if err != nil {
+		log.Fatalf("Error creating feedback store: %v", err)
+	}
+
+	var subs *subscriptions
+	if *subscribeProvider != "" {
+		if *subscribeSecret == "" {
+			log.Fatalf("Announce-list signups with --subscribe-provider require --subscribe-secret")
+		}
+		list, err := newMailingList(ctx, *subscribeProvider, *subscribeListURL)
+		if err != nil {
+			log.Fatalf("Error creating mailing list: %v", err)
+		}
+		m, err := newSMTPMailer(*subscribeSMTPURL, *subscribeFrom)
+		if err != nil {
+			log.Fatalf("Error creating mailer: %v", err)
+		}
+		subs = &subscriptions{list: list, mail: m, secret: []byte(*subscribeSecret)}
+	}
+
+	var previews *previewStore
+	if *previewBucket != "" {
+		previews, err = newPreviewStore(ctx, *previewBucket, *previewPrefix, *previewTTL)
+		if err != nil {
+			log.Fatalf("Error creating preview store: %v", err)
+		}
+	}
+	if *signingAccount != "" {
+		signer, err = newURLSigner(ctx, *signingAccount, *signedBuckets, *signedPrefixes, *signedURLTTL)
+		if err != nil {
+			log.Fatalf("Error creating URL signer: %v", err)
+		}
+	}
+
+	startup.phase("stores", time.Now())
+
+	// Build the static manifest and search index now rather than on the
+	// first requests, unless the static content is still being synced, in
+	// which case the manifest is built once it is ready and the index on
+	// the first search after that.
+	ready, _, _ := readiness.check(time.Now())
+	startup.phase("static-manifest", time.Now())
+	if ready {
+		getSearchIndex(*staticDir)
+		startup.phase("search-index", time.Now())
+	}
+
+	registerSite(nil, *staticDir, &site{
+		dynamic:       dynamic,
+		banner:        banner,
+		benchmarks:    benchmarks,
+		feedback:      feedback,
+		views:         newPageViews(),
+		previews:      previews,
+		canary:        canary,
+		subscriptions: subs,
+	})
+
+	startup.phase("register", time.Now())
+
+	ls, err := listen(listenConfigs)
+	if err != nil {
+		log.Fatalf("Error listening: %v", err)
+	}
+	startup.phase("listen", time.Now())
+	startup.report(*startupBudget)
+	log.Fatal(serve(listenConfigs, ls, http.DefaultServeMux))
 }
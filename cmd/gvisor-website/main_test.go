@@ -0,0 +1,114 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFlattenRedirects(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   map[string]string
+		want map[string]string
+	}{
+		{
+			name: "direct",
+			in:   map[string]string{"/a": "/x", "/b": "https://example.com/"},
+			want: map[string]string{"/a": "/x", "/b": "https://example.com/"},
+		},
+		{
+			name: "chain",
+			in:   map[string]string{"/a": "/b", "/b": "/c", "/c": "/d"},
+			want: map[string]string{"/a": "/d", "/b": "/d", "/c": "/d"},
+		},
+		{
+			name: "loop",
+			in:   map[string]string{"/a": "/b", "/b": "/a"},
+			want: map[string]string{"/a": "/b", "/b": "/a"},
+		},
+		{
+			name: "into loop",
+			in:   map[string]string{"/x": "/a", "/a": "/b", "/b": "/a"},
+			want: map[string]string{"/x": "/a", "/a": "/b", "/b": "/a"},
+		},
+		{
+			name: "self",
+			in:   map[string]string{"/a": "/a"},
+			want: map[string]string{"/a": "/a"},
+		},
+	} {
+		if got := flattenRedirects(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: flattenRedirects = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSiteRedirectsAreFlat(t *testing.T) {
+	flat := flattenRedirects(redirects)
+	for path, target := range flat {
+		if _, ok := flat[target]; ok {
+			t.Errorf("redirect %q -> %q chains or loops", path, target)
+		}
+	}
+}
+
+func TestGoGetStub(t *testing.T) {
+	h := wrappedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("site"))
+	}))
+	for _, method := range []string{"GET", "HEAD"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/pkg/sentry/kernel?go-get=1", nil))
+		hdr := w.Header()
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d, want 200", method, w.Code)
+		}
+		for name, want := range map[string]string{
+			"Content-Type":   "text/html; charset=utf-8",
+			"Content-Length": strconv.Itoa(len(goGetHTML5)),
+			"Cache-Control":  "public, max-age=86400",
+			"X-Robots-Tag":   "noindex",
+		} {
+			if got := hdr.Get(name); got != want {
+				t.Errorf("%s: got %s %q, want %q", method, name, got, want)
+			}
+		}
+		body := w.Body.String()
+		if method == "HEAD" {
+			if body != "" {
+				t.Errorf("HEAD: got body %q, want none", body)
+			}
+			continue
+		}
+		if !strings.Contains(body, goGetHeader) || !strings.Contains(body, `<meta name="robots" content="noindex">`) {
+			t.Errorf("GET: got body %q, want the go-import and noindex meta tags", body)
+		}
+	}
+
+	// Other requests fall through.
+	for _, target := range []string{"/pkg/sentry/kernel", "/?go-get=0", "/?go-get=1&go-get=1"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Body.String() != "site" || w.Header().Get("Cache-Control") != "" {
+			t.Errorf("GET %s: got body %q and Cache-Control %q, want the site", target, w.Body, w.Header().Get("Cache-Control"))
+		}
+	}
+}
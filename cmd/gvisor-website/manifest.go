@@ -0,0 +1,156 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// staticManifest maps the URL path of each file in the static dir to a hash
+// of its contents.
+type staticManifest struct {
+	// Version is a hash over all files, which changes whenever any file
+	// changes.
+	Version string `json:"version"`
+
+	// Files maps URL paths to content hashes. Pages are listed under
+	// their directory path, as they are served.
+	Files map[string]string `json:"files"`
+}
+
+// contentHash returns the hash used for the given contents: the first 16 hex
+// digits of the SHA-256.
+func contentHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// buildStaticManifest hashes all files in the static dir. Error page
+// templates are skipped, since they are never served directly.
+func buildStaticManifest(staticDir string) (*staticManifest, error) {
+	m := &staticManifest{Files: make(map[string]string)}
+	err := filepath.Walk(staticDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staticDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if rel == errorPageDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hash, err := contentHash(f)
+		if err != nil {
+			return err
+		}
+		url := "/" + rel
+		if path := strings.TrimSuffix(url, "index.html"); path != url && strings.HasSuffix(path, "/") {
+			url = path
+		}
+		m.Files[url] = hash
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Hash the sorted listing for the version.
+	var listing bytes.Buffer
+	paths := make([]string, 0, len(m.Files))
+	for p := range m.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		listing.WriteString(p + " " + m.Files[p] + "\n")
+	}
+	m.Version, _ = contentHash(&listing)
+	return m, nil
+}
+
+var (
+	manifestsMu sync.Mutex
+	manifests   = make(map[string]*staticManifest)
+)
+
+// getStaticManifest returns the manifest of the static dir, building it on
+// first use. There is more than one static dir while a canary build is
+// served.
+func getStaticManifest(staticDir string) *staticManifest {
+	manifestsMu.Lock()
+	defer manifestsMu.Unlock()
+	if m, ok := manifests[staticDir]; ok {
+		return m
+	}
+	m, err := buildStaticManifest(staticDir)
+	if err != nil {
+		log.Printf("Error building static manifest: %v", err)
+	}
+	manifests[staticDir] = m
+	return m
+}
+
+// setStaticManifest replaces the manifest of the static dir.
+func setStaticManifest(staticDir string, m *staticManifest) {
+	manifestsMu.Lock()
+	defer manifestsMu.Unlock()
+	manifests[staticDir] = m
+}
+
+// precacheManifestHandler serves the static manifest, so that a service
+// worker can precache the site for offline use and invalidate exactly the
+// files whose contents changed.
+func precacheManifestHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := getStaticManifest(staticDir)
+		if m == nil {
+			httpError(w, r, "manifest is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			httpError(w, r, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"`+m.Version+`"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContentHash(t *testing.T) {
+	// The first 16 hex digits of the SHA-256 of "hello".
+	if got, err := contentHash(strings.NewReader("hello")); err != nil || got != "2cf24dba5fb0a30e" {
+		t.Errorf("contentHash(hello) = %q, %v, want 2cf24dba5fb0a30e", got, err)
+	}
+}
+
+func TestBuildStaticManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"index.html":      "home",
+		"docs/index.html": "docs",
+		"docs/faq.html":   "faq",
+		"css/main.css":    "body{}",
+		"errors/5xx.html": "error",
+	})
+
+	m, err := buildStaticManifest(dir)
+	if err != nil {
+		t.Fatalf("buildStaticManifest failed: %v", err)
+	}
+	hash := func(s string) string {
+		h, _ := contentHash(strings.NewReader(s))
+		return h
+	}
+	want := map[string]string{
+		"/":              hash("home"),
+		"/docs/":         hash("docs"),
+		"/docs/faq.html": hash("faq"),
+		"/css/main.css":  hash("body{}"),
+	}
+	if !reflect.DeepEqual(m.Files, want) {
+		t.Errorf("got files %v, want %v without the error pages", m.Files, want)
+	}
+
+	// The version is stable, and changes with the contents of any file.
+	m2, err := buildStaticManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Version) != 16 || m2.Version != m.Version {
+		t.Errorf("got versions %q and %q of the same dir, want the same hash", m.Version, m2.Version)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "css", "main.css"), []byte("body{margin:0}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m3, err := buildStaticManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m3.Version == m.Version || m3.Files["/css/main.css"] == m.Files["/css/main.css"] || m3.Files["/"] != m.Files["/"] {
+		t.Errorf("changed file: got version %q and files %v, want a new version and hash of the file only", m3.Version, m3.Files)
+	}
+
+	// Error page templates don't change the version.
+	if err := ioutil.WriteFile(filepath.Join(dir, "errors", "5xx.html"), []byte("new error"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m4, err := buildStaticManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m4.Version != m3.Version {
+		t.Errorf("changed error page: got version %q, want %q", m4.Version, m3.Version)
+	}
+
+	if _, err := buildStaticManifest(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("buildStaticManifest of a missing dir succeeded")
+	}
+}
+
+func TestPrecacheManifestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{"index.html": "home"})
+	defer setStaticManifest(dir, nil)
+
+	h := precacheManifestHandler(dir)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/precache-manifest.json", nil))
+	var m staticManifest
+	if err := json.NewDecoder(w.Body).Decode(&m); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if w.Code != http.StatusOK || len(m.Files) != 1 || m.Version == "" {
+		t.Errorf("got status %d and manifest %+v, want the manifest", w.Code, m)
+	}
+	etag := w.Header().Get("ETag")
+	if etag != `"`+m.Version+`"` || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("got ETag %q and Cache-Control %q, want the version and no-cache", etag, w.Header().Get("Cache-Control"))
+	}
+
+	r := httptest.NewRequest("GET", "/precache-manifest.json", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation: got status %d, want 304", w.Code)
+	}
+
+	// A failed build leaves the manifest unavailable.
+	setStaticManifest(dir, nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/precache-manifest.json", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a manifest: got status %d, want 503", w.Code)
+	}
+}
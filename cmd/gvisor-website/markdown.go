@@ -0,0 +1,135 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	markdownOnce  sync.Once
+	markdownPages map[string]string
+)
+
+// markdownPath returns the Markdown source for the page at the given URL
+// path, or "" if there is none or it is dated in the future. Hugo derives
+// page URLs from the content file paths, lowercased, with _index.md and
+// index.md serving their directory.
+func markdownPath(urlPath string) string {
+	markdownOnce.Do(func() {
+		markdownPages = make(map[string]string)
+		err := filepath.Walk(*contentDir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(p) != ".md" {
+				return err
+			}
+			rel, err := filepath.Rel(*contentDir, p)
+			if err != nil {
+				return err
+			}
+			page := strings.TrimSuffix(filepath.ToSlash(rel), ".md")
+			switch path.Base(page) {
+			case "_index", "index":
+				page = path.Dir(page)
+			}
+			page = strings.ToLower(path.Clean("/" + page))
+			if page != "/" {
+				page += "/"
+			}
+			markdownPages[page] = p
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error indexing Markdown sources: %v", err)
+		}
+	})
+	if src := markdownPages[urlPath]; src != "" && !futureContent(src) {
+		return src
+	}
+	return ""
+}
+
+// markdownPage returns the URL path of the page whose source is requested by
+// the given .md URL path.
+func markdownPage(urlPath string) string {
+	page := path.Clean(strings.ToLower(strings.TrimSuffix(urlPath, ".md")))
+	switch path.Base(page) {
+	case "_index", "index":
+		page = path.Dir(page)
+	}
+	if page != "/" {
+		page += "/"
+	}
+	return page
+}
+
+// markdownURL returns the .md URL path of the source of the page at the given
+// URL path. The root has no name of its own, so its source is /_index.md.
+func markdownURL(urlPath string) string {
+	if urlPath == "/" {
+		return "/_index.md"
+	}
+	return strings.TrimSuffix(urlPath, "/") + ".md"
+}
+
+// serveMarkdown serves the Markdown source at the given path.
+func serveMarkdown(w http.ResponseWriter, r *http.Request, src string) {
+	f, err := os.Open(src)
+	if err != nil {
+		httpError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		httpError(w, r, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// markdownHandler serves the Markdown source of pages instead of the rendered
+// HTML when the request path has a .md suffix (e.g. /docs/user_guide/faq.md)
+// or the client sends Accept: text/markdown. Rendered pages that have a
+// Markdown source advertise it in a Link header.
+func markdownHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".md") {
+			if src := markdownPath(markdownPage(r.URL.Path)); src != "" {
+				serveMarkdown(w, r, src)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		src := markdownPath(r.URL.Path)
+		if src == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if negotiate(w, r, "Accept", "text/markdown") != "" {
+			serveMarkdown(w, r, src)
+			return
+		}
+		w.Header().Add("Link", "<"+markdownURL(r.URL.Path)+">; rel=\"alternate\"; type=\"text/markdown\"")
+		h.ServeHTTP(w, r)
+	})
+}
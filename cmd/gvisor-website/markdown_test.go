@@ -0,0 +1,45 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestMarkdownURLs(t *testing.T) {
+	for _, tc := range []struct {
+		page string
+		url  string
+	}{
+		{"/", "/_index.md"},
+		{"/docs/", "/docs.md"},
+		{"/docs/user_guide/faq/", "/docs/user_guide/faq.md"},
+	} {
+		if got := markdownURL(tc.page); got != tc.url {
+			t.Errorf("markdownURL(%q) = %q, want %q", tc.page, got, tc.url)
+		}
+		if got := markdownPage(tc.url); got != tc.page {
+			t.Errorf("markdownPage(%q) = %q, want %q", tc.url, got, tc.page)
+		}
+	}
+	for url, want := range map[string]string{
+		"/index.md":               "/",
+		"/docs/_index.md":         "/docs/",
+		"/Docs/User_Guide/FAQ.md": "/docs/user_guide/faq/",
+		"/docs/../docs.md":        "/docs/",
+	} {
+		if got := markdownPage(url); got != want {
+			t.Errorf("markdownPage(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// memoryRecoveryMargin is how far below the shedding threshold, as a fraction
+// of the limit, memory use must fall before shedding stops, so that shedding
+// doesn't flap around the threshold.
+const memoryRecoveryMargin = 0.05
+
+var (
+	memoryUsedGauge     = newGauge("process_memory_used_bytes", "Memory used by the process, as checked against the memory limit.")
+	memoryPressureGauge = newGauge("memory_pressure", "Whether expensive requests are being shed because memory use is near the limit.")
+)
+
+// cgroupMemoryLimit returns the memory limit of the container, or 0 if there
+// is none or it can't be read. Both cgroup v2 and v1 are supported.
+func cgroupMemoryLimit() int64 {
+	for _, file := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 64)
+		// "max", or a huge v1 value, means unlimited.
+		if err != nil || n <= 0 || n >= 1<<60 {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
+
+// memoryUsed returns the resident set size of the process, or if it can't be
+// read the memory obtained from the OS by the Go runtime.
+func memoryUsed() int64 {
+	if b, err := ioutil.ReadFile("/proc/self/statm"); err == nil {
+		if f := strings.Fields(string(b)); len(f) > 1 {
+			if pages, err := strconv.ParseInt(f[1], 10, 64); err == nil {
+				return pages * int64(os.Getpagesize())
+			}
+		}
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.Sys)
+}
+
+// memoryMonitor tracks whether memory use is close enough to the limit that
+// expensive requests should be shed: an instance that sheds some requests
+// keeps serving the rest, while one that is OOM-killed drops all of them.
+type memoryMonitor struct {
+	limit     int64
+	threshold float64
+
+	// pressure is 1 while requests are shed.
+	pressure int32
+}
+
+// memory is nil unless a memory limit is set or detected.
+var memory *memoryMonitor
+
+// shedding returns whether expensive requests are being shed.
+func (m *memoryMonitor) shedding() bool {
+	return m != nil && atomic.LoadInt32(&m.pressure) == 1
+}
+
+// update updates the pressure for the given memory use.
+func (m *memoryMonitor) update(used int64) {
+	memoryUsedGauge.set(float64(used))
+	frac := float64(used) / float64(m.limit)
+	switch {
+	case !m.shedding() && frac >= m.threshold:
+		atomic.StoreInt32(&m.pressure, 1)
+		memoryPressureGauge.set(1)
+		log.Printf("Memory use %d of %d bytes is over %.0f%% of the limit; shedding expensive requests", used, m.limit, m.threshold*100)
+	case m.shedding() && frac < m.threshold-memoryRecoveryMargin:
+		atomic.StoreInt32(&m.pressure, 0)
+		memoryPressureGauge.set(0)
+		log.Printf("Memory use %d of %d bytes is back to normal; no longer shedding requests", used, m.limit)
+	}
+}
+
+// memoryMonitorLoop checks memory use every interval until the context is
+// done.
+func memoryMonitorLoop(ctx context.Context, m *memoryMonitor, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.update(memoryUsed())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// memoryShedRoutes are the routes whose requests are shed under memory
+// pressure, set from flags at startup.
+var memoryShedRoutes map[string]bool
+
+// memoryPressureHandler rejects requests to expensive routes with 503 Service
+// Unavailable while memory use is near the limit.
+func memoryPressureHandler(route string, h http.Handler) http.Handler {
+	if !memoryShedRoutes[route] {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if memory.shedding() {
+			requestsShed.inc(route, "memory")
+			w.Header().Set("Retry-After", "30")
+			httpError(w, r, "Server busy, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMemoryMonitor(t *testing.T) {
+	m := &memoryMonitor{limit: 1000, threshold: 0.8}
+	for _, tc := range []struct {
+		used int64
+		want bool
+	}{
+		{500, false},
+		{800, true},
+		{760, true},
+		{749, false},
+		{790, false},
+		{1200, true},
+	} {
+		m.update(tc.used)
+		if got := m.shedding(); got != tc.want {
+			t.Errorf("shedding at %d = %v, want %v", tc.used, got, tc.want)
+		}
+	}
+	var none *memoryMonitor
+	if none.shedding() {
+		t.Errorf("nil monitor sheds requests")
+	}
+}
+
+func TestMemoryPressureHandler(t *testing.T) {
+	defer func(m *memoryMonitor, routes map[string]bool) {
+		memory, memoryShedRoutes = m, routes
+	}(memory, memoryShedRoutes)
+	memory = &memoryMonitor{limit: 1000, threshold: 0.8}
+	memoryShedRoutes = map[string]bool{"search": true}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	search, docs := memoryPressureHandler("search", ok), memoryPressureHandler("docs", ok)
+
+	for _, tc := range []struct {
+		used   int64
+		h      http.Handler
+		status int
+	}{
+		{100, search, 200},
+		{900, search, 503},
+		{900, docs, 200},
+	} {
+		memory.update(tc.used)
+		rec := httptest.NewRecorder()
+		tc.h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != tc.status {
+			t.Errorf("status at %d bytes = %d, want %d", tc.used, rec.Code, tc.status)
+		}
+	}
+}
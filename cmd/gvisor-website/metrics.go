@@ -0,0 +1,217 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a named family of values exported in the Prometheus text format.
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsMu  sync.Mutex
+	allMetrics []metric
+)
+
+// register adds a metric to the exported set.
+func register(m metric) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	allMetrics = append(allMetrics, m)
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\x00")
+}
+
+// formatLabels formats the given label names and values.
+func formatLabels(names []string, key string, extra ...string) string {
+	var values []string
+	if len(names) > 0 {
+		values = strings.Split(key, "\x00")
+	}
+	var parts []string
+	for i, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// sortedKeys returns the keys of the given map in order.
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueVec is a set of values partitioned by labels.
+type valueVec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// newValueVec returns a new registered valueVec of the given kind.
+func newValueVec(kind, name, help string, labels ...string) *valueVec {
+	v := &valueVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]float64),
+	}
+	register(v)
+	return v
+}
+
+// newCounter returns a new registered counter.
+func newCounter(name, help string, labels ...string) *valueVec {
+	return newValueVec("counter", name, help, labels...)
+}
+
+// newGauge returns a new registered gauge.
+func newGauge(name, help string, labels ...string) *valueVec {
+	return newValueVec("gauge", name, help, labels...)
+}
+
+// inc adds one to the value with the given label values.
+func (v *valueVec) inc(labelValues ...string) {
+	v.add(1, labelValues...)
+}
+
+// add adds delta to the value with the given label values.
+func (v *valueVec) add(delta float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[labelKey(labelValues)] += delta
+}
+
+// set sets the value with the given label values.
+func (v *valueVec) set(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[labelKey(labelValues)] = value
+}
+
+func (v *valueVec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %v\n", v.name, formatLabels(v.labels, key), v.values[key])
+	}
+}
+
+// defaultBuckets are the default histogram bucket bounds, in seconds.
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogramVec is a set of histograms partitioned by labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts map[string][]uint64
+	sums   map[string]float64
+	totals map[string]float64
+}
+
+// newHistogram returns a new registered histogram with the given bucket
+// upper bounds.
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]float64),
+	}
+	register(h)
+	return h
+}
+
+// observe records a value in the histogram with the given label values.
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			counts[i]++
+		}
+	}
+	h.sums[key] += value
+	h.totals[key]++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.totals) {
+		for i, bound := range h.buckets {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", le), h.counts[key][i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %v\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), h.totals[key])
+		fmt.Fprintf(w, "%s_sum%s %v\n", h.name, formatLabels(h.labels, key), h.sums[key])
+		fmt.Fprintf(w, "%s_count%s %v\n", h.name, formatLabels(h.labels, key), h.totals[key])
+	}
+}
+
+// metricsHandler serves all registered metrics in the Prometheus text format.
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metricsMu.Lock()
+		ms := append([]metric(nil), allMetrics...)
+		metricsMu.Unlock()
+		for _, m := range ms {
+			m.write(w)
+		}
+	})
+}
@@ -0,0 +1,329 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// middleware is a named http.Handler wrapper.
+type middleware struct {
+	name string
+	wrap func(http.Handler) http.Handler
+}
+
+// chain is an ordered list of middleware. The first middleware in the chain
+// is the outermost, i.e. it sees the request first and the response last.
+type chain []middleware
+
+// newChain returns a chain of the given middleware.
+func newChain(m ...middleware) chain {
+	return chain(m)
+}
+
+// append returns a new chain with the given middleware added innermost. The
+// receiver is not modified.
+func (c chain) append(m ...middleware) chain {
+	n := make(chain, 0, len(c)+len(m))
+	n = append(n, c...)
+	return append(n, m...)
+}
+
+// before returns a new chain with the given middleware added just outside the
+// named middleware, or innermost if there is none. The receiver is not
+// modified.
+func (c chain) before(name string, m ...middleware) chain {
+	for i := range c {
+		if c[i].name == name {
+			n := make(chain, 0, len(c)+len(m))
+			n = append(n, c[:i]...)
+			n = append(n, m...)
+			return append(n, c[i:]...)
+		}
+	}
+	return c.append(m...)
+}
+
+// then wraps the given handler with the chain.
+func (c chain) then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i].wrap(h)
+	}
+	return h
+}
+
+// baseChain returns the middleware applied to every route. The route name is
+// used to label logs and metrics.
+func baseChain(route string) chain {
+	return newChain(
+		middleware{"request-id", requestIDHandler},
+		middleware{"classify", func(h http.Handler) http.Handler { return classifyHandler(route, h) }},
+		middleware{"logging", func(h http.Handler) http.Handler { return loggingHandler(route, h) }},
+		middleware{"metrics", func(h http.Handler) http.Handler { return metricsMiddlewareHandler(route, h) }},
+		// Recovery is inside logging and metrics, so that the 500s of
+		// panics are logged and counted.
+		middleware{"recovery", recoveryHandler},
+		middleware{"request-size", func(h http.Handler) http.Handler { return requestSizeHandler(route, h) }},
+		middleware{"class-policy", func(h http.Handler) http.Handler { return classPolicyHandler(route, h) }},
+		middleware{"origin-policy", func(h http.Handler) http.Handler { return originPolicyHandler(route, h) }},
+		middleware{"concurrency-limit", func(h http.Handler) http.Handler { return concurrencyLimitHandler(route, h) }},
+		middleware{"memory-pressure", func(h http.Handler) http.Handler { return memoryPressureHandler(route, h) }},
+		// Abuse blocking is inside the policies, so that clients aren't
+		// scored for the requests they shed.
+		middleware{"abuse-block", func(h http.Handler) http.Handler { return abuseBlockHandler(route, h) }},
+		middleware{"shadow", func(h http.Handler) http.Handler { return shadowHandler(route, h) }},
+		middleware{"features", featuresHandler},
+		middleware{"experiments", experimentsHandler},
+		middleware{"security-headers", securityHeadersHandler},
+		middleware{"noindex", noindexHandler},
+		middleware{"compression", compressionHandler},
+		middleware{"host-redirect", hostRedirectHandler},
+		middleware{"response-cache", func(h http.Handler) http.Handler { return responseCacheHandler(route, h) }},
+	)
+}
+
+// siteChain returns the middleware applied to user-facing site routes.
+func siteChain(route string) chain {
+	return baseChain(route).append(middleware{"go-get", wrappedHandler})
+}
+
+// statusRecorder records the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.size += n
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// code returns the recorded status code.
+func (s *statusRecorder) code() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
+
+// recoveryHandler converts panics in the wrapped handler into 500 responses.
+func recoveryHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Printf("Panic serving %s: %v\n%s", r.URL.Path, err, debug.Stack())
+				httpError(w, r, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// secretParams are query parameters carrying secrets, which are not logged.
+var secretParams = []string{"token"}
+
+// loggedURI returns the request URI with the values of secret query
+// parameters redacted.
+func loggedURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	q := u.Query()
+	redacted := false
+	for _, p := range secretParams {
+		if _, ok := q[p]; ok {
+			q.Set(p, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.RequestURI()
+	}
+	return u.EscapedPath() + "?" + q.Encode()
+}
+
+// loggingHandler logs each request with its route, status and latency.
+func loggingHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !*accessLog {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		verbosity, _ := accessLogVerbosity.get(time.Now())
+		ok, rate := sampleAccessLog(route, rec.code(), verbosity)
+		if !ok {
+			return
+		}
+		line := fmt.Sprintf("%s %s %s route=%s class=%s status=%d size=%d latency=%v", r.RemoteAddr, r.Method, loggedURI(r.URL), route, trafficClass(r), rec.code(), rec.size, time.Since(start))
+		if rate < 1 {
+			line += fmt.Sprintf(" sample=%g", rate)
+		}
+		if verbosity > 1 {
+			line += fmt.Sprintf(" request_id=%s user_agent=%q referer=%q", requestID(r), r.UserAgent(), r.Referer())
+		}
+		log.Print(line)
+	})
+}
+
+var (
+	requestsTotal   = newCounter("http_requests_total", "Total HTTP requests by route and status code.", "route", "code")
+	requestDuration = newHistogram("http_request_duration_seconds", "HTTP request latency by route.", defaultBuckets, "route")
+)
+
+// metricsMiddlewareHandler records request counts and latencies.
+func metricsMiddlewareHandler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		latency := time.Since(start)
+		requestsTotal.inc(route, strconv.Itoa(rec.code()))
+		requestDuration.observe(latency.Seconds(), route)
+		slos.record(route, rec.code(), latency, start.Add(latency))
+	})
+}
+
+// securityHeadersHandler sets security-related response headers.
+func securityHeadersHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hdr := w.Header()
+		hdr.Set("Strict-Transport-Security", "max-age=31536000")
+		hdr.Set("X-Content-Type-Options", "nosniff")
+		hdr.Set("X-Frame-Options", "SAMEORIGIN")
+		hdr.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// compressibleTypes are the content type prefixes that are gzipped.
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"application/rss+xml",
+	"image/svg+xml",
+}
+
+// gzipResponseWriter compresses the response body if the response turns out to
+// be compressible, which is only known once the headers are written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	hdr := g.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && hdr.Get("Content-Encoding") == "" && compressible(hdr.Get("Content-Type")) {
+		hdr.Del("Content-Length")
+		hdr.Set("Content-Encoding", "gzip")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher.
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close flushes any compressed data.
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// compressible returns true if the given content type should be gzipped.
+func compressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressionHandler gzips compressible responses for clients that accept it.
+// Range requests are never compressed, since ranges refer to the identity
+// encoding.
+func compressionHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted := negotiate(w, r, "Accept-Encoding", "gzip") != ""
+		if r.Method == "HEAD" || r.Header.Get("Range") != "" || !accepted {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestLoggedURI(t *testing.T) {
+	for in, want := range map[string]string{
+		"/docs/":                             "/docs/",
+		"/search?q=runsc":                    "/search?q=runsc",
+		"/webhook/cloud-builds?token=s3cret": "/webhook/cloud-builds?token=REDACTED",
+		"/x?a=1&token=s3cret&token=again":    "/x?a=1&token=REDACTED",
+	} {
+		u, err := url.Parse(in)
+		if err != nil {
+			t.Fatalf("url.Parse(%q): %v", in, err)
+		}
+		if got := loggedURI(u); got != want {
+			t.Errorf("loggedURI(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestChainBefore(t *testing.T) {
+	var order []string
+	m := func(name string) middleware {
+		return middleware{name, func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}}
+	}
+	c := newChain(m("a"), m("b"), m("c"))
+	for _, tc := range []struct {
+		name string
+		want []string
+	}{
+		{"b", []string{"a", "x", "b", "c"}},
+		{"a", []string{"x", "a", "b", "c"}},
+		{"missing", []string{"a", "b", "c", "x"}},
+	} {
+		order = nil
+		c.before(tc.name, m("x")).then(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if !reflect.DeepEqual(order, tc.want) {
+			t.Errorf("before(%q): got order %q, want %q", tc.name, order, tc.want)
+		}
+	}
+	if len(c) != 3 {
+		t.Errorf("before modified the chain: %d middleware", len(c))
+	}
+}
+
+// metricValue returns the value of the counter or gauge with the given label
+// values. Metrics are global, so tests compare values before and after.
+func metricValue(v *valueVec, labelValues ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[labelKey(labelValues)]
+}
+
+func TestBaseChainRecovery(t *testing.T) {
+	h := baseChain("panic-test").then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	before := metricValue(requestsTotal, "panic-test", "500")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", w.Code)
+	}
+	if got := metricValue(requestsTotal, "panic-test", "500") - before; got != 1 {
+		t.Errorf("got %v more requests counted with code 500, want 1", got)
+	}
+}
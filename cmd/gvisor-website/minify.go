@@ -0,0 +1,205 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxMinifySize is the largest file that is minified; larger files are
+// served as is.
+const maxMinifySize = 1 << 20
+
+var (
+	// rawTextRE matches elements whose contents must be kept verbatim.
+	rawTextRE = regexp.MustCompile(`(?is)<(pre|textarea|script|style)\b.*?</(pre|textarea|script|style)>`)
+
+	// htmlCommentRE matches comments, except conditional comments.
+	htmlCommentRE = regexp.MustCompile(`(?s)<!--[^\[].*?-->`)
+
+	// spaceRE matches runs of whitespace.
+	spaceRE = regexp.MustCompile(`\s+`)
+
+	// cssTokenRE matches CSS strings and comments.
+	cssTokenRE = regexp.MustCompile(`(?s)"(?:\\.|[^"\\])*"|'(?:\\.|[^'\\])*'|/\*.*?\*/`)
+
+	// cssPunctRE matches whitespace around punctuation that never needs it.
+	cssPunctRE = regexp.MustCompile(`\s*([{};,])\s*`)
+)
+
+// collapseSpace replaces each run of whitespace with a single space, or a
+// newline if the run contained one.
+func collapseSpace(b []byte) []byte {
+	return spaceRE.ReplaceAllFunc(b, func(s []byte) []byte {
+		if bytes.IndexByte(s, '\n') >= 0 {
+			return []byte("\n")
+		}
+		return []byte(" ")
+	})
+}
+
+// minifyHTML removes comments and collapses whitespace, leaving preformatted
+// and raw text elements untouched.
+func minifyHTML(b []byte) []byte {
+	var out bytes.Buffer
+	last := 0
+	for _, loc := range rawTextRE.FindAllIndex(b, -1) {
+		out.Write(collapseSpace(htmlCommentRE.ReplaceAll(b[last:loc[0]], nil)))
+		out.Write(b[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	out.Write(collapseSpace(htmlCommentRE.ReplaceAll(b[last:], nil)))
+	return out.Bytes()
+}
+
+// minifyCSS removes comments and unneeded whitespace. Strings are kept
+// verbatim.
+func minifyCSS(b []byte) []byte {
+	var out bytes.Buffer
+	minify := func(s []byte) []byte {
+		return cssPunctRE.ReplaceAll(spaceRE.ReplaceAll(s, []byte(" ")), []byte("$1"))
+	}
+	last := 0
+	for _, loc := range cssTokenRE.FindAllIndex(b, -1) {
+		out.Write(minify(b[last:loc[0]]))
+		if b[loc[0]] != '/' {
+			out.Write(b[loc[0]:loc[1]])
+		} else {
+			// Keep a separator in place of the comment.
+			out.WriteByte(' ')
+		}
+		last = loc[1]
+	}
+	out.Write(minify(b[last:]))
+	return bytes.TrimSpace(out.Bytes())
+}
+
+// minifyJS removes indentation and blank lines. Scripts containing template
+// literals are left alone, since their whitespace may be significant.
+func minifyJS(b []byte) []byte {
+	if bytes.IndexByte(b, '`') >= 0 {
+		return b
+	}
+	var out bytes.Buffer
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// minifiers are the minifiers by file extension.
+var minifiers = map[string]func([]byte) []byte{
+	".html": minifyHTML,
+	".css":  minifyCSS,
+	".js":   minifyJS,
+}
+
+// staticEntry is a minified file in the static cache.
+type staticEntry struct {
+	modTime time.Time
+
+	// size is the size of the original file.
+	size int64
+
+	body []byte
+}
+
+// staticCache holds minified copies of static files, keyed by file name.
+// Entries are revalidated against the file's modification time.
+type staticCache struct {
+	dir http.Dir
+
+	mu      sync.Mutex
+	entries map[string]staticEntry
+}
+
+// get returns the minified contents of the given file, or false if the file
+// can't be minified.
+func (c *staticCache) get(name string) (staticEntry, bool) {
+	minify, ok := minifiers[path.Ext(name)]
+	if !ok || strings.Contains(path.Base(name), ".min.") {
+		return staticEntry{}, false
+	}
+	f, err := c.dir.Open(name)
+	if err != nil {
+		return staticEntry{}, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() || info.Size() > maxMinifySize {
+		return staticEntry{}, false
+	}
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && e.modTime.Equal(info.ModTime()) {
+		return e, true
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return staticEntry{}, false
+	}
+	e = staticEntry{modTime: info.ModTime(), size: info.Size(), body: minify(b)}
+	c.mu.Lock()
+	c.entries[name] = e
+	c.mu.Unlock()
+	return e, true
+}
+
+var minifiedBytesSaved = newCounter("static_minify_saved_bytes_total", "Bytes saved by minifying static files.")
+
+// minifyHandler serves HTML, CSS and JS files from the static dir minified.
+// Other files, and requests the file server must redirect, fall through.
+func minifyHandler(staticDir string, h http.Handler) http.Handler {
+	c := &staticCache{dir: http.Dir(staticDir), entries: make(map[string]staticEntry)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := r.URL.Path
+		if strings.HasSuffix(name, "/index.html") {
+			// Redirected to the directory by the file server.
+			h.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		e, ok := c.get(name)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			minifiedBytesSaved.add(float64(e.size - int64(len(e.body))))
+		}
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+		http.ServeContent(w, r, name, e.modTime, bytes.NewReader(e.body))
+	})
+}
@@ -0,0 +1,43 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestMinifiers(t *testing.T) {
+	for _, tc := range []struct {
+		ext  string
+		in   string
+		want string
+	}{
+		{".html", "<p>a   b</p>\n\n  <p>c</p>", "<p>a b</p>\n<p>c</p>"},
+		{".html", "a<!-- x -->b", "ab"},
+		{".html", "<!--[if IE]>x<![endif]-->", "<!--[if IE]>x<![endif]-->"},
+		{".html", "<pre>  a\n  b</pre>  x", "<pre>  a\n  b</pre> x"},
+		{".html", "<script>// <!-- x -->\n  y</script>\t<STYLE>a  {}</STYLE>", "<script>// <!-- x -->\n  y</script> <STYLE>a  {}</STYLE>"},
+		{".html", "<textarea>\n\n</textarea>", "<textarea>\n\n</textarea>"},
+		{".css", "a {\n  color: red;\n}\n", "a{color: red;}"},
+		{".css", "a/* x */b", "a b"},
+		{".css", `a::before { content: "  ;  " }`, `a::before{content: "  ;  "}`},
+		{".css", `b::after { content: '/* no */' }`, `b::after{content: '/* no */'}`},
+		{".css", "a, b {x:y}", "a,b{x:y}"},
+		{".js", "  a();\n\n    b();\n", "a();\nb();\n"},
+		{".js", "const s = `a\n  b`;\n", "const s = `a\n  b`;\n"},
+	} {
+		if got := string(minifiers[tc.ext]([]byte(tc.in))); got != tc.want {
+			t.Errorf("minify %s %q = %q, want %q", tc.ext, tc.in, got, tc.want)
+		}
+	}
+}
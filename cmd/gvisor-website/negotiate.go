@@ -0,0 +1,89 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content negotiation is done by several middlewares on the same response.
+// Each must declare the request headers it reads in Vary, even when it serves
+// the default representation, or shared caches would serve one client's
+// representation to another. Handlers negotiate through negotiate, which
+// does both, and add other request headers they read with addVary.
+
+// addVary adds the given request header names to the Vary header, keeping a
+// single, duplicate-free Vary header.
+func addVary(h http.Header, names ...string) {
+	var vary []string
+	seen := make(map[string]bool)
+	for _, name := range append(varyHeaders(h), names...) {
+		name = http.CanonicalHeaderKey(name)
+		if !seen[name] {
+			seen[name] = true
+			vary = append(vary, name)
+		}
+	}
+	if seen["*"] {
+		vary = []string{"*"}
+	}
+	h.Set("Vary", strings.Join(vary, ", "))
+}
+
+// acceptQuality returns the quality the client gives the value in the given
+// Accept-style request header, e.g. Accept or Accept-Encoding, or 0 if it
+// isn't listed. Only explicitly listed values count: clients accepting */*
+// don't necessarily support every image format or encoding.
+func acceptQuality(r *http.Request, header, value string) float64 {
+	for _, part := range strings.Split(strings.Join(r.Header[header], ","), ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), value) {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		return q
+	}
+	return 0
+}
+
+// accepts returns true if the client accepts the value in the given request
+// header. Handlers whose response depends on it should use negotiate.
+func accepts(r *http.Request, header, value string) bool {
+	return acceptQuality(r, header, value) > 0
+}
+
+// negotiate returns the offer the client prefers in the given request
+// header, or "" if it accepts none of them. Ties are broken by the order of
+// the offers. The header is added to Vary whatever the result.
+func negotiate(w http.ResponseWriter, r *http.Request, header string, offers ...string) string {
+	addVary(w.Header(), header)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(r, header, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAddVary(t *testing.T) {
+	for _, tc := range []struct {
+		header http.Header
+		names  []string
+		want   string
+	}{
+		{http.Header{}, []string{"Accept"}, "Accept"},
+		{http.Header{"Vary": {"Accept-Encoding"}}, []string{"accept", "Accept-Encoding"}, "Accept-Encoding, Accept"},
+		{http.Header{"Vary": {"Accept", "Cookie, X-Canary"}}, []string{"Cookie"}, "Accept, Cookie, X-Canary"},
+		{http.Header{"Vary": {"*"}}, []string{"Accept"}, "*"},
+	} {
+		addVary(tc.header, tc.names...)
+		if got := tc.header["Vary"]; len(got) != 1 || got[0] != tc.want {
+			t.Errorf("addVary(%v) set Vary %q, want %q", tc.names, got, tc.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		offers []string
+		want   string
+	}{
+		{"", []string{"image/avif"}, ""},
+		{"*/*", []string{"image/avif"}, ""},
+		{"image/avif,image/webp,*/*;q=0.8", []string{"image/avif", "image/webp"}, "image/avif"},
+		{"image/webp", []string{"image/avif", "image/webp"}, "image/webp"},
+		{"image/avif;q=0.5, image/webp", []string{"image/avif", "image/webp"}, "image/webp"},
+		{"image/avif;q=0, image/webp;q=0", []string{"image/avif", "image/webp"}, ""},
+		{"Text/Markdown", []string{"text/markdown"}, "text/markdown"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tc.accept != "" {
+			r.Header.Set("Accept", tc.accept)
+		}
+		rec := httptest.NewRecorder()
+		if got := negotiate(rec, r, "Accept", tc.offers...); got != tc.want {
+			t.Errorf("negotiate(Accept: %q, %v) = %q, want %q", tc.accept, tc.offers, got, tc.want)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("negotiate(Accept: %q) set Vary %q, want Accept", tc.accept, got)
+		}
+	}
+}
+
+func TestNegotiationVary(t *testing.T) {
+	// Compression and Markdown negotiation on the same response declare
+	// both headers, once each.
+	h := compressionHandler(markdownHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Accept-Encoding")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<p>gVisor</p>"))
+	})))
+	for _, encoding := range []string{"", "gzip", "gzip;q=0"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if got := rec.Header()["Vary"]; len(got) != 1 || got[0] != "Accept-Encoding" && got[0] != "Accept-Encoding, Accept" {
+			t.Errorf("Accept-Encoding %q: got Vary %q, want a single Vary header", encoding, got)
+		}
+		if got, want := rec.Header().Get("Content-Encoding") == "gzip", encoding == "gzip"; got != want {
+			t.Errorf("Accept-Encoding %q: got gzip %t, want %t", encoding, got, want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// buildOutcomes maps terminal Cloud Build statuses to how they are reported.
+// Builds in any other status are still running.
+var buildOutcomes = map[string]string{
+	"SUCCESS":        "succeeded",
+	"FAILURE":        "failed",
+	"INTERNAL_ERROR": "failed with an internal error",
+	"TIMEOUT":        "timed out",
+	"CANCELLED":      "was cancelled",
+}
+
+// buildNotification is the subset of a Cloud Build message that is used.
+type buildNotification struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	LogURL        string            `json:"logUrl"`
+	Tags          []string          `json:"tags"`
+	Substitutions map[string]string `json:"substitutions"`
+}
+
+// message returns the chat message for the build, or "" if it isn't finished.
+func (b *buildNotification) message() string {
+	outcome, ok := buildOutcomes[b.Status]
+	if !ok {
+		return ""
+	}
+	msg := "gvisor.dev build " + outcome
+	if branch := b.Substitutions["BRANCH_NAME"]; branch != "" {
+		msg += " on " + branch
+	}
+	if commit := b.Substitutions["COMMIT_SHA"]; len(commit) >= 7 {
+		msg += " at " + commit[:7]
+	}
+	if b.LogURL != "" {
+		msg += fmt.Sprintf(": <%s|build %s>", b.LogURL, b.ID)
+	}
+	return msg
+}
+
+// deployed returns true if the build deployed the site, which only builds of
+// master do.
+func (b *buildNotification) deployed() bool {
+	return b.Status == "SUCCESS" && b.Substitutions["BRANCH_NAME"] == "master" && !isPreviewBuild(b.Tags)
+}
+
+// isPreviewBuild returns true if a build with the given tags is a PR preview
+// build.
+func isPreviewBuild(tags []string) bool {
+	for _, t := range tags {
+		if t == "preview" {
+			return true
+		}
+	}
+	return false
+}
+
+// postChatMessage posts a message to a Slack or Google Chat incoming webhook.
+// Both accept a JSON object with a text field.
+func postChatMessage(ctx context.Context, webhookURL, text string) error {
+	b, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", webhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := upstreamClient("notify").Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("chat webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// buildNotifyHandler receives Pub/Sub push deliveries from the cloud-builds
+// topic and posts finished site builds to the given chat webhook, if any.
+// Search engines are pinged with the sitemap at the given endpoints after
+// builds that deployed the site. Pub/Sub must push to the handler with the
+// given token in the token parameter.
+func buildNotifyHandler(token, webhookURL string, pingEndpoints []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			httpError(w, r, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		var push struct {
+			Message struct {
+				// Data is decoded from base64 by encoding/json.
+				Data []byte `json:"data"`
+			} `json:"message"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookPayload)).Decode(&push); err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var build buildNotification
+		if err := json.Unmarshal(push.Message.Data, &build); err != nil {
+			// Redelivering a malformed message won't help, so
+			// acknowledge it.
+			log.Printf("Error decoding build notification: %v", err)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if msg := build.message(); msg != "" && !isPreviewBuild(build.Tags) && webhookURL != "" {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+			if err := postChatMessage(ctx, webhookURL, msg); err != nil {
+				// Fail so that Pub/Sub retries the delivery.
+				log.Printf("Error posting build notification: %v", err)
+				httpError(w, r, err.Error(), http.StatusBadGateway)
+				return
+			}
+		}
+		if build.deployed() && len(pingEndpoints) > 0 {
+			// A failed ping isn't retried, since the redelivery
+			// would post the build again; the next build pings
+			// again anyway.
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			defer cancel()
+			if err := pingSearchEngines(ctx, pingEndpoints, siteURL("/"+sitemapIndexFile)); err != nil {
+				log.Printf("Error pinging search engines: %v", err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
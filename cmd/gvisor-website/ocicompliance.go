@@ -0,0 +1,119 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+)
+
+// ociComplianceFile is the OCI runtime spec compliance matrix written into
+// the static dir by generate-reference-docs.
+const ociComplianceFile = "oci-compliance.json"
+
+// ociCompliancePath is the URL path of the compliance page.
+const ociCompliancePath = "/docs/reference/oci-compliance/"
+
+// ociStatuses are the support statuses of features in the matrix.
+var ociStatuses = map[string]bool{
+	"supported":   true,
+	"partial":     true,
+	"ignored":     true,
+	"unsupported": true,
+	"unknown":     true,
+}
+
+// ociFeature is a feature of the OCI runtime spec and runsc's support of it.
+type ociFeature struct {
+	Feature string `json:"feature"`
+	Section string `json:"section"`
+	Status  string `json:"status"`
+	Note    string `json:"note,omitempty"`
+	Source  string `json:"source,omitempty"`
+
+	// Anchor is the URL of the feature on the compliance page.
+	Anchor string `json:"anchor"`
+}
+
+// ociCompliance is the OCI runtime spec compliance matrix of runsc.
+type ociCompliance struct {
+	SpecVersion string       `json:"spec_version,omitempty"`
+	Features    []ociFeature `json:"features"`
+}
+
+// loadOCICompliance reads the compliance matrix from the static dir.
+func loadOCICompliance(staticDir string) (*ociCompliance, error) {
+	b, err := ioutil.ReadFile(filepath.Join(staticDir, ociComplianceFile))
+	if err != nil {
+		return nil, err
+	}
+	var c ociCompliance
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	for i := range c.Features {
+		c.Features[i].Anchor = ociCompliancePath + "#" + c.Features[i].Feature
+	}
+	return &c, nil
+}
+
+var (
+	ociComplianceOnce sync.Once
+	ociComplianceData *ociCompliance
+)
+
+// getOCICompliance returns the compliance matrix, loading it on first use.
+func getOCICompliance(staticDir string) *ociCompliance {
+	ociComplianceOnce.Do(func() {
+		var err error
+		ociComplianceData, err = loadOCICompliance(staticDir)
+		if err != nil {
+			log.Printf("Error loading OCI compliance: %v", err)
+		}
+	})
+	return ociComplianceData
+}
+
+// ociComplianceHandler serves the OCI runtime spec compliance matrix,
+// optionally only the features with the given status or in the given
+// section.
+func ociComplianceHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := r.URL.Query().Get("status")
+		section := r.URL.Query().Get("section")
+		if status != "" && !ociStatuses[status] {
+			httpError(w, r, "invalid status", http.StatusBadRequest)
+			return
+		}
+		c := getOCICompliance(staticDir)
+		if c == nil {
+			httpError(w, r, "OCI compliance is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		result := ociCompliance{SpecVersion: c.SpecVersion, Features: make([]ociFeature, 0, len(c.Features))}
+		for _, f := range c.Features {
+			if (status == "" || f.Status == status) && (section == "" || f.Section == section) {
+				result.Features = append(result.Features, f)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(result)
+	})
+}
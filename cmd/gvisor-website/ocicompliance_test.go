@@ -0,0 +1,96 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOCIComplianceHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-compliance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ociComplianceOnce, ociComplianceData = sync.Once{}, nil
+	defer func() { ociComplianceOnce, ociComplianceData = sync.Once{}, nil }()
+
+	h := ociComplianceHandler(dir)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/compliance/oci", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("without data: got status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	data := `{
+		"spec_version": "v1.0.1",
+		"features": [
+			{"feature": "process.args", "section": "Process", "status": "supported"},
+			{"feature": "linux.resources.cpu", "section": "Linux", "status": "partial", "note": "Only shares and quota are enforced."},
+			{"feature": "linux.intelRdt", "section": "Linux", "status": "unknown"}
+		]
+	}`
+	if err := ioutil.WriteFile(filepath.Join(dir, ociComplianceFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ociComplianceOnce, ociComplianceData = sync.Once{}, nil
+
+	for _, tc := range []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"", http.StatusOK, []string{"process.args", "linux.resources.cpu", "linux.intelRdt"}},
+		{"?section=Linux", http.StatusOK, []string{"linux.resources.cpu", "linux.intelRdt"}},
+		{"?status=partial", http.StatusOK, []string{"linux.resources.cpu"}},
+		{"?status=supported&section=Linux", http.StatusOK, []string{}},
+		{"?status=maybe", http.StatusBadRequest, nil},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/compliance/oci"+tc.query, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.query, rec.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var c ociCompliance
+		if err := json.NewDecoder(rec.Body).Decode(&c); err != nil {
+			t.Fatalf("%s: Decode failed: %v", tc.query, err)
+		}
+		if c.SpecVersion != "v1.0.1" {
+			t.Errorf("%s: spec version = %q, want v1.0.1", tc.query, c.SpecVersion)
+		}
+		features := []string{}
+		for _, f := range c.Features {
+			features = append(features, f.Feature)
+		}
+		if strings.Join(features, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: got features %v, want %v", tc.query, features, tc.want)
+		}
+		if len(c.Features) > 0 && c.Features[0].Anchor != ociCompliancePath+"#"+c.Features[0].Feature {
+			t.Errorf("%s: anchor = %q", tc.query, c.Features[0].Anchor)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// ogPrefix is the path of the social card images, which are served at
+	// ogPrefix<page-hash>.png.
+	ogPrefix = "/og/"
+
+	// ogWidth and ogHeight are the size of social cards recommended by
+	// the major social platforms.
+	ogWidth  = 1200
+	ogHeight = 630
+
+	// ogMargin is the margin around the text of social cards.
+	ogMargin = 80
+
+	// ogTitleLines is the maximum number of lines of the title of a card.
+	ogTitleLines = 3
+
+	// ogTwitterCard is the Twitter card tag of the Hugo theme.
+	ogTwitterCard = `<meta name="twitter:card" content="summary"/>`
+)
+
+var (
+	ogBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	ogBrand      = color.RGBA{0x26, 0x23, 0x62, 0xff}
+	ogTitle      = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	ogSection    = color.RGBA{0x28, 0x6f, 0xd7, 0xff}
+	ogMuted      = color.RGBA{0x66, 0x66, 0x66, 0xff}
+)
+
+// ogHash returns the hash identifying the social card of the page at the
+// given URL path. It covers the title too, so that social platforms fetch a
+// new card when the title changes.
+func ogHash(urlPath, title string) string {
+	h := sha256.Sum256([]byte(urlPath + "\n" + title))
+	return hex.EncodeToString(h[:8])
+}
+
+// ogSectionName returns the section shown above the title of the page at the
+// given URL path: "Blog", or the title of the top-level docs section.
+func ogSectionName(staticDir, urlPath string) string {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	if segments[0] == "blog" {
+		return "Blog"
+	}
+	if len(segments) < 3 {
+		return "Documentation"
+	}
+	p := "/docs/" + segments[1] + "/"
+	if t := pageTitle(staticDir, p); t != "" {
+		return t
+	}
+	return strings.Title(strings.Replace(segments[1], "_", " ", -1))
+}
+
+var (
+	ogPagesOnce sync.Once
+	ogPages     map[string]string
+)
+
+// ogPage returns the URL path of the docs or blog page with the given card
+// hash, or false if there is none. Pages are indexed once, since the static
+// dir only changes on deploy.
+func ogPage(staticDir, hash string) (string, bool) {
+	ogPagesOnce.Do(func() {
+		ogPages = make(map[string]string)
+		for _, section := range []string{"docs", "blog"} {
+			filepath.Walk(filepath.Join(staticDir, section), func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() || info.Name() != "index.html" {
+					return nil
+				}
+				rel, err := filepath.Rel(staticDir, filepath.Dir(p))
+				if err != nil {
+					return nil
+				}
+				urlPath := "/" + filepath.ToSlash(rel) + "/"
+				if title := pageTitle(staticDir, urlPath); title != "" {
+					ogPages[ogHash(urlPath, title)] = urlPath
+				}
+				return nil
+			})
+		}
+	})
+	p, ok := ogPages[hash]
+	return p, ok
+}
+
+// ogText draws the given text with the top left corner at x, y, with pixels
+// of the font scaled to scale x scale squares. Characters outside of the font
+// are drawn as '?'.
+func ogText(img *image.RGBA, x, y, scale int, c color.Color, s string) {
+	for _, r := range s {
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		g := ogGlyphs[r-' ']
+		for col := 0; col < 5; col++ {
+			for row := 0; row < 7; row++ {
+				if g[col]&(1<<uint(row)) == 0 {
+					continue
+				}
+				for dx := 0; dx < scale; dx++ {
+					for dy := 0; dy < scale; dy++ {
+						img.Set(x+(col*scale)+dx, y+(row*scale)+dy, c)
+					}
+				}
+			}
+		}
+		x += 6 * scale
+	}
+}
+
+// ogWrap wraps the given text into lines of at most width characters, with
+// at most n lines. Words longer than a line are broken, and the last line is
+// truncated at a word with an ellipsis if the text doesn't fit.
+func ogWrap(s string, width, n int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(s) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > n {
+		lines = lines[:n]
+		last := lines[n-1]
+		if len(last) > width-3 {
+			last = last[:width-3]
+			if i := strings.LastIndex(last, " "); i > 0 {
+				last = last[:i]
+			}
+		}
+		lines[n-1] = strings.TrimRight(last, " ") + "..."
+	}
+	return lines
+}
+
+// renderSocialCard renders the social card of a page with the given title
+// and section as a PNG.
+func renderSocialCard(title, section string) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, ogWidth, ogHeight))
+	for y := 0; y < ogHeight; y++ {
+		for x := 0; x < ogWidth; x++ {
+			c := ogBackground
+			if y < 120 || y >= ogHeight-12 {
+				c = ogBrand
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	ogText(img, ogMargin, 32, 8, ogBackground, "gVisor")
+
+	const titleScale = 8
+	ogText(img, ogMargin, 170, 5, ogSection, section)
+	lines := ogWrap(title, (ogWidth-2*ogMargin)/(6*titleScale), ogTitleLines)
+	for i, line := range lines {
+		ogText(img, ogMargin, 240+i*10*titleScale, titleScale, ogTitle, line)
+	}
+	ogText(img, ogMargin, ogHeight-12-ogMargin/2-7*4, 4, ogMuted, *customHost)
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// socialCardHandler serves the social cards of docs and blog pages at
+// ogPrefix<page-hash>.png.
+func socialCardHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, ogPrefix)
+		if !strings.HasSuffix(name, ".png") {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		urlPath, ok := ogPage(staticDir, strings.TrimSuffix(name, ".png"))
+		if !ok {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		b, err := renderSocialCard(pageTitle(staticDir, urlPath), ogSectionName(staticDir, urlPath))
+		if err != nil {
+			httpError(w, r, fmt.Sprintf("rendering social card: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		// The hash changes with the title, so cards never change.
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Write(b)
+	})
+}
+
+// socialCardTags returns the Open Graph and Twitter tags of the social card
+// of the given page, or "" if it has no title.
+func socialCardTags(staticDir, urlPath string) string {
+	title := pageTitle(staticDir, urlPath)
+	if title == "" {
+		return ""
+	}
+	u := html.EscapeString(siteURL(ogPrefix + ogHash(urlPath, title) + ".png"))
+	return fmt.Sprintf(`<meta property="og:image" content="%s">
+<meta property="og:image:width" content="%d">
+<meta property="og:image:height" content="%d">
+<meta property="og:image:alt" content="%s">
+<meta name="twitter:image" content="%s">
+`, u, ogWidth, ogHeight, html.EscapeString(title), u)
+}
+
+// socialCardRules returns the rewrite rules injecting the social card tags
+// into docs and blog pages, and switching their Twitter card to the large
+// image summary, since the Hugo theme sets no images.
+func socialCardRules(staticDir string) []*rewriteRule {
+	paths := []string{"/docs/*", "/blog/*"}
+	return []*rewriteRule{
+		{
+			Name:   "social-card",
+			Paths:  paths,
+			Anchor: "</head>",
+			Action: rewriteBefore,
+			render: func(r *http.Request) string {
+				return socialCardTags(staticDir, r.URL.Path)
+			},
+		},
+		{
+			Name:   "social-card-twitter",
+			Paths:  paths,
+			Anchor: ogTwitterCard,
+			Action: rewriteReplace,
+			render: func(r *http.Request) string {
+				if pageTitle(staticDir, r.URL.Path) == "" {
+					return ogTwitterCard
+				}
+				return `<meta name="twitter:card" content="summary_large_image"/>`
+			},
+		},
+	}
+}
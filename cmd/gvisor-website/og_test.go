@@ -0,0 +1,127 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOGWrap(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		width int
+		n     int
+		want  []string
+	}{
+		{"gVisor Security Basics - Part 1", 20, 3, []string{"gVisor Security", "Basics - Part 1"}},
+		{"Installation", 20, 3, []string{"Installation"}},
+		{"runsc-debug-and-profiling", 10, 3, []string{"runsc-debu", "g-and-prof", "iling"}},
+		{"one two three four five six", 9, 2, []string{"one two", "three..."}},
+		{"Defense in depth with a long title", 20, 1, []string{"Defense in depth..."}},
+	} {
+		if got := ogWrap(tc.s, tc.width, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ogWrap(%q, %d, %d) = %q, want %q", tc.s, tc.width, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestSocialCards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "og")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for p, title := range map[string]string{
+		"docs/og_guide":                "OG Guide",
+		"docs/og_guide/quick_start":    "Docker Quick Start",
+		"blog/2020/04/02/og-post":      "gVisor Networking Security",
+		"docs/og_guide/untitled_asset": "",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		page := "<html><head><title>" + title + " | gVisor</title></head></html>"
+		if title == "" {
+			page = "<html></html>"
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, p, "index.html"), []byte(page), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ogPagesOnce = sync.Once{}
+	defer func() { ogPagesOnce = sync.Once{} }()
+
+	if got := ogSectionName(dir, "/docs/og_guide/quick_start/"); got != "OG Guide" {
+		t.Errorf("got section %q, want OG Guide", got)
+	}
+	if got := ogSectionName(dir, "/blog/2020/04/02/og-post/"); got != "Blog" {
+		t.Errorf("got section %q, want Blog", got)
+	}
+	if got := socialCardTags(dir, "/docs/og_guide/untitled_asset/"); got != "" {
+		t.Errorf("got tags %q for a page without title, want none", got)
+	}
+
+	tags := socialCardTags(dir, "/docs/og_guide/quick_start/")
+	m := regexp.MustCompile(`<meta property="og:image" content="https://[^/]+(/og/[0-9a-f]{16}\.png)">`).FindStringSubmatch(tags)
+	if m == nil || !strings.Contains(tags, `content="Docker Quick Start"`) {
+		t.Fatalf("got tags %q, want og:image and its alt text", tags)
+	}
+
+	h := socialCardHandler(dir)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", m[1], nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("GET %s: got status %d and type %q", m[1], rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.Len() > maxCachedResponse {
+		t.Errorf("got a %d byte card, want it small enough for the response cache", rec.Body.Len())
+	}
+	img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("invalid PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != ogWidth || b.Dy() != ogHeight {
+		t.Errorf("got a %dx%d card, want %dx%d", b.Dx(), b.Dy(), ogWidth, ogHeight)
+	}
+
+	for _, p := range []string{"/og/0123456789abcdef.png", "/og/" + strings.TrimSuffix(strings.TrimPrefix(m[1], "/og/"), ".png"), "/og/"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got status %d, want %d", p, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	rules := socialCardRules(dir)
+	r := httptest.NewRequest("GET", "/blog/2020/04/02/og-post/", nil)
+	if got := rules[1].content(r); got != `<meta name="twitter:card" content="summary_large_image"/>` {
+		t.Errorf("got Twitter card %q, want the large image summary", got)
+	}
+	r = httptest.NewRequest("GET", "/docs/og_guide/untitled_asset/", nil)
+	if got := rules[1].content(r); got != ogTwitterCard {
+		t.Errorf("got Twitter card %q for a page without a card, want it unchanged", got)
+	}
+}
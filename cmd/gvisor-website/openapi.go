@@ -0,0 +1,257 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// openAPIPath is where the OpenAPI document describing the versioned API is
+// served.
+const openAPIPath = "/api/openapi.json"
+
+// openAPISchema is an OpenAPI 3 schema object, limited to what is generated
+// from the API response types.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+// schemaRefPrefix prefixes references to the document's component schemas.
+const schemaRefPrefix = "#/components/schemas/"
+
+// schemaGenerator generates schemas from Go types, following the rules of
+// encoding/json. Named struct types are added to schemas and referenced.
+type schemaGenerator struct {
+	schemas map[string]*openAPISchema
+}
+
+// schemaName returns the component name of a named struct type, e.g.
+// SearchResponse for searchResponse.
+func schemaName(t reflect.Type) string {
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+// schema returns the schema of values of type t.
+func (g *schemaGenerator) schema(t reflect.Type) *openAPISchema {
+	if t == reflect.TypeOf(time.Time{}) {
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if s.Ref != "" {
+			// Siblings of $ref are ignored, so nullable references
+			// can't be expressed; pointers are used for optional
+			// fields, which are omitted rather than null.
+			return s
+		}
+		s.Nullable = true
+		return s
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			// Reserve the name first, in case the type is recursive.
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return &openAPISchema{Ref: schemaRefPrefix + name}
+	case reflect.Slice, reflect.Array:
+		return &openAPISchema{Type: "array", Items: g.schema(t.Elem()), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: g.schema(t.Elem()), Nullable: true}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	}
+	// Interfaces may hold any value.
+	return &openAPISchema{}
+}
+
+// object returns the schema of a struct type, with a property for each field
+// encoded by encoding/json. Fields without omitempty are required.
+func (g *schemaGenerator) object(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the fields of struct type t to s, flattening embedded
+// structs as encoding/json does.
+func (g *schemaGenerator) addFields(s *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i:]
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(s, f.Type)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(f.Type)
+		if !strings.Contains(opts, ",omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// openAPIParameter is an OpenAPI 3 query parameter.
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+	Example     string         `json:"example,omitempty"`
+}
+
+// openAPIResponse is an OpenAPI 3 response with a JSON body.
+type openAPIResponse struct {
+	Description string `json:"description"`
+	Content     map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+// openAPIOperation is an OpenAPI 3 operation.
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+}
+
+// openAPIDocument is an OpenAPI 3 document.
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// jsonResponse returns a response whose JSON body has the given schema.
+func jsonResponse(description string, s *openAPISchema) openAPIResponse {
+	r := openAPIResponse{Description: description}
+	r.Content = map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	}{"application/json": {Schema: s}}
+	return r
+}
+
+// operationID returns the operation ID of an endpoint path, e.g.
+// compatibilitySearch for compatibility/search.
+func operationID(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// newOpenAPIDocument describes the given API endpoints. Response schemas are
+// generated from the endpoints' response types, so the document can't drift
+// from what is served.
+func newOpenAPIDocument(endpoints []apiEndpoint) *openAPIDocument {
+	g := &schemaGenerator{schemas: make(map[string]*openAPISchema)}
+	errorResponse := jsonResponse("Error.", g.schema(reflect.TypeOf(errorBody{})))
+	operation := func(id, summary string, params []apiParam, response interface{}) map[string]openAPIOperation {
+		op := openAPIOperation{
+			OperationID: id,
+			Summary:     summary,
+			Responses: map[string]openAPIResponse{
+				"200":     jsonResponse("OK.", g.schema(reflect.TypeOf(response))),
+				"default": errorResponse,
+			},
+		}
+		for _, p := range params {
+			op.Parameters = append(op.Parameters, openAPIParameter{
+				Name:        p.name,
+				In:          "query",
+				Description: p.description,
+				Required:    p.required,
+				Schema:      &openAPISchema{Type: p.typ},
+				Example:     p.example,
+			})
+		}
+		return map[string]openAPIOperation{"get": op}
+	}
+
+	doc := &openAPIDocument{OpenAPI: "3.0.3"}
+	doc.Info.Title = "gVisor website API"
+	doc.Info.Version = "1"
+	doc.Servers = append(doc.Servers, struct {
+		URL string `json:"url"`
+	}{strings.TrimSuffix(apiPrefix, "/")})
+	doc.Paths = map[string]map[string]openAPIOperation{
+		"/": operation("index", "List the API endpoints.", nil, apiIndex{}),
+	}
+	for _, e := range endpoints {
+		doc.Paths["/"+e.path] = operation(operationID(e.path), e.summary, e.params, e.response)
+	}
+	doc.Components.Schemas = g.schemas
+	return doc
+}
+
+// openAPIHandler serves the OpenAPI document describing the given endpoints.
+func openAPIHandler(endpoints []apiEndpoint) http.Handler {
+	b, err := json.MarshalIndent(newOpenAPIDocument(endpoints), "", "  ")
+	if err != nil {
+		log.Fatalf("Error encoding the OpenAPI document: %v", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write(b)
+	})
+}
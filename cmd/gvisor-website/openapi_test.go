@@ -0,0 +1,217 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaGenerator(t *testing.T) {
+	type inner struct {
+		A string `json:"a"`
+	}
+	type outer struct {
+		inner
+		B       int              `json:"b,omitempty"`
+		C       []inner          `json:"c"`
+		D       *time.Time       `json:"d,omitempty"`
+		E       map[string]bool  `json:"e"`
+		F       float64          `json:"-"`
+		G       struct{ H bool } `json:"g"`
+		private string
+	}
+	g := &schemaGenerator{schemas: make(map[string]*openAPISchema)}
+	if got, want := g.schema(reflect.TypeOf(outer{})), (&openAPISchema{Ref: schemaRefPrefix + "Outer"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got schema %+v, want %+v", got, want)
+	}
+	want := map[string]*openAPISchema{
+		"Outer": {
+			Type: "object",
+			Properties: map[string]*openAPISchema{
+				"a": {Type: "string"},
+				"b": {Type: "integer"},
+				"c": {Type: "array", Items: &openAPISchema{Ref: schemaRefPrefix + "Inner"}, Nullable: true},
+				"d": {Type: "string", Format: "date-time", Nullable: true},
+				"e": {Type: "object", AdditionalProperties: &openAPISchema{Type: "boolean"}, Nullable: true},
+				"g": {Type: "object", Properties: map[string]*openAPISchema{"H": {Type: "boolean"}}, Required: []string{"H"}},
+			},
+			Required: []string{"a", "c", "e", "g"},
+		},
+		"Inner": {
+			Type:       "object",
+			Properties: map[string]*openAPISchema{"a": {Type: "string"}},
+			Required:   []string{"a"},
+		},
+	}
+	if !reflect.DeepEqual(g.schemas, want) {
+		got, _ := json.MarshalIndent(g.schemas, "", "  ")
+		t.Errorf("got schemas %s", got)
+	}
+}
+
+func TestOperationID(t *testing.T) {
+	for path, want := range map[string]string{
+		"search":               "search",
+		"compatibility/search": "compatibilitySearch",
+		"rebuild/history":      "rebuildHistory",
+	} {
+		if got := operationID(path); got != want {
+			t.Errorf("operationID(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// validateSchema checks that v, decoded from JSON, matches the schema s.
+func validateSchema(v interface{}, s *openAPISchema, schemas map[string]*openAPISchema, path string) error {
+	if s.Ref != "" {
+		ref, ok := schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, s.Ref)
+		}
+		return validateSchema(v, ref, schemas, path)
+	}
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: got null, want %s", path, s.Type)
+	}
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %T, want object", path, v)
+		}
+		for _, name := range s.Required {
+			if _, ok := m[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, pv := range m {
+			ps, ok := s.Properties[name]
+			if !ok {
+				ps = s.AdditionalProperties
+			}
+			if ps == nil {
+				return fmt.Errorf("%s: undocumented property %q", path, name)
+			}
+			if err := validateSchema(pv, ps, schemas, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %T, want array", path, v)
+		}
+		for i, iv := range a {
+			if err := validateSchema(iv, s.Items, schemas, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want string", path, v)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: got %v, want integer", path, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: got %T, want number", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: got %T, want boolean", path, v)
+		}
+	}
+	return nil
+}
+
+// TestOpenAPIContract requests every documented endpoint with its example
+// parameters, and checks the responses against the documented schemas.
+func TestOpenAPIContract(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	resp, body := s.do(t, "GET", openAPIPath, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: got status %d, body %q", openAPIPath, resp.StatusCode, body)
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatalf("invalid OpenAPI document: %v", err)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL+"/" != apiPrefix {
+		t.Fatalf("got servers %+v, want %s", doc.Servers, apiPrefix)
+	}
+
+	// Every registered endpoint is documented.
+	var paths []string
+	for p := range doc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, e := range apiEndpoints("", nil) {
+		if _, ok := doc.Paths["/"+e.path]; !ok {
+			t.Errorf("endpoint %s is not documented; documented paths: %v", e.path, paths)
+		}
+	}
+
+	for _, p := range paths {
+		op := doc.Paths[p]["get"]
+		t.Run(op.OperationID, func(t *testing.T) {
+			q := url.Values{}
+			for _, param := range op.Parameters {
+				q.Set(param.Name, param.Example)
+			}
+			u := doc.Servers[0].URL + p
+			if len(q) > 0 {
+				u += "?" + q.Encode()
+			}
+			resp, body := s.do(t, "GET", u, nil)
+			r, ok := op.Responses[fmt.Sprint(resp.StatusCode)]
+			if !ok {
+				r = op.Responses["default"]
+			}
+			if resp.StatusCode == http.StatusOK && !ok {
+				t.Fatalf("GET %s: status 200 is not documented", u)
+			}
+			var v interface{}
+			if err := json.Unmarshal([]byte(body), &v); err != nil {
+				t.Fatalf("GET %s: got status %d and invalid JSON %q: %v", u, resp.StatusCode, body, err)
+			}
+			if err := validateSchema(v, r.Content["application/json"].Schema, doc.Components.Schemas, "response"); err != nil {
+				t.Errorf("GET %s: got status %d and body %s not matching the schema: %v", u, resp.StatusCode, body, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// anonymizeIP truncates the address to its network: the /24 for IPv4 and the
+// /48 for IPv6, so that individual hosts can't be identified.
+func anonymizeIP(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// pageViews counts page views and approximate unique visitors per page.
+//
+// Visitors are identified only by a hash of their truncated IP address and
+// user agent, salted with a random value that is replaced daily and never
+// stored, so that visitors can't be tracked across days or re-identified from
+// the counts. No cookies are used.
+type pageViews struct {
+	mu       sync.Mutex
+	day      string
+	salt     [16]byte
+	views    map[string]int
+	visitors map[string]map[[sha256.Size]byte]bool
+}
+
+// newPageViews returns an empty page view counter.
+func newPageViews() *pageViews {
+	return &pageViews{views: make(map[string]int)}
+}
+
+var pageViewsTotal = newCounter("page_views_total", "Page views recorded by the beacon, by page.", "page")
+
+// record counts a view of the given page by the given client.
+func (p *pageViews) record(page, ip, userAgent string) {
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if day != p.day {
+		p.day = day
+		rand.Read(p.salt[:])
+		p.visitors = make(map[string]map[[sha256.Size]byte]bool)
+	}
+	p.views[page]++
+	h := sha256.New()
+	h.Write(p.salt[:])
+	h.Write([]byte(anonymizeIP(ip) + "\x00" + userAgent))
+	var id [sha256.Size]byte
+	copy(id[:], h.Sum(nil))
+	if p.visitors[page] == nil {
+		p.visitors[page] = make(map[[sha256.Size]byte]bool)
+	}
+	p.visitors[page][id] = true
+	pageViewsTotal.inc(page)
+}
+
+// pageViewCount is the admin report entry for a single page.
+type pageViewCount struct {
+	Page string `json:"page"`
+
+	// Views is the number of views since the instance started.
+	Views int `json:"views"`
+
+	// VisitorsToday is the approximate number of unique visitors today
+	// (UTC).
+	VisitorsToday int `json:"visitors_today"`
+}
+
+// report returns the counts for all pages, most viewed first.
+func (p *pageViews) report() []pageViewCount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counts := make([]pageViewCount, 0, len(p.views))
+	today := p.day == time.Now().UTC().Format("2006-01-02")
+	for page, views := range p.views {
+		c := pageViewCount{Page: page, Views: views}
+		if today {
+			c.VisitorsToday = len(p.visitors[page])
+		}
+		counts = append(counts, c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Views != counts[j].Views {
+			return counts[i].Views > counts[j].Views
+		}
+		return counts[i].Page < counts[j].Page
+	})
+	return counts
+}
+
+// doNotTrack returns true if the client asked not to be tracked.
+func doNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// knownPage returns the canonical path of the given page if it is an HTML
+// page in the static dir. Beacons only report known pages so that the set of
+// metric labels is bounded.
+func knownPage(staticDir, page string) (string, bool) {
+	m := getStaticManifest(staticDir)
+	if m == nil {
+		return "", false
+	}
+	if _, ok := m.Files[page+"/"]; ok {
+		page += "/"
+	}
+	if _, ok := m.Files[page]; !ok || !(strings.HasSuffix(page, "/") || strings.HasSuffix(page, ".html")) {
+		return "", false
+	}
+	return page, true
+}
+
+// beaconHandler records a view of the page given by the page parameter, sent
+// by navigator.sendBeacon. Only known pages are counted; crawlers and clients
+// sending DNT or GPC are not counted.
+func beaconHandler(views *pageViews, staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 4096)
+		if err := r.ParseForm(); err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, ok := knownPage(staticDir, r.PostForm.Get("page"))
+		if !ok {
+			httpError(w, r, "invalid request: unknown page", http.StatusBadRequest)
+			return
+		}
+		if trafficClass(r) != classCrawler && !doNotTrack(r) {
+			views.record(page, clientIP(r), r.UserAgent())
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// pageViewsReportHandler serves the page view counts of this instance.
+func pageViewsReportHandler(views *pageViews) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(views.report())
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.123":           "192.0.2.0",
+		"2001:db8:1234:5678::1": "2001:db8:1234::",
+		"::ffff:192.0.2.7":      "192.0.2.0",
+		"not an ip":             "",
+	} {
+		if got := anonymizeIP(addr); got != want {
+			t.Errorf("anonymizeIP(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestPageViews(t *testing.T) {
+	p := newPageViews()
+	p.record("/docs/", "192.0.2.1", "Firefox")
+	p.record("/docs/", "192.0.2.1", "Firefox")
+	// Hosts in the same network with the same browser are one visitor.
+	p.record("/docs/", "192.0.2.2", "Firefox")
+	p.record("/docs/", "192.0.2.1", "Chrome")
+	p.record("/docs/", "198.51.100.1", "Firefox")
+	p.record("/blog/", "192.0.2.1", "Firefox")
+	p.record("/", "192.0.2.1", "Firefox")
+
+	want := []pageViewCount{
+		{Page: "/docs/", Views: 5, VisitorsToday: 3},
+		{Page: "/", Views: 1, VisitorsToday: 1},
+		{Page: "/blog/", Views: 1, VisitorsToday: 1},
+	}
+	if got := p.report(); !reflect.DeepEqual(got, want) {
+		t.Errorf("report() = %+v, want %+v", got, want)
+	}
+
+	// Visitors are only reported for the current day.
+	p.day = "2019-01-01"
+	if got := p.report(); got[0].Views != 5 || got[0].VisitorsToday != 0 {
+		t.Errorf("report() on a new day = %+v, want the views without visitors", got)
+	}
+	p.record("/docs/", "192.0.2.1", "Firefox")
+	if got := p.report(); got[0].Views != 6 || got[0].VisitorsToday != 1 {
+		t.Errorf("report() after a view on a new day = %+v, want 6 views by 1 visitor", got)
+	}
+}
+
+func TestBeaconHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pageviews-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"docs/index.html": "docs",
+		"docs/faq.html":   "faq",
+		"css/main.css":    "body{}",
+	})
+	defer setStaticManifest(dir, nil)
+
+	views := newPageViews()
+	h := classifyHandler("beacon", beaconHandler(views, dir))
+	post := func(page string, header http.Header) int {
+		r := httptest.NewRequest("POST", "/api/beacon", strings.NewReader(url.Values{"page": {page}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) Firefox/70.0")
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		page   string
+		header http.Header
+		code   int
+	}{
+		{"/docs/", nil, http.StatusNoContent},
+		{"/docs", nil, http.StatusNoContent},
+		{"/docs/faq.html", nil, http.StatusNoContent},
+		{"/docs/", http.Header{"Dnt": {"1"}}, http.StatusNoContent},
+		{"/docs/", http.Header{"Sec-Gpc": {"1"}}, http.StatusNoContent},
+		{"/docs/", http.Header{"User-Agent": {"Googlebot/2.1 (+http://www.google.com/bot.html)"}}, http.StatusNoContent},
+		{"/css/main.css", nil, http.StatusBadRequest},
+		{"/missing/", nil, http.StatusBadRequest},
+		{"", nil, http.StatusBadRequest},
+	} {
+		if code := post(tc.page, tc.header); code != tc.code {
+			t.Errorf("beacon for %q with %v: got status %d, want %d", tc.page, tc.header, code, tc.code)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/beacon?page=/docs/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want 405", w.Code)
+	}
+
+	// Only the views by tracked browsers are counted.
+	w = httptest.NewRecorder()
+	pageViewsReportHandler(views).ServeHTTP(w, httptest.NewRequest("GET", "/admin/pageviews", nil))
+	var report []pageViewCount
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+	want := []pageViewCount{
+		{Page: "/docs/", Views: 2, VisitorsToday: 1},
+		{Page: "/docs/faq.html", Views: 1, VisitorsToday: 1},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got report %+v, want %+v", report, want)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PDF page layout, in points.
+const (
+	pdfPageWidth  = 612
+	pdfPageHeight = 792
+	pdfMargin     = 54
+)
+
+// pdfStyle is the style of a block of text.
+type pdfStyle struct {
+	font    string
+	size    float64
+	code    bool
+	spacing float64
+}
+
+var (
+	pdfH1   = pdfStyle{font: "F2", size: 18, spacing: 10}
+	pdfH2   = pdfStyle{font: "F2", size: 14, spacing: 8}
+	pdfH3   = pdfStyle{font: "F2", size: 11.5, spacing: 6}
+	pdfText = pdfStyle{font: "F1", size: 10, spacing: 5}
+	pdfCode = pdfStyle{font: "F3", size: 8.5, code: true, spacing: 6}
+)
+
+// pdfBlock is a paragraph, heading or code block of a page.
+type pdfBlock struct {
+	style pdfStyle
+	text  string
+}
+
+// pdfBlockStyles are the styles of the elements that start blocks.
+var pdfBlockStyles = map[string]pdfStyle{
+	"h1": pdfH1, "h2": pdfH2, "h3": pdfH3, "h4": pdfH3, "h5": pdfH3, "h6": pdfH3,
+	"p": pdfText, "li": pdfText, "dt": pdfText, "dd": pdfText, "tr": pdfText,
+	"blockquote": pdfText, "pre": pdfCode,
+}
+
+// pdfBlocks splits the XHTML body of an exported page into blocks of text.
+// Tables are flattened to a block per row.
+func pdfBlocks(body string) []pdfBlock {
+	var blocks []pdfBlock
+	var text strings.Builder
+	style, prefix, pre := pdfText, "", 0
+	flush := func() {
+		t := text.String()
+		if style.code {
+			t = strings.Trim(t, "\n")
+		} else {
+			t = strings.Join(strings.Fields(t), " ")
+		}
+		if strings.TrimSpace(t) != "" {
+			blocks = append(blocks, pdfBlock{style, prefix + t})
+			prefix = ""
+		}
+		text.Reset()
+	}
+	d := xml.NewDecoder(strings.NewReader("<body>" + body + "</body>"))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if s, ok := pdfBlockStyles[name]; ok && pre == 0 {
+				flush()
+				style = s
+				if name == "li" {
+					prefix = "• "
+				}
+			}
+			switch name {
+			case "pre":
+				pre++
+			case "td", "th":
+				if text.Len() > 0 {
+					text.WriteString(" | ")
+				}
+			case "br":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			if t.Name.Local == "pre" {
+				pre--
+			}
+			if _, ok := pdfBlockStyles[t.Name.Local]; ok && pre == 0 {
+				flush()
+				style = pdfText
+			}
+		case xml.CharData:
+			text.Write(t)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// pdfCharWidth returns the approximate width of a character of Helvetica, in
+// thousandths of the font size.
+func pdfCharWidth(c byte) float64 {
+	switch {
+	case strings.IndexByte(" ijlI.,:;!'|", c) >= 0:
+		return 260
+	case strings.IndexByte("frt()[]-", c) >= 0:
+		return 333
+	case strings.IndexByte("mwMW", c) >= 0:
+		return 833
+	case c >= 'A' && c <= 'Z':
+		return 667
+	}
+	return 556
+}
+
+// pdfTextWidth returns the width of the text in the style, in points.
+func pdfTextWidth(s []byte, style pdfStyle) float64 {
+	if style.code {
+		return float64(len(s)) * 0.6 * style.size
+	}
+	w := 0.0
+	for _, c := range s {
+		w += pdfCharWidth(c)
+	}
+	if style.font == "F2" {
+		w *= 1.05
+	}
+	return w * style.size / 1000
+}
+
+// pdfWrap wraps the text of a block to the given width. Code is wrapped by
+// line and, if too long, by character.
+func pdfWrap(text []byte, style pdfStyle, width float64) [][]byte {
+	var lines [][]byte
+	if style.code {
+		perLine := int(width / (0.6 * style.size))
+		for _, l := range bytes.Split(text, []byte("\n")) {
+			l = bytes.Replace(l, []byte("\t"), []byte("    "), -1)
+			for len(l) > perLine {
+				lines = append(lines, l[:perLine])
+				l = l[perLine:]
+			}
+			lines = append(lines, l)
+		}
+		return lines
+	}
+	var line []byte
+	for _, word := range bytes.Fields(text) {
+		candidate := word
+		if len(line) > 0 {
+			candidate = append(append(append([]byte{}, line...), ' '), word...)
+		}
+		if len(line) > 0 && pdfTextWidth(candidate, style) > width {
+			lines = append(lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// winAnsi encodes text for the standard PDF fonts, replacing characters they
+// don't have.
+func winAnsi(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\n' || (r >= 0x20 && r < 0x7f):
+			b = append(b, byte(r))
+		case r == ' ' || unicode.IsSpace(r):
+			b = append(b, ' ')
+		case r >= 0xa1 && r <= 0xff:
+			b = append(b, byte(r))
+		case r == '‘' || r == '’':
+			b = append(b, '\'')
+		case r == '“' || r == '”':
+			b = append(b, '"')
+		case r == '–' || r == '—':
+			b = append(b, '-')
+		case r == '…':
+			b = append(b, "..."...)
+		case r == '•':
+			b = append(b, 0x95)
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+// pdfString returns the PDF literal string of the encoded text.
+func pdfString(b []byte) string {
+	var s strings.Builder
+	s.WriteByte('(')
+	for _, c := range b {
+		if c == '(' || c == ')' || c == '\\' {
+			s.WriteByte('\\')
+		}
+		s.WriteByte(c)
+	}
+	s.WriteByte(')')
+	return s.String()
+}
+
+// pdfLayout lays out blocks of text onto pages.
+type pdfLayout struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+// newPage starts a new page.
+func (l *pdfLayout) newPage() {
+	l.pages = append(l.pages, &bytes.Buffer{})
+	l.y = pdfPageHeight - pdfMargin
+}
+
+// add lays out a block, starting new pages as needed.
+func (l *pdfLayout) add(b pdfBlock) {
+	width := float64(pdfPageWidth - 2*pdfMargin)
+	leading := b.style.size * 1.35
+	lines := pdfWrap(winAnsi(b.text), b.style, width)
+	if l.y < pdfPageHeight-pdfMargin {
+		l.y -= b.style.spacing
+	}
+	for i, line := range lines {
+		// Keep headings with the text that follows them.
+		need := leading
+		if i == 0 && b.style.font == "F2" {
+			need *= 3
+		}
+		if l.y-need < pdfMargin {
+			l.newPage()
+		}
+		l.y -= leading
+		fmt.Fprintf(l.pages[len(l.pages)-1], "BT /%s %.1f Tf %d %.1f Td %s Tj ET\n", b.style.font, b.style.size, pdfMargin, l.y, pdfString(line))
+	}
+}
+
+// renderPDF renders the export as a PDF document, starting each page on a new
+// PDF page and with an outline entry per page. Only the text is rendered.
+func renderPDF(e *docsExport) ([]byte, error) {
+	l := &pdfLayout{}
+	firstPages := make([]int, len(e.Pages))
+	for i, p := range e.Pages {
+		l.newPage()
+		firstPages[i] = len(l.pages) - 1
+		blocks := pdfBlocks(p.Body)
+		if len(blocks) == 0 || blocks[0].style.font != "F2" {
+			blocks = append([]pdfBlock{{pdfH1, p.Title}}, blocks...)
+		}
+		blocks = append([]pdfBlock{{pdfStyle{font: "F1", size: 7.5}, siteURL(p.URL)}}, blocks...)
+		for _, b := range blocks {
+			l.add(b)
+		}
+	}
+	if len(l.pages) == 0 {
+		l.newPage()
+		l.add(pdfBlock{pdfH1, e.Title})
+	}
+	for i, page := range l.pages {
+		fmt.Fprintf(page, "BT /F1 8 Tf %d %d Td (%d) Tj ET\n", pdfPageWidth/2, pdfMargin/2, i+1)
+	}
+
+	// Objects are numbered: the catalog, page tree, fonts and info first,
+	// then each page and its content, then the outline.
+	const (
+		catalogObj = 1
+		pagesObj   = 2
+		infoObj    = 6
+		firstPage  = 7
+	)
+	n := len(l.pages)
+	outlineObj := firstPage + 2*n
+	objs := map[int]string{
+		3:       "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		4:       "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		5:       "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		infoObj: fmt.Sprintf("<< /Title %s /Producer (gvisor.dev) >>", pdfString(winAnsi(e.Title))),
+	}
+	var kids []string
+	for i, page := range l.pages {
+		pageObj, contentObj := firstPage+2*i, firstPage+2*i+1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+		objs[pageObj] = fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, pdfPageWidth, pdfPageHeight, contentObj)
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(page.Bytes())
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		objs[contentObj] = fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", z.Len(), z.Bytes())
+	}
+	objs[pagesObj] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), n)
+	catalog := fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R", pagesObj)
+	if len(e.Pages) > 0 {
+		first, last := outlineObj+1, outlineObj+len(e.Pages)
+		objs[outlineObj] = fmt.Sprintf("<< /Type /Outlines /First %d 0 R /Last %d 0 R /Count %d >>", first, last, len(e.Pages))
+		for i, p := range e.Pages {
+			item := fmt.Sprintf("<< /Title %s /Parent %d 0 R /Dest [%d 0 R /Fit]", pdfString(winAnsi(p.Title)), outlineObj, firstPage+2*firstPages[i])
+			if i > 0 {
+				item += fmt.Sprintf(" /Prev %d 0 R", first+i-1)
+			}
+			if i < len(e.Pages)-1 {
+				item += fmt.Sprintf(" /Next %d 0 R", first+i+1)
+			}
+			objs[first+i] = item + " >>"
+		}
+		catalog += fmt.Sprintf(" /Outlines %d 0 R /PageMode /UseOutlines", outlineObj)
+	}
+	objs[catalogObj] = catalog + " >>"
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objs)+1)
+	for i := 1; i <= len(objs); i++ {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i, objs[i])
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for i := 1; i <= len(objs); i++ {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offsets[i])
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, catalogObj, infoObj, xref)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxPreloads bounds the number of preload hints per page; preloading too
+// much delays the resources that matter.
+const maxPreloads = 8
+
+var (
+	// headTagRE matches stylesheet links and scripts.
+	headTagRE = regexp.MustCompile(`(?is)<(link|script)\b([^>]*)>`)
+
+	// attrRE matches a single tag attribute.
+	attrRE = regexp.MustCompile(`(?is)([a-z-]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+)
+
+// tagAttrs returns the attributes of a tag, keyed by lowercase name.
+func tagAttrs(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRE.FindAllStringSubmatch(s, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3] + m[4])
+	}
+	return attrs
+}
+
+// criticalAssets returns Link preload header values for the render-blocking
+// stylesheets and scripts in the head of the given page. Only same-origin
+// assets are preloaded, under the paths the page references them by.
+func criticalAssets(staticDir string, b []byte) []string {
+	if i := bytes.Index(b, []byte("</head>")); i >= 0 {
+		b = b[:i]
+	}
+	var links []string
+	for _, m := range headTagRE.FindAllSubmatch(b, -1) {
+		attrs := tagAttrs(string(m[2]))
+		var href, as string
+		switch strings.ToLower(string(m[1])) {
+		case "link":
+			if strings.ToLower(attrs["rel"]) != "stylesheet" {
+				continue
+			}
+			href, as = attrs["href"], "style"
+		case "script":
+			// Async and deferred scripts don't block rendering.
+			if _, ok := attrs["async"]; ok {
+				continue
+			}
+			if _, ok := attrs["defer"]; ok {
+				continue
+			}
+			href, as = attrs["src"], "script"
+		}
+		if !strings.HasPrefix(href, "/") || strings.HasPrefix(href, "//") {
+			continue
+		}
+		link := "<" + assetURL(staticDir, href) + ">; rel=preload; as=" + as
+		if _, ok := attrs["crossorigin"]; ok {
+			// The preload must match the request mode of the
+			// element, or it won't be used.
+			link += "; crossorigin"
+		}
+		links = append(links, link)
+		if len(links) == maxPreloads {
+			break
+		}
+	}
+	return links
+}
+
+var (
+	preloadsMu sync.Mutex
+	preloads   = make(map[string][]string) // keyed by static dir and URL path
+)
+
+// pagePreloads returns the preload hints for the page at the given URL path.
+// Only pages listed in the static manifest are analyzed, so the results can
+// be kept: the static dir only changes on deploy.
+func pagePreloads(staticDir, urlPath string) []string {
+	m := getStaticManifest(staticDir)
+	if m == nil {
+		return nil
+	}
+	if _, ok := m.Files[urlPath]; !ok {
+		return nil
+	}
+	preloadsMu.Lock()
+	defer preloadsMu.Unlock()
+	key := staticDir + "\x00" + urlPath
+	if links, ok := preloads[key]; ok {
+		return links
+	}
+	name := urlPath
+	if strings.HasSuffix(name, "/") {
+		name = path.Join(name, "index.html")
+	}
+	f, err := http.Dir(staticDir).Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil
+	}
+	links := criticalAssets(staticDir, b)
+	preloads[key] = links
+	return links
+}
+
+// preloadHandler adds Link preload headers for the critical assets of HTML
+// pages, so that browsers and the CDN can start fetching them before the page
+// has been parsed.
+func preloadHandler(staticDir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && (strings.HasSuffix(r.URL.Path, "/") || strings.HasSuffix(r.URL.Path, ".html")) {
+			for _, link := range pagePreloads(staticDir, r.URL.Path) {
+				w.Header().Add("Link", link)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPreloadPage references critical and non-critical assets.
+const testPreloadPage = `<!doctype html><html><head>
+<link rel="stylesheet" href="/css/main.css">
+<link rel=stylesheet href='/css/print.css' media=print>
+<link rel="icon" href="/favicon.ico">
+<link rel="stylesheet" href="https://fonts.googleapis.com/css?family=Roboto">
+<link rel="stylesheet" href="//cdn.example.com/x.css">
+<script src="/js/main.js"></script>
+<script src="/js/search.js" async></script>
+<script defer src="/js/toc.js"></script>
+<script src="/js/module.js" crossorigin></script>
+<script>inline()</script>
+</head><body><script src="/js/late.js"></script></body></html>`
+
+func TestCriticalAssets(t *testing.T) {
+	defer func(fp bool) { *fingerprintAssets = fp }(*fingerprintAssets)
+	*fingerprintAssets = false
+	want := []string{
+		"</css/main.css>; rel=preload; as=style",
+		"</css/print.css>; rel=preload; as=style",
+		"</js/main.js>; rel=preload; as=script",
+		"</js/module.js>; rel=preload; as=script; crossorigin",
+	}
+	if got := criticalAssets("/nonexistent", []byte(testPreloadPage)); !reflect.DeepEqual(got, want) {
+		t.Errorf("criticalAssets = %q, want %q", got, want)
+	}
+
+	var page strings.Builder
+	page.WriteString("<head>")
+	for i := 0; i < maxPreloads+2; i++ {
+		fmt.Fprintf(&page, `<script src="/js/%d.js"></script>`, i)
+	}
+	if got := criticalAssets("/nonexistent", []byte(page.String())); len(got) != maxPreloads {
+		t.Errorf("got %d preloads, want %d", len(got), maxPreloads)
+	}
+}
+
+func TestPreloadHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preload-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"docs/index.html":  testPreloadPage,
+		"docs/print.html":  `<head><link rel="stylesheet" href="/css/print.css"></head>`,
+		"css/main.css":     "body{}",
+		"css/print.css":    "@media print{}",
+		"js/main.js":       "main()",
+		"js/module.js":     "export {}",
+		"unlisted.txt":     "text",
+		"other/index.html": "<head></head>",
+	})
+	defer func(fp bool) { *fingerprintAssets = fp }(*fingerprintAssets)
+	*fingerprintAssets = true
+	m := getStaticManifest(dir)
+	defer setStaticManifest(dir, nil)
+
+	h := preloadHandler(dir, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	links := func(method, path string) []string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Header()["Link"]
+	}
+
+	// Known assets are preloaded under their fingerprinted paths, the
+	// paths that pages reference them by.
+	want := []string{
+		"</css/main." + m.Files["/css/main.css"] + ".css>; rel=preload; as=style",
+		"</css/print." + m.Files["/css/print.css"] + ".css>; rel=preload; as=style",
+		"</js/main." + m.Files["/js/main.js"] + ".js>; rel=preload; as=script",
+		"</js/module." + m.Files["/js/module.js"] + ".js>; rel=preload; as=script; crossorigin",
+	}
+	if got := links("GET", "/docs/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("GET /docs/: got Link %q, want %q", got, want)
+	}
+	if got := links("GET", "/docs/print.html"); len(got) != 1 || !strings.HasPrefix(got[0], "</css/print.") {
+		t.Errorf("GET /docs/print.html: got Link %q, want the stylesheet", got)
+	}
+	for _, tc := range []struct{ method, path string }{
+		{"HEAD", "/docs/"},
+		{"POST", "/docs/"},
+		{"GET", "/other/"},
+		{"GET", "/missing/"},
+		{"GET", "/css/main.css"},
+		{"GET", "/unlisted.txt"},
+	} {
+		if got := links(tc.method, tc.path); len(got) != 0 {
+			t.Errorf("%s %s: got Link %q, want none", tc.method, tc.path, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// storageReadScope is the OAuth scope required to read preview builds.
+const storageReadScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// previewPathRE matches preview URLs and captures the PR number and the path
+// within the preview.
+var previewPathRE = regexp.MustCompile(`^/preview/([1-9][0-9]{0,6})(/.*)?$`)
+
+// previewMarker is the object written to a preview's directory by every
+// preview build. Builds only upload the files that changed, so the other
+// objects' modification times say nothing about when the preview was built.
+const previewMarker = ".preview-built"
+
+// previewStore serves per-PR site builds from a Cloud Storage bucket. Each PR
+// is built into <prefix>/<number>/ by the preview build.
+type previewStore struct {
+	client *http.Client
+	bucket string
+	prefix string
+
+	// ttl is how long a preview is served after it was last built.
+	ttl time.Duration
+}
+
+// newPreviewStore returns a store for previews in the given bucket using the
+// application default credentials.
+func newPreviewStore(ctx context.Context, bucket, prefix string, ttl time.Duration) (*previewStore, error) {
+	client, err := googleClient(ctx, "storage", storageReadScope)
+	if err != nil {
+		return nil, err
+	}
+	return &previewStore{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+		ttl:    ttl,
+	}, nil
+}
+
+// object returns the name of the object holding the given file of a preview.
+func (s *previewStore) object(pr, p string) string {
+	dir := strings.HasSuffix(p, "/")
+	p = path.Clean("/" + p)
+	if dir {
+		p = path.Join(p, "index.html")
+	}
+	name := pr + p
+	if s.prefix != "" {
+		name = s.prefix + "/" + name
+	}
+	return name
+}
+
+// open fetches the given object. The caller must close the response body.
+func (s *previewStore) open(ctx context.Context, object string) (*http.Response, error) {
+	u := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media", url.PathEscape(s.bucket), url.PathEscape(object))
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.Do(req.WithContext(ctx))
+}
+
+// built returns when the given PR's preview was last built, or the zero time
+// if it has no preview. Results are cached for a minute.
+func (s *previewStore) built(ctx context.Context, pr string) (time.Time, error) {
+	key := "preview:built:" + pr
+	if b, ok, err := sharedCache.get(ctx, key); err == nil && ok {
+		var t time.Time
+		if err := t.UnmarshalText(b); err == nil {
+			return t, nil
+		}
+	}
+	u := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?fields=updated", url.PathEscape(s.bucket), url.PathEscape(s.object(pr, "/"+previewMarker)))
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer resp.Body.Close()
+	var t time.Time
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var meta struct {
+			Updated time.Time `json:"updated"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+			return time.Time{}, err
+		}
+		t = meta.Updated
+	case http.StatusNotFound:
+	default:
+		return time.Time{}, fmt.Errorf("preview marker: %s", resp.Status)
+	}
+	if b, err := t.MarshalText(); err == nil {
+		sharedCache.set(ctx, key, b, time.Minute)
+	}
+	return t, nil
+}
+
+// previewHandler serves /preview/<pr>/... from the preview store.
+//
+// Previews are never indexed or cached by shared caches, and are gone once
+// they haven't been rebuilt for the store's TTL, as recorded by their marker.
+func previewHandler(s *previewStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := previewPathRE.FindStringSubmatch(r.URL.Path)
+		if m == nil {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		pr, p := m[1], m[2]
+		if p == "" {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		w.Header().Set("Cache-Control", "private, no-cache")
+
+		built, err := s.built(r.Context(), pr)
+		if err != nil {
+			httpError(w, r, "upstream error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if built.IsZero() {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		if s.ttl > 0 && time.Since(built) > s.ttl {
+			httpError(w, r, "preview expired", http.StatusGone)
+			return
+		}
+
+		resp, err := s.open(r.Context(), s.object(pr, p))
+		if err != nil {
+			httpError(w, r, "upstream error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			httpError(w, r, "upstream error: "+resp.Status, http.StatusBadGateway)
+			return
+		}
+		hdr := w.Header()
+		for _, k := range []string{"Content-Type", "Content-Length", "ETag", "Last-Modified"} {
+			if v := resp.Header.Get(k); v != "" {
+				hdr.Set(k, v)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Printf("Error streaming preview %s%s: %v", pr, p, err)
+		}
+	})
+}
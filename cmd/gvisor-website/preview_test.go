@@ -0,0 +1,98 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviewObject(t *testing.T) {
+	s := &previewStore{prefix: "previews"}
+	for in, want := range map[string]string{
+		"/":              "previews/12/index.html",
+		"/docs/":         "previews/12/docs/index.html",
+		"/a/../../x.css": "previews/12/x.css",
+	} {
+		if got := s.object("12", in); got != want {
+			t.Errorf("object(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// storageTransport redirects Cloud Storage requests to a test server.
+type storageTransport struct {
+	srv *httptest.Server
+}
+
+func (t storageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(t.srv.URL)
+	req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestPreviewHandler(t *testing.T) {
+	sharedCache = newMemoryCache(100, 1<<20)
+	now := time.Now().UTC()
+	markers := map[string]time.Time{
+		"1": now.Add(-time.Hour),
+		"2": now.Add(-30 * 24 * time.Hour),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		object := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")
+		for pr, built := range markers {
+			if object == "previews/"+pr+"/"+previewMarker {
+				fmt.Fprintf(w, `{"updated": %q}`, built.Format(time.RFC3339Nano))
+				return
+			}
+		}
+		// Unchanged files keep their original upload time.
+		w.Header().Set("Last-Modified", now.Add(-100*24*time.Hour).Format(http.TimeFormat))
+		if object == "previews/1/index.html" || object == "previews/2/index.html" {
+			fmt.Fprint(w, "page")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+	s := &previewStore{
+		client: &http.Client{Transport: storageTransport{srv}},
+		bucket: "bucket",
+		prefix: "previews",
+		ttl:    14 * 24 * time.Hour,
+	}
+	h := previewHandler(s)
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/preview/1/", http.StatusOK},
+		{"/preview/1/missing.css", http.StatusNotFound},
+		{"/preview/2/", http.StatusGone},
+		{"/preview/3/", http.StatusNotFound},
+		{"/preview/1", http.StatusFound},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("GET %s = %d, want %d", tc.path, w.Code, tc.want)
+		}
+	}
+}
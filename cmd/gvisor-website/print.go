@@ -0,0 +1,91 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// printRequested returns true if the request is for the print variant of a
+// page, which users save as a PDF from their browser.
+func printRequested(r *http.Request) bool {
+	return r.URL.Query().Get("print") == "1"
+}
+
+// printStyle returns the style inlined into the print variant of docs pages.
+// Links to the site are followed by their absolute URL, so that they can be
+// followed from paper.
+func printStyle() string {
+	return `<meta name="robots" content="noindex">
+<style>
+@page { margin: 2cm; }
+body { color: #000; background: #fff; font-size: 11pt; line-height: 1.45; }
+.td-outer, .td-main { padding: 0; }
+.td-main main { flex: 0 0 100%; max-width: 100%; padding: 0 1em; }
+.breadcrumb, .site-announcement, .feedback--title, .feedback--question, .feedback--answer, .feedback--response { display: none; }
+a { color: #000; text-decoration: underline; }
+main a[href^="http"]::after { content: " (" attr(href) ")"; font-size: 90%; word-break: break-all; }
+main a[href^="/"]::after { content: " (` + strings.TrimSuffix(siteURL("/"), "/") + `" attr(href) ")"; font-size: 90%; word-break: break-all; }
+pre { white-space: pre-wrap; border: 1px solid #ccc; }
+pre, blockquote, table, img { page-break-inside: avoid; }
+h1, h2, h3, h4 { page-break-after: avoid; }
+</style>
+`
+}
+
+// printRules are the built-in rules producing the print variant of docs
+// pages: the site header, sidebar, table of contents and footer are dropped,
+// and the print style is inlined. The anchors are those of the docs layout.
+func printRules() []*rewriteRule {
+	paths := []string{"/docs/*"}
+	return []*rewriteRule{
+		{
+			Name:   "print-style",
+			Paths:  paths,
+			Anchor: "</head>",
+			Action: rewriteBefore,
+			Print:  true,
+			render: func(r *http.Request) string { return printStyle() },
+		},
+		{
+			Name:   "print-header",
+			Paths:  paths,
+			Anchor: "<header>",
+			End:    "</header>",
+			Action: rewriteReplace,
+			Print:  true,
+		},
+		{
+			// The table of contents follows the sidebar, and the main
+			// content follows both.
+			Name:    "print-sidebar",
+			Paths:   paths,
+			Anchor:  `<div class="col-12 col-md-3 col-xl-2 td-sidebar d-print-none">`,
+			End:     "<main",
+			Action:  rewriteReplace,
+			Content: "<main",
+			Print:   true,
+		},
+		{
+			Name:   "print-footer",
+			Paths:  paths,
+			Anchor: `<footer class="bg-dark py-5 row d-print-none">`,
+			End:    "</footer>",
+			Action: rewriteReplace,
+			Print:  true,
+		},
+	}
+}
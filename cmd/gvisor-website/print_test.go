@@ -0,0 +1,72 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrintRules(t *testing.T) {
+	defer func(rules []*rewriteRule) { rewriteRules = rules }(rewriteRules)
+	rewriteRules = printRules()
+	for _, rule := range rewriteRules {
+		if err := rule.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// As rendered by the docs layout.
+	page := `<html><head><title>Install | gVisor</title></head><body class="td-page">
+<header><nav class="navbar"><a href="/">gVisor</a></nav></header>
+<div class="container-fluid td-outer"><div class="td-main"><div class="row flex-xl-nowrap">
+<div class="col-12 col-md-3 col-xl-2 td-sidebar d-print-none"><nav id="td-sidebar-nav"><a href="/docs/">Docs</a></nav></div>
+<div class="d-none d-xl-block col-xl-2 td-toc d-print-none"><nav id="TableOfContents"></nav></div>
+<main class="col-12 col-md-9 col-xl-8 pl-md-5" role="main"><h1>Install</h1><p>See <a href="/docs/user_guide/faq/">the FAQ</a>.</p></main>
+</div></div>
+<footer class="bg-dark py-5 row d-print-none"><div class="container-fluid">links</div></footer>
+</div></body></html>`
+	for _, tc := range []struct {
+		url   string
+		print bool
+	}{
+		{"/docs/user_guide/install/", false},
+		{"/docs/user_guide/install/?print=0", false},
+		{"/docs/user_guide/install/?print=1", true},
+		{"/blog/?print=1", false},
+	} {
+		for _, chunk := range []int{1, 7, 1000} {
+			rec := httptest.NewRecorder()
+			rewriteHandler(chunkedHandler("text/html; charset=utf-8", page, chunk)).ServeHTTP(rec, httptest.NewRequest("GET", tc.url, nil))
+			got := rec.Body.String()
+			if !tc.print {
+				if got != page {
+					t.Errorf("%s, %d byte writes: got %q, want the page unmodified", tc.url, chunk, got)
+				}
+				continue
+			}
+			for _, gone := range []string{"<header>", "td-sidebar", "TableOfContents", "<footer"} {
+				if strings.Contains(got, gone) {
+					t.Errorf("%s, %d byte writes: page contains %q", tc.url, chunk, gone)
+				}
+			}
+			for _, kept := range []string{"@page", `<main class="col-12 col-md-9 col-xl-8 pl-md-5" role="main"><h1>Install</h1>`, "</main>\n</div></div>\n\n</div></body></html>"} {
+				if !strings.Contains(got, kept) {
+					t.Errorf("%s, %d byte writes: got %q, want it to contain %q", tc.url, chunk, got, kept)
+				}
+			}
+		}
+	}
+}
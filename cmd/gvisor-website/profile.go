@@ -0,0 +1,188 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// profile bundles the defaults that must differ between production and other
+// deployments, so that a staging version can't be indexed by search engines
+// or rebuild the production site.
+type profile struct {
+	name string
+
+	// noindex marks every response noindex and serves a robots.txt
+	// disallowing all crawling.
+	noindex bool
+
+	// rebuild enables the /rebuild cron handler.
+	rebuild bool
+
+	// debug enables the debug endpoints and fault injection with --chaos.
+	debug bool
+
+	// future serves content dated in the future, like the devserver.
+	future bool
+}
+
+// profiles are the named profiles selectable with --profile.
+var profiles = map[string]profile{
+	"prod":    {name: "prod", rebuild: true},
+	"staging": {name: "staging", noindex: true, debug: true},
+	"dev":     {name: "dev", noindex: true, debug: true, future: true},
+}
+
+// deployProfile is the profile in effect. It is production unless set
+// otherwise by main.
+var deployProfile = profiles["prod"]
+
+// defaultProfileName returns the profile used if --profile isn't set: dev
+// outside App Engine, and on App Engine staging for versions deployed by make
+// stage and prod otherwise.
+func defaultProfileName(gaeEnv, gaeVersion string) string {
+	switch {
+	case gaeEnv == "":
+		return "dev"
+	case strings.HasPrefix(gaeVersion, "staging-"):
+		return "staging"
+	default:
+		return "prod"
+	}
+}
+
+// selectProfile returns the named profile, or the default profile if the name
+// is empty, with the given overrides applied where they are not nil.
+func selectProfile(name string, noindex, rebuild, debug *bool) (profile, error) {
+	if name == "" {
+		name = defaultProfileName(os.Getenv("GAE_ENV"), os.Getenv("GAE_VERSION"))
+	}
+	p, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return profile{}, fmt.Errorf("unknown profile %q: want one of %s", name, strings.Join(names, ", "))
+	}
+	if noindex != nil {
+		p.noindex = *noindex
+	}
+	if rebuild != nil {
+		p.rebuild = *rebuild
+	}
+	if debug != nil {
+		p.debug = *debug
+	}
+	return p, nil
+}
+
+// explicitBool returns v if the named flag was set on the command line or by
+// the given environment variable, and nil if it has its default value.
+func explicitBool(name, env string, v *bool) *bool {
+	set := os.Getenv(env) != ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	if !set {
+		return nil
+	}
+	return v
+}
+
+// noindexHandler marks responses noindex if the profile says so.
+func noindexHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deployProfile.noindex {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// disallowAllRobots is the robots.txt served instead of the site's when the
+// profile is noindex.
+const disallowAllRobots = "User-agent: *\nDisallow: /\n"
+
+// robotsHandler serves a robots.txt disallowing all crawling.
+func robotsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		io.WriteString(w, disallowAllRobots)
+	})
+}
+
+// debugRequest is served by /debug/request.
+type debugRequest struct {
+	Profile   string              `json:"profile"`
+	RequestID string              `json:"request_id"`
+	ClientIP  string              `json:"client_ip"`
+	Class     string              `json:"class"`
+	Features  []string            `json:"features"`
+	Headers   map[string][]string `json:"headers"`
+}
+
+// debugRequestHandler serves what the server made of the request, so that
+// classification, client addresses and feature rollouts can be checked on
+// non-production deployments.
+func debugRequestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := debugRequest{
+			Profile:   deployProfile.name,
+			RequestID: requestID(r),
+			ClientIP:  clientIP(r),
+			Class:     trafficClass(r),
+			Features:  []string{},
+			Headers:   make(map[string][]string),
+		}
+		for k, v := range r.Header {
+			if k != "Authorization" && k != "Cookie" {
+				d.Headers[k] = v
+			}
+		}
+		for _, f := range features {
+			if featureEnabled(r, f.Name) {
+				d.Features = append(d.Features, f.Name)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(d)
+	})
+}
+
+// registerProfile registers the handlers that depend on the profile.
+func registerProfile(mux *http.ServeMux) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	if deployProfile.noindex {
+		mux.Handle("/robots.txt", baseChain("robots").then(robotsHandler()))
+	}
+	if deployProfile.debug {
+		mux.Handle("/debug/request", baseChain("debug").then(debugRequestHandler()))
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDefaultProfileName(t *testing.T) {
+	for _, tc := range []struct {
+		gaeEnv, gaeVersion string
+		want               string
+	}{
+		{want: "dev"},
+		{gaeEnv: "standard", gaeVersion: "20190801t120000", want: "prod"},
+		{gaeEnv: "standard", gaeVersion: "staging-search", want: "staging"},
+	} {
+		if got := defaultProfileName(tc.gaeEnv, tc.gaeVersion); got != tc.want {
+			t.Errorf("defaultProfileName(%q, %q) = %q, want %q", tc.gaeEnv, tc.gaeVersion, got, tc.want)
+		}
+	}
+}
+
+func TestSelectProfile(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		name                    string
+		noindex, rebuild, debug *bool
+		want                    profile
+		wantErr                 bool
+	}{
+		{name: "prod", want: profile{name: "prod", rebuild: true}},
+		{name: "staging", want: profile{name: "staging", noindex: true, debug: true}},
+		{name: "staging", rebuild: &yes, debug: &no, want: profile{name: "staging", noindex: true, rebuild: true}},
+		{name: "prod", noindex: &yes, want: profile{name: "prod", noindex: true, rebuild: true}},
+		{name: "production", wantErr: true},
+	} {
+		got, err := selectProfile(tc.name, tc.noindex, tc.rebuild, tc.debug)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("selectProfile(%q) returned error %v, want error %t", tc.name, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("selectProfile(%q) = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProfileHandlers(t *testing.T) {
+	defer func(p profile) { deployProfile = p }(deployProfile)
+
+	for _, tc := range []struct {
+		profile     string
+		wantRobots  string
+		wantDebug   int
+		wantNoindex string
+	}{
+		{profile: "prod", wantDebug: http.StatusNotFound},
+		{profile: "staging", wantRobots: disallowAllRobots, wantDebug: http.StatusOK, wantNoindex: "noindex, nofollow"},
+	} {
+		t.Run(tc.profile, func(t *testing.T) {
+			deployProfile = profiles[tc.profile]
+			mux := http.NewServeMux()
+			registerProfile(mux)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", "/robots.txt", nil))
+			if tc.wantRobots != "" && rec.Body.String() != tc.wantRobots {
+				t.Errorf("got robots.txt %q, want %q", rec.Body.String(), tc.wantRobots)
+			}
+			if tc.wantRobots == "" && rec.Code != http.StatusNotFound {
+				t.Errorf("got robots.txt status %d, want the site's robots.txt", rec.Code)
+			}
+
+			rec = httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/debug/request", nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			r.Header.Set("User-Agent", "test")
+			mux.ServeHTTP(rec, r)
+			if rec.Code != tc.wantDebug {
+				t.Fatalf("got /debug/request status %d, want %d", rec.Code, tc.wantDebug)
+			}
+			if got := rec.Header().Get("X-Robots-Tag"); got != tc.wantNoindex {
+				t.Errorf("got X-Robots-Tag %q, want %q", got, tc.wantNoindex)
+			}
+			if rec.Code == http.StatusOK {
+				var d debugRequest
+				if err := json.NewDecoder(rec.Body).Decode(&d); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				if d.Profile != tc.profile || d.Headers["User-Agent"] == nil || d.Headers["Authorization"] != nil {
+					t.Errorf("got %+v, want the profile and headers without credentials", d)
+				}
+			}
+		})
+	}
+}
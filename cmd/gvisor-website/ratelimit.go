@@ -0,0 +1,140 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// onAppEngine is true on the App Engine standard environment, whose front end
+// sets X-Appengine-User-Ip and strips it from client requests.
+var onAppEngine = os.Getenv("GAE_ENV") == "standard"
+
+// clientIP returns the IP address of the client that made the request. On App
+// Engine, the address is provided by the front end; elsewhere clients can set
+// X-Appengine-User-Ip to anything, so it is ignored. X-Forwarded-For is only
+// used behind trusted proxies: clients can set it to anything, so the address
+// appended by the outermost trusted proxy is used rather than the leftmost.
+// Otherwise the peer address is used.
+func clientIP(r *http.Request) string {
+	if onAppEngine {
+		if ip := r.Header.Get("X-Appengine-User-Ip"); ip != "" {
+			return ip
+		}
+	}
+	if n := *trustedProxies; n > 0 {
+		var hops []string
+		for _, xff := range r.Header["X-Forwarded-For"] {
+			hops = append(hops, strings.Split(xff, ",")...)
+		}
+		if len(hops) >= n {
+			if ip := strings.TrimSpace(hops[len(hops)-n]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bucket is a single token bucket.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a set of token buckets keyed by client.
+type rateLimiter struct {
+	// rate is the number of tokens added per second.
+	rate float64
+
+	// burst is the bucket capacity.
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	lastGC  time.Time
+}
+
+// newRateLimiter returns a limiter allowing perMinute requests per minute per
+// key, with bursts of up to burst requests.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		lastGC:  time.Now(),
+	}
+}
+
+// allow consumes a token for the given key, returning false if none are left.
+func (l *rateLimiter) allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gcLocked(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// gcLocked drops buckets that have been refilled completely, at most once a
+// minute, so that the map doesn't grow without bound.
+//
+// Precondition: l.mu must be held.
+func (l *rateLimiter) gcLocked(now time.Time) {
+	if now.Sub(l.lastGC) < time.Minute {
+		return
+	}
+	l.lastGC = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitHandler rejects requests from clients that exceed the limiter with
+// 429 Too Many Requests.
+func rateLimitHandler(l *rateLimiter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.allow(clientIP(r)) {
+			w.Header().Set("Retry-After", "60")
+			httpError(w, r, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(n int, gae bool) { *trustedProxies, onAppEngine = n, gae }(*trustedProxies, onAppEngine)
+	for _, tc := range []struct {
+		name      string
+		appEngine bool
+		proxies   int
+		headers   map[string][]string
+		want      string
+	}{
+		{
+			name: "peer",
+			want: "192.0.2.1",
+		},
+		{
+			name:    "untrusted forwarded",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9"}},
+			want:    "192.0.2.1",
+		},
+		{
+			name:      "app engine",
+			appEngine: true,
+			proxies:   1,
+			headers:   map[string][]string{"X-Appengine-User-Ip": {"198.51.100.7"}, "X-Forwarded-For": {"203.0.113.9"}},
+			want:      "198.51.100.7",
+		},
+		{
+			name:    "spoofed app engine header",
+			headers: map[string][]string{"X-Appengine-User-Ip": {"198.51.100.7"}},
+			want:    "192.0.2.1",
+		},
+		{
+			name:    "spoofed app engine header behind proxy",
+			proxies: 1,
+			headers: map[string][]string{"X-Appengine-User-Ip": {"198.51.100.7"}, "X-Forwarded-For": {"203.0.113.9"}},
+			want:    "203.0.113.9",
+		},
+		{
+			name:    "spoofed leftmost",
+			proxies: 1,
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9, 198.51.100.7"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "two proxies",
+			proxies: 2,
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9, 198.51.100.7", "10.0.0.1"}},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "too few hops",
+			proxies: 2,
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}},
+			want:    "192.0.2.1",
+		},
+	} {
+		*trustedProxies, onAppEngine = tc.proxies, tc.appEngine
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for k, vs := range tc.headers {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+		if got := clientIP(r); got != tc.want {
+			t.Errorf("%s: clientIP = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60, 3)
+	for i := 0; i < 3; i++ {
+		if !l.allow("a") {
+			t.Fatalf("request %d within burst denied", i)
+		}
+	}
+	if l.allow("a") {
+		t.Errorf("request beyond burst allowed")
+	}
+	if !l.allow("b") {
+		t.Errorf("request from another client denied")
+	}
+
+	// One token is added per second.
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Second)
+	if !l.allow("a") {
+		t.Errorf("request after refill denied")
+	}
+	if l.allow("a") {
+		t.Errorf("second request after refilling one token allowed")
+	}
+
+	// Full buckets are dropped.
+	l.buckets["b"].last = time.Now().Add(-time.Hour)
+	l.lastGC = time.Now().Add(-2 * time.Minute)
+	l.allow("a")
+	if _, ok := l.buckets["b"]; ok {
+		t.Errorf("refilled bucket not collected")
+	}
+	if _, ok := l.buckets["a"]; !ok {
+		t.Errorf("empty bucket collected")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// rawBaseURL is where raw source files are fetched from.
+	rawBaseURL = "https://raw.githubusercontent.com/google/gvisor/go/"
+
+	// maxRawSize bounds the size of a proxied source file.
+	maxRawSize = 4 << 20
+
+	// rawCacheTTL is how long source files are cached. The go branch
+	// moves with every commit, so this is kept short.
+	rawCacheTTL = 10 * time.Minute
+)
+
+// errRawNotFound is returned when the upstream file does not exist.
+var errRawNotFound = fmt.Errorf("not found")
+
+// rawTypes maps file extensions to content types. Anything that a browser
+// might execute or render (HTML, SVG, JavaScript) is deliberately served as
+// plain text so that proxied content can't run in the gvisor.dev origin.
+var rawTypes = map[string]string{
+	".json": "application/json",
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+}
+
+// rawContentType returns the content type to serve the given file with.
+func rawContentType(name string, content []byte) string {
+	if ct, ok := rawTypes[strings.ToLower(path.Ext(name))]; ok {
+		return ct
+	}
+	if strings.HasPrefix(http.DetectContentType(content), "text/") {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// fetchRaw returns the contents of the given file on the go branch, using
+// the shared cache.
+func fetchRaw(ctx context.Context, name string) ([]byte, error) {
+	key := "raw:go:" + name
+	if b, ok, err := sharedCache.get(ctx, key); err == nil && ok {
+		return b, nil
+	}
+	req, err := http.NewRequest("GET", rawBaseURL+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errRawNotFound
+	default:
+		return nil, fmt.Errorf("upstream: %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRawSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxRawSize {
+		return nil, fmt.Errorf("file exceeds %d bytes", maxRawSize)
+	}
+	sharedCache.set(ctx, key, b, rawCacheTTL)
+	return b, nil
+}
+
+// rawHandler returns a handler serving raw source files from the go branch
+// under the given prefix.
+func rawHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		if name == "" || strings.HasSuffix(name, "/") || path.Clean("/"+name) != "/"+name {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		b, err := fetchRaw(r.Context(), name)
+		if err == errRawNotFound {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			httpError(w, r, "upstream error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		sum := sha256.Sum256(b)
+		hdr := w.Header()
+		hdr.Set("Content-Type", rawContentType(name, b))
+		hdr.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+		hdr.Set("Access-Control-Allow-Origin", "*")
+		hdr.Set("Cache-Control", "public, max-age=300")
+		hdr.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(b))
+	})
+}
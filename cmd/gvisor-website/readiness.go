@@ -0,0 +1,164 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readinessCheckInterval is the minimum time between hashes of the static dir
+// while it doesn't hold the expected content.
+const readinessCheckInterval = 5 * time.Second
+
+// contentReadiness reports whether the static dir holds the content version
+// the build produced, so that an instance isn't sent traffic while its content
+// is still being synced. The content version is the version of the static
+// manifest.
+type contentReadiness struct {
+	staticDir string
+
+	// expected is the expected content version; if empty, any content is
+	// accepted once it has been hashed.
+	expected string
+
+	mu        sync.Mutex
+	ready     bool
+	version   string
+	err       error
+	lastCheck time.Time
+}
+
+// readiness is nil until main has loaded the expected content version.
+var readiness *contentReadiness
+
+// loadExpectedContentVersion returns the expected content version: the given
+// version if set, and otherwise the contents of the given marker file written
+// by the build. A missing marker file means no version is expected.
+func loadExpectedContentVersion(version, file string) (string, error) {
+	if version != "" || file == "" {
+		return version, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// check returns whether the content is ready, hashing the static dir again if
+// it wasn't at the last check and that was long enough ago. Once ready, the
+// content is not checked again, and the cached static manifest is replaced
+// and the search index and docs exports dropped, in case they were built from
+// partially synced content.
+func (c *contentReadiness) check(now time.Time) (ready bool, version string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ready || (!c.lastCheck.IsZero() && now.Sub(c.lastCheck) < readinessCheckInterval) {
+		return c.ready, c.version, c.err
+	}
+	c.lastCheck = now
+	m, err := buildStaticManifest(c.staticDir)
+	if err != nil {
+		c.version, c.err = "", err
+		return false, "", err
+	}
+	c.version, c.err = m.Version, nil
+	if c.expected != "" && m.Version != c.expected {
+		c.err = fmt.Errorf("content version %s, want %s", m.Version, c.expected)
+		return false, c.version, c.err
+	}
+	c.ready = true
+	setStaticManifest(c.staticDir, m)
+	resetSearchIndex()
+	resetExports()
+	log.Printf("Static content version %s is ready", m.Version)
+	return true, c.version, nil
+}
+
+// readyzResponse is served by /readyz.
+type readyzResponse struct {
+	Ready           bool   `json:"ready"`
+	ContentVersion  string `json:"content_version,omitempty"`
+	ExpectedVersion string `json:"expected_version,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// readyzHandler serves 200 OK once the static content is ready and 503
+// Service Unavailable before.
+func readyzHandler(c *contentReadiness) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ready, version, err := c.check(time.Now())
+		res := readyzResponse{Ready: ready, ContentVersion: version, ExpectedVersion: c.expected}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+// registerReadiness registers the readiness check of the static dir.
+func registerReadiness(mux *http.ServeMux, staticDir string) {
+	if mux == nil {
+		mux = http.DefaultServeMux
+	}
+	c := readiness
+	if c == nil {
+		c = &contentReadiness{staticDir: staticDir}
+	}
+	mux.Handle("/readyz", baseChain("health").then(readyzHandler(c)))
+}
+
+// writeContentVersion writes the content version of the static dir to the
+// marker file that the server reads at startup.
+func writeContentVersion(staticDir, file string) error {
+	m, err := buildStaticManifest(staticDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Content version of %s is %s", staticDir, m.Version)
+	return ioutil.WriteFile(file, []byte(m.Version+"\n"), 0644)
+}
+
+// runContentVersion runs the content-version subcommand with the given
+// arguments, for builds that don't use the build subcommand.
+func runContentVersion(args []string) error {
+	fs := flag.NewFlagSet("content-version", flag.ExitOnError)
+	static := fs.String("static", "public/static", "Static dir to hash.")
+	out := fs.String("out", "public/content-version", "Marker file to write.")
+	fs.Parse(args)
+	return writeContentVersion(*static, *out)
+}
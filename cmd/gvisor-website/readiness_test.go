@@ -0,0 +1,160 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadExpectedContentVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readiness-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "content-version")
+	if err := ioutil.WriteFile(marker, []byte("0123456789abcdef\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		version, file string
+		want          string
+	}{
+		{version: "fedcba9876543210", file: marker, want: "fedcba9876543210"},
+		{file: marker, want: "0123456789abcdef"},
+		{file: filepath.Join(dir, "missing"), want: ""},
+		{want: ""},
+	} {
+		got, err := loadExpectedContentVersion(tc.version, tc.file)
+		if err != nil || got != tc.want {
+			t.Errorf("loadExpectedContentVersion(%q, %q) = %q, %v, want %q", tc.version, tc.file, got, err, tc.want)
+		}
+	}
+}
+
+func TestContentReadiness(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readiness-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	static := filepath.Join(dir, "static")
+	os.Mkdir(static, 0755)
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(static, name), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	// The build wrote the version of the complete content.
+	write("index.html", "<p>home</p>")
+	write("app.css", "p{}")
+	marker := filepath.Join(dir, "content-version")
+	if err := writeContentVersion(static, marker); err != nil {
+		t.Fatalf("writeContentVersion failed: %v", err)
+	}
+	expected, err := loadExpectedContentVersion("", marker)
+	if err != nil || expected == "" {
+		t.Fatalf("loadExpectedContentVersion = %q, %v, want the written version", expected, err)
+	}
+
+	// The instance has only synced part of it.
+	os.Remove(filepath.Join(static, "app.css"))
+	c := &contentReadiness{staticDir: static, expected: expected}
+	h := readyzHandler(c)
+	readyz := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		return rec.Code
+	}
+	if got := readyz(); got != http.StatusServiceUnavailable {
+		t.Errorf("got status %d with partial content, want %d", got, http.StatusServiceUnavailable)
+	}
+
+	// The sync completes, but the content isn't hashed again right away.
+	write("app.css", "p{}")
+	if got := readyz(); got != http.StatusServiceUnavailable {
+		t.Errorf("got status %d right after the last check, want %d", got, http.StatusServiceUnavailable)
+	}
+	c.lastCheck = time.Now().Add(-readinessCheckInterval)
+	if got := readyz(); got != http.StatusOK {
+		t.Errorf("got status %d with complete content, want %d", got, http.StatusOK)
+	}
+	if m := getStaticManifest(static); m == nil || m.Version != expected {
+		t.Errorf("got static manifest %+v, want version %s", m, expected)
+	}
+
+	// Once ready, the instance stays ready.
+	write("index.html", "changed")
+	c.lastCheck = time.Time{}
+	if got := readyz(); got != http.StatusOK {
+		t.Errorf("got status %d after becoming ready, want %d", got, http.StatusOK)
+	}
+}
+
+func TestContentReadinessResetsCaches(t *testing.T) {
+	static, err := ioutil.TempDir("", "readiness-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(static)
+	defer resetSearchIndex()
+	defer resetExports()
+	write := func(name, content string) {
+		p := filepath.Join(static, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	search := func(q string) int {
+		results, _ := getSearchIndex(static).search(q, searchOptions{boosts: defaultSearchBoosts}, 10)
+		return len(results)
+	}
+
+	// A search and an export while the content is being synced.
+	write("docs/index.html", "<title>Docs</title><main><p>Overview.</p></main>")
+	resetSearchIndex()
+	if got := search("checkpoint"); got != 0 {
+		t.Fatalf("got %d results before the sync, want 0", got)
+	}
+	exportsMu.Lock()
+	exports["user_guide.pdf"] = []byte("partial")
+	exportsMu.Unlock()
+
+	write("docs/checkpoint/index.html", "<title>Checkpoint</title><main><p>Checkpoint a container.</p></main>")
+	c := &contentReadiness{staticDir: static}
+	if ready, _, err := c.check(time.Now()); !ready {
+		t.Fatalf("check = not ready, %v, want ready", err)
+	}
+	if got := search("checkpoint"); got != 1 {
+		t.Errorf("got %d results once ready, want 1", got)
+	}
+	exportsMu.Lock()
+	_, ok := exports["user_guide.pdf"]
+	exportsMu.Unlock()
+	if ok {
+		t.Errorf("export rendered before readiness kept")
+	}
+}
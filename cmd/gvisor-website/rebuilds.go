@@ -0,0 +1,194 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	rebuildTriggers     = newCounter("rebuild_triggers_total", "Rebuild trigger attempts, by result.", "result")
+	siteBuilds          = newCounter("site_builds_total", "Finished site builds, by Cloud Build status.", "status")
+	siteBuildDuration   = newHistogram("site_build_duration_seconds", "Run time of finished site builds.", []float64{60, 120, 300, 600, 900, 1200, 1800}, "status")
+	siteBuildQueueWait  = newHistogram("site_build_queue_seconds", "Time site builds waited to start.", []float64{1, 5, 15, 30, 60, 120, 300, 600}, "status")
+	lastSuccessfulBuild = newGauge("site_last_successful_build_timestamp_seconds", "Finish time of the most recent successful site build, in seconds since the epoch.")
+)
+
+// rebuildMetricsHandler counts rebuild trigger attempts by result.
+func rebuildMetricsHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.code() < 400 {
+			rebuildTriggers.inc("ok")
+		} else {
+			rebuildTriggers.inc("error")
+		}
+	})
+}
+
+// observedBuilds are the IDs of finished builds already recorded in the
+// metrics, so that each build is only counted once however often it is
+// listed.
+var observedBuilds struct {
+	mu          sync.Mutex
+	ids         map[string]bool
+	lastSuccess time.Time
+}
+
+// maxObservedBuilds bounds observedBuilds. Only recent builds are listed, so
+// forgetting old ones doesn't cause them to be counted again.
+const maxObservedBuilds = 1000
+
+// recordBuilds records the finished builds among the given ones in the build
+// metrics.
+func recordBuilds(builds []buildStatus) {
+	observedBuilds.mu.Lock()
+	defer observedBuilds.mu.Unlock()
+	if observedBuilds.ids == nil || len(observedBuilds.ids) > maxObservedBuilds {
+		observedBuilds.ids = make(map[string]bool)
+	}
+	for _, b := range builds {
+		if _, ok := buildOutcomes[b.Status]; !ok || observedBuilds.ids[b.ID] {
+			continue
+		}
+		observedBuilds.ids[b.ID] = true
+		siteBuilds.inc(b.Status)
+		if !b.StartTime.IsZero() {
+			siteBuildQueueWait.observe(b.StartTime.Sub(b.CreateTime).Seconds(), b.Status)
+			if !b.FinishTime.IsZero() {
+				siteBuildDuration.observe(b.FinishTime.Sub(b.StartTime).Seconds(), b.Status)
+			}
+		}
+		if b.Status == "SUCCESS" && b.FinishTime.After(observedBuilds.lastSuccess) {
+			observedBuilds.lastSuccess = b.FinishTime
+			lastSuccessfulBuild.set(float64(b.FinishTime.Unix()))
+		}
+	}
+}
+
+// buildMetricsLoop refreshes the build metrics every interval until the
+// context is cancelled, so that they are current even when nobody looks at
+// the status page.
+func buildMetricsLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if builds, err := recentBuilds(ctx, 20); err != nil {
+			log.Printf("Error listing builds: %v", err)
+		} else {
+			recordBuilds(builds)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// buildHistoryEntry is a build in the rebuild history.
+type buildHistoryEntry struct {
+	buildStatus
+	QueueSeconds    float64 `json:"queue_seconds,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// rebuildHistory is served by /api/rebuild/history.
+type rebuildHistory struct {
+	LastSuccess time.Time `json:"last_success"`
+
+	// ContentAgeSeconds is the time since the last successful build, or
+	// 0 if there is none among the listed builds.
+	ContentAgeSeconds float64 `json:"content_age_seconds,omitempty"`
+
+	// SuccessRate is the fraction of the listed finished builds that
+	// succeeded.
+	SuccessRate float64             `json:"success_rate"`
+	Builds      []buildHistoryEntry `json:"builds"`
+}
+
+// newRebuildHistory summarizes the given builds, newest first.
+func newRebuildHistory(builds []buildStatus) *rebuildHistory {
+	h := &rebuildHistory{Builds: make([]buildHistoryEntry, 0, len(builds))}
+	var finished, succeeded int
+	for _, b := range builds {
+		e := buildHistoryEntry{buildStatus: b}
+		if !b.StartTime.IsZero() {
+			e.QueueSeconds = b.StartTime.Sub(b.CreateTime).Seconds()
+			if !b.FinishTime.IsZero() {
+				e.DurationSeconds = b.FinishTime.Sub(b.StartTime).Seconds()
+			}
+		}
+		h.Builds = append(h.Builds, e)
+		if _, ok := buildOutcomes[b.Status]; !ok {
+			continue
+		}
+		finished++
+		if b.Status == "SUCCESS" {
+			succeeded++
+			if h.LastSuccess.IsZero() {
+				h.LastSuccess = b.FinishTime
+				h.ContentAgeSeconds = time.Since(b.FinishTime).Seconds()
+			}
+		}
+	}
+	if finished > 0 {
+		h.SuccessRate = float64(succeeded) / float64(finished)
+	}
+	return h
+}
+
+// rebuildHistoryHandler serves the recent site builds and their summary as
+// JSON. The limit parameter sets the number of builds, up to 50.
+func rebuildHistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := 20
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > 50 {
+				httpError(w, r, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		key := "rebuild:history:" + strconv.Itoa(limit)
+		var builds []buildStatus
+		if b, ok, err := sharedCache.get(r.Context(), key); err == nil && ok {
+			json.Unmarshal(b, &builds)
+		} else {
+			var err error
+			builds, err = recentBuilds(r.Context(), int64(limit))
+			if err != nil {
+				log.Printf("Error fetching build history: %v", err)
+				httpError(w, r, "build history unavailable", http.StatusBadGateway)
+				return
+			}
+			if b, err := json.Marshal(builds); err == nil {
+				sharedCache.set(r.Context(), key, b, time.Minute)
+			}
+			recordBuilds(builds)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(newRebuildHistory(builds))
+	})
+}
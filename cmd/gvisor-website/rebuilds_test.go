@@ -0,0 +1,168 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordBuilds(t *testing.T) {
+	observedBuilds.ids, observedBuilds.lastSuccess = nil, time.Time{}
+	siteBuilds.values = make(map[string]float64)
+	lastSuccessfulBuild.values = make(map[string]float64)
+	for _, h := range []*histogramVec{siteBuildDuration, siteBuildQueueWait} {
+		h.counts, h.sums, h.totals = make(map[string][]uint64), make(map[string]float64), make(map[string]float64)
+	}
+
+	created := time.Date(2019, 10, 2, 10, 0, 0, 0, time.UTC)
+	success := buildStatus{ID: "b2", Status: "SUCCESS", CreateTime: created, StartTime: created.Add(5 * time.Second), FinishTime: created.Add(8 * time.Minute)}
+	running := buildStatus{ID: "b3", Status: "WORKING", CreateTime: created.Add(time.Hour), StartTime: created.Add(time.Hour + 10*time.Second)}
+	failure := buildStatus{ID: "b1", Status: "FAILURE", CreateTime: created.Add(-time.Hour), FinishTime: created.Add(-time.Hour + time.Minute)}
+
+	// Builds listed again are only counted once.
+	recordBuilds([]buildStatus{running, success, failure})
+	recordBuilds([]buildStatus{running, success, failure})
+	var buf bytes.Buffer
+	siteBuilds.write(&buf)
+	siteBuildDuration.write(&buf)
+	siteBuildQueueWait.write(&buf)
+	for _, want := range []string{
+		`site_builds_total{status="SUCCESS"} 1`,
+		`site_builds_total{status="FAILURE"} 1`,
+		`site_build_duration_seconds_sum{status="SUCCESS"} 475`,
+		`site_build_queue_seconds_sum{status="SUCCESS"} 5`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got metrics %q, want %q", buf.String(), want)
+		}
+	}
+	// Running builds and builds that never started have no timings.
+	for _, unwanted := range []string{`status="WORKING"`, `site_build_queue_seconds_sum{status="FAILURE"}`} {
+		if strings.Contains(buf.String(), unwanted) {
+			t.Errorf("got metrics %q, want no %q", buf.String(), unwanted)
+		}
+	}
+	if got := lastSuccessfulBuild.values[labelKey(nil)]; got != float64(success.FinishTime.Unix()) {
+		t.Errorf("got last successful build %v, want %d", got, success.FinishTime.Unix())
+	}
+
+	// A running build is counted once it finishes, and only a newer
+	// success moves the last successful build.
+	running.Status, running.FinishTime = "SUCCESS", running.StartTime.Add(10*time.Minute)
+	older := buildStatus{ID: "b0", Status: "SUCCESS", FinishTime: created.Add(-2 * time.Hour)}
+	recordBuilds([]buildStatus{running, success, failure, older})
+	buf.Reset()
+	siteBuilds.write(&buf)
+	if want := `site_builds_total{status="SUCCESS"} 3`; !strings.Contains(buf.String(), want) {
+		t.Errorf("got metrics %q, want %q", buf.String(), want)
+	}
+	if got := lastSuccessfulBuild.values[labelKey(nil)]; got != float64(running.FinishTime.Unix()) {
+		t.Errorf("got last successful build %v, want %d", got, running.FinishTime.Unix())
+	}
+}
+
+func TestNewRebuildHistory(t *testing.T) {
+	finished := time.Now().Add(-time.Hour).Truncate(time.Second)
+	builds := []buildStatus{
+		{ID: "b4", Status: "QUEUED"},
+		{ID: "b3", Status: "SUCCESS", CreateTime: finished.Add(-10 * time.Minute), StartTime: finished.Add(-9 * time.Minute), FinishTime: finished},
+		{ID: "b2", Status: "SUCCESS", FinishTime: finished.Add(-24 * time.Hour)},
+		{ID: "b1", Status: "TIMEOUT"},
+		{ID: "b0", Status: "FAILURE"},
+	}
+	h := newRebuildHistory(builds)
+	if len(h.Builds) != len(builds) || h.Builds[1].QueueSeconds != 60 || h.Builds[1].DurationSeconds != 540 {
+		t.Errorf("got builds %+v, want all builds with the timings of b3", h.Builds)
+	}
+	if !h.LastSuccess.Equal(finished) || h.ContentAgeSeconds < 3600 || h.ContentAgeSeconds > 3700 {
+		t.Errorf("got last success %v, %vs ago, want %v an hour ago", h.LastSuccess, h.ContentAgeSeconds, finished)
+	}
+	if h.SuccessRate != 0.5 {
+		t.Errorf("got success rate %v, want 0.5 of the finished builds", h.SuccessRate)
+	}
+
+	if h := newRebuildHistory(nil); h.Builds == nil || !h.LastSuccess.IsZero() || h.ContentAgeSeconds != 0 || h.SuccessRate != 0 {
+		t.Errorf("newRebuildHistory(nil) = %+v, want an empty history", h)
+	}
+}
+
+func TestEndToEndRebuildHistory(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	s.history = testBuildHistory
+
+	get := func(query string) (*rebuildHistory, []string) {
+		t.Helper()
+		resp, body := s.do(t, "GET", "/api/v1/rebuild/history"+query, nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Cache-Control") != "no-cache" {
+			t.Fatalf("GET %s: got status %d and Cache-Control %q, want 200 and no-cache: %s", query, resp.StatusCode, resp.Header.Get("Cache-Control"), body)
+		}
+		var h rebuildHistory
+		if err := json.Unmarshal([]byte(body), &h); err != nil {
+			t.Fatalf("GET %s: invalid JSON: %v", query, err)
+		}
+		var ids []string
+		for _, b := range h.Builds {
+			ids = append(ids, b.ID)
+		}
+		return &h, ids
+	}
+
+	h, ids := get("")
+	if want := []string{"build-4", "build-2", "build-1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got builds %q, want %q without the preview build", ids, want)
+	}
+	if want := time.Date(2019, 10, 2, 10, 8, 0, 0, time.UTC); !h.LastSuccess.Equal(want) || h.SuccessRate != 0.5 {
+		t.Errorf("got last success %v and success rate %v, want %v and 0.5", h.LastSuccess, h.SuccessRate, want)
+	}
+	if b := h.Builds[1]; b.QueueSeconds != 5 || b.DurationSeconds != 475 {
+		t.Errorf("got build %+v, want the timings of build-2", b)
+	}
+
+	// The limit applies to the listed builds, previews included.
+	if _, ids := get("?limit=3"); !reflect.DeepEqual(ids, []string{"build-4", "build-2"}) {
+		t.Errorf("limit=3: got builds %q, want build-4 and build-2", ids)
+	}
+	for _, limit := range []string{"0", "51", "-1", "ten"} {
+		if resp, _ := s.do(t, "GET", "/api/v1/rebuild/history?limit="+limit, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("limit=%s: got status %d, want 400", limit, resp.StatusCode)
+		}
+	}
+
+	// The history is cached per limit, and Cloud Build errors aren't
+	// disclosed.
+	s.mu.Lock()
+	s.historyErr = true
+	requests := s.historyRequests
+	s.mu.Unlock()
+	if _, ids := get("?limit=3"); len(ids) != 2 {
+		t.Errorf("cached limit=3: got builds %q, want the cached builds", ids)
+	}
+	resp, body := s.do(t, "GET", "/api/v1/rebuild/history?limit=4", nil)
+	if resp.StatusCode != http.StatusBadGateway || strings.Contains(body, "projects/") {
+		t.Errorf("limit=4 with a Cloud Build error: got status %d and body %q, want 502 without the error", resp.StatusCode, body)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.historyRequests != requests+1 {
+		t.Errorf("got %d build list requests, want only the uncached one", s.historyRequests-requests)
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	redirectTargetUp     = newGauge("redirect_target_up", "Whether the last check of an external redirect target succeeded.", "target")
+	redirectTargetChecks = newCounter("redirect_target_checks_total", "Checks of external redirect targets, by result.", "result")
+)
+
+// redirectCheckTimeout bounds a single check of a redirect target.
+const redirectCheckTimeout = 10 * time.Second
+
+// targetHealth is the health of an external redirect target, as served by
+// /admin/redirects/health.
+type targetHealth struct {
+	Target string `json:"target"`
+	Up     bool   `json:"up"`
+
+	// Status is the HTTP status of the last check, or 0 if the request
+	// failed.
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	LastCheck   time.Time `json:"last_check"`
+	LastSuccess time.Time `json:"last_success"`
+
+	// FailingSince is the time of the first of the consecutive failed
+	// checks, or zero if the target is up.
+	FailingSince time.Time `json:"failing_since"`
+	Failures     int       `json:"consecutive_failures,omitempty"`
+}
+
+// redirectChecker periodically checks that external redirect targets are
+// reachable, so that dead shortlinks are noticed before users report them.
+type redirectChecker struct {
+	client *http.Client
+
+	// targets returns the external targets to check.
+	targets func() []string
+
+	mu     sync.Mutex
+	health map[string]*targetHealth
+}
+
+// targetChecker is nil if redirect targets are not checked.
+var targetChecker *redirectChecker
+
+// newRedirectChecker returns a checker for the static redirects, the
+// community links and the dynamic redirects.
+func newRedirectChecker(dynamic *dynamicRedirects) *redirectChecker {
+	c := &redirectChecker{
+		targets: func() []string {
+			return externalTargets(redirects, communityLinks, dynamic.all())
+		},
+		health: make(map[string]*targetHealth),
+	}
+	c.client = upstreamClientAllowing("redirect-check", c.isTargetHost)
+	c.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		// Redirects to hosts the egress policy doesn't allow aren't
+		// followed; the target answering is enough to be up.
+		if !egress.allows(req.URL.Hostname()) && !c.isTargetHost(req.URL.Hostname()) {
+			return http.ErrUseLastResponse
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	}
+	return c
+}
+
+// isTargetHost returns true if the host is that of a redirect target. The
+// checker may contact them whatever the egress policy, as the targets are
+// configured by the site's maintainers.
+func (c *redirectChecker) isTargetHost(host string) bool {
+	for _, target := range c.targets() {
+		if u, err := url.Parse(target); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// externalTargets returns the sorted, distinct targets of the given redirect
+// tables that are on other hosts.
+func externalTargets(static, community map[string]string, dynamic map[string]redirectRecord) []string {
+	seen := make(map[string]bool)
+	add := func(target string) {
+		if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+			seen[target] = true
+		}
+	}
+	for _, target := range static {
+		add(target)
+	}
+	for _, target := range community {
+		add(target)
+	}
+	now := time.Now()
+	for _, rec := range dynamic {
+		if !rec.expired(now) {
+			add(rec.Target)
+		}
+	}
+	targets := make([]string, 0, len(seen))
+	for target := range seen {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// probe requests the target, returning the response status. HEAD is tried
+// first; servers that don't support it are sent a GET instead.
+func (c *redirectChecker) probe(ctx context.Context, target string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, redirectCheckTimeout)
+	defer cancel()
+	code := 0
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, target, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := c.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		code = resp.StatusCode
+		if code != http.StatusMethodNotAllowed && code != http.StatusNotImplemented {
+			break
+		}
+	}
+	if code >= 400 {
+		return code, fmt.Errorf("unexpected status %d", code)
+	}
+	return code, nil
+}
+
+// check checks all targets once.
+func (c *redirectChecker) check(ctx context.Context) {
+	targets := c.targets()
+	results := make([]targetHealth, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			code, err := c.probe(ctx, target)
+			results[i] = targetHealth{Target: target, Status: code, Up: err == nil}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	health := make(map[string]*targetHealth, len(results))
+	for _, res := range results {
+		h := c.health[res.Target]
+		if h == nil {
+			h = &targetHealth{Target: res.Target}
+		}
+		h.Up, h.Status, h.Error, h.LastCheck = res.Up, res.Status, res.Error, now
+		if res.Up {
+			h.LastSuccess, h.FailingSince, h.Failures = now, time.Time{}, 0
+			redirectTargetUp.set(1, res.Target)
+			redirectTargetChecks.inc("ok")
+		} else {
+			if h.Failures == 0 {
+				h.FailingSince = now
+				log.Printf("Redirect target %s is failing: %s", res.Target, res.Error)
+			}
+			h.Failures++
+			redirectTargetUp.set(0, res.Target)
+			redirectTargetChecks.inc("error")
+		}
+		health[res.Target] = h
+	}
+	c.health = health
+}
+
+// failingFor returns how long the target has been failing, or 0 if it is up
+// or has not been checked.
+func (c *redirectChecker) failingFor(target string, now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h, ok := c.health[target]
+	if !ok || h.Up {
+		return 0
+	}
+	return now.Sub(h.FailingSince)
+}
+
+// list returns the health of all checked targets, sorted by target.
+func (c *redirectChecker) list() []targetHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]targetHealth, 0, len(c.health))
+	for _, h := range c.health {
+		list = append(list, *h)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Target < list[j].Target })
+	return list
+}
+
+// redirectCheckLoop checks the redirect targets every interval until the
+// context is cancelled.
+func redirectCheckLoop(ctx context.Context, c *redirectChecker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// adminRedirectHealthHandler serves the health of the external redirect
+// targets as JSON.
+func adminRedirectHealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := targetChecker
+		if c == nil {
+			httpError(w, r, "redirect checks are disabled", http.StatusNotFound)
+			return
+		}
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.list())
+	})
+}
+
+// redirectWarningTemplate is served instead of redirecting to a target that
+// has been failing for longer than --redirect-warn-after.
+var redirectWarningTemplate = template.Must(template.New("redirect-warning").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Link may be broken - gVisor</title>
+<style>
+body { font-family: "Roboto", sans-serif; margin: 0; color: #222; }
+header { background: #262362; color: #fff; padding: 1em 2em; font-size: 1.5em; }
+main { margin: 2em; }
+a { color: #286FD7; }
+</style>
+</head>
+<body>
+<header>gVisor</header>
+<main>
+<h1>This link may be broken</h1>
+<p>This link points to <a href="{{.URL}}">{{.URL}}</a>, which has not been reachable since {{.Since.Format "2006-01-02 15:04 MST"}}.</p>
+<p>Please <a href="https://github.com/google/gvisor-website/issues/new">let us know</a> if it no longer works.</p>
+<p><a href="{{.URL}}">Continue anyway</a></p>
+</main>
+</body>
+</html>
+`))
+
+// redirectWarning serves the warning page instead of redirecting to url if
+// target has been failing for longer than --redirect-warn-after, returning
+// true if it did.
+func redirectWarning(w http.ResponseWriter, target, url string) bool {
+	c := targetChecker
+	if c == nil || *redirectWarnAfter <= 0 {
+		return false
+	}
+	now := time.Now()
+	failing := c.failingFor(target, now)
+	if failing < *redirectWarnAfter {
+		return false
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	redirectWarningTemplate.Execute(w, struct {
+		URL   string
+		Since time.Time
+	}{url, now.Add(-failing).UTC()})
+	return true
+}
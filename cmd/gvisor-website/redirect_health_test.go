@@ -0,0 +1,144 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExternalTargets(t *testing.T) {
+	static := map[string]string{
+		"/issue": "https://github.com/google/gvisor/issues",
+		"/pr":    "https://github.com/google/gvisor/pulls",
+		"/faq":   "/docs/user_guide/faq/",
+	}
+	community := map[string]string{
+		"/chat":  "https://gitter.im/gvisor/community",
+		"/slack": "/docs/community/",
+	}
+	dynamic := map[string]redirectRecord{
+		"/chat":   {Target: "https://example.com/invite"},
+		"/old":    {Target: "https://example.com/expired", Expires: time.Now().Add(-time.Hour)},
+		"/issues": {Target: "https://github.com/google/gvisor/issues"},
+	}
+	got := externalTargets(static, community, dynamic)
+	want := []string{
+		"https://example.com/invite",
+		"https://github.com/google/gvisor/issues",
+		"https://github.com/google/gvisor/pulls",
+		"https://gitter.im/gvisor/community",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("externalTargets() = %q, want %q", got, want)
+	}
+}
+
+func TestRedirectChecker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/no-head":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	targets := []string{srv.URL + "/ok", srv.URL + "/no-head", srv.URL + "/gone"}
+	c := &redirectChecker{
+		client:  srv.Client(),
+		targets: func() []string { return targets },
+		health:  make(map[string]*targetHealth),
+	}
+	c.check(context.Background())
+	c.check(context.Background())
+
+	want := map[string]struct {
+		up       bool
+		status   int
+		failures int
+	}{
+		srv.URL + "/ok":      {true, http.StatusOK, 0},
+		srv.URL + "/no-head": {true, http.StatusOK, 0},
+		srv.URL + "/gone":    {false, http.StatusNotFound, 2},
+	}
+	list := c.list()
+	if len(list) != len(want) {
+		t.Fatalf("list() returned %d targets, want %d", len(list), len(want))
+	}
+	for _, h := range list {
+		w := want[h.Target]
+		if h.Up != w.up || h.Status != w.status || h.Failures != w.failures {
+			t.Errorf("%s: got up %v, status %d, failures %d; want up %v, status %d, failures %d", h.Target, h.Up, h.Status, h.Failures, w.up, w.status, w.failures)
+		}
+	}
+
+	now := time.Now()
+	if d := c.failingFor(srv.URL+"/ok", now); d != 0 {
+		t.Errorf("failingFor(/ok) = %v, want 0", d)
+	}
+	if d := c.failingFor(srv.URL+"/gone", now); d <= 0 {
+		t.Errorf("failingFor(/gone) = %v, want > 0", d)
+	}
+
+	// Targets that are no longer redirected to are forgotten.
+	targets = targets[:1]
+	c.check(context.Background())
+	if list := c.list(); len(list) != 1 {
+		t.Errorf("list() returned %d targets after removal, want 1", len(list))
+	}
+}
+
+func TestRedirectWarning(t *testing.T) {
+	defer func(c *redirectChecker, d time.Duration) {
+		targetChecker, *redirectWarnAfter = c, d
+	}(targetChecker, *redirectWarnAfter)
+
+	const target = "https://example.com/dead"
+	targetChecker = &redirectChecker{health: map[string]*targetHealth{
+		target: {Target: target, FailingSince: time.Now().Add(-2 * time.Hour), Failures: 8},
+	}}
+
+	for _, tc := range []struct {
+		warnAfter time.Duration
+		target    string
+		wantCode  int
+	}{
+		{0, target, http.StatusFound},
+		{time.Hour, target, http.StatusOK},
+		{3 * time.Hour, target, http.StatusFound},
+		{time.Hour, "https://example.com/unchecked", http.StatusFound},
+	} {
+		*redirectWarnAfter = tc.warnAfter
+		rec := httptest.NewRecorder()
+		redirectHandler(tc.target).ServeHTTP(rec, httptest.NewRequest("GET", "/x?a=b", nil))
+		if rec.Code != tc.wantCode {
+			t.Errorf("warn after %v, %s: got status %d, want %d", tc.warnAfter, tc.target, rec.Code, tc.wantCode)
+			continue
+		}
+		if tc.wantCode == http.StatusOK && !strings.Contains(rec.Body.String(), `href="`+tc.target+`?a=b"`) {
+			t.Errorf("warning page doesn't link to the target: %s", rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// redirectSpec is the spec of the site's redirects.
+const redirectSpec = "testdata/redirects.txt"
+
+// redirectCase is a request of a redirect spec and its expected response.
+type redirectCase struct {
+	line     int
+	url      string
+	status   int
+	location string
+}
+
+// parseRedirectSpec parses a redirect spec: a case per line, as
+// "<url> <status> [<location>]", with # comments.
+func parseRedirectSpec(r io.Reader) ([]redirectCase, error) {
+	var cases []redirectCase
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 || len(f) > 3 {
+			return nil, fmt.Errorf("line %d: want <url> <status> [<location>], got %q", n, line)
+		}
+		if _, err := url.ParseRequestURI(f[0]); err != nil {
+			return nil, fmt.Errorf("line %d: invalid url: %v", n, err)
+		}
+		status, err := strconv.Atoi(f[1])
+		if err != nil || http.StatusText(status) == "" {
+			return nil, fmt.Errorf("line %d: invalid status %q", n, f[1])
+		}
+		c := redirectCase{line: n, url: f[0], status: status}
+		if len(f) == 3 {
+			c.location = f[2]
+		}
+		cases = append(cases, c)
+	}
+	return cases, s.Err()
+}
+
+// loadRedirectSpec loads the redirect spec file.
+func loadRedirectSpec(t *testing.T, file string) []redirectCase {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	cases, err := parseRedirectSpec(f)
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	return cases
+}
+
+// check sends the case's request to h, and returns how the response differs
+// from the expected one, or "" if it doesn't.
+func (c redirectCase) check(h http.Handler) string {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", c.url, nil))
+	var diffs []string
+	if w.Code != c.status {
+		diffs = append(diffs, fmt.Sprintf("got status %d, want %d", w.Code, c.status))
+	}
+	if got := w.Header().Get("Location"); got != c.location {
+		diffs = append(diffs, fmt.Sprintf("got Location %q, want %q", got, c.location))
+	}
+	return strings.Join(diffs, "; ")
+}
+
+// runRedirectSpec checks the cases of the spec file against h, which should be
+// the full site handler.
+func runRedirectSpec(t *testing.T, h http.Handler, file string) {
+	t.Helper()
+	for _, c := range loadRedirectSpec(t, file) {
+		if diff := c.check(h); diff != "" {
+			t.Errorf("%s:%d: GET %s: %s", file, c.line, c.url, diff)
+		}
+	}
+}
+
+func TestParseRedirectSpec(t *testing.T) {
+	cases, err := parseRedirectSpec(strings.NewReader("# comment\n\n/a 302 /b\n  https://www.example.com/x  404\n"))
+	if err != nil {
+		t.Fatalf("parseRedirectSpec failed: %v", err)
+	}
+	want := []redirectCase{{3, "/a", 302, "/b"}, {4, "https://www.example.com/x", 404, ""}}
+	if len(cases) != len(want) || cases[0] != want[0] || cases[1] != want[1] {
+		t.Errorf("parseRedirectSpec = %+v, want %+v", cases, want)
+	}
+	for _, spec := range []string{"/a", "/a 302 /b extra", "/a found /b", "/a 999", "a 302 /b"} {
+		if _, err := parseRedirectSpec(strings.NewReader(spec)); err == nil {
+			t.Errorf("parseRedirectSpec(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestRedirectSpec(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	runRedirectSpec(t, s.Config.Handler, redirectSpec)
+}
+
+// TestRedirectSpecCoverage checks that every shortlink and moved page has a
+// case in the spec, so that new ones land with one.
+func TestRedirectSpecCoverage(t *testing.T) {
+	paths := make(map[string]bool)
+	for _, c := range loadRedirectSpec(t, redirectSpec) {
+		if u, err := url.Parse(c.url); err == nil && u.Host == "" {
+			paths[u.Path] = true
+		}
+	}
+	var want []string
+	for p := range redirects {
+		want = append(want, p)
+	}
+	for p := range communityLinks {
+		want = append(want, p)
+	}
+	for _, p := range compatPlatforms {
+		want = append(want, "/c/"+p.os+"/"+p.arch)
+	}
+	for _, p := range want {
+		if !paths[p] {
+			t.Errorf("%s has no case for %s", redirectSpec, p)
+		}
+	}
+	for prefix := range prefixHelpers {
+		found := false
+		for p := range paths {
+			found = found || (strings.HasPrefix(p, "/"+prefix+"/") && len(p) > len(prefix)+2)
+		}
+		if !found {
+			t.Errorf("%s has no case for /%s/<id>", redirectSpec, prefix)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// trackedShortlinks are the prefix helpers whose referrers are counted, as
+// their semantics are what external links depend on.
+var trackedShortlinks = map[string]bool{
+	"issue":  true,
+	"pr":     true,
+	"change": true,
+}
+
+const (
+	// maxReferrerHosts bounds the referrer hosts counted per shortlink;
+	// further hosts are counted as referrerOther.
+	maxReferrerHosts = 200
+
+	referrerNone     = "(none)"
+	referrerInternal = "(internal)"
+	referrerOther    = "(other)"
+)
+
+// scrubReferrer reduces a Referer header to the referring host, dropping the
+// path, query and any credentials, which may identify the visitor. Links from
+// the site itself, given by host, are internal.
+func scrubReferrer(referer, host string) string {
+	if referer == "" {
+		return referrerNone
+	}
+	u, err := url.Parse(referer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return referrerOther
+	}
+	h := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if h == strings.TrimPrefix(strings.ToLower(host), "www.") {
+		return referrerInternal
+	}
+	return h
+}
+
+// referrerCounts counts the referrer hosts of shortlinks.
+type referrerCounts struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+}
+
+// shortlinkReferrers counts the referrers of the tracked shortlinks.
+var shortlinkReferrers = newReferrerCounts()
+
+// newReferrerCounts returns empty referrer counts.
+func newReferrerCounts() *referrerCounts {
+	return &referrerCounts{counts: make(map[string]map[string]int)}
+}
+
+// record counts a referral to the given shortlink. Hosts seen for the first
+// time are logged.
+func (c *referrerCounts) record(shortlink, referrer string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hosts := c.counts[shortlink]
+	if hosts == nil {
+		hosts = make(map[string]int)
+		c.counts[shortlink] = hosts
+	}
+	if _, ok := hosts[referrer]; !ok {
+		if len(hosts) >= maxReferrerHosts {
+			referrer = referrerOther
+		} else {
+			log.Printf("First referral to /%s/ from %s", shortlink, referrer)
+		}
+	}
+	hosts[referrer]++
+}
+
+// referrerCount is the admin stats entry for a single shortlink and referrer.
+type referrerCount struct {
+	Shortlink string `json:"shortlink"`
+	Referrer  string `json:"referrer"`
+	Count     int    `json:"count"`
+}
+
+// report returns the counts, most referrals first.
+func (c *referrerCounts) report() []referrerCount {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := []referrerCount{}
+	for shortlink, hosts := range c.counts {
+		for referrer, n := range hosts {
+			counts = append(counts, referrerCount{shortlink, referrer, n})
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		if counts[i].Shortlink != counts[j].Shortlink {
+			return counts[i].Shortlink < counts[j].Shortlink
+		}
+		return counts[i].Referrer < counts[j].Referrer
+	})
+	return counts
+}
+
+// referrerHandler counts the referrers of requests to the given shortlink.
+// As for page views, crawlers and clients sending DNT or GPC are not counted.
+func referrerHandler(c *referrerCounts, shortlink string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if trafficClass(r) != classCrawler && !doNotTrack(r) {
+			c.record(shortlink, scrubReferrer(r.Referer(), r.Host))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminStats is served by /admin/stats.
+type adminStats struct {
+	// ShortlinkReferrers counts the referring hosts of shortlinks since
+	// the instance started.
+	ShortlinkReferrers []referrerCount `json:"shortlink_referrers"`
+
+	// SearchQueries summarizes the queries of the site search since the
+	// instance started, so that docs authors know what users look for
+	// and can't find.
+	SearchQueries searchQueryReport `json:"search_queries"`
+}
+
+// adminStatsHandler serves the usage statistics of this instance.
+func adminStatsHandler(referrers *referrerCounts, queries *searchQueries) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminStats{
+			ShortlinkReferrers: referrers.report(),
+			SearchQueries:      queries.report(),
+		})
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// releasesPrefix is the path of the release notes pages.
+	releasesPrefix = "/releases/"
+
+	// releaseNotesTTL is how long release notes are cached; they are
+	// rarely edited once published.
+	releaseNotesTTL = time.Hour
+
+	// releaseNotFoundTTL is how long unknown tags are cached, so that
+	// requests for them don't each reach GitHub.
+	releaseNotFoundTTL = 5 * time.Minute
+
+	// releaseFetchTimeout bounds a fetch of release notes, which is shared
+	// by all requests waiting for it.
+	releaseFetchTimeout = 30 * time.Second
+)
+
+var releaseFetches = newCounter("release_notes_fetch_total", "GitHub release notes fetches by result.", "result")
+
+// releaseTagRE matches the release tags pages are served for.
+var releaseTagRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,99}$`)
+
+// releaseNotes is a GitHub release of gVisor, cached by tag.
+type releaseNotes struct {
+	Tag        string    `json:"tag"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Published  time.Time `json:"published"`
+	Prerelease bool      `json:"prerelease"`
+
+	// BodyHTML is the release body, rendered from Markdown and sanitized
+	// by GitHub.
+	BodyHTML string `json:"body_html"`
+
+	// NotFound is set if there is no release with the tag.
+	NotFound bool `json:"not_found,omitempty"`
+}
+
+// fetchReleaseNotes fetches the release with the given tag from GitHub, with
+// its body rendered as HTML.
+func fetchReleaseNotes(ctx context.Context, tag string) (*releaseNotes, error) {
+	return fetchRelease(ctx, strings.TrimSuffix(*releasesURL, "/")+"/tags/"+url.PathEscape(tag), tag)
+}
+
+// fetchRelease fetches the release at the given GitHub API URL. If there is
+// none, the release notes of the given tag are returned as not found.
+func fetchRelease(ctx context.Context, releaseURL, tag string) (*releaseNotes, error) {
+	req, err := http.NewRequest("GET", releaseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3.html+json")
+	resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return &releaseNotes{Tag: tag, NotFound: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release %s: %s", tag, resp.Status)
+	}
+	var body struct {
+		TagName     string    `json:"tag_name"`
+		Name        string    `json:"name"`
+		HTMLURL     string    `json:"html_url"`
+		PublishedAt time.Time `json:"published_at"`
+		Prerelease  bool      `json:"prerelease"`
+		BodyHTML    string    `json:"body_html"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	n := &releaseNotes{
+		Tag:        body.TagName,
+		Name:       body.Name,
+		URL:        body.HTMLURL,
+		Published:  body.PublishedAt,
+		Prerelease: body.Prerelease,
+		BodyHTML:   body.BodyHTML,
+	}
+	if n.Name == "" {
+		n.Name = n.Tag
+	}
+	return n, nil
+}
+
+// releaseFlight deduplicates concurrent fetches of the same release notes.
+var releaseFlight flightGroup
+
+// getReleaseNotes returns the release notes of the tag, using the shared
+// cache.
+func getReleaseNotes(ctx context.Context, tag string) (*releaseNotes, error) {
+	key := "github:release:" + tag
+	if b, ok, err := sharedCache.get(ctx, key); err == nil && ok {
+		var n releaseNotes
+		if err := json.Unmarshal(b, &n); err == nil {
+			return &n, nil
+		}
+	}
+	v, err := releaseFlight.do(key, func() (interface{}, error) {
+		// The fetch is shared, so it must not be canceled with the
+		// request that started it.
+		ctx, cancel := context.WithTimeout(context.Background(), releaseFetchTimeout)
+		defer cancel()
+		n, err := fetchReleaseNotes(ctx, tag)
+		if err != nil {
+			releaseFetches.inc("error")
+			return nil, err
+		}
+		ttl := releaseNotesTTL
+		if n.NotFound {
+			releaseFetches.inc("not_found")
+			ttl = releaseNotFoundTTL
+		} else {
+			releaseFetches.inc("ok")
+		}
+		if b, err := json.Marshal(n); err == nil {
+			sharedCache.set(ctx, key, b, ttl)
+		}
+		return n, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	n, ok := v.(*releaseNotes)
+	if !ok {
+		return nil, errFlightPanicked
+	}
+	return n, nil
+}
+
+// releaseNotesPage is passed to releaseNotesTemplate.
+type releaseNotesPage struct {
+	*releaseNotes
+	Canonical string
+
+	Body template.HTML
+}
+
+var releaseNotesTemplate = template.Must(template.New("release").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} - gVisor release notes</title>
+<meta name="description" content="Release notes of gVisor {{.Tag}}.">
+<link rel="canonical" href="{{.Canonical}}">
+<link rel="alternate" type="text/html" href="{{.URL}}">
+<style>
+body { font-family: "Roboto", sans-serif; margin: 0; color: #222; line-height: 1.5; }
+header { background: #262362; color: #fff; padding: 1em 2em; font-size: 1.5em; }
+header a { color: #fff; text-decoration: none; }
+main { margin: 2em; max-width: 50em; }
+a { color: #286FD7; }
+.meta { color: #666; }
+code, pre { font-family: "Roboto Mono", monospace; font-size: 0.9em; }
+pre { background: #f6f8fa; padding: 1em; overflow: auto; }
+.pl-c { color: #6a737d; }
+.pl-k, .pl-s1 .pl-k { color: #d73a49; }
+.pl-s, .pl-pds, .pl-sr { color: #032f62; }
+.pl-c1, .pl-v, .pl-smi { color: #005cc5; }
+.pl-en, .pl-e { color: #6f42c1; }
+.pl-ent { color: #22863a; }
+</style>
+</head>
+<body>
+<header><a href="/">gVisor</a></header>
+<main>
+<h1>{{.Name}}</h1>
+<p class="meta">{{if .Prerelease}}Pre-release, p{{else}}P{{end}}ublished {{.Published.Format "January 2, 2006"}}. <a href="{{.URL}}">View on GitHub</a></p>
+{{.Body}}
+</main>
+</body>
+</html>
+`))
+
+// releaseNotesHandler serves /releases/<tag>/, the notes of the GitHub
+// release with the tag.
+func releaseNotesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, releasesPrefix)
+		tag := strings.TrimSuffix(rest, "/")
+		if !releaseTagRE.MatchString(tag) {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		if !strings.HasSuffix(rest, "/") {
+			http.Redirect(w, r, releasesPrefix+tag+"/", http.StatusMovedPermanently)
+			return
+		}
+		n, err := getReleaseNotes(r.Context(), tag)
+		if err != nil {
+			log.Printf("Error fetching release notes of %s: %v", tag, err)
+			httpError(w, r, "release notes are unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if n.NotFound {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		page := releaseNotesPage{
+			releaseNotes: n,
+			Canonical:    siteURL(releasesPrefix + n.Tag + "/"),
+			Body:         template.HTML(n.BodyHTML),
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Header().Set("Link", "<"+page.Canonical+">; rel=\"canonical\"")
+		if err := releaseNotesTemplate.Execute(w, page); err != nil {
+			log.Printf("Error rendering release notes of %s: %v", tag, err)
+		}
+	})
+}
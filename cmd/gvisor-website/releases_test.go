@@ -0,0 +1,89 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReleaseNotesHandler(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Accept") != "application/vnd.github.v3.html+json" {
+			t.Errorf("got Accept %q, want the HTML media type", r.Header.Get("Accept"))
+		}
+		if r.URL.Path != "/releases/tags/release-20200127.0" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"tag_name": "release-20200127.0", "name": "Release 20200127.0",
+			"html_url": "https://github.com/google/gvisor/releases/tag/release-20200127.0",
+			"published_at": "2020-01-27T18:00:00Z",
+			"body": "ignored",
+			"body_html": "<p>Fixes:</p><div class=\"highlight highlight-source-shell\"><pre><span class=\"pl-c1\">runsc</span> --version</pre></div>"}`)
+	}))
+	defer srv.Close()
+	defer func(c cache, u string) { sharedCache, *releasesURL = c, u }(sharedCache, *releasesURL)
+	sharedCache = newMemoryCache(100, 1<<20)
+	*releasesURL = srv.URL + "/releases"
+
+	h := releaseNotesHandler()
+	for _, tc := range []struct {
+		path     string
+		code     int
+		location string
+		body     []string
+	}{
+		{"/releases/release-20200127.0/", http.StatusOK, "", []string{
+			`<link rel="canonical" href="https://` + *customHost + `/releases/release-20200127.0/">`,
+			"<h1>Release 20200127.0</h1>",
+			"Published January 27, 2020.",
+			`<span class="pl-c1">runsc</span> --version`,
+		}},
+		{"/releases/release-20200127.0", http.StatusMovedPermanently, "/releases/release-20200127.0/", nil},
+		{"/releases/release-19000101.0/", http.StatusNotFound, "", nil},
+		{"/releases/a/b/", http.StatusNotFound, "", nil},
+		{"/releases/", http.StatusNotFound, "", nil},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.path, rec.Code, tc.code)
+			continue
+		}
+		if got := rec.Header().Get("Location"); got != tc.location {
+			t.Errorf("%s: got Location %q, want %q", tc.path, got, tc.location)
+		}
+		for _, want := range tc.body {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("%s: body doesn't contain %q:\n%s", tc.path, want, rec.Body.String())
+			}
+		}
+	}
+
+	// Release notes and unknown tags are cached.
+	for _, path := range []string{"/releases/release-20200127.0/", "/releases/release-19000101.0/"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if requests != 2 {
+		t.Errorf("got %d upstream requests, want 2", requests)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var responseCacheRequests = newCounter("response_cache_requests_total", "Requests to routes with a response cache, by route and result.", "route", "result")
+
+// parseTTLs parses route=duration pairs, e.g. "search=5m,status=15s".
+func parseTTLs(spec string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid TTL %q: want route=duration", part)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid TTL %q: want a positive duration", part)
+		}
+		ttls[kv[0]] = d
+	}
+	return ttls, nil
+}
+
+var (
+	// responseCacheTTLs are the per-route response cache TTLs, set from
+	// flags at startup.
+	responseCacheTTLs map[string]time.Duration
+
+	// responseCache holds generated responses. It is local to the instance,
+	// so that each response is generated at most once per TTL per
+	// instance without round trips to a shared cache. Responses are not
+	// cached if it is nil.
+	responseCache cache
+
+	// responseFlights deduplicates concurrent generation of a response.
+	responseFlights flightGroup
+)
+
+// maxCachedResponse is the largest response body that is cached.
+const maxCachedResponse = 1 << 20
+
+// cachedResponse is a response stored in the response cache.
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// responseRecorder records a response for the cache.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	rr.WriteHeader(http.StatusOK)
+	return rr.body.Write(p)
+}
+
+// cacheable returns whether the recorded response may be cached. Only
+// complete, public, successful responses are.
+func (rr *responseRecorder) cacheable() bool {
+	cc := rr.header.Get("Cache-Control")
+	return rr.status == http.StatusOK &&
+		rr.body.Len() <= maxCachedResponse &&
+		len(rr.header["Set-Cookie"]) == 0 &&
+		!strings.Contains(cc, "no-store") &&
+		!strings.Contains(cc, "private") &&
+		!strings.Contains(rr.header.Get("Vary"), "*")
+}
+
+// varyHeaders returns the request headers named in the Vary header of a
+// response.
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// responseCacheKey returns the cache key of the response to r, given the
+// request headers the response varies on.
+func responseCacheKey(base string, r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString("response:")
+	b.WriteString(base)
+	for _, name := range vary {
+		fmt.Fprintf(&b, "\n%s: %s", name, strings.Join(r.Header[name], ", "))
+	}
+	return b.String()
+}
+
+// serveCachedResponse writes a cached response.
+func serveCachedResponse(w http.ResponseWriter, resp *cachedResponse, result string) {
+	hdr := w.Header()
+	for k, vs := range resp.Header {
+		if k == "Vary" {
+			for _, v := range vs {
+				hdr.Add(k, v)
+			}
+			continue
+		}
+		hdr[k] = append([]string(nil), vs...)
+	}
+	hdr.Set("X-Cache", result)
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// responseCacheHandler serves GET and HEAD requests for routes with a TTL
+// from the response cache, keyed by path, normalized query (without tracking
+// parameters, if they are stripped) and the request headers that the cached
+// response varies on. A response's Vary header is only known once it has been
+// generated, so it is stored under the path and query, and the response under
+// the full key.
+func responseCacheHandler(route string, h http.Handler) http.Handler {
+	ttl, ok := responseCacheTTLs[route]
+	if !ok {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if responseCache == nil || (r.Method != "GET" && r.Method != "HEAD") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		query, _ := url.ParseQuery(stripTrackingParams(r.URL.RawQuery))
+		base := route + " " + r.URL.Path + "?" + query.Encode()
+		varyKey := "response-vary:" + base
+		var vary []string
+		b, varyKnown, err := responseCache.get(ctx, varyKey)
+		if err == nil && varyKnown {
+			vary = strings.Fields(string(b))
+			if b, ok, err := responseCache.get(ctx, responseCacheKey(base, r, vary)); err == nil && ok {
+				var resp cachedResponse
+				if json.Unmarshal(b, &resp) == nil {
+					responseCacheRequests.inc(route, "hit")
+					serveCachedResponse(w, &resp, "HIT")
+					return
+				}
+			}
+		}
+		if r.Method == "HEAD" {
+			responseCacheRequests.inc(route, "bypass")
+			h.ServeHTTP(w, r)
+			return
+		}
+		responseCacheRequests.inc(route, "miss")
+		generate := func() (interface{}, error) {
+			rec := &responseRecorder{header: make(http.Header)}
+			h.ServeHTTP(rec, r)
+			rec.WriteHeader(http.StatusOK)
+			resp := &cachedResponse{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()}
+			if rec.cacheable() {
+				names := varyHeaders(rec.header)
+				responseCache.set(ctx, varyKey, []byte(strings.Join(names, " ")), ttl)
+				if b, err := json.Marshal(resp); err == nil {
+					responseCache.set(ctx, responseCacheKey(base, r, names), b, ttl)
+				}
+			}
+			return resp, nil
+		}
+		var v interface{}
+		if varyKnown {
+			// Concurrent misses for the same response are generated
+			// once. Until the response's Vary header is known,
+			// requests can't be assumed to get the same response.
+			v, err = responseFlights.do(responseCacheKey(base, r, vary), generate)
+		} else {
+			v, err = generate()
+		}
+		resp, ok := v.(*cachedResponse)
+		if err != nil || !ok {
+			httpError(w, r, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		serveCachedResponse(w, resp, "MISS")
+	})
+}
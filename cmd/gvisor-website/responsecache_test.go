@@ -0,0 +1,156 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTTLs(t *testing.T) {
+	for _, tc := range []struct {
+		spec    string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{spec: "", want: map[string]time.Duration{}},
+		{spec: "search=5m, status=15s", want: map[string]time.Duration{"search": 5 * time.Minute, "status": 15 * time.Second}},
+		{spec: "search", wantErr: true},
+		{spec: "search=5", wantErr: true},
+		{spec: "search=0s", wantErr: true},
+	} {
+		got, err := parseTTLs(tc.spec)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("parseTTLs(%q) returned error %v, want error %t", tc.spec, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseTTLs(%q) = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestResponseCacheHandler(t *testing.T) {
+	defer func(ttls map[string]time.Duration, c cache) {
+		responseCacheTTLs, responseCache = ttls, c
+	}(responseCacheTTLs, responseCache)
+	responseCacheTTLs = map[string]time.Duration{"search": time.Minute}
+	responseCache = newMemoryCache(100, 1<<20)
+
+	calls := 0
+	h := responseCacheHandler("search", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Vary", "Accept")
+		switch r.URL.Query().Get("q") {
+		case "error":
+			http.Error(w, "error", http.StatusInternalServerError)
+			return
+		case "cookie":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		}
+		fmt.Fprintf(w, "%s %s %d", r.URL.Query().Get("q"), r.Header.Get("Accept"), calls)
+	}))
+
+	for _, tc := range []struct {
+		name      string
+		method    string
+		url       string
+		accept    string
+		wantCache string
+		wantBody  string
+		wantCalls int
+	}{
+		{name: "miss", method: "GET", url: "/api/search?q=a&limit=5", wantCache: "MISS", wantBody: "a  1", wantCalls: 1},
+		{name: "hit", method: "GET", url: "/api/search?q=a&limit=5", wantCache: "HIT", wantBody: "a  1", wantCalls: 1},
+		{name: "normalized query", method: "GET", url: "/api/search?limit=5&q=a", wantCache: "HIT", wantBody: "a  1", wantCalls: 1},
+		{name: "head hit", method: "HEAD", url: "/api/search?q=a&limit=5", wantCache: "HIT", wantCalls: 1},
+		{name: "vary", method: "GET", url: "/api/search?q=a&limit=5", accept: "text/html", wantCache: "MISS", wantBody: "a text/html 2", wantCalls: 2},
+		{name: "vary hit", method: "GET", url: "/api/search?q=a&limit=5", accept: "text/html", wantCache: "HIT", wantBody: "a text/html 2", wantCalls: 2},
+		{name: "head miss", method: "HEAD", url: "/api/search?q=b", wantCalls: 3},
+		{name: "post", method: "POST", url: "/api/search?q=a&limit=5", wantBody: "a  4", wantCalls: 4},
+		{name: "error", method: "GET", url: "/api/search?q=error", wantCache: "MISS", wantCalls: 5},
+		{name: "error not cached", method: "GET", url: "/api/search?q=error", wantCache: "MISS", wantCalls: 6},
+		{name: "cookie", method: "GET", url: "/api/search?q=cookie", wantCache: "MISS", wantBody: "cookie  7", wantCalls: 7},
+		{name: "cookie not cached", method: "GET", url: "/api/search?q=cookie", wantCache: "MISS", wantBody: "cookie  8", wantCalls: 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.url, nil)
+			if tc.accept != "" {
+				r.Header.Set("Accept", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if got := rec.Header().Get("X-Cache"); got != tc.wantCache {
+				t.Errorf("got X-Cache %q, want %q", got, tc.wantCache)
+			}
+			if tc.wantBody != "" && rec.Body.String() != tc.wantBody {
+				t.Errorf("got body %q, want %q", rec.Body.String(), tc.wantBody)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("got Vary %q, want Accept", got)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResponseCacheHandlerUncachedRoute(t *testing.T) {
+	defer func(ttls map[string]time.Duration, c cache) {
+		responseCacheTTLs, responseCache = ttls, c
+	}(responseCacheTTLs, responseCache)
+	responseCacheTTLs = map[string]time.Duration{"search": time.Minute}
+	responseCache = newMemoryCache(100, 1<<20)
+
+	calls := 0
+	h := responseCacheHandler("feedback", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/feedback", nil))
+		if got := rec.Header().Get("X-Cache"); got != "" {
+			t.Errorf("got X-Cache %q for a route without a TTL, want none", got)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
+
+func TestResponseCacheKeyStripsTrackingParams(t *testing.T) {
+	defer func(ttls map[string]time.Duration, c cache, strip bool) {
+		responseCacheTTLs, responseCache, *stripTracking = ttls, c, strip
+	}(responseCacheTTLs, responseCache, *stripTracking)
+	responseCacheTTLs = map[string]time.Duration{"search": time.Minute}
+	responseCache = newMemoryCache(100, 1<<20)
+	*stripTracking = true
+
+	calls := 0
+	h := responseCacheHandler("search", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	for _, u := range []string{"/api/search?q=a", "/api/search?q=a&utm_source=newsletter", "/api/search?fbclid=x&q=a"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", u, nil))
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Rewrite actions.
+const (
+	// rewriteBefore inserts the content before the anchor.
+	rewriteBefore = "before"
+
+	// rewriteAfter inserts the content after the anchor.
+	rewriteAfter = "after"
+
+	// rewriteReplace replaces the anchor, and everything up to and
+	// including the end marker if one is given, with the content.
+	rewriteReplace = "replace"
+)
+
+// rewriteRule modifies HTML pages as they are served. Each rule applies at
+// most once per page, at the first occurrence of its anchor. Where several
+// rules share an anchor, the first replace rule wins and insertions are made
+// in rule order.
+type rewriteRule struct {
+	// Name identifies the rule in logs.
+	Name string `json:"name"`
+
+	// Paths are the URL paths the rule applies to. A pattern ending in *
+	// matches by prefix; "*" alone matches all pages.
+	Paths []string `json:"paths"`
+
+	// Anchor is the literal HTML the rule applies at, e.g. "</head>".
+	Anchor string `json:"anchor"`
+
+	// End is the end marker for replace rules.
+	End string `json:"end,omitempty"`
+
+	// Action is before, after or replace.
+	Action string `json:"action"`
+
+	// Content is a text/template producing the HTML to insert, executed
+	// with rewriteData.
+	Content string `json:"content"`
+
+	// Feature, if set, limits the rule to requests the named feature flag
+	// is enabled for.
+	Feature string `json:"feature,omitempty"`
+
+	// Experiment and Variant, if set, limit the rule to requests served
+	// the given variant of the named experiment.
+	Experiment string `json:"experiment,omitempty"`
+	Variant    string `json:"variant,omitempty"`
+
+	// Print, if set, limits the rule to the print variant of pages,
+	// requested with ?print=1.
+	Print bool `json:"print,omitempty"`
+
+	tmpl *template.Template
+
+	// render, if set, produces the content instead of the template.
+	render func(r *http.Request) string
+}
+
+// rewriteData is passed to rule templates. Values are HTML escaped.
+type rewriteData struct {
+	// Path is the URL path of the page.
+	Path string
+
+	// Canonical is the canonical URL of the page.
+	Canonical string
+
+	// Version is the App Engine version serving the page.
+	Version string
+}
+
+// matches returns true if the rule applies to the given request.
+func (rule *rewriteRule) matches(r *http.Request) bool {
+	if rule.Feature != "" && !featureEnabled(r, rule.Feature) {
+		return false
+	}
+	if rule.Experiment != "" && experimentVariant(r, rule.Experiment) != rule.Variant {
+		return false
+	}
+	if rule.Print && !printRequested(r) {
+		return false
+	}
+	urlPath := r.URL.Path
+	for _, p := range rule.Paths {
+		if p == "*" || p == urlPath || (strings.HasSuffix(p, "*") && strings.HasPrefix(urlPath, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// content returns the HTML to insert for the given request.
+func (rule *rewriteRule) content(r *http.Request) string {
+	if rule.render != nil {
+		return rule.render(r)
+	}
+	var b strings.Builder
+	if err := rule.tmpl.Execute(&b, rewriteData{
+		Path:      html.EscapeString(r.URL.Path),
+		Canonical: html.EscapeString(siteURL(r.URL.Path)),
+		Version:   os.Getenv("GAE_VERSION"),
+	}); err != nil {
+		log.Printf("Error executing rewrite rule %q: %v", rule.Name, err)
+		return ""
+	}
+	return b.String()
+}
+
+// validate checks the rule and compiles its template.
+func (rule *rewriteRule) validate() error {
+	if rule.Name == "" || rule.Anchor == "" || len(rule.Paths) == 0 {
+		return fmt.Errorf("rule %q: name, paths and anchor are required", rule.Name)
+	}
+	switch rule.Action {
+	case rewriteBefore, rewriteAfter, rewriteReplace:
+	default:
+		return fmt.Errorf("rule %q: unknown action %q", rule.Name, rule.Action)
+	}
+	if rule.End != "" && rule.Action != rewriteReplace {
+		return fmt.Errorf("rule %q: end is only valid for replace", rule.Name)
+	}
+	if rule.Experiment != "" || rule.Variant != "" {
+		if e := findExperiment(rule.Experiment); e == nil || !e.hasVariant(rule.Variant) {
+			return fmt.Errorf("rule %q: unknown variant %q of experiment %q", rule.Name, rule.Variant, rule.Experiment)
+		}
+	}
+	if rule.render != nil {
+		return nil
+	}
+	tmpl, err := template.New(rule.Name).Parse(rule.Content)
+	if err != nil {
+		return fmt.Errorf("rule %q: %v", rule.Name, err)
+	}
+	rule.tmpl = tmpl
+	return nil
+}
+
+// htmlFilter transforms a fragment of an HTML page. Fragments are split
+// between tags, so a filter always sees whole tags.
+type htmlFilter func([]byte) []byte
+
+// htmlFilters are applied to every HTML page after the rewrite rules. They
+// are set at startup.
+var htmlFilters []htmlFilter
+
+// rewriteRules are the active rules, in order of precedence. They are set at
+// startup from the built-in rules and the config file.
+var rewriteRules []*rewriteRule
+
+// loadRewriteRules returns the rules in the given JSON config file, followed
+// by the given built-in rules. A missing file is not an error.
+func loadRewriteRules(file string, builtin ...*rewriteRule) ([]*rewriteRule, error) {
+	var rules []*rewriteRule
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &rules); err != nil {
+			return nil, fmt.Errorf("invalid rewrite config %s: %v", file, err)
+		}
+	}
+	rules = append(rules, builtin...)
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// rewriteWriter applies rules and filters to an HTML response as it is
+// streamed. Only enough of the response is held back to match anchors and
+// tags that straddle writes.
+type rewriteWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	rules       []*rewriteRule
+	filters     []htmlFilter
+	wroteHeader bool
+
+	// active is false if the response is passed through unmodified.
+	active bool
+
+	// pending are the rules yet to be applied to the response.
+	pending []*rewriteRule
+	buf     []byte
+
+	// skipUntil is the end marker of a replace in progress; output is
+	// dropped until it is found.
+	skipUntil string
+
+	// out holds output not yet filtered, from the last incomplete tag.
+	out []byte
+}
+
+func (rw *rewriteWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	hdr := rw.Header()
+	if status == http.StatusOK && strings.HasPrefix(hdr.Get("Content-Type"), "text/html") && hdr.Get("Content-Encoding") == "" {
+		for _, rule := range rw.rules {
+			if rule.matches(rw.r) {
+				rw.pending = append(rw.pending, rule)
+			}
+		}
+		rw.active = len(rw.pending) > 0 || len(rw.filters) > 0
+	}
+	if rw.active {
+		hdr.Del("Content-Length")
+		// The file's validators don't cover the rewritten content.
+		hdr.Del("Last-Modified")
+		hdr.Del("ETag")
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *rewriteWriter) Write(p []byte) (int, error) {
+	if !rw.wroteHeader {
+		if rw.Header().Get("Content-Type") == "" {
+			rw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+	if !rw.active {
+		return rw.ResponseWriter.Write(p)
+	}
+	rw.buf = append(rw.buf, p...)
+	if err := rw.process(false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// emit writes b through the filters unless a replace is in progress.
+func (rw *rewriteWriter) emit(b []byte) error {
+	if rw.skipUntil != "" || len(b) == 0 {
+		return nil
+	}
+	if len(rw.filters) == 0 {
+		_, err := rw.ResponseWriter.Write(b)
+		return err
+	}
+	rw.out = append(rw.out, b...)
+	// Hold back an incomplete tag.
+	ready := rw.out
+	var rest []byte
+	if i := bytes.LastIndexByte(rw.out, '<'); i >= 0 && bytes.IndexByte(rw.out[i:], '>') < 0 {
+		ready, rest = rw.out[:i], rw.out[i:]
+	}
+	err := rw.writeFiltered(ready)
+	rw.out = append([]byte(nil), rest...)
+	return err
+}
+
+// writeFiltered applies the filters to b and writes the result.
+func (rw *rewriteWriter) writeFiltered(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	for _, f := range rw.filters {
+		b = f(b)
+	}
+	_, err := rw.ResponseWriter.Write(b)
+	return err
+}
+
+// process applies pending rules to the buffer and writes out everything that
+// can no longer be part of an anchor. If final is set, the whole buffer is
+// written.
+func (rw *rewriteWriter) process(final bool) error {
+	for {
+		// Find the earliest match: the end marker of a replace in
+		// progress, or else the first anchor of any pending rule.
+		at, which, n := -1, -1, 0
+		if rw.skipUntil != "" {
+			if i := bytes.Index(rw.buf, []byte(rw.skipUntil)); i >= 0 {
+				at, n = i, len(rw.skipUntil)
+			}
+		} else {
+			for j, rule := range rw.pending {
+				if i := bytes.Index(rw.buf, []byte(rule.Anchor)); i >= 0 && (at < 0 || i < at) {
+					at, which, n = i, j, len(rule.Anchor)
+				}
+			}
+		}
+		if at < 0 {
+			break
+		}
+		if err := rw.emit(rw.buf[:at]); err != nil {
+			return err
+		}
+		match := rw.buf[at : at+n]
+		rw.buf = rw.buf[at+n:]
+		if which < 0 {
+			// End of a replace.
+			rw.skipUntil = ""
+			continue
+		}
+		// Apply all pending rules with the same anchor here, in order.
+		anchor := rw.pending[which].Anchor
+		var before, after, replace []byte
+		replaced, end := false, ""
+		rest := rw.pending[:0]
+		for _, rule := range rw.pending {
+			if rule.Anchor != anchor {
+				rest = append(rest, rule)
+				continue
+			}
+			content := rule.content(rw.r)
+			switch rule.Action {
+			case rewriteBefore:
+				before = append(before, content...)
+			case rewriteAfter:
+				after = append(after, content...)
+			case rewriteReplace:
+				if !replaced {
+					replaced, replace, end = true, []byte(content), rule.End
+				}
+			}
+		}
+		rw.pending = rest
+		if replaced {
+			match = replace
+		}
+		for _, b := range [][]byte{before, match, after} {
+			if err := rw.emit(b); err != nil {
+				return err
+			}
+		}
+		rw.skipUntil = end
+	}
+	if final {
+		err := rw.emit(rw.buf)
+		rw.buf = nil
+		return err
+	}
+	// Hold back enough to complete the longest anchor.
+	keep := len(rw.skipUntil)
+	for _, rule := range rw.pending {
+		if len(rule.Anchor) > keep {
+			keep = len(rule.Anchor)
+		}
+	}
+	if keep > 0 {
+		keep--
+	}
+	if len(rw.buf) > keep {
+		if err := rw.emit(rw.buf[:len(rw.buf)-keep]); err != nil {
+			return err
+		}
+		rw.buf = append(rw.buf[:0], rw.buf[len(rw.buf)-keep:]...)
+	}
+	return nil
+}
+
+// Flush implements http.Flusher. Held back bytes are not flushed.
+func (rw *rewriteWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes out the remainder of the response.
+func (rw *rewriteWriter) close() {
+	if rw.active {
+		if rw.process(true) == nil {
+			rw.writeFiltered(rw.out)
+		}
+	}
+}
+
+// rewriteHandler applies the rewrite rules and filters to HTML responses, so that
+// elements can be injected or replaced without regenerating the site.
+func rewriteHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (len(rewriteRules) == 0 && len(htmlFilters) == 0) || r.Method == "HEAD" || r.Header.Get("Range") != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rewritten := len(htmlFilters) > 0
+		for _, rule := range rewriteRules {
+			rewritten = rewritten || rule.matches(r)
+		}
+		if rewritten {
+			// Rules may insert content that changes without the
+			// file changing, e.g. announcements, so the page must
+			// not be revalidated against the file alone.
+			r.Header.Del("If-Modified-Since")
+			r.Header.Del("If-None-Match")
+		}
+		rw := &rewriteWriter{ResponseWriter: w, r: r, rules: rewriteRules, filters: htmlFilters}
+		defer rw.close()
+		h.ServeHTTP(rw, r)
+	})
+}
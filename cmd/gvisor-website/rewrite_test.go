@@ -0,0 +1,132 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// chunkedHandler serves body as the given content type, written in chunks of
+// the given size so that anchors are split across writes.
+func chunkedHandler(contentType, body string, chunk int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", "999")
+		for i := 0; i < len(body); i += chunk {
+			end := i + chunk
+			if end > len(body) {
+				end = len(body)
+			}
+			w.Write([]byte(body[i:end]))
+		}
+	})
+}
+
+func TestRewriteWriter(t *testing.T) {
+	defer func(rules []*rewriteRule) { rewriteRules = rules }(rewriteRules)
+	const page = `<html><head><title>t</title></head><body><div id="old">gone</div>keep</body></html>`
+	for _, tc := range []struct {
+		name        string
+		rules       []*rewriteRule
+		path        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{
+			name: "insertions and replace",
+			rules: []*rewriteRule{
+				{Name: "a", Paths: []string{"*"}, Anchor: "</head>", Action: rewriteBefore, Content: "[A]"},
+				{Name: "b", Paths: []string{"/x/*"}, Anchor: `<div id="old">`, End: "</div>", Action: rewriteReplace, Content: "[B]"},
+				{Name: "c", Paths: []string{"*"}, Anchor: "<body>", Action: rewriteAfter, Content: "[C]"},
+			},
+			path: "/x/y",
+			body: page,
+			want: `<html><head><title>t</title>[A]</head><body>[C][B]keep</body></html>`,
+		},
+		{
+			name: "path gating",
+			rules: []*rewriteRule{
+				{Name: "b", Paths: []string{"/x/*"}, Anchor: `<div id="old">`, End: "</div>", Action: rewriteReplace, Content: "[B]"},
+			},
+			path: "/z",
+			body: page,
+			want: page,
+		},
+		{
+			name: "shared anchor",
+			rules: []*rewriteRule{
+				{Name: "a", Paths: []string{"*"}, Anchor: "</head>", Action: rewriteBefore, Content: "[A]"},
+				{Name: "b", Paths: []string{"*"}, Anchor: "</head>", Action: rewriteBefore, Content: "[B]"},
+				{Name: "c", Paths: []string{"*"}, Anchor: "</head>", Action: rewriteAfter, Content: "[C]"},
+			},
+			path: "/",
+			body: "<head></head>x",
+			want: "<head>[A][B]</head>[C]x",
+		},
+		{
+			name: "first occurrence only",
+			rules: []*rewriteRule{
+				{Name: "a", Paths: []string{"*"}, Anchor: "<hr>", Action: rewriteAfter, Content: "[A]"},
+			},
+			path: "/",
+			body: "<hr><hr>",
+			want: "<hr>[A]<hr>",
+		},
+		{
+			name: "missing anchor",
+			rules: []*rewriteRule{
+				{Name: "a", Paths: []string{"*"}, Anchor: "</head>", Action: rewriteBefore, Content: "[A]"},
+			},
+			path: "/",
+			body: "<p>no head</p>",
+			want: "<p>no head</p>",
+		},
+		{
+			name: "not html",
+			rules: []*rewriteRule{
+				{Name: "a", Paths: []string{"*"}, Anchor: "</head>", Action: rewriteBefore, Content: "[A]"},
+			},
+			path:        "/x.txt",
+			contentType: "text/plain",
+			body:        "<head></head>",
+			want:        "<head></head>",
+		},
+	} {
+		for _, r := range tc.rules {
+			if err := r.validate(); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+		}
+		rewriteRules = tc.rules
+		contentType := tc.contentType
+		if contentType == "" {
+			contentType = "text/html; charset=utf-8"
+		}
+		for _, chunk := range []int{1, 2, 3, 7, 1000} {
+			rec := httptest.NewRecorder()
+			rewriteHandler(chunkedHandler(contentType, tc.body, chunk)).ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+			got := rec.Body.String()
+			if got != tc.want {
+				t.Errorf("%s, %d byte writes: got %q, want %q", tc.name, chunk, got, tc.want)
+			}
+			if got != tc.body && rec.Header().Get("Content-Length") != "" {
+				t.Errorf("%s, %d byte writes: Content-Length kept on a rewritten page", tc.name, chunk)
+			}
+		}
+	}
+}
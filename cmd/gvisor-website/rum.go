@@ -0,0 +1,148 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// maxRUMPayload bounds the size of a performance beacon.
+const maxRUMPayload = 4096
+
+// rumMetric describes a web vital accepted by the RUM endpoint.
+type rumMetric struct {
+	hist *histogramVec
+
+	// scale converts reported values to the histogram unit.
+	scale float64
+
+	// max is the largest plausible reported value. Larger values are
+	// rejected as bogus.
+	max float64
+}
+
+// rumLabels are the labels of the RUM histograms. Pages are grouped by
+// section to keep the number of series small.
+var rumLabels = []string{"section"}
+
+// rumMetrics are the web vitals accepted by the RUM endpoint, keyed by the
+// names used by the web-vitals library. Timings are reported in milliseconds
+// and exported in seconds; CLS is unitless.
+var rumMetrics = map[string]rumMetric{
+	"TTFB": {newHistogram("rum_ttfb_seconds", "Time to first byte reported by browsers.", defaultBuckets, rumLabels...), 1e-3, 60e3},
+	"FCP":  {newHistogram("rum_fcp_seconds", "First contentful paint reported by browsers.", defaultBuckets, rumLabels...), 1e-3, 60e3},
+	"LCP":  {newHistogram("rum_lcp_seconds", "Largest contentful paint reported by browsers.", defaultBuckets, rumLabels...), 1e-3, 60e3},
+	"FID":  {newHistogram("rum_fid_seconds", "First input delay reported by browsers.", defaultBuckets, rumLabels...), 1e-3, 60e3},
+	"INP":  {newHistogram("rum_inp_seconds", "Interaction to next paint reported by browsers.", defaultBuckets, rumLabels...), 1e-3, 60e3},
+	"CLS":  {newHistogram("rum_cls", "Cumulative layout shift reported by browsers.", []float64{.01, .025, .05, .1, .15, .25, .5, 1}, rumLabels...), 1, 100},
+}
+
+// rumExperimentValues are the web vitals of pages in experiments, so that
+// variants can be compared. Values are in the unit of the metric's histogram.
+var rumExperimentValues = newHistogram("rum_experiment_values", "Web vitals reported by browsers on pages in experiments, by experiment, variant and metric.", defaultBuckets, "experiment", "variant", "metric")
+
+// rumReport is a performance beacon.
+type rumReport struct {
+	Page    string `json:"page"`
+	Metrics []struct {
+		Name  string  `json:"name"`
+		Value float64 `json:"value"`
+	} `json:"metrics"`
+
+	// Experiments are the variants the page was served, by experiment.
+	Experiments map[string]string `json:"experiments,omitempty"`
+}
+
+// validate checks that all metrics in the report are known and plausible.
+func (rep *rumReport) validate() error {
+	if len(rep.Metrics) == 0 {
+		return fmt.Errorf("no metrics")
+	}
+	if len(rep.Metrics) > len(rumMetrics) {
+		return fmt.Errorf("too many metrics")
+	}
+	for _, m := range rep.Metrics {
+		rm, ok := rumMetrics[m.Name]
+		if !ok {
+			return fmt.Errorf("unknown metric %q", m.Name)
+		}
+		if math.IsNaN(m.Value) || m.Value < 0 || m.Value > rm.max {
+			return fmt.Errorf("invalid %s value %v", m.Name, m.Value)
+		}
+	}
+	for name, v := range rep.Experiments {
+		if e := findExperiment(name); e == nil || !e.runsOn(rep.Page) || !e.hasVariant(v) {
+			return fmt.Errorf("unknown variant %q of experiment %q", v, name)
+		}
+	}
+	return nil
+}
+
+// pageSection returns the top-level section of the given page.
+func pageSection(page string) string {
+	parts := strings.SplitN(strings.TrimPrefix(page, "/"), "/", 2)
+	if len(parts) < 2 {
+		return "home"
+	}
+	return parts[0]
+}
+
+// rumHandler accepts web vitals sent by navigator.sendBeacon as JSON and
+// aggregates them into histograms by site section. Beacons are sent as
+// text/plain to avoid CORS preflights, so the content type is not checked.
+func rumHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRUMPayload))
+		if err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var rep rumReport
+		if err := json.Unmarshal(b, &rep); err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := rep.validate(); err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, ok := knownPage(staticDir, rep.Page)
+		if !ok {
+			httpError(w, r, "invalid request: unknown page", http.StatusBadRequest)
+			return
+		}
+		if trafficClass(r) != classCrawler {
+			section := pageSection(page)
+			for _, m := range rep.Metrics {
+				rm := rumMetrics[m.Name]
+				rm.hist.observe(m.Value*rm.scale, section)
+				for name, v := range rep.Experiments {
+					rumExperimentValues.observe(m.Value*rm.scale, name, v, m.Name)
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
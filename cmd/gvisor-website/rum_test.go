@@ -0,0 +1,125 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// histogramValue returns the number and the sum of the observations of the
+// histogram with the given label values.
+func histogramValue(h *histogramVec, labelValues ...string) (count, sum float64) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.totals[key], h.sums[key]
+}
+
+func TestPageSection(t *testing.T) {
+	for page, want := range map[string]string{
+		"/":                   "home",
+		"/index.html":         "home",
+		"/docs/":              "docs",
+		"/docs/user_guide/":   "docs",
+		"/blog/2019/11/18/x/": "blog",
+	} {
+		if got := pageSection(page); got != want {
+			t.Errorf("pageSection(%q) = %q, want %q", page, got, want)
+		}
+	}
+}
+
+func TestRUMHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rum-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"index.html":           "home",
+		"community/index.html": "community",
+		"css/main.css":         "body{}",
+	})
+	defer setStaticManifest(dir, nil)
+	defer func(es []*experiment) { experiments = es }(experiments)
+	experiments = []*experiment{{Name: "nav", Paths: []string{"/community/*"}, Percent: 100, Variants: []string{"collapsed"}}}
+
+	h := classifyHandler("rum", rumHandler(dir))
+	post := func(body, userAgent string) int {
+		r := httptest.NewRequest("POST", "/api/rum", strings.NewReader(body))
+		r.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	const browser = "Mozilla/5.0 (X11; Linux x86_64) Firefox/70.0"
+	// Metrics are global, so only their increases are checked.
+	lcpCount, lcpSum := histogramValue(rumMetrics["LCP"].hist, "community")
+	clsCount, _ := histogramValue(rumMetrics["CLS"].hist, "community")
+	expCount, _ := histogramValue(rumExperimentValues, "nav", "collapsed", "LCP")
+
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{"not JSON", `{"page":`},
+		{"no metrics", `{"page":"/community/","metrics":[]}`},
+		{"unknown metric", `{"page":"/community/","metrics":[{"name":"FPS","value":60}]}`},
+		{"negative value", `{"page":"/community/","metrics":[{"name":"LCP","value":-1}]}`},
+		{"implausible value", `{"page":"/community/","metrics":[{"name":"CLS","value":1000}]}`},
+		{"too many metrics", `{"page":"/community/","metrics":[` + strings.Repeat(`{"name":"LCP","value":1},`, len(rumMetrics)) + `{"name":"LCP","value":1}]}`},
+		{"unknown page", `{"page":"/missing/","metrics":[{"name":"LCP","value":1200}]}`},
+		{"asset", `{"page":"/css/main.css","metrics":[{"name":"LCP","value":1200}]}`},
+		{"unknown experiment", `{"page":"/community/","metrics":[{"name":"LCP","value":1200}],"experiments":{"footer":"dark"}}`},
+		{"unknown variant", `{"page":"/community/","metrics":[{"name":"LCP","value":1200}],"experiments":{"nav":"hidden"}}`},
+		{"experiment on another page", `{"page":"/","metrics":[{"name":"LCP","value":1200}],"experiments":{"nav":"collapsed"}}`},
+		{"too large", `{"page":"/community/","metrics":[{"name":"LCP","value":1200}],"pad":"` + strings.Repeat("x", maxRUMPayload) + `"}`},
+	} {
+		if code := post(tc.body, browser); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want 400", tc.name, code)
+		}
+	}
+	r := httptest.NewRequest("GET", "/api/rum", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want 405", w.Code)
+	}
+
+	if code := post(`{"page":"/community/","metrics":[{"name":"LCP","value":1200},{"name":"CLS","value":0.05}],"experiments":{"nav":"collapsed"}}`, browser); code != http.StatusNoContent {
+		t.Fatalf("valid beacon: got status %d, want 204", code)
+	}
+	// Crawlers' beacons are accepted but not recorded.
+	if code := post(`{"page":"/community/","metrics":[{"name":"LCP","value":1200}]}`, "Googlebot/2.1 (+http://www.google.com/bot.html)"); code != http.StatusNoContent {
+		t.Errorf("crawler beacon: got status %d, want 204", code)
+	}
+
+	if n, sum := histogramValue(rumMetrics["LCP"].hist, "community"); n-lcpCount != 1 || math.Abs(sum-lcpSum-1.2) > 1e-9 {
+		t.Errorf("got %v more LCP observations adding up to %v, want one of 1.2", n-lcpCount, sum-lcpSum)
+	}
+	if n, _ := histogramValue(rumMetrics["CLS"].hist, "community"); n-clsCount != 1 {
+		t.Errorf("got %v more CLS observations, want 1", n-clsCount)
+	}
+	if n, _ := histogramValue(rumExperimentValues, "nav", "collapsed", "LCP"); n-expCount != 1 {
+		t.Errorf("got %v more LCP observations in the experiment, want 1", n-expCount)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// runscFlagsFile is the runsc flags written into the static dir by
+// generate-reference-docs.
+const runscFlagsFile = "runsc-flags.json"
+
+// runscFlagsPath is the URL path of the runsc flags reference.
+const runscFlagsPath = "/docs/reference/runsc-flags/"
+
+// runscFlag is a flag of runsc.
+type runscFlag struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+	Section string `json:"section,omitempty"`
+
+	// Anchor is the URL of the flag in the flags reference.
+	Anchor string `json:"anchor"`
+}
+
+// loadRunscFlags reads the runsc flags from the static dir.
+func loadRunscFlags(staticDir string) ([]runscFlag, error) {
+	b, err := ioutil.ReadFile(filepath.Join(staticDir, runscFlagsFile))
+	if err != nil {
+		return nil, err
+	}
+	var flags []runscFlag
+	if err := json.Unmarshal(b, &flags); err != nil {
+		return nil, err
+	}
+	for i := range flags {
+		flags[i].Anchor = runscFlagsPath + "#" + flags[i].Name
+	}
+	return flags, nil
+}
+
+var (
+	runscFlagsOnce sync.Once
+	runscFlags     []runscFlag
+)
+
+// getRunscFlags returns the runsc flags, loading them on first use.
+func getRunscFlags(staticDir string) []runscFlag {
+	runscFlagsOnce.Do(func() {
+		var err error
+		runscFlags, err = loadRunscFlags(staticDir)
+		if err != nil {
+			log.Printf("Error loading runsc flags: %v", err)
+		}
+	})
+	return runscFlags
+}
+
+// runscFlagsHandler serves the flags of runsc, optionally only those whose
+// name contains the q parameter or in the given section.
+func runscFlagsHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+		section := r.URL.Query().Get("section")
+		if len(q) > 64 {
+			httpError(w, r, "invalid query", http.StatusBadRequest)
+			return
+		}
+		all := getRunscFlags(staticDir)
+		if all == nil {
+			httpError(w, r, "runsc flags are unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		results := make([]runscFlag, 0, len(all))
+		for _, f := range all {
+			if strings.Contains(strings.ToLower(f.Name), strings.TrimLeft(q, "-")) && (section == "" || f.Section == section) {
+				results = append(results, f)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(results)
+	})
+}
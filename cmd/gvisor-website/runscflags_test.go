@@ -0,0 +1,78 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestRunscFlagsHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runsc-flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := `[
+		{"name": "debug-log", "type": "string", "default": "", "usage": "additional location for logs.", "section": "Debugging flags"},
+		{"name": "platform", "type": "string", "default": "ptrace", "usage": "specifies which platform to use."},
+		{"name": "debug", "type": "bool", "default": "false", "usage": "enable debug logging.", "section": "Debugging flags"}
+	]`
+	if err := ioutil.WriteFile(filepath.Join(dir, runscFlagsFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runscFlagsOnce, runscFlags = sync.Once{}, nil
+	defer func() { runscFlagsOnce, runscFlags = sync.Once{}, nil }()
+
+	h := runscFlagsHandler(dir)
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"debug-log", "platform", "debug"}},
+		{"?q=--DEBUG", []string{"debug-log", "debug"}},
+		{"?section=Debugging+flags&q=log", []string{"debug-log"}},
+		{"?q=network", []string{}},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/runsc/flags"+tc.query, nil))
+		var flags []runscFlag
+		if err := json.NewDecoder(rec.Body).Decode(&flags); err != nil {
+			t.Fatalf("%s: Decode failed: %v", tc.query, err)
+		}
+		names := []string{}
+		for _, f := range flags {
+			names = append(names, f.Name)
+		}
+		if len(names) != len(tc.want) {
+			t.Errorf("%s: got flags %v, want %v", tc.query, names, tc.want)
+			continue
+		}
+		for i := range names {
+			if names[i] != tc.want[i] {
+				t.Errorf("%s: got flags %v, want %v", tc.query, names, tc.want)
+				break
+			}
+		}
+		if len(flags) > 0 && flags[0].Anchor != runscFlagsPath+"#"+flags[0].Name {
+			t.Errorf("%s: anchor = %q", tc.query, flags[0].Anchor)
+		}
+	}
+}
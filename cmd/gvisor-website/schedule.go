@@ -0,0 +1,199 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Content can be merged ahead of its announcement by giving it a date in the
+// future. Hugo leaves such pages out of the site until it is rebuilt after
+// their date, except with -F, as in the devserver. The server doesn't serve
+// their Markdown sources either, except with the dev profile, and the
+// /publish cron handler rebuilds the site once their date passes.
+
+// publishedTTL is how long a publication is remembered in the shared cache,
+// so that other instances don't rebuild the site for it again.
+const publishedTTL = 24 * time.Hour
+
+// frontMatterDateKeys are the front matter keys Hugo takes the publication
+// date of a page from, by precedence.
+var frontMatterDateKeys = []string{"publishdate", "pubdate", "date"}
+
+// frontMatterDateLayouts are the date formats accepted in front matter.
+var frontMatterDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// frontMatter returns the values of the YAML (---) or TOML (+++) front matter
+// of the given page source, by lowercased key. Only the simple forms used by
+// the content are supported: scalars, and lists either inline, e.g.
+// [a, "b"], or, in YAML, as "- item" lines following the key.
+func frontMatter(b []byte) map[string][]string {
+	var delim, sep string
+	switch {
+	case bytes.HasPrefix(b, []byte("---")):
+		delim, sep = "---", ":"
+	case bytes.HasPrefix(b, []byte("+++")):
+		delim, sep = "+++", "="
+	default:
+		return nil
+	}
+	unquote := func(v string) string { return strings.Trim(strings.TrimSpace(v), `"'`) }
+	values := make(map[string][]string)
+	var last string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Scan()
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == delim {
+			break
+		}
+		if strings.HasPrefix(line, "- ") && last != "" {
+			values[last] = append(values[last], unquote(line[2:]))
+			continue
+		}
+		i := strings.Index(line, sep)
+		if i < 0 {
+			continue
+		}
+		key, v := strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+		last = key
+		switch {
+		case v == "":
+			values[key] = nil
+		case strings.HasPrefix(v, "[") && strings.HasSuffix(v, "]"):
+			var list []string
+			for _, item := range strings.Split(v[1:len(v)-1], ",") {
+				if item = unquote(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			values[key] = list
+		default:
+			values[key] = []string{unquote(v)}
+		}
+	}
+	return values
+}
+
+// frontMatterDate returns the publication date in the front matter of the
+// given page source, or the zero time if it has none. Dates without a zone
+// are in UTC, as Hugo assumes by default.
+func frontMatterDate(b []byte) time.Time {
+	values := frontMatter(b)
+	for _, key := range frontMatterDateKeys {
+		v := values[key]
+		if len(v) != 1 {
+			continue
+		}
+		for _, layout := range frontMatterDateLayouts {
+			if t, err := time.Parse(layout, v[0]); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+var (
+	contentDatesOnce sync.Once
+	contentDates     map[string]time.Time
+)
+
+// contentDate returns the publication date of the given page source in the
+// content dir, or the zero time if it has none.
+func contentDate(src string) time.Time {
+	contentDatesOnce.Do(func() {
+		contentDates = make(map[string]time.Time)
+		err := filepath.Walk(*contentDir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || filepath.Ext(p) != ".md" {
+				return err
+			}
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			if t := frontMatterDate(b); !t.IsZero() {
+				contentDates[p] = t
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error reading content dates: %v", err)
+		}
+	})
+	return contentDates[src]
+}
+
+// futureContent returns true if the given page source is dated in the future
+// and so must not be served yet.
+func futureContent(src string) bool {
+	return !deployProfile.future && contentDate(src).After(time.Now())
+}
+
+// dueContent returns the page sources dated after since, when the content
+// was deployed, and no later than now, soonest last.
+func dueContent(since, now time.Time) []string {
+	contentDate("")
+	var due []string
+	for src, t := range contentDates {
+		if t.After(since) && !t.After(now) {
+			due = append(due, src)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return contentDates[due[i]].Before(contentDates[due[j]]) })
+	return due
+}
+
+// publishHandler rebuilds the site if content that was dated in the future
+// when it was deployed is now due, so that it is published shortly after its
+// date. The shared cache remembers the publication, so that each is rebuilt
+// once even while older instances still serve the previous content.
+func publishHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		due := dueContent(processStart, time.Now())
+		if len(due) == 0 {
+			return
+		}
+		key := "publish:" + contentDates[due[len(due)-1]].UTC().Format(time.RFC3339)
+		if _, ok, err := sharedCache.get(ctx, key); err == nil && ok {
+			return
+		}
+		log.Printf("Rebuilding to publish %s", strings.Join(due, ", "))
+		if err := runRebuild(context.Background()); err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sharedCache.set(ctx, key, []byte("1"), publishedTTL)
+		fmt.Fprintf(w, "Publishing %d pages\n", len(due))
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFrontMatterDate(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want time.Time
+	}{
+		{"---\ndate: 2019-11-18\ntitle: \"Part 1\"\n---\n\n# Part 1\n", time.Date(2019, 11, 18, 0, 0, 0, 0, time.UTC)},
+		{"---\ndate: 2019-11-18\npublishDate: 2020-01-02T15:00:00-08:00\n---\n", time.Date(2020, 1, 2, 23, 0, 0, 0, time.UTC)},
+		{"+++\ntitle = \"Docs\"\ndate = \"2020-04-02 09:30:00\"\n+++\n", time.Date(2020, 4, 2, 9, 30, 0, 0, time.UTC)},
+		{"---\ntitle: Undated\n---\ndate: 2020-01-01\n", time.Time{}},
+		{"# No front matter\n", time.Time{}},
+	} {
+		if got := frontMatterDate([]byte(tc.src)); !got.Equal(tc.want) {
+			t.Errorf("frontMatterDate(%q) = %v, want %v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestScheduledContent(t *testing.T) {
+	now := time.Now()
+	contentDatesOnce.Do(func() {})
+	defer func(d map[string]time.Time, p profile) { contentDates, deployProfile = d, p }(contentDates, deployProfile)
+	contentDates = map[string]time.Time{
+		"content/blog/1_old/index.md":       now.Add(-365 * 24 * time.Hour),
+		"content/blog/2_due/index.md":       now.Add(-time.Minute),
+		"content/blog/3_also_due/index.md":  now.Add(-2 * time.Minute),
+		"content/blog/4_embargoed/index.md": now.Add(time.Hour),
+	}
+	deployProfile = profiles["prod"]
+	if futureContent("content/blog/2_due/index.md") || !futureContent("content/blog/4_embargoed/index.md") {
+		t.Errorf("futureContent doesn't hide exactly the content dated in the future")
+	}
+	deployProfile = profiles["dev"]
+	if futureContent("content/blog/4_embargoed/index.md") {
+		t.Errorf("futureContent hides future content with the dev profile")
+	}
+
+	got := dueContent(now.Add(-time.Hour), now)
+	if want := []string{"content/blog/3_also_due/index.md", "content/blog/2_due/index.md"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("dueContent = %q, want %q", got, want)
+	}
+	if got := dueContent(now, now.Add(2*time.Hour)); len(got) != 1 {
+		t.Errorf("dueContent after the embargo = %q, want the embargoed post", got)
+	}
+}
@@ -0,0 +1,620 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// searchDoc is a single indexed page.
+type searchDoc struct {
+	URL   string
+	Title string
+
+	// text is the visible text of the page content.
+	text string
+
+	// terms counts the occurrences of each stemmed term in the text.
+	terms map[string]int
+
+	// titleTerms and headingTerms are the terms in the title and headings,
+	// which are weighted higher.
+	titleTerms   map[string]bool
+	headingTerms map[string]bool
+
+	// section is the section of the site the page is in, and arch the
+	// architecture it is about, if any.
+	section string
+	arch    string
+}
+
+// Sections of the site that searches can be limited to. Pages in none of
+// them are in sectionOther.
+const (
+	sectionDocs          = "docs"
+	sectionBlog          = "blog"
+	sectionCompatibility = "compatibility"
+	sectionOther         = "other"
+)
+
+// searchSections are the sections that searches can be limited to.
+var searchSections = []string{sectionDocs, sectionBlog, sectionCompatibility}
+
+// searchArches are the architectures that searches can be limited to.
+var searchArches = []string{"amd64", "arm64"}
+
+// searchSection returns the search section of the page at the given URL path.
+func searchSection(urlPath string) string {
+	switch {
+	case strings.HasPrefix(urlPath, "/docs/user_guide/compatibility/"):
+		return sectionCompatibility
+	case strings.HasPrefix(urlPath, "/docs/"):
+		return sectionDocs
+	case strings.HasPrefix(urlPath, "/blog/"):
+		return sectionBlog
+	}
+	return sectionOther
+}
+
+// pageArch returns the architecture the page at the given URL path is about,
+// e.g. amd64 for the amd64 syscall compatibility tables, or "" if the page
+// applies to all architectures.
+func pageArch(urlPath string) string {
+	for _, arch := range searchArches {
+		if strings.Contains(urlPath, "/"+arch+"/") {
+			return arch
+		}
+	}
+	return ""
+}
+
+// searchIndex is an in-memory full text index of the rendered site.
+type searchIndex struct {
+	docs []*searchDoc
+
+	// pages counts the documents each term is found in, and is the
+	// vocabulary typos are corrected to.
+	pages map[string]int
+}
+
+var (
+	titleRE   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	mainRE    = regexp.MustCompile(`(?is)<main\b[^>]*>(.*)</main>`)
+	nonTextRE = regexp.MustCompile(`(?is)<(script|style|noscript)\b[^>]*>.*?</(script|style|noscript)>`)
+)
+
+// tokenize splits text into lowercase terms.
+func tokenize(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	terms := fields[:0]
+	for _, f := range fields {
+		if len(f) >= 2 {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// termSet returns the set of stemmed terms in s.
+func termSet(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, t := range searchTerms(s) {
+		m[t] = true
+	}
+	return m
+}
+
+// pageText returns the visible text of the given HTML.
+func pageText(b []byte) string {
+	b = nonTextRE.ReplaceAll(b, nil)
+	return strings.Join(strings.Fields(html.UnescapeString(tagRE.ReplaceAllString(string(b), " "))), " ")
+}
+
+// newSearchDoc indexes the rendered page at the given URL. Only the main
+// content is indexed, so that navigation shared by all pages doesn't match.
+func newSearchDoc(url string, b []byte) *searchDoc {
+	d := &searchDoc{URL: url, terms: make(map[string]int), section: searchSection(url), arch: pageArch(url)}
+	if m := titleRE.FindSubmatch(b); m != nil {
+		d.Title = strings.TrimSuffix(pageText(m[1]), " | gVisor")
+	}
+	if m := mainRE.FindSubmatch(b); m != nil {
+		b = m[1]
+	}
+	d.text = pageText(b)
+	for _, t := range searchTerms(d.text) {
+		d.terms[t]++
+	}
+	d.titleTerms = termSet(d.Title)
+	var headings []string
+	for _, h := range extractHeadings(b) {
+		headings = append(headings, h.Title)
+	}
+	d.headingTerms = termSet(strings.Join(headings, " "))
+	return d
+}
+
+// buildSearchIndex indexes all HTML pages in the static dir.
+func buildSearchIndex(staticDir string) (*searchIndex, error) {
+	idx := &searchIndex{pages: make(map[string]int)}
+	err := filepath.Walk(staticDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staticDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if rel == errorPageDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".html" || rel == "404.html" {
+			return nil
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		url := "/" + rel
+		if strings.HasSuffix(url, "/index.html") || url == "/index.html" {
+			url = strings.TrimSuffix(url, "index.html")
+		}
+		d := newSearchDoc(url, b)
+		idx.docs = append(idx.docs, d)
+		for t := range d.terms {
+			idx.pages[t]++
+		}
+		for t := range d.titleTerms {
+			if d.terms[t] == 0 {
+				idx.pages[t]++
+			}
+		}
+		return nil
+	})
+	return idx, err
+}
+
+// searchResult is a single search result.
+type searchResult struct {
+	URL     string  `json:"url"`
+	Title   string  `json:"title"`
+	Snippet string  `json:"snippet"`
+	Score   float64 `json:"score"`
+}
+
+// searchFacets counts the matches of a search in each section and for each
+// architecture, as if the search were limited to it, so that searches can be
+// refined.
+type searchFacets struct {
+	Sections map[string]int `json:"sections"`
+	Arches   map[string]int `json:"arches"`
+}
+
+// searchResponse is the JSON search API response.
+type searchResponse struct {
+	Query   string         `json:"query"`
+	Results []searchResult `json:"results"`
+	Facets  searchFacets   `json:"facets"`
+}
+
+// searchBoosts are the scores of query terms found in the title and in
+// headings, in addition to their occurrences in the text.
+type searchBoosts struct {
+	title   float64
+	heading float64
+}
+
+// defaultSearchBoosts are the boosts of searches that don't set them.
+var defaultSearchBoosts = searchBoosts{title: 10, heading: 5}
+
+// maxSearchBoost bounds the boosts set by searches.
+const maxSearchBoost = 100
+
+// searchOptions limit searches and weigh their matches.
+type searchOptions struct {
+	// section and arch, if set, limit results to the section and to
+	// pages about the architecture or about all architectures.
+	section string
+	arch    string
+
+	boosts searchBoosts
+}
+
+// matches returns true if the document is in the section and architecture
+// the search is limited to.
+func (o searchOptions) matches(d *searchDoc) bool {
+	return o.matchesSection(d) && o.matchesArch(d.arch)
+}
+
+func (o searchOptions) matchesSection(d *searchDoc) bool {
+	return o.section == "" || d.section == o.section
+}
+
+func (o searchOptions) matchesArch(arch string) bool {
+	return o.arch == "" || arch == "" || arch == o.arch
+}
+
+// snippetLength is the approximate length of result snippets.
+const snippetLength = 160
+
+// indexLower returns the byte offset of the first occurrence of the lowercase
+// term in s, ignoring case, or -1 if there is none. Lowercasing can change
+// the length of s, so offsets into strings.ToLower(s) can't be used in s.
+func indexLower(s, term string) int {
+	for i := range s {
+		j := i
+		match := true
+		for _, tr := range term {
+			r, size := utf8.DecodeRuneInString(s[j:])
+			if size == 0 || unicode.ToLower(r) != tr {
+				match = false
+				break
+			}
+			j += size
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// snippet returns an excerpt of the text around the first query term.
+func (d *searchDoc) snippet(terms []string) string {
+	start := 0
+	for _, t := range terms {
+		if i := indexLower(d.text, t); i >= 0 {
+			start = i
+			break
+		}
+	}
+	start -= snippetLength / 4
+	if start < 0 {
+		start = 0
+	}
+	// Align to a word boundary, or failing that a rune boundary.
+	if start > 0 {
+		if i := strings.IndexByte(d.text[start:], ' '); i >= 0 {
+			start += i + 1
+		}
+		for start < len(d.text) && !utf8.RuneStart(d.text[start]) {
+			start++
+		}
+	}
+	end := start + snippetLength
+	if end >= len(d.text) {
+		end = len(d.text)
+	} else if i := strings.LastIndexByte(d.text[start:end], ' '); i > 0 {
+		end = start + i
+	} else {
+		for end > start && !utf8.RuneStart(d.text[end]) {
+			end--
+		}
+	}
+	s := d.text[start:end]
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(d.text) {
+		s += "…"
+	}
+	return s
+}
+
+// score returns the relevance of the document for the given terms, or 0 if
+// any term is missing.
+func (d *searchDoc) score(terms []string, b searchBoosts) float64 {
+	var score float64
+	for _, t := range terms {
+		n := d.terms[t]
+		if n == 0 && !d.titleTerms[t] {
+			return 0
+		}
+		score += float64(n)
+		if d.titleTerms[t] {
+			score += b.title
+		}
+		if d.headingTerms[t] {
+			score += b.heading
+		}
+	}
+	return score
+}
+
+// search returns up to limit results for the given query, best first, and
+// the facets of all its matches. Queries match stemmed terms, synonyms and
+// corrections of typos.
+func (idx *searchIndex) search(query string, opts searchOptions, limit int) ([]searchResult, searchFacets) {
+	clauses := idx.parseQuery(query)
+	results := []searchResult{}
+	facets := searchFacets{Sections: make(map[string]int), Arches: make(map[string]int)}
+	if len(clauses) == 0 {
+		return results, facets
+	}
+	var terms []string
+	for _, c := range clauses {
+		for _, alt := range c.alternatives {
+			terms = append(terms, alt...)
+		}
+	}
+	for _, d := range idx.docs {
+		score := d.scoreQuery(clauses, opts.boosts)
+		if score <= 0 {
+			continue
+		}
+		// Each facet counts the matches of the other filters, so that
+		// it counts the results of changing its own filter.
+		if opts.matchesArch(d.arch) {
+			facets.Sections[d.section]++
+		}
+		if opts.matchesSection(d) {
+			for _, arch := range searchArches {
+				if d.arch == "" || d.arch == arch {
+					facets.Arches[arch]++
+				}
+			}
+		}
+		if opts.matches(d) {
+			results = append(results, searchResult{
+				URL:     d.URL,
+				Title:   d.Title,
+				Snippet: d.snippet(terms),
+				Score:   score,
+			})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, facets
+}
+
+var (
+	siteIndexMu    sync.Mutex
+	siteIndexBuilt bool
+	siteIndex      *searchIndex
+)
+
+// getSearchIndex returns the index of the static dir, building it on first
+// use. The static dir only changes on deploy, or while it is synced before
+// the instance is ready, after which resetSearchIndex drops the index.
+func getSearchIndex(staticDir string) *searchIndex {
+	siteIndexMu.Lock()
+	defer siteIndexMu.Unlock()
+	if !siteIndexBuilt {
+		var err error
+		siteIndex, err = buildSearchIndex(staticDir)
+		if err != nil {
+			log.Printf("Error building search index: %v", err)
+		}
+		siteIndexBuilt = true
+	}
+	return siteIndex
+}
+
+// resetSearchIndex drops the index, so that the next search indexes the
+// static dir again.
+func resetSearchIndex() {
+	siteIndexMu.Lock()
+	defer siteIndexMu.Unlock()
+	siteIndex, siteIndexBuilt = nil, false
+}
+
+// Search result limits.
+const (
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+var searchResultsTemplate = template.Must(template.New("search").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{.Query}} - gVisor search</title>
+<style>
+body { font-family: "Roboto", sans-serif; margin: 2em; max-width: 50em; }
+a { color: #286FD7; }
+.result { margin-bottom: 1.5em; }
+.url { color: #1a7f37; font-size: 0.9em; }
+.refine { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<form action="/api/search"><input name="q" value="{{.Query}}" size="40"> <button>Search</button></form>
+<h1>Results for “{{.Query}}”</h1>
+{{with .Refinements}}<p class="refine">{{range .}}{{if .Selected}}<strong>{{.Name}}</strong>{{else}}<a href="{{.URL}}">{{.Name}}</a>{{end}} ({{.Count}}) {{end}}</p>{{end}}
+{{range .Results}}<div class="result">
+<a href="{{.URL}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a>
+<div class="url">{{.URL}}</div>
+<div>{{.Snippet}}</div>
+</div>{{else}}<p>No results.</p>{{end}}
+</body>
+</html>
+`))
+
+// searchRefinement links to the results of a search limited to a section or
+// architecture.
+type searchRefinement struct {
+	Name     string
+	Count    int
+	URL      string
+	Selected bool
+}
+
+// searchRefinements returns the links that limit the search of the given
+// request to each non-empty facet.
+func searchRefinements(r *http.Request, facets searchFacets) []searchRefinement {
+	var refinements []searchRefinement
+	add := func(param string, values []string, counts map[string]int) {
+		for _, v := range values {
+			if counts[v] == 0 {
+				continue
+			}
+			q := r.URL.Query()
+			q.Set(param, v)
+			refinements = append(refinements, searchRefinement{
+				Name:     v,
+				Count:    counts[v],
+				URL:      r.URL.Path + "?" + q.Encode(),
+				Selected: r.URL.Query().Get(param) == v,
+			})
+		}
+	}
+	add("section", searchSections, facets.Sections)
+	add("arch", searchArches, facets.Arches)
+	return refinements
+}
+
+// containsString returns true if values contains v.
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSearchOptions returns the filters and boosts of the search query
+// parameters.
+func parseSearchOptions(q url.Values) (searchOptions, error) {
+	opts := searchOptions{section: q.Get("section"), arch: q.Get("arch"), boosts: defaultSearchBoosts}
+	if opts.section != "" && !containsString(searchSections, opts.section) {
+		return opts, fmt.Errorf("section must be one of %s", strings.Join(searchSections, ", "))
+	}
+	if opts.arch != "" && !containsString(searchArches, opts.arch) {
+		return opts, fmt.Errorf("arch must be one of %s", strings.Join(searchArches, ", "))
+	}
+	for _, p := range []struct {
+		name  string
+		boost *float64
+	}{
+		{"title_boost", &opts.boosts.title},
+		{"heading_boost", &opts.boosts.heading},
+	} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > maxSearchBoost {
+			return opts, fmt.Errorf("%s must be between 0 and %d", p.name, maxSearchBoost)
+		}
+		*p.boost = f
+	}
+	return opts, nil
+}
+
+// searchHandler serves search results for the q parameter. Results are
+// returned as JSON by default, as an HTML page to browsers (e.g. address bar
+// searches), or as OpenSearch suggestions with format=suggestions. Results can
+// be limited with the section and arch parameters, and the weight of matches
+// in titles and headings set with title_boost and heading_boost.
+func searchHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		query := strings.TrimSpace(q.Get("q"))
+		limit := defaultSearchLimit
+		if l := q.Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				httpError(w, r, fmt.Sprintf("invalid request: limit must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		opts, err := parseSearchOptions(q)
+		if err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		idx := getSearchIndex(staticDir)
+		if idx == nil {
+			httpError(w, r, "search is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		results, facets := idx.search(query, opts, limit)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Header().Set(searchResultsHeader, strconv.Itoa(len(results)))
+		html := negotiate(w, r, "Accept", "text/html") != ""
+		switch {
+		case q.Get("format") == "suggestions":
+			titles := []string{}
+			for _, res := range results {
+				titles = append(titles, res.Title)
+			}
+			w.Header().Set("Content-Type", "application/x-suggestions+json")
+			json.NewEncoder(w).Encode([]interface{}{query, titles})
+		case html:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			searchResultsTemplate.Execute(w, struct {
+				Query       string
+				Results     []searchResult
+				Refinements []searchRefinement
+			}{query, results, searchRefinements(r, facets)})
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(searchResponse{query, results, facets})
+		}
+	})
+}
+
+var openSearchTemplate = template.Must(template.New("opensearch").Parse(`<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/" xmlns:moz="http://www.mozilla.org/2006/browser/search/">
+<ShortName>gVisor</ShortName>
+<Description>Search the gVisor documentation</Description>
+<InputEncoding>UTF-8</InputEncoding>
+<Image width="16" height="16" type="image/png">{{.}}/favicons/favicon-16x16.png</Image>
+<Url type="text/html" method="get" template="{{.}}/api/search?q={searchTerms}"/>
+<Url type="application/json" method="get" template="{{.}}/api/search?q={searchTerms}"/>
+<Url type="application/x-suggestions+json" method="get" template="{{.}}/api/search?format=suggestions&amp;q={searchTerms}"/>
+<Url type="application/opensearchdescription+xml" rel="self" template="{{.}}/opensearch.xml"/>
+<moz:SearchForm>{{.}}/api/search</moz:SearchForm>
+</OpenSearchDescription>
+`))
+
+// openSearchHandler serves the OpenSearch description of the site search.
+func openSearchHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		io.WriteString(w, xml.Header)
+		openSearchTemplate.Execute(w, "https://"+*customHost)
+	})
+}
@@ -0,0 +1,242 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTokenize(t *testing.T) {
+	for in, want := range map[string][]string{
+		"":                         {},
+		"Run runsc --platform=kvm": {"run", "runsc", "platform", "kvm"},
+		"a b_c 9p I/O":             {"b_c", "9p"},
+		"Überprüfung, café!":       {"überprüfung", "café"},
+	} {
+		got := tokenize(in)
+		if len(got) == 0 && len(want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tokenize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestIndexLower(t *testing.T) {
+	for _, tc := range []struct {
+		s, term string
+		want    int
+	}{
+		{"Hello World", "world", 6},
+		{"hello", "xyz", -1},
+		{"", "a", -1},
+		// U+212A KELVIN SIGN is 3 bytes and lowercases to a 1-byte k.
+		{"KK Kernel", "kernel", 7},
+		{"ÉCOLE école", "école", 0},
+	} {
+		if got := indexLower(tc.s, tc.term); got != tc.want {
+			t.Errorf("indexLower(%q, %q) = %d, want %d", tc.s, tc.term, got, tc.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("word ", 60)
+	for _, tc := range []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{
+			name:  "short",
+			text:  "gVisor is an application kernel.",
+			terms: []string{"kernel"},
+			want:  "gVisor is an application kernel.",
+		},
+		{
+			name:  "match late in text",
+			text:  long + "Sentry",
+			terms: []string{"sentry"},
+			want:  "…" + strings.TrimSpace(long[len(long)-snippetLength/4+4:]) + " Sentry",
+		},
+	} {
+		if got := (&searchDoc{text: tc.text}).snippet(tc.terms); got != tc.want {
+			t.Errorf("%s: snippet = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// Snippets of text without spaces are cut on rune boundaries, wherever
+	// the match is.
+	for _, text := range []string{
+		strings.Repeat("é", 200) + "MATCH" + strings.Repeat("é", 200),
+		strings.Repeat("K", 100) + "match",
+	} {
+		got := (&searchDoc{text: text}).snippet([]string{"match"})
+		if !utf8.ValidString(got) {
+			t.Errorf("snippet of %q = %q, not valid UTF-8", text, got)
+		}
+		if !strings.Contains(strings.ToLower(got), "match") {
+			t.Errorf("snippet of %q = %q, want the match", text, got)
+		}
+	}
+}
+
+func TestSearchScore(t *testing.T) {
+	d := newSearchDoc("/docs/", []byte(`<title>Platforms | gVisor</title>
+<nav>ignored navigation</nav>
+<main><h2 id="kvm">KVM</h2><p>The KVM platform uses kvm. Ptrace is slower.</p></main>`))
+	if d.Title != "Platforms" {
+		t.Errorf("Title = %q, want Platforms", d.Title)
+	}
+	for _, tc := range []struct {
+		query string
+		want  float64
+	}{
+		{"kvm", 3 + 5},
+		{"platforms", 1 + 10},
+		{"ptrace kvm", 1 + 3 + 5},
+		{"navigation", 0},
+		{"kvm missing", 0},
+	} {
+		if got := d.score(searchTerms(tc.query), defaultSearchBoosts); got != tc.want {
+			t.Errorf("score(%q) = %v, want %v", tc.query, got, tc.want)
+		}
+	}
+	if got, want := d.score(searchTerms("kvm"), searchBoosts{title: 0, heading: 20}), 3.0+20; got != want {
+		t.Errorf("score(kvm) with a heading boost of 20 = %v, want %v", got, want)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, page := range map[string]string{
+		"docs/platforms/index.html":                            `<title>Platforms</title><main><p>Syscalls are intercepted.</p></main>`,
+		"docs/user_guide/compatibility/linux/amd64/index.html": `<title>AMD64</title><main><p>Syscalls on amd64.</p></main>`,
+		"docs/user_guide/compatibility/linux/arm64/index.html": `<title>ARM64</title><main><p>Syscalls on arm64.</p></main>`,
+		"blog/2023/syscalls/index.html":                        `<title>Fast syscalls</title><main><p>Syscalls got faster.</p></main>`,
+		"index.html":                                           `<title>gVisor</title><main><p>No matches here.</p></main>`,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(page), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := buildSearchIndex(dir)
+	if err != nil {
+		t.Fatalf("buildSearchIndex failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		opts       searchOptions
+		want       []string
+		wantFacets searchFacets
+	}{
+		{
+			opts: searchOptions{},
+			want: []string{"/blog/2023/syscalls/", "/docs/platforms/", "/docs/user_guide/compatibility/linux/amd64/", "/docs/user_guide/compatibility/linux/arm64/"},
+			wantFacets: searchFacets{
+				Sections: map[string]int{"blog": 1, "docs": 1, "compatibility": 2},
+				Arches:   map[string]int{"amd64": 3, "arm64": 3},
+			},
+		},
+		{
+			opts: searchOptions{section: "compatibility"},
+			want: []string{"/docs/user_guide/compatibility/linux/amd64/", "/docs/user_guide/compatibility/linux/arm64/"},
+			wantFacets: searchFacets{
+				Sections: map[string]int{"blog": 1, "docs": 1, "compatibility": 2},
+				Arches:   map[string]int{"amd64": 1, "arm64": 1},
+			},
+		},
+		{
+			opts: searchOptions{arch: "arm64"},
+			want: []string{"/blog/2023/syscalls/", "/docs/platforms/", "/docs/user_guide/compatibility/linux/arm64/"},
+			wantFacets: searchFacets{
+				Sections: map[string]int{"blog": 1, "docs": 1, "compatibility": 1},
+				Arches:   map[string]int{"amd64": 3, "arm64": 3},
+			},
+		},
+		{
+			opts: searchOptions{section: "docs", arch: "amd64"},
+			want: []string{"/docs/platforms/"},
+			wantFacets: searchFacets{
+				Sections: map[string]int{"blog": 1, "docs": 1, "compatibility": 1},
+				Arches:   map[string]int{"amd64": 1, "arm64": 1},
+			},
+		},
+	} {
+		tc.opts.boosts = defaultSearchBoosts
+		results, facets := idx.search("syscalls", tc.opts, 10)
+		var got []string
+		for _, r := range results {
+			got = append(got, r.URL)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("search(syscalls, %+v) = %q, want %q", tc.opts, got, tc.want)
+		}
+		if !reflect.DeepEqual(facets, tc.wantFacets) {
+			t.Errorf("search(syscalls, %+v) facets = %+v, want %+v", tc.opts, facets, tc.wantFacets)
+		}
+	}
+}
+
+func TestParseSearchOptions(t *testing.T) {
+	for _, tc := range []struct {
+		query   string
+		want    searchOptions
+		wantErr bool
+	}{
+		{query: "", want: searchOptions{boosts: defaultSearchBoosts}},
+		{query: "section=blog&arch=arm64", want: searchOptions{section: "blog", arch: "arm64", boosts: defaultSearchBoosts}},
+		{query: "title_boost=2.5&heading_boost=0", want: searchOptions{boosts: searchBoosts{title: 2.5, heading: 0}}},
+		{query: "section=news", wantErr: true},
+		{query: "arch=riscv64", wantErr: true},
+		{query: "title_boost=-1", wantErr: true},
+		{query: "heading_boost=101", wantErr: true},
+		{query: "title_boost=high", wantErr: true},
+	} {
+		q, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseSearchOptions(q)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseSearchOptions(%q) succeeded, want error", tc.query)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseSearchOptions(%q) = %+v, %v, want %+v", tc.query, got, err, tc.want)
+		}
+	}
+}
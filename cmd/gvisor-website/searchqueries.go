@@ -0,0 +1,190 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// searchResultsHeader carries the number of results of a search, so
+	// that it is known for responses served from the response cache too.
+	searchResultsHeader = "X-Search-Results"
+
+	// maxSearchQueries bounds the distinct queries counted; further queries
+	// are counted as searchQueryOther.
+	maxSearchQueries = 1000
+
+	// maxSearchQueryLength is the length queries are truncated to.
+	maxSearchQueryLength = 100
+
+	// minReportedSearches is the number of times a query must be searched
+	// for it to be reported, so that the report doesn't expose one-off
+	// queries that may identify who made them.
+	minReportedSearches = 2
+
+	// maxReportedQueries is the number of top queries and misses reported.
+	maxReportedQueries = 50
+
+	searchQueryOther = "(other)"
+)
+
+// personalQueryRE matches queries that may contain personal data: email
+// addresses, long numbers such as phone numbers, and tokens.
+var personalQueryRE = regexp.MustCompile(`@|\d{7,}|[A-Za-z0-9_\-]{32,}`)
+
+// normalizeSearchQuery returns the query as it is counted: lowercased, with
+// runs of whitespace collapsed and truncated, or "" if it isn't counted.
+func normalizeSearchQuery(q string) string {
+	q = strings.Join(strings.Fields(strings.ToLower(q)), " ")
+	if q == "" || personalQueryRE.MatchString(q) {
+		return ""
+	}
+	if len(q) > maxSearchQueryLength {
+		q = q[:maxSearchQueryLength]
+		for !utf8.ValidString(q) {
+			q = q[:len(q)-1]
+		}
+	}
+	return q
+}
+
+// searchQueryCount is the count of a single query.
+type searchQueryCount struct {
+	searches int
+	misses   int
+}
+
+// searchQueries counts the queries of the site search and those returning no
+// results, without recording who made them.
+type searchQueries struct {
+	mu       sync.Mutex
+	searches int
+	misses   int
+	queries  map[string]*searchQueryCount
+}
+
+// siteSearchQueries counts the queries of /api/search.
+var siteSearchQueries = newSearchQueries()
+
+// newSearchQueries returns empty query counts.
+func newSearchQueries() *searchQueries {
+	return &searchQueries{queries: make(map[string]*searchQueryCount)}
+}
+
+// record counts a search for the given normalized query with the given
+// number of results.
+func (s *searchQueries) record(q string, results int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.queries[q]
+	if c == nil {
+		if len(s.queries) >= maxSearchQueries {
+			q = searchQueryOther
+			c = s.queries[q]
+		}
+		if c == nil {
+			c = &searchQueryCount{}
+			s.queries[q] = c
+		}
+	}
+	s.searches++
+	c.searches++
+	if results == 0 {
+		s.misses++
+		c.misses++
+	}
+}
+
+// searchQueryStat is the report entry of a single query.
+type searchQueryStat struct {
+	Query    string `json:"query"`
+	Searches int    `json:"searches"`
+	Misses   int    `json:"misses"`
+}
+
+// searchQueryReport summarizes the queries of the site search.
+type searchQueryReport struct {
+	Searches int `json:"searches"`
+	Misses   int `json:"misses"`
+
+	// MissRate is the fraction of searches that returned no results.
+	MissRate float64 `json:"miss_rate"`
+
+	// Top are the most searched queries, and TopMisses the queries that
+	// most often returned no results.
+	Top       []searchQueryStat `json:"top"`
+	TopMisses []searchQueryStat `json:"top_misses"`
+}
+
+// report returns the summary of the queries.
+func (s *searchQueries) report() searchQueryReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := searchQueryReport{Searches: s.searches, Misses: s.misses}
+	if s.searches > 0 {
+		r.MissRate = float64(s.misses) / float64(s.searches)
+	}
+	var stats []searchQueryStat
+	for q, c := range s.queries {
+		if c.searches >= minReportedSearches {
+			stats = append(stats, searchQueryStat{q, c.searches, c.misses})
+		}
+	}
+	top := func(n func(searchQueryStat) int) []searchQueryStat {
+		sort.Slice(stats, func(i, j int) bool {
+			if n(stats[i]) != n(stats[j]) {
+				return n(stats[i]) > n(stats[j])
+			}
+			return stats[i].Query < stats[j].Query
+		})
+		t := []searchQueryStat{}
+		for _, st := range stats {
+			if n(st) == 0 || len(t) == maxReportedQueries {
+				break
+			}
+			t = append(t, st)
+		}
+		return t
+	}
+	r.Top = top(func(st searchQueryStat) int { return st.Searches })
+	r.TopMisses = top(func(st searchQueryStat) int { return st.Misses })
+	return r
+}
+
+// searchQueriesHandler counts the queries of searches served by h, which
+// reports the number of results in searchResultsHeader. As for page views,
+// crawlers and clients sending DNT or GPC are not counted, and neither are
+// the partial queries of search suggestions.
+func searchQueriesHandler(s *searchQueries, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r)
+		if trafficClass(r) == classCrawler || doNotTrack(r) || r.URL.Query().Get("format") == "suggestions" {
+			return
+		}
+		q := normalizeSearchQuery(r.URL.Query().Get("q"))
+		n, err := strconv.Atoi(w.Header().Get(searchResultsHeader))
+		if q == "" || err != nil {
+			return
+		}
+		s.record(q, n)
+	})
+}
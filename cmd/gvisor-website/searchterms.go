@@ -0,0 +1,251 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// stem reduces a term to its stem by stripping common English inflections,
+// so that e.g. "mounts", "mounted" and "mounting" all match "mount". Terms
+// with digits or underscores, such as syscall names, are left alone.
+func stem(t string) string {
+	if len(t) < 4 || strings.IndexFunc(t, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+		return t
+	}
+	switch {
+	case strings.HasSuffix(t, "sses"):
+		t = t[:len(t)-2]
+	case strings.HasSuffix(t, "ies"):
+		t = t[:len(t)-3] + "y"
+	case strings.HasSuffix(t, "ss"), strings.HasSuffix(t, "us"), strings.HasSuffix(t, "is"):
+	case strings.HasSuffix(t, "s"):
+		t = t[:len(t)-1]
+	}
+	for _, suffix := range []string{"ing", "ed"} {
+		s := strings.TrimSuffix(t, suffix)
+		if s == t || len(s) < 3 || strings.IndexAny(s, "aeiouy") < 0 {
+			continue
+		}
+		t = s
+		// Undouble the final consonant, e.g. running -> run.
+		if n := len(t); t[n-1] == t[n-2] && !strings.ContainsRune("aeioulsz", rune(t[n-1])) {
+			t = t[:n-1]
+		}
+		break
+	}
+	if n := len(t); n > 4 && t[n-1] == 'e' {
+		t = t[:n-1]
+	}
+	return t
+}
+
+// searchTerms splits text into the stemmed terms it is indexed and searched
+// by.
+func searchTerms(s string) []string {
+	terms := tokenize(s)
+	for i, t := range terms {
+		terms[i] = stem(t)
+	}
+	return terms
+}
+
+// defaultSearchSynonyms are the built-in groups of equivalent search terms
+// and phrases. Most are names of gVisor components that were renamed or have
+// common alternatives.
+var defaultSearchSynonyms = [][]string{
+	{"ptrace", "systrap"},
+	{"kvm platform", "kvm"},
+	{"k8s", "kubernetes"},
+	{"netstack", "network stack"},
+	{"gofer", "lisafs", "9p"},
+	{"oci", "open container initiative"},
+}
+
+// loadSearchSynonyms returns the synonym groups in the given JSON config file,
+// followed by the built-in groups. A missing file is not an error.
+func loadSearchSynonyms(file string) ([][]string, error) {
+	var groups [][]string
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &groups); err != nil {
+			return nil, fmt.Errorf("invalid search synonyms config %s: %v", file, err)
+		}
+	}
+	for _, g := range groups {
+		if len(g) < 2 {
+			return nil, fmt.Errorf("synonyms %q: at least two terms are required", g)
+		}
+		for _, s := range g {
+			if len(searchTerms(s)) == 0 {
+				return nil, fmt.Errorf("synonyms %q: %q has no searchable terms", g, s)
+			}
+		}
+	}
+	return append(groups, defaultSearchSynonyms...), nil
+}
+
+// synonyms indexes synonym groups by the stemmed terms of their members.
+type synonyms struct {
+	// groups maps the terms of each member, joined by spaces, to the
+	// terms of all members of its group.
+	groups map[string][][]string
+
+	// maxTerms is the number of terms of the longest member.
+	maxTerms int
+}
+
+// newSynonyms indexes the given groups.
+func newSynonyms(groups [][]string) *synonyms {
+	s := &synonyms{groups: make(map[string][][]string)}
+	for _, g := range groups {
+		var members [][]string
+		for _, m := range g {
+			members = append(members, searchTerms(m))
+		}
+		for _, m := range members {
+			key := strings.Join(m, " ")
+			s.groups[key] = append(s.groups[key], members...)
+			if len(m) > s.maxTerms {
+				s.maxTerms = len(m)
+			}
+		}
+	}
+	return s
+}
+
+// alternatives returns the synonyms of the given terms, excluding the terms
+// themselves.
+func (s *synonyms) alternatives(terms []string) [][]string {
+	key := strings.Join(terms, " ")
+	var alts [][]string
+	seen := map[string]bool{key: true}
+	for _, m := range s.groups[key] {
+		if k := strings.Join(m, " "); !seen[k] {
+			seen[k] = true
+			alts = append(alts, m)
+		}
+	}
+	return alts
+}
+
+// searchSynonyms are the synonyms queries are expanded with, set at startup.
+var searchSynonyms = newSynonyms(defaultSearchSynonyms)
+
+// correct returns the indexed term closest to the given term, which isn't
+// indexed, or "" if there is none close enough to be a typo. Longer terms
+// tolerate more typos. Ties go to the term found in the most pages.
+func (idx *searchIndex) correct(t string) string {
+	n := len(t)
+	if n < 4 || strings.IndexFunc(t, unicode.IsDigit) >= 0 {
+		return ""
+	}
+	max := 1
+	if n >= 8 {
+		max = 2
+	}
+	var best string
+	var bestDist int
+	for term, pages := range idx.pages {
+		if d := len(term) - len(t); d > max || -d > max {
+			continue
+		}
+		d := editDistance(t, term)
+		if d > max {
+			continue
+		}
+		if best == "" || d < bestDist || d == bestDist && (pages > idx.pages[best] || pages == idx.pages[best] && term < best) {
+			best, bestDist = term, d
+		}
+	}
+	return best
+}
+
+// searchClause is a part of a query, which documents must match one way or
+// another.
+type searchClause struct {
+	// alternatives are the terms matching the clause, each of which
+	// must all be found. The first are the terms of the query; the
+	// others are synonyms and typo corrections, which score less.
+	alternatives [][]string
+}
+
+// alternativeWeight is how much matches of synonyms and typo corrections
+// score relative to matches of the query's own terms.
+const alternativeWeight = 0.5
+
+// parseQuery splits the query into clauses: its terms, with the synonyms of
+// the longest phrases having any, and corrections of the terms that aren't
+// indexed.
+func (idx *searchIndex) parseQuery(query string) []searchClause {
+	terms := searchTerms(query)
+	var clauses []searchClause
+	for i := 0; i < len(terms); {
+		n := searchSynonyms.maxTerms
+		if n > len(terms)-i {
+			n = len(terms) - i
+		}
+		for ; n > 1; n-- {
+			if len(searchSynonyms.alternatives(terms[i:i+n])) > 0 {
+				break
+			}
+		}
+		if n < 1 {
+			n = 1
+		}
+		c := searchClause{alternatives: [][]string{terms[i : i+n]}}
+		c.alternatives = append(c.alternatives, searchSynonyms.alternatives(terms[i:i+n])...)
+		if t := terms[i]; n == 1 && idx.pages[t] == 0 && len(c.alternatives) == 1 {
+			if corrected := idx.correct(t); corrected != "" {
+				c.alternatives = append(c.alternatives, []string{corrected})
+				c.alternatives = append(c.alternatives, searchSynonyms.alternatives([]string{corrected})...)
+			}
+		}
+		clauses = append(clauses, c)
+		i += n
+	}
+	return clauses
+}
+
+// scoreQuery returns the relevance of the document for the given clauses, or
+// 0 if any clause isn't matched.
+func (d *searchDoc) scoreQuery(clauses []searchClause, b searchBoosts) float64 {
+	var score float64
+	for _, c := range clauses {
+		var best float64
+		for i, alt := range c.alternatives {
+			s := d.score(alt, b)
+			if i > 0 {
+				s *= alternativeWeight
+			}
+			if s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		score += best
+	}
+	return score
+}
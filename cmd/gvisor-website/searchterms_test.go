@@ -0,0 +1,140 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	for in, want := range map[string]string{
+		"mount":         "mount",
+		"mounts":        "mount",
+		"mounted":       "mount",
+		"mounting":      "mount",
+		"running":       "run",
+		"runs":          "run",
+		"configure":     "configur",
+		"configured":    "configur",
+		"configuring":   "configur",
+		"libraries":     "library",
+		"processes":     "process",
+		"status":        "status",
+		"sandboxes":     "sandbox",
+		"sandboxing":    "sandbox",
+		"need":          "need",
+		"clock_gettime": "clock_gettime",
+		"epoll_wait2":   "epoll_wait2",
+		"kvm":           "kvm",
+	} {
+		if got := stem(in); got != want {
+			t.Errorf("stem(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLoadSearchSynonyms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synonyms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	groups, err := loadSearchSynonyms(filepath.Join(dir, "missing.json"))
+	if err != nil || !reflect.DeepEqual(groups, defaultSearchSynonyms) {
+		t.Errorf("missing config: got %q, %v, want the built-in synonyms", groups, err)
+	}
+	for config, ok := range map[string]bool{
+		`[["runsc", "runtime"]]`: true,
+		`[["runsc"]]`:            false,
+		`[["runsc", "!"]]`:       false,
+		`{"runsc": "runtime"}`:   false,
+	} {
+		file := filepath.Join(dir, "synonyms.json")
+		if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSearchSynonyms(file); (err == nil) != ok {
+			t.Errorf("loadSearchSynonyms(%s) = %v, want success %t", config, err, ok)
+		}
+	}
+}
+
+func TestSearchSynonymsAndTypos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, page := range map[string]string{
+		"docs/platforms/index.html":  `<title>Platforms | gVisor</title><main><p>The systrap platform is the default. The KVM platform uses virtualization.</p></main>`,
+		"docs/checkpoint/index.html": `<title>Checkpoint/Restore | gVisor</title><main><p>Checkpointing saves a running container.</p></main>`,
+		"docs/kubernetes/index.html": `<title>Kubernetes | gVisor</title><main><p>Use gVisor in Kubernetes clusters.</p></main>`,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(page), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := buildSearchIndex(dir)
+	if err != nil {
+		t.Fatalf("buildSearchIndex failed: %v", err)
+	}
+	defer func(s *synonyms) { searchSynonyms = s }(searchSynonyms)
+	searchSynonyms = newSynonyms(defaultSearchSynonyms)
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		// Synonyms.
+		{"ptrace", []string{"/docs/platforms/"}},
+		{"k8s", []string{"/docs/kubernetes/"}},
+		{"kvm platform", []string{"/docs/platforms/"}},
+		// Stemming.
+		{"checkpoints", []string{"/docs/checkpoint/"}},
+		{"runs containers", []string{"/docs/checkpoint/"}},
+		// Typos.
+		{"chekcpoint", []string{"/docs/checkpoint/"}},
+		{"kubernets", []string{"/docs/kubernetes/"}},
+		{"platfrom", []string{"/docs/platforms/"}},
+		// Short terms aren't corrected.
+		{"kvn", nil},
+		{"missingword", nil},
+	} {
+		results, _ := idx.search(tc.query, searchOptions{boosts: defaultSearchBoosts}, 10)
+		var got []string
+		for _, r := range results {
+			got = append(got, r.URL)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("search(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+
+	// Exact matches score higher than synonyms and corrections.
+	opts := searchOptions{boosts: defaultSearchBoosts}
+	exact, _ := idx.search("systrap", opts, 1)
+	synonym, _ := idx.search("ptrace", opts, 1)
+	if len(exact) != 1 || len(synonym) != 1 || synonym[0].Score >= exact[0].Score {
+		t.Errorf("got exact results %+v and synonym results %+v, want the synonym to score less", exact, synonym)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// shadowHeader is set on mirrored requests, so that the shadow server
+	// can tell them apart, e.g. to skip side effects.
+	shadowHeader = "X-Shadow"
+
+	// shadowTimeout bounds a mirrored request, including its body.
+	shadowTimeout = 30 * time.Second
+)
+
+// shadowHeaders are the request headers copied to mirrored requests. Cookies
+// and credentials are not sent to the shadow server.
+var shadowHeaders = []string{"Accept", "Accept-Language", "Git-Protocol", "User-Agent", "Range"}
+
+var shadowRequests = newCounter("shadow_requests_total", "Requests mirrored to the shadow server, by route and result: match, mismatch, error or dropped.", "route", "result")
+
+// shadowMirror mirrors requests to a shadow server and compares the status
+// codes of its responses with the served ones.
+type shadowMirror struct {
+	upstream *url.URL
+	client   *http.Client
+	fraction float64
+	routes   map[string]bool
+
+	// inFlight bounds the mirrored requests in flight.
+	inFlight chan struct{}
+}
+
+// shadow mirrors requests if set. It is set at startup.
+var shadow *shadowMirror
+
+// newShadowMirror returns a mirror of the given fraction of the requests of
+// the comma-separated routes to the upstream URL, or nil if upstream is empty.
+func newShadowMirror(upstream string, fraction float64, routes string, maxInFlight int) (*shadowMirror, error) {
+	if upstream == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid shadow upstream %q", upstream)
+	}
+	if fraction < 0 || fraction > 1 {
+		return nil, fmt.Errorf("shadow fraction %g is not between 0 and 1", fraction)
+	}
+	if maxInFlight < 1 {
+		return nil, fmt.Errorf("shadow requests in flight must be positive")
+	}
+	s := &shadowMirror{
+		upstream: u,
+		client:   upstreamClient("shadow"),
+		fraction: fraction,
+		routes:   make(map[string]bool),
+		inFlight: make(chan struct{}, maxInFlight),
+	}
+	s.client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	for _, route := range strings.Split(routes, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			s.routes[route] = true
+		}
+	}
+	return s, nil
+}
+
+// mirror sends a copy of the request to the shadow server in the background,
+// and records whether its status matches the served one. Requests beyond
+// the limit in flight are dropped rather than queued.
+func (s *shadowMirror) mirror(route string, r *http.Request, status int) {
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		shadowRequests.inc(route, "dropped")
+		return
+	}
+	req, err := http.NewRequest(r.Method, strings.TrimSuffix(s.upstream.String(), "/")+r.URL.RequestURI(), nil)
+	if err != nil {
+		<-s.inFlight
+		shadowRequests.inc(route, "error")
+		return
+	}
+	for _, name := range shadowHeaders {
+		if v, ok := r.Header[name]; ok {
+			req.Header[name] = v
+		}
+	}
+	req.Header.Set(shadowHeader, "1")
+	if id := requestID(r); id != "" {
+		req.Header.Set("X-Request-Id", id)
+	}
+	go func() {
+		defer func() { <-s.inFlight }()
+		ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+		defer cancel()
+		resp, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			log.Printf("Shadow %s %s route=%s: %v", r.Method, req.URL.RequestURI(), route, err)
+			shadowRequests.inc(route, "error")
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			log.Printf("Shadow mismatch: %s %s route=%s status=%d shadow_status=%d", r.Method, req.URL.RequestURI(), route, status, resp.StatusCode)
+			shadowRequests.inc(route, "mismatch")
+			return
+		}
+		shadowRequests.inc(route, "match")
+	}()
+}
+
+// shadowHandler mirrors a fraction of the GET and HEAD requests of the route
+// to the shadow server, if it is set and the route is mirrored. The response
+// is served by h as usual; the shadow server's is only compared.
+func shadowHandler(route string, h http.Handler) http.Handler {
+	s := shadow
+	if s == nil || !s.routes[route] {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != "GET" && r.Method != "HEAD") || rand.Float64() >= s.fraction {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		s.mirror(route, r, rec.code())
+	})
+}
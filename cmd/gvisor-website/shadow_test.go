@@ -0,0 +1,118 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// shadowCount returns the count of mirrored requests of the route with the
+// given result.
+func shadowCount(route, result string) float64 {
+	shadowRequests.mu.Lock()
+	defer shadowRequests.mu.Unlock()
+	return shadowRequests.values[labelKey([]string{route, result})]
+}
+
+func TestShadowHandler(t *testing.T) {
+	defer func(s *shadowMirror) { shadow = s }(shadow)
+	var mu sync.Mutex
+	var mirrored []*http.Request
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		mirrored = append(mirrored, r)
+		mu.Unlock()
+		if r.URL.Path == "/slow" {
+			<-block
+		}
+	}))
+	defer srv.Close()
+	var err error
+	shadow, err = newShadowMirror(srv.URL, 1, "shadow-test, other", 1)
+	if err != nil {
+		t.Fatalf("newShadowMirror failed: %v", err)
+	}
+	served := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	})
+	h := shadowHandler("shadow-test", served)
+
+	wait := func(result string, want float64) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); shadowCount("shadow-test", result) < want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %v %s mirrored requests, want %v", shadowCount("shadow-test", result), result, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	match, mismatch, dropped := shadowCount("shadow-test", "match"), shadowCount("shadow-test", "mismatch"), shadowCount("shadow-test", "dropped")
+
+	req := httptest.NewRequest("GET", "/info?x=1", nil)
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("Git-Protocol", "version=2")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	wait("match", match+1)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+	wait("mismatch", mismatch+1)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/info", nil))
+
+	// Requests beyond the limit in flight are dropped.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/info", nil))
+	wait("dropped", dropped+1)
+	close(block)
+	wait("match", match+2)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(mirrored) != 3 {
+		t.Fatalf("got %d mirrored requests, want 3", len(mirrored))
+	}
+	first := mirrored[0]
+	if first.URL.RequestURI() != "/info?x=1" || first.Header.Get(shadowHeader) != "1" || first.Header.Get("Git-Protocol") != "version=2" {
+		t.Errorf("mirrored request %s with headers %v, want /info?x=1 with the shadow and Git-Protocol headers", first.URL, first.Header)
+	}
+	if first.Header.Get("Cookie") != "" {
+		t.Errorf("mirrored request has cookies %q", first.Header.Get("Cookie"))
+	}
+}
+
+func TestNewShadowMirror(t *testing.T) {
+	if s, err := newShadowMirror("", 0.5, "raw", 1); s != nil || err != nil {
+		t.Errorf("newShadowMirror without upstream = %v, %v; want nil", s, err)
+	}
+	for _, tc := range []struct {
+		upstream    string
+		fraction    float64
+		maxInFlight int
+	}{
+		{"ftp://shadow", 0.5, 1},
+		{"https://", 0.5, 1},
+		{"https://shadow", 1.5, 1},
+		{"https://shadow", 0.5, 0},
+	} {
+		if _, err := newShadowMirror(tc.upstream, tc.fraction, "raw", tc.maxInFlight); err == nil {
+			t.Errorf("newShadowMirror(%q, %g, %d) succeeded, want error", tc.upstream, tc.fraction, tc.maxInFlight)
+		}
+	}
+}
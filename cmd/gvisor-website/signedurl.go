@@ -0,0 +1,293 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// cloudPlatformScope is the OAuth scope required to sign blobs as a
+	// service account.
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	// storageHost is the host signed URLs point to.
+	storageHost = "storage.googleapis.com"
+)
+
+// urlSigner mints V4 signed URLs for objects in private Cloud Storage
+// buckets, so that reviewers can download preview artifacts and build outputs
+// without the buckets being public.
+//
+// App Engine has no service account key to sign with, so blobs are signed by
+// the IAM Credentials API as the given service account, which the app's
+// service account must be allowed to act as.
+type urlSigner struct {
+	// account is the email of the service account URLs are signed as.
+	account string
+
+	// buckets are the buckets URLs may be signed for.
+	buckets map[string]bool
+
+	// prefixes are the object name prefixes URLs may be signed for.
+	prefixes []string
+
+	// maxTTL bounds how long signed URLs are valid.
+	maxTTL time.Duration
+
+	// sign signs a blob as the service account.
+	sign func(ctx context.Context, blob []byte) ([]byte, error)
+}
+
+// signer is nil unless signed URLs are enabled with --signing-account.
+var signer *urlSigner
+
+// newURLSigner returns a signer for the objects with the given
+// comma-separated prefixes in the given comma-separated buckets, using the
+// application default credentials.
+func newURLSigner(ctx context.Context, account, buckets, prefixes string, maxTTL time.Duration) (*urlSigner, error) {
+	// V4 signed URLs are valid for at most a week.
+	if maxTTL < time.Second || maxTTL > 7*24*time.Hour {
+		return nil, fmt.Errorf("invalid TTL %v: must be between 1s and 168h", maxTTL)
+	}
+	var ps []string
+	for _, p := range strings.Split(prefixes, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	if len(ps) == 0 {
+		return nil, fmt.Errorf("no object prefixes to sign URLs for")
+	}
+	client, err := googleClient(ctx, "iamcredentials", cloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	s := &urlSigner{
+		account:  account,
+		buckets:  make(map[string]bool),
+		prefixes: ps,
+		maxTTL:   maxTTL,
+		sign: func(ctx context.Context, blob []byte) ([]byte, error) {
+			return signBlob(ctx, client, account, blob)
+		},
+	}
+	for _, b := range strings.Split(buckets, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			s.buckets[b] = true
+		}
+	}
+	return s, nil
+}
+
+// signBlob signs the blob as the given service account with the IAM
+// Credentials API.
+func signBlob(ctx context.Context, client *http.Client, account string, blob []byte) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{"payload": base64.StdEncoding.EncodeToString(blob)})
+	if err != nil {
+		return nil, err
+	}
+	u := "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/" + url.PathEscape(account) + ":signBlob"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signBlob: %s", resp.Status)
+	}
+	var res struct {
+		SignedBlob string `json:"signedBlob"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.SignedBlob)
+}
+
+// escapeRFC3986 percent-encodes everything but unreserved characters, and
+// slashes if path is true, as required in canonical requests.
+func escapeRFC3986(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// validObject returns true if the object name is one that signed URLs may be
+// minted for. Names that would be rewritten by clients, such as those with
+// dot segments, are rejected.
+func validObject(object string) bool {
+	if object == "" || len(object) > 1024 || strings.HasPrefix(object, "/") {
+		return false
+	}
+	for _, seg := range strings.Split(object, "/") {
+		if seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return !strings.ContainsAny(object, "\r\n")
+}
+
+// allowedObject returns true if the object has one of the signer's prefixes.
+func (s *urlSigner) allowedObject(object string) bool {
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(object, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringToSign returns the canonical query string of a V4 signed URL for a
+// GET of the object, and the string to sign for it.
+func (s *urlSigner) stringToSign(bucket, object string, now time.Time, ttl time.Duration) (query, toSign string) {
+	now = now.UTC()
+	date := now.Format("20060102")
+	timestamp := now.Format("20060102T150405Z")
+	scope := date + "/auto/storage/goog4_request"
+
+	// Parameters are sorted by name.
+	query = strings.Join([]string{
+		"X-Goog-Algorithm=GOOG4-RSA-SHA256",
+		"X-Goog-Credential=" + escapeRFC3986(s.account+"/"+scope, false),
+		"X-Goog-Date=" + timestamp,
+		"X-Goog-Expires=" + fmt.Sprint(int64(ttl/time.Second)),
+		"X-Goog-SignedHeaders=host",
+	}, "&")
+	canonical := strings.Join([]string{
+		"GET",
+		"/" + escapeRFC3986(bucket+"/"+object, true),
+		query,
+		"host:" + storageHost,
+		"",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	toSign = strings.Join([]string{"GOOG4-RSA-SHA256", timestamp, scope, hex.EncodeToString(hash[:])}, "\n")
+	return query, toSign
+}
+
+// signedURL returns a URL to GET the object, valid for ttl from now.
+func (s *urlSigner) signedURL(ctx context.Context, bucket, object string, now time.Time, ttl time.Duration) (string, error) {
+	query, toSign := s.stringToSign(bucket, object, now, ttl)
+	sig, err := s.sign(ctx, []byte(toSign))
+	if err != nil {
+		return "", err
+	}
+	return "https://" + storageHost + "/" + escapeRFC3986(bucket+"/"+object, true) + "?" + query + "&X-Goog-Signature=" + hex.EncodeToString(sig), nil
+}
+
+// signedURLResponse is served by /admin/signed-url.
+type signedURLResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// signerHandler wraps an http.Handler to check that the request carries the
+// signer token or the admin token as a bearer token. The signer token only
+// grants access to signed URLs, so that clients such as CI jobs downloading
+// build outputs don't need the admin token. If neither token is configured,
+// signed URLs are disabled.
+func signerHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *signerToken == "" && *adminToken == "" {
+			httpError(w, r, "Not found", http.StatusNotFound)
+			return
+		}
+		if !hasBearerToken(r, *signerToken) && !hasBearerToken(r, *adminToken) {
+			httpError(w, r, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// Fallthrough.
+		h.ServeHTTP(w, r)
+	})
+}
+
+// adminSignedURLHandler mints signed URLs for allowed objects in the allowed
+// buckets, given by the bucket and object parameters. The ttl parameter sets how long
+// the URL is valid, up to the signer's maximum, which is the default.
+func adminSignedURLHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := signer
+		if s == nil {
+			httpError(w, r, "signed URLs are disabled", http.StatusNotFound)
+			return
+		}
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		bucket, object := q.Get("bucket"), q.Get("object")
+		if !s.buckets[bucket] {
+			httpError(w, r, "bucket not allowed", http.StatusForbidden)
+			return
+		}
+		if !validObject(object) {
+			httpError(w, r, "invalid request: invalid object", http.StatusBadRequest)
+			return
+		}
+		if !s.allowedObject(object) {
+			httpError(w, r, "object not allowed", http.StatusForbidden)
+			return
+		}
+		ttl := s.maxTTL
+		if v := q.Get("ttl"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < time.Second || d > s.maxTTL {
+				httpError(w, r, fmt.Sprintf("invalid request: ttl must be between 1s and %v", s.maxTTL), http.StatusBadRequest)
+				return
+			}
+			ttl = d
+		}
+		now := time.Now()
+		u, err := s.signedURL(r.Context(), bucket, object, now, ttl)
+		if err != nil {
+			httpError(w, r, "upstream error: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.Printf("Signed URL for gs://%s/%s, valid for %v", bucket, object, ttl)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(signedURLResponse{URL: u, Expires: now.Add(ttl).UTC()})
+	})
+}
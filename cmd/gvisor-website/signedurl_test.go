@@ -0,0 +1,197 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscapeRFC3986(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		path bool
+		want string
+	}{
+		{in: "previews/123/index.html", path: true, want: "previews/123/index.html"},
+		{in: "a b+c~d", path: true, want: "a%20b%2Bc~d"},
+		{in: "sa@p.iam.gserviceaccount.com/20190101/auto", want: "sa%40p.iam.gserviceaccount.com%2F20190101%2Fauto"},
+		{in: "ü", want: "%C3%BC"},
+	} {
+		if got := escapeRFC3986(tc.in, tc.path); got != tc.want {
+			t.Errorf("escapeRFC3986(%q, %t) = %q, want %q", tc.in, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestValidObject(t *testing.T) {
+	for object, want := range map[string]bool{
+		"previews/123/index.html": true,
+		"builds/out.tar.gz":       true,
+		"":                        false,
+		"/previews/123":           false,
+		"previews/../secret":      false,
+		"./previews":              false,
+		"a\nb":                    false,
+	} {
+		if got := validObject(object); got != want {
+			t.Errorf("validObject(%q) = %t, want %t", object, got, want)
+		}
+	}
+}
+
+func TestStringToSign(t *testing.T) {
+	s := &urlSigner{account: "sa@p.iam.gserviceaccount.com"}
+	now := time.Date(2019, 8, 1, 12, 30, 0, 0, time.FixedZone("PDT", -7*3600))
+	query, toSign := s.stringToSign("bucket", "previews/1/a b.html", now, 15*time.Minute)
+
+	wantQuery := "X-Goog-Algorithm=GOOG4-RSA-SHA256" +
+		"&X-Goog-Credential=sa%40p.iam.gserviceaccount.com%2F20190801%2Fauto%2Fstorage%2Fgoog4_request" +
+		"&X-Goog-Date=20190801T193000Z" +
+		"&X-Goog-Expires=900" +
+		"&X-Goog-SignedHeaders=host"
+	if query != wantQuery {
+		t.Errorf("got query %q, want %q", query, wantQuery)
+	}
+	canonical := "GET\n/bucket/previews/1/a%20b.html\n" + wantQuery + "\nhost:storage.googleapis.com\n\nhost\nUNSIGNED-PAYLOAD"
+	hash := sha256.Sum256([]byte(canonical))
+	wantToSign := "GOOG4-RSA-SHA256\n20190801T193000Z\n20190801/auto/storage/goog4_request\n" + hex.EncodeToString(hash[:])
+	if toSign != wantToSign {
+		t.Errorf("got string to sign %q, want %q", toSign, wantToSign)
+	}
+}
+
+func TestAdminSignedURLHandler(t *testing.T) {
+	defer func(s *urlSigner) { signer = s }(signer)
+
+	rec := httptest.NewRecorder()
+	signer = nil
+	adminSignedURLHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/signed-url?bucket=b&object=o", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got status %d with signing disabled, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	var signed string
+	signer = &urlSigner{
+		account:  "sa@p.iam.gserviceaccount.com",
+		buckets:  map[string]bool{"previews": true},
+		prefixes: []string{"previews/"},
+		maxTTL:   15 * time.Minute,
+		sign: func(ctx context.Context, blob []byte) ([]byte, error) {
+			signed = string(blob)
+			return []byte{0xde, 0xad}, nil
+		},
+	}
+	for _, tc := range []struct {
+		name       string
+		method     string
+		query      string
+		wantStatus int
+		wantTTL    string
+	}{
+		{name: "default ttl", method: "GET", query: "bucket=previews&object=previews/1/index.html", wantStatus: http.StatusOK, wantTTL: "900"},
+		{name: "ttl", method: "GET", query: "bucket=previews&object=previews/1/index.html&ttl=1m", wantStatus: http.StatusOK, wantTTL: "60"},
+		{name: "ttl too long", method: "GET", query: "bucket=previews&object=previews/o&ttl=1h", wantStatus: http.StatusBadRequest},
+		{name: "invalid ttl", method: "GET", query: "bucket=previews&object=previews/o&ttl=soon", wantStatus: http.StatusBadRequest},
+		{name: "bucket not allowed", method: "GET", query: "bucket=private&object=previews/o", wantStatus: http.StatusForbidden},
+		{name: "invalid object", method: "GET", query: "bucket=previews&object=previews/../o", wantStatus: http.StatusBadRequest},
+		{name: "object not allowed", method: "GET", query: "bucket=previews&object=builds/o", wantStatus: http.StatusForbidden},
+		{name: "post", method: "POST", query: "bucket=previews&object=previews/o", wantStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			signed = ""
+			rec := httptest.NewRecorder()
+			adminSignedURLHandler().ServeHTTP(rec, httptest.NewRequest(tc.method, "/admin/signed-url?"+tc.query, nil))
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tc.wantStatus, rec.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				if signed != "" {
+					t.Errorf("rejected request was signed")
+				}
+				return
+			}
+			var res signedURLResponse
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			u, err := url.Parse(res.URL)
+			if err != nil {
+				t.Fatalf("invalid URL %q: %v", res.URL, err)
+			}
+			if u.Host != "storage.googleapis.com" || u.Path != "/previews/previews/1/index.html" {
+				t.Errorf("got URL %q, want the object on storage.googleapis.com", res.URL)
+			}
+			q := u.Query()
+			if q.Get("X-Goog-Expires") != tc.wantTTL || q.Get("X-Goog-Signature") != "dead" {
+				t.Errorf("got URL %q, want expiry %s and signature dead", res.URL, tc.wantTTL)
+			}
+			if !strings.HasPrefix(signed, "GOOG4-RSA-SHA256\n") {
+				t.Errorf("signed %q, want a V4 string to sign", signed)
+			}
+			if rec.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("got Cache-Control %q, want no-store", rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestSignerHandler(t *testing.T) {
+	defer func(admin, signer string) { *adminToken, *signerToken = admin, signer }(*adminToken, *signerToken)
+	h := signerHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		name          string
+		admin, signer string
+		token         string
+		want          int
+	}{
+		{"disabled", "", "", "", http.StatusNotFound},
+		{"signer token", "admin", "signer", "signer", http.StatusOK},
+		{"admin token", "admin", "signer", "admin", http.StatusOK},
+		{"admin token without a signer token", "admin", "", "admin", http.StatusOK},
+		{"signer token without an admin token", "", "signer", "signer", http.StatusOK},
+		{"wrong token", "admin", "signer", "other", http.StatusUnauthorized},
+		{"no token", "admin", "signer", "", http.StatusUnauthorized},
+	} {
+		*adminToken, *signerToken = tc.admin, tc.signer
+		r := httptest.NewRequest("GET", "/admin/signed-url", nil)
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.want)
+		}
+	}
+
+	// The signer token doesn't grant access to the rest of the admin API.
+	*adminToken, *signerToken = "admin", "signer"
+	r := httptest.NewRequest("GET", "/admin/redirects", nil)
+	r.Header.Set("Authorization", "Bearer signer")
+	w := httptest.NewRecorder()
+	adminHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("admin API with the signer token: got status %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	xhtmlNamespace   = "http://www.w3.org/1999/xhtml"
+
+	// sitemapIndexFile is the sitemap that search engines are pointed
+	// at, listing the sitemap of each section.
+	sitemapIndexFile = "sitemap.xml"
+
+	// sitemapPagesSection is the sitemap of the pages in no section,
+	// e.g. the home page.
+	sitemapPagesSection = "pages"
+)
+
+// sitemapLink is an hreflang alternate of a sitemap URL.
+type sitemapLink struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+// hugoSitemap is a sitemap or sitemap index written by Hugo.
+type hugoSitemap struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc        string        `xml:"loc"`
+		Lastmod    string        `xml:"lastmod"`
+		Changefreq string        `xml:"changefreq"`
+		Priority   string        `xml:"priority"`
+		Links      []sitemapLink `xml:"http://www.w3.org/1999/xhtml link"`
+	} `xml:"url"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+
+// sitemapURL is a URL in a sitemap.
+type sitemapURL struct {
+	Loc        string        `xml:"loc"`
+	Lastmod    string        `xml:"lastmod,omitempty"`
+	Changefreq string        `xml:"changefreq,omitempty"`
+	Priority   string        `xml:"priority,omitempty"`
+	Links      []sitemapLink `xml:"xhtml:link"`
+
+	// lang is the language of the page, and base the path of the page
+	// in the default language, which its translations share.
+	lang string
+	base string
+}
+
+// urlset is a sitemap.
+type urlset struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Xhtml   string       `xml:"xmlns:xhtml,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// sitemapRef is a sitemap in a sitemap index.
+type sitemapRef struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapIndex lists the sitemaps of the site.
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapRef `xml:"sitemap"`
+}
+
+// sitemapLanguage returns the language of the page at the given URL path,
+// from its language prefix, and the path of the page without it.
+func sitemapLanguage(p string) (lang, base string) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	for _, l := range languages[1:] {
+		if parts[0] == l {
+			if len(parts) < 2 {
+				return l, "/"
+			}
+			return l, "/" + parts[1]
+		}
+	}
+	return defaultLanguage, p
+}
+
+// sitemapSection returns the sitemap that the page at the given path, without
+// a language prefix, is listed in.
+func sitemapSection(p string) string {
+	if s := searchSection(p); s != sectionOther {
+		return s
+	}
+	return sitemapPagesSection
+}
+
+// readHugoSitemap returns the URLs of the sitemap Hugo wrote to the static
+// dir, and the files it is made of. Multilingual sites have a sitemap per
+// language, listed in a sitemap index.
+func readHugoSitemap(staticDir string) ([]sitemapURL, []string, error) {
+	var urls []sitemapURL
+	var files []string
+	var read func(file string) error
+	read = func(file string) error {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		var s hugoSitemap
+		if err := xml.Unmarshal(b, &s); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for _, child := range s.Sitemaps {
+			u, err := url.Parse(child.Loc)
+			if err != nil {
+				return fmt.Errorf("%s: %v", file, err)
+			}
+			if err := read(filepath.Join(staticDir, filepath.FromSlash(path.Clean("/"+u.Path)))); err != nil {
+				return err
+			}
+		}
+		for _, u := range s.URLs {
+			urls = append(urls, sitemapURL{Loc: u.Loc, Lastmod: u.Lastmod, Changefreq: u.Changefreq, Priority: u.Priority, Links: u.Links})
+		}
+		return nil
+	}
+	if err := read(filepath.Join(staticDir, sitemapIndexFile)); err != nil {
+		return nil, nil, err
+	}
+	return urls, files, nil
+}
+
+// addAlternates adds the hreflang alternates of translated pages to the
+// URLs. Translations are the pages at the same path with a language prefix,
+// and the alternates Hugo found. Each translation lists all of them, itself
+// included, and the page in the default language as x-default.
+func addAlternates(urls []sitemapURL) {
+	translations := make(map[string]map[string]string)
+	for i := range urls {
+		u, err := url.Parse(urls[i].Loc)
+		if err != nil {
+			continue
+		}
+		urls[i].lang, urls[i].base = sitemapLanguage(u.Path)
+		if translations[urls[i].base] == nil {
+			translations[urls[i].base] = make(map[string]string)
+		}
+		translations[urls[i].base][urls[i].lang] = urls[i].Loc
+	}
+	for i := range urls {
+		t := translations[urls[i].base]
+		for _, l := range urls[i].Links {
+			if _, ok := t[l.Hreflang]; !ok && l.Hreflang != "x-default" {
+				t[l.Hreflang] = l.Href
+			}
+		}
+	}
+	for i := range urls {
+		t := translations[urls[i].base]
+		urls[i].Links = nil
+		if len(t) < 2 {
+			continue
+		}
+		for _, lang := range languages {
+			if href, ok := t[lang]; ok {
+				urls[i].Links = append(urls[i].Links, sitemapLink{"alternate", lang, href})
+			}
+		}
+		if href, ok := t[defaultLanguage]; ok {
+			urls[i].Links = append(urls[i].Links, sitemapLink{"alternate", "x-default", href})
+		}
+	}
+}
+
+// latestLastmod returns the latest modification time of the URLs, or "" if
+// none has one.
+func latestLastmod(urls []sitemapURL) string {
+	var latest time.Time
+	var s string
+	for _, u := range urls {
+		if t, err := time.Parse(time.RFC3339, u.Lastmod); err == nil && t.After(latest) {
+			latest, s = t, u.Lastmod
+		}
+	}
+	return s
+}
+
+// writeXML writes the XML document to the file.
+func writeXML(file string, v interface{}) error {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
+
+// writeSitemaps replaces the sitemap Hugo wrote to the static dir with a
+// sitemap index of a sitemap per section, e.g. sitemap-docs.xml, with the
+// hreflang alternates of translated pages.
+func writeSitemaps(staticDir string) error {
+	urls, files, err := readHugoSitemap(staticDir)
+	if err != nil {
+		return err
+	}
+	addAlternates(urls)
+
+	sections := make(map[string][]sitemapURL)
+	var origin string
+	for _, u := range urls {
+		if origin == "" {
+			if p, err := url.Parse(u.Loc); err == nil {
+				origin = p.Scheme + "://" + p.Host
+			}
+		}
+		s := sitemapSection(u.base)
+		sections[s] = append(sections[s], u)
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	for _, s := range append(append([]string{}, searchSections...), sitemapPagesSection) {
+		if len(sections[s]) == 0 {
+			continue
+		}
+		name := "sitemap-" + s + ".xml"
+		if err := writeXML(filepath.Join(staticDir, name), urlset{Xmlns: sitemapNamespace, Xhtml: xhtmlNamespace, URLs: sections[s]}); err != nil {
+			return err
+		}
+		index.Sitemaps = append(index.Sitemaps, sitemapRef{origin + "/" + name, latestLastmod(sections[s])})
+		log.Printf("Wrote %d URLs to %s", len(sections[s]), name)
+	}
+	return writeXML(filepath.Join(staticDir, sitemapIndexFile), index)
+}
+
+// runSitemaps runs the sitemaps subcommand with the given arguments, for
+// builds that don't use the build subcommand.
+func runSitemaps(args []string) error {
+	fs := flag.NewFlagSet("sitemaps", flag.ExitOnError)
+	static := fs.String("static", "public/static", "Static dir Hugo wrote the sitemap to.")
+	fs.Parse(args)
+	return writeSitemaps(*static)
+}
+
+// sitemapPingEndpoints returns the endpoints of --sitemap-ping-urls.
+func sitemapPingEndpoints() []string {
+	var endpoints []string
+	for _, e := range strings.Split(*sitemapPingURLs, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints
+}
+
+// pingSearchEngines asks the search engines with the given ping endpoints,
+// e.g. https://example.com/ping?sitemap=, to recrawl the sitemap. The URL of
+// the sitemap is appended to each endpoint.
+func pingSearchEngines(ctx context.Context, endpoints []string, sitemap string) error {
+	var errs []string
+	for _, e := range endpoints {
+		req, err := http.NewRequest("GET", e+url.QueryEscape(sitemap), nil)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		resp, err := upstreamClient("sitemap-ping").Do(req.WithContext(ctx))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errs = append(errs, fmt.Sprintf("%s: %s", req.URL.Host, resp.Status))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("sitemap ping: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSitemapLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		path, lang, base string
+	}{
+		{"/docs/", "en", "/docs/"},
+		{"/zh/docs/", "zh", "/docs/"},
+		{"/de/", "de", "/"},
+		{"/design/", "en", "/design/"},
+	} {
+		if lang, base := sitemapLanguage(tc.path); lang != tc.lang || base != tc.base {
+			t.Errorf("sitemapLanguage(%q) = %q, %q, want %q, %q", tc.path, lang, base, tc.lang, tc.base)
+		}
+	}
+}
+
+func TestWriteSitemaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A multilingual Hugo site writes a sitemap per language.
+	for name, content := range map[string]string{
+		"sitemap.xml": `<?xml version="1.0" encoding="utf-8" standalone="yes" ?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://gvisor.dev/en/sitemap.xml</loc></sitemap>
+  <sitemap><loc>https://gvisor.dev/zh/sitemap.xml</loc></sitemap>
+</sitemapindex>`,
+		"en/sitemap.xml": `<?xml version="1.0" encoding="utf-8" standalone="yes" ?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:xhtml="http://www.w3.org/1999/xhtml">
+  <url><loc>https://gvisor.dev/</loc><lastmod>2019-10-01T00:00:00+00:00</lastmod></url>
+  <url><loc>https://gvisor.dev/docs/</loc><lastmod>2019-09-01T00:00:00+00:00</lastmod></url>
+  <url><loc>https://gvisor.dev/docs/user_guide/compatibility/linux/amd64/</loc></url>
+  <url><loc>https://gvisor.dev/blog/2019/11/18/security-basics/</loc><lastmod>2019-11-18T00:00:00+00:00</lastmod>
+    <xhtml:link rel="alternate" hreflang="ja" href="https://gvisor.dev/ja/blog/security/"/>
+  </url>
+</urlset>`,
+		"zh/sitemap.xml": `<?xml version="1.0" encoding="utf-8" standalone="yes" ?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:xhtml="http://www.w3.org/1999/xhtml">
+  <url><loc>https://gvisor.dev/zh/docs/</loc><lastmod>2019-09-15T00:00:00+00:00</lastmod></url>
+</urlset>`,
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeSitemaps(dir); err != nil {
+		t.Fatalf("writeSitemaps failed: %v", err)
+	}
+
+	for _, name := range []string{"en/sitemap.xml", "zh/sitemap.xml"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Hugo's %s wasn't removed: %v", name, err)
+		}
+	}
+	var index sitemapIndex
+	readXML(t, filepath.Join(dir, "sitemap.xml"), &index)
+	if want := []sitemapRef{
+		{"https://gvisor.dev/sitemap-docs.xml", "2019-09-15T00:00:00+00:00"},
+		{"https://gvisor.dev/sitemap-blog.xml", "2019-11-18T00:00:00+00:00"},
+		{"https://gvisor.dev/sitemap-compatibility.xml", ""},
+		{"https://gvisor.dev/sitemap-pages.xml", "2019-10-01T00:00:00+00:00"},
+	}; !reflect.DeepEqual(index.Sitemaps, want) {
+		t.Errorf("got sitemap index %+v, want %+v", index.Sitemaps, want)
+	}
+
+	var docs hugoSitemap
+	readXML(t, filepath.Join(dir, "sitemap-docs.xml"), &docs)
+	if len(docs.URLs) != 2 {
+		t.Fatalf("got %d docs URLs, want 2", len(docs.URLs))
+	}
+	alternates := []sitemapLink{
+		{"alternate", "en", "https://gvisor.dev/docs/"},
+		{"alternate", "zh", "https://gvisor.dev/zh/docs/"},
+		{"alternate", "x-default", "https://gvisor.dev/docs/"},
+	}
+	for _, u := range docs.URLs {
+		if !reflect.DeepEqual(u.Links, alternates) {
+			t.Errorf("got alternates %+v of %s, want %+v", u.Links, u.Loc, alternates)
+		}
+	}
+
+	// Alternates found by Hugo are kept.
+	var blog hugoSitemap
+	readXML(t, filepath.Join(dir, "sitemap-blog.xml"), &blog)
+	if len(blog.URLs) != 1 || len(blog.URLs[0].Links) != 3 || blog.URLs[0].Links[1].Href != "https://gvisor.dev/ja/blog/security/" {
+		t.Errorf("got blog sitemap %+v, want the ja alternate", blog.URLs)
+	}
+
+	var compat hugoSitemap
+	readXML(t, filepath.Join(dir, "sitemap-compatibility.xml"), &compat)
+	if len(compat.URLs) != 1 || len(compat.URLs[0].Links) != 0 {
+		t.Errorf("got compatibility sitemap %+v, want one URL without alternates", compat.URLs)
+	}
+}
+
+// readXML decodes the XML file into v.
+func readXML(t *testing.T, file string, v interface{}) {
+	t.Helper()
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(b, v); err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+}
+
+func TestPingSearchEngines(t *testing.T) {
+	var pinged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pinged = append(pinged, r.URL.Query().Get("sitemap"))
+		if r.URL.Path == "/gone" {
+			http.Error(w, "gone", http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	sitemap := "https://gvisor.dev/sitemap.xml"
+	if err := pingSearchEngines(context.Background(), []string{srv.URL + "/ping?sitemap="}, sitemap); err != nil {
+		t.Errorf("pingSearchEngines failed: %v", err)
+	}
+	err := pingSearchEngines(context.Background(), []string{srv.URL + "/gone?sitemap=", srv.URL + "/ping?sitemap="}, sitemap)
+	if err == nil || !strings.Contains(err.Error(), "410") {
+		t.Errorf("pingSearchEngines = %v, want a 410 error", err)
+	}
+	if want := []string{sitemap, sitemap, sitemap}; !reflect.DeepEqual(pinged, want) {
+		t.Errorf("got pings of %q, want %q", pinged, want)
+	}
+}
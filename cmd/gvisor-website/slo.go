@@ -0,0 +1,262 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sloBucket is the length of the buckets SLI windows are made of.
+const sloBucket = time.Minute
+
+var sloBudgetRemaining = newGauge("slo_error_budget_remaining", "Fraction of the error budget left in the SLO window, by route and SLI.", "route", "sli")
+
+// sloObjective is the objective of a route: the fraction of requests that
+// must succeed, and the same fraction that must be faster than the latency
+// threshold.
+type sloObjective struct {
+	target  float64
+	latency time.Duration
+}
+
+// parseSLOs parses route=target:latency pairs, with the target in percent,
+// e.g. "git-refs=99.5:2s,rebuild=99:60s".
+func parseSLOs(spec string) (map[string]sloObjective, error) {
+	objectives := make(map[string]sloObjective)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid SLO %q: want route=target:latency", part)
+		}
+		tl := strings.SplitN(kv[1], ":", 2)
+		if len(tl) != 2 {
+			return nil, fmt.Errorf("invalid SLO %q: want route=target:latency", part)
+		}
+		target, err := strconv.ParseFloat(tl[0], 64)
+		if err != nil || target <= 0 || target >= 100 {
+			return nil, fmt.Errorf("invalid SLO %q: target must be a percentage between 0 and 100", part)
+		}
+		latency, err := time.ParseDuration(tl[1])
+		if err != nil || latency <= 0 {
+			return nil, fmt.Errorf("invalid SLO %q: want a positive latency", part)
+		}
+		objectives[kv[0]] = sloObjective{target: target / 100, latency: latency}
+	}
+	return objectives, nil
+}
+
+// sloCounts are the requests of a route in a bucket.
+type sloCounts struct {
+	start  time.Time
+	total  int64
+	errors int64
+	slow   int64
+}
+
+// sloTracker tracks the SLIs of routes with objectives over a sliding window,
+// and logs an event whenever an SLI crosses its objective so that Cloud
+// Monitoring can alert on it with a log-based metric.
+type sloTracker struct {
+	objectives map[string]sloObjective
+	window     time.Duration
+
+	mu       sync.Mutex
+	buckets  map[string][]sloCounts // route -> ring of buckets
+	breached map[string]bool        // route/sli -> whether below objective
+}
+
+// slos is nil unless objectives are set with --slos.
+var slos *sloTracker
+
+// newSLOTracker returns a tracker of the given objectives over the given
+// window.
+func newSLOTracker(objectives map[string]sloObjective, window time.Duration) *sloTracker {
+	n := int(window / sloBucket)
+	if n < 1 {
+		n = 1
+	}
+	t := &sloTracker{
+		objectives: objectives,
+		window:     time.Duration(n) * sloBucket,
+		buckets:    make(map[string][]sloCounts),
+		breached:   make(map[string]bool),
+	}
+	for route := range objectives {
+		t.buckets[route] = make([]sloCounts, n)
+	}
+	return t
+}
+
+// record records a request to the route. Server errors count against
+// availability, and requests slower than the threshold against latency.
+func (t *sloTracker) record(route string, code int, latency time.Duration, now time.Time) {
+	if t == nil {
+		return
+	}
+	o, ok := t.objectives[route]
+	if !ok {
+		return
+	}
+	start := now.Truncate(sloBucket)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ring := t.buckets[route]
+	b := &ring[int(start.Unix()/int64(sloBucket/time.Second))%len(ring)]
+	if !b.start.Equal(start) {
+		*b = sloCounts{start: start}
+	}
+	b.total++
+	if code >= 500 {
+		b.errors++
+	}
+	if latency > o.latency {
+		b.slow++
+	}
+}
+
+// sliStatus is the state of an SLI over the window.
+type sliStatus struct {
+	Objective float64 `json:"objective"`
+
+	// Value is the fraction of good requests, 1 if there were none.
+	Value float64 `json:"value"`
+
+	// BudgetRemaining is the fraction of the error budget left; it is
+	// negative once the budget is overspent.
+	BudgetRemaining float64 `json:"error_budget_remaining"`
+
+	Breached bool `json:"breached"`
+}
+
+// sloStatus is the state of the SLIs of a route over the window.
+type sloStatus struct {
+	Route        string    `json:"route"`
+	Window       string    `json:"window"`
+	Requests     int64     `json:"requests"`
+	Availability sliStatus `json:"availability"`
+	Latency      sliStatus `json:"latency"`
+
+	// LatencyThreshold is the latency good requests are faster than.
+	LatencyThreshold string `json:"latency_threshold"`
+}
+
+// newSLIStatus returns the state of an SLI with the given bad requests.
+func newSLIStatus(objective float64, total, bad int64) sliStatus {
+	s := sliStatus{Objective: objective, Value: 1, BudgetRemaining: 1}
+	if total > 0 {
+		s.Value = 1 - float64(bad)/float64(total)
+		s.BudgetRemaining = 1 - (1-s.Value)/(1-objective)
+	}
+	s.Breached = s.Value < objective
+	return s
+}
+
+// summary returns the state of every route's SLIs at the given time, sorted by
+// route.
+func (t *sloTracker) summary(now time.Time) []sloStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make([]sloStatus, 0, len(t.objectives))
+	for route, o := range t.objectives {
+		var sum sloCounts
+		for _, b := range t.buckets[route] {
+			if now.Sub(b.start) < t.window {
+				sum.total += b.total
+				sum.errors += b.errors
+				sum.slow += b.slow
+			}
+		}
+		res = append(res, sloStatus{
+			Route:            route,
+			Window:           t.window.String(),
+			Requests:         sum.total,
+			Availability:     newSLIStatus(o.target, sum.total, sum.errors),
+			Latency:          newSLIStatus(o.target, sum.total, sum.slow),
+			LatencyThreshold: o.latency.String(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Route < res[j].Route })
+	return res
+}
+
+// check updates the error budget metric and logs SLIs that crossed their
+// objective since the last check.
+func (t *sloTracker) check(now time.Time) {
+	for _, s := range t.summary(now) {
+		for _, sli := range []struct {
+			name   string
+			status sliStatus
+		}{
+			{"availability", s.Availability},
+			{"latency", s.Latency},
+		} {
+			sloBudgetRemaining.set(sli.status.BudgetRemaining, s.Route, sli.name)
+			key := s.Route + "/" + sli.name
+			t.mu.Lock()
+			crossed := t.breached[key] != sli.status.Breached
+			t.breached[key] = sli.status.Breached
+			t.mu.Unlock()
+			if !crossed {
+				continue
+			}
+			event := "SLO_RECOVERED"
+			if sli.status.Breached {
+				event = "SLO_BREACHED"
+			}
+			log.Printf("%s route=%s sli=%s value=%.4f objective=%.4f budget_remaining=%.2f requests=%d window=%s", event, s.Route, sli.name, sli.status.Value, sli.status.Objective, sli.status.BudgetRemaining, s.Requests, s.Window)
+		}
+	}
+}
+
+// sloCheckLoop checks the SLOs every interval until the context is done.
+func sloCheckLoop(ctx context.Context, t *sloTracker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.check(now)
+		}
+	}
+}
+
+// sloHandler serves the state of the SLOs as JSON.
+func sloHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := slos
+		if t == nil {
+			httpError(w, r, "no SLOs are configured", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(t.summary(time.Now()))
+	})
+}
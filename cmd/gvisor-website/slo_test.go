@@ -0,0 +1,105 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSLOs(t *testing.T) {
+	objectives, err := parseSLOs("git-refs=99.5:2s, rebuild=99:1m")
+	if err != nil {
+		t.Fatalf("parseSLOs failed: %v", err)
+	}
+	want := map[string]sloObjective{
+		"git-refs": {target: 0.995, latency: 2 * time.Second},
+		"rebuild":  {target: 0.99, latency: time.Minute},
+	}
+	if !reflect.DeepEqual(objectives, want) {
+		t.Errorf("parseSLOs = %v, want %v", objectives, want)
+	}
+	for _, spec := range []string{"git-refs", "git-refs=99.5", "git-refs=100:1s", "git-refs=x:1s", "git-refs=99:0s", "git-refs=99:x"} {
+		if _, err := parseSLOs(spec); err == nil {
+			t.Errorf("parseSLOs(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestSLOTracker(t *testing.T) {
+	tr := newSLOTracker(map[string]sloObjective{"git-refs": {target: 0.9, latency: time.Second}}, 10*time.Minute)
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		tr.record("git-refs", 200, 10*time.Millisecond, now)
+	}
+	tr.record("docs", 500, time.Minute, now)
+
+	s := tr.summary(now)
+	if len(s) != 1 || s[0].Requests != 10 || s[0].Availability.Value != 1 || s[0].Latency.BudgetRemaining != 1 {
+		t.Fatalf("summary = %+v, want 10 good requests", s)
+	}
+
+	now = now.Add(5 * time.Minute)
+	tr.record("git-refs", 503, 10*time.Millisecond, now)
+	tr.record("git-refs", 200, 2*time.Second, now)
+	tr.record("git-refs", 200, 2*time.Second, now)
+	s = tr.summary(now)
+	if a := s[0].Availability; a.Breached || a.Value != 1-1.0/13 {
+		t.Errorf("availability = %+v, want not breached", a)
+	}
+	if l := s[0].Latency; !l.Breached || l.BudgetRemaining >= 0 {
+		t.Errorf("latency = %+v, want breached", l)
+	}
+
+	// The first requests leave the window.
+	s = tr.summary(now.Add(6 * time.Minute))
+	if s[0].Requests != 3 {
+		t.Errorf("requests = %d, want 3", s[0].Requests)
+	}
+	// All requests leave the window.
+	s = tr.summary(now.Add(time.Hour))
+	if s[0].Requests != 0 || s[0].Latency.Breached {
+		t.Errorf("summary = %+v, want no requests", s[0])
+	}
+}
+
+func TestSLOTrackerCheck(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	tr := newSLOTracker(map[string]sloObjective{"rebuild": {target: 0.99, latency: time.Minute}}, time.Hour)
+	now := time.Now()
+	tr.check(now)
+	if buf.Len() != 0 {
+		t.Errorf("check logged %q, want nothing", buf.String())
+	}
+	tr.record("rebuild", 500, time.Second, now)
+	tr.check(now)
+	tr.check(now)
+	if got := strings.Count(buf.String(), "SLO_BREACHED route=rebuild sli=availability"); got != 1 {
+		t.Errorf("check logged %q, want one availability breach", buf.String())
+	}
+	buf.Reset()
+	tr.check(now.Add(2 * time.Hour))
+	if !strings.Contains(buf.String(), "SLO_RECOVERED route=rebuild sli=availability") {
+		t.Errorf("check logged %q, want availability recovery", buf.String())
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// sriTagRE matches script and link start tags.
+	sriTagRE = regexp.MustCompile(`(?i)<(?:script|link)\b[^>]*>`)
+
+	// sriAttrRE matches an attribute of a tag, capturing its name and
+	// value.
+	sriAttrRE = regexp.MustCompile(`(?i)\s([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+	// sriIntegrityRE matches the value of an integrity attribute.
+	sriIntegrityRE = regexp.MustCompile(`^sha(?:256|384|512)-[A-Za-z0-9+/]+={0,2}$`)
+)
+
+// loadSRIHashes reads the integrity of third-party scripts and styles from
+// the given JSON file, an object mapping absolute URLs to integrity values,
+// e.g. {"https://cdn.example.com/lib.js": "sha384-..."}. Third-party
+// resources change outside our control, so their hashes are pinned rather than
+// computed. A missing file is not an error; there are simply no hashes.
+func loadSRIHashes(file string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return hashes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &hashes); err != nil {
+		return nil, fmt.Errorf("invalid SRI config %s: %v", file, err)
+	}
+	for u, integrity := range hashes {
+		if !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("SRI hash for %q: only https URLs can be pinned", u)
+		}
+		if !sriIntegrityRE.MatchString(integrity) {
+			return nil, fmt.Errorf("SRI hash for %q: invalid integrity %q", u, integrity)
+		}
+	}
+	return hashes, nil
+}
+
+// sriHasher computes the integrity of assets in the static dir, as served,
+// i.e. minified if --minify is set. Hashes are cached until the asset's
+// content hash in the static manifest changes.
+type sriHasher struct {
+	staticDir string
+
+	mu    sync.Mutex
+	cache map[string][2]string // path -> {content hash, integrity}
+}
+
+// integrity returns the integrity of the given asset path, or "" if it isn't
+// a CSS or JS file in the static dir.
+func (s *sriHasher) integrity(p string) string {
+	if sub := fingerprintedRE.FindStringSubmatch(p); sub != nil {
+		p = sub[1] + sub[3]
+	}
+	if !fingerprintable(p) {
+		return ""
+	}
+	m := getStaticManifest(s.staticDir)
+	if m == nil {
+		return ""
+	}
+	hash, ok := m.Files[p]
+	if !ok {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.cache[p]; ok && e[0] == hash {
+		return e[1]
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.staticDir, filepath.FromSlash(path.Clean(p))))
+	if err != nil {
+		return ""
+	}
+	if minify, ok := minifiers[path.Ext(p)]; ok && *minifyStatic {
+		b = minify(b)
+	}
+	sum := sha512.Sum384(b)
+	integrity := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	s.cache[p] = [2]string{hash, integrity}
+	return integrity
+}
+
+// sriFilter returns the HTML filter adding integrity and crossorigin
+// attributes to scripts and stylesheets in the static dir and to the
+// configured third-party ones. Tags that already have an integrity
+// attribute are left alone.
+func sriFilter(staticDir string, thirdParty map[string]string) htmlFilter {
+	hasher := &sriHasher{staticDir: staticDir, cache: make(map[string][2]string)}
+	origin := "https://" + *customHost
+	return func(b []byte) []byte {
+		return sriTagRE.ReplaceAllFunc(b, func(tag []byte) []byte {
+			attrs := make(map[string]string)
+			for _, a := range sriAttrRE.FindAllSubmatch(tag, -1) {
+				attrs[strings.ToLower(string(a[1]))] = string(a[2]) + string(a[3]) + string(a[4])
+			}
+			if _, ok := attrs["integrity"]; ok {
+				return tag
+			}
+			ref := attrs["src"]
+			if strings.EqualFold(string(tag[1:5]), "link") {
+				if !strings.EqualFold(attrs["rel"], "stylesheet") {
+					return tag
+				}
+				ref = attrs["href"]
+			}
+			var integrity string
+			switch {
+			case ref == "":
+				return tag
+			case thirdParty[ref] != "":
+				integrity = thirdParty[ref]
+			case strings.HasPrefix(ref, "/") && !strings.HasPrefix(ref, "//"):
+				integrity = hasher.integrity(ref)
+			case strings.HasPrefix(ref, origin+"/"):
+				integrity = hasher.integrity(strings.TrimPrefix(ref, origin))
+			}
+			if integrity == "" {
+				return tag
+			}
+			end := len(tag) - 1
+			if tag[end-1] == '/' {
+				end--
+			}
+			attr := ` integrity="` + integrity + `"`
+			if _, ok := attrs["crossorigin"]; !ok {
+				attr += ` crossorigin="anonymous"`
+			}
+			return []byte(string(tag[:end]) + attr + string(tag[end:]))
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSRIFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sri-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "js"), 0755)
+	if err := ioutil.WriteFile(filepath.Join(dir, "js", "main.js"), []byte("  a();\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.css"), []byte("a {}"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	integrity := func(s string) string {
+		sum := sha512.Sum384([]byte(s))
+		return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+	js, css := integrity("  a();\n"), integrity("a {}")
+	fp := fingerprint(getStaticManifest(dir), "/js/main.js")
+	thirdParty := map[string]string{"https://cdn.example.com/lib.js": "sha384-pinned"}
+
+	filter := sriFilter(dir, thirdParty)
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{`<script src="/js/main.js"></script>`, `<script src="/js/main.js" integrity="` + js + `" crossorigin="anonymous"></script>`},
+		{`<script src="` + fp + `" defer></script>`, `<script src="` + fp + `" defer integrity="` + js + `" crossorigin="anonymous"></script>`},
+		{`<script src="https://gvisor.dev/js/main.js"></script>`, `<script src="https://gvisor.dev/js/main.js" integrity="` + js + `" crossorigin="anonymous"></script>`},
+		{`<LINK rel=stylesheet href='/main.css'/>`, `<LINK rel=stylesheet href='/main.css' integrity="` + css + `" crossorigin="anonymous"/>`},
+		{`<script src="https://cdn.example.com/lib.js" crossorigin="use-credentials"></script>`, `<script src="https://cdn.example.com/lib.js" crossorigin="use-credentials" integrity="sha384-pinned"></script>`},
+		// Left alone.
+		{`<link rel="preload" href="/main.css">`, `<link rel="preload" href="/main.css">`},
+		{`<script src="/js/main.js" integrity="sha384-x"></script>`, `<script src="/js/main.js" integrity="sha384-x"></script>`},
+		{`<script src="https://cdn.example.com/other.js"></script>`, `<script src="https://cdn.example.com/other.js"></script>`},
+		{`<script src="//gvisor.dev/js/main.js"></script>`, `<script src="//gvisor.dev/js/main.js"></script>`},
+		{`<script src="/js/missing.js"></script>`, `<script src="/js/missing.js"></script>`},
+		{`<script>a();</script>`, `<script>a();</script>`},
+	} {
+		if got := string(filter([]byte(tc.in))); got != tc.want {
+			t.Errorf("sriFilter(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestLoadSRIHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sri-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if hashes, err := loadSRIHashes(filepath.Join(dir, "missing.json")); err != nil || len(hashes) != 0 {
+		t.Errorf("loadSRIHashes(missing) = %v, %v, want no hashes", hashes, err)
+	}
+	for _, tc := range []struct {
+		config string
+		ok     bool
+	}{
+		{`{"https://cdn.example.com/lib.js": "sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC"}`, true},
+		{`{"http://cdn.example.com/lib.js": "sha384-abc"}`, false},
+		{`{"https://cdn.example.com/lib.js": "md5-abc"}`, false},
+		{`[]`, false},
+	} {
+		file := filepath.Join(dir, "sri.json")
+		if err := ioutil.WriteFile(file, []byte(tc.config), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+		if _, err := loadSRIHashes(file); (err == nil) != tc.ok {
+			t.Errorf("loadSRIHashes(%s) error = %v, want ok %v", tc.config, err, tc.ok)
+		}
+	}
+}
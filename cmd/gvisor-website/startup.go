@@ -0,0 +1,74 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// processStart approximates when the process started: package variables are
+// initialized before main runs.
+var processStart = time.Now()
+
+var startupPhaseDuration = newGauge("startup_phase_duration_seconds", "Duration of the startup phases of this instance; the total phase is the time to first listen.", "phase")
+
+// startupPhase is a timed phase of startup.
+type startupPhase struct {
+	name     string
+	duration time.Duration
+}
+
+// startupTimer times the phases of startup, so that additions to the startup
+// path that slow down cold starts are noticed.
+type startupTimer struct {
+	start  time.Time
+	last   time.Time
+	phases []startupPhase
+}
+
+// newStartupTimer returns a timer for a startup that began at the given time.
+func newStartupTimer(start time.Time) *startupTimer {
+	return &startupTimer{start: start, last: start}
+}
+
+// phase ends the named phase, which began when the previous one ended.
+func (t *startupTimer) phase(name string, now time.Time) {
+	t.phases = append(t.phases, startupPhase{name: name, duration: now.Sub(t.last)})
+	t.last = now
+}
+
+// total returns the time from the start to the end of the last phase.
+func (t *startupTimer) total() time.Duration {
+	return t.last.Sub(t.start)
+}
+
+// report logs the phase durations and exports them as metrics, warning if
+// startup took longer than the budget. A zero budget is unlimited.
+func (t *startupTimer) report(budget time.Duration) {
+	parts := make([]string, 0, len(t.phases))
+	for _, p := range t.phases {
+		startupPhaseDuration.set(p.duration.Seconds(), p.name)
+		parts = append(parts, fmt.Sprintf("%s=%v", p.name, p.duration.Round(time.Millisecond)))
+	}
+	total := t.total()
+	startupPhaseDuration.set(total.Seconds(), "total")
+	log.Printf("Started in %v: %s", total.Round(time.Millisecond), strings.Join(parts, " "))
+	if budget > 0 && total > budget {
+		log.Printf("WARNING: startup took %v, over the budget of %v", total.Round(time.Millisecond), budget)
+	}
+}
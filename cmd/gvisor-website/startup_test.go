@@ -0,0 +1,51 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStartupTimer(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	start := time.Now()
+	st := newStartupTimer(start)
+	st.phase("flags", start.Add(100*time.Millisecond))
+	st.phase("search-index", start.Add(2*time.Second))
+	if got, want := st.total(), 2*time.Second; got != want {
+		t.Errorf("total = %v, want %v", got, want)
+	}
+	if got, want := st.phases[1].duration, 1900*time.Millisecond; got != want {
+		t.Errorf("search-index duration = %v, want %v", got, want)
+	}
+
+	st.report(time.Minute)
+	if got := buf.String(); !strings.Contains(got, "Started in 2s: flags=100ms search-index=1.9s") || strings.Contains(got, "WARNING") {
+		t.Errorf("report logged %q, want phases without warning", got)
+	}
+	buf.Reset()
+	st.report(time.Second)
+	if got := buf.String(); !strings.Contains(got, "WARNING: startup took 2s, over the budget of 1s") {
+		t.Errorf("report logged %q, want budget warning", got)
+	}
+}
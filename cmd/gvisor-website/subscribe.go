@@ -0,0 +1,431 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// subscribeConfirmPath is where the confirmation links mailed to new
+	// subscribers point.
+	subscribeConfirmPath = "/api/subscribe/confirm"
+
+	// subscribeTokenTTL is how long confirmation links are valid for.
+	subscribeTokenTTL = 48 * time.Hour
+
+	// subscribeMailInterval is the minimum time between confirmation mails
+	// to the same address, so that the endpoint can't be used to flood a
+	// mailbox.
+	subscribeMailInterval = time.Hour
+
+	// maxEmailLength is the maximum length of an email address, per RFC
+	// 5321.
+	maxEmailLength = 254
+
+	// groupMemberScope is the OAuth scope for adding members to a Google
+	// Group.
+	groupMemberScope = "https://www.googleapis.com/auth/admin.directory.group.member"
+)
+
+// mailingList is the list that confirmed subscribers are added to.
+type mailingList interface {
+	// add subscribes the given address. Adding an existing subscriber is
+	// not an error.
+	add(ctx context.Context, email string) error
+}
+
+// newMailingList returns the list of the given provider, which is either
+// "mailchimp" or "groups", at the given API URL.
+func newMailingList(ctx context.Context, provider, listURL string) (mailingList, error) {
+	if listURL == "" {
+		return nil, errors.New("a list URL is required")
+	}
+	switch provider {
+	case "mailchimp":
+		if *mailchimpAPIKey == "" {
+			return nil, errors.New("mailchimp requires an API key")
+		}
+		return &mailchimpList{url: strings.TrimSuffix(listURL, "/"), key: *mailchimpAPIKey}, nil
+	case "groups":
+		client, err := googleClient(ctx, "groups", groupMemberScope)
+		if err != nil {
+			return nil, err
+		}
+		return &groupsList{url: strings.TrimSuffix(listURL, "/"), client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailing list provider %q", provider)
+	}
+}
+
+// mailchimpList is a Mailchimp audience, at its API URL, e.g.
+// https://us4.api.mailchimp.com/3.0/lists/<list-id>.
+type mailchimpList struct {
+	url string
+	key string
+}
+
+func (l *mailchimpList) add(ctx context.Context, email string) error {
+	b, err := json.Marshal(map[string]string{
+		"email_address": email,
+		// The address is confirmed already, so Mailchimp's own double
+		// opt-in is skipped.
+		"status_if_new": "subscribed",
+	})
+	if err != nil {
+		return err
+	}
+	// Members are addressed by the MD5 hash of the lowercased address,
+	// which makes the update idempotent.
+	h := md5.Sum([]byte(strings.ToLower(email)))
+	req, err := http.NewRequest("PUT", l.url+"/members/"+hex.EncodeToString(h[:]), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("gvisor-website", l.key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := upstreamClient("mailchimp").Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mailchimp: %s", resp.Status)
+	}
+	return nil
+}
+
+// groupsList is a Google Group, at its Admin SDK Directory API URL, e.g.
+// https://admin.googleapis.com/admin/directory/v1/groups/<group-email>.
+type groupsList struct {
+	url    string
+	client *http.Client
+}
+
+func (l *groupsList) add(ctx context.Context, email string) error {
+	b, err := json.Marshal(map[string]string{"email": email, "role": "MEMBER"})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", l.url+"/members", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Conflict means the address is a member already.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("groups: %s", resp.Status)
+	}
+	return nil
+}
+
+// mailer sends the confirmation mails.
+type mailer interface {
+	send(to, subject, body string) error
+}
+
+// smtpMailer sends mail through an SMTP relay.
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// newSMTPMailer returns a mailer sending mail from the given address through
+// the relay at the given smtp://[user:password@]host:port URL.
+func newSMTPMailer(relay, from string) (*smtpMailer, error) {
+	u, err := url.Parse(relay)
+	if err != nil || u.Scheme != "smtp" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid SMTP URL %q: want smtp://[user:password@]host:port", relay)
+	}
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %v", from, err)
+	}
+	m := &smtpMailer{addr: u.Host, from: from}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		m.auth = smtp.PlainAuth("", u.User.Username(), password, u.Hostname())
+	}
+	return m, nil
+}
+
+func (m *smtpMailer) send(to, subject, body string) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+	msg := "From: " + m.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.Replace(body, "\n", "\r\n", -1)
+	return smtp.SendMail(m.addr, m.auth, from.Address, []string{to}, []byte(msg))
+}
+
+// validateEmail returns the given address with its domain lowercased, or an
+// error if it isn't a plain, deliverable-looking address.
+func validateEmail(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", errors.New("missing email")
+	}
+	if len(s) > maxEmailLength {
+		return "", errors.New("email too long")
+	}
+	addr, err := mail.ParseAddress(s)
+	// Only bare addresses are accepted, not names or comments.
+	if err != nil || addr.Name != "" || addr.Address != s {
+		return "", errors.New("invalid email")
+	}
+	at := strings.LastIndex(s, "@")
+	domain := strings.ToLower(s[at+1:])
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || net.ParseIP(strings.Trim(domain, "[]")) != nil {
+		return "", errors.New("invalid email domain")
+	}
+	return s[:at+1] + domain, nil
+}
+
+// subscriptions handles announce-list signups: addresses are confirmed with
+// a signed link mailed to them before they are added to the list, so that
+// nobody can be subscribed without their consent.
+type subscriptions struct {
+	list   mailingList
+	mail   mailer
+	secret []byte
+}
+
+// token returns the confirmation token of the given address, which expires
+// at the given time.
+func (s *subscriptions) token(email string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(email)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the address confirmed by the given token, or an error if
+// the token is invalid or has expired.
+func (s *subscriptions) verify(token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("invalid token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("invalid token")
+	}
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("invalid token")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.After(time.Unix(expires, 0)) {
+		return "", errors.New("expired token")
+	}
+	email, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.New("invalid token")
+	}
+	return string(email), nil
+}
+
+// subscribeRequest is the body accepted by /api/subscribe, either as JSON or
+// as a form.
+type subscribeRequest struct {
+	Email string `json:"email"`
+
+	// Website is a honeypot field hidden from humans, as in the feedback
+	// form.
+	Website string `json:"website"`
+}
+
+// subscribeMailKey returns the cache key recording a confirmation mail to the
+// given address. The address is hashed, so that it isn't kept in the cache.
+func subscribeMailKey(email string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(email)))
+	return "subscribe:" + hex.EncodeToString(h[:])
+}
+
+const subscribeMailBody = `Hello,
+
+Someone, hopefully you, asked to subscribe this address to gVisor
+announcements. To confirm, open this link within two days:
+
+%s
+
+If you didn't ask to subscribe, ignore this mail and you won't be
+subscribed.
+`
+
+// subscribeHandler returns a handler mailing a confirmation link to the
+// address in the request. It responds with 202 Accepted once the mail is
+// sent.
+func subscribeHandler(s *subscriptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+		var sr subscribeRequest
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(&sr); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			if err := r.ParseForm(); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			sr.Email = r.PostForm.Get("email")
+			sr.Website = r.PostForm.Get("website")
+		}
+		if sr.Website != "" {
+			// Pretend to succeed so that bots don't adapt.
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		email, err := validateEmail(sr.Email)
+		if err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		key := subscribeMailKey(email)
+		if _, ok, err := sharedCache.get(ctx, key); err == nil && ok {
+			// A confirmation was mailed recently. Respond as if it
+			// was mailed now, so that the endpoint doesn't reveal
+			// which addresses were submitted.
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		link := siteURL(subscribeConfirmPath + "?" + url.Values{"token": {s.token(email, time.Now().Add(subscribeTokenTTL))}}.Encode())
+		if err := s.mail.send(email, "Confirm your gVisor announcements subscription", fmt.Sprintf(subscribeMailBody, link)); err != nil {
+			log.Printf("Error mailing subscription confirmation: %v", err)
+			httpError(w, r, "subscriptions unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		sharedCache.set(ctx, key, []byte{1}, subscribeMailInterval)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+var subscribedTemplate = template.Must(template.New("subscribed").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{if .Subscribed}}Subscribed{{else}}Confirm subscription{{end}} - gVisor</title>
+<style>
+body { font-family: "Roboto", sans-serif; margin: 0; color: #222; line-height: 1.5; }
+header { background: #262362; color: #fff; padding: 1em 2em; font-size: 1.5em; }
+header a { color: #fff; text-decoration: none; }
+main { margin: 2em; max-width: 40em; }
+a { color: #286FD7; }
+button { font: inherit; padding: 0.5em 1em; }
+</style>
+</head>
+<body>
+<header><a href="/">gVisor</a></header>
+<main>
+{{if .Subscribed}}<h1>You're subscribed</h1>
+<p>{{.Email}} will receive gVisor announcements.</p>
+<p><a href="/">Back to gVisor</a></p>
+{{else}}<h1>Confirm your subscription</h1>
+<p>Subscribe {{.Email}} to gVisor announcements?</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Confirm subscription</button>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+// subscribedPage is the data of subscribedTemplate.
+type subscribedPage struct {
+	Email      string
+	Token      string
+	Action     string
+	Subscribed bool
+}
+
+// subscribeConfirmHandler returns a handler adding the address confirmed by
+// the token to the mailing list. The mailed link, with the token parameter,
+// only renders a form posting the token back, since mail scanners and link
+// prefetchers follow links: the address is only added on POST.
+func subscribeConfirmHandler(s *subscriptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		switch r.Method {
+		case "GET", "HEAD":
+			token = r.URL.Query().Get("token")
+		case "POST":
+			token = r.PostFormValue("token")
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		email, err := s.verify(token, time.Now())
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := subscribedPage{Email: email, Token: token, Action: subscribeConfirmPath}
+		if r.Method == "POST" {
+			if err := s.list.add(r.Context(), email); err != nil {
+				log.Printf("Error adding subscriber: %v", err)
+				httpError(w, r, "subscriptions unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			page.Subscribed = true
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := subscribedTemplate.Execute(w, page); err != nil {
+			log.Printf("Error rendering subscription confirmation: %v", err)
+		}
+	})
+}
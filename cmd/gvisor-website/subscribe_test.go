@@ -0,0 +1,186 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateEmail(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"user@Example.COM", "user@example.com"},
+		{" first.last+tag@gvisor.dev ", "first.last+tag@gvisor.dev"},
+		{"", ""},
+		{"user", ""},
+		{"user@localhost", ""},
+		{"user@example.com.", ""},
+		{"user@[127.0.0.1]", ""},
+		{"User <user@example.com>", ""},
+		{"user@example.com\r\nBcc: victim@example.com", ""},
+		{strings.Repeat("a", 250) + "@example.com", ""},
+	} {
+		got, err := validateEmail(tc.in)
+		if tc.want == "" && err == nil {
+			t.Errorf("validateEmail(%q) = %q, want an error", tc.in, got)
+		}
+		if tc.want != "" && (err != nil || got != tc.want) {
+			t.Errorf("validateEmail(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestSubscriptionToken(t *testing.T) {
+	s := &subscriptions{secret: []byte("secret")}
+	now := time.Now()
+	token := s.token("user@example.com", now.Add(time.Hour))
+	if got, err := s.verify(token, now); err != nil || got != "user@example.com" {
+		t.Errorf("verify = %q, %v, want user@example.com", got, err)
+	}
+	if _, err := s.verify(token, now.Add(2*time.Hour)); err == nil {
+		t.Errorf("verify of an expired token succeeded")
+	}
+	other := (&subscriptions{secret: []byte("other")}).token("user@example.com", now.Add(time.Hour))
+	parts := strings.Split(token, ".")
+	for _, bad := range []string{"", "a.b", other, parts[0] + "." + parts[1] + "1." + parts[2], s.token("user@example.com", now.Add(time.Hour))[:len(token)-2]} {
+		if _, err := s.verify(bad, now); err == nil {
+			t.Errorf("verify(%q) succeeded", bad)
+		}
+	}
+}
+
+type testMailer struct {
+	to, body []string
+}
+
+func (m *testMailer) send(to, subject, body string) error {
+	m.to = append(m.to, to)
+	m.body = append(m.body, body)
+	return nil
+}
+
+type testList struct {
+	emails []string
+}
+
+func (l *testList) add(ctx context.Context, email string) error {
+	l.emails = append(l.emails, email)
+	return nil
+}
+
+func TestSubscribe(t *testing.T) {
+	defer func(c cache) { sharedCache = c }(sharedCache)
+	sharedCache = newMemoryCache(100, 1<<20)
+	m, l := &testMailer{}, &testList{}
+	s := &subscriptions{list: l, mail: m, secret: []byte("secret")}
+	h, confirm := subscribeHandler(s), subscribeConfirmHandler(s)
+
+	post := func(contentType, body string) int {
+		r := httptest.NewRequest("POST", "/api/subscribe", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if got := post("application/json", `{"email": "not an address"}`); got != http.StatusBadRequest {
+		t.Errorf("invalid address: got status %d, want %d", got, http.StatusBadRequest)
+	}
+	if got := post("application/x-www-form-urlencoded", "email=bot%40example.com&website=spam"); got != http.StatusAccepted || len(m.to) != 0 {
+		t.Errorf("honeypot: got status %d and %d mails, want %d and none", got, len(m.to), http.StatusAccepted)
+	}
+	for i := 0; i < 2; i++ {
+		if got := post("application/x-www-form-urlencoded", "email=user%40Example.com"); got != http.StatusAccepted {
+			t.Errorf("got status %d, want %d", got, http.StatusAccepted)
+		}
+	}
+	// The second signup doesn't mail the address again.
+	if len(m.to) != 1 || m.to[0] != "user@example.com" {
+		t.Fatalf("got mails to %q, want one to user@example.com", m.to)
+	}
+	if len(l.emails) != 0 {
+		t.Errorf("got subscribers %q before confirmation, want none", l.emails)
+	}
+
+	link := regexp.MustCompile(`https://\S+`).FindString(m.body[0])
+	u, err := url.Parse(link)
+	if err != nil || u.Path != subscribeConfirmPath {
+		t.Fatalf("got confirmation link %q", link)
+	}
+	// Following the link, as mail scanners do, doesn't subscribe.
+	rec := httptest.NewRecorder()
+	confirm.ServeHTTP(rec, httptest.NewRequest("GET", u.RequestURI(), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `<form method="post"`) {
+		t.Errorf("confirmation page: got status %d and body %q", rec.Code, rec.Body.String())
+	}
+	if len(l.emails) != 0 {
+		t.Errorf("got subscribers %q after following the link, want none", l.emails)
+	}
+
+	r := httptest.NewRequest("POST", subscribeConfirmPath, strings.NewReader(url.Values{"token": {u.Query().Get("token")}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	confirm.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "user@example.com will receive") {
+		t.Errorf("confirmation: got status %d and body %q", rec.Code, rec.Body.String())
+	}
+	if len(l.emails) != 1 || l.emails[0] != "user@example.com" {
+		t.Errorf("got subscribers %q, want user@example.com", l.emails)
+	}
+
+	r = httptest.NewRequest("POST", subscribeConfirmPath, strings.NewReader("token=forged"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	confirm.ServeHTTP(rec, r)
+	if rec.Code != http.StatusBadRequest || len(l.emails) != 1 {
+		t.Errorf("forged token: got status %d and subscribers %q, want %d", rec.Code, l.emails, http.StatusBadRequest)
+	}
+}
+
+func TestMailchimpList(t *testing.T) {
+	var got struct {
+		path, user, key string
+		body            map[string]string
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.path = r.Method + " " + r.URL.Path
+		got.user, got.key, _ = r.BasicAuth()
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &got.body)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	l := &mailchimpList{url: srv.URL + "/3.0/lists/abc123", key: "key-us4"}
+	if err := l.add(context.Background(), "User@example.com"); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	// The member is the MD5 hash of user@example.com.
+	if want := "PUT /3.0/lists/abc123/members/b58996c504c5638798eb6b511e6f49af"; got.path != want {
+		t.Errorf("got request %q, want %q", got.path, want)
+	}
+	if got.key != "key-us4" || got.body["email_address"] != "User@example.com" || got.body["status_if_new"] != "subscribed" {
+		t.Errorf("got key %q and body %v", got.key, got.body)
+	}
+}
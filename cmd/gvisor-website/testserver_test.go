@@ -0,0 +1,416 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	// testProject is the project of the fake Cloud Build API.
+	testProject = "test-project"
+
+	// testTrigger is the build trigger of the fake Cloud Build API.
+	testTrigger = "test-trigger"
+)
+
+// testAdvertisement is the ref advertisement of the fake upstream repository.
+var testAdvertisement = pkt("# service=git-upload-pack\n") + "0000" +
+	pkt("1111111111111111111111111111111111111111 HEAD\x00multi_ack symref=HEAD:refs/heads/master agent=git/test\n") +
+	pkt("1111111111111111111111111111111111111111 refs/heads/master\n") +
+	pkt("2222222222222222222222222222222222222222 refs/heads/go\n") +
+	pkt("3333333333333333333333333333333333333333 refs/tags/release-20190806.1\n") +
+	pkt("4444444444444444444444444444444444444444 refs/tags/release-20190806.1^{}\n") +
+	"0000"
+
+// testStaticFiles are the files of the test static dir.
+var testStaticFiles = map[string]string{
+	"index.html":      `<!doctype html><html><head><title>gVisor</title></head><body><h1>gVisor</h1></body></html>`,
+	"docs/index.html": `<!doctype html><html><head><title>Docs</title></head><body><h1>Documentation</h1></body></html>`,
+	"docs/user_guide/compatibility/linux/amd64/index.html": `<!doctype html><html><body><table>` +
+		`<tr><td><a class="doc-table-anchor" id="read"></a>read</td></tr>` +
+		`<tr><td><a class="doc-table-anchor" id="write"></a>write</td></tr>` +
+		`</table></body></html>`,
+	"docs/user_guide/compatibility/linux/arm64/index.html": `<!doctype html><html><body><table>` +
+		`<tr><td><a class="doc-table-anchor" id="openat"></a>openat</td></tr>` +
+		`</table></body></html>`,
+}
+
+// testServer is the full site, wired to a fake upstream git repository, a
+// fake Cloud Build API and a temporary static dir.
+type testServer struct {
+	*httptest.Server
+
+	// StaticDir is the temporary static dir.
+	StaticDir string
+
+	git        *httptest.Server
+	cloudBuild *httptest.Server
+
+	// restore restores the global state replaced by the test server.
+	restore func()
+
+	mu          sync.Mutex
+	gitRequests int
+	builds      []cloudbuild.RepoSource
+	previews    []*cloudbuild.Build
+
+	// history is the build history listed by the fake Cloud Build API,
+	// newest first, which fails to list builds if historyErr is set.
+	history         []*cloudbuild.Build
+	historyErr      bool
+	historyRequests int
+}
+
+// newTestServer starts a test server. The global state it replaces is
+// restored by close, so tests using it must not run in parallel.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{}
+
+	dir, err := ioutil.TempDir("", "gvisor-website-test")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	s.StaticDir = dir
+	for name, content := range testStaticFiles {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	s.git = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/google/gvisor.git/info/refs" || r.URL.Query().Get("service") != "git-upload-pack" {
+			http.NotFound(w, r)
+			return
+		}
+		s.mu.Lock()
+		s.gitRequests++
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		io.WriteString(w, testAdvertisement)
+	}))
+
+	s.cloudBuild = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v1/projects/"+testProject+"/triggers":
+			json.NewEncoder(w).Encode(cloudbuild.ListBuildTriggersResponse{
+				Triggers: []*cloudbuild.BuildTrigger{{Id: testTrigger}},
+			})
+		case r.Method == "POST" && r.URL.Path == "/v1/projects/"+testProject+"/triggers/"+testTrigger+":run":
+			var src cloudbuild.RepoSource
+			if err := json.NewDecoder(r.Body).Decode(&src); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.mu.Lock()
+			s.builds = append(s.builds, src)
+			s.mu.Unlock()
+			json.NewEncoder(w).Encode(cloudbuild.Operation{Name: "operations/build/test"})
+		case r.Method == "GET" && r.URL.Path == "/v1/projects/"+testProject+"/builds":
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.historyRequests++
+			if s.historyErr {
+				http.Error(w, `{"error":{"code":500,"message":"internal error in projects/`+testProject+`"}}`, http.StatusInternalServerError)
+				return
+			}
+			builds := s.history
+			if n, err := strconv.Atoi(r.URL.Query().Get("pageSize")); err == nil && n < len(builds) {
+				builds = builds[:n]
+			}
+			json.NewEncoder(w).Encode(cloudbuild.ListBuildsResponse{Builds: builds})
+		case r.Method == "POST" && r.URL.Path == "/v1/projects/"+testProject+"/builds":
+			var b cloudbuild.Build
+			if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.mu.Lock()
+			s.previews = append(s.previews, &b)
+			s.mu.Unlock()
+			json.NewEncoder(w).Encode(cloudbuild.Operation{Name: "operations/build/preview"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	service, err := cloudbuild.NewService(context.Background(),
+		option.WithEndpoint(s.cloudBuild.URL+"/"),
+		option.WithHTTPClient(s.cloudBuild.Client()))
+	if err != nil {
+		t.Fatalf("cloudbuild.NewService failed: %v", err)
+	}
+
+	prevCache, prevGitUpstream := sharedCache, *gitUpstream
+	buildServiceMu.Lock()
+	prevService, prevProject := buildService, buildProject
+	buildService, buildProject = service, testProject
+	buildServiceMu.Unlock()
+	sharedCache = newMemoryCache(100, 1<<20)
+	*gitUpstream = s.git.URL + "/google/gvisor.git"
+	s.restore = func() {
+		sharedCache, *gitUpstream = prevCache, prevGitUpstream
+		buildServiceMu.Lock()
+		buildService, buildProject = prevService, prevProject
+		buildServiceMu.Unlock()
+	}
+
+	ctx := context.Background()
+	dynamic, err := newDynamicRedirects(ctx, "memory")
+	if err != nil {
+		t.Fatalf("newDynamicRedirects failed: %v", err)
+	}
+	banner, err := newAnnouncements(ctx, "memory")
+	if err != nil {
+		t.Fatalf("newAnnouncements failed: %v", err)
+	}
+	benchmarks, err := newBenchmarkStore(ctx, "memory")
+	if err != nil {
+		t.Fatalf("newBenchmarkStore failed: %v", err)
+	}
+	feedback, err := newFeedbackStore(ctx, "memory")
+	if err != nil {
+		t.Fatalf("newFeedbackStore failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	registerSite(mux, dir, &site{
+		dynamic:    dynamic,
+		banner:     banner,
+		benchmarks: benchmarks,
+		feedback:   feedback,
+		views:      newPageViews(),
+	})
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// close stops the servers, removes the static dir and restores the global
+// state.
+func (s *testServer) close() {
+	s.Server.Close()
+	s.git.Close()
+	s.cloudBuild.Close()
+	os.RemoveAll(s.StaticDir)
+	s.restore()
+}
+
+// do sends a request to the test server without following redirects.
+func (s *testServer) do(t *testing.T, method, path string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s %s failed: %v", method, path, err)
+	}
+	return resp, string(b)
+}
+
+func TestEndToEndGoGet(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	for _, path := range []string{"/?go-get=1", "/runsc?go-get=1", "/pkg/sentry/kernel?go-get=1"} {
+		resp, body := s.do(t, "GET", path, nil)
+		if resp.StatusCode != http.StatusOK || !strings.Contains(body, goGetHeader) {
+			t.Errorf("GET %s: got status %d, body %q; want the go-import meta tag", path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestEndToEndRedirects(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	for _, tc := range []struct {
+		path     string
+		wantCode int
+		wantLoc  string
+	}{
+		{"/issue", http.StatusFound, "https://github.com/google/gvisor/issues"},
+		{"/issue/123", http.StatusFound, "https://github.com/google/gvisor/issues/123"},
+		{"/pr/45?w=1", http.StatusFound, "https://github.com/google/gvisor/pull/45?w=1"},
+		{"/faq", http.StatusFound, "/docs/user_guide/faq/"},
+		{"/chat", http.StatusFound, "https://gitter.im/gvisor/community"},
+		{"/c/linux/amd64/read", http.StatusFound, "/docs/user_guide/compatibility/linux/amd64/#read"},
+		{"/c/linux/amd64/raed", http.StatusNotFound, ""},
+		{"/c/linux/amd64", http.StatusFound, "/docs/user_guide/compatibility/linux/amd64/"},
+		{"/c/linux/arm64", http.StatusFound, "/docs/user_guide/compatibility/linux/arm64/"},
+		{"/c/linux/arm64/openat", http.StatusFound, "/docs/user_guide/compatibility/linux/arm64/#openat"},
+		{"/c/linux/arm64/read", http.StatusNotFound, ""},
+		{"/issue/../etc", http.StatusMovedPermanently, ""},
+	} {
+		resp, _ := s.do(t, "GET", tc.path, nil)
+		if resp.StatusCode != tc.wantCode {
+			t.Errorf("GET %s: got status %d, want %d", tc.path, resp.StatusCode, tc.wantCode)
+			continue
+		}
+		if tc.wantLoc != "" {
+			if got := resp.Header.Get("Location"); got != tc.wantLoc {
+				t.Errorf("GET %s: got Location %q, want %q", tc.path, got, tc.wantLoc)
+			}
+		}
+	}
+}
+
+func TestEndToEndGitRefs(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	for _, tc := range []struct {
+		path string
+		want []refInfo
+	}{
+		{"/api/git/branches", []refInfo{
+			{Name: "master", Commit: "1111111111111111111111111111111111111111", Default: true},
+			{Name: "go", Commit: "2222222222222222222222222222222222222222"},
+		}},
+		{"/api/git/tags", []refInfo{
+			{Name: "release-20190806.1", Commit: "4444444444444444444444444444444444444444"},
+		}},
+	} {
+		resp, body := s.do(t, "GET", tc.path, nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: got status %d, body %q", tc.path, resp.StatusCode, body)
+			continue
+		}
+		var got []refInfo
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Errorf("GET %s: invalid JSON %q: %v", tc.path, body, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("GET %s: got %+v, want %+v", tc.path, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("GET %s: got %+v, want %+v", tc.path, got, tc.want)
+				break
+			}
+		}
+	}
+
+	// The advertisement is cached, so upstream is only asked once.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gitRequests != 1 {
+		t.Errorf("got %d upstream info/refs requests, want 1", s.gitRequests)
+	}
+}
+
+func TestEndToEndRebuild(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	// Only App Engine cron may trigger rebuilds.
+	if resp, _ := s.do(t, "GET", "/rebuild", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /rebuild without cron header: got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	resp, body := s.do(t, "GET", "/rebuild", http.Header{"X-Appengine-Cron": {"true"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /rebuild: got status %d, body %q", resp.StatusCode, body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.builds) != 1 {
+		t.Fatalf("got %d builds, want 1", len(s.builds))
+	}
+	if b := s.builds[0]; b.BranchName != "master" || b.RepoName != "github_google_gvisor-website" || b.ProjectId != testProject {
+		t.Errorf("got build from %+v, want master of github_google_gvisor-website in %s", b, testProject)
+	}
+}
+
+func TestEndToEndDisabledSubsystems(t *testing.T) {
+	defer func(git, status, admin bool, p profile, token string) {
+		*enableGitProxy, *enableStatus, *enableAdmin, deployProfile, *adminToken = git, status, admin, p, token
+	}(*enableGitProxy, *enableStatus, *enableAdmin, deployProfile, *adminToken)
+	*enableGitProxy, *enableStatus, *enableAdmin = false, false, false
+	deployProfile.rebuild = false
+	*adminToken = "secret"
+
+	s := newTestServer(t)
+	defer s.close()
+
+	header := http.Header{"X-Appengine-Cron": {"true"}, "Authorization": {"Bearer secret"}}
+	for _, path := range []string{"/rebuild", "/gvisor/raw/README.md", "/api/git/tags", "/api/v1/git/tags", "/status", "/api/v1/status", "/admin/stats"} {
+		if resp, body := s.do(t, "GET", path, header); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: got status %d, want %d: %s", path, resp.StatusCode, http.StatusNotFound, body)
+		}
+	}
+	resp, body := s.do(t, "GET", "/api/v1/", nil)
+	if resp.StatusCode != http.StatusOK || strings.Contains(body, "git/") || strings.Contains(body, "status") || !strings.Contains(body, "search") {
+		t.Errorf("GET /api/v1/: got status %d, body %q, want only enabled endpoints", resp.StatusCode, body)
+	}
+	if resp, _ := s.do(t, "GET", "/", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if resp, _ := s.do(t, "GET", "/issue/1", nil); resp.StatusCode != http.StatusFound {
+		t.Errorf("GET /issue/1: got status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+}
+
+func TestEndToEndStatic(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+
+	for _, tc := range []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/", http.StatusOK, "<h1>gVisor</h1>"},
+		{"/docs/", http.StatusOK, "<h1>Documentation</h1>"},
+		{"/docs/missing/", http.StatusNotFound, ""},
+	} {
+		resp, body := s.do(t, "GET", tc.path, nil)
+		if resp.StatusCode != tc.wantCode || !strings.Contains(body, tc.wantBody) {
+			t.Errorf("GET %s: got status %d, body %q; want status %d, body containing %q", tc.path, resp.StatusCode, body, tc.wantCode, tc.wantBody)
+		}
+	}
+}
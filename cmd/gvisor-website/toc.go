@@ -0,0 +1,114 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// heading is a single heading of a rendered page.
+type heading struct {
+	Anchor string `json:"anchor"`
+	Level  int    `json:"level"`
+	Title  string `json:"title"`
+}
+
+var (
+	// headingRE matches headings with an id, as generated by Hugo. Headings
+	// are never nested, so the first closing heading tag ends the match.
+	headingRE = regexp.MustCompile(`(?is)<h([1-6])\b[^>]*?\bid="([^"]+)"[^>]*>(.*?)</h[1-6]>`)
+
+	// tagRE matches HTML tags within a heading.
+	tagRE = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+// extractHeadings returns the headings with anchors in the given rendered
+// HTML, in document order.
+func extractHeadings(b []byte) []heading {
+	var hs []heading
+	for _, m := range headingRE.FindAllSubmatch(b, -1) {
+		title := html.UnescapeString(tagRE.ReplaceAllString(string(m[3]), ""))
+		hs = append(hs, heading{
+			Anchor: html.UnescapeString(string(m[2])),
+			Level:  int(m[1][0] - '0'),
+			Title:  strings.Join(strings.Fields(title), " "),
+		})
+	}
+	return hs
+}
+
+// readPage returns the rendered page at the given URL path in the static dir.
+func readPage(staticDir, page string) ([]byte, error) {
+	name := path.Clean("/" + page)
+	if !strings.HasSuffix(name, ".html") {
+		name = path.Join(name, "index.html")
+	}
+	f, err := http.Dir(staticDir).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// pageHeadings returns the headings of the rendered page at the given URL
+// path in the static dir.
+func pageHeadings(staticDir, page string) ([]heading, error) {
+	b, err := readPage(staticDir, page)
+	if err != nil {
+		return nil, err
+	}
+	return extractHeadings(b), nil
+}
+
+// tocResponse is the table of contents API response.
+type tocResponse struct {
+	Page     string    `json:"page"`
+	Headings []heading `json:"headings"`
+}
+
+// tocHandler serves the headings of the page given by the page parameter,
+// e.g. /api/toc?page=/docs/user_guide/faq/.
+func tocHandler(staticDir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if !strings.HasPrefix(page, "/") {
+			httpError(w, r, "invalid request: page must be an absolute path", http.StatusBadRequest)
+			return
+		}
+		hs, err := pageHeadings(staticDir, page)
+		if os.IsNotExist(err) {
+			httpError(w, r, "page not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			httpError(w, r, "error reading page: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if hs == nil {
+			hs = []heading{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(tocResponse{Page: page, Headings: hs})
+	})
+}
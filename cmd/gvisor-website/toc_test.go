@@ -0,0 +1,58 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractHeadings(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want []heading
+	}{
+		{
+			name: "none",
+			in:   "<p>No headings.</p><h2>No anchor</h2>",
+			want: nil,
+		},
+		{
+			name: "levels",
+			in:   `<h1 id="title">Title</h1><p>x</p><h2 id="install">Install</h2><h3 class="x" id="apt">apt</h3>`,
+			want: []heading{{"title", 1, "Title"}, {"install", 2, "Install"}, {"apt", 3, "apt"}},
+		},
+		{
+			name: "markup and entities",
+			in:   "<h2 id=\"q&amp;a\">Q&amp;A <code>runsc</code>\n  flags</h2>",
+			want: []heading{{"q&a", 2, "Q&A runsc flags"}},
+		},
+		{
+			name: "uppercase tags",
+			in:   `<H4 ID="faq">FAQ</H4>`,
+			want: []heading{{"faq", 4, "FAQ"}},
+		},
+		{
+			name: "adjacent",
+			in:   `<h2 id="a">A</h2><h2 id="b">B</h2>`,
+			want: []heading{{"a", 2, "A"}, {"b", 2, "B"}},
+		},
+	} {
+		if got := extractHeadings([]byte(tc.in)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: extractHeadings = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// trackingParams are the click identifiers added to links by ad and email
+// platforms. Parameters prefixed utm_ are also tracking parameters.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"gbraid":  true,
+	"wbraid":  true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_gl":     true,
+}
+
+// isTrackingParam returns true if the named query parameter is a campaign
+// or click tracking parameter.
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || trackingParams[name]
+}
+
+// stripTrackingParams removes tracking parameters from the raw query if
+// --strip-tracking-params is set, keeping the order and encoding of the
+// other parameters.
+func stripTrackingParams(rawQuery string) string {
+	if !*stripTracking || rawQuery == "" {
+		return rawQuery
+	}
+	var kept []string
+	for _, part := range strings.Split(rawQuery, "&") {
+		name := part
+		if i := strings.Index(part, "="); i >= 0 {
+			name = part[:i]
+		}
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if !isTrackingParam(name) {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "&")
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStripTrackingParams(t *testing.T) {
+	defer func(v bool) { *stripTracking = v }(*stripTracking)
+	for _, tc := range []struct {
+		strip bool
+		query string
+		want  string
+	}{
+		{true, "", ""},
+		{true, "utm_source=twitter&utm_medium=social", ""},
+		{true, "w=1&UTM_Campaign=launch&fbclid=abc&q=a%20b", "w=1&q=a%20b"},
+		{true, "utm%5Fsource=x&gclid&tab=files", "tab=files"},
+		{true, "utmost=1", "utmost=1"},
+		{false, "w=1&utm_source=twitter", "w=1&utm_source=twitter"},
+	} {
+		*stripTracking = tc.strip
+		if got := stripTrackingParams(tc.query); got != tc.want {
+			t.Errorf("stripTrackingParams(%q) with stripping %t = %q, want %q", tc.query, tc.strip, got, tc.want)
+		}
+	}
+}
+
+func TestRedirectStripsTrackingParams(t *testing.T) {
+	defer func(v bool) { *stripTracking = v }(*stripTracking)
+	*stripTracking = true
+	for _, tc := range []struct {
+		url     string
+		h       http.Handler
+		wantLoc string
+	}{
+		{"/issue/1?utm_source=blog&w=1", prefixRedirectHandler("/issue/", "https://github.com/google/gvisor/issues/%s"), "https://github.com/google/gvisor/issues/1?w=1"},
+		{"/faq?fbclid=abc", redirectHandler("/docs/user_guide/faq/"), "/docs/user_guide/faq/"},
+		{"https://www.gvisor.dev/docs/?utm_medium=email&lang=en", hostRedirectHandler(nil), "https://gvisor.dev/docs/?lang=en"},
+	} {
+		rec := httptest.NewRecorder()
+		tc.h.ServeHTTP(rec, httptest.NewRequest("GET", tc.url, nil))
+		if got := rec.Header().Get("Location"); got != tc.wantLoc {
+			t.Errorf("GET %s: got Location %q, want %q", tc.url, got, tc.wantLoc)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// newUpstreamTransport returns the transport shared by all upstream clients.
+// Connections are kept alive and pooled per host, so that requests to GitHub
+// and Google APIs reuse established TLS connections rather than handshaking
+// for each request. The TLS config is left to the default so that HTTP/2 is
+// negotiated.
+func newUpstreamTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: countConns((&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext),
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ResponseHeaderTimeout: *upstreamTimeout,
+	}
+}
+
+var (
+	upstreamOnce      sync.Once
+	upstreamTransport http.RoundTripper
+)
+
+// upstreamClient returns the client for requests to the named upstream, e.g.
+// git or github. Clients share the upstream transport, restricted to the
+// hosts allowed by --egress-allow and with faults injected if --chaos is set.
+// Responses may be streamed, so clients have no overall timeout; requests
+// are bounded by their context and the transport's timeouts.
+func upstreamClient(upstream string) *http.Client {
+	return upstreamClientAllowing(upstream, nil)
+}
+
+// upstreamClientAllowing is like upstreamClient, but also allows requests to
+// the hosts for which allow returns true.
+func upstreamClientAllowing(upstream string, allow func(host string) bool) *http.Client {
+	upstreamOnce.Do(func() {
+		upstreamTransport = newUpstreamTransport()
+	})
+	return &http.Client{Transport: chaos.transport(upstream, egress.transport(upstream, allow, upstreamTransport))}
+}
+
+// googleClient returns a client for the named Google API upstream,
+// authenticated with the application default credentials. Both API and token
+// requests use the upstream client.
+func googleClient(ctx context.Context, upstream string, scopes ...string) (*http.Client, error) {
+	return google.DefaultClient(context.WithValue(ctx, oauth2.HTTPClient, upstreamClient(upstream)), scopes...)
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUpstreamClient(t *testing.T) {
+	defer func(c *chaosConfig, e *egressPolicy) { chaos, egress = c, e }(chaos, egress)
+
+	chaos, egress = nil, nil
+	git, github := upstreamClient("git"), upstreamClient("github")
+	if git.Transport != github.Transport {
+		t.Errorf("upstream clients don't share a transport")
+	}
+	tr, ok := git.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("got transport %T, want *http.Transport", git.Transport)
+	}
+	if tr.Proxy == nil || tr.MaxIdleConnsPerHost < 2 || tr.ResponseHeaderTimeout != *upstreamTimeout {
+		t.Errorf("transport isn't tuned: %+v", tr)
+	}
+
+	chaos = &chaosConfig{errorRate: 1}
+	ct, ok := upstreamClient("git").Transport.(*chaosTransport)
+	if !ok || ct.rt != tr || ct.upstream != "git" {
+		t.Errorf("got transport %#v with chaos, want the upstream transport with faults", upstreamClient("git").Transport)
+	}
+}
+
+func TestUpstreamConnectionReuse(t *testing.T) {
+	defer func(c *chaosConfig, e *egressPolicy) { chaos, egress = c, e }(chaos, egress)
+	chaos, egress = nil, nil
+
+	conns := 0
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns++
+		}
+	}
+	s.Start()
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, err := upstreamClient("test").Get(s.URL)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if conns != 1 {
+		t.Errorf("got %d connections for 3 sequential requests, want 1", conns)
+	}
+}
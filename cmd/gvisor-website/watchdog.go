@@ -0,0 +1,147 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	goroutinesGauge    = newGauge("goroutines", "Number of goroutines, sampled by the watchdog.")
+	upstreamConnsGauge = newGauge("upstream_open_connections", "Open connections to upstreams, sampled by the watchdog.")
+	cacheEntriesGauge  = newGauge("cache_entries", "Entries in in-memory caches, by cache, sampled by the watchdog.", "cache")
+	cacheBytesGauge    = newGauge("cache_bytes", "Size of the values in in-memory caches, by cache, sampled by the watchdog.", "cache")
+)
+
+// upstreamConns is the number of open upstream connections.
+var upstreamConns int64
+
+// countedConn decrements upstreamConns when it is first closed.
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(&upstreamConns, -1) })
+	return c.Conn.Close()
+}
+
+// countConns returns dial with its connections counted in upstreamConns while
+// they are open.
+func countConns(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&upstreamConns, 1)
+		return &countedConn{Conn: c}, nil
+	}
+}
+
+// watchdogSample is a sample of the resources that leak when requests or
+// streams are never finished.
+type watchdogSample struct {
+	goroutines    int
+	upstreamConns int
+}
+
+// watchdog samples goroutines, upstream connections and cache sizes, and logs
+// when they are anomalous: above their limit, or more than doubled since the
+// first sample, which is taken once the instance has warmed up.
+type watchdog struct {
+	maxGoroutines    int
+	maxUpstreamConns int
+
+	// caches are the in-memory caches whose sizes are exported, by name.
+	caches map[string]*memoryCache
+
+	baseline  *watchdogSample
+	anomalous map[string]bool
+}
+
+// leakSlack is how much a sampled value may grow beyond double its baseline
+// before it is anomalous, so that small baselines don't cause noise.
+const leakSlack = 100
+
+// newWatchdog returns a watchdog with the given limits, where 0 is unlimited.
+func newWatchdog(maxGoroutines, maxUpstreamConns int, caches map[string]*memoryCache) *watchdog {
+	return &watchdog{
+		maxGoroutines:    maxGoroutines,
+		maxUpstreamConns: maxUpstreamConns,
+		caches:           caches,
+		anomalous:        make(map[string]bool),
+	}
+}
+
+// check exports the sample and logs values that became or stopped being
+// anomalous.
+func (w *watchdog) check(s watchdogSample) {
+	goroutinesGauge.set(float64(s.goroutines))
+	upstreamConnsGauge.set(float64(s.upstreamConns))
+	for name, c := range w.caches {
+		entries, size := c.stats()
+		cacheEntriesGauge.set(float64(entries), name)
+		cacheBytesGauge.set(float64(size), name)
+	}
+	if w.baseline == nil {
+		w.baseline = &s
+	}
+	w.report("goroutines", s.goroutines, w.baseline.goroutines, w.maxGoroutines)
+	w.report("upstream connections", s.upstreamConns, w.baseline.upstreamConns, w.maxUpstreamConns)
+}
+
+// report logs if the named value became or stopped being anomalous.
+func (w *watchdog) report(name string, v, baseline, max int) {
+	over := max > 0 && v > max
+	grown := v > 2*baseline+leakSlack
+	anomalous := over || grown
+	if anomalous == w.anomalous[name] {
+		return
+	}
+	w.anomalous[name] = anomalous
+	switch {
+	case over:
+		log.Printf("WATCHDOG: %d %s, over the limit of %d", v, name, max)
+	case grown:
+		log.Printf("WATCHDOG: %d %s, up from %d; possible leak", v, name, baseline)
+	default:
+		log.Printf("WATCHDOG: %d %s, back to normal", v, name)
+	}
+}
+
+// watchdogLoop samples every interval until the context is done.
+func watchdogLoop(ctx context.Context, w *watchdog, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(watchdogSample{
+				goroutines:    runtime.NumGoroutine(),
+				upstreamConns: int(atomic.LoadInt64(&upstreamConns)),
+			})
+		}
+	}
+}
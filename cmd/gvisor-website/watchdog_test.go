@@ -0,0 +1,81 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCountConns(t *testing.T) {
+	before := atomic.LoadInt64(&upstreamConns)
+	dial := countConns(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, _ := net.Pipe()
+		return c, nil
+	})
+	c, err := dial(context.Background(), "tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	if got := atomic.LoadInt64(&upstreamConns) - before; got != 1 {
+		t.Errorf("open connections = %d, want 1", got)
+	}
+	c.Close()
+	c.Close()
+	if got := atomic.LoadInt64(&upstreamConns) - before; got != 0 {
+		t.Errorf("open connections after close = %d, want 0", got)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := newMemoryCache(10, 1000)
+	c.set(context.Background(), "k", []byte("value"), time.Minute)
+	w := newWatchdog(1000, 0, map[string]*memoryCache{"shared": c})
+	for _, tc := range []struct {
+		sample watchdogSample
+		want   string
+	}{
+		{watchdogSample{goroutines: 50, upstreamConns: 2}, ""},
+		{watchdogSample{goroutines: 150, upstreamConns: 100}, ""},
+		{watchdogSample{goroutines: 250, upstreamConns: 2}, "WATCHDOG: 250 goroutines, up from 50; possible leak"},
+		{watchdogSample{goroutines: 300, upstreamConns: 2}, ""},
+		{watchdogSample{goroutines: 1001, upstreamConns: 2}, ""},
+		{watchdogSample{goroutines: 60, upstreamConns: 2}, "WATCHDOG: 60 goroutines, back to normal"},
+		{watchdogSample{goroutines: 60, upstreamConns: 105}, "WATCHDOG: 105 upstream connections, up from 2; possible leak"},
+	} {
+		buf.Reset()
+		w.check(tc.sample)
+		if got := strings.TrimSpace(buf.String()); !strings.HasSuffix(got, tc.want) || (tc.want == "") != (got == "") {
+			t.Errorf("check(%+v) logged %q, want %q", tc.sample, got, tc.want)
+		}
+	}
+	w = newWatchdog(1000, 0, nil)
+	buf.Reset()
+	w.check(watchdogSample{goroutines: 1200})
+	if got := buf.String(); !strings.Contains(got, "WATCHDOG: 1200 goroutines, over the limit of 1000") {
+		t.Errorf("check logged %q, want limit exceeded", got)
+	}
+}
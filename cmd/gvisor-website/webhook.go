@@ -0,0 +1,281 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudbuild/v1"
+)
+
+const (
+	// websiteRepo is the GitHub repository of the website.
+	websiteRepo = "google/gvisor-website"
+
+	// maxWebhookPayload bounds the size of a webhook delivery.
+	maxWebhookPayload = 1 << 20
+)
+
+// trustedAssociations are the author associations whose pull requests are
+// built automatically. Preview builds run with the project's build
+// credentials, so PRs from anyone else must not be built unreviewed.
+var trustedAssociations = map[string]bool{
+	"OWNER":        true,
+	"MEMBER":       true,
+	"COLLABORATOR": true,
+}
+
+// validWebhookSignature returns true if the X-Hub-Signature-256 header of a
+// delivery matches its body.
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// pullRequestEvent is the subset of a pull_request delivery that is used.
+type pullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		AuthorAssociation string `json:"author_association"`
+		Head              struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Repo struct {
+				FullName string `json:"full_name"`
+			} `json:"repo"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+// pushEvent is the subset of a push delivery that is used.
+type pushEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Commits []struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+}
+
+// touchesG3doc returns true if the push changes the docs on the branch they
+// are synced from.
+func (ev *pushEvent) touchesG3doc(branch string) bool {
+	if ev.Repository.FullName != "google/gvisor" || ev.Ref != "refs/heads/"+branch {
+		return false
+	}
+	for _, c := range ev.Commits {
+		for _, files := range [][]string{c.Added, c.Removed, c.Modified} {
+			for _, f := range files {
+				if strings.HasPrefix(f, g3docDir+"/") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// previewURL returns the URL the preview of the given PR is served at.
+func previewURL(pr int) string {
+	return siteURL("/preview/" + strconv.Itoa(pr) + "/")
+}
+
+// previewBuild returns a build of the given PR commit that publishes the
+// site to the PR's prefix in the preview bucket. As in cloudbuild.yaml, the
+// compatibility docs are generated from the gVisor go branch.
+//
+// Every build rewrites the preview's marker object, which the TTL is checked
+// against, and stamps all of the preview's objects with a Custom-Time so that
+// the bucket's lifecycle rule (see previews-lifecycle.json) deletes previews
+// that are no longer rebuilt.
+func previewBuild(pr int, sha, bucket, prefix string) *cloudbuild.Build {
+	dest := "gs://" + bucket + "/" + strconv.Itoa(pr)
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		dest = "gs://" + bucket + "/" + prefix + "/" + strconv.Itoa(pr)
+	}
+	return &cloudbuild.Build{
+		Steps: []*cloudbuild.BuildStep{
+			{Name: "gcr.io/cloud-builders/git", Args: []string{"clone", "https://github.com/" + websiteRepo + ".git", "."}},
+			{Name: "gcr.io/cloud-builders/git", Args: []string{"fetch", "origin", fmt.Sprintf("pull/%d/head", pr)}},
+			{Name: "gcr.io/cloud-builders/git", Args: []string{"checkout", sha}},
+			{Name: "gcr.io/cloud-builders/git", Args: []string{"clone", "--branch", "go", "--depth", "1", "https://github.com/google/gvisor.git", "upstream/gvisor"}},
+			{Name: "golang", Env: []string{"GO111MODULE=on"}, Args: []string{"go", "build", "-o", "bin/generate-syscall-docs", "gvisor.dev/website/cmd/generate-syscall-docs"}},
+			{Name: "golang", Args: []string{"./bin/generate-syscall-docs", "-src", "./upstream/gvisor", "-out", "./content/docs/user_guide/compatibility/", "-json", "./static/compatibility.json"}},
+			{Name: "golang", Env: []string{"GO111MODULE=on"}, Args: []string{"go", "run", "gvisor.dev/website/cmd/generate-reference-docs", "-src", "./upstream/gvisor", "-out", "./content/docs/reference/", "-flags-json", "./static/runsc-flags.json", "-oci-json", "./static/oci-compliance.json"}},
+			{Name: "gcr.io/cloud-builders/npm", Args: []string{"ci"}},
+			{Name: "gcr.io/gvisor-website/hugo:0.53", Args: []string{"hugo", "--baseURL", previewURL(pr)}},
+			{Name: "gcr.io/cloud-builders/gsutil", Args: []string{"-m", "rsync", "-d", "-r", "-x", `^\.preview-built$`, "public/static", dest}},
+			{Name: "gcr.io/cloud-builders/gsutil", Entrypoint: "bash", Args: []string{"-c", fmt.Sprintf(`t=$$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ) && echo "$$t" | gsutil cp - %[1]s/%[2]s && gsutil -m setmeta -h "Custom-Time:$$t" '%[1]s/**'`, dest, previewMarker)}},
+		},
+		Tags:    []string{"preview", fmt.Sprintf("pr-%d", pr)},
+		Timeout: "1200s",
+	}
+}
+
+// startPreviewBuild starts a preview build of the given PR commit and returns
+// the URL of its log.
+func startPreviewBuild(ctx context.Context, pr int, sha string) (string, error) {
+	cloudbuildService, projectID, err := newCloudBuild(ctx)
+	if err != nil {
+		return "", err
+	}
+	op, err := cloudbuildService.Projects.Builds.Create(projectID, previewBuild(pr, sha, *previewBucket, *previewPrefix)).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("build create error: %v", err)
+	}
+	var meta struct {
+		Build struct {
+			LogURL string `json:"logUrl"`
+		} `json:"build"`
+	}
+	json.Unmarshal(op.Metadata, &meta)
+	return meta.Build.LogURL, nil
+}
+
+// commentOnPR posts a comment on the given PR of the website repository.
+func commentOnPR(ctx context.Context, token string, pr int, body string) error {
+	b, err := json.Marshal(map[string]string{"body": body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("https://api.github.com/repos/%s/issues/%d/comments", websiteRepo, pr), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := upstreamClient("github").Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("comment on PR %d: %s", pr, resp.Status)
+	}
+	return nil
+}
+
+// handlePullRequest starts a preview build for an opened or updated PR, and
+// comments with the preview URL when a PR is opened.
+func handlePullRequest(ctx context.Context, ev *pullRequestEvent) error {
+	switch ev.Action {
+	case "opened", "reopened", "synchronize":
+	default:
+		return nil
+	}
+	if *previewBucket == "" {
+		return nil
+	}
+	if ev.PullRequest.Base.Repo.FullName != websiteRepo {
+		return fmt.Errorf("unexpected repository %q", ev.PullRequest.Base.Repo.FullName)
+	}
+	if !trustedAssociations[ev.PullRequest.AuthorAssociation] {
+		log.Printf("Not building preview of PR %d by %s author", ev.Number, ev.PullRequest.AuthorAssociation)
+		return nil
+	}
+	logURL, err := startPreviewBuild(ctx, ev.Number, ev.PullRequest.Head.SHA)
+	if err != nil {
+		return err
+	}
+	if ev.Action == "synchronize" || *githubToken == "" {
+		return nil
+	}
+	msg := fmt.Sprintf("A preview of this change will be available at %s once the build finishes.", previewURL(ev.Number))
+	if logURL != "" {
+		msg += fmt.Sprintf(" ([build log](%s))", logURL)
+	}
+	return commentOnPR(ctx, *githubToken, ev.Number, msg)
+}
+
+// githubWebhookHandler handles GitHub webhook deliveries signed with the given
+// secret.
+func githubWebhookHandler(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+		if err != nil {
+			httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !validWebhookSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			httpError(w, r, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		switch event := r.Header.Get("X-GitHub-Event"); event {
+		case "ping":
+		case "pull_request":
+			var ev pullRequestEvent
+			if err := json.Unmarshal(body, &ev); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+			defer cancel()
+			if err := handlePullRequest(ctx, &ev); err != nil {
+				log.Printf("Error handling pull_request event for PR %d: %v", ev.Number, err)
+				httpError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "push":
+			// Pushes to the gVisor repository, whose webhook is
+			// signed with the same secret, sync its docs.
+			var ev pushEvent
+			if err := json.Unmarshal(body, &ev); err != nil {
+				httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if g3docSync == nil || !ev.touchesG3doc(*g3docBranch) {
+				break
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+			defer cancel()
+			if _, err := g3docSync.sync(ctx); err != nil {
+				log.Printf("Error syncing docs for push to %s: %v", ev.Ref, err)
+				httpError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			log.Printf("Ignoring GitHub %q event", event)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+// signWebhook returns the X-Hub-Signature-256 header of the body.
+func signWebhook(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook sends a webhook delivery of the given event to the handler.
+func deliverWebhook(h http.Handler, event, body, signature string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/webhook/github", strings.NewReader(body))
+	r.Header.Set("X-GitHub-Event", event)
+	if signature != "" {
+		r.Header.Set("X-Hub-Signature-256", signature)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestValidWebhookSignature(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+	for _, tc := range []struct {
+		signature string
+		want      bool
+	}{
+		{signWebhook(testWebhookSecret, string(body)), true},
+		{strings.TrimPrefix(signWebhook(testWebhookSecret, string(body)), "sha256="), false},
+		{signWebhook("other-secret", string(body)), false},
+		{signWebhook(testWebhookSecret, string(body)+" "), false},
+		{"sha256=not-hex", false},
+		{"", false},
+	} {
+		if got := validWebhookSignature(testWebhookSecret, body, tc.signature); got != tc.want {
+			t.Errorf("validWebhookSignature(%q) = %t, want %t", tc.signature, got, tc.want)
+		}
+	}
+}
+
+func TestGithubWebhookHandler(t *testing.T) {
+	s := newTestServer(t)
+	defer s.close()
+	defer func(bucket, token string) { *previewBucket, *githubToken = bucket, token }(*previewBucket, *githubToken)
+	*previewBucket, *githubToken = "previews", ""
+	h := githubWebhookHandler(testWebhookSecret)
+
+	ping := `{"zen":"Keep it logically awesome."}`
+	for _, signature := range []string{"", "sha256=00", signWebhook("other-secret", ping)} {
+		if w := deliverWebhook(h, "ping", ping, signature); w.Code != http.StatusUnauthorized {
+			t.Errorf("ping with signature %q: got status %d, want 401", signature, w.Code)
+		}
+	}
+	if w := deliverWebhook(h, "ping", ping, signWebhook(testWebhookSecret, ping)); w.Code != http.StatusNoContent {
+		t.Errorf("ping: got status %d, want 204", w.Code)
+	}
+	r := httptest.NewRequest("GET", "/webhook/github", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: got status %d, want 405", w.Code)
+	}
+
+	pr := func(association string) string {
+		return `{"action":"opened","number":42,"pull_request":{"author_association":"` + association + `","head":{"sha":"abc123"},"base":{"repo":{"full_name":"google/gvisor-website"}}}}`
+	}
+	for _, association := range []string{"CONTRIBUTOR", "FIRST_TIME_CONTRIBUTOR", "NONE"} {
+		body := pr(association)
+		if w := deliverWebhook(h, "pull_request", body, signWebhook(testWebhookSecret, body)); w.Code != http.StatusNoContent {
+			t.Errorf("PR by %s author: got status %d, want 204", association, w.Code)
+		}
+	}
+	if len(s.previews) != 0 {
+		t.Errorf("PRs by untrusted authors started %d preview builds, want none", len(s.previews))
+	}
+	body := pr("MEMBER")
+	if w := deliverWebhook(h, "pull_request", body, signWebhook(testWebhookSecret, body)); w.Code != http.StatusNoContent {
+		t.Errorf("PR by MEMBER author: got status %d, want 204: %s", w.Code, w.Body)
+	}
+	if len(s.previews) != 1 || strings.Join(s.previews[0].Tags, ",") != "preview,pr-42" {
+		t.Errorf("PR by MEMBER author started preview builds %+v, want one of PR 42", s.previews)
+	}
+}
+
+func TestGithubWebhookPush(t *testing.T) {
+	var syncs int
+	defer func(s *g3docSyncer, branch string) { g3docSync, *g3docBranch = s, branch }(g3docSync, *g3docBranch)
+	*g3docBranch = "master"
+	g3docSync = &g3docSyncer{
+		deployed: "deployed",
+		upstream: func(ctx context.Context) (string, error) {
+			syncs++
+			return "deployed", nil
+		},
+		rebuild: func(ctx context.Context) error { return nil },
+	}
+	h := githubWebhookHandler(testWebhookSecret)
+
+	for _, tc := range []struct {
+		name string
+		body string
+		sync bool
+	}{
+		{
+			name: "docs change",
+			body: `{"ref":"refs/heads/master","repository":{"full_name":"google/gvisor"},"commits":[{"modified":["g3doc/user_guide/quick_start.md"]}]}`,
+			sync: true,
+		},
+		{
+			name: "removed docs",
+			body: `{"ref":"refs/heads/master","repository":{"full_name":"google/gvisor"},"commits":[{"modified":["runsc/main.go"]},{"removed":["g3doc/old.md"]}]}`,
+			sync: true,
+		},
+		{
+			name: "change outside the docs",
+			body: `{"ref":"refs/heads/master","repository":{"full_name":"google/gvisor"},"commits":[{"added":["pkg/g3doc.go"],"modified":["runsc/main.go"]}]}`,
+		},
+		{
+			name: "other branch",
+			body: `{"ref":"refs/heads/go","repository":{"full_name":"google/gvisor"},"commits":[{"modified":["g3doc/README.md"]}]}`,
+		},
+		{
+			name: "other repository",
+			body: `{"ref":"refs/heads/master","repository":{"full_name":"google/gvisor-website"},"commits":[{"modified":["g3doc/README.md"]}]}`,
+		},
+	} {
+		syncs = 0
+		if w := deliverWebhook(h, "push", tc.body, signWebhook(testWebhookSecret, tc.body)); w.Code != http.StatusNoContent {
+			t.Errorf("%s: got status %d, want 204", tc.name, w.Code)
+		}
+		if got := syncs == 1; got != tc.sync {
+			t.Errorf("%s: got %d syncs, want sync %t", tc.name, syncs, tc.sync)
+		}
+	}
+}